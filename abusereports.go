@@ -0,0 +1,492 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/ratelimit"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// reportPath is where visitors report a link on a served host as leading
+// somewhere malicious.
+const reportPath = "/_redirect/report"
+
+// Limits on what abuse reports keep, so that a flood of them can't use up
+// memory or disk. Past them, the least reported entries awaiting review,
+// and an entry's oldest reports, give way.
+const (
+	maxReportReason  = 500   // bytes of a visitor's reason
+	maxHostReports   = 100   // reports kept per entry
+	maxReportedHosts = 10000 // entries, one per host and destination
+)
+
+// reportSaveDelay is how long after a report abuse_reports_file is
+// rewritten, so that a burst of reports is saved once.
+const reportSaveDelay = 5 * time.Second
+
+// The states of a reported destination. It is reported, awaiting review,
+// until abuse_report_threshold visitors have reported it, if set, and then
+// put behind a warning; an operator may take it down, blocking the
+// redirects to it, or dismiss its reports.
+const (
+	reportReported  = "reported"
+	reportWarning   = "warning"
+	reportTakenDown = "taken_down"
+)
+
+var abuseReportsTotal = registry.Counter("redirect_abuse_reports_total",
+	"Abuse reports submitted, by outcome: accepted, duplicate or full.", "outcome")
+
+// abuseReports keeps visitors' reports of malicious destinations. It is nil
+// unless abuse_reports is set.
+var abuseReports *abuseReportStore
+
+// abuseReportStore keeps abuse reports by the host whose links were
+// reported and the host they lead to, and as a TargetChecker warns before
+// the redirects of that host to that destination once enough visitors
+// have reported them, and refuses those taken down. Other hosts redirecting
+// to the same destination are left alone.
+type abuseReportStore struct {
+	path        string // abuse_reports_file, or "" to keep reports in memory
+	threshold   int    // 0 leaves warnings to operators
+	limiter     *ratelimit.Limiter
+	reporters   *ipAnonymizer // hashes reporters' networks, for telling them apart
+	crossOrigin *http.CrossOriginProtection
+
+	mu          sync.Mutex
+	reports     map[reportKey]*reportedHost
+	savePending bool
+
+	saveMu sync.Mutex // held while writing path
+}
+
+// A reportKey is the host whose links were reported and the host they
+// lead to.
+type reportKey struct {
+	host, destination string
+}
+
+// A reportedHost is a destination visitors have reported the links of a
+// host leading to, and their reports.
+type reportedHost struct {
+	Host        string        `json:"host"`
+	Destination string        `json:"destination"`
+	State       string        `json:"state"`
+	Reporters   int           `json:"reporters"` // distinct networks among Reports
+	Reports     []abuseReport `json:"reports"`
+}
+
+// An abuseReport is one visitor's report of a link.
+type abuseReport struct {
+	Time     time.Time `json:"time"`
+	Link     string    `json:"link"`     // the reported host and path
+	Location string    `json:"location"` // where the link led when reported
+	Reason   string    `json:"reason,omitempty"`
+	Reporter string    `json:"reporter"` // a keyed hash of the visitor's network
+}
+
+// newAbuseReportStore returns the store cfg asks for, with the reports
+// abuse_reports_file kept, or nil without abuse_reports.
+func newAbuseReportStore(cfg *config) (*abuseReportStore, error) {
+	if !cfg.AbuseReports {
+		return nil, nil
+	}
+	s := &abuseReportStore{
+		path:      cfg.AbuseReportsFile,
+		threshold: cfg.AbuseReportThreshold,
+		// A report a minute per visitor, after the first five.
+		limiter:     ratelimit.New(1.0/60, 5),
+		reporters:   &ipAnonymizer{mode: "hash", key: []byte(cfg.IPHashKey)},
+		crossOrigin: http.NewCrossOriginProtection(),
+		reports:     make(map[reportKey]*reportedHost),
+	}
+	if len(s.reporters.key) == 0 {
+		s.reporters.key = processHashKey()
+	}
+	if s.path != "" {
+		if err := s.load(); err != nil {
+			return nil, fmt.Errorf("abuse_reports_file: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// load reads the reports saved to s.path. A missing file is not an error.
+func (s *abuseReportStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var reports []*reportedHost
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	for _, h := range reports {
+		s.reports[reportKey{h.Host, h.Destination}] = h
+	}
+	log.Printf("Loaded %d abuse report entries from %s", len(reports), s.path)
+	return nil
+}
+
+// save writes the reports to s.path, if set, logging a failure: a report
+// that isn't saved is still acted on until a restart.
+func (s *abuseReportStore) save() {
+	if s.path == "" {
+		return
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	s.savePending = false
+	s.mu.Unlock()
+	data, err := json.Marshal(s.list(""))
+	if err == nil {
+		err = replaceFile(s.path, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	if err != nil {
+		log.Printf("Saving abuse reports to %s: %v", s.path, err)
+	}
+}
+
+// saveLater saves the reports after reportSaveDelay, unless a save is
+// already due. s.mu must be held.
+func (s *abuseReportStore) saveLater() {
+	if s.path == "" || s.savePending {
+		return
+	}
+	s.savePending = true
+	time.AfterFunc(reportSaveDelay, s.save)
+}
+
+// add records report of a link on host leading to destination, and
+// returns its outcome: "duplicate" if the same network already reported
+// the link, "full" if every entry is under a warning or taken down, or
+// "accepted".
+func (s *abuseReportStore) add(host, destination string, report abuseReport) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := reportKey{host, destination}
+	h := s.reports[key]
+	if h == nil {
+		if len(s.reports) >= maxReportedHosts && !s.evict() {
+			return "full"
+		}
+		h = &reportedHost{Host: host, Destination: destination, State: reportReported}
+		s.reports[key] = h
+	}
+	if slices.ContainsFunc(h.Reports, func(r abuseReport) bool { return r.Reporter == report.Reporter && r.Link == report.Link }) {
+		return "duplicate"
+	}
+	if len(h.Reports) >= maxHostReports {
+		h.Reports = slices.Delete(h.Reports, 0, 1)
+	}
+	h.Reports = append(h.Reports, report)
+	if h.State == reportReported && s.threshold > 0 && h.reporters() >= s.threshold {
+		h.State = reportWarning
+		log.Printf("%d visitors have reported %s's links to %s; warning before them until they're reviewed", s.threshold, host, destination)
+	}
+	s.saveLater()
+	return "accepted"
+}
+
+// evict drops the least reported entry awaiting review, the one reported
+// longest ago of those, to make room for another. Warnings and takedowns
+// are kept. It reports whether there was one to drop. s.mu must be held.
+func (s *abuseReportStore) evict() bool {
+	var victim *reportedHost
+	for _, h := range s.reports {
+		if h.State != reportReported {
+			continue
+		}
+		if victim == nil || cmp.Or(cmp.Compare(h.reporters(), victim.reporters()), h.lastReport().Compare(victim.lastReport())) < 0 {
+			victim = h
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	delete(s.reports, reportKey{victim.Host, victim.Destination})
+	return true
+}
+
+// reporters returns how many distinct networks reported h.
+func (h *reportedHost) reporters() int {
+	seen := make(map[string]bool)
+	for _, r := range h.Reports {
+		seen[r.Reporter] = true
+	}
+	return len(seen)
+}
+
+// lastReport returns when h was last reported.
+func (h *reportedHost) lastReport() time.Time {
+	if len(h.Reports) == 0 {
+		return time.Time{}
+	}
+	return h.Reports[len(h.Reports)-1].Time
+}
+
+// list returns the entries in state, or all of them for "", most reported
+// first.
+func (s *abuseReportStore) list(state string) []reportedHost {
+	s.mu.Lock()
+	var reports []reportedHost
+	for _, h := range s.reports {
+		if state == "" || h.State == state {
+			c := *h
+			c.Reports = slices.Clone(h.Reports)
+			c.Reporters = h.reporters()
+			reports = append(reports, c)
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(reports, func(a, b reportedHost) int {
+		return cmp.Or(cmp.Compare(b.Reporters, a.Reporters), strings.Compare(a.Host, b.Host), strings.Compare(a.Destination, b.Destination))
+	})
+	return reports
+}
+
+// setState changes the state of the reports of host's links to
+// destination, or with "" dismisses them, saving the change at once, and
+// reports whether there were any.
+func (s *abuseReportStore) setState(host, destination, state string) bool {
+	key := reportKey{host, destination}
+	s.mu.Lock()
+	h := s.reports[key]
+	switch {
+	case h == nil:
+	case state == "":
+		delete(s.reports, key)
+	default:
+		h.State = state
+	}
+	s.mu.Unlock()
+	if h != nil {
+		s.save()
+	}
+	return h != nil
+}
+
+// CheckTarget warns before a host's redirects to a destination awaiting
+// review, and refuses those taken down.
+func (s *abuseReportStore) CheckTarget(ctx context.Context, location string) error {
+	info := redirect.LookupInfoFrom(ctx)
+	u, err := url.Parse(location)
+	if info == nil || err != nil {
+		return nil
+	}
+	dest, err := redirect.ASCIIHost(u.Hostname())
+	if err != nil {
+		return nil
+	}
+	var state string
+	s.mu.Lock()
+	if h := s.reports[reportKey{info.Host, dest}]; h != nil {
+		state = h.State
+	}
+	s.mu.Unlock()
+	switch state {
+	case reportWarning:
+		return &redirect.BlockedError{Status: http.StatusOK, Reason: "visitors have reported it as malicious, and it is awaiting review", Proceed: true}
+	case reportTakenDown:
+		return &redirect.BlockedError{Status: http.StatusForbidden, Reason: "it was reported as malicious and has been taken down"}
+	}
+	return nil
+}
+
+// serve returns next, answering reportPath on every host with pages' report
+// form, and taking the reports it posts.
+func (s *abuseReportStore) serve(pages *redirect.Pages, next http.Handler) http.Handler {
+	if pages == nil {
+		pages = redirect.DefaultPages()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := redirect.ParseHost(r.Host)
+		if r.URL.Path != reportPath || err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			pages.ServeReport(w, r, host, r.URL.Query().Get("path"), http.StatusOK, "")
+		case http.MethodPost:
+			s.handleReport(w, r, pages, host)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// handleReport takes a report, posted with the path of a link on host and
+// the visitor's reason, of where the link leads. Reports posted from other
+// sites are refused, so a page elsewhere can't file them in its visitors'
+// names.
+func (s *abuseReportStore) handleReport(w http.ResponseWriter, r *http.Request, pages *redirect.Pages, host string) {
+	if err := s.crossOrigin.Check(r); err != nil {
+		http.Error(w, "Reports must be made from this site's own form", http.StatusForbidden)
+		return
+	}
+	ip := redirect.ClientIP(r)
+	if ok, wait := s.limiter.Allow(ip); !ok {
+		rateLimited.Inc("abuse_reports")
+		tooManyRequests(w, wait)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	path := r.PostFormValue("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	location, dest := destination(r.Context(), host, path)
+	if dest == "" {
+		pages.ServeReport(w, r, host, path, http.StatusNotFound, fmt.Sprintf("%s%s doesn't redirect to another site, so there's nothing to report.", host, path))
+		return
+	}
+	reason := strings.TrimSpace(r.PostFormValue("reason"))
+	if len(reason) > maxReportReason {
+		reason = strings.ToValidUTF8(reason[:maxReportReason], "")
+	}
+	outcome := s.add(host, dest, abuseReport{
+		Time:     time.Now().UTC(),
+		Link:     host + path,
+		Location: location,
+		Reason:   reason,
+		Reporter: s.reporter(ip),
+	})
+	abuseReportsTotal.Inc(outcome)
+	if outcome == "full" {
+		pages.ServeReport(w, r, host, path, http.StatusServiceUnavailable, "We can't take more reports right now. Please try again later.")
+		return
+	}
+	// A duplicate is thanked too, so reporting again gives nothing away.
+	pages.ServeReport(w, r, host, path, http.StatusOK, fmt.Sprintf("Thanks. We'll review where %s%s leads.", host, path))
+}
+
+// reporter returns who a report from ip counts as: a keyed hash of its /24
+// or /64, so that one network's many addresses count once.
+func (s *abuseReportStore) reporter(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return s.reporters.anonymize(ip)
+	}
+	addr = addr.Unmap()
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	p, _ := addr.Prefix(bits)
+	return s.reporters.anonymizeAddr(p.Addr())
+}
+
+// destination returns where a GET of path on host redirects, and the host
+// it leads to, or "", "" if it doesn't lead off host.
+func destination(ctx context.Context, host, path string) (location, dest string) {
+	rules, err := resolver.LookupConfig(ctx, host)
+	if err != nil {
+		return "", ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", ""
+	}
+	rd, err := redirect.MatchRequest(rules, req, false)
+	if err != nil {
+		return "", ""
+	}
+	u, err := url.Parse(rd.Location)
+	if err != nil || u.Hostname() == "" {
+		return "", ""
+	}
+	dest, err = redirect.ASCIIHost(u.Hostname())
+	if err != nil || dest == host {
+		return "", ""
+	}
+	return rd.Location, dest
+}
+
+// handleList serves GET /reports?state=..., the reported links, in state
+// if given, most reported first, as JSON.
+func (s *abuseReportStore) handleList(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && state != reportReported && state != reportWarning && state != reportTakenDown {
+		http.Error(w, "state must be reported, warning or taken_down", http.StatusBadRequest)
+		return
+	}
+	reports := s.list(state)
+	if reports == nil {
+		reports = []reportedHost{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// reportedPair returns the host and destination named in the path of an
+// admin request about their reports, answering 400 if either isn't a
+// hostname.
+func reportedPair(w http.ResponseWriter, r *http.Request) (host, destination string, ok bool) {
+	host, err := redirect.ParseHost(r.PathValue("host"))
+	if err == nil {
+		destination, err = redirect.ParseHost(r.PathValue("destination"))
+	}
+	if err != nil {
+		http.Error(w, "host and destination must be hostnames", http.StatusBadRequest)
+		return "", "", false
+	}
+	return host, destination, true
+}
+
+// handleState returns the handler of PUT
+// /reports/{host}/{destination}/warning and .../takedown, which put host's
+// redirects to destination behind a warning, or refuse them, from then on.
+func (s *abuseReportStore) handleState(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, destination, ok := reportedPair(w, r)
+		if !ok {
+			return
+		}
+		if !s.setState(host, destination, state) {
+			http.Error(w, fmt.Sprintf("%s's links to %s have not been reported", host, destination), http.StatusNotFound)
+			return
+		}
+		log.Printf("Abuse reports of %s's links to %s are now %s", host, destination, state)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDismiss serves DELETE /reports/{host}/{destination}, dropping the
+// reports of host's links to destination and lifting any warning or
+// takedown.
+func (s *abuseReportStore) handleDismiss(w http.ResponseWriter, r *http.Request) {
+	host, destination, ok := reportedPair(w, r)
+	if !ok {
+		return
+	}
+	if !s.setState(host, destination, "") {
+		http.Error(w, fmt.Sprintf("%s's links to %s have not been reported", host, destination), http.StatusNotFound)
+		return
+	}
+	log.Printf("Dismissed abuse reports of %s's links to %s", host, destination)
+	w.WriteHeader(http.StatusNoContent)
+}
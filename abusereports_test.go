@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestAbuseReports(t *testing.T) {
+	origResolver, origReports := resolver, abuseReports
+	t.Cleanup(func() { resolver, abuseReports = origResolver, origReports })
+	resolver = redirect.StaticResolver{
+		"go.example.com":    {"Redirects from /login to https://Evil.example.net/login", "Redirects from /home to /"},
+		"other.example.com": {"Redirects to https://evil.example.net/login"},
+	}
+
+	cfg := defaultConfig()
+	cfg.AbuseReports = true
+	cfg.AbuseReportThreshold = 2
+	cfg.AbuseReportsFile = filepath.Join(t.TempDir(), "reports.json")
+	var err error
+	if abuseReports, err = newAbuseReportStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := newMux(cfg, nil, abuseReports)
+	admin := newAdminMux(cfg, nil)
+
+	report := func(ip, path string) *httptest.ResponseRecorder {
+		form := url.Values{"path": {path}, "reason": {"It asks for my bank password"}}
+		req := httptest.NewRequest("POST", reportPath, strings.NewReader(form.Encode()))
+		req.Host = "go.example.com"
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	visit := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/login", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	call := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	if rr := report("192.0.2.1", "/home"); rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "nothing to report") {
+		t.Errorf("reporting a link that stays on the host: got %d\n%s", rr.Code, rr.Body)
+	}
+	if rr := report("192.0.2.1", "/login"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Thanks") {
+		t.Errorf("reporting: got %d\n%s", rr.Code, rr.Body)
+	}
+	report("192.0.2.1", "/login")
+	report("192.0.2.77", "/login")
+	if rr := visit("go.example.com"); rr.Code != http.StatusFound {
+		t.Errorf("one network's reports, from two of its addresses, shouldn't warn: got %d", rr.Code)
+	}
+	report("2001:db8::1", "/login")
+	if rr := visit("go.example.com"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "awaiting review") {
+		t.Errorf("past the threshold: want the warning, got %d\n%s", rr.Code, rr.Body)
+	}
+	if rr := visit("other.example.com"); rr.Code != http.StatusFound {
+		t.Errorf("another host's redirect to the same destination: want it left alone, got %d", rr.Code)
+	}
+
+	var listed []reportedHost
+	json.Unmarshal(call("GET", "/reports?state=warning").Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].Host != "go.example.com" || listed[0].Destination != "evil.example.net" || listed[0].Reporters != 2 || len(listed[0].Reports) != 2 {
+		t.Fatalf("GET /reports: got %+v", listed)
+	}
+	if r := listed[0].Reports[0]; r.Link != "go.example.com/login" || r.Location != "https://Evil.example.net/login" || strings.Contains(r.Reporter, "192.0.2") {
+		t.Errorf("report: got %+v", r)
+	}
+
+	if rr := call("PUT", "/reports/go.example.com/evil.example.net/takedown"); rr.Code != http.StatusNoContent {
+		t.Errorf("takedown: got %d", rr.Code)
+	}
+	if rr := visit("go.example.com"); rr.Code != http.StatusForbidden {
+		t.Errorf("taken down: want 403, got %d", rr.Code)
+	}
+	reloaded, err := newAbuseReportStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.list(""); len(got) != 1 || got[0].State != reportTakenDown {
+		t.Errorf("reloaded from abuse_reports_file: got %+v", got)
+	}
+
+	if rr := call("DELETE", "/reports/go.example.com/evil.example.net"); rr.Code != http.StatusNoContent {
+		t.Errorf("dismiss: got %d", rr.Code)
+	}
+	if rr := visit("go.example.com"); rr.Code != http.StatusFound {
+		t.Errorf("dismissed: want the redirect back, got %d", rr.Code)
+	}
+	if rr := call("DELETE", "/reports/go.example.com/evil.example.net"); rr.Code != http.StatusNotFound {
+		t.Errorf("dismissing again: want 404, got %d", rr.Code)
+	}
+	if rr := call("DELETE", "/reports/go.example.com/not%20a%20host"); rr.Code != http.StatusBadRequest {
+		t.Errorf("dismissing a non-hostname: want 400, got %d", rr.Code)
+	}
+}
+
+func TestAbuseReportIDN(t *testing.T) {
+	origResolver, origReports := resolver, abuseReports
+	t.Cleanup(func() { resolver, abuseReports = origResolver, origReports })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://Bücher.example/*"}}
+
+	cfg := defaultConfig()
+	cfg.AbuseReports = true
+	var err error
+	if abuseReports, err = newAbuseReportStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := newMux(cfg, nil, abuseReports)
+	admin := newAdminMux(cfg, nil)
+
+	form := url.Values{"path": {"/login"}}
+	req := httptest.NewRequest("POST", reportPath, strings.NewReader(form.Encode()))
+	req.Host = "go.example.com"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := abuseReports.list(""); len(got) != 1 || got[0].Destination != "xn--bcher-kva.example" {
+		t.Fatalf("want the report stored under the ASCII destination, got %+v", got)
+	}
+
+	for _, dest := range []string{"b%C3%BCcher.example", "xn--bcher-kva.example"} {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest("PUT", "/reports/go.example.com/"+dest+"/takedown", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("takedown of %s: got %d", dest, rr.Code)
+		}
+	}
+	req = httptest.NewRequest("GET", "/login", nil)
+	req.Host = "go.example.com"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("taken down: want 403, got %d", rr.Code)
+	}
+}
+
+func TestAbuseReportThreshold(t *testing.T) {
+	origResolver := resolver
+	t.Cleanup(func() { resolver = origResolver })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://evil.example.net/*"}}
+
+	cfg := defaultConfig()
+	cfg.AbuseReports = true
+	s, err := newAbuseReportStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.serve(nil, http.NotFoundHandler())
+	for i := range 5 {
+		form := url.Values{"path": {"/login"}}
+		req := httptest.NewRequest("POST", reportPath, strings.NewReader(form.Encode()))
+		req.Host = "go.example.com"
+		req.RemoteAddr = fmt.Sprintf("192.0.%d.1:1234", i)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if got := s.list(""); len(got) != 1 || got[0].Reporters != 5 || got[0].State != reportReported {
+		t.Errorf("without abuse_report_threshold, want the reports awaiting review, got %+v", got)
+	}
+}
+
+func TestAbuseReportCrossOrigin(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AbuseReports = true
+	s, err := newAbuseReportStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", reportPath, strings.NewReader("path=/login"))
+	req.Host = "go.example.com"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr := httptest.NewRecorder()
+	s.serve(nil, http.NotFoundHandler()).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || len(s.list("")) != 0 {
+		t.Errorf("a cross-site report: want 403 and nothing kept, got %d", rr.Code)
+	}
+}
+
+func TestAbuseReportEviction(t *testing.T) {
+	s := &abuseReportStore{reports: make(map[reportKey]*reportedHost)}
+	now := time.Now()
+	for i := range maxReportedHosts {
+		s.add("go.example.com", fmt.Sprintf("d%d.example.net", i), abuseReport{Time: now.Add(time.Duration(i)), Reporter: "a"})
+	}
+	s.add("go.example.com", "d1.example.net", abuseReport{Time: now, Reporter: "b"})
+	s.setState("go.example.com", "d0.example.net", reportTakenDown)
+	if got := s.add("go.example.com", "new.example.net", abuseReport{Time: now, Reporter: "a"}); got != "accepted" {
+		t.Fatalf("once full: want the report accepted, got %q", got)
+	}
+	for _, dest := range []string{"d0.example.net", "d1.example.net", "new.example.net"} {
+		if s.reports[reportKey{"go.example.com", dest}] == nil {
+			t.Errorf("%s was evicted", dest)
+		}
+	}
+	if s.reports[reportKey{"go.example.com", "d2.example.net"}] != nil {
+		t.Error("want the oldest entry with the fewest reporters evicted")
+	}
+}
+
+func TestAbuseReportRateLimit(t *testing.T) {
+	origResolver := resolver
+	t.Cleanup(func() { resolver = origResolver })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/*"}}
+
+	cfg := defaultConfig()
+	cfg.AbuseReports = true
+	s, err := newAbuseReportStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.serve(nil, http.NotFoundHandler())
+	var codes []int
+	for i := range 6 {
+		form := url.Values{"path": {"/" + strings.Repeat("a", i+1)}}
+		req := httptest.NewRequest("POST", reportPath, strings.NewReader(form.Encode()))
+		req.Host = "go.example.com"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	if codes[4] != http.StatusOK || codes[5] != http.StatusTooManyRequests {
+		t.Errorf("want five reports taken and the sixth refused, got %v", codes)
+	}
+}
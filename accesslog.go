@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/trace"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// accessLog receives a structured entry for every request. It is nil when
+// access_log is "off".
+var accessLog *slog.Logger
+
+// newAccessLog returns the logger configured by access_log and log_level,
+// writing JSON to the sink openLogSink picks, or nil for "off".
+func newAccessLog(cfg *config) (*slog.Logger, error) {
+	if cfg.AccessLog == "off" {
+		return nil, nil
+	}
+	w, err := openLogSink(cfg, cfg.AccessLog)
+	if err != nil {
+		return nil, fmt.Errorf("access_log: %w", err)
+	}
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.LogLevel))
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+}
+
+// statusRecorder remembers the status written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// logRequests logs each request to l once it has been answered, with the
+// rule it matched and how its host's configuration was looked up. Health
+// checks are logged at debug level, server errors at error level. Client IPs
+// are logged as ip allows, if at all.
+func logRequests(l *slog.Logger, ip *ipAnonymizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := new(redirect.LookupInfo)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(redirect.WithLookupInfo(r.Context(), info)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case isHealthCheck(r):
+			level = slog.LevelDebug
+		case rec.status >= 500:
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("host", r.Host),
+			slog.String("path", r.URL.RequestURI()),
+			slog.Int("status", rec.status),
+		}
+		if client := ip.anonymize(redirect.ClientIP(r)); client != "" {
+			attrs = append(attrs, slog.String("client_ip", client))
+		}
+		attrs = append(attrs, slog.Float64("duration_ms", milliseconds(time.Since(start))))
+		if loc := rec.Header().Get("Location"); loc != "" {
+			attrs = append(attrs, slog.String("location", loc))
+		}
+		if info.Rule != nil {
+			attrs = append(attrs, slog.String("rule", info.Rule.String()))
+		}
+		if info.Duration > 0 {
+			attrs = append(attrs,
+				slog.String("source", info.Source),
+				slog.Bool("cache_hit", info.Cached),
+				slog.Float64("lookup_ms", milliseconds(info.Duration)),
+			)
+		}
+		if ua := r.UserAgent(); ua != "" {
+			attrs = append(attrs, slog.String("user_agent", ua))
+		}
+		if info.Bot {
+			attrs = append(attrs, slog.Bool("bot", true))
+		}
+		if id := redirect.RequestIDFrom(r.Context()); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if span := trace.FromContext(r.Context()); span != nil {
+			attrs = append(attrs, slog.String("trace_id", span.Context().TraceID.String()))
+		}
+		l.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
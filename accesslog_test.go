@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestAccessLog(t *testing.T) {
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.NewLayers(redirect.Source{Name: "dns", Resolver: redirect.NewCache(
+		redirect.StaticResolver{"go.example.com": {"Redirects from /docs/* to https://docs.example.com/* permanently"}}, 0)})
+
+	var buf bytes.Buffer
+	origLog := accessLog
+	t.Cleanup(func() { accessLog = origLog })
+	accessLog = slog.New(slog.NewJSONHandler(&buf, nil))
+	h := newMux(defaultConfig(), nil)
+
+	req := httptest.NewRequest("GET", "/docs/intro?x=1", nil)
+	req.Host = "go.example.com"
+	req.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("want one JSON line (health checks are debug), got %q: %v", buf.String(), err)
+	}
+	for key, want := range map[string]any{
+		"msg":        "request",
+		"host":       "go.example.com",
+		"path":       "/docs/intro?x=1",
+		"status":     float64(301),
+		"location":   "https://docs.example.com/intro?x=1",
+		"rule":       "Redirects from /docs/* to https://docs.example.com/* permanently",
+		"source":     "dns",
+		"cache_hit":  false,
+		"client_ip":  "192.0.2.1",
+		"user_agent": "test",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s: want %v, got %v", key, want, entry[key])
+		}
+	}
+	for _, key := range []string{"lookup_ms", "request_id"} {
+		if _, ok := entry[key]; !ok {
+			t.Errorf("want %s", key)
+		}
+	}
+}
+
+func TestNewAccessLog(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AccessLog = "off"
+	if l, err := newAccessLog(cfg); l != nil || err != nil {
+		t.Errorf("off: got %v, %v", l, err)
+	}
+
+	cfg.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	cfg.LogLevel = "warn"
+	l, err := newAccessLog(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("dropped")
+	l.Error("kept")
+	data, _ := os.ReadFile(cfg.AccessLog)
+	if bytes.Contains(data, []byte("dropped")) || !bytes.Contains(data, []byte("kept")) {
+		t.Errorf("log_level warn: got %q", data)
+	}
+}
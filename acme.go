@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"slices"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Key types for acme_key_type. ECDSA certificates fall back to RSA for the
+// rare clients that can't verify them.
+const (
+	keyTypeECDSA = "ecdsa"
+	keyTypeRSA   = "rsa"
+)
+
+// newACMEClient returns a client for the CA at cfg's acme_directory.
+func newACMEClient(cfg *config) *acme.Client {
+	return &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+}
+
+// acmeAccount returns the account to register, with email as its contact
+// if set, bound to the CA account eab identifies if not nil.
+func acmeAccount(email string, eab *acme.ExternalAccountBinding) *acme.Account {
+	a := &acme.Account{ExternalAccountBinding: eab}
+	if email != "" {
+		a.Contact = []string{"mailto:" + email}
+	}
+	return a
+}
+
+// newCertKey generates a certificate key of keyType.
+func newCertKey(keyType string) (crypto.Signer, error) {
+	if keyType == keyTypeRSA {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// rsaOnly returns config with its certificates asked for as if no client
+// supported ECDSA, so autocert only issues and serves RSA ones.
+func rsaOnly(config *tls.Config) *tls.Config {
+	get := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		h := *hello
+		h.SignatureSchemes = slices.DeleteFunc(slices.Clone(hello.SignatureSchemes), isECDSAScheme)
+		if h.SignatureSchemes == nil {
+			h.SignatureSchemes = []tls.SignatureScheme{}
+		}
+		return get(&h)
+	}
+	return config
+}
+
+func isECDSAScheme(s tls.SignatureScheme) bool {
+	switch s {
+	case tls.ECDSAWithSHA1, tls.ECDSAWithP256AndSHA256, tls.ECDSAWithP384AndSHA384, tls.ECDSAWithP521AndSHA512:
+		return true
+	}
+	return false
+}
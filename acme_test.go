@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestRSAOnly(t *testing.T) {
+	var got *tls.ClientHelloInfo
+	config := rsaOnly(&tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		got = hello
+		return nil, nil
+	}})
+	for _, schemes := range [][]tls.SignatureScheme{
+		{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		nil,
+	} {
+		hello := &tls.ClientHelloInfo{ServerName: "example.com", SignatureSchemes: schemes}
+		config.GetCertificate(hello)
+		if got.ServerName != "example.com" || got.SignatureSchemes == nil || slices.ContainsFunc(got.SignatureSchemes, isECDSAScheme) {
+			t.Errorf("%v: got %v", schemes, got.SignatureSchemes)
+		}
+		if !slices.Equal(hello.SignatureSchemes, schemes) {
+			t.Errorf("%v: modified to %v", schemes, hello.SignatureSchemes)
+		}
+	}
+}
+
+func TestAccountContact(t *testing.T) {
+	if got := acmeAccount("ops@example.com", nil).Contact; !slices.Equal(got, []string{"mailto:ops@example.com"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := acmeAccount("", nil).Contact; got != nil {
+		t.Errorf("no email: got %v", got)
+	}
+}
+
+func TestACMEEAB(t *testing.T) {
+	for _, key := range []string{"c2VjcmV0LWtleT__", "c2VjcmV0LWtleT8="} {
+		cfg := &config{ACMEEABKeyID: "kid-1", ACMEEABHMACKey: key}
+		eab, err := cfg.acmeEAB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eab.KID != "kid-1" || !strings.HasPrefix(string(eab.Key), "secret-key?") {
+			t.Errorf("%s: got %q %q", key, eab.KID, eab.Key)
+		}
+	}
+	if eab, err := (&config{}).acmeEAB(); eab != nil || err != nil {
+		t.Errorf("unset: got %v, %v", eab, err)
+	}
+}
+
+func TestDNSCertManagerRSA(t *testing.T) {
+	provider := &fakeProvider{records: make(map[string][]string)}
+	ca := newFakeCA(t, provider)
+	cache := autocert.DirCache(t.TempDir())
+	newManager := func() *dnsCertManager {
+		return &dnsCertManager{
+			client:     &acme.Client{DirectoryURL: ca.URL + "/dir"},
+			provider:   provider,
+			cache:      cache,
+			domains:    []string{"*.example.com"},
+			propagated: func(ctx context.Context, fqdn, value string) error { return nil },
+			keyType:    keyTypeRSA,
+			certs:      make(map[string]*tls.Certificate),
+		}
+	}
+	ctx := context.Background()
+	if err := newManager().renew(ctx, "*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := newManager().certificate(ctx, "*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cert.PrivateKey.(*rsa.PrivateKey); !ok {
+		t.Errorf("key is %T", cert.PrivateKey)
+	}
+	if provider.presents != 2 {
+		t.Errorf("presented %d records", provider.presents)
+	}
+}
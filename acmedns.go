@@ -0,0 +1,640 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/certcache"
+	"github.com/frolic/redirect.name/internal/dnsprovider"
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/publicsuffix"
+)
+
+// dnsRenewBefore is how long before expiry DNS-01 certificates are
+// renewed, dnsRenewEvery how often that's checked, and dnsRetryAfter how
+// long an apex whose wildcard couldn't be issued is left to autocert.
+// dnsLockTTL bounds an order, challenge propagation included, holding the
+// lock between replicas. At most dnsMaxPending apex wildcards are ordered
+// at once, and dnsMaxFailed failures remembered.
+const (
+	dnsRenewBefore = 30 * 24 * time.Hour
+	dnsRenewEvery  = 12 * time.Hour
+	dnsRetryAfter  = time.Hour
+	dnsLockTTL     = 15 * time.Minute
+	dnsMaxPending  = 16
+	dnsMaxFailed   = 1024
+)
+
+// dnsCertManager obtains certificates for acme_dns_domains with ACME
+// DNS-01 challenges, published through a DNS provider, so wildcard names
+// and hosts unreachable on port 80 get certificates too. Each domain gets
+// its own certificate, and a wildcard's also covers its parent name. They
+// are issued and renewed in the background by run; handshakes only use
+// what's been issued.
+//
+// With wildcards, other hosts policy allows get one wildcard certificate
+// per apex: the first handshake for go.example.com orders *.example.com in
+// the background and is answered by autocert meanwhile, and later ones for
+// any name directly under example.com share the wildcard.
+type dnsCertManager struct {
+	client   *acme.Client
+	provider dnsprovider.Provider
+	cache    autocert.Cache
+	// limit, if not nil, holds orders to autocert's certsPerApex, and
+	// counts them.
+	limit   *rateLimitedCache
+	domains []string
+	// propagated waits for a challenge record to be visible.
+	propagated func(ctx context.Context, fqdn, value string) error
+	wildcards  bool
+	policy     autocert.HostPolicy
+	// locker, if not nil, keeps replicas from ordering the same
+	// certificate at once.
+	locker certcache.Locker
+	// caa, if not nil, checks names' CAA records let the CA issue for
+	// them before ordering.
+	caa     *caaChecker
+	email   string
+	eab     *acme.ExternalAccountBinding
+	keyType string
+
+	mu      sync.Mutex
+	certs   map[string]*tls.Certificate
+	apexes  map[string]bool      // wildcard domains issued on demand
+	failed  map[string]time.Time // and those that failed, when
+	pending map[string]bool
+	issuing sync.WaitGroup
+
+	registerMu sync.Mutex
+	registered bool
+}
+
+// newDNSCertManager returns the DNS-01 manager cfg asks for, with caa (if
+// not nil) vetting its orders, or nil.
+// policy vets hosts before their apex gets a wildcard, and certificates
+// are kept in cache, counting toward their apex's certsPerApex.
+func newDNSCertManager(cfg *config, policy autocert.HostPolicy, cache autocert.Cache, caa *caaChecker) *dnsCertManager {
+	if cfg.ACMEDNSProvider == "" {
+		return nil
+	}
+	provider, err := dnsprovider.New(cfg.ACMEDNSProvider, cfg.ACMEDNSCredentials)
+	if err != nil {
+		log.Fatal(err)
+	}
+	eab, err := cfg.acmeEAB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeout := cfg.ACMEDNSPropagation
+	locker, _ := cache.(certcache.Locker)
+	return &dnsCertManager{
+		client:   newACMEClient(cfg),
+		provider: provider,
+		cache:    cache,
+		limit:    newRateLimitedCache(cache),
+		locker:   locker,
+		caa:      caa,
+		domains:  cfg.acmeDNSDomains(),
+		propagated: func(ctx context.Context, fqdn, value string) error {
+			return waitForTXT(ctx, net.DefaultResolver, fqdn, value, timeout)
+		},
+		wildcards: cfg.ACMEDNSWildcards,
+		policy:    policy,
+		email:     cfg.ACMEEmail,
+		eab:       eab,
+		keyType:   cfg.ACMEKeyType,
+		certs:     make(map[string]*tls.Certificate),
+		apexes:    make(map[string]bool),
+		failed:    make(map[string]time.Time),
+		pending:   make(map[string]bool),
+	}
+}
+
+// tlsConfig returns config with its certificates for m's domains coming
+// from m, and the rest as before.
+func (m *dnsCertManager) tlsConfig(config *tls.Config) *tls.Config {
+	next := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return next(hello)
+		}
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if domain := m.covering(name); domain != "" {
+			return m.certificate(ctx, domain)
+		}
+		if domain := m.apexWildcard(name); domain != "" && m.allowed(ctx, domain, name) {
+			if cert, err := m.certificate(ctx, domain); err == nil {
+				m.mu.Lock()
+				m.apexes[domain] = true
+				m.mu.Unlock()
+				return cert, nil
+			}
+			m.issueLater(domain)
+		}
+		return next(hello)
+	}
+	return config
+}
+
+// apexWildcard returns the wildcard domain for name's apex, if m issues
+// those and it would cover name, or "".
+func (m *dnsCertManager) apexWildcard(name string) string {
+	if !m.wildcards || name == "" {
+		return ""
+	}
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return ""
+	}
+	if _, parent, _ := strings.Cut(name, "."); name != apex && parent != apex {
+		return ""
+	}
+	return "*." + apex
+}
+
+// allowed reports whether name may share its apex's wildcard domain: it
+// has been issued already, or policy allows name.
+func (m *dnsCertManager) allowed(ctx context.Context, domain, name string) bool {
+	m.mu.Lock()
+	issued := m.apexes[domain]
+	m.mu.Unlock()
+	return issued || m.policy(ctx, name) == nil
+}
+
+// issueLater issues domain in the background, unless it's already being
+// issued, failed recently or dnsMaxPending others are being issued.
+func (m *dnsCertManager) issueLater(domain string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending[domain] || len(m.pending) >= dnsMaxPending || time.Since(m.failed[domain]) < dnsRetryAfter {
+		return
+	}
+	m.pending[domain] = true
+	m.issuing.Add(1)
+	go func() {
+		defer m.issuing.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		err := m.renew(ctx, domain)
+		if err != nil {
+			m.report(domain, err)
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.pending, domain)
+		if err != nil {
+			m.fail(domain)
+			return
+		}
+		delete(m.failed, domain)
+		m.apexes[domain] = true
+	}()
+}
+
+// fail records that domain failed now, forgetting failures older than
+// dnsRetryAfter and, past dnsMaxFailed, the oldest. m.mu must be held.
+func (m *dnsCertManager) fail(domain string) {
+	now := time.Now()
+	if len(m.failed) >= dnsMaxFailed {
+		var oldest string
+		for d, t := range m.failed {
+			if now.Sub(t) >= dnsRetryAfter {
+				delete(m.failed, d)
+			} else if oldest == "" || t.Before(m.failed[oldest]) {
+				oldest = d
+			}
+		}
+		if len(m.failed) >= dnsMaxFailed {
+			delete(m.failed, oldest)
+		}
+	}
+	m.failed[domain] = now
+}
+
+// report logs and reports a failure to issue domain's certificate.
+func (m *dnsCertManager) report(domain string, err error) {
+	log.Printf("DNS-01 certificate for %s: %v", domain, err)
+	recentCertErrors.record(domain, err)
+	errorReports.report(errorEvent{
+		Kind:    "certificate",
+		Message: err.Error(),
+		Tags:    map[string]string{"server_name": domain},
+	})
+}
+
+// covering returns the domain among m's whose certificate covers name, or
+// "".
+func (m *dnsCertManager) covering(name string) string {
+	if name == "" {
+		return ""
+	}
+	if slices.Contains(m.domains, name) {
+		return name
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok && slices.Contains(m.domains, "*."+parent) {
+		return "*." + parent
+	}
+	if slices.Contains(m.domains, "*."+name) {
+		return "*." + name
+	}
+	return ""
+}
+
+// domainFor returns the domain whose certificate m serves name with, or
+// "".
+func (m *dnsCertManager) domainFor(name string) string {
+	if domain := m.covering(name); domain != "" {
+		return domain
+	}
+	if domain := m.apexWildcard(name); domain != "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.apexes[domain] {
+			return domain
+		}
+	}
+	return ""
+}
+
+// forget drops domain's certificate from memory once it's been deleted
+// from the cache.
+func (m *dnsCertManager) forget(domain string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.certs, domain)
+	delete(m.apexes, domain)
+}
+
+// retry lets name's apex wildcard, if it failed, be ordered again at the
+// next handshake rather than after dnsRetryAfter.
+func (m *dnsCertManager) retry(name string) {
+	if domain := m.apexWildcard(name); domain != "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.failed, domain)
+	}
+}
+
+// certificate returns the issued certificate for domain, loading it from
+// the cache if need be.
+func (m *dnsCertManager) certificate(ctx context.Context, domain string) (*tls.Certificate, error) {
+	m.mu.Lock()
+	cert := m.certs[domain]
+	m.mu.Unlock()
+	if cert != nil && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	cert, err := m.load(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("no DNS-01 certificate for %s yet: %w", domain, err)
+	}
+	if !time.Now().Before(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("DNS-01 certificate for %s expired %s", domain, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	m.mu.Lock()
+	m.certs[domain] = cert
+	m.mu.Unlock()
+	return cert, nil
+}
+
+// run issues and renews certificates for m's domains, and the apex
+// wildcards in use, until ctx is done. Failures are reported and retried
+// at the next check.
+func (m *dnsCertManager) run(ctx context.Context) {
+	for {
+		m.mu.Lock()
+		domains := slices.Clone(m.domains)
+		for domain := range m.apexes {
+			domains = append(domains, domain)
+		}
+		m.mu.Unlock()
+		for _, domain := range domains {
+			if err := m.renew(ctx, domain); err != nil && ctx.Err() == nil {
+				m.report(domain, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dnsRenewEvery):
+		}
+	}
+}
+
+// renew issues a certificate for domain unless a cached one is good for
+// another dnsRenewBefore.
+func (m *dnsCertManager) renew(ctx context.Context, domain string) error {
+	good := func(cert *tls.Certificate) bool { return time.Until(cert.Leaf.NotAfter) > dnsRenewBefore }
+	if cert, err := m.certificate(ctx, domain); err == nil && good(cert) {
+		return nil
+	}
+	return m.order(ctx, domain, good)
+}
+
+// reissue issues a certificate for domain however long the cached one is
+// good for.
+func (m *dnsCertManager) reissue(ctx context.Context, domain string) error {
+	return m.order(ctx, domain, nil)
+}
+
+// order issues a certificate for domain under the replicas' lock, unless
+// good, if not nil, accepts one another replica cached meanwhile.
+func (m *dnsCertManager) order(ctx context.Context, domain string, good func(*tls.Certificate) bool) error {
+	if m.locker != nil {
+		unlock, err := certcache.Lock(ctx, m.locker, "lock+"+m.cacheKey(domain), dnsLockTTL)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if cert, err := m.load(ctx, domain); err == nil && good != nil && good(cert) {
+			m.mu.Lock()
+			m.certs[domain] = cert
+			m.mu.Unlock()
+			return nil
+		}
+	}
+	cert, err := m.issue(ctx, domain)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.certs[domain] = cert
+	m.mu.Unlock()
+	return nil
+}
+
+// certNames returns the names a certificate for domain is issued for.
+func certNames(domain string) []string {
+	if parent, ok := strings.CutPrefix(domain, "*."); ok {
+		return []string{domain, parent}
+	}
+	return []string{domain}
+}
+
+// cacheKey is where domain's certificate is kept in the cache, apart from
+// autocert's, and from those of other key types.
+func (m *dnsCertManager) cacheKey(domain string) string {
+	key := "dns01+" + strings.Replace(domain, "*", "wildcard", 1)
+	if m.keyType == keyTypeRSA {
+		key += "+rsa"
+	}
+	return key
+}
+
+// issue orders a certificate for domain, answering its challenges through
+// the provider, and caches it.
+func (m *dnsCertManager) issue(ctx context.Context, domain string) (*tls.Certificate, error) {
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+	names := certNames(domain)
+	if apex, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(domain, "*.")); err == nil && m.limit != nil {
+		if err := m.limit.check(ctx, apex); err != nil {
+			return nil, err
+		}
+	}
+	if m.caa != nil {
+		for _, name := range names {
+			if err := m.caa.check(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := newCertKey(m.keyType)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: names}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := newCertificate(der, key)
+	if err != nil {
+		return nil, err
+	}
+	var store autocert.Cache = m.cache
+	if m.limit != nil {
+		store = m.limit
+	}
+	if err := store.Put(ctx, m.cacheKey(domain), encodeCertificate(der, key)); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// authorize answers the DNS-01 challenge of the authorization at u, unless
+// it's already valid.
+func (m *dnsCertManager) authorize(ctx context.Context, u string) error {
+	z, err := m.client.GetAuthorization(ctx, u)
+	if err != nil {
+		return err
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s: the CA offers no dns-01 challenge", z.Identifier.Value)
+	}
+	value, err := m.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + z.Identifier.Value
+	if err := m.provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.provider.CleanUp(context.WithoutCancel(ctx), fqdn, value); err != nil {
+			log.Printf("Removing %s: %v", fqdn, err)
+		}
+	}()
+	if err := m.propagated(ctx, fqdn, value); err != nil {
+		return err
+	}
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = m.client.WaitAuthorization(ctx, z.URI)
+	return err
+}
+
+// register loads or creates the ACME account key and registers it, once.
+func (m *dnsCertManager) register(ctx context.Context) error {
+	m.registerMu.Lock()
+	defer m.registerMu.Unlock()
+	if m.registered {
+		return nil
+	}
+	if m.client.Key == nil {
+		key, err := m.accountKey(ctx)
+		if err != nil {
+			return err
+		}
+		m.client.Key = key
+	}
+	if _, err := m.client.Register(ctx, acmeAccount(m.email, m.eab), acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return err
+	}
+	m.registered = true
+	return nil
+}
+
+// accountKey returns the cached account key, generating one the first
+// time.
+func (m *dnsCertManager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	const name = "dns01_account+key"
+	data, err := m.cache.Get(ctx, name)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: not PEM", name)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cache.Put(ctx, name, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// load returns domain's cached certificate.
+func (m *dnsCertManager) load(ctx context.Context, domain string) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, m.cacheKey(domain))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data)
+}
+
+// parseCertificate parses a cached certificate: its key and chain as PEM.
+func parseCertificate(data []byte) (*tls.Certificate, error) {
+	var key crypto.Signer
+	var err error
+	var der [][]byte
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, err
+			}
+		case "RSA PRIVATE KEY":
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, err
+			}
+		case "CERTIFICATE":
+			der = append(der, block.Bytes)
+		}
+	}
+	if key == nil || len(der) == 0 {
+		return nil, errors.New("cached certificate is incomplete")
+	}
+	return newCertificate(der, key)
+}
+
+func newCertificate(der [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// encodeCertificate returns key and the chain der as PEM, as autocert
+// caches certificates.
+func encodeCertificate(der [][]byte, key crypto.Signer) []byte {
+	var block *pem.Block
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		b, _ := x509.MarshalECPrivateKey(key)
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	}
+	out := pem.EncodeToMemory(block)
+	for _, b := range der {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	return out
+}
+
+// waitForTXT polls resolver until value is among the TXT records at fqdn,
+// for up to timeout, so the CA won't look before the record has spread.
+func waitForTXT(ctx context.Context, resolver *net.Resolver, fqdn, value string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if records, err := resolver.LookupTXT(ctx, fqdn); err == nil && slices.Contains(records, value) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s didn't appear in DNS within %s", fqdn, timeout)
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// validDNSDomain reports whether d is a host name, or one under "*.", that
+// a DNS-01 certificate may be issued for.
+func validDNSDomain(d string) bool {
+	host := strings.TrimPrefix(d, "*.")
+	h, err := redirect.ParseHost(host)
+	return err == nil && h == host && strings.Contains(host, ".") && !strings.Contains(host, "*")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// fakeProvider records the TXT values presented at each name.
+type fakeProvider struct {
+	mu       sync.Mutex
+	records  map[string][]string
+	presents int
+}
+
+func (p *fakeProvider) Present(ctx context.Context, fqdn, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records[fqdn] = append(p.records[fqdn], value)
+	p.presents++
+	return nil
+}
+
+func (p *fakeProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records[fqdn] = slices.DeleteFunc(p.records[fqdn], func(v string) bool { return v == value })
+	if len(p.records[fqdn]) == 0 {
+		delete(p.records, fqdn)
+	}
+	return nil
+}
+
+// lookup returns the values at fqdn, as a resolver would once they've
+// propagated.
+func (p *fakeProvider) lookup(ctx context.Context, fqdn string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.records[fqdn]), nil
+}
+
+func (p *fakeProvider) has(fqdn string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.records[fqdn]) > 0
+}
+
+// newFakeCA returns an ACME server that validates dns-01 challenges by
+// checking provider holds a record for them, and signs certificates with
+// its own key.
+func newFakeCA(t *testing.T, provider *fakeProvider) *httptest.Server {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	var (
+		mu          sync.Mutex
+		identifiers []string
+		valid       = map[int]bool{}
+		certPEM     []byte
+	)
+	var srv *httptest.Server
+	payload := func(r *http.Request, v any) {
+		var jws struct{ Payload string }
+		json.NewDecoder(r.Body).Decode(&jws)
+		data, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+		json.Unmarshal(data, v)
+	}
+	order := func(w http.ResponseWriter, status int) {
+		var authzs []string
+		var ids []map[string]string
+		for i, id := range identifiers {
+			authzs = append(authzs, fmt.Sprintf("%s/authz/%d", srv.URL, i))
+			ids = append(ids, map[string]string{"type": "dns", "value": id})
+		}
+		state := "pending"
+		if len(valid) == len(identifiers) {
+			state = "ready"
+		}
+		o := map[string]any{"status": state, "identifiers": ids, "authorizations": authzs, "finalize": srv.URL + "/finalize"}
+		if certPEM != nil {
+			o["status"], o["certificate"] = "valid", srv.URL+"/cert"
+		}
+		w.Header().Set("Location", srv.URL+"/order")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(o)
+	}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+		switch path := r.URL.Path; {
+		case path == "/dir":
+			json.NewEncoder(w).Encode(map[string]string{"newNonce": srv.URL + "/nonce", "newAccount": srv.URL + "/account", "newOrder": srv.URL + "/new-order"})
+		case path == "/nonce":
+		case path == "/account":
+			w.Header().Set("Location", srv.URL+"/account/1")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+		case path == "/new-order":
+			var req struct{ Identifiers []struct{ Value string } }
+			payload(r, &req)
+			identifiers = nil
+			for _, id := range req.Identifiers {
+				identifiers = append(identifiers, id.Value)
+			}
+			order(w, http.StatusCreated)
+		case path == "/order":
+			order(w, http.StatusOK)
+		case strings.HasPrefix(path, "/authz/") || strings.HasPrefix(path, "/chal/"):
+			var i int
+			fmt.Sscanf(path[strings.LastIndexByte(path, '/')+1:], "%d", &i)
+			base := strings.TrimPrefix(identifiers[i], "*.")
+			if strings.HasPrefix(path, "/chal/") && provider.has("_acme-challenge."+base) {
+				valid[i] = true
+			}
+			status := "pending"
+			if valid[i] {
+				status = "valid"
+			}
+			chal := map[string]string{"type": "dns-01", "url": fmt.Sprintf("%s/chal/%d", srv.URL, i), "token": fmt.Sprintf("token%d", i), "status": status}
+			if strings.HasPrefix(path, "/chal/") {
+				json.NewEncoder(w).Encode(chal)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":     status,
+				"identifier": map[string]string{"type": "dns", "value": base},
+				"wildcard":   base != identifiers[i],
+				"challenges": []any{chal, map[string]string{"type": "http-01", "url": srv.URL + "/nope", "token": "t"}},
+			})
+		case path == "/finalize":
+			var req struct{ CSR string }
+			payload(r, &req)
+			der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			leaf := &x509.Certificate{
+				SerialNumber: big.NewInt(2),
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			}
+			leafDER, _ := x509.CreateCertificate(rand.Reader, leaf, ca, csr.PublicKey, caKey)
+			certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+			order(w, http.StatusOK)
+		case path == "/cert":
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+			w.Write(certPEM)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDNSCertManager(t *testing.T) {
+	provider := &fakeProvider{records: make(map[string][]string)}
+	ca := newFakeCA(t, provider)
+	cache := autocert.DirCache(t.TempDir())
+	newManager := func() *dnsCertManager {
+		return &dnsCertManager{
+			client:     &acme.Client{DirectoryURL: ca.URL + "/dir"},
+			provider:   provider,
+			cache:      cache,
+			domains:    []string{"*.example.com", "static.example.org"},
+			propagated: func(ctx context.Context, fqdn, value string) error { return nil },
+			certs:      make(map[string]*tls.Certificate),
+		}
+	}
+	ctx := context.Background()
+
+	m := newManager()
+	if err := m.renew(ctx, "*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.presents != 2 || len(provider.records) != 0 {
+		t.Errorf("presented %d records, %d left: %v", provider.presents, len(provider.records), provider.records)
+	}
+
+	errNext := errors.New("autocert")
+	config := m.tlsConfig(&tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, errNext }})
+	for _, name := range []string{"www.example.com", "example.com"} {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cert.Leaf.VerifyHostname(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.b.example.com"}); err != errNext {
+		t.Errorf("a.b.example.com: got %v, want autocert's answer", err)
+	}
+	if _, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "static.example.org"}); err == nil || err == errNext {
+		t.Errorf("static.example.org before issuance: got %v", err)
+	}
+
+	// A restarted manager finds the certificate in the cache instead of
+	// ordering another.
+	m = newManager()
+	if err := m.renew(ctx, "*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.presents != 2 {
+		t.Errorf("reissued: %d records presented", provider.presents)
+	}
+}
+
+func TestDNSCertManagerRateLimit(t *testing.T) {
+	provider := &fakeProvider{records: make(map[string][]string)}
+	ca := newFakeCA(t, provider)
+	cache := autocert.DirCache(t.TempDir())
+	m := &dnsCertManager{
+		client:     &acme.Client{DirectoryURL: ca.URL + "/dir"},
+		provider:   provider,
+		cache:      cache,
+		limit:      newRateLimitedCache(cache),
+		propagated: func(ctx context.Context, fqdn, value string) error { return nil },
+		certs:      make(map[string]*tls.Certificate),
+	}
+	ctx := context.Background()
+
+	// One of example.com's certificates came from autocert; the wildcard
+	// is its second.
+	if err := m.limit.Put(ctx, "go.example.com", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.renew(ctx, "*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.limit.Put(ctx, "docs.example.com", []byte("cert")); err == nil {
+		t.Error("want the DNS-01 issuance counted against example.com")
+	}
+	presents := provider.presents
+	if err := m.reissue(ctx, "*.example.com"); err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Errorf("reissuing past the limit: got %v", err)
+	}
+	if provider.presents != presents {
+		t.Error("want the order refused before any challenge")
+	}
+}
+
+func TestDNSCertManagerWildcards(t *testing.T) {
+	provider := &fakeProvider{records: make(map[string][]string)}
+	ca := newFakeCA(t, provider)
+	m := &dnsCertManager{
+		client:     &acme.Client{DirectoryURL: ca.URL + "/dir"},
+		provider:   provider,
+		cache:      autocert.DirCache(t.TempDir()),
+		propagated: func(ctx context.Context, fqdn, value string) error { return nil },
+		wildcards:  true,
+		policy: func(ctx context.Context, host string) error {
+			if strings.HasSuffix(host, ".example.com") {
+				return nil
+			}
+			return errors.New("not served")
+		},
+		certs:   make(map[string]*tls.Certificate),
+		apexes:  make(map[string]bool),
+		failed:  make(map[string]time.Time),
+		pending: make(map[string]bool),
+	}
+
+	errNext := errors.New("autocert")
+	config := m.tlsConfig(&tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, errNext }})
+	get := func(name string) (*tls.Certificate, error) {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		m.issuing.Wait()
+		return cert, err
+	}
+
+	// The first handshake is left to autocert while the apex's wildcard
+	// is issued; later ones under the apex share it.
+	if _, err := get("go.example.com"); err != errNext {
+		t.Fatalf("go.example.com: got %v, want autocert's answer", err)
+	}
+	for _, name := range []string{"go.example.com", "docs.example.com"} {
+		cert, err := get(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cert.Leaf.VerifyHostname(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if provider.presents != 2 {
+		t.Errorf("presented %d records, want one order", provider.presents)
+	}
+	if !m.apexes["*.example.com"] {
+		t.Errorf("apexes = %v", m.apexes)
+	}
+
+	// Names a wildcard wouldn't cover, or policy refuses, stay with autocert.
+	for _, name := range []string{"a.b.example.com", "other.example.net", "localhost"} {
+		if _, err := get(name); err != errNext {
+			t.Errorf("%s: got %v, want autocert's answer", name, err)
+		}
+	}
+	if provider.presents != 2 || len(m.failed) != 0 {
+		t.Errorf("presented %d records, failed %v", provider.presents, m.failed)
+	}
+}
+
+func TestDNSCertManagerFailed(t *testing.T) {
+	m := &dnsCertManager{failed: make(map[string]time.Time)}
+	now := time.Now()
+	m.failed["*.stale.example"] = now.Add(-2 * dnsRetryAfter)
+	for i := range dnsMaxFailed - 1 {
+		m.failed[fmt.Sprintf("*.d%d.example", i)] = now.Add(time.Duration(i-dnsMaxFailed) * time.Second)
+	}
+	m.fail("*.a.example")
+	if _, ok := m.failed["*.stale.example"]; ok || len(m.failed) != dnsMaxFailed {
+		t.Errorf("want the stale failure forgotten first, got %d failures", len(m.failed))
+	}
+	m.fail("*.b.example")
+	_, oldest := m.failed["*.d0.example"]
+	if _, ok := m.failed["*.b.example"]; !ok || oldest || len(m.failed) != dnsMaxFailed {
+		t.Errorf("past dnsMaxFailed: want the oldest failure forgotten, got %d failures", len(m.failed))
+	}
+}
+
+func TestValidDNSDomain(t *testing.T) {
+	for d, want := range map[string]bool{
+		"*.example.com":   true,
+		"www.example.com": true,
+		"*.*.example.com": false,
+		"example":         false,
+		"Example.com":     false,
+		"*example.com":    false,
+	} {
+		if got := validDNSDomain(d); got != want {
+			t.Errorf("%s: got %v", d, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/metrics"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+var registry = metrics.NewRegistry()
+
+var (
+	requestDuration = registry.Histogram("redirect_request_duration_seconds",
+		"Time handling requests, other than health checks.", metrics.DefaultLatencyBounds)
+	lookupDuration = registry.Histogram("redirect_lookup_duration_seconds",
+		"Time looking up hosts' configuration, by the source that answered.", metrics.DefaultLatencyBounds, "source")
+)
+
+// measureRequests records how long each request, and its host's lookup,
+// took.
+func measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		info := redirect.LookupInfoFrom(r.Context())
+		if info == nil {
+			info = new(redirect.LookupInfo)
+			r = r.WithContext(redirect.WithLookupInfo(r.Context(), info))
+		}
+		next.ServeHTTP(w, r)
+		requestDuration.Observe(time.Since(start).Seconds())
+		if info.Duration > 0 {
+			lookupDuration.Observe(info.Duration.Seconds(), info.Source)
+			classifiedRequests.Inc(clientClass(info.Bot))
+		}
+	})
+}
+
+// newStatsd returns a Statsd pushing the registry to statsd_addr, or nil if
+// it is unset.
+func newStatsd(cfg *config) (*metrics.Statsd, error) {
+	if cfg.StatsdAddr == "" {
+		return nil, nil
+	}
+	s, err := metrics.NewStatsd(registry, cfg.StatsdAddr, cfg.StatsdPrefix, cfg.StatsdFormat == "dogstatsd")
+	if err != nil {
+		return nil, fmt.Errorf("statsd_addr: %w", err)
+	}
+	return s, nil
+}
+
+// registerSourceMetrics publishes the per-source lookup counts of s.
+func registerSourceMetrics(reg *metrics.Registry, s *sources) {
+	reg.CounterFunc("redirect_source_lookups_total",
+		"Config lookups by source and outcome (answered, not_found, error).",
+		[]string{"source", "result"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, stats := range s.layers.Stats() {
+				samples = append(samples,
+					metrics.Sample{Labels: []string{stats.Name, "answered"}, Value: float64(stats.Answered)},
+					metrics.Sample{Labels: []string{stats.Name, "not_found"}, Value: float64(stats.NotFound)},
+					metrics.Sample{Labels: []string{stats.Name, "error"}, Value: float64(stats.Errors)},
+				)
+			}
+			return samples
+		})
+	if s.cache != nil {
+		reg.GaugeFunc("redirect_cache_entries", "Hosts held in the DNS config cache.", nil,
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(s.cache.Len())}}
+			})
+	}
+	if s.lookups != nil {
+		reg.GaugeFunc("redirect_dns_lookups_in_flight", "DNS lookups in progress, at most max_dns_lookups.", nil,
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(s.lookups.InFlight())}}
+			})
+		reg.CounterFunc("redirect_dns_lookups_refused_total", "DNS lookups refused past max_dns_lookups, their requests answered 503.", nil,
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(s.lookups.Refused())}}
+			})
+	}
+	if s.shared != nil {
+		reg.CounterFunc("redirect_shared_cache_lookups_total", "Lookups in shared_cache by result (hit, miss, error).", []string{"result"},
+			func() []metrics.Sample {
+				return []metrics.Sample{
+					{Labels: []string{"hit"}, Value: float64(s.shared.hits.Load())},
+					{Labels: []string{"miss"}, Value: float64(s.shared.misses.Load())},
+					{Labels: []string{"error"}, Value: float64(s.shared.errors.Load())},
+				}
+			})
+	}
+}
+
+// newAdminMux returns the mux for the admin listener, which is meant to be
+// bound to loopback or a private network only. Every endpoint is for
+// callers with its scope, /metrics and /version being read's, if
+// admin_token, admin_auth_file or admin_client_ca say who they are.
+func newAdminMux(cfg *config, quota *hostQuota) *http.ServeMux {
+	mux := http.NewServeMux()
+	auth := newAdminAuth(cfg)
+	mux.Handle("/metrics", auth.require(scopeRead, registry.Handler()))
+	mux.Handle("/version", auth.require(scopeRead, http.HandlerFunc(versionHandler)))
+	if topHosts != nil {
+		mux.Handle("GET /top", auth.require(scopeRead, http.HandlerFunc(topHosts.handleTop)))
+	}
+	if clicks != nil {
+		mux.Handle("GET /analytics/export", auth.require(scopeRead, handleClickExport(clicks)))
+	}
+	if maintenance != nil {
+		mux.Handle("GET /maintenance", auth.require(scopeRead, http.HandlerFunc(maintenance.handleStatus)))
+		mux.Handle("PUT /maintenance", auth.require(scopeMaintenance, http.HandlerFunc(maintenance.handleStart)))
+		mux.Handle("DELETE /maintenance", auth.require(scopeMaintenance, http.HandlerFunc(maintenance.handleStop)))
+	}
+	if configSources != nil {
+		mux.Handle("DELETE /cache/{host}", auth.require(scopeCache, http.HandlerFunc(configSources.handlePurge)))
+	}
+	if quota != nil {
+		mux.Handle("GET /suspensions", auth.require(scopeRead, http.HandlerFunc(quota.handleSuspensions)))
+		mux.Handle("DELETE /suspensions/{host}", auth.require(scopeSuspensions, http.HandlerFunc(quota.handleLift)))
+	}
+	if abuseReports != nil {
+		mux.Handle("GET /reports", auth.require(scopeRead, http.HandlerFunc(abuseReports.handleList)))
+		mux.Handle("PUT /reports/{host}/{destination}/warning", auth.require(scopeReports, abuseReports.handleState(reportWarning)))
+		mux.Handle("PUT /reports/{host}/{destination}/takedown", auth.require(scopeReports, abuseReports.handleState(reportTakenDown)))
+		mux.Handle("DELETE /reports/{host}/{destination}", auth.require(scopeReports, http.HandlerFunc(abuseReports.handleDismiss)))
+	}
+	if certs != nil {
+		mux.Handle("GET /certificates", auth.require(scopeRead, http.HandlerFunc(certs.handleList)))
+		mux.Handle("GET /certificates/errors", auth.require(scopeRead, http.HandlerFunc(handleCertErrors)))
+		mux.Handle("POST /certificates/{host}/renew", auth.require(scopeCertificates, http.HandlerFunc(certs.handleRenew)))
+		mux.Handle("DELETE /certificates/{host}", auth.require(scopeCertificates, http.HandlerFunc(certs.handleDelete)))
+		mux.Handle("DELETE /certificates/{host}/policy", auth.require(scopeCertificates, http.HandlerFunc(certs.handlePurgePolicy)))
+	}
+	return mux
+}
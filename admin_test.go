@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/metrics"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestSourceMetrics(t *testing.T) {
+	cache := redirect.NewCache(redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}, time.Minute)
+	s := &sources{
+		layers: redirect.NewLayers(redirect.Source{Name: "dns", Resolver: cache}),
+		cache:  cache,
+	}
+	reg := metrics.NewRegistry()
+	registerSourceMetrics(reg, s)
+
+	s.layers.LookupConfig(context.Background(), "go.example.com")
+	s.layers.LookupConfig(context.Background(), "missing.example.com")
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	for _, want := range []string{
+		`redirect_source_lookups_total{source="dns",result="answered"} 1`,
+		`redirect_source_lookups_total{source="dns",result="not_found"} 1`,
+		`redirect_cache_entries 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestMeasureRequests(t *testing.T) {
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.NewLayers(redirect.Source{Name: "file", Resolver: redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}})
+	count := func(name string) uint64 {
+		var n uint64
+		for _, f := range registry.Snapshot() {
+			for _, s := range f.Samples {
+				if f.Name == name {
+					n += s.Count
+				}
+			}
+		}
+		return n
+	}
+	requests, lookups := count("redirect_request_duration_seconds"), count("redirect_lookup_duration_seconds")
+
+	h := newMux(defaultConfig(), nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	if n := count("redirect_request_duration_seconds") - requests; n != 1 {
+		t.Errorf("want 1 request timed (not the health check), got %d", n)
+	}
+	if n := count("redirect_lookup_duration_seconds") - lookups; n != 1 {
+		t.Errorf("want 1 lookup timed, got %d", n)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newAdminMux(defaultConfig(), nil).ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	var info buildInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil || info.GoVersion == "" || info.Version == "" {
+		t.Errorf("want build info JSON, got %d %q (%v)", rr.Code, rr.Body, err)
+	}
+	if s := info.String(); !strings.Contains(s, info.GoVersion) {
+		t.Errorf("String() = %q", s)
+	}
+
+	origResolver := resolver
+	t.Cleanup(func() { resolver = origResolver })
+	resolver = redirect.StaticResolver{}
+	rr = httptest.NewRecorder()
+	newMux(defaultConfig(), nil).ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	if strings.Contains(rr.Body.String(), info.GoVersion) {
+		t.Errorf("want /version kept off the public listener, got %d %q", rr.Code, rr.Body)
+	}
+}
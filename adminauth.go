@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes of the admin APIs' endpoints. Reading anything but the debug
+// listener is scopeRead; each kind of change has its own.
+const (
+	scopeAll          = "*"
+	scopeRead         = "read"
+	scopeCache        = "cache"
+	scopeSuspensions  = "suspensions"
+	scopeCertificates = "certificates"
+	scopeMaintenance  = "maintenance"
+	scopeReports      = "reports"
+	scopeDebug        = "debug"
+)
+
+var adminScopes = []string{scopeAll, scopeRead, scopeCache, scopeSuspensions, scopeCertificates, scopeMaintenance, scopeReports, scopeDebug}
+
+// adminCredentials are the callers admin_auth_file names. They're nil
+// unless it is set.
+var adminCredentials []adminCredential
+
+// An adminCredential is a caller of the admin APIs, known by a bearer
+// token or by the name in a client certificate, and the scopes it may use.
+type adminCredential struct {
+	Name       string   `yaml:"name"`
+	Token      string   `yaml:"token"`
+	ClientCert string   `yaml:"client_cert"` // a common or DNS name
+	Scopes     []string `yaml:"scopes"`
+}
+
+// loadAdminCredentials reads admin_auth_file: a YAML list of credentials.
+func loadAdminCredentials(path string) ([]adminCredential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("admin_auth_file: %w", err)
+	}
+	var list []adminCredential
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&list); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("admin_auth_file %s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for _, c := range list {
+		switch {
+		case c.Name == "" || names[c.Name]:
+			return nil, fmt.Errorf("admin_auth_file %s: each credential needs a name of its own", path)
+		case (c.Token == "") == (c.ClientCert == ""):
+			return nil, fmt.Errorf("admin_auth_file %s: %s needs one of token and client_cert", path, c.Name)
+		case len(c.Scopes) == 0:
+			return nil, fmt.Errorf("admin_auth_file %s: %s has no scopes", path, c.Name)
+		}
+		for _, scope := range c.Scopes {
+			if !slices.Contains(adminScopes, scope) {
+				return nil, fmt.Errorf("admin_auth_file %s: %s: unknown scope %q; known scopes are %s", path, c.Name, scope, strings.Join(adminScopes, ", "))
+			}
+		}
+		names[c.Name] = true
+	}
+	if list == nil {
+		list = []adminCredential{}
+	}
+	return list, nil
+}
+
+// adminAuth decides who the callers of an admin API are, and whether they
+// may use an endpoint's scope. The admin_token, if set, may use them all,
+// as may any client certificate the listener verified unless
+// admin_auth_file names the ones that may. Without any of those, the
+// API is open to whoever can reach it, which validate keeps to loopback.
+type adminAuth struct {
+	token       string
+	credentials []adminCredential
+	clientCerts bool // the listener verifies client certificates
+}
+
+// newAdminAuth returns the auth of the admin listener.
+func newAdminAuth(cfg *config) *adminAuth {
+	return &adminAuth{token: cfg.AdminToken, credentials: adminCredentials, clientCerts: cfg.AdminClientCA != ""}
+}
+
+// authorize returns who r comes from and 0, or else 401 if they aren't
+// known or 403 if they may not use scope.
+func (a *adminAuth) authorize(r *http.Request, scope string) (string, int) {
+	if a.token == "" && a.credentials == nil && !a.clientCerts {
+		return "anonymous", 0
+	}
+	who, scopes := a.identify(r)
+	switch {
+	case who == "":
+		return "", http.StatusUnauthorized
+	case slices.Contains(scopes, scopeAll), slices.Contains(scopes, scope):
+		return who, 0
+	}
+	return who, http.StatusForbidden
+}
+
+// identify returns the name and scopes of the credential r presents, or ""
+// if it presents none known.
+func (a *adminAuth) identify(r *http.Request) (string, []string) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return "admin_token", []string{scopeAll}
+		}
+		for _, c := range a.credentials {
+			if c.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+				return c.Name, c.Scopes
+			}
+		}
+		return "", nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	if a.credentials == nil {
+		return "certificate " + leaf.Subject.CommonName, []string{scopeAll}
+	}
+	for _, c := range a.credentials {
+		if c.ClientCert != "" && (strings.EqualFold(c.ClientCert, leaf.Subject.CommonName) || slices.ContainsFunc(leaf.DNSNames, func(name string) bool { return strings.EqualFold(c.ClientCert, name) })) {
+			return c.Name, c.Scopes
+		}
+	}
+	return "", nil
+}
+
+// require returns next, refusing callers who may not use scope, and audits
+// what's done through it.
+func (a *adminAuth) require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, status := a.authorize(r, scope)
+		switch status {
+		case http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case http.StatusForbidden:
+			http.Error(w, fmt.Sprintf("Forbidden: %s may not use the %s scope", who, scope), http.StatusForbidden)
+		}
+		if status != 0 {
+			audit(r, who, r.Method+" "+r.URL.Path, strconv.Itoa(status))
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		audit(r, who, r.Method+" "+r.URL.Path, strconv.Itoa(rec.status))
+	})
+}
+
+// audit logs action, done or refused as result says, so the log records
+// who changed what: every change made through an admin API and every
+// refused call.
+func audit(r *http.Request, who, action, result string) {
+	if who == "" {
+		who = "unknown caller"
+	}
+	from, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		from = r.RemoteAddr
+	}
+	log.Printf("Admin audit: %s by %s from %s: %s", action, who, from, result)
+}
+
+// loadServerTLS returns the config of an admin listener serving the
+// certificate in certFile and keyFile. If clientCA is set, client
+// certificates signed by its CAs are verified, with auth saying whether
+// clients must present one.
+func loadServerTLS(certFile, keyFile, clientCA string, auth tls.ClientAuthType) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		data, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", clientCA)
+		}
+		config.ClientCAs, config.ClientAuth = pool, auth
+	}
+	return config, nil
+}
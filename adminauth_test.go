@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAdminCredentials(t *testing.T) {
+	dir := t.TempDir()
+	load := func(yaml string) ([]adminCredential, error) {
+		path := filepath.Join(dir, "auth.yaml")
+		os.WriteFile(path, []byte(yaml), 0o600)
+		return loadAdminCredentials(path)
+	}
+	list, err := load(`
+- name: deploy
+  token: t0ken
+  scopes: [maintenance, cache]
+- name: fleet
+  client_cert: fleet.ops.internal
+  scopes: ["*"]
+`)
+	if err != nil || len(list) != 2 || list[1].ClientCert != "fleet.ops.internal" {
+		t.Fatalf("got %+v, %v", list, err)
+	}
+	for yaml, want := range map[string]string{
+		"- name: a\n  token: x\n  scopes: [everything]\n":                                    `unknown scope "everything"`,
+		"- name: a\n  token: x\n":                                                            "no scopes",
+		"- name: a\n  token: x\n  client_cert: a\n  scopes: [read]\n":                        "one of token and client_cert",
+		"- name: a\n  token: x\n  scopes: [read]\n- name: a\n  token: y\n  scopes: [read]\n": "name of its own",
+		"- name: a\n  tokens: x\n  scopes: [read]\n":                                         "not found",
+		"- token: x\n  scopes: [read]\n":                                                     "name of its own",
+	} {
+		if _, err := load(yaml); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", yaml, err, want)
+		}
+	}
+	if list, err := load(""); err != nil || list == nil {
+		t.Errorf("empty file: %v, %v", list, err)
+	}
+}
+
+func TestAdminScopes(t *testing.T) {
+	origCreds, origSources, origMaintenance := adminCredentials, configSources, maintenance
+	t.Cleanup(func() { adminCredentials, configSources, maintenance = origCreds, origSources, origMaintenance })
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	adminCredentials = []adminCredential{
+		{Name: "deploy", Token: "deploy-token", Scopes: []string{scopeCache}},
+		{Name: "fleet", ClientCert: "fleet.ops.internal", Scopes: []string{scopeRead, scopeMaintenance}},
+	}
+	configSources = mustSources(t, map[string]string{"SOURCES": "dns"})
+	cfg := defaultConfig()
+	cfg.AdminToken = "secret"
+	maintenance = newMaintenanceMode(cfg)
+	admin := newAdminMux(cfg, nil)
+	do := func(method, path, token string, cert *x509.Certificate) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	fleet := &x509.Certificate{Subject: pkix.Name{CommonName: "fleet"}, DNSNames: []string{"Fleet.Ops.Internal"}}
+	stranger := &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}
+
+	for _, tc := range []struct {
+		method, path, token string
+		cert                *x509.Certificate
+		want                int
+	}{
+		{"DELETE", "/cache/go.example.com", "deploy-token", nil, http.StatusNoContent},
+		{"GET", "/maintenance", "deploy-token", nil, http.StatusForbidden},
+		{"PUT", "/maintenance", "", fleet, http.StatusOK},
+		{"DELETE", "/maintenance", "", fleet, http.StatusNoContent},
+		{"DELETE", "/cache/go.example.com", "", fleet, http.StatusForbidden},
+		{"GET", "/maintenance", "", stranger, http.StatusUnauthorized},
+		{"GET", "/maintenance", "wrong", nil, http.StatusUnauthorized},
+		{"DELETE", "/cache/go.example.com", "secret", nil, http.StatusNoContent},
+		{"GET", "/metrics", "", nil, http.StatusUnauthorized},
+		{"GET", "/version", "", nil, http.StatusUnauthorized},
+		{"GET", "/metrics", "", fleet, http.StatusOK},
+		{"GET", "/version", "deploy-token", nil, http.StatusForbidden},
+	} {
+		if got := do(tc.method, tc.path, tc.token, tc.cert); got != tc.want {
+			t.Errorf("%s %s with %q: %d, want %d", tc.method, tc.path, tc.token, got, tc.want)
+		}
+	}
+	for _, want := range []string{
+		"Admin audit: DELETE /cache/go.example.com by deploy from 192.0.2.1: 204",
+		"Admin audit: GET /maintenance by deploy from 192.0.2.1: 403",
+		"Admin audit: PUT /maintenance by fleet from 192.0.2.1: 200",
+		"Admin audit: GET /maintenance by unknown caller from 192.0.2.1: 401",
+		"Admin audit: DELETE /cache/go.example.com by admin_token from 192.0.2.1: 204",
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("audit log lacks %q:\n%s", want, logged.String())
+		}
+	}
+	if strings.Contains(logged.String(), "GET /maintenance by fleet") {
+		t.Error("reads audited")
+	}
+
+	// Without credentials, tokens or client certificates, it's open as it
+	// always was; the debug listener too.
+	adminCredentials = nil
+	open := &adminAuth{}
+	if who, status := open.authorize(httptest.NewRequest("GET", "/debug/vars", nil), scopeDebug); status != 0 || who != "anonymous" {
+		t.Errorf("open: %q, %d", who, status)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/clickstore"
+	"github.com/frolic/redirect.name/internal/topk"
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/sync/singleflight"
+)
+
+// topHosts counts redirects per host and rule for the admin API's /top. It
+// is nil when analytics_max_hosts is 0.
+var topHosts *hostTraffic
+
+// hostTraffic tracks the hosts, and the rules within them, that answer the
+// most redirects, in memory bounded by analytics_max_hosts.
+type hostTraffic struct {
+	hosts *topk.Counter
+	rules *topk.Counter
+	since time.Time
+}
+
+func newHostTraffic(cfg *config) *hostTraffic {
+	if cfg.AnalyticsMaxHosts == 0 {
+		return nil
+	}
+	return &hostTraffic{
+		hosts: topk.New(cfg.AnalyticsMaxHosts),
+		rules: topk.New(cfg.AnalyticsMaxHosts),
+		since: time.Now(),
+	}
+}
+
+// countRedirects counts each request that matched a rule against its host
+// and the rule. Requests from bots are counted only if bots is set.
+func countRedirects(t *hostTraffic, bots bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := redirect.LookupInfoFrom(r.Context())
+		if info == nil {
+			info = new(redirect.LookupInfo)
+			r = r.WithContext(redirect.WithLookupInfo(r.Context(), info))
+		}
+		next.ServeHTTP(w, r)
+		if info.Rule == nil || (info.Bot && !bots) {
+			return
+		}
+		host, err := redirect.ParseHost(r.Host)
+		if err != nil {
+			return
+		}
+		t.hosts.Inc(host)
+		t.rules.Inc(host + " " + info.Rule.String())
+	})
+}
+
+type hostCount struct {
+	Host     string `json:"host"`
+	Rule     string `json:"rule,omitempty"`
+	Requests uint64 `json:"requests"`
+	// MaxOvercount bounds how much Requests may overstate the true count,
+	// once more hosts have been seen than are tracked.
+	MaxOvercount uint64 `json:"max_overcount,omitempty"`
+}
+
+// handleTop lists the ?n= (default 10) hosts and rules that answered the
+// most redirects, as JSON.
+func (t *hostTraffic) handleTop(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	report := struct {
+		Since time.Time   `json:"since"`
+		Hosts []hostCount `json:"hosts"`
+		Rules []hostCount `json:"rules"`
+	}{Since: t.since.UTC()}
+	report.Hosts, report.Rules = t.top(n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// top returns the n hosts and rules that answered the most redirects.
+func (t *hostTraffic) top(n int) (hosts, rules []hostCount) {
+	hosts, rules = []hostCount{}, []hostCount{}
+	for _, e := range t.hosts.Top(n) {
+		hosts = append(hosts, hostCount{Host: e.Key, Requests: e.Count, MaxOvercount: e.Error})
+	}
+	for _, e := range t.rules.Top(n) {
+		host, rule, _ := strings.Cut(e.Key, " ")
+		rules = append(rules, hostCount{Host: host, Rule: rule, Requests: e.Count, MaxOvercount: e.Error})
+	}
+	return hosts, rules
+}
+
+// clicks records hourly request counts per host, path and status under
+// analytics_dir for the admin API's /analytics/export. It is nil when that
+// is unset.
+var clicks *clickstore.Store
+
+// clickFlushInterval is how often counts are written to analytics_dir;
+// counts not yet written are lost if the process dies.
+const clickFlushInterval = time.Minute
+
+func newClickStore(cfg *config) (*clickstore.Store, error) {
+	if cfg.AnalyticsDir == "" {
+		return nil, nil
+	}
+	return clickstore.Open(cfg.AnalyticsDir, cfg.AnalyticsRetention)
+}
+
+// runClickStore writes counts to disk and removes expired days every
+// clickFlushInterval until ctx is done.
+func runClickStore(ctx context.Context, s *clickstore.Store) {
+	if err := s.Prune(time.Now()); err != nil {
+		log.Printf("Pruning analytics: %v", err)
+	}
+	tick := time.NewTicker(clickFlushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("Writing analytics: %v", err)
+			}
+			if err := s.Prune(time.Now()); err != nil {
+				log.Printf("Pruning analytics: %v", err)
+			}
+		}
+	}
+}
+
+// recordClicks counts each request but health checks against its host,
+// path and response status. Requests from bots are counted only if bots is
+// set.
+func recordClicks(s *clickstore.Store, bots bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		info := redirect.LookupInfoFrom(r.Context())
+		if info == nil {
+			info = new(redirect.LookupInfo)
+			r = r.WithContext(redirect.WithLookupInfo(r.Context(), info))
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if info.Bot && !bots {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		host, err := redirect.ParseHost(r.Host)
+		if err != nil {
+			return
+		}
+		s.Add(time.Now(), host, r.URL.Path, rec.status)
+	})
+}
+
+// handleClickExport writes the hourly counts from ?from= up to ?to= (dates
+// or RFC 3339 times; the last 7 days by default), for ?host= if given, as
+// JSON Lines or, with ?format=csv, CSV.
+func handleClickExport(s *clickstore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		now := time.Now().UTC()
+		from, err := parseExportTime(q.Get("from"), now.Add(-7*24*time.Hour))
+		if err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseExportTime(q.Get("to"), now.Add(time.Hour))
+		if err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var write func(clickstore.Count) error
+		var flush func()
+		switch q.Get("format") {
+		case "", "jsonl":
+			w.Header().Set("Content-Type", "application/jsonl")
+			enc := json.NewEncoder(w)
+			write = func(c clickstore.Count) error { return enc.Encode(c) }
+			flush = func() {}
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"hour", "host", "path", "status", "count"})
+			write = func(c clickstore.Count) error {
+				return cw.Write([]string{c.Hour.Format(time.RFC3339), c.Host, c.Path, strconv.Itoa(c.Status), strconv.FormatUint(c.Count, 10)})
+			}
+			flush = cw.Flush
+		default:
+			http.Error(w, "format must be jsonl or csv", http.StatusBadRequest)
+			return
+		}
+		if err := s.Query(from, to, q.Get("host"), write); err != nil {
+			log.Printf("Exporting analytics: %v", err)
+		}
+		flush()
+	}
+}
+
+// parseExportTime parses v as a date or an RFC 3339 time, or returns def if
+// v is empty.
+func parseExportTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// statsPath is where hosts flagged stats=public serve their redirect counts.
+const statsPath = "/_redirect/stats"
+
+// statsDays and statsTopPaths bound the public stats of a host.
+const (
+	statsDays     = 30
+	statsTopPaths = 20
+)
+
+// statsCacheTTL is how long a host's public stats are served from memory.
+// Only today's counts are read again then: the earlier days' are summed
+// once a day, keeping at most statsPastPaths paths.
+const (
+	statsCacheTTL  = 5 * time.Minute
+	statsPastPaths = 1000
+)
+
+// publicStats answers GET /_redirect/stats for hosts whose records include
+// the stats=public flag, from the counts in clicks. Requests for a host
+// whose stats are being computed wait for them.
+type publicStats struct {
+	store  *clickstore.Store
+	flight singleflight.Group
+
+	mu    sync.Mutex
+	cache map[string]cachedStats
+}
+
+type cachedStats struct {
+	body    []byte
+	expires time.Time
+	past    *statsCounts
+}
+
+// statsCounts sums a host's redirects per day and path. Those of the days
+// before today are kept, with today the day they're before.
+type statsCounts struct {
+	today   time.Time
+	perDay  map[string]uint64
+	perPath map[string]uint64
+}
+
+// hostStats is the public stats of a host: its redirects (3xx responses)
+// over the last statsDays days, per day and for its busiest paths.
+type hostStats struct {
+	Host      string      `json:"host"`
+	Since     string      `json:"since"`
+	Redirects uint64      `json:"redirects"`
+	Days      []dayCount  `json:"days"`
+	Paths     []pathCount `json:"paths"`
+}
+
+type dayCount struct {
+	Date      string `json:"date"`
+	Redirects uint64 `json:"redirects"`
+}
+
+type pathCount struct {
+	Path      string `json:"path"`
+	Redirects uint64 `json:"redirects"`
+}
+
+// serveStats serves statsPath for hosts that opted in and passes every
+// other request, including statsPath on other hosts, to next.
+func serveStats(s *clickstore.Store, next http.Handler) http.Handler {
+	p := &publicStats{store: s, cache: make(map[string]cachedStats)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != statsPath || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, err := redirect.ParseHost(r.Host)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rules, err := resolver.LookupConfig(r.Context(), host)
+		if err != nil || !redirect.HasFlag(rules, redirect.FlagPublicStats) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := p.stats(host)
+		if err != nil {
+			log.Printf("Computing stats for %s: %v", host, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(statsCacheTTL.Seconds())))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(body)
+	})
+}
+
+// stats returns host's stats as JSON, computing them at most once per
+// statsCacheTTL.
+func (p *publicStats) stats(host string) ([]byte, error) {
+	p.mu.Lock()
+	cached, ok := p.cache[host]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.body, nil
+	}
+	body, err, _ := p.flight.Do(host, func() (any, error) {
+		return p.compute(host, cached.past)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body.([]byte), nil
+}
+
+// compute reads host's stats, with the earlier days' from past if they're
+// still up to today, and caches them.
+func (p *publicStats) compute(host string, past *statsCounts) ([]byte, error) {
+	now := time.Now()
+	today := now.UTC().Truncate(24 * time.Hour)
+	from := today.Add(-(statsDays - 1) * 24 * time.Hour)
+	if past == nil || !past.today.Equal(today) {
+		past = &statsCounts{today: today, perDay: make(map[string]uint64), perPath: make(map[string]uint64)}
+		if err := p.store.Query(from, today, host, past.add); err != nil {
+			return nil, err
+		}
+		past.trim()
+	}
+	current := &statsCounts{today: today, perDay: maps.Clone(past.perDay), perPath: maps.Clone(past.perPath)}
+	if err := p.store.Query(today, now, host, current.add); err != nil {
+		return nil, err
+	}
+
+	stats := hostStats{Host: host, Since: from.Format(time.DateOnly), Days: []dayCount{}, Paths: []pathCount{}}
+	for day := from; !day.After(today); day = day.Add(24 * time.Hour) {
+		date := day.Format(time.DateOnly)
+		stats.Days = append(stats.Days, dayCount{date, current.perDay[date]})
+		stats.Redirects += current.perDay[date]
+	}
+	stats.Paths = topPaths(current.perPath)
+	if len(stats.Paths) > statsTopPaths {
+		stats.Paths = stats.Paths[:statsTopPaths]
+	}
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) > 1000 {
+		clear(p.cache)
+	}
+	p.cache[host] = cachedStats{body: body, expires: now.Add(statsCacheTTL), past: past}
+	return body, nil
+}
+
+// add counts c if it's a redirect.
+func (s *statsCounts) add(c clickstore.Count) error {
+	if c.Status/100 == 3 {
+		s.perDay[c.Hour.Format(time.DateOnly)] += c.Count
+		s.perPath[c.Path] += c.Count
+	}
+	return nil
+}
+
+// trim keeps the statsPastPaths busiest paths.
+func (s *statsCounts) trim() {
+	if len(s.perPath) <= statsPastPaths {
+		return
+	}
+	for _, p := range topPaths(s.perPath)[statsPastPaths:] {
+		delete(s.perPath, p.Path)
+	}
+}
+
+// topPaths returns the paths in perPath, busiest first.
+func topPaths(perPath map[string]uint64) []pathCount {
+	paths := []pathCount{}
+	for path, n := range perPath {
+		paths = append(paths, pathCount{path, n})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := paths[i], paths[j]
+		if a.Redirects != b.Redirects {
+			return a.Redirects > b.Redirects
+		}
+		return a.Path < b.Path
+	})
+	return paths
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/clickstore"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestTopHosts(t *testing.T) {
+	orig, origTop := resolver, topHosts
+	t.Cleanup(func() { resolver, topHosts = orig, origTop })
+	resolver = redirect.StaticResolver{
+		"go.example.com":    {"Redirects from /docs/* to https://docs.example.com/*", "Redirects to https://example.com/"},
+		"other.example.com": {"Redirects to https://other.example/"},
+	}
+	cfg := defaultConfig()
+	topHosts = newHostTraffic(cfg)
+	h := newMux(cfg, nil)
+	for _, url := range []string{
+		"go.example.com/docs/a",
+		"go.example.com/docs/b",
+		"go.example.com/",
+		"other.example.com/",
+		"missing.example.com/",
+	} {
+		host, path, _ := strings.Cut(url, "/")
+		req := httptest.NewRequest("GET", "/"+path, nil)
+		req.Host = host
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	newAdminMux(cfg, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/top?n=2", nil))
+	var got struct {
+		Hosts []hostCount
+		Rules []hostCount
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, rr.Body)
+	}
+	if len(got.Hosts) != 2 || got.Hosts[0] != (hostCount{Host: "go.example.com", Requests: 3}) || got.Hosts[1] != (hostCount{Host: "other.example.com", Requests: 1}) {
+		t.Errorf("hosts: got %+v", got.Hosts)
+	}
+	want := hostCount{Host: "go.example.com", Rule: "Redirects from /docs/* to https://docs.example.com/*", Requests: 2}
+	if len(got.Rules) != 2 || got.Rules[0] != want {
+		t.Errorf("rules: got %+v", got.Rules)
+	}
+
+	rr = httptest.NewRecorder()
+	newAdminMux(cfg, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/top?n=zero", nil))
+	if rr.Code != 400 {
+		t.Errorf("bad n: want 400, got %d", rr.Code)
+	}
+}
+
+func TestClickExport(t *testing.T) {
+	orig, origClicks := resolver, clicks
+	t.Cleanup(func() { resolver, clicks = orig, origClicks })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}
+	cfg := defaultConfig()
+	cfg.AnalyticsDir = t.TempDir()
+	var err error
+	if clicks, err = newClickStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := newMux(cfg, nil)
+	for _, path := range []string{"/a", "/a", "/b", "/healthz"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "go.example.com"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := clicks.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	newAdminMux(cfg, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/analytics/export?format=csv&host=go.example.com", nil))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "hour,host,path,status,count" || !strings.HasSuffix(lines[1], ",go.example.com,/a,302,2") || !strings.HasSuffix(lines[2], ",go.example.com,/b,302,1") {
+		t.Errorf("got %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	newAdminMux(cfg, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/analytics/export", nil))
+	var first map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&first); err != nil || first["path"] != "/a" || first["count"] != 2.0 {
+		t.Errorf("jsonl: got %v (%v)", first, err)
+	}
+
+	rr = httptest.NewRecorder()
+	newAdminMux(cfg, nil).ServeHTTP(rr, httptest.NewRequest("GET", "/analytics/export?from=yesterday", nil))
+	if rr.Code != 400 {
+		t.Errorf("bad from: want 400, got %d", rr.Code)
+	}
+}
+
+func TestPublicStats(t *testing.T) {
+	orig, origClicks := resolver, clicks
+	t.Cleanup(func() { resolver, clicks = orig, origClicks })
+	resolver = redirect.StaticResolver{
+		"go.example.com":      {"Redirects to https://example.com/", "stats=public"},
+		"private.example.com": {"Redirects to https://private.example/"},
+	}
+	cfg := defaultConfig()
+	cfg.AnalyticsDir = t.TempDir()
+	var err error
+	if clicks, err = newClickStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := newMux(cfg, nil)
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	for _, path := range []string{"/a", "/a", "/b"} {
+		get("go.example.com", path)
+		get("private.example.com", path)
+	}
+
+	rr := get("go.example.com", "/_redirect/stats")
+	var got hostStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, rr.Body)
+	}
+	if got.Host != "go.example.com" || got.Redirects != 3 || len(got.Days) != statsDays || got.Days[statsDays-1].Redirects != 3 {
+		t.Errorf("got %+v", got)
+	}
+	if len(got.Paths) != 2 || got.Paths[0] != (pathCount{"/a", 2}) {
+		t.Errorf("paths: got %+v", got.Paths)
+	}
+
+	if rr := get("private.example.com", "/_redirect/stats"); rr.Code != 302 {
+		t.Errorf("host without the flag: want its redirect, got %d", rr.Code)
+	}
+}
+
+func TestPublicStatsPastDays(t *testing.T) {
+	store, err := clickstore.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-24 * time.Hour)
+	store.Add(yesterday, "go.example.com", "/a", 302)
+	store.Add(time.Now(), "go.example.com", "/b", 302)
+	p := &publicStats{store: store, cache: make(map[string]cachedStats)}
+	read := func() hostStats {
+		t.Helper()
+		body, err := p.stats("go.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats hostStats
+		json.Unmarshal(body, &stats)
+		return stats
+	}
+	if got := read(); got.Redirects != 2 || got.Days[statsDays-2].Redirects != 1 {
+		t.Fatalf("got %+v", got)
+	}
+
+	// Once the cached stats expire, only today's counts are read again.
+	store.Add(yesterday, "go.example.com", "/a", 302)
+	store.Add(time.Now(), "go.example.com", "/b", 302)
+	p.mu.Lock()
+	cached := p.cache["go.example.com"]
+	cached.expires = time.Now()
+	p.cache["go.example.com"] = cached
+	p.mu.Unlock()
+	if got := read(); got.Redirects != 3 || got.Days[statsDays-2].Redirects != 1 || got.Days[statsDays-1].Redirects != 2 {
+		t.Errorf("after expiry: want yesterday's counts kept and today's read again, got %+v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ipAnonymizer rewrites client IPs before they are logged, per
+// client_ip_logging: "full" keeps them, "truncate" zeroes all but the
+// network (/24 for IPv4, /48 for IPv6), "hash" replaces them with a keyed
+// hash that can be correlated but not reversed, and "off" drops them.
+type ipAnonymizer struct {
+	mode string
+	key  []byte
+}
+
+// processHashKey is the hash key used when ip_hash_key is unset, so hashes
+// are consistent within a process but not across restarts.
+var processHashKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+func newIPAnonymizer(cfg *config) *ipAnonymizer {
+	a := &ipAnonymizer{mode: cfg.ClientIPLogging, key: []byte(cfg.IPHashKey)}
+	if a.mode == "hash" && len(a.key) == 0 {
+		a.key = processHashKey()
+	}
+	return a
+}
+
+// anonymize returns ip as it may be logged, or "" if it may not be.
+// Strings that aren't an IP address are returned as they are.
+func (a *ipAnonymizer) anonymize(ip string) string {
+	if a.mode == "full" {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return a.anonymizeAddr(addr)
+}
+
+func (a *ipAnonymizer) anonymizeAddr(addr netip.Addr) string {
+	addr = addr.Unmap()
+	switch a.mode {
+	case "truncate":
+		bits := 24
+		if addr.Is6() {
+			bits = 48
+		}
+		p, _ := addr.Prefix(bits)
+		return p.Addr().String()
+	case "hash":
+		mac := hmac.New(sha256.New, a.key)
+		b, _ := addr.MarshalBinary()
+		mac.Write(b)
+		return "ip-" + hex.EncodeToString(mac.Sum(nil)[:8])
+	case "off":
+		return ""
+	}
+	return addr.String()
+}
+
+// scrub anonymizes every IP address, with or without a port, in text. With
+// "off" they are replaced by "-".
+func (a *ipAnonymizer) scrub(text []byte) []byte {
+	if a.mode == "full" {
+		return text
+	}
+	var out []byte
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && isAddrByte(text[j]) {
+			j++
+		}
+		if j == i {
+			out = append(out, text[i])
+			i++
+			continue
+		}
+		out = append(out, a.scrubToken(string(text[i:j]))...)
+		i = j
+	}
+	return out
+}
+
+// scrubToken anonymizes token if it is an address or address:port, trying
+// again without trailing punctuation such as the colon in
+// "from 192.0.2.1:4321: EOF".
+func (a *ipAnonymizer) scrubToken(token string) string {
+	if s, ok := a.scrubAddr(token); ok {
+		return s
+	}
+	if trimmed := strings.TrimRight(token, ".:"); trimmed != token {
+		if s, ok := a.scrubAddr(trimmed); ok {
+			return s + token[len(trimmed):]
+		}
+	}
+	return token
+}
+
+func (a *ipAnonymizer) scrubAddr(s string) (string, bool) {
+	replace := func(addr netip.Addr) string {
+		if s := a.anonymizeAddr(addr); s != "" {
+			return s
+		}
+		return "-"
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return replace(addr), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		port := strconv.Itoa(int(ap.Port()))
+		if ap.Addr().Is6() {
+			return "[" + replace(ap.Addr()) + "]:" + port, true
+		}
+		return replace(ap.Addr()) + ":" + port, true
+	}
+	return "", false
+}
+
+func isAddrByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' || c == '.' || c == ':' || c == '[' || c == ']'
+}
+
+// scrubWriter anonymizes the IP addresses in everything written through it.
+type scrubWriter struct {
+	w io.Writer
+	a *ipAnonymizer
+}
+
+func (s scrubWriter) Write(p []byte) (int, error) {
+	if _, err := s.w.Write(s.a.scrub(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
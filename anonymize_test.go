@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestAnonymize(t *testing.T) {
+	for _, tt := range []struct {
+		mode, ip, want string
+	}{
+		{"full", "192.0.2.123", "192.0.2.123"},
+		{"truncate", "192.0.2.123", "192.0.2.0"},
+		{"truncate", "::ffff:192.0.2.123", "192.0.2.0"},
+		{"truncate", "2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"off", "192.0.2.123", ""},
+		{"truncate", "not an ip", "not an ip"},
+	} {
+		cfg := defaultConfig()
+		cfg.ClientIPLogging = tt.mode
+		if got := newIPAnonymizer(cfg).anonymize(tt.ip); got != tt.want {
+			t.Errorf("%s %s: want %q, got %q", tt.mode, tt.ip, tt.want, got)
+		}
+	}
+}
+
+func TestAnonymizeHash(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ClientIPLogging = "hash"
+	cfg.IPHashKey = "secret"
+	a := newIPAnonymizer(cfg)
+	h1, h2 := a.anonymize("192.0.2.1"), a.anonymize("192.0.2.2")
+	if !strings.HasPrefix(h1, "ip-") || len(h1) != len("ip-")+16 || strings.Contains(h1, "192") {
+		t.Errorf("want ip- and 16 hex digits, got %q", h1)
+	}
+	if h1 == h2 {
+		t.Error("different IPs hashed the same")
+	}
+	if again := newIPAnonymizer(cfg).anonymize("192.0.2.1"); again != h1 {
+		t.Errorf("same key: want %q, got %q", h1, again)
+	}
+	cfg.IPHashKey = "other"
+	if other := newIPAnonymizer(cfg).anonymize("192.0.2.1"); other == h1 {
+		t.Error("different keys hashed the same")
+	}
+}
+
+func TestScrub(t *testing.T) {
+	line := "http: TLS handshake error from 192.0.2.77:51234: EOF; also [2001:db8:1:2::9]:443 and ::1 at 12:30:00 build deadbeef\n"
+	for mode, want := range map[string]string{
+		"full":     line,
+		"truncate": "http: TLS handshake error from 192.0.2.0:51234: EOF; also [2001:db8:1::]:443 and :: at 12:30:00 build deadbeef\n",
+		"off":      "http: TLS handshake error from -:51234: EOF; also [-]:443 and - at 12:30:00 build deadbeef\n",
+	} {
+		cfg := defaultConfig()
+		cfg.ClientIPLogging = mode
+		var buf bytes.Buffer
+		w := scrubWriter{w: &buf, a: newIPAnonymizer(cfg)}
+		if n, err := w.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("%s: Write returned %d, %v", mode, n, err)
+		}
+		if buf.String() != want {
+			t.Errorf("%s:\nwant %q\ngot  %q", mode, want, buf.String())
+		}
+	}
+}
+
+func TestAccessLogClientIP(t *testing.T) {
+	var buf bytes.Buffer
+	origLog, origResolver := accessLog, resolver
+	t.Cleanup(func() { accessLog, resolver = origLog, origResolver })
+	accessLog = slog.New(slog.NewJSONHandler(&buf, nil))
+	resolver = redirect.StaticResolver{"example.com": {"Redirects to https://example.net/"}}
+
+	for mode, want := range map[string]any{"truncate": "192.0.2.0", "off": nil} {
+		buf.Reset()
+		cfg := defaultConfig()
+		cfg.ClientIPLogging = mode
+		newMux(cfg, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: %q: %v", mode, buf.String(), err)
+		}
+		if entry["client_ip"] != want {
+			t.Errorf("%s: want client_ip %v, got %v", mode, want, entry["client_ip"])
+		}
+	}
+}
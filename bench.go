@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// runBench runs `redirect-name bench` with args, such as
+//
+//	-host go.example.com -rps 2000 -duration 60s -target http://10.0.0.5
+//
+// sending requests for host to the instance at target at a steady rate,
+// then writing their latency percentiles, statuses and error rate to out,
+// for capacity planning. With -stub, the requests go instead to an
+// instance started in the process that answers host with the stub's
+// records, to measure the server without DNS.
+func runBench(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	host := fs.String("host", "", "hostname whose redirects to request")
+	target := fs.String("target", "http://localhost", "URL of the instance to load")
+	path := fs.String("path", "/", "path to request, with its query")
+	rps := fs.Float64("rps", 100, "requests per second to send")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send requests for")
+	concurrency := fs.Int("concurrency", 256, "requests in flight at most; those due past it are counted as missed")
+	timeout := fs.Duration("timeout", 5*time.Second, "how long a request may take before it counts as an error")
+	var stub []string
+	fs.Func("stub", "TXT record answering host in an instance started in the process, instead of loading -target; repeat for more", func(record string) error {
+		stub = append(stub, record)
+		return nil
+	})
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	h, err := redirect.ParseHost(*host)
+	if err != nil || h == "" {
+		return fmt.Errorf("-host must be a hostname, such as go.example.com")
+	}
+	if *rps <= 0 || *duration <= 0 || *concurrency < 1 || *timeout <= 0 {
+		return fmt.Errorf("-rps, -duration, -concurrency and -timeout must be positive")
+	}
+	if !strings.HasPrefix(*path, "/") {
+		return fmt.Errorf("-path must start with /")
+	}
+
+	base := strings.TrimSuffix(*target, "/")
+	if len(stub) > 0 {
+		addr, stop, err := startStub(h, stub)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		base = "http://" + addr
+	} else if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("-target must be an http or https URL, such as http://10.0.0.5")
+	}
+
+	b := &bench{
+		url:  base + *path,
+		host: h,
+		client: &http.Client{
+			Timeout: *timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: *concurrency,
+				// An instance with certificates for its hosts only is
+				// loaded by address; the Host header says which it is.
+				TLSClientConfig: &tls.Config{ServerName: h},
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+	fmt.Fprintf(out, "Sending %s requests a second for %s to %s for %s\n", strconv.FormatFloat(*rps, 'f', -1, 64), h, b.url, *duration)
+	r := b.run(ctx, *rps, *duration, *concurrency)
+	r.write(out)
+	return nil
+}
+
+// startStub serves host's redirects with records on a loopback port, as
+// the server would with defaults, returning its address and a func to
+// stop it.
+func startStub(host string, records []string) (string, func(), error) {
+	rules := redirect.ParseAll(records)
+	if len(rules) == 0 {
+		return "", nil, errors.New("-stub has no valid records")
+	}
+	resolver = redirect.ResolverFunc(func(ctx context.Context, h string) ([]*redirect.Rule, error) {
+		if h != host {
+			return nil, fmt.Errorf("%w for %s", redirect.ErrNotFound, h)
+		}
+		return rules, nil
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	cfg := defaultConfig()
+	srv := newPublicServer(cfg, newMux(cfg, nil), false)
+	go srv.Serve(ln)
+	return ln.Addr().String(), func() { srv.Close() }, nil
+}
+
+// bench sends the requests of `redirect-name bench`.
+type bench struct {
+	url    string
+	host   string
+	client *http.Client
+}
+
+// benchResult is what a run measured.
+type benchResult struct {
+	elapsed   time.Duration
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+	missed    int
+}
+
+// run sends rps requests a second for duration, each due at its time
+// however long those before it take, with at most concurrency in flight.
+// Requests falling due with none free are missed rather than queued, so
+// that a slow server can't slow the load down and hide its latency.
+func (b *bench) run(ctx context.Context, rps float64, duration time.Duration, concurrency int) *benchResult {
+	r := &benchResult{statuses: make(map[int]int), errors: make(map[string]int)}
+	var mu sync.Mutex
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	interval := time.Duration(float64(time.Second) / rps)
+	start := time.Now()
+	for due := start; due.Before(start.Add(duration)); due = due.Add(interval) {
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			mu.Lock()
+			r.missed++
+			mu.Unlock()
+			continue
+		}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			latency, status, err := b.send(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				r.errors[benchError(err)]++
+				return
+			}
+			r.latencies = append(r.latencies, latency)
+			r.statuses[status]++
+		})
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	return r
+}
+
+// send makes one request, reading its response through.
+func (b *bench) send(ctx context.Context) (time.Duration, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Host = b.host
+	req.Header.Set("User-Agent", "redirect-name bench")
+	begun := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return time.Since(begun), resp.StatusCode, err
+}
+
+// benchError names err's kind for the report, so a spike of timeouts
+// isn't a thousand lines with different addresses.
+func benchError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "connection refused"
+	case strings.Contains(err.Error(), "connection reset"):
+		return "connection reset"
+	}
+	return "other"
+}
+
+// write reports r: counts, statuses, errors (failed requests and 5xx
+// answers) and latency percentiles.
+func (r *benchResult) write(out io.Writer) {
+	sent := len(r.latencies)
+	failed := 0
+	for _, n := range r.errors {
+		sent += n
+		failed += n
+	}
+	for status, n := range r.statuses {
+		if status >= 500 {
+			failed += n
+		}
+	}
+	fmt.Fprintf(out, "Requests  %d in %s (%.1f/s), %d missed\n", sent, r.elapsed.Round(time.Millisecond), float64(sent)/r.elapsed.Seconds(), r.missed)
+	fmt.Fprintf(out, "Statuses  %s\n", countList(r.statuses))
+	rate := 0.0
+	if sent > 0 {
+		rate = 100 * float64(failed) / float64(sent)
+	}
+	fmt.Fprintf(out, "Errors    %d (%.2f%%)", failed, rate)
+	if len(r.errors) > 0 {
+		fmt.Fprintf(out, ": %s", countList(r.errors))
+	}
+	fmt.Fprintln(out)
+	if len(r.latencies) == 0 {
+		return
+	}
+	slices.Sort(r.latencies)
+	fmt.Fprint(out, "Latency  ")
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(out, " p%s %s", strconv.FormatFloat(p, 'f', -1, 64), roundLatency(percentile(r.latencies, p)))
+	}
+	fmt.Fprintf(out, " max %s\n", roundLatency(r.latencies[len(r.latencies)-1]))
+}
+
+// countList formats counts as "key: n, key: n", in key order.
+func countList[K int | string](counts map[K]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	var items []string
+	for _, k := range slices.Sorted(maps.Keys(counts)) {
+		items = append(items, fmt.Sprintf("%v: %d", k, counts[k]))
+	}
+	return strings.Join(items, ", ")
+}
+
+// percentile returns the pth percentile of sorted, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// roundLatency rounds d to three significant figures or so.
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
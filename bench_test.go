@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBench(t *testing.T) {
+	var requests, wrongHost atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "go.example.com" || r.URL.RequestURI() != "/docs?x=1" {
+			wrongHost.Add(1)
+		}
+		// Every fourth request fails.
+		if requests.Add(1)%4 == 0 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, "https://example.com/", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	err := runBench(context.Background(), []string{"-host", "go.example.com", "-target", ts.URL, "-path", "/docs?x=1", "-rps", "200", "-duration", "200ms"}, env(nil), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrongHost.Load() != 0 {
+		t.Errorf("%d requests weren't for go.example.com/docs?x=1", wrongHost.Load())
+	}
+	// The redirects weren't followed.
+	for _, want := range []string{"Requests  40 in ", "Statuses  302: 30, 503: 10", "Errors    10 (25.00%)", "Latency   p50 "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-rps", "100"},
+		{"-host", "go.example.com", "-rps", "0"},
+		{"-host", "go.example.com", "-target", "ftp://example.com"},
+		{"-host", "go.example.com", "-stub", "not a record"},
+	} {
+		if err := runBench(context.Background(), args, env(nil), &out); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestRunBenchStub(t *testing.T) {
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	var out bytes.Buffer
+	err := runBench(context.Background(), []string{"-host", "go.example.com", "-stub", "Redirects from /a/* to https://example.com/*", "-stub", "Redirects to https://example.com/", "-path", "/a/b", "-rps", "100", "-duration", "100ms"}, env(nil), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Statuses  302: 10\n") {
+		t.Errorf("got:\n%s", out.String())
+	}
+}
+
+func TestBenchResult(t *testing.T) {
+	r := &benchResult{elapsed: time.Second, statuses: map[int]int{301: 97}, errors: map[string]int{"timeout": 2, "connection refused": 1}, missed: 4}
+	for i := range 97 {
+		r.latencies = append(r.latencies, time.Duration(97-i)*time.Millisecond)
+	}
+	var out bytes.Buffer
+	r.write(&out)
+	want := `Requests  100 in 1s (100.0/s), 4 missed
+Statuses  301: 97
+Errors    3 (3.00%): connection refused: 1, timeout: 2
+Latency   p50 49ms p90 88ms p99 97ms p99.9 97ms max 97ms
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
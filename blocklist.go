@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// maxBlocklistSize bounds how much of a remote feed is read.
+const maxBlocklistSize = 32 << 20
+
+// blocklistSync keeps a redirect.Blocklist loaded from blocklist_file and
+// blocklist_url. When either can't be read, the last good copy of it is
+// kept.
+type blocklistSync struct {
+	list   *redirect.Blocklist
+	file   string
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	local  []string
+	remote []string
+	etag   string
+}
+
+// newBlocklist returns the blocklist configured by cfg, loaded once, or nil
+// if there is none. An unreadable file is an error; an unreachable URL is
+// only logged, so a feed outage can't stop the server from starting.
+func newBlocklist(cfg *config) (*blocklistSync, error) {
+	if cfg.BlocklistFile == "" && cfg.BlocklistURL == "" {
+		return nil, nil
+	}
+	s := &blocklistSync{
+		list:   &redirect.Blocklist{Status: cfg.BlocklistStatus},
+		file:   cfg.BlocklistFile,
+		url:    cfg.BlocklistURL,
+		client: &http.Client{Timeout: time.Minute},
+	}
+	if err := s.loadFile(); err != nil {
+		return nil, err
+	}
+	if err := s.fetch(context.Background()); err != nil {
+		log.Printf("Fetching blocklist: %v", err)
+	}
+	s.apply()
+	return s, nil
+}
+
+// refresh reloads both lists.
+func (s *blocklistSync) refresh(ctx context.Context) error {
+	fileErr := s.loadFile()
+	fetchErr := s.fetch(ctx)
+	s.apply()
+	if fileErr != nil {
+		return fileErr
+	}
+	return fetchErr
+}
+
+// run refreshes the lists every interval until ctx is done.
+func (s *blocklistSync) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.refresh(ctx); err != nil {
+				log.Printf("Refreshing blocklist: %v", err)
+			}
+		}
+	}
+}
+
+func (s *blocklistSync) loadFile() error {
+	if s.file == "" {
+		return nil
+	}
+	f, err := os.Open(s.file)
+	if err != nil {
+		return fmt.Errorf("reading blocklist: %w", err)
+	}
+	defer f.Close()
+	entries, err := redirect.ReadBlocklist(f)
+	if err != nil {
+		return fmt.Errorf("reading blocklist %s: %w", s.file, err)
+	}
+	s.mu.Lock()
+	s.local = entries
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *blocklistSync) fetch(ctx context.Context) error {
+	if s.url == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.Unlock()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	entries, err := redirect.ReadBlocklist(io.LimitReader(resp.Body, maxBlocklistSize))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.url, err)
+	}
+	s.mu.Lock()
+	s.remote = entries
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *blocklistSync) apply() {
+	s.mu.Lock()
+	entries := append(append([]string(nil), s.local...), s.remote...)
+	s.mu.Unlock()
+	s.list.Set(entries)
+}
+
+var blockedTotal = registry.Counter("redirect_blocked_total",
+	"Redirects refused by a target check, by check.", "check")
+
+// countBlocks returns c, counting the redirects it refuses as name.
+func countBlocks(name string, c redirect.TargetChecker) redirect.TargetChecker {
+	return redirect.TargetCheckerFunc(func(ctx context.Context, location string) error {
+		err := c.CheckTarget(ctx, location)
+		if err != nil {
+			blockedTotal.Inc(name)
+		}
+		return err
+	})
+}
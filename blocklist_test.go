@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlocklistSync(t *testing.T) {
+	feed, status := "phish.example\n", http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` && status == http.StatusOK {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(status)
+		w.Write([]byte(feed))
+	}))
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	os.WriteFile(path, []byte("# local additions\nhttps://files.example/malware/\n"), 0o644)
+
+	cfg := defaultConfig()
+	cfg.BlocklistFile = path
+	cfg.BlocklistURL = ts.URL
+	s, err := newBlocklist(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.list.Len(); n != 2 {
+		t.Fatalf("want file and feed entries, got %d", n)
+	}
+
+	// Unchanged feed, then a failing one: the last good copy is kept.
+	if err := s.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusInternalServerError
+	if err := s.refresh(context.Background()); err == nil {
+		t.Error("expected an error from the failing feed")
+	}
+	if n := s.list.Len(); n != 2 {
+		t.Errorf("feed outage: want 2 entries kept, got %d", n)
+	}
+
+	stubTXT(t, []string{"Redirects to https://login.phish.example/"}, nil)
+	h := newMux(cfg, nil, s.list)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusGone {
+		t.Errorf("blocked target: want 410, got %d", rr.Code)
+	}
+}
+
+func TestBlocklistMissingFile(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.BlocklistFile = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := newBlocklist(cfg); err == nil {
+		t.Error("expected an error for a missing blocklist file")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// botNets are the crawler networks from bot_networks_file. It is nil when
+// that is unset.
+var botNets *botNetworks
+
+var classifiedRequests = registry.Counter("redirect_classified_requests_total",
+	"Requests answered by the redirect handler, by client (bot or human).", "client")
+
+// botNetworks are IP prefixes known to be crawlers', as published by
+// search engines.
+type botNetworks struct {
+	prefixes []netip.Prefix
+}
+
+// loadBotNetworks reads a file of prefixes or addresses, one per line with
+// # comments, or a JSON document like the ones Google and Bing publish
+// their crawlers' ranges in ({"prefixes": [{"ipv4Prefix": "..."}]}).
+func loadBotNetworks(file string) (*botNetworks, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	n := new(botNetworks)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var doc struct {
+			Prefixes []struct {
+				IPv4 string `json:"ipv4Prefix"`
+				IPv6 string `json:"ipv6Prefix"`
+			} `json:"prefixes"`
+		}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, p := range doc.Prefixes {
+			if err := n.add(p.IPv4 + p.IPv6); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+		return n, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if err := n.add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+	}
+	return n, nil
+}
+
+func (n *botNetworks) add(entry string) error {
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		n.prefixes = append(n.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		return nil
+	}
+	p, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return err
+	}
+	n.prefixes = append(n.prefixes, p.Masked())
+	return nil
+}
+
+// contains reports whether ip is in one of the networks. A nil
+// *botNetworks contains nothing.
+func (n *botNetworks) contains(ip string) bool {
+	if n == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range n.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBot classifies r as coming from a bot by its User-Agent or, with
+// bot_networks_file, its client IP.
+func isBot(r *http.Request) bool {
+	return redirect.IsBotUserAgent(r.UserAgent()) || botNets.contains(redirect.ClientIP(r))
+}
+
+// clientClass is the client label of classifiedRequests.
+func clientClass(bot bool) string {
+	if bot {
+		return "bot"
+	}
+	return "human"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestLoadBotNetworks(t *testing.T) {
+	dir := t.TempDir()
+	lines := filepath.Join(dir, "bots.txt")
+	os.WriteFile(lines, []byte("# crawlers\n66.249.64.0/19\n2001:4860:4801::/48 # ipv6\n157.55.39.1\n"), 0o644)
+	googlebot := filepath.Join(dir, "googlebot.json")
+	os.WriteFile(googlebot, []byte(`{"creationTime":"2026-01-01","prefixes":[{"ipv6Prefix":"2001:4860:4801:10::/64"},{"ipv4Prefix":"66.249.64.0/27"}]}`), 0o644)
+
+	for _, file := range []string{lines, googlebot} {
+		n, err := loadBotNetworks(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ip, want := range map[string]bool{"66.249.64.5": true, "::ffff:66.249.64.5": true, "2001:4860:4801:10::1": true, "192.0.2.1": false, "not-an-ip": false} {
+			if got := n.contains(ip); got != want {
+				t.Errorf("%s: contains(%s) = %v", filepath.Base(file), ip, got)
+			}
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("66.249.64.0/19\nexample.com\n"), 0o644)
+	if _, err := loadBotNetworks(bad); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("want an error naming line 2, got %v", err)
+	}
+}
+
+func TestBotClassification(t *testing.T) {
+	orig, origTop, origLog, origNets := resolver, topHosts, accessLog, botNets
+	t.Cleanup(func() { resolver, topHosts, accessLog, botNets = orig, origTop, origLog, origNets })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects bots to https://example.com/crawlers", "Redirects to https://example.com/"}}
+	botNets = &botNetworks{}
+	botNets.add("198.51.100.0/24")
+	var logs bytes.Buffer
+	accessLog = slog.New(slog.NewJSONHandler(&logs, nil))
+	cfg := defaultConfig()
+	topHosts = newHostTraffic(cfg)
+	h := newMux(cfg, nil)
+
+	for _, c := range []struct{ ua, ip, want string }{
+		{"Mozilla/5.0 Firefox/128.0", "192.0.2.1", "https://example.com/"},
+		{"Mozilla/5.0 (compatible; bingbot/2.0)", "192.0.2.1", "https://example.com/crawlers"},
+		{"Mozilla/5.0 Firefox/128.0", "198.51.100.7", "https://example.com/crawlers"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "go.example.com"
+		req.RemoteAddr = c.ip + ":1234"
+		req.Header.Set("User-Agent", c.ua)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Location"); got != c.want {
+			t.Errorf("%s from %s: got %s, want %s", c.ua, c.ip, got, c.want)
+		}
+	}
+
+	var bots int
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		json.Unmarshal(line, &entry)
+		if entry["bot"] == true {
+			bots++
+		}
+	}
+	if bots != 2 {
+		t.Errorf("want 2 requests logged as bots, got %d: %s", bots, logs.Bytes())
+	}
+	if top := topHosts.hosts.Top(1); len(top) != 1 || top[0].Count != 1 {
+		t.Errorf("want only the human counted, got %+v", top)
+	}
+}
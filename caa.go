@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// caaTTL is how long what a host's CAA records say is kept before they're
+// looked up again.
+const caaTTL = 5 * time.Minute
+
+// typeCAA is the CAA record type (RFC 8659), which dnsmessage doesn't name.
+const typeCAA dnsmessage.Type = 257
+
+// caaChecks are the checkers of the CAs certificates come from, primary
+// first, whose verdicts the check API reports; none without cert_dir.
+var caaChecks []*caaChecker
+
+// caaIdentities are the issuer domain names well-known ACME CAs, by their
+// directory's host, accept in CAA records.
+var caaIdentities = map[string][]string{
+	"acme-v02.api.letsencrypt.org":         {"letsencrypt.org"},
+	"acme-staging-v02.api.letsencrypt.org": {"letsencrypt.org"},
+	"acme.zerossl.com":                     {"sectigo.com", "zerossl.com"},
+	"api.buypass.com":                      {"buypass.com", "buypass.no"},
+	"api.test4.buypass.no":                 {"buypass.com", "buypass.no"},
+	"dv.acme-v02.api.pki.goog":             {"pki.goog"},
+	"dv.acme-v02.test-api.pki.goog":        {"pki.goog"},
+	"acme.ssl.com":                         {"ssl.com"},
+}
+
+// caaForbiddenError is a host's CAA records not letting the CA issue for
+// it, found before ordering rather than from the CA's refusal.
+type caaForbiddenError struct {
+	host string
+	caaResult
+}
+
+func (e *caaForbiddenError) Error() string {
+	allowed := "no CA"
+	if len(e.Issuers) > 0 {
+		allowed = strings.Join(e.Issuers, ", ")
+	}
+	return fmt.Sprintf("CAA records at %s don't let %s issue for %s; they allow %s", e.Domain, e.CA, e.host, allowed)
+}
+
+// caaResult is what the CAA records covering a host say about a CA.
+type caaResult struct {
+	CA      string   `json:"ca"`               // the ACME directory's host
+	Domain  string   `json:"domain,omitempty"` // where the records are, "" if there are none
+	Issuers []string `json:"issuers,omitempty"`
+	Allowed bool     `json:"allowed"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type caaRecord struct {
+	flags      uint8
+	tag, value string
+}
+
+// caaChecker finds, ahead of an order, hosts whose CAA records won't let
+// the CA issue for them, so they fail with a clear error rather than the
+// CA's. Hosts whose records can't be looked up are left to the CA.
+type caaChecker struct {
+	ca         string
+	identities []string
+	lookup     func(ctx context.Context, name string) ([]caaRecord, error)
+	now        func() time.Time
+
+	mu     sync.Mutex
+	cached map[string]caaResult
+	until  map[string]time.Time
+}
+
+// newCAAChecker returns the checker for certificates from directory, or
+// nil if acme_caa_check is off or the CA's CAA identities aren't known.
+// identities, if any, replace the known ones.
+func newCAAChecker(cfg *config, directory string, identities []string) *caaChecker {
+	if !cfg.ACMECAACheck {
+		return nil
+	}
+	u, _ := url.Parse(directory)
+	if len(identities) == 0 {
+		identities = caaIdentities[u.Hostname()]
+	}
+	if len(identities) == 0 {
+		return nil
+	}
+	servers := systemNameservers()
+	return &caaChecker{
+		ca:         u.Hostname(),
+		identities: identities,
+		lookup: func(ctx context.Context, name string) ([]caaRecord, error) {
+			return lookupCAA(ctx, servers, name)
+		},
+		now:    time.Now,
+		cached: make(map[string]caaResult),
+		until:  make(map[string]time.Time),
+	}
+}
+
+// policy returns next, also refusing hosts whose CAA records forbid c's CA.
+func (c *caaChecker) policy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+		return c.check(ctx, host)
+	}
+}
+
+// check returns a *caaForbiddenError if host's CAA records forbid c's CA.
+func (c *caaChecker) check(ctx context.Context, host string) error {
+	if result := c.result(ctx, host); !result.Allowed {
+		return &caaForbiddenError{host: host, caaResult: result}
+	}
+	return nil
+}
+
+// result returns what host's CAA records say about c's CA, looking them
+// up if they weren't in the last caaTTL.
+func (c *caaChecker) result(ctx context.Context, host string) caaResult {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	c.mu.Lock()
+	result, ok := c.cached[host]
+	if ok && c.now().Before(c.until[host]) {
+		c.mu.Unlock()
+		return result
+	}
+	c.mu.Unlock()
+
+	result = caaResult{CA: c.ca, Allowed: true}
+	name, wildcard := strings.CutPrefix(host, "*.")
+	// The records that count are the closest ones, on the name itself or
+	// else its nearest ancestor with any.
+	for ; name != ""; _, name, _ = strings.Cut(name, ".") {
+		records, err := c.lookup(ctx, name)
+		if err != nil {
+			// Whether the CA would issue is its own call, then.
+			result.Error = err.Error()
+			return result
+		}
+		if len(records) > 0 {
+			result.Domain = name
+			result.Issuers, result.Allowed = caaAllows(records, c.identities, wildcard)
+			break
+		}
+	}
+	c.mu.Lock()
+	c.cached[host], c.until[host] = result, c.now().Add(caaTTL)
+	c.mu.Unlock()
+	return result
+}
+
+// purge drops the cached verdicts for host and the names under it, whose
+// records may be host's.
+func (c *caaChecker) purge(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name := range c.cached {
+		if name == host || strings.HasSuffix(name, "."+host) {
+			delete(c.cached, name)
+			delete(c.until, name)
+		}
+	}
+}
+
+// caaAllows returns the issuers records name for a certificate, wildcard
+// or not, and reports whether one of them is identities' CA.
+func caaAllows(records []caaRecord, identities []string, wildcard bool) ([]string, bool) {
+	tag := "issue"
+	if wildcard && slices.ContainsFunc(records, func(r caaRecord) bool { return strings.EqualFold(r.tag, "issuewild") }) {
+		tag = "issuewild"
+	}
+	var issuers []string
+	restricted, allowed := false, false
+	for _, r := range records {
+		switch lower := strings.ToLower(r.tag); {
+		case lower == tag:
+			restricted = true
+			issuer, _, _ := strings.Cut(r.value, ";")
+			if issuer = strings.TrimSpace(issuer); issuer == "" {
+				continue
+			}
+			issuers = append(issuers, issuer)
+			if slices.ContainsFunc(identities, func(id string) bool { return strings.EqualFold(id, issuer) }) {
+				allowed = true
+			}
+		case r.flags&0x80 != 0 && !slices.Contains([]string{"issue", "issuewild", "iodef", "issuemail", "contactemail", "contactphone"}, lower):
+			// A critical property no CA can know to follow bars them all.
+			return issuers, false
+		}
+	}
+	return issuers, allowed || !restricted
+}
+
+// systemNameservers returns the nameservers in /etc/resolv.conf, or the
+// local one, as the Go resolver would.
+func systemNameservers() []string {
+	var servers []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+// lookupCAA returns the CAA records at name from the first of servers that
+// answers, over UDP unless the answer is truncated. A name that doesn't
+// exist has none.
+func lookupCAA(ctx context.Context, servers []string, name string) ([]caaRecord, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: typeCAA, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	err = errors.New("no nameservers")
+	for _, server := range servers {
+		var reply *dnsmessage.Message
+		if reply, err = exchangeDNS(ctx, "udp", server, packed, query.ID); err == nil && reply.Truncated {
+			reply, err = exchangeDNS(ctx, "tcp", server, packed, query.ID)
+		}
+		if err != nil {
+			continue
+		}
+		switch reply.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("CAA lookup for %s: %s responded %s", name, server, reply.RCode)
+		}
+		var records []caaRecord
+		for _, answer := range reply.Answers {
+			body, ok := answer.Body.(*dnsmessage.UnknownResource)
+			if !ok || answer.Header.Type != typeCAA || len(body.Data) < 2 || len(body.Data) < 2+int(body.Data[1]) {
+				continue
+			}
+			n := int(body.Data[1])
+			records = append(records, caaRecord{flags: body.Data[0], tag: string(body.Data[2 : 2+n]), value: string(body.Data[2+n:])})
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("CAA lookup for %s: %w", name, err)
+}
+
+// exchangeDNS sends query to server over network and returns its reply.
+func exchangeDNS(ctx context.Context, network, server string, query []byte, id uint16) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf))
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+	var reply dnsmessage.Message
+	if err := reply.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	if reply.ID != id {
+		return nil, errors.New("mismatched DNS reply")
+	}
+	return &reply, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/frolic/redirect.name/redirect/dnstest"
+)
+
+func TestCAAChecker(t *testing.T) {
+	srv := dnstest.NewServer()
+	defer srv.Close()
+	srv.SetCAA("example.com", `0 issue "letsencrypt.org"`, `0 issuewild ";"`, `0 iodef "mailto:security@example.com"`)
+	srv.SetCAA("other.example.com", `0 issue "pki.goog; validationmethods=dns-01"`)
+	srv.SetCAA("critical.example.com", `0 issue "letsencrypt.org"`, `128 tbs "unknown"`)
+	srv.SetCAA("mail.example.com", `0 iodef "mailto:security@example.com"`)
+	srv.SetCAA("broken.example.com", `0 issue "letsencrypt.org"`)
+	srv.SetRCode("broken.example.com", dnsmessage.RCodeServerFailure)
+
+	cfg := defaultConfig()
+	caa := newCAAChecker(cfg, "https://acme-v02.api.letsencrypt.org/directory", nil)
+	if caa == nil {
+		t.Fatal("no checker for Let's Encrypt")
+	}
+	caa.lookup = func(ctx context.Context, name string) ([]caaRecord, error) {
+		return lookupCAA(ctx, []string{srv.Addr}, name)
+	}
+	for host, want := range map[string]caaResult{
+		"go.example.com":       {Domain: "example.com", Issuers: []string{"letsencrypt.org"}, Allowed: true},
+		"*.example.com":        {Domain: "example.com"},
+		"other.example.com":    {Domain: "other.example.com", Issuers: []string{"pki.goog"}},
+		"critical.example.com": {Domain: "critical.example.com", Issuers: []string{"letsencrypt.org"}},
+		"mail.example.com":     {Domain: "mail.example.com", Allowed: true},
+		"go.example.net":       {Allowed: true},
+	} {
+		got := caa.result(context.Background(), host)
+		if got.Domain != want.Domain || got.Allowed != want.Allowed || !slices.Equal(got.Issuers, want.Issuers) || got.Error != "" {
+			t.Errorf("%s: got %+v, want %+v", host, got, want)
+		}
+	}
+	if got := caa.result(context.Background(), "broken.example.com"); !got.Allowed || got.Error == "" {
+		t.Errorf("failed lookup: got %+v, want allowed with an error", got)
+	}
+
+	var forbidden *caaForbiddenError
+	if err := caa.check(context.Background(), "other.example.com"); !errors.As(err, &forbidden) || err.Error() != "CAA records at other.example.com don't let acme-v02.api.letsencrypt.org issue for other.example.com; they allow pki.goog" {
+		t.Errorf("check: got %v", err)
+	}
+	queries := len(srv.Queries())
+	caa.check(context.Background(), "go.example.com")
+	if len(srv.Queries()) != queries {
+		t.Error("verdict not cached")
+	}
+
+	// Long answers come over TCP.
+	srv.SetTruncate(true)
+	if records, err := lookupCAA(context.Background(), []string{srv.Addr}, "example.com"); err != nil || len(records) != 3 {
+		t.Errorf("truncated: got %v, %v", records, err)
+	}
+
+	cfg.ACMECAACheck = false
+	if newCAAChecker(cfg, "https://acme-v02.api.letsencrypt.org/directory", nil) != nil {
+		t.Error("checker with acme_caa_check off")
+	}
+	cfg.ACMECAACheck = true
+	if newCAAChecker(cfg, "https://ca.internal/acme/directory", nil) != nil {
+		t.Error("checker for a CA of unknown identity")
+	}
+	if c := newCAAChecker(cfg, "https://ca.internal/acme/directory", []string{"ca.internal"}); c == nil || c.ca != "ca.internal" {
+		t.Error("no checker with acme_caa_identities")
+	}
+}
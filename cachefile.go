@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// loadCache warms c with the answers saveCache wrote to path on the last
+// shutdown, so that a restart doesn't look every busy host up at once. A
+// missing file is not an error.
+func loadCache(path string, c *redirect.Cache) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := c.Load(f)
+	log.Printf("Loaded %d cached hosts from %s", n, path)
+	return err
+}
+
+// saveCache writes c's answers to path, replacing it only once they're
+// all written.
+func saveCache(path string, c *redirect.Cache) error {
+	return replaceFile(path, c.Save)
+}
+
+// replaceFile writes path with write, through a temporary file renamed
+// over it, so that a crash midway leaves the old file whole.
+func replaceFile(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
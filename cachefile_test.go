@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	lookups := 0
+	upstream := redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		lookups++
+		return redirect.ParseAll([]string{"Redirects to https://example.com/"}), nil
+	})
+	if err := loadCache(path, redirect.NewCache(upstream, time.Minute)); err != nil {
+		t.Fatalf("a missing cache_file: %v", err)
+	}
+
+	old := redirect.NewCache(upstream, time.Minute)
+	old.LookupConfig(context.Background(), "go.example.com")
+	if err := saveCache(path, old); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("want just the cache_file left, got %d files", len(entries))
+	}
+	restarted := redirect.NewCache(upstream, time.Minute)
+	if err := loadCache(path, restarted); err != nil {
+		t.Fatal(err)
+	}
+	restarted.LookupConfig(context.Background(), "go.example.com")
+	if lookups != 1 {
+		t.Errorf("the restarted cache looked go.example.com up again")
+	}
+}
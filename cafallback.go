@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// caFallbackAfter is how many certificate failures in a row send a host
+// to acme_fallback_directory, and caFallbackFor how long it stays there
+// before the primary CA is tried again.
+const (
+	caFallbackAfter = 3
+	caFallbackFor   = 7 * 24 * time.Hour
+)
+
+var certsIssued = registry.Counter("redirect_certificates_issued_total",
+	"Certificates issued on demand, by CA (the host of its ACME directory).", "ca")
+
+// caFallback gets certificates from the primary CA until it fails
+// caFallbackAfter times in a row for a host, rate limited or refused by a
+// CAA record say, then from the secondary for caFallbackFor. Both share
+// cert_dir, so challenge tokens and certificates either caches are found
+// by the other, and ALPN challenges are always answered by the primary.
+type caFallback struct {
+	secondary func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]int
+	since    map[string]time.Time // hosts using the secondary, since
+}
+
+// newCAFallback returns a fallback to the CA whose certificates secondary
+// gets.
+func newCAFallback(secondary func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *caFallback {
+	return &caFallback{
+		secondary: secondary,
+		now:       time.Now,
+		failures:  make(map[string]int),
+		since:     make(map[string]time.Time),
+	}
+}
+
+// tlsConfig returns config with certificates from its GetCertificate, the
+// primary CA, falling back to f's secondary.
+func (f *caFallback) tlsConfig(config *tls.Config) *tls.Config {
+	primary := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if isTokenHello(hello) {
+			return primary(hello)
+		}
+		if f.fellBack(name) {
+			return f.secondary(hello)
+		}
+		cert, err := primary(hello)
+		if !isCertFailure(err) {
+			if err == nil {
+				f.succeeded(name)
+			}
+			return cert, err
+		}
+		var forbidden *caaForbiddenError
+		switch {
+		case errors.As(err, &forbidden):
+			f.fallBack(name)
+			log.Printf("Certificate for %s can't come from the primary CA, trying the fallback: %v", name, err)
+		case f.failed(name):
+			log.Printf("Certificate for %s failed %d times from the primary CA, trying the fallback: %v", name, caFallbackAfter, err)
+		default:
+			return nil, err
+		}
+		return f.secondary(hello)
+	}
+	return config
+}
+
+// fellBack reports whether name uses the secondary CA.
+func (f *caFallback) fellBack(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	since, ok := f.since[name]
+	if ok && f.now().Sub(since) >= caFallbackFor {
+		delete(f.since, name)
+		return false
+	}
+	return ok
+}
+
+func (f *caFallback) succeeded(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.failures, name)
+}
+
+// failed counts a primary failure for name, and reports whether that
+// sends it to the secondary.
+func (f *caFallback) failed(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[name]++
+	if f.failures[name] < caFallbackAfter {
+		return false
+	}
+	delete(f.failures, name)
+	f.since[name] = f.now()
+	return true
+}
+
+// fallBack sends name to the secondary CA now, its CAA records ruling out
+// the primary.
+func (f *caFallback) fallBack(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.failures, name)
+	f.since[name] = f.now()
+}
+
+func isTokenHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// issuerCache is a manager's cache, recording in <name>+issuer in records,
+// next to each certificate it stores, which CA issued it.
+type issuerCache struct {
+	autocert.Cache
+	directory string
+	records   autocert.Cache
+}
+
+func newIssuerCache(cache, records autocert.Cache, directory string) *issuerCache {
+	return &issuerCache{Cache: cache, directory: directory, records: records}
+}
+
+func (c *issuerCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	if strings.Contains(strings.TrimSuffix(key, "+rsa"), "+") {
+		return nil // a challenge token or the account key
+	}
+	log.Printf("Certificate for %s issued by %s", key, c.directory)
+	certsIssued.Inc(caName(c.directory))
+	return c.records.Put(ctx, key+"+issuer", []byte(c.directory+"\n"))
+}
+
+// caName returns the host of an ACME directory URL, to label metrics.
+func caName(directory string) string {
+	if u, err := url.Parse(directory); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return directory
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCAFallback(t *testing.T) {
+	primaryCert, secondaryCert := &tls.Certificate{}, &tls.Certificate{}
+	primaryErr := errors.New("429 urn:ietf:params:acme:error:rateLimited")
+	var primaryOK bool
+	now := time.Now()
+	f := &caFallback{
+		secondary: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return secondaryCert, nil },
+		now:       func() time.Time { return now },
+		failures:  make(map[string]int),
+		since:     make(map[string]time.Time),
+	}
+	config := f.tlsConfig(&tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "refused.example.com" {
+			return nil, refusedHostError{errors.New("not served")}
+		}
+		if hello.ServerName == "caa.example.com" {
+			return nil, &caaForbiddenError{host: hello.ServerName, caaResult: caaResult{Domain: "example.com", Issuers: []string{"pki.goog"}}}
+		}
+		if primaryOK || len(hello.SupportedProtos) > 0 {
+			return primaryCert, nil
+		}
+		return nil, primaryErr
+	}})
+	get := func(name string) (*tls.Certificate, error) {
+		return config.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	}
+
+	for i := 1; i < caFallbackAfter; i++ {
+		if _, err := get("go.example.com"); err != primaryErr {
+			t.Fatalf("failure %d: got %v", i, err)
+		}
+	}
+	if cert, err := get("go.example.com"); cert != secondaryCert || err != nil {
+		t.Fatalf("failure %d: got %v, %v", caFallbackAfter, cert, err)
+	}
+	primaryOK = true
+	if cert, _ := get("go.example.com"); cert != secondaryCert {
+		t.Error("fell back host went back to the primary")
+	}
+	if cert, _ := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "go.example.com", SupportedProtos: []string{acme.ALPNProto}}); cert != primaryCert {
+		t.Error("ALPN challenge not answered by the primary")
+	}
+	now = now.Add(caFallbackFor)
+	if cert, _ := get("go.example.com"); cert != primaryCert {
+		t.Errorf("primary not retried after %v", caFallbackFor)
+	}
+
+	// Refusals aren't failures.
+	for range caFallbackAfter {
+		get("refused.example.com")
+	}
+	if f.failures["refused.example.com"] != 0 || !f.since["refused.example.com"].IsZero() {
+		t.Error("refused host counted as failing")
+	}
+
+	// A CAA record ruling out the primary falls back at once.
+	if cert, err := get("caa.example.com"); cert != secondaryCert || err != nil {
+		t.Errorf("CAA-forbidden host: got %v, %v", cert, err)
+	}
+}
+
+func TestIssuerCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := newIssuerCache(autocert.DirCache(dir), autocert.DirCache(dir), "https://acme.example.net/directory")
+	ctx := context.Background()
+	for _, key := range []string{"go.example.com", "go.example.com+rsa", "go.example.com+token", "abc+http-01", "acme_account+key"} {
+		if err := cache.Put(ctx, key, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, want := range map[string]bool{
+		"go.example.com":       true,
+		"go.example.com+rsa":   true,
+		"go.example.com+token": false,
+		"abc+http-01":          false,
+		"acme_account+key":     false,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, key+"+issuer"))
+		if got := err == nil; got != want {
+			t.Errorf("%s: recorded %v", key, got)
+		}
+		if want && string(data) != "https://acme.example.net/directory\n" {
+			t.Errorf("%s: recorded %q", key, data)
+		}
+	}
+}
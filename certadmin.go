@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/certcache"
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certs is the admin API's view of the cached certificates. It is nil
+// unless cert_dir is set.
+var certs *certAdmin
+
+// certErrorsKept is how many distinct certificate failures
+// /certificates/errors shows.
+const certErrorsKept = 100
+
+// recentCertErrors holds the latest certificate failures, for
+// /certificates/errors.
+var recentCertErrors = new(certErrorLog)
+
+// certAdmin lists, renews and deletes the certificates in the cache all
+// managers share, for /certificates.
+type certAdmin struct {
+	cache autocert.Cache
+	dns   *dnsCertManager // or nil
+	// get answers a handshake through autocert and everything wrapping
+	// it, and reset drops the certificates autocert holds in memory.
+	get     func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	reset   func()
+	keyType string
+	// directory is acme_directory, the CA of certificates with no
+	// +issuer record.
+	directory string
+}
+
+// A certInfo describes a cached certificate.
+type certInfo struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	Names     []string  `json:"names,omitempty"`
+	KeyType   string    `json:"key_type,omitempty"`
+	Issuer    string    `json:"issuer,omitempty"` // the issuing CA certificate's common name
+	CA        string    `json:"ca,omitempty"`     // the ACME directory it came from
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DNS01     bool      `json:"dns01,omitempty"`
+	Error     string    `json:"error,omitempty"` // why the entry couldn't be read
+}
+
+// certName returns the name of the certificate cached at key, and whether
+// it's a DNS-01 one, or "" if key holds something else: a challenge token,
+// account key, lock or record.
+func certName(key string) (name string, dns01 bool) {
+	name = strings.TrimSuffix(key, "+rsa")
+	if domain, ok := strings.CutPrefix(name, "dns01+"); ok {
+		name, dns01 = domain, true
+		if parent, ok := strings.CutPrefix(name, "wildcard."); ok {
+			name = "*." + parent
+		}
+	}
+	if strings.Contains(name, "+") {
+		return "", false
+	}
+	return name, dns01
+}
+
+// list describes every cached certificate, by name.
+func (c *certAdmin) list(ctx context.Context) ([]certInfo, error) {
+	keys, err := certcache.List(ctx, c.cache, "")
+	if err != nil {
+		return nil, err
+	}
+	list := []certInfo{}
+	for _, key := range keys {
+		if name, _ := certName(key); name != "" {
+			list = append(list, c.describe(ctx, key))
+		}
+	}
+	slices.SortFunc(list, func(a, b certInfo) int {
+		if n := strings.Compare(a.Name, b.Name); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return list, nil
+}
+
+// describe reads the certificate cached at key.
+func (c *certAdmin) describe(ctx context.Context, key string) certInfo {
+	name, dns01 := certName(key)
+	info := certInfo{Name: name, Key: key, DNS01: dns01, CA: c.directory}
+	data, err := c.cache.Get(ctx, key)
+	var cert *tls.Certificate
+	if err == nil {
+		cert, err = parseCertificate(data)
+	}
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Names = cert.Leaf.DNSNames
+	info.Issuer = cert.Leaf.Issuer.CommonName
+	info.NotBefore, info.NotAfter = cert.Leaf.NotBefore, cert.Leaf.NotAfter
+	info.KeyType = keyTypeECDSA
+	if _, ok := cert.PrivateKey.(*rsa.PrivateKey); ok {
+		info.KeyType = keyTypeRSA
+	}
+	info.CA = c.issuer(ctx, key)
+	return info
+}
+
+// issuer returns the directory of the CA that issued the certificate at
+// key.
+func (c *certAdmin) issuer(ctx context.Context, key string) string {
+	if record, err := c.cache.Get(ctx, key+"+issuer"); err == nil {
+		return strings.TrimSpace(string(record))
+	}
+	return c.directory
+}
+
+// dnsDomain returns the DNS-01 domain whose certificate serves host, or
+// "".
+func (c *certAdmin) dnsDomain(host string) string {
+	if c.dns == nil {
+		return ""
+	}
+	return c.dns.domainFor(host)
+}
+
+// renew orders a new certificate for host, however long its current one
+// is good for, and describes it. If autocert's order fails, its previous
+// certificate is put back.
+func (c *certAdmin) renew(ctx context.Context, host string) (certInfo, error) {
+	if domain := c.dnsDomain(host); domain != "" {
+		if err := c.dns.reissue(ctx, domain); err != nil {
+			return certInfo{}, err
+		}
+		return c.describe(ctx, c.dns.cacheKey(domain)), nil
+	}
+	saved := make(map[string][]byte)
+	for _, key := range []string{host, host + "+rsa"} {
+		data, err := c.cache.Get(ctx, key)
+		if err == autocert.ErrCacheMiss {
+			continue
+		}
+		if err == nil {
+			err = c.cache.Delete(ctx, key)
+		}
+		if err != nil {
+			return certInfo{}, err
+		}
+		saved[key] = data
+	}
+	c.reset()
+	if _, err := c.get(renewalHello(host)); err != nil {
+		for key, data := range saved {
+			if err := c.cache.Put(ctx, key, data); err != nil {
+				log.Printf("Restoring certificate %s: %v", key, err)
+			}
+		}
+		return certInfo{}, err
+	}
+	key := host
+	if c.keyType == keyTypeRSA {
+		key += "+rsa"
+	}
+	return c.describe(ctx, key), nil
+}
+
+// renewalHello asks for host's certificate as a current client would, so
+// the certificate most handshakes get is the one ordered.
+func renewalHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+		SupportedCurves:  []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+// remove deletes host's certificates, revoking them first if revoke is
+// set, and reports whether there were any. Handshakes for host then get
+// new ones ordered; a DNS-01 domain's is reissued at the next renewal
+// check.
+func (c *certAdmin) remove(ctx context.Context, host string, revoke bool) (bool, error) {
+	keys := []string{host, host + "+rsa"}
+	domain := c.dnsDomain(host)
+	if domain != "" {
+		keys = append(keys, c.dns.cacheKey(domain))
+	}
+	found := false
+	for _, key := range keys {
+		data, err := c.cache.Get(ctx, key)
+		if err == autocert.ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			return found, err
+		}
+		found = true
+		if revoke {
+			if err := c.revoke(ctx, key, data); err != nil {
+				return found, fmt.Errorf("revoking %s: %w", key, err)
+			}
+			log.Printf("Revoked certificate %s", key)
+		}
+		if err := c.cache.Delete(ctx, key); err != nil {
+			return found, err
+		}
+		if err := c.cache.Delete(ctx, key+"+issuer"); err != nil {
+			return found, err
+		}
+	}
+	if domain != "" {
+		c.dns.forget(domain)
+	}
+	c.reset()
+	return found, nil
+}
+
+// revoke asks the CA that issued the certificate cached at key, as data,
+// to revoke it, proving control with the certificate's own key.
+func (c *certAdmin) revoke(ctx context.Context, key string, data []byte) error {
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{DirectoryURL: c.issuer(ctx, key)}
+	return client.RevokeCert(ctx, cert.PrivateKey.(crypto.Signer), cert.Certificate[0], acme.CRLReasonUnspecified)
+}
+
+// handleList lists the cached certificates as JSON.
+func (c *certAdmin) handleList(w http.ResponseWriter, r *http.Request) {
+	list, err := c.list(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Listing certificates: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleRenew renews the certificate of the host named in the path, and
+// describes the new one as JSON.
+func (c *certAdmin) handleRenew(w http.ResponseWriter, r *http.Request) {
+	host, ok := certHost(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	info, err := c.renew(ctx, host)
+	if err != nil {
+		recentCertErrors.record(host, err)
+		http.Error(w, fmt.Sprintf("Renewing %s: %v", host, err), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Renewed certificate for %s, valid until %s", host, info.NotAfter.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleDelete deletes the certificates of the host named in the path,
+// revoking them first if asked to with ?revoke.
+func (c *certAdmin) handleDelete(w http.ResponseWriter, r *http.Request) {
+	host, ok := certHost(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	found, err := c.remove(ctx, host, r.URL.Query().Has("revoke"))
+	switch {
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Deleting %s: %v", host, err), http.StatusBadGateway)
+	case !found:
+		http.Error(w, fmt.Sprintf("No certificate for %s", host), http.StatusNotFound)
+	default:
+		log.Printf("Deleted certificate for %s", host)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// certHost returns the host named in the path, or answers 400 if it's not
+// a hostname, such as a cache key that holds no certificate
+// (acme_account+key, ratelimit+...).
+func certHost(w http.ResponseWriter, r *http.Request) (string, bool) {
+	host, err := redirect.ParseHost(r.PathValue("host"))
+	if err != nil || host == "" {
+		http.Error(w, "host must be a hostname", http.StatusBadRequest)
+		return "", false
+	}
+	return host, true
+}
+
+// handlePurgePolicy purges the policy of the host named in the path.
+func (c *certAdmin) handlePurgePolicy(w http.ResponseWriter, r *http.Request) {
+	host, err := redirect.ParseHost(r.PathValue("host"))
+	if err != nil {
+		http.Error(w, "host must be a hostname", http.StatusBadRequest)
+		return
+	}
+	c.purgePolicy(host)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgePolicy drops what's remembered of host when deciding whether to
+// order its certificate: its cached rules, its CAA records and a DNS-01
+// wildcard failure keeping its apex waiting, so the next handshake decides
+// afresh.
+func (c *certAdmin) purgePolicy(host string) {
+	if configSources != nil {
+		configSources.purge(host)
+	}
+	for _, caa := range caaChecks {
+		caa.purge(host)
+	}
+	if c.dns != nil {
+		c.dns.retry(host)
+	}
+	log.Printf("Purged certificate policy of %s", host)
+}
+
+// A certError is a failure to get host's certificate, seen Count times
+// between First and Last.
+type certError struct {
+	Host  string    `json:"host"`
+	Error string    `json:"error"`
+	Count int       `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// certErrorLog keeps the last certErrorsKept distinct certificate
+// failures.
+type certErrorLog struct {
+	mu     sync.Mutex
+	errors []certError // least recently seen first
+}
+
+// record adds err for host, or counts it again if it's already kept.
+func (l *certErrorLog) record(host string, err error) {
+	now := time.Now()
+	e := certError{Host: host, Error: err.Error(), First: now}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := slices.IndexFunc(l.errors, func(seen certError) bool { return seen.Host == e.Host && seen.Error == e.Error }); i >= 0 {
+		e = l.errors[i]
+		l.errors = slices.Delete(l.errors, i, i+1)
+	}
+	e.Count++
+	e.Last = now
+	l.errors = append(l.errors, e)
+	if n := len(l.errors) - certErrorsKept; n > 0 {
+		l.errors = slices.Delete(l.errors, 0, n)
+	}
+}
+
+// list returns the failures kept, most recently seen first.
+func (l *certErrorLog) list() []certError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := slices.Clone(l.errors)
+	slices.Reverse(list)
+	if list == nil {
+		list = []certError{}
+	}
+	return list
+}
+
+// handleCertErrors lists recent certificate failures as JSON.
+func handleCertErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recentCertErrors.list())
+}
+
+// switchedManager answers with the autocert.Manager build last returned,
+// so reset drops the certificates autocert holds in memory and picks up
+// what's been changed in the cache. The managers it replaces are retired:
+// autocert can't stop their renewal timers, so their ACME requests fail
+// instead, and a certificate deleted from the cache isn't ordered again by
+// a manager still holding it.
+type switchedManager struct {
+	build   func() *autocert.Manager
+	current atomic.Pointer[autocert.Manager]
+
+	mu      sync.Mutex
+	retired *atomic.Bool // the current manager's
+}
+
+// errRetiredManager fails the ACME requests of a retired manager.
+var errRetiredManager = errors.New("autocert manager replaced by a reset")
+
+// retiringTransport passes requests to next until retired is set.
+type retiringTransport struct {
+	retired *atomic.Bool
+	next    http.RoundTripper
+}
+
+func (t retiringTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.retired.Load() {
+		return nil, errRetiredManager
+	}
+	return t.next.RoundTrip(r)
+}
+
+func newSwitchedManager(build func() *autocert.Manager) *switchedManager {
+	s := &switchedManager{build: build}
+	s.reset()
+	return s
+}
+
+func (s *switchedManager) reset() {
+	m := s.build()
+	retired := new(atomic.Bool)
+	if m.Client == nil {
+		m.Client = &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	}
+	httpClient := new(http.Client)
+	if m.Client.HTTPClient != nil {
+		*httpClient = *m.Client.HTTPClient
+	}
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	httpClient.Transport = retiringTransport{retired: retired, next: next}
+	m.Client.HTTPClient = httpClient
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retired != nil {
+		s.retired.Store(true)
+	}
+	s.retired = retired
+	s.current.Store(m)
+}
+
+func (s *switchedManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.current.Load().GetCertificate(hello)
+}
+
+// tlsConfig returns autocert's TLS config, with certificates from the
+// current manager.
+func (s *switchedManager) tlsConfig() *tls.Config {
+	config := s.current.Load().TLSConfig()
+	config.GetCertificate = s.GetCertificate
+	return config
+}
+
+// httpHandler answers HTTP-01 challenges with the current manager, and
+// passes other requests to fallback.
+func (s *switchedManager) httpHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.current.Load().HTTPHandler(fallback).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// cachedCert returns a self-signed certificate for name, expiring at
+// notAfter, as the cache holds it.
+func cachedCert(t *testing.T, name string, notAfter time.Time) []byte {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notAfter.Unix()),
+		Subject:      pkix.Name{CommonName: name},
+		Issuer:       pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodeCertificate([][]byte{der}, key)
+}
+
+// newRevokingCA returns an ACME server that counts revocations.
+func newRevokingCA(t *testing.T, revoked *atomic.Int32) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+		switch r.URL.Path {
+		case "/dir":
+			json.NewEncoder(w).Encode(map[string]string{"newNonce": srv.URL + "/nonce", "newOrder": srv.URL + "/new-order", "revokeCert": srv.URL + "/revoke"})
+		case "/nonce":
+		case "/revoke":
+			revoked.Add(1)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCertAdmin(t *testing.T) {
+	var revoked atomic.Int32
+	ca := newRevokingCA(t, &revoked)
+	ctx := context.Background()
+	cache := autocert.DirCache(t.TempDir())
+	expiry := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	cache.Put(ctx, "go.example.com", cachedCert(t, "go.example.com", expiry))
+	cache.Put(ctx, "go.example.com+issuer", []byte(ca.URL+"/dir\n"))
+	cache.Put(ctx, "dns01+wildcard.example.org", cachedCert(t, "*.example.org", expiry))
+	cache.Put(ctx, "acme_account+key", []byte("not a certificate"))
+
+	var resets atomic.Int32
+	issueErr := error(nil)
+	orig := certs
+	t.Cleanup(func() { certs = orig })
+	certs = &certAdmin{
+		cache: cache,
+		get: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if issueErr != nil {
+				return nil, issueErr
+			}
+			if _, err := cache.Get(ctx, hello.ServerName); err != autocert.ErrCacheMiss {
+				t.Errorf("ordering with a certificate still cached: %v", err)
+			}
+			return nil, cache.Put(ctx, hello.ServerName, cachedCert(t, hello.ServerName, expiry.Add(60*24*time.Hour)))
+		},
+		reset:     func() { resets.Add(1) },
+		keyType:   keyTypeECDSA,
+		directory: "https://acme.example/dir",
+	}
+	cfg := defaultConfig()
+	cfg.AdminToken = "secret"
+	admin := newAdminMux(cfg, nil)
+	adminDo := func(method, path string, v any) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		if v != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+				t.Fatalf("%s %s: %v: %s", method, path, err, rr.Body)
+			}
+		}
+		return rr.Code
+	}
+
+	var list []certInfo
+	if code := adminDo("GET", "/certificates", &list); code != http.StatusOK || len(list) != 2 {
+		t.Fatalf("list: %d %+v", code, list)
+	}
+	if c := list[0]; c.Name != "*.example.org" || !c.DNS01 || c.CA != "https://acme.example/dir" {
+		t.Errorf("DNS-01 certificate: %+v", c)
+	}
+	if c := list[1]; c.Name != "go.example.com" || c.KeyType != keyTypeECDSA || !c.NotAfter.Equal(expiry) || c.CA != ca.URL+"/dir" {
+		t.Errorf("autocert certificate: %+v", c)
+	}
+
+	var info certInfo
+	if code := adminDo("POST", "/certificates/go.example.com/renew", &info); code != http.StatusOK || !info.NotAfter.After(expiry) {
+		t.Fatalf("renew: %d %+v", code, info)
+	}
+	if resets.Load() != 1 {
+		t.Errorf("autocert reset %d times", resets.Load())
+	}
+
+	// A renewal that fails leaves the certificate there was, and is
+	// listed among recent errors.
+	issueErr = errors.New("acme: rate limited")
+	if code := adminDo("POST", "/certificates/go.example.com/renew", nil); code != http.StatusBadGateway {
+		t.Errorf("failed renewal: got %d", code)
+	}
+	if c := certs.describe(ctx, "go.example.com"); !c.NotAfter.After(expiry) {
+		t.Errorf("after a failed renewal: %+v", c)
+	}
+	var errs []certError
+	adminDo("GET", "/certificates/errors", &errs)
+	if len(errs) == 0 || errs[0].Host != "go.example.com" || errs[0].Error != "acme: rate limited" {
+		t.Errorf("errors: %+v", errs)
+	}
+
+	if code := adminDo("DELETE", "/certificates/go.example.com?revoke", nil); code != http.StatusNoContent || revoked.Load() != 1 {
+		t.Fatalf("delete: %d, %d revoked", code, revoked.Load())
+	}
+	if _, err := cache.Get(ctx, "go.example.com+issuer"); err != autocert.ErrCacheMiss {
+		t.Errorf("issuer record left: %v", err)
+	}
+	if code := adminDo("DELETE", "/certificates/go.example.com", nil); code != http.StatusNotFound {
+		t.Errorf("delete again: got %d", code)
+	}
+	adminDo("GET", "/certificates", &list)
+	if len(list) != 1 {
+		t.Errorf("after delete: %+v", list)
+	}
+
+	// Cache keys holding anything but certificates aren't hosts.
+	for _, path := range []string{"/certificates/acme_account+key/renew", "/certificates/ratelimit+example.com"} {
+		method := "DELETE"
+		if strings.HasSuffix(path, "/renew") {
+			method = "POST"
+		}
+		if code := adminDo(method, path, nil); code != http.StatusBadRequest {
+			t.Errorf("%s %s: want 400, got %d", method, path, code)
+		}
+	}
+	if _, err := cache.Get(ctx, "acme_account+key"); err != nil {
+		t.Errorf("account key: %v", err)
+	}
+}
+
+func TestSwitchedManagerRetires(t *testing.T) {
+	var revoked atomic.Int32
+	ca := newRevokingCA(t, &revoked)
+	s := newSwitchedManager(func() *autocert.Manager {
+		return &autocert.Manager{Client: &acme.Client{DirectoryURL: ca.URL + "/dir"}}
+	})
+	old := s.current.Load()
+	s.reset()
+	ctx := context.Background()
+	if _, err := old.Client.Discover(ctx); !errors.Is(err, errRetiredManager) {
+		t.Errorf("the replaced manager's requests: want them failed, got %v", err)
+	}
+	if _, err := s.current.Load().Client.Discover(ctx); err != nil {
+		t.Errorf("the current manager's requests: %v", err)
+	}
+}
+
+func TestCertErrorLog(t *testing.T) {
+	l := new(certErrorLog)
+	for i := range certErrorsKept + 5 {
+		l.record(fmt.Sprintf("h%d.example.com", i), errors.New("failed"))
+	}
+	l.record("h10.example.com", errors.New("failed"))
+	list := l.list()
+	if len(list) != certErrorsKept || list[0].Host != "h10.example.com" || list[0].Count != 2 {
+		t.Errorf("got %d errors, first %+v", len(list), list[0])
+	}
+	if last := list[len(list)-1]; last.Host != "h5.example.com" {
+		t.Errorf("oldest kept: %+v", last)
+	}
+}
+
+func TestCertPolicyPurge(t *testing.T) {
+	var lookups int
+	caa := &caaChecker{
+		ca:         "acme.example",
+		identities: []string{"acme.example"},
+		lookup: func(ctx context.Context, name string) ([]caaRecord, error) {
+			lookups++
+			return []caaRecord{{tag: "issue", value: "pki.goog"}}, nil
+		},
+		now:    time.Now,
+		cached: make(map[string]caaResult),
+		until:  make(map[string]time.Time),
+	}
+	origCAA, origCerts := caaChecks, certs
+	t.Cleanup(func() { caaChecks, certs = origCAA, origCerts })
+	caaChecks = []*caaChecker{caa}
+	dns := &dnsCertManager{wildcards: true, failed: map[string]time.Time{"*.example.com": time.Now()}}
+	certs = &certAdmin{dns: dns}
+
+	ctx := context.Background()
+	for _, host := range []string{"go.example.com", "example.com", "example.org"} {
+		caa.check(ctx, host)
+	}
+	req := httptest.NewRequest("DELETE", "/certificates/example.com/policy", nil)
+	rr := httptest.NewRecorder()
+	newAdminMux(defaultConfig(), nil).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("purge: %d %s", rr.Code, rr.Body)
+	}
+	if len(caa.cached) != 1 || len(dns.failed) != 0 {
+		t.Errorf("after purging: CAA verdicts for %v, failed wildcards %v", caa.cached, dns.failed)
+	}
+	if caa.check(ctx, "go.example.com"); lookups != 4 {
+		t.Errorf("%d lookups, want the purged host's looked up again", lookups)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// checkResult is the answer of the check API: how a request for a host and
+// path would be handled, without redirecting.
+type checkResult struct {
+	Host       string        `json:"host"`
+	RecordName string        `json:"record_name"`
+	Path       string        `json:"path"`
+	Source     string        `json:"source,omitempty"`
+	Rules      []checkedRule `json:"rules"`
+	Location   string        `json:"location,omitempty"`
+	Status     int           `json:"status,omitempty"`
+	State      string        `json:"state,omitempty"` // "locked" if Location is left out
+	Blocked    string        `json:"blocked,omitempty"`
+	CAA        []caaResult   `json:"caa,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type checkedRule struct {
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	State      string `json:"state,omitempty"`
+	Bots       bool   `json:"bots,omitempty"`
+	Methods    string `json:"methods,omitempty"`
+	Header     string `json:"header,omitempty"`
+	GoGet      bool   `json:"goget,omitempty"`
+	Flag       string `json:"flag,omitempty"`
+	Serves     string `json:"serves,omitempty"`
+	ServesFrom string `json:"serves_from,omitempty"`
+	Ignores    bool   `json:"ignores,omitempty"`
+	Canonical  string `json:"canonical,omitempty"`
+	Responds   bool   `json:"responds,omitempty"`
+	Type       string `json:"content_type,omitempty"`
+	Requires   string `json:"requires,omitempty"`
+	Protected  string `json:"protected,omitempty"`
+	Cookie     string `json:"cookie,omitempty"`
+}
+
+// locked reports whether rule is behind a key or a password, so that the
+// check API must not give its destination away.
+func locked(rule *redirect.Rule) bool {
+	return rule.Requires != "" || rule.Protected != ""
+}
+
+// requiredParam is the query parameter rule's key goes in, leaving out
+// the key.
+func requiredParam(rule *redirect.Rule) string {
+	name, _, _ := strings.Cut(rule.Requires, "=")
+	return name
+}
+
+// protectedUser is the user name of rule's Basic auth, leaving out the
+// password hash.
+func protectedUser(rule *redirect.Rule) string {
+	user, _, _ := strings.Cut(rule.Protected, ":")
+	return user
+}
+
+// handleCheck serves GET /api/check?host=...&path=..., reporting the rules
+// found for host, the redirect they give path (default "/"), or give a bot
+// with bot=1 or a method other than GET with method=, whether a target
+// check would refuse it, and whether host's CAA records let each CA issue
+// for it. Hosts filter (if not nil) doesn't serve get 421, as their
+// requests do.
+func handleCheck(filter *hostFilter, checks []redirect.TargetChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, err := redirect.ParseHost(r.URL.Query().Get("host"))
+		if err != nil {
+			http.Error(w, "host must be a hostname", http.StatusBadRequest)
+			return
+		}
+		if filter != nil && !filter.serves(host) {
+			http.Error(w, "This server does not serve "+host, http.StatusMisdirectedRequest)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if path == "" || path[0] != '/' {
+			path = "/" + path
+		}
+		result := checkResult{Host: host, RecordName: redirect.RecordName(host), Path: path, Rules: []checkedRule{}}
+
+		info := &redirect.LookupInfo{Host: host}
+		ctx := redirect.WithLookupInfo(r.Context(), info)
+		rules, err := resolver.LookupConfig(ctx, host)
+		result.Source = info.Source
+		for _, rule := range rules {
+			checked := checkedRule{
+				From: rule.From, To: rule.To, State: rule.RedirectState,
+				Bots: rule.Bots, Methods: rule.Methods, Header: rule.Header, GoGet: rule.GoGet, Flag: rule.Flag,
+				Serves: rule.Serves, ServesFrom: rule.ServesFrom, Ignores: rule.Ignores, Canonical: rule.Canonical,
+				Responds: rule.Responds, Type: rule.ContentType, Requires: requiredParam(rule),
+				Protected: protectedUser(rule), Cookie: rule.Cookie,
+			}
+			if locked(rule) {
+				checked.To, checked.State = "", "locked"
+			}
+			result.Rules = append(result.Rules, checked)
+		}
+		if err == nil {
+			var target *redirect.Redirect
+			method := strings.ToUpper(r.URL.Query().Get("method"))
+			if method == "" {
+				method = http.MethodGet
+			}
+			if target, err = redirect.MatchMethod(rules, method, path, r.URL.Query().Get("bot") == "1"); err == nil {
+				result.Location, result.Status = target.Location, target.Status
+				if locked(target.Rule) {
+					// The target checks would tell of the destination
+					// too, so they don't run.
+					result.Location, result.State = "", "locked"
+				} else {
+					result.Blocked = checkTargets(ctx, checks, host, path, target.Location)
+				}
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		for _, caa := range caaChecks {
+			result.CAA = append(result.CAA, caa.result(r.Context(), host))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// checkTargets returns why checks would refuse a redirect of path on host
+// to location, or "" if none would.
+func checkTargets(ctx context.Context, checks []redirect.TargetChecker, host, path, location string) string {
+	base := &url.URL{Scheme: "http", Host: host, Path: path}
+	checked := location
+	if u, err := base.Parse(location); err == nil {
+		checked = u.String()
+	}
+	for _, c := range checks {
+		if err := c.CheckTarget(ctx, checked); err != nil {
+			var be *redirect.BlockedError
+			if errors.As(err, &be) {
+				return be.Reason
+			}
+			return err.Error()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,1118 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/certcache"
+	"github.com/frolic/redirect.name/internal/dnsprovider"
+	"github.com/frolic/redirect.name/internal/stream"
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
+)
+
+// config holds every server setting. Each one can come from, in increasing
+// order of precedence: its default, the YAML config file, an environment
+// variable, or a command-line flag. A setting named cache_ttl in the file is
+// CACHE_TTL in the environment and -cache-ttl on the command line.
+type config struct {
+	Port                   int
+	CertDir                string
+	CertCache              string
+	CertPrewarm            string
+	CertPrewarmFile        string
+	CertPrewarmEvery       time.Duration
+	StaticCertsDir         string
+	ACMEDirectory          string
+	ACMEEmail              string
+	ACMEKeyType            string
+	ACMEEABKeyID           string
+	ACMEEABHMACKey         string
+	ACMEFallbackDirectory  string
+	ACMECAACheck           bool
+	ACMECAAIdentities      string
+	ACMEFallbackEABKeyID   string
+	ACMEFallbackEABHMACKey string
+	ACMEDNSProvider        string
+	ACMEDNSCredentials     string
+	ACMEDNSDomains         string
+	ACMEDNSPropagation     time.Duration
+	ACMEDNSWildcards       bool
+	HTTPAddr               string
+	HTTPSAddr              string
+	HTTP3Addr              string
+	TLSMinVersion          string
+	TLSCipherSuites        string
+	TLSCurves              string
+	TLSTicketRotation      time.Duration
+	DevTLS                 bool
+	DevTLSCA               string
+	BehindProxy            bool
+	Serverless             string
+	Maintenance            bool
+	MaintenanceRetry       time.Duration
+	FallbackURL            string
+	CanonicalHost          string
+	FallbackPage           bool
+	LinkPreviews           string
+	QRCodes                bool
+	ForceHTTPS             bool
+	HSTSMaxAge             time.Duration
+	WebFinger              string
+	ShadowMatcher          string
+	TemplatesDir           string
+	AdminAddr              string
+	DebugAddr              string
+	GRPCAddr               string
+	GRPCCert               string
+	GRPCKey                string
+	GRPCClientCA           string
+	Sources                string
+	DoHURL                 string
+	CacheTTL               time.Duration
+	NegativeCacheTTL       time.Duration
+	SharedCache            string
+	CacheFile              string
+	RedirectsFile          string
+	StaticRedirects        string
+	AllowedSchemes         string
+	LoopHops               int
+	IgnoredPaths           string
+	IgnoredStatus          int
+	CORSOrigins            string
+	SourceHeader           bool
+	ServerTiming           bool
+	RequestIDHeader        string
+	AccessLog              string
+	LogLevel               string
+	ClientIPLogging        string
+	IPHashKey              string
+	ErrorLog               string
+	LogMaxSize             int
+	LogMaxAge              time.Duration
+	LogMaxBackups          int
+	ErrorDSN               string
+	EventWebhook           string
+	EventWebhookSecret     string
+	EventFormat            string
+	EventStream            string
+	EventBatchSize         int
+	EventFlushInterval     time.Duration
+	OTLPEndpoint           string
+	OTLPHeaders            string
+	TraceSampleRatio       float64
+	TrustedProxies         string
+	ProxyProtocol          string
+	AllowedHosts           string
+	DeniedHosts            string
+	RateLimit              float64
+	RateLimitBurst         int
+	HostRateLimit          float64
+	HostRateLimitBurst     int
+	HostSuspendAfter       int
+	MaxInflight            int
+	MaxDNSLookups          int
+	MaxHostRules           int
+	MaxPatternLength       int
+	AnalyticsMaxHosts      int
+	AnalyticsDir           string
+	AnalyticsRetention     time.Duration
+	AnalyticsBots          string
+	BotNetworksFile        string
+	HostSuspendFor         time.Duration
+	AdminToken             string
+	AdminAuthFile          string
+	AdminCert              string
+	AdminKey               string
+	AdminClientCA          string
+	StatsdAddr             string
+	StatsdFormat           string
+	StatsdPrefix           string
+	StatsdInterval         time.Duration
+	BlocklistFile          string
+	BlocklistURL           string
+	BlocklistRefresh       time.Duration
+	BlocklistStatus        int
+	SafeBrowsingAPIKey     string
+	SafeBrowsingAction     string
+	SafeBrowsingWait       time.Duration
+	SafeBrowsingCacheTTL   time.Duration
+	AbuseReports           bool
+	AbuseReportThreshold   int
+	AbuseReportsFile       string
+	H2C                    bool
+	HTTP2MaxStreams        int
+	IdleTimeout            time.Duration
+	ReadTimeout            time.Duration
+	ReadHeaderTimeout      time.Duration
+	WriteTimeout           time.Duration
+	HandlerTimeout         time.Duration
+	MaxHeaderBytes         int
+	ReusePort              bool
+	UpgradeTimeout         time.Duration
+	DrainTimeout           time.Duration
+	DrainDelay             time.Duration
+
+	// validateOnly is -validate, which isn't a setting: the server checks
+	// the others instead of starting.
+	validateOnly bool
+}
+
+// knownSources are the names accepted in the sources setting.
+var knownSources = []string{"env", "file", "dns", "wellknown"}
+
+// schemeRE matches a URL scheme as defined by RFC 3986.
+var schemeRE = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+func defaultConfig() *config {
+	return &config{
+		Port:                 8081,
+		HTTPAddr:             ":80",
+		HTTPSAddr:            ":443",
+		TLSMinVersion:        "1.2",
+		ACMEDirectory:        "https://acme-v02.api.letsencrypt.org/directory",
+		ACMEKeyType:          keyTypeECDSA,
+		CertPrewarmEvery:     12 * time.Hour,
+		ACMECAACheck:         true,
+		MaintenanceRetry:     5 * time.Minute,
+		ACMEDNSPropagation:   2 * time.Minute,
+		CacheTTL:             time.Minute,
+		NegativeCacheTTL:     6 * time.Second,
+		RateLimitBurst:       20,
+		HostRateLimitBurst:   200,
+		HostSuspendFor:       time.Hour,
+		BlocklistRefresh:     time.Hour,
+		BlocklistStatus:      http.StatusGone,
+		AllowedSchemes:       strings.Join(redirect.AllowedSchemes, ","),
+		LoopHops:             3,
+		AccessLog:            "stdout",
+		LogLevel:             "info",
+		ClientIPLogging:      "full",
+		ErrorLog:             "stderr",
+		LogMaxSize:           100,
+		LogMaxBackups:        7,
+		MaxHostRules:         500,
+		MaxPatternLength:     2048,
+		AnalyticsMaxHosts:    1000,
+		AnalyticsRetention:   90 * 24 * time.Hour,
+		AnalyticsBots:        "exclude",
+		LinkPreviews:         "off",
+		QRCodes:              true,
+		IgnoredStatus:        http.StatusNotFound,
+		WebFinger:            "redirect",
+		EventFormat:          "json",
+		EventBatchSize:       100,
+		EventFlushInterval:   10 * time.Second,
+		StatsdFormat:         "statsd",
+		StatsdPrefix:         "redirect_name.",
+		StatsdInterval:       10 * time.Second,
+		RequestIDHeader:      "X-Request-ID",
+		TraceSampleRatio:     1,
+		SafeBrowsingAction:   "warn",
+		SafeBrowsingWait:     300 * time.Millisecond,
+		SafeBrowsingCacheTTL: 30 * time.Minute,
+		ReadTimeout:          5 * time.Second,
+		WriteTimeout:         5 * time.Second,
+		UpgradeTimeout:       30 * time.Second,
+		DrainTimeout:         10 * time.Second,
+	}
+}
+
+// flagSet registers a flag for every setting, bound to the fields of c.
+func (c *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("redirect-name", flag.ContinueOnError)
+	fs.IntVar(&c.Port, "port", c.Port, "port for plain HTTP when cert_dir is unset")
+	fs.StringVar(&c.CertDir, "cert-dir", c.CertDir, "directory for ACME certificates; enables HTTPS on http_addr and https_addr")
+	fs.StringVar(&c.CertCache, "cert-cache", c.CertCache, "s3://, gs:// or redis:// URL of storage for certificates shared between replicas, instead of cert_dir")
+	fs.StringVar(&c.CertPrewarm, "cert-prewarm", c.CertPrewarm, "comma-separated hosts whose certificates are obtained at startup rather than at their first visit")
+	fs.StringVar(&c.CertPrewarmFile, "cert-prewarm-file", c.CertPrewarmFile, "file of hosts to pre-warm certificates for, one per line, reread every cert_prewarm_every")
+	fs.DurationVar(&c.CertPrewarmEvery, "cert-prewarm-every", c.CertPrewarmEvery, "how often cert_prewarm_file is reread and its hosts' certificates checked")
+	fs.StringVar(&c.StaticCertsDir, "static-certs-dir", c.StaticCertsDir, "directory of <name>.crt and <name>.key pairs served for the names they cover instead of ACME certificates")
+	fs.StringVar(&c.ACMEDirectory, "acme-directory", c.ACMEDirectory, "directory URL of the ACME CA certificates come from")
+	fs.StringVar(&c.ACMEEmail, "acme-email", c.ACMEEmail, "contact email registered with the ACME CA, for expiry and incident notices")
+	fs.StringVar(&c.ACMEKeyType, "acme-key-type", c.ACMEKeyType, "certificate key type: ecdsa, falling back to rsa for clients without ECDSA support, or rsa")
+	fs.StringVar(&c.ACMEEABKeyID, "acme-eab-key-id", c.ACMEEABKeyID, "key ID binding the ACME account to one at CAs requiring external account binding")
+	fs.StringVar(&c.ACMEEABHMACKey, "acme-eab-hmac-key", c.ACMEEABHMACKey, "base64url HMAC key for acme_eab_key_id")
+	fs.StringVar(&c.ACMEFallbackDirectory, "acme-fallback-directory", c.ACMEFallbackDirectory, "directory URL of a second ACME CA for hosts the first keeps failing to issue for")
+	fs.BoolVar(&c.ACMECAACheck, "acme-caa-check", c.ACMECAACheck, "check hosts' CAA records before ordering and refuse those the CA may not issue for")
+	fs.StringVar(&c.ACMECAAIdentities, "acme-caa-identities", c.ACMECAAIdentities, "comma-separated issuer domains acme_directory is named by in CAA records, if it's not a well-known CA")
+	fs.StringVar(&c.ACMEFallbackEABKeyID, "acme-fallback-eab-key-id", c.ACMEFallbackEABKeyID, "external account binding key ID for acme_fallback_directory")
+	fs.StringVar(&c.ACMEFallbackEABHMACKey, "acme-fallback-eab-hmac-key", c.ACMEFallbackEABHMACKey, "base64url HMAC key for acme_fallback_eab_key_id")
+	fs.StringVar(&c.ACMEDNSProvider, "acme-dns-provider", c.ACMEDNSProvider, "DNS provider answering DNS-01 challenges for acme_dns_domains: "+strings.Join(dnsprovider.Names, ", "))
+	fs.StringVar(&c.ACMEDNSCredentials, "acme-dns-credentials", c.ACMEDNSCredentials, "API token for acme_dns_provider, or ACCESS_KEY_ID:SECRET_ACCESS_KEY for route53")
+	fs.StringVar(&c.ACMEDNSDomains, "acme-dns-domains", c.ACMEDNSDomains, "comma-separated names, such as *.example.com, whose certificates use DNS-01 challenges")
+	fs.BoolVar(&c.ACMEDNSWildcards, "acme-dns-wildcards", c.ACMEDNSWildcards, "give every served host's apex one DNS-01 wildcard certificate instead of a certificate per host")
+	fs.DurationVar(&c.ACMEDNSPropagation, "acme-dns-propagation", c.ACMEDNSPropagation, "how long to wait for a DNS-01 challenge record to appear in DNS")
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "address for HTTP and ACME challenges when cert_dir is set")
+	fs.StringVar(&c.HTTPSAddr, "https-addr", c.HTTPSAddr, "address for HTTPS when cert_dir is set")
+	fs.StringVar(&c.HTTP3Addr, "http3-addr", c.HTTP3Addr, "UDP address for HTTP/3 when cert_dir is set; empty disables HTTP/3")
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "oldest TLS version accepted: 1.2 or 1.3")
+	fs.StringVar(&c.TLSCipherSuites, "tls-cipher-suites", c.TLSCipherSuites, "comma-separated TLS 1.2 cipher suites, as Go names them, in place of Go's defaults")
+	fs.StringVar(&c.TLSCurves, "tls-curves", c.TLSCurves, "comma-separated key exchanges in order of preference: X25519MLKEM768, X25519, P-256, P-384, P-521")
+	fs.DurationVar(&c.TLSTicketRotation, "tls-session-ticket-rotation", c.TLSTicketRotation, "how often session tickets get a new key; 0 leaves rotation to Go (daily)")
+	fs.BoolVar(&c.DevTLS, "dev-tls", c.DevTLS, "serve HTTPS on https_addr with certificates made on the fly for any host, for local development; never in production")
+	fs.BoolVar(&c.BehindProxy, "behind-proxy", c.BehindProxy, "serve plain HTTP on port for a TLS-terminating proxy or CDN, believing its forwarded headers from trusted_proxies or, if unset, loopback and private peers")
+	fs.StringVar(&c.Serverless, "serverless", c.Serverless, "lambda to serve AWS Lambda invocations from API Gateway or function URLs instead of listening")
+	fs.StringVar(&c.DevTLSCA, "dev-tls-ca", c.DevTLSCA, "directory of a local CA's rootCA.pem and rootCA-key.pem, such as mkcert -CAROOT's, to sign dev_tls certificates with instead of self-signing")
+	fs.StringVar(&c.FallbackURL, "fallback-url", c.FallbackURL, "where to send requests that have no matching rule")
+	fs.StringVar(&c.CanonicalHost, "canonical-host", c.CanonicalHost, "the service's own hostname, which gets the homepage and check API instead of redirects")
+	fs.BoolVar(&c.FallbackPage, "fallback-page", c.FallbackPage, "serve a 404 page with setup instructions instead of redirecting to fallback_url")
+	fs.StringVar(&c.LinkPreviews, "link-previews", c.LinkPreviews, "off, txt (og: records) or fetch (og: records, else the destination's metadata): answer link unfurling bots with a preview page")
+	fs.BoolVar(&c.QRCodes, "qr-codes", c.QRCodes, "serve QR codes of each host's links at /_redirect/qr")
+	fs.BoolVar(&c.ForceHTTPS, "force-https", c.ForceHTTPS, "redirect plain-HTTP requests to HTTPS before applying rules; hosts opt out with https=off")
+	fs.DurationVar(&c.HSTSMaxAge, "hsts-max-age", c.HSTSMaxAge, "Strict-Transport-Security max-age for hosts redirected to HTTPS; 0 sends none")
+	fs.StringVar(&c.WebFinger, "webfinger", c.WebFinger, "redirect or proxy /.well-known/webfinger queries for hosts with a webfinger= record")
+	fs.StringVar(&c.ShadowMatcher, "shadow-matcher", c.ShadowMatcher, "matching engine evaluated alongside the serving one, logging where they diverge: "+shadowMatcherNames())
+	fs.StringVar(&c.TemplatesDir, "templates-dir", c.TemplatesDir, "directory of .html templates overriding the built-in pages")
+	fs.BoolVar(&c.Maintenance, "maintenance", c.Maintenance, "start in maintenance mode, answering redirects with the maintenance page until it's turned off at the admin listener")
+	fs.DurationVar(&c.MaintenanceRetry, "maintenance-retry-after", c.MaintenanceRetry, "Retry-After sent with the maintenance page; 0 sends none")
+	fs.StringVar(&c.AdminAddr, "admin-addr", c.AdminAddr, "address for the admin listener (metrics); keep it private")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "address for the gRPC control API, which only clients with a certificate from grpc_client_ca may call")
+	fs.StringVar(&c.GRPCCert, "grpc-cert", c.GRPCCert, "PEM certificate chain file the gRPC control API serves")
+	fs.StringVar(&c.GRPCKey, "grpc-key", c.GRPCKey, "PEM key file of grpc_cert")
+	fs.StringVar(&c.GRPCClientCA, "grpc-client-ca", c.GRPCClientCA, "PEM file of the CA certificates gRPC control API clients' certificates must be signed by")
+	fs.StringVar(&c.DebugAddr, "debug-addr", c.DebugAddr, "loopback address for the pprof and expvar listener, e.g. 127.0.0.1:6060")
+	fs.StringVar(&c.Sources, "sources", c.Sources, "comma-separated config sources in precedence order: "+strings.Join(knownSources, ", "))
+	fs.StringVar(&c.DoHURL, "doh-url", c.DoHURL, "DNS-over-HTTPS endpoint used instead of the system resolver")
+	fs.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "how long DNS lookups are cached; 0 disables caching")
+	fs.DurationVar(&c.NegativeCacheTTL, "negative-cache-ttl", c.NegativeCacheTTL, "how long DNS lookups finding no redirect rules are cached, when cache_ttl is set")
+	fs.StringVar(&c.SharedCache, "shared-cache", c.SharedCache, "redis:// or rediss:// URL of a cache of DNS lookups shared between replicas, which purges propagate through")
+	fs.StringVar(&c.CacheFile, "cache-file", c.CacheFile, "file the DNS lookup cache is saved to on shutdown and loaded from on start")
+	fs.StringVar(&c.RedirectsFile, "redirects-file", c.RedirectsFile, "YAML or JSON file mapping hosts to redirect records")
+	fs.StringVar(&c.StaticRedirects, "static-redirects", c.StaticRedirects, "comma-separated host=target overrides that bypass DNS")
+	fs.StringVar(&c.AllowedSchemes, "allowed-schemes", c.AllowedSchemes, "comma-separated URL schemes redirect targets may use")
+	fs.StringVar(&c.IgnoredPaths, "ignored-paths", c.IgnoredPaths, "comma-separated paths, with at most one * each, never redirected on any host")
+	fs.IntVar(&c.IgnoredStatus, "ignored-status", c.IgnoredStatus, "status of ignored paths: 404 or 204")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, "comma-separated origins, or *, whose scripts may fetch through redirects; hosts override with cors=")
+	fs.IntVar(&c.LoopHops, "loop-hops", c.LoopHops, "how many redirects to follow looking for loops back to this server; 0 disables the check")
+	fs.StringVar(&c.AccessLog, "access-log", c.AccessLog, "where JSON access logs go: stdout, stderr, syslog, journald, a file path, or off")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "least severe access log entries to write: debug, info, warn or error")
+	fs.StringVar(&c.ClientIPLogging, "client-ip-logging", c.ClientIPLogging, "how client IPs are logged: full, truncate (to /24 or /48), hash, or off")
+	fs.StringVar(&c.IPHashKey, "ip-hash-key", c.IPHashKey, "secret keying client_ip_logging=hash; random per process if empty")
+	fs.StringVar(&c.ErrorLog, "error-log", c.ErrorLog, "where server logs go: stdout, stderr, syslog, journald or a file path")
+	fs.IntVar(&c.LogMaxSize, "log-max-size", c.LogMaxSize, "megabytes a log file reaches before it is rotated; 0 disables")
+	fs.DurationVar(&c.LogMaxAge, "log-max-age", c.LogMaxAge, "how long a log file is written before it is rotated; 0 disables")
+	fs.IntVar(&c.LogMaxBackups, "log-max-backups", c.LogMaxBackups, "rotated log files to keep; 0 keeps all")
+	fs.StringVar(&c.ErrorDSN, "error-dsn", c.ErrorDSN, "Sentry DSN, or a webhook URL, that panics and certificate failures are reported to")
+	fs.StringVar(&c.EventWebhook, "event-webhook", c.EventWebhook, "URL each redirect is forwarded to as an analytics event")
+	fs.StringVar(&c.EventWebhookSecret, "event-webhook-secret", c.EventWebhookSecret, "key event_webhook requests are signed with, in an X-Redirect-Signature HMAC-SHA256 header")
+	fs.StringVar(&c.EventFormat, "event-format", c.EventFormat, "json (batches of events), plausible (Plausible Events API) or ga4 (GA4 Measurement Protocol)")
+	fs.StringVar(&c.EventStream, "event-stream", c.EventStream, "kafka://brokers/topic or nats://host/subject each redirect is published to as a JSON event")
+	fs.IntVar(&c.EventBatchSize, "event-batch-size", c.EventBatchSize, "events sent together to a json event_webhook or event_stream")
+	fs.DurationVar(&c.EventFlushInterval, "event-flush-interval", c.EventFlushInterval, "longest time events wait before being sent")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "OpenTelemetry collector to export traces to over OTLP/HTTP, e.g. http://localhost:4318; empty disables tracing")
+	fs.StringVar(&c.OTLPHeaders, "otlp-headers", c.OTLPHeaders, "comma-separated key=value headers sent with trace exports")
+	fs.Float64Var(&c.TraceSampleRatio, "trace-sample-ratio", c.TraceSampleRatio, "fraction of new traces recorded, from 0 to 1")
+	fs.BoolVar(&c.SourceHeader, "source-header", c.SourceHeader, "add an X-Redirect-Source header naming the config source")
+	fs.BoolVar(&c.ServerTiming, "server-timing", c.ServerTiming, "add a Server-Timing header with the lookup and total handling durations")
+	fs.StringVar(&c.RequestIDHeader, "request-id-header", c.RequestIDHeader, "header carrying each request's ID, honored if a proxy set it and echoed in responses; empty disables request IDs")
+	fs.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "comma-separated CIDRs of proxies whose X-Forwarded-* headers are honored")
+	fs.StringVar(&c.AllowedHosts, "allowed-hosts", c.AllowedHosts, "comma-separated hostnames or suffix patterns (*.example.com, .example.com) to serve; empty serves any")
+	fs.StringVar(&c.DeniedHosts, "denied-hosts", c.DeniedHosts, "comma-separated hostnames or suffix patterns never to serve")
+	fs.StringVar(&c.ProxyProtocol, "proxy-protocol", c.ProxyProtocol, "comma-separated listeners (http, https, admin) that expect a PROXY protocol header")
+	fs.Float64Var(&c.RateLimit, "rate-limit", c.RateLimit, "requests per second allowed from each client IP; 0 disables the limit")
+	fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", c.RateLimitBurst, "requests a client IP may make at once before rate_limit applies")
+	fs.Float64Var(&c.HostRateLimit, "host-rate-limit", c.HostRateLimit, "requests per second allowed for each served hostname; 0 disables host quotas")
+	fs.IntVar(&c.HostRateLimitBurst, "host-rate-limit-burst", c.HostRateLimitBurst, "requests a hostname may get at once before host_rate_limit applies")
+	fs.IntVar(&c.HostSuspendAfter, "host-suspend-after", c.HostSuspendAfter, "throttled requests within a minute that suspend a hostname; 0 never suspends")
+	fs.DurationVar(&c.HostSuspendFor, "host-suspend-for", c.HostSuspendFor, "how long a hostname stays suspended")
+	fs.IntVar(&c.MaxInflight, "max-inflight", c.MaxInflight, "requests handled at once, past which more are answered 503; 0 is unlimited")
+	fs.IntVar(&c.MaxDNSLookups, "max-dns-lookups", c.MaxDNSLookups, "DNS lookups in flight at once, past which requests needing one are answered 503; 0 is unlimited")
+	fs.IntVar(&c.MaxHostRules, "max-host-rules", c.MaxHostRules, "rules a host may have, past which its requests get the fallback; 0 is unlimited")
+	fs.IntVar(&c.MaxPatternLength, "max-pattern-length", c.MaxPatternLength, "bytes a rule's from pattern may have, past which its host's requests get the fallback; 0 is unlimited")
+	fs.IntVar(&c.AnalyticsMaxHosts, "analytics-max-hosts", c.AnalyticsMaxHosts, "hosts (and rules) counted for the admin API's top hosts; 0 disables")
+	fs.StringVar(&c.AnalyticsDir, "analytics-dir", c.AnalyticsDir, "directory for hourly request counts per host, path and status, exported by the admin API")
+	fs.DurationVar(&c.AnalyticsRetention, "analytics-retention", c.AnalyticsRetention, "how long hourly counts in analytics_dir are kept; 0 keeps them forever")
+	fs.StringVar(&c.AnalyticsBots, "analytics-bots", c.AnalyticsBots, "exclude or include requests from bots in top hosts, analytics_dir and redirect events")
+	fs.StringVar(&c.BotNetworksFile, "bot-networks-file", c.BotNetworksFile, "file of crawler IP ranges, one per line or in Google's JSON format, whose requests are classified as bots")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token required by the admin API")
+	fs.StringVar(&c.AdminAuthFile, "admin-auth-file", c.AdminAuthFile, "YAML file of the admin APIs' callers, each with a token or client certificate name, and the scopes they may use")
+	fs.StringVar(&c.AdminCert, "admin-cert", c.AdminCert, "PEM certificate chain file, to serve the admin listener over TLS")
+	fs.StringVar(&c.AdminKey, "admin-key", c.AdminKey, "PEM key file of admin_cert")
+	fs.StringVar(&c.AdminClientCA, "admin-client-ca", c.AdminClientCA, "PEM file of the CA certificates admin listener clients may authenticate with certificates from")
+	fs.StringVar(&c.StatsdAddr, "statsd-addr", c.StatsdAddr, "UDP address of a statsd or DogStatsD agent to push metrics to, e.g. 127.0.0.1:8125")
+	fs.StringVar(&c.StatsdFormat, "statsd-format", c.StatsdFormat, "statsd (labels in metric names) or dogstatsd (labels as tags)")
+	fs.StringVar(&c.StatsdPrefix, "statsd-prefix", c.StatsdPrefix, "prefix for statsd metric names")
+	fs.DurationVar(&c.StatsdInterval, "statsd-interval", c.StatsdInterval, "how often counters and gauges are pushed to statsd")
+	fs.StringVar(&c.BlocklistFile, "blocklist-file", c.BlocklistFile, "file of destination domains and URLs to refuse, one per line")
+	fs.StringVar(&c.BlocklistURL, "blocklist-url", c.BlocklistURL, "URL of a blocklist feed, such as a phishing domain list, merged with blocklist_file")
+	fs.DurationVar(&c.BlocklistRefresh, "blocklist-refresh", c.BlocklistRefresh, "how often blocklist_file and blocklist_url are reloaded")
+	fs.IntVar(&c.BlocklistStatus, "blocklist-status", c.BlocklistStatus, "status for blocked redirects: 410 or 451")
+	fs.StringVar(&c.SafeBrowsingAPIKey, "safe-browsing-api-key", c.SafeBrowsingAPIKey, "Google Safe Browsing API key; enables checking destinations")
+	fs.StringVar(&c.SafeBrowsingAction, "safe-browsing-action", c.SafeBrowsingAction, "what to do with flagged destinations: warn (interstitial) or block")
+	fs.DurationVar(&c.SafeBrowsingWait, "safe-browsing-wait", c.SafeBrowsingWait, "how long a redirect waits for an uncached Safe Browsing verdict before going ahead")
+	fs.DurationVar(&c.SafeBrowsingCacheTTL, "safe-browsing-cache-ttl", c.SafeBrowsingCacheTTL, "how long Safe Browsing verdicts are cached")
+	fs.BoolVar(&c.AbuseReports, "abuse-reports", c.AbuseReports, "let visitors report malicious destinations at /_redirect/report")
+	fs.IntVar(&c.AbuseReportThreshold, "abuse-report-threshold", c.AbuseReportThreshold, "distinct networks whose reports of a host's links to a destination put a warning before them until they're reviewed; 0 leaves warnings to operators")
+	fs.StringVar(&c.AbuseReportsFile, "abuse-reports-file", c.AbuseReportsFile, "file abuse reports and takedowns are kept in across restarts")
+	fs.BoolVar(&c.H2C, "h2c", c.H2C, "accept HTTP/2 without TLS (h2c) on plain HTTP listeners")
+	fs.IntVar(&c.HTTP2MaxStreams, "http2-max-streams", c.HTTP2MaxStreams, "maximum concurrent HTTP/2 streams per connection; 0 uses the Go default (250)")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "how long idle keep-alive and HTTP/2 connections stay open; 0 uses read_timeout")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "how long a public listener waits to read a request, body included; 0 waits forever")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", c.ReadHeaderTimeout, "how long a public listener waits to read a request's headers; 0 uses read_timeout")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "how long a public listener takes to write a response, from the end of its request's headers; 0 waits forever")
+	fs.DurationVar(&c.HandlerTimeout, "handler-timeout", c.HandlerTimeout, "how long a redirect may take to decide, cancelling its lookups and checks and answering 503 past it; 0 is unlimited")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "maximum size of a request's headers; 0 uses the Go default (1 MB)")
+	fs.BoolVar(&c.ReusePort, "reuse-port", c.ReusePort, "set SO_REUSEPORT so several processes can share the listening ports (Linux only)")
+	fs.DurationVar(&c.UpgradeTimeout, "upgrade-timeout", c.UpgradeTimeout, "how long a zero-downtime restart waits for the new process")
+	fs.DurationVar(&c.DrainTimeout, "drain-timeout", c.DrainTimeout, "how long shutdown waits for requests in flight to finish, then for queued events to be sent")
+	fs.DurationVar(&c.DrainDelay, "drain-delay", c.DrainDelay, "how long shutdown answers /readyz with 503 before closing the listeners, for load balancers to stop sending requests")
+	return fs
+}
+
+// loadConfig builds the configuration from the config file (named by
+// -config or CONFIG_FILE), the environment and args, then validates it.
+func loadConfig(args []string, getenv func(string) string) (*config, error) {
+	c := defaultConfig()
+	fs := c.flagSet()
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "YAML config file")
+	fs.BoolVar(&c.validateOnly, "validate", false, "check the configuration, and that its listeners, cert_dir, sources and blocklist are usable, then exit")
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.Usage()
+		}
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	fromFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { fromFlags[f.Name] = f.Value.String() })
+
+	if *configFile != "" {
+		if err := applyConfigFile(fs, *configFile); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "validate" || err != nil {
+			return
+		}
+		name := envName(f.Name)
+		v := getenv(name)
+		if secretSettings[f.Name] {
+			if v, err = secretEnv(getenv, name); err != nil {
+				return
+			}
+		}
+		if v != "" {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid %s %q: %v", name, v, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range fromFlags {
+		fs.Set(name, v)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// secretSettings are the settings, by flag name, that can hold credentials.
+// Each can also be read from the file named by its environment variable
+// with _FILE appended, such as ADMIN_TOKEN_FILE, so that a Docker or
+// Kubernetes secret can be mounted rather than put in the environment.
+var secretSettings = map[string]bool{
+	"admin-token":                true,
+	"acme-eab-hmac-key":          true,
+	"acme-fallback-eab-hmac-key": true,
+	"acme-dns-credentials":       true,
+	"cert-cache":                 true,
+	"shared-cache":               true,
+	"ip-hash-key":                true,
+	"error-dsn":                  true,
+	"event-webhook":              true,
+	"event-webhook-secret":       true,
+	"event-stream":               true,
+	"otlp-headers":               true,
+	"safe-browsing-api-key":      true,
+}
+
+// secretEnv returns the value of the environment variable name, holding a
+// secret setting, or else the contents of the file name_FILE names. It's an
+// error for both to be set.
+func secretEnv(getenv func(string) string, name string) (string, error) {
+	v, path := getenv(name), getenv(name+"_FILE")
+	if path == "" {
+		return v, nil
+	}
+	if v != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set", name, name)
+	}
+	v, err := readSecretFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return v, nil
+}
+
+// readSecretFile returns the contents of the secret file at path, without
+// the trailing newline editors and echo leave.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// applyConfigFile sets flags from the keys of a YAML file. Lists are joined
+// with commas so they read like their environment variable equivalents.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	for key, value := range values {
+		name := strings.ReplaceAll(key, "_", "-")
+		if name == "config" || name == "validate" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		var v string
+		switch value := value.(type) {
+		case []any:
+			parts := make([]string, len(value))
+			for i, part := range value {
+				parts[i] = fmt.Sprint(part)
+			}
+			v = strings.Join(parts, ",")
+		case map[string]any:
+			return fmt.Errorf("%s: setting %q must be a scalar or list", path, key)
+		default:
+			v = fmt.Sprint(value)
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %v", path, key, v, err)
+		}
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// sourceNames returns the configured sources in precedence order, applying
+// the defaults described in newSources.
+func (c *config) sourceNames() []string {
+	var names []string
+	for _, name := range strings.Split(c.Sources, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{"dns"}
+		if c.RedirectsFile != "" {
+			names = []string{"file", "dns"}
+		}
+	}
+	if c.StaticRedirects != "" && !slices.Contains(names, "env") {
+		names = append([]string{"env"}, names...)
+	}
+	return names
+}
+
+// proxyProtocolListeners returns the listener names in proxy_protocol.
+func (c *config) proxyProtocolListeners() []string {
+	var names []string
+	for _, name := range strings.Split(c.ProxyProtocol, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// canonicalHost returns canonical_host as redirect.ParseHost returns it, or
+// "" if it is unset.
+func (c *config) canonicalHost() string {
+	host, _ := redirect.ParseHost(c.CanonicalHost)
+	return host
+}
+
+// allowedSchemes returns the schemes in allowed_schemes, lowercased.
+func (c *config) allowedSchemes() []string {
+	var schemes []string
+	for _, scheme := range strings.Split(c.AllowedSchemes, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+// certPrewarm returns the hosts in cert_prewarm, lowercased.
+func (c *config) certPrewarm() []string {
+	var hosts []string
+	for _, h := range strings.Split(c.CertPrewarm, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// acmeCAAIdentities returns the issuer domains in acme_caa_identities,
+// lowercased.
+func (c *config) acmeCAAIdentities() []string {
+	var ids []string
+	for _, id := range strings.Split(c.ACMECAAIdentities, ",") {
+		if id = strings.ToLower(strings.TrimSpace(id)); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// acmeDNSDomains returns the names in acme_dns_domains, lowercased.
+func (c *config) acmeDNSDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(c.ACMEDNSDomains, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// ignoredPaths returns the patterns in ignored_paths.
+func (c *config) ignoredPaths() []string {
+	var paths []string
+	for _, p := range strings.Split(c.IgnoredPaths, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// otlpHeaders returns the headers in otlp_headers, skipping entries that
+// don't parse (validate reports them).
+func (c *config) otlpHeaders() map[string]string {
+	headers, _ := parseHeaders(c.OTLPHeaders)
+	return headers
+}
+
+func parseHeaders(v string) (map[string]string, error) {
+	headers := make(map[string]string)
+	var firstErr error
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(s, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%q is not key=value", s)
+			}
+			continue
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, firstErr
+}
+
+// trustedProxies returns the networks listed in trusted_proxies. Bare
+// addresses are single-host networks; entries that don't parse are skipped
+// (validate reports them). With behind_proxy and none listed, peers on
+// loopback and private networks are, where a proxy in front of the
+// service would usually be, but not the public ones.
+func (c *config) trustedProxies() []netip.Prefix {
+	prefixes, _ := parsePrefixes(c.TrustedProxies)
+	if c.BehindProxy && len(prefixes) == 0 {
+		return privateNetworks
+	}
+	return prefixes
+}
+
+// privateNetworks are the loopback and private address ranges.
+var privateNetworks = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+func parsePrefixes(v string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	var firstErr error
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		var p netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			p, err = netip.ParsePrefix(s)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(s)
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, firstErr
+}
+
+// validate reports the first setting with an unusable value.
+func (c *config) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", c.Port)
+	}
+	if c.CanonicalHost != "" {
+		if _, err := redirect.ParseHost(c.CanonicalHost); err != nil {
+			return fmt.Errorf("canonical_host %q: %w", c.CanonicalHost, err)
+		}
+	}
+	if _, err := redirect.LoadPages(c.TemplatesDir); err != nil {
+		return fmt.Errorf("templates_dir: %w", err)
+	}
+	if err := validateURL("fallback_url", c.FallbackURL); err != nil {
+		return err
+	}
+	if err := validateURL("doh_url", c.DoHURL); err != nil {
+		return err
+	}
+	if err := validateAddr("http_addr", c.HTTPAddr); err != nil {
+		return err
+	}
+	if err := validateAddr("https_addr", c.HTTPSAddr); err != nil {
+		return err
+	}
+	if c.CertCache != "" {
+		if c.CertDir == "" {
+			return fmt.Errorf("cert_cache requires cert_dir")
+		}
+		if _, err := certcache.New(c.CertCache); err != nil {
+			return fmt.Errorf("cert_cache: %v", err)
+		}
+	}
+	if (c.CertPrewarm != "" || c.CertPrewarmFile != "") && c.CertDir == "" {
+		return fmt.Errorf("cert_prewarm requires cert_dir")
+	}
+	for _, host := range c.certPrewarm() {
+		if !validPrewarmHost(host) {
+			return fmt.Errorf("cert_prewarm: %q is not a host name", host)
+		}
+	}
+	if c.CertPrewarmEvery <= 0 {
+		return fmt.Errorf("cert_prewarm_every must be positive")
+	}
+	if c.StaticCertsDir != "" && c.CertDir == "" {
+		return fmt.Errorf("static_certs_dir requires cert_dir")
+	}
+	if c.ACMEDirectory == "" {
+		return fmt.Errorf("acme_directory must be set")
+	}
+	if err := validateURL("acme_directory", c.ACMEDirectory); err != nil {
+		return err
+	}
+	if c.ACMEEmail != "" {
+		if addr, err := mail.ParseAddress(c.ACMEEmail); err != nil || addr.Address != c.ACMEEmail {
+			return fmt.Errorf("acme_email %q is not an email address", c.ACMEEmail)
+		}
+	}
+	if c.ACMEKeyType != keyTypeECDSA && c.ACMEKeyType != keyTypeRSA {
+		return fmt.Errorf("acme_key_type %q must be %s or %s", c.ACMEKeyType, keyTypeECDSA, keyTypeRSA)
+	}
+	if (c.ACMEEABKeyID == "") != (c.ACMEEABHMACKey == "") {
+		return fmt.Errorf("acme_eab_key_id and acme_eab_hmac_key must be set together")
+	}
+	if _, err := c.acmeEAB(); err != nil {
+		return err
+	}
+	for _, id := range c.acmeCAAIdentities() {
+		if !validDNSDomain(id) {
+			return fmt.Errorf("acme_caa_identities: %q is not a domain name", id)
+		}
+	}
+	if err := validateURL("acme_fallback_directory", c.ACMEFallbackDirectory); err != nil {
+		return err
+	}
+	if c.ACMEFallbackDirectory == c.ACMEDirectory {
+		return fmt.Errorf("acme_fallback_directory must differ from acme_directory")
+	}
+	if (c.ACMEFallbackEABKeyID == "") != (c.ACMEFallbackEABHMACKey == "") {
+		return fmt.Errorf("acme_fallback_eab_key_id and acme_fallback_eab_hmac_key must be set together")
+	}
+	if c.ACMEFallbackEABKeyID != "" && c.ACMEFallbackDirectory == "" {
+		return fmt.Errorf("acme_fallback_eab_key_id requires acme_fallback_directory")
+	}
+	if _, err := c.acmeFallbackEAB(); err != nil {
+		return err
+	}
+	if c.ACMEDNSProvider != "" {
+		if c.CertDir == "" {
+			return fmt.Errorf("acme_dns_provider requires cert_dir")
+		}
+		if _, err := dnsprovider.New(c.ACMEDNSProvider, c.ACMEDNSCredentials); err != nil {
+			return fmt.Errorf("acme_dns_provider: %v", err)
+		}
+		if len(c.acmeDNSDomains()) == 0 && !c.ACMEDNSWildcards {
+			return fmt.Errorf("acme_dns_provider requires acme_dns_domains or acme_dns_wildcards")
+		}
+		for _, d := range c.acmeDNSDomains() {
+			if !validDNSDomain(d) {
+				return fmt.Errorf("acme_dns_domains: %q is not a host name or *.<host name>", d)
+			}
+		}
+		if c.ACMEDNSPropagation <= 0 {
+			return fmt.Errorf("acme_dns_propagation must be positive")
+		}
+	}
+	if c.ACMEDNSWildcards && c.ACMEDNSProvider == "" {
+		return fmt.Errorf("acme_dns_wildcards requires acme_dns_provider")
+	}
+	if _, err := newTLSPolicy(c); err != nil {
+		return err
+	}
+	if c.DevTLS && c.CertDir != "" {
+		return fmt.Errorf("dev_tls and cert_dir can't both be set")
+	}
+	if c.BehindProxy && (c.CertDir != "" || c.DevTLS) {
+		return fmt.Errorf("behind_proxy can't be set with cert_dir or dev_tls")
+	}
+	switch {
+	case c.Serverless != "" && c.Serverless != "lambda":
+		return fmt.Errorf("serverless must be lambda or empty")
+	case c.Serverless != "" && (c.CertDir != "" || c.DevTLS || c.BehindProxy):
+		return fmt.Errorf("serverless can't be set with cert_dir, dev_tls or behind_proxy: the platform terminates TLS")
+	}
+	if c.DevTLSCA != "" && !c.DevTLS {
+		return fmt.Errorf("dev_tls_ca requires dev_tls")
+	}
+	if c.HTTP3Addr != "" {
+		if c.CertDir == "" && !c.DevTLS {
+			return fmt.Errorf("http3_addr requires cert_dir or dev_tls")
+		}
+		if err := validateAddr("http3_addr", c.HTTP3Addr); err != nil {
+			return err
+		}
+	}
+	if c.AdminAddr != "" {
+		if err := validateAddr("admin_addr", c.AdminAddr); err != nil {
+			return err
+		}
+		if !isLoopbackAddr(c.AdminAddr) && c.AdminToken == "" && c.AdminAuthFile == "" && c.AdminClientCA == "" {
+			return fmt.Errorf("admin_addr %q must be a loopback address such as 127.0.0.1:9090 unless admin_token, admin_auth_file or admin_client_ca is set", c.AdminAddr)
+		}
+	}
+	if (c.AdminCert == "") != (c.AdminKey == "") {
+		return fmt.Errorf("admin_cert and admin_key must be set together")
+	}
+	if c.AdminClientCA != "" && c.AdminCert == "" {
+		return fmt.Errorf("admin_client_ca requires admin_cert")
+	}
+	if c.GRPCAddr != "" {
+		if err := validateAddr("grpc_addr", c.GRPCAddr); err != nil {
+			return err
+		}
+		if c.GRPCCert == "" || c.GRPCKey == "" || c.GRPCClientCA == "" {
+			return fmt.Errorf("grpc_addr requires grpc_cert, grpc_key and grpc_client_ca")
+		}
+	}
+	if c.DebugAddr != "" && !isLoopbackAddr(c.DebugAddr) {
+		return fmt.Errorf("debug_addr %q must be a loopback address such as 127.0.0.1:6060", c.DebugAddr)
+	}
+	if c.CacheTTL < 0 || c.NegativeCacheTTL < 0 {
+		return fmt.Errorf("cache_ttl and negative_cache_ttl must not be negative")
+	}
+	if c.SharedCache != "" {
+		if c.CacheTTL == 0 {
+			return fmt.Errorf("shared_cache requires cache_ttl")
+		}
+		if _, _, err := parseSharedCache(c.SharedCache); err != nil {
+			return fmt.Errorf("shared_cache: %v", err)
+		}
+	}
+	if c.CacheFile != "" && c.CacheTTL == 0 {
+		return fmt.Errorf("cache_file requires cache_ttl")
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	if c.RateLimit > 0 && c.RateLimitBurst < 1 {
+		return fmt.Errorf("rate_limit_burst must be at least 1")
+	}
+	if c.HostRateLimit < 0 {
+		return fmt.Errorf("host_rate_limit must not be negative")
+	}
+	if c.HostRateLimit > 0 && c.HostRateLimitBurst < 1 {
+		return fmt.Errorf("host_rate_limit_burst must be at least 1")
+	}
+	if c.HostSuspendAfter < 0 || c.HostSuspendFor < 0 {
+		return fmt.Errorf("host_suspend_after and host_suspend_for must not be negative")
+	}
+	if c.MaxInflight < 0 || c.MaxDNSLookups < 0 {
+		return fmt.Errorf("max_inflight and max_dns_lookups must not be negative")
+	}
+	if c.MaxHostRules < 0 || c.MaxPatternLength < 0 {
+		return fmt.Errorf("max_host_rules and max_pattern_length must not be negative")
+	}
+	if !slices.Contains([]string{"off", "txt", "fetch"}, c.LinkPreviews) {
+		return fmt.Errorf("link_previews must be off, txt or fetch, not %q", c.LinkPreviews)
+	}
+	for _, p := range c.ignoredPaths() {
+		if !strings.HasPrefix(p, "/") || strings.Count(p, "*") > 1 {
+			return fmt.Errorf("ignored_paths: %q is not a path with at most one *", p)
+		}
+	}
+	if c.IgnoredStatus != http.StatusNotFound && c.IgnoredStatus != http.StatusNoContent {
+		return fmt.Errorf("ignored_status must be 404 or 204, not %d", c.IgnoredStatus)
+	}
+	if c.MaintenanceRetry < 0 {
+		return fmt.Errorf("maintenance_retry_after must not be negative")
+	}
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("hsts_max_age must not be negative")
+	}
+	if _, err := redirect.ParseOrigins(c.CORSOrigins); err != nil {
+		return fmt.Errorf("cors_origins: %v", err)
+	}
+	if c.WebFinger != "redirect" && c.WebFinger != "proxy" {
+		return fmt.Errorf("webfinger must be redirect or proxy, not %q", c.WebFinger)
+	}
+	if _, ok := shadowMatchers[c.ShadowMatcher]; c.ShadowMatcher != "" && !ok {
+		return fmt.Errorf("shadow_matcher must be one of %s, not %q", shadowMatcherNames(), c.ShadowMatcher)
+	}
+	if c.AnalyticsMaxHosts < 0 {
+		return fmt.Errorf("analytics_max_hosts must not be negative")
+	}
+	if c.AnalyticsRetention < 0 {
+		return fmt.Errorf("analytics_retention must not be negative")
+	}
+	if c.AnalyticsBots != "exclude" && c.AnalyticsBots != "include" {
+		return fmt.Errorf("analytics_bots must be exclude or include, not %q", c.AnalyticsBots)
+	}
+	if err := validateURL("blocklist_url", c.BlocklistURL); err != nil {
+		return err
+	}
+	if c.BlocklistRefresh <= 0 {
+		return fmt.Errorf("blocklist_refresh must be positive")
+	}
+	if c.BlocklistStatus != http.StatusGone && c.BlocklistStatus != http.StatusUnavailableForLegalReasons {
+		return fmt.Errorf("blocklist_status must be 410 or 451, not %d", c.BlocklistStatus)
+	}
+	for _, scheme := range c.allowedSchemes() {
+		if !schemeRE.MatchString(scheme) {
+			return fmt.Errorf("allowed_schemes: %q is not a URL scheme", scheme)
+		}
+	}
+	if _, err := parseHostPatterns(c.AllowedHosts); err != nil {
+		return fmt.Errorf("invalid allowed_hosts: %w", err)
+	}
+	if _, err := parseHostPatterns(c.DeniedHosts); err != nil {
+		return fmt.Errorf("invalid denied_hosts: %w", err)
+	}
+	if c.AccessLog == "" || c.ErrorLog == "" || c.ErrorLog == "off" {
+		return fmt.Errorf("access_log and error_log must be stdout, stderr, syslog, journald or a file path (access_log may also be off)")
+	}
+	if c.AccessLog == c.ErrorLog && isLogFile(c.AccessLog) {
+		return fmt.Errorf("access_log and error_log must not be the same file")
+	}
+	if c.LogMaxSize < 0 || c.LogMaxAge < 0 || c.LogMaxBackups < 0 {
+		return fmt.Errorf("log_max_size, log_max_age and log_max_backups must not be negative")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return fmt.Errorf("log_level must be debug, info, warn or error, not %q", c.LogLevel)
+	}
+	switch c.ClientIPLogging {
+	case "full", "truncate", "hash", "off":
+	default:
+		return fmt.Errorf("client_ip_logging must be full, truncate, hash or off, not %q", c.ClientIPLogging)
+	}
+	if err := validateURL("error_dsn", c.ErrorDSN); err != nil {
+		return err
+	}
+	if err := validateURL("event_webhook", c.EventWebhook); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"json", "plausible", "ga4"}, c.EventFormat) {
+		return fmt.Errorf("event_format must be json, plausible or ga4, not %q", c.EventFormat)
+	}
+	if err := validateEventWebhook(c.EventFormat, c.EventWebhook); err != nil {
+		return err
+	}
+	if c.EventWebhookSecret != "" && c.EventWebhook == "" {
+		return fmt.Errorf("event_webhook_secret requires event_webhook")
+	}
+	if c.EventStream != "" {
+		if _, err := stream.New(c.EventStream); err != nil {
+			return fmt.Errorf("event_stream: %w", err)
+		}
+	}
+	if c.EventBatchSize < 1 || c.EventFlushInterval <= 0 {
+		return fmt.Errorf("event_batch_size and event_flush_interval must be positive")
+	}
+	if err := validateURL("otlp_endpoint", c.OTLPEndpoint); err != nil {
+		return err
+	}
+	if _, err := parseHeaders(c.OTLPHeaders); err != nil {
+		return fmt.Errorf("invalid otlp_headers: %w", err)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return fmt.Errorf("trace_sample_ratio must be between 0 and 1")
+	}
+	if c.RequestIDHeader != "" && !httpguts.ValidHeaderFieldName(c.RequestIDHeader) {
+		return fmt.Errorf("request_id_header %q is not a header name", c.RequestIDHeader)
+	}
+	if c.StatsdAddr != "" {
+		if err := validateAddr("statsd_addr", c.StatsdAddr); err != nil {
+			return err
+		}
+	}
+	if c.StatsdFormat != "statsd" && c.StatsdFormat != "dogstatsd" {
+		return fmt.Errorf("statsd_format must be statsd or dogstatsd, not %q", c.StatsdFormat)
+	}
+	if c.StatsdInterval <= 0 {
+		return fmt.Errorf("statsd_interval must be positive")
+	}
+	if c.LoopHops < 0 {
+		return fmt.Errorf("loop_hops must not be negative")
+	}
+	if c.SafeBrowsingAction != "warn" && c.SafeBrowsingAction != "block" {
+		return fmt.Errorf("safe_browsing_action must be warn or block, not %q", c.SafeBrowsingAction)
+	}
+	if c.SafeBrowsingWait < 0 || c.SafeBrowsingCacheTTL <= 0 {
+		return fmt.Errorf("safe_browsing_wait must not be negative and safe_browsing_cache_ttl must be positive")
+	}
+	if c.AbuseReportThreshold < 0 {
+		return fmt.Errorf("abuse_report_threshold must not be negative")
+	}
+	if c.AbuseReportsFile != "" && !c.AbuseReports {
+		return fmt.Errorf("abuse_reports_file requires abuse_reports")
+	}
+	if c.HTTP2MaxStreams < 0 {
+		return fmt.Errorf("http2_max_streams must not be negative")
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout must not be negative")
+	}
+	if c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.HandlerTimeout < 0 {
+		return fmt.Errorf("read_timeout, read_header_timeout, write_timeout and handler_timeout must not be negative")
+	}
+	if c.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max_header_bytes must not be negative")
+	}
+	if c.UpgradeTimeout <= 0 {
+		return fmt.Errorf("upgrade_timeout must be positive")
+	}
+	if c.DrainTimeout <= 0 {
+		return fmt.Errorf("drain_timeout must be positive")
+	}
+	if c.DrainDelay < 0 {
+		return fmt.Errorf("drain_delay must not be negative")
+	}
+	if _, err := parsePrefixes(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted_proxies: %v", err)
+	}
+	for _, name := range c.proxyProtocolListeners() {
+		if name != "http" && name != "https" && name != "admin" {
+			return fmt.Errorf("unknown listener %q in proxy_protocol (want http, https, admin)", name)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range c.sourceNames() {
+		if !slices.Contains(knownSources, name) {
+			return fmt.Errorf("unknown source %q in sources (want %s)", name, strings.Join(knownSources, ", "))
+		}
+		if seen[name] {
+			return fmt.Errorf("sources lists %q more than once", name)
+		}
+		seen[name] = true
+	}
+	if seen["file"] && c.RedirectsFile == "" {
+		return fmt.Errorf("sources includes file but redirects_file is not set")
+	}
+	if seen["env"] {
+		if _, err := parseStaticRedirects(c.StaticRedirects); err != nil {
+			return fmt.Errorf("invalid static_redirects: %w", err)
+		}
+	}
+	return nil
+}
+
+// acmeEAB returns the external account binding acme_eab_key_id and
+// acme_eab_hmac_key give, or nil.
+func (c *config) acmeEAB() (*acme.ExternalAccountBinding, error) {
+	return parseEAB("acme_eab_hmac_key", c.ACMEEABKeyID, c.ACMEEABHMACKey)
+}
+
+// acmeFallbackEAB is acmeEAB for acme_fallback_directory.
+func (c *config) acmeFallbackEAB() (*acme.ExternalAccountBinding, error) {
+	return parseEAB("acme_fallback_eab_hmac_key", c.ACMEFallbackEABKeyID, c.ACMEFallbackEABHMACKey)
+}
+
+// parseEAB returns the binding with kid and the HMAC key hmac, or nil if
+// kid is empty. CAs hand out the key base64url-encoded, with or without
+// padding.
+func parseEAB(setting, kid, hmac string) (*acme.ExternalAccountBinding, error) {
+	if kid == "" {
+		return nil, nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(hmac, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("%s is not base64url", setting)
+	}
+	return &acme.ExternalAccountBinding{KID: kid, Key: key}, nil
+}
+
+func validateAddr(name, v string) error {
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		return fmt.Errorf("%s %q: %v", name, v, err)
+	}
+	return nil
+}
+
+func validateURL(name, v string) error {
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		return fmt.Errorf("%s %q: %v", name, v, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q must be an absolute http or https URL", name, v)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8081 || cfg.CacheTTL != time.Minute || cfg.CertDir != "" || cfg.HTTPAddr != ":80" || cfg.HTTPSAddr != ":443" {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+port: 9000
+cache_ttl: 5m
+fallback_url: https://file.example/
+sources: [dns, wellknown]
+source_header: true
+`), 0o644)
+
+	// File only.
+	cfg, err := loadConfig([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9000 || cfg.CacheTTL != 5*time.Minute || cfg.Sources != "dns,wellknown" || !cfg.SourceHeader {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+
+	// Environment beats the file; CONFIG_FILE names the file.
+	cfg, err = loadConfig(nil, env(map[string]string{
+		"CONFIG_FILE":  path,
+		"PORT":         "9001",
+		"FALLBACK_URL": "https://env.example/",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9001 || cfg.FallbackURL != "https://env.example/" || cfg.CacheTTL != 5*time.Minute {
+		t.Errorf("env values not applied over file: %+v", cfg)
+	}
+
+	// Flags beat both.
+	cfg, err = loadConfig([]string{"-config", path, "-port", "9002", "-cache-ttl", "0"}, env(map[string]string{"PORT": "9001"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9002 || cfg.CacheTTL != 0 || cfg.FallbackURL != "https://file.example/" {
+		t.Errorf("flag values not applied over env and file: %+v", cfg)
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin_token")
+	os.WriteFile(path, []byte("s3cret\n"), 0o600)
+	cfg, err := loadConfig(nil, env(map[string]string{"ADMIN_TOKEN_FILE": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AdminToken != "s3cret" {
+		t.Errorf("ADMIN_TOKEN_FILE: got admin_token %q", cfg.AdminToken)
+	}
+	if _, err := loadConfig(nil, env(map[string]string{"ADMIN_TOKEN_FILE": path, "ADMIN_TOKEN": "other"})); err == nil || !strings.Contains(err.Error(), "both set") {
+		t.Errorf("ADMIN_TOKEN and ADMIN_TOKEN_FILE: want an error, got %v", err)
+	}
+	if _, err := loadConfig(nil, env(map[string]string{"ERROR_DSN_FILE": path + ".missing"})); err == nil || !strings.Contains(err.Error(), "ERROR_DSN_FILE") {
+		t.Errorf("a missing file: want an error naming ERROR_DSN_FILE, got %v", err)
+	}
+
+	fs := defaultConfig().flagSet()
+	for name := range secretSettings {
+		if fs.Lookup(name) == nil {
+			t.Errorf("secret setting %s isn't a setting", name)
+		}
+		if fs.Lookup(name+"-file") != nil {
+			t.Errorf("%s_FILE would be both a secret file and a setting", envName(name))
+		}
+	}
+}
+
+func TestLoadConfigAdminAddr(t *testing.T) {
+	for _, vars := range []map[string]string{
+		{"ADMIN_ADDR": "127.0.0.1:9090"},
+		{"ADMIN_ADDR": "[::1]:9090"},
+		{"ADMIN_ADDR": ":9090", "ADMIN_TOKEN": "s3cret"},
+	} {
+		if _, err := loadConfig(nil, env(vars)); err != nil {
+			t.Errorf("loadConfig(%v): %v", vars, err)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.yaml")
+	os.WriteFile(unknown, []byte("prot: 80\n"), 0o644)
+
+	cases := []struct {
+		args []string
+		vars map[string]string
+		want string
+	}{
+		{[]string{"-port", "http"}, nil, "invalid value"},
+		{nil, map[string]string{"PORT": "70000"}, "out of range"},
+		{nil, map[string]string{"CACHE_TTL": "forever"}, "invalid CACHE_TTL"},
+		{nil, map[string]string{"UPGRADE_TIMEOUT": "0s"}, "upgrade_timeout"},
+		{nil, map[string]string{"DRAIN_TIMEOUT": "0s"}, "drain_timeout"},
+		{nil, map[string]string{"DRAIN_DELAY": "-1s"}, "drain_delay"},
+		{nil, map[string]string{"HTTP2_MAX_STREAMS": "-1"}, "http2_max_streams"},
+		{nil, map[string]string{"RATE_LIMIT": "5", "RATE_LIMIT_BURST": "0"}, "rate_limit_burst"},
+		{nil, map[string]string{"MAX_INFLIGHT": "-1"}, "max_inflight"},
+		{nil, map[string]string{"MAX_PATTERN_LENGTH": "-1"}, "max_pattern_length"},
+		{nil, map[string]string{"SHADOW_MATCHER": "glob"}, "shadow_matcher"},
+		{nil, map[string]string{"READ_HEADER_TIMEOUT": "-1s"}, "read_header_timeout"},
+		{nil, map[string]string{"MAX_HEADER_BYTES": "-1"}, "max_header_bytes"},
+		{nil, map[string]string{"HOST_SUSPEND_FOR": "-1h"}, "host_suspend_for"},
+		{nil, map[string]string{"BLOCKLIST_STATUS": "403"}, "blocklist_status"},
+		{nil, map[string]string{"SAFE_BROWSING_ACTION": "shrug"}, "safe_browsing_action"},
+		{nil, map[string]string{"ABUSE_REPORT_THRESHOLD": "-1"}, "abuse_report_threshold"},
+		{nil, map[string]string{"ABUSE_REPORTS_FILE": "/var/lib/redirect/reports.json"}, "abuse_reports_file requires abuse_reports"},
+		{nil, map[string]string{"ALLOWED_SCHEMES": "https,not a scheme"}, "allowed_schemes"},
+		{nil, map[string]string{"LOOP_HOPS": "-1"}, "loop_hops"},
+		{nil, map[string]string{"LOG_LEVEL": "chatty"}, "log_level"},
+		{nil, map[string]string{"CLIENT_IP_LOGGING": "partial"}, "client_ip_logging"},
+		{nil, map[string]string{"ERROR_DSN": "sentry.io/42"}, "error_dsn"},
+		{nil, map[string]string{"OTLP_ENDPOINT": "localhost:4318"}, "otlp_endpoint"},
+		{nil, map[string]string{"OTLP_HEADERS": "Authorization"}, "otlp_headers"},
+		{nil, map[string]string{"TRACE_SAMPLE_RATIO": "1.5"}, "trace_sample_ratio"},
+		{nil, map[string]string{"REQUEST_ID_HEADER": "X Request ID"}, "request_id_header"},
+		{nil, map[string]string{"STATSD_ADDR": "8125"}, "statsd_addr"},
+		{nil, map[string]string{"DEBUG_ADDR": ":6060"}, "debug_addr"},
+		{nil, map[string]string{"ANALYTICS_MAX_HOSTS": "-1"}, "analytics_max_hosts"},
+		{nil, map[string]string{"ANALYTICS_RETENTION": "-1h"}, "analytics_retention"},
+		{nil, map[string]string{"ANALYTICS_BOTS": "yes"}, "analytics_bots"},
+		{nil, map[string]string{"LINK_PREVIEWS": "on"}, "link_previews"},
+		{nil, map[string]string{"WEBFINGER": "off"}, "webfinger"},
+		{nil, map[string]string{"IGNORED_PATHS": "api/*"}, "ignored_paths"},
+		{nil, map[string]string{"IGNORED_STATUS": "200"}, "ignored_status"},
+		{nil, map[string]string{"CORS_ORIGINS": "https://example.com/app"}, "cors_origins"},
+		{nil, map[string]string{"HSTS_MAX_AGE": "-1h"}, "hsts_max_age"},
+		{nil, map[string]string{"ACME_DNS_PROVIDER": "cloudflare", "ACME_DNS_CREDENTIALS": "tok"}, "requires cert_dir"},
+		{nil, map[string]string{"ACME_DNS_PROVIDER": "bind", "CERT_DIR": "/tmp"}, "acme_dns_provider"},
+		{nil, map[string]string{"ACME_DNS_WILDCARDS": "true"}, "requires acme_dns_provider"},
+		{nil, map[string]string{"ACME_DIRECTORY": "acme.example.com/directory"}, "acme_directory"},
+		{nil, map[string]string{"CERT_CACHE": "redis://cache.internal"}, "requires cert_dir"},
+		{nil, map[string]string{"CERT_PREWARM": "go.example.com"}, "cert_prewarm requires cert_dir"},
+		{nil, map[string]string{"CERT_PREWARM": "go.example.com,*.example.com", "CERT_DIR": "/tmp"}, "cert_prewarm"},
+		{nil, map[string]string{"CERT_PREWARM_EVERY": "0s"}, "cert_prewarm_every"},
+		{nil, map[string]string{"STATIC_CERTS_DIR": "/tmp"}, "static_certs_dir requires cert_dir"},
+		{nil, map[string]string{"ACME_CAA_IDENTITIES": "ca.internal, not a domain"}, "acme_caa_identities"},
+		{nil, map[string]string{"MAINTENANCE_RETRY_AFTER": "-1m"}, "maintenance_retry_after"},
+		{nil, map[string]string{"TLS_MIN_VERSION": "1.1"}, "tls_min_version"},
+		{nil, map[string]string{"TLS_CIPHER_SUITES": "TLS_RSA_WITH_RC4_128_SHA"}, "tls_cipher_suites"},
+		{nil, map[string]string{"TLS_CURVES": "X25519,P-224"}, "tls_curves"},
+		{nil, map[string]string{"TLS_SESSION_TICKET_ROTATION": "-1h"}, "tls_session_ticket_rotation"},
+		{nil, map[string]string{"DEV_TLS": "true", "CERT_DIR": "/tmp"}, "dev_tls and cert_dir"},
+		{nil, map[string]string{"DEV_TLS_CA": "/tmp"}, "dev_tls_ca requires dev_tls"},
+		{nil, map[string]string{"BEHIND_PROXY": "true", "CERT_DIR": "/tmp"}, "behind_proxy"},
+		{nil, map[string]string{"SERVERLESS": "gcf"}, "serverless must be"},
+		{nil, map[string]string{"SHARED_CACHE": "memcached://cache:11211"}, "scheme must be redis"},
+		{nil, map[string]string{"SHARED_CACHE": "redis://cache", "CACHE_TTL": "0"}, "shared_cache requires cache_ttl"},
+		{nil, map[string]string{"CACHE_FILE": "/var/cache/redirect.jsonl", "CACHE_TTL": "0"}, "cache_file requires cache_ttl"},
+		{nil, map[string]string{"SERVERLESS": "lambda", "CERT_DIR": "/tmp"}, "the platform terminates TLS"},
+		{nil, map[string]string{"NEGATIVE_CACHE_TTL": "-1s"}, "negative_cache_ttl"},
+		{nil, map[string]string{"CERT_CACHE": "memcached://cache.internal", "CERT_DIR": "/tmp"}, "cert_cache"},
+		{nil, map[string]string{"ACME_EMAIL": "Ops <ops@example.com>"}, "acme_email"},
+		{nil, map[string]string{"ACME_KEY_TYPE": "ed25519"}, "acme_key_type"},
+		{nil, map[string]string{"ACME_EAB_KEY_ID": "kid-1"}, "set together"},
+		{nil, map[string]string{"ACME_FALLBACK_DIRECTORY": "https://acme-v02.api.letsencrypt.org/directory"}, "must differ"},
+		{nil, map[string]string{"ACME_FALLBACK_EAB_KEY_ID": "kid-1", "ACME_FALLBACK_EAB_HMAC_KEY": "c2VjcmV0"}, "requires acme_fallback_directory"},
+		{nil, map[string]string{"ACME_EAB_KEY_ID": "kid-1", "ACME_EAB_HMAC_KEY": "not base64!"}, "acme_eab_hmac_key"},
+		{nil, map[string]string{"ACME_DNS_PROVIDER": "cloudflare", "ACME_DNS_CREDENTIALS": "tok", "CERT_DIR": "/tmp", "ACME_DNS_DOMAINS": "*.*.example.com"}, "acme_dns_domains"},
+		{nil, map[string]string{"EVENT_WEBHOOK": "collector:8080"}, "event_webhook"},
+		{nil, map[string]string{"EVENT_FORMAT": "matomo"}, "event_format"},
+		{nil, map[string]string{"EVENT_FORMAT": "ga4", "EVENT_WEBHOOK": "https://www.google-analytics.com/mp/collect"}, "measurement_id"},
+		{nil, map[string]string{"EVENT_WEBHOOK_SECRET": "s3cret"}, "event_webhook_secret requires event_webhook"},
+		{nil, map[string]string{"EVENT_BATCH_SIZE": "0"}, "event_batch_size"},
+		{nil, map[string]string{"EVENT_STREAM": "kafka://broker:9092"}, "event_stream"},
+		{nil, map[string]string{"STATSD_FORMAT": "graphite"}, "statsd_format"},
+		{nil, map[string]string{"ACCESS_LOG": "/var/log/rn.log", "ERROR_LOG": "/var/log/rn.log"}, "same file"},
+		{nil, map[string]string{"LOG_MAX_BACKUPS": "-1"}, "log_max_backups"},
+		{nil, map[string]string{"TEMPLATES_DIR": "/nonexistent"}, "templates_dir"},
+		{nil, map[string]string{"CANONICAL_HOST": "redirect.name/"}, "canonical_host"},
+		{nil, map[string]string{"ALLOWED_HOSTS": "go.example.com,*"}, "allowed_hosts"},
+		{nil, map[string]string{"DENIED_HOSTS": "https://bad.example"}, "denied_hosts"},
+		{nil, map[string]string{"FALLBACK_URL": "redirect.name"}, "absolute http or https URL"},
+		{nil, map[string]string{"ADMIN_ADDR": "9090"}, "admin_addr"},
+		{nil, map[string]string{"ADMIN_ADDR": ":9090"}, "must be a loopback address"},
+		{nil, map[string]string{"ADMIN_ADDR": "10.0.0.7:9090"}, "must be a loopback address"},
+		{nil, map[string]string{"GRPC_ADDR": "127.0.0.1:9443"}, "grpc_addr requires"},
+		{nil, map[string]string{"ADMIN_CERT": "admin.pem"}, "admin_cert and admin_key"},
+		{nil, map[string]string{"ADMIN_CLIENT_CA": "ca.pem"}, "admin_client_ca requires admin_cert"},
+		{nil, map[string]string{"HTTPS_ADDR": "443"}, "https_addr"},
+		{[]string{"-http-addr", ""}, nil, "http_addr"},
+		{[]string{"-http3-addr", ":443"}, nil, "http3_addr requires cert_dir"},
+		{nil, map[string]string{"SOURCES": "dns,carrier-pigeon"}, "unknown source"},
+		{nil, map[string]string{"SOURCES": "dns,dns"}, "more than once"},
+		{nil, map[string]string{"SOURCES": "file"}, "redirects_file is not set"},
+		{nil, map[string]string{"STATIC_REDIRECTS": "nonsense"}, "static_redirects"},
+		{nil, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, 300.1.1.1"}, "trusted_proxies"},
+		{nil, map[string]string{"PROXY_PROTOCOL": "http,http3"}, "proxy_protocol"},
+		{[]string{"-config", unknown}, nil, `unknown setting "prot"`},
+		{[]string{"-config", filepath.Join(dir, "missing.yaml")}, nil, "reading config file"},
+		{[]string{"stray"}, nil, "unexpected argument"},
+	}
+	for _, c := range cases {
+		_, err := loadConfig(c.args, env(c.vars))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("loadConfig(%v, %v): want error containing %q, got %v", c.args, c.vars, c.want, err)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	cfg, err := loadConfig([]string{"-trusted-proxies", "10.0.0.0/8, 192.0.2.1,2001:db8::/32"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.trustedProxies()
+	if len(got) != 3 || got[1].String() != "192.0.2.1/32" {
+		t.Errorf("unexpected prefixes %v", got)
+	}
+
+	// Behind a proxy with none listed, only private peers are the proxy.
+	cfg, err = loadConfig([]string{"-behind-proxy"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contains := func(addr string) bool {
+		return slices.ContainsFunc(cfg.trustedProxies(), func(p netip.Prefix) bool { return p.Contains(netip.MustParseAddr(addr)) })
+	}
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "::1", "fd00::1"} {
+		if !contains(addr) {
+			t.Errorf("behind_proxy: want %s trusted", addr)
+		}
+	}
+	for _, addr := range []string{"203.0.113.9", "2001:db8::1"} {
+		if contains(addr) {
+			t.Errorf("behind_proxy: want %s, a public address, not trusted", addr)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"strings"
+
+	"github.com/frolic/redirect.name/internal/metrics"
+)
+
+// newDebugMux returns the mux for the debug listener: the runtime profiles
+// under /debug/pprof/ and the expvar variables, including the build and
+// every metric in the registry, at /debug/vars.
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func init() {
+	expvar.Publish("build", expvar.Func(func() any { return readBuildInfo() }))
+	expvar.Publish("metrics", expvar.Func(func() any { return metricVars(registry.Snapshot()) }))
+}
+
+// metricVars maps each family to its samples' values, keyed by their label
+// values joined with commas (or "" without labels). Histograms give their
+// count and sum.
+func metricVars(families []metrics.Family) map[string]map[string]any {
+	vars := make(map[string]map[string]any, len(families))
+	for _, f := range families {
+		samples := make(map[string]any, len(f.Samples))
+		for _, s := range f.Samples {
+			key := strings.Join(s.Labels, ",")
+			if f.Kind == metrics.KindHistogram {
+				samples[key] = map[string]any{"count": s.Count, "sum": s.Value}
+			} else {
+				samples[key] = s.Value
+			}
+		}
+		vars[f.Name] = samples
+	}
+	return vars
+}
+
+// isLoopbackAddr reports whether addr's host is localhost or a loopback IP,
+// so a listener on it can't be reached from other machines.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
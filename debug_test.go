@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugMux(t *testing.T) {
+	rateLimited.Inc("client")
+	mux := newDebugMux()
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars struct {
+		Build    buildInfo                 `json:"build"`
+		Metrics  map[string]map[string]any `json:"metrics"`
+		Memstats map[string]any            `json:"memstats"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("/debug/vars: %v\n%s", err, rr.Body)
+	}
+	if vars.Build.GoVersion == "" || vars.Memstats == nil {
+		t.Errorf("/debug/vars lacks build or memstats: %s", rr.Body)
+	}
+	if n, _ := vars.Metrics["redirect_rate_limited_total"]["client"].(float64); n < 1 {
+		t.Errorf("/debug/vars metrics: got %v", vars.Metrics["redirect_rate_limited_total"])
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "goroutine") {
+		t.Errorf("/debug/pprof/: got %d", rr.Code)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		"localhost:6060": true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"127.0.0.1":      false,
+	} {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("%s: want %v, got %v", addr, want, got)
+		}
+	}
+}
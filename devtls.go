@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devCertLifetime is how long dev_tls certificates are valid, mkcert's
+// choice too.
+const devCertLifetime = 825 * 24 * time.Hour
+
+// devCerts makes a certificate for each host asked for, so HTTPS can be
+// tried locally without ACME. They're signed by the CA in dev_tls_ca, as
+// mkcert's are, so browsers trusting that CA accept them, or else are
+// self-signed. Certificates are kept in memory only.
+type devCerts struct {
+	ca    *x509.Certificate // nil for self-signed
+	caKey crypto.Signer
+	key   *ecdsa.PrivateKey // shared by all certificates
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+func newDevCerts(cfg *config) (*devCerts, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	d := &devCerts{key: key, certs: make(map[string]*tls.Certificate)}
+	if cfg.DevTLSCA != "" {
+		if d.ca, d.caKey, err = loadDevCA(cfg.DevTLSCA); err != nil {
+			return nil, fmt.Errorf("dev_tls_ca: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// loadDevCA reads the CA certificate and key mkcert keeps in dir, its
+// CAROOT: rootCA.pem and rootCA-key.pem.
+func loadDevCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, "rootCA.pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "rootCA-key.pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, errors.New("rootCA.pem holds no certificate")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ = pem.Decode(keyPEM); block == nil {
+		return nil, nil, errors.New("rootCA-key.pem holds no key")
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("rootCA-key.pem holds a %s", block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("rootCA-key.pem can't sign")
+	}
+	return ca, signer, nil
+}
+
+// String describes where d's certificates come from, for the log.
+func (d *devCerts) String() string {
+	if d.ca != nil {
+		return fmt.Sprintf("%q-signed", d.ca.Subject.CommonName)
+	}
+	return "self-signed"
+}
+
+// tlsConfig returns a config with certificates from d.
+func (d *devCerts) tlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: d.GetCertificate, NextProtos: []string{"h2", "http/1.1"}}
+}
+
+// GetCertificate returns the certificate for the hello's server name, or,
+// if it sent none, for the address it connected to.
+func (d *devCerts) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" && hello.Conn != nil {
+		name, _, _ = net.SplitHostPort(hello.Conn.LocalAddr().String())
+	}
+	if name == "" {
+		name = "localhost"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cert := d.certs[name]; cert != nil {
+		return cert, nil
+	}
+	cert, err := d.issue(name)
+	if err != nil {
+		return nil, fmt.Errorf("dev_tls certificate for %s: %w", name, err)
+	}
+	d.certs[name] = cert
+	return cert, nil
+}
+
+func (d *devCerts) issue(name string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"redirect.name development certificate"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	parent, signer := template, crypto.Signer(d.key)
+	if d.ca != nil {
+		parent, signer = d.ca, d.caKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &d.key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	return newCertificate([][]byte{der}, d.key)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDevCertsSelfSigned(t *testing.T) {
+	d, err := newDevCerts(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "Go.Example.test."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("go.example.test"); err != nil {
+		t.Error(err)
+	}
+	if err := cert.Leaf.CheckSignature(cert.Leaf.SignatureAlgorithm, cert.Leaf.RawTBSCertificate, cert.Leaf.Signature); err != nil {
+		t.Errorf("not self-signed: %v", err)
+	}
+	if again, _ := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "go.example.test"}); again != cert {
+		t.Error("certificate made again")
+	}
+	ip, _ := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "127.0.0.1"})
+	if ip == nil || ip.Leaf.VerifyHostname("127.0.0.1") != nil {
+		t.Errorf("IP address certificate: %+v", ip)
+	}
+}
+
+func TestDevCertsLocalCA(t *testing.T) {
+	// A CA laid out as mkcert keeps it.
+	dir := t.TempDir()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mkcert development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	os.WriteFile(filepath.Join(dir, "rootCA.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	os.WriteFile(filepath.Join(dir, "rootCA-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	cfg := defaultConfig()
+	cfg.DevTLS, cfg.DevTLSCA = true, dir
+	d, err := newDevCerts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.String(); s != `"mkcert development CA"-signed` {
+		t.Errorf("String() = %s", s)
+	}
+	cert, err := d.GetCertificate(&tls.ClientHelloInfo{ServerName: "go.example.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "go.example.test", Roots: roots}); err != nil {
+		t.Error(err)
+	}
+
+	cfg.DevTLSCA = t.TempDir()
+	if _, err := newDevCerts(cfg); err == nil {
+		t.Error("empty CA directory: no error")
+	}
+}
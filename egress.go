@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddr refuses fetches of loopback, private and link-local
+// addresses, which TXT records could otherwise point at.
+var errPrivateAddr = errors.New("destination is not a public address")
+
+// newPublicClient returns a client for fetching URLs taken from TXT
+// records, which only connects to public addresses.
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublic}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+	}
+}
+
+// dialPublic is a net.Dialer Control refusing non-public addresses. It
+// runs after name resolution, so it also covers names resolving to them.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := ap.Addr().Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return errPrivateAddr
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDialPublic(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34:443":     true,
+		"[2606:4700::1111]:443": true,
+		"127.0.0.1:80":          false,
+		"10.1.2.3:80":           false,
+		"192.168.0.1:80":        false,
+		"169.254.169.254:80":    false,
+		"[::1]:80":              false,
+		"[fd00::1]:80":          false,
+		"[::ffff:127.0.0.1]:80": false,
+		"0.0.0.0:80":            false,
+	} {
+		if err := dialPublic("tcp", addr, nil); (err == nil) != public {
+			t.Errorf("%s: got %v", addr, err)
+		}
+	}
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	if _, err := newPublicClient(time.Second).Get(ts.URL); !errors.Is(err, errPrivateAddr) {
+		t.Errorf("loopback destination: got %v", err)
+	}
+}
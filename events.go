@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/stream"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// redirectEvents forward each redirect to event_webhook and event_stream.
+// It is empty when neither is set.
+var redirectEvents []*eventForwarder
+
+var (
+	eventsSent = registry.Counter("redirect_events_sent_total",
+		"Redirect events delivered, by sink (webhook or stream).", "sink")
+	eventsDropped = registry.Counter("redirect_events_dropped_total",
+		"Redirect events not delivered, by sink and reason (queue_full or delivery).", "sink", "reason")
+)
+
+// eventQueueSize bounds the events waiting to be sent by each forwarder.
+// Once it is full, new events are dropped rather than slowing redirects
+// down.
+const eventQueueSize = 10000
+
+// A redirectEvent is one redirect, as forwarded to event_webhook and
+// event_stream.
+type redirectEvent struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Location  string    `json:"location"`
+	Rule      string    `json:"rule,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Bot       bool      `json:"bot,omitempty"`
+
+	network string // the client's truncated IP, for Plausible
+}
+
+// eventClient identifies clients in events: by a keyed hash of their IP,
+// and for Plausible by their truncated IP, unless client_ip_logging is
+// "off".
+type eventClient struct {
+	hash, truncate *ipAnonymizer
+}
+
+func newEventClient(cfg *config) eventClient {
+	c := eventClient{
+		hash:     &ipAnonymizer{mode: "hash", key: []byte(cfg.IPHashKey)},
+		truncate: &ipAnonymizer{mode: "truncate"},
+	}
+	if len(c.hash.key) == 0 {
+		c.hash.key = processHashKey()
+	}
+	if cfg.ClientIPLogging == "off" {
+		c.hash.mode, c.truncate.mode = "off", "off"
+	}
+	return c
+}
+
+// eventForwarder queues redirectEvents and hands them to a sink in the
+// background, in batches of up to batchSize at least every interval, or
+// one at a time if perEvent is set.
+type eventForwarder struct {
+	name      string // for metrics
+	sink      func(ctx context.Context, events []redirectEvent) error
+	perEvent  bool
+	batchSize int
+	interval  time.Duration
+	events    chan redirectEvent
+	done      chan struct{}
+}
+
+// newEventForwarders returns a forwarder for each of event_webhook and
+// event_stream that is set, and starts them.
+func newEventForwarders(cfg *config) ([]*eventForwarder, error) {
+	var fs []*eventForwarder
+	if cfg.EventWebhook != "" {
+		w := &webhookSink{url: cfg.EventWebhook, format: cfg.EventFormat, secret: []byte(cfg.EventWebhookSecret), client: &http.Client{Timeout: 10 * time.Second}}
+		fs = append(fs, &eventForwarder{name: "webhook", sink: w.send, perEvent: cfg.EventFormat != "json"})
+	}
+	if cfg.EventStream != "" {
+		pub, err := stream.New(cfg.EventStream)
+		if err != nil {
+			return nil, fmt.Errorf("event_stream: %w", err)
+		}
+		fs = append(fs, &eventForwarder{name: "stream", sink: streamSink(pub)})
+	}
+	for _, f := range fs {
+		f.batchSize, f.interval = cfg.EventBatchSize, cfg.EventFlushInterval
+		f.start()
+	}
+	return fs, nil
+}
+
+func (f *eventForwarder) start() {
+	f.events = make(chan redirectEvent, eventQueueSize)
+	f.done = make(chan struct{})
+	go f.run()
+}
+
+// send queues ev, dropping it if the queue is full.
+func (f *eventForwarder) send(ev redirectEvent) {
+	select {
+	case f.events <- ev:
+	default:
+		eventsDropped.Inc(f.name, "queue_full")
+	}
+}
+
+// Close sends the events still queued, waiting until ctx is done at most.
+// send must not be called afterwards.
+func (f *eventForwarder) Close(ctx context.Context) error {
+	close(f.events)
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *eventForwarder) run() {
+	defer close(f.done)
+	tick := time.NewTicker(f.interval)
+	defer tick.Stop()
+	var batch []redirectEvent
+	for {
+		select {
+		case ev, ok := <-f.events:
+			if !ok {
+				f.deliver(batch)
+				return
+			}
+			if batch = append(batch, ev); len(batch) >= f.batchSize {
+				f.deliver(batch)
+				batch = nil
+			}
+		case <-tick.C:
+			f.deliver(batch)
+			batch = nil
+		}
+	}
+}
+
+// deliver sends batch, retrying each request once after a second if it
+// fails.
+func (f *eventForwarder) deliver(batch []redirectEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	groups := [][]redirectEvent{batch}
+	if f.perEvent {
+		groups = nil
+		for i := range batch {
+			groups = append(groups, batch[i:i+1])
+		}
+	}
+	for _, events := range groups {
+		err := f.deliverOnce(events)
+		if err != nil {
+			time.Sleep(time.Second)
+			err = f.deliverOnce(events)
+		}
+		if err != nil {
+			log.Printf("Forwarding redirect events to %s: %v", f.name, err)
+			eventsDropped.Add(float64(len(events)), f.name, "delivery")
+			continue
+		}
+		eventsSent.Add(float64(len(events)), f.name)
+	}
+}
+
+func (f *eventForwarder) deliverOnce(events []redirectEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return f.sink(ctx, events)
+}
+
+// streamSink publishes events as JSON messages keyed by host, so a host's
+// events stay in order on one Kafka partition.
+func streamSink(pub stream.Publisher) func(context.Context, []redirectEvent) error {
+	return func(ctx context.Context, events []redirectEvent) error {
+		msgs := make([]stream.Message, len(events))
+		for i, ev := range events {
+			value, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			msgs[i] = stream.Message{Key: []byte(ev.Host), Value: value}
+		}
+		return pub.Publish(ctx, msgs)
+	}
+}
+
+// webhookSink POSTs events to event_webhook per event_format: "json" sends
+// batches as a JSON array, "plausible" each as a Plausible pageview and
+// "ga4" as a Google Analytics Measurement Protocol event (event_webhook
+// carrying measurement_id and api_secret). With a secret, each request is
+// signed (see sign).
+type webhookSink struct {
+	url    string
+	format string
+	secret []byte
+	client *http.Client
+}
+
+// send sends events: all of them for "json", otherwise the only one.
+func (s *webhookSink) send(ctx context.Context, events []redirectEvent) error {
+	var body any = events
+	switch s.format {
+	case "plausible":
+		ev := events[0]
+		body = map[string]string{
+			"name":     "pageview",
+			"domain":   ev.Host,
+			"url":      "https://" + ev.Host + ev.Path,
+			"referrer": ev.Referrer,
+		}
+	case "ga4":
+		ev := events[0]
+		body = map[string]any{
+			"client_id": ev.Client,
+			"events": []map[string]any{{
+				"name": "redirect",
+				"params": map[string]any{
+					"page_location": "https://" + ev.Host + ev.Path,
+					"page_referrer": ev.Referrer,
+					"destination":   ev.Location,
+					"status":        ev.Status,
+				},
+			}},
+		}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		s.sign(req.Header, time.Now(), b)
+	}
+	if s.format == "plausible" {
+		req.Header.Set("User-Agent", events[0].UserAgent)
+		if events[0].network != "" {
+			req.Header.Set("X-Forwarded-For", events[0].network)
+		}
+	}
+	// Errors name only the host: a ga4 webhook's query holds its secret.
+	resp, err := s.client.Do(req)
+	if ue, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %w", req.URL.Host, ue.Err)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// sign sets the headers a receiver authenticates body by: the time it was
+// sent and a random nonce, which it can use to refuse the request if it's
+// old or it has seen it before, and X-Redirect-Signature, the hex
+// HMAC-SHA256 with the secret of "<timestamp>.<nonce>.<body>". A retry is
+// signed afresh.
+func (s *webhookSink) sign(h http.Header, now time.Time, body []byte) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	h.Set("X-Redirect-Timestamp", timestamp)
+	h.Set("X-Redirect-Nonce", nonce)
+	h.Set("X-Redirect-Signature", "sha256="+webhookSignature(s.secret, timestamp, nonce, body))
+}
+
+// webhookSignature returns the hex HMAC-SHA256 with secret of a webhook
+// request's timestamp, nonce and body.
+func webhookSignature(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// forwardEvents queues an event with each of fs for each request answered
+// with a redirect. Redirects of bots are forwarded only if bots is set.
+func forwardEvents(fs []*eventForwarder, client eventClient, bots bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := redirect.LookupInfoFrom(r.Context())
+		if info == nil {
+			info = new(redirect.LookupInfo)
+			r = r.WithContext(redirect.WithLookupInfo(r.Context(), info))
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		location := rec.Header().Get("Location")
+		if rec.status/100 != 3 || location == "" || (info.Bot && !bots) {
+			return
+		}
+		ip := redirect.ClientIP(r)
+		ev := redirectEvent{
+			Time:      time.Now().UTC(),
+			Host:      r.Host,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			Location:  location,
+			Client:    client.hash.anonymize(ip),
+			UserAgent: r.UserAgent(),
+			Referrer:  r.Referer(),
+			RequestID: redirect.RequestIDFrom(r.Context()),
+			Bot:       info.Bot,
+			network:   client.truncate.anonymize(ip),
+		}
+		if host, err := redirect.ParseHost(r.Host); err == nil {
+			ev.Host = host
+		}
+		if info.Rule != nil {
+			ev.Rule = info.Rule.String()
+		}
+		for _, f := range fs {
+			f.send(ev)
+		}
+	})
+}
+
+// validateEventWebhook checks that a ga4 event_webhook names the stream
+// to send to.
+func validateEventWebhook(format, webhook string) error {
+	if format != "ga4" || webhook == "" {
+		return nil
+	}
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return fmt.Errorf("event_webhook: %w", err)
+	}
+	if q := u.Query(); q.Get("measurement_id") == "" || q.Get("api_secret") == "" {
+		return fmt.Errorf("event_webhook for ga4 needs measurement_id and api_secret query parameters")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/stream"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// newTestForwarder points redirectEvents at a server that passes each
+// request's headers and body to the returned channel.
+func newTestForwarder(t *testing.T, format, query string) chan *http.Request {
+	t.Helper()
+	got := make(chan *http.Request, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		got <- r
+	}))
+	t.Cleanup(ts.Close)
+	orig, origEvents := resolver, redirectEvents
+	t.Cleanup(func() { resolver, redirectEvents = orig, origEvents })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects from /* to https://example.com/*"}}
+	cfg := defaultConfig()
+	cfg.EventWebhook = ts.URL + "/events" + query
+	cfg.EventFormat = format
+	cfg.EventBatchSize = 2
+	cfg.EventFlushInterval = time.Hour
+	var err error
+	if redirectEvents, err = newEventForwarders(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func serveRedirects(t *testing.T, paths ...string) {
+	t.Helper()
+	h := newMux(defaultConfig(), nil)
+	for _, path := range paths {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "go.example.com"
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("Referer", "https://news.example/")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestForwardEventsBatches(t *testing.T) {
+	reqs := newTestForwarder(t, "json", "")
+	serveRedirects(t, "/a", "/healthz", "/b", "/c")
+
+	var events []redirectEvent
+	if err := json.NewDecoder(nextReport(t, reqs).Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want a batch of 2, got %+v", events)
+	}
+	ev := events[0]
+	if ev.Host != "go.example.com" || ev.Path != "/a" || ev.Status != 302 || ev.Location != "https://example.com/a" || ev.Referrer != "https://news.example/" {
+		t.Errorf("got %+v", ev)
+	}
+	if !strings.HasPrefix(ev.Client, "ip-") {
+		t.Errorf("client not hashed: %q", ev.Client)
+	}
+
+	// Close sends what is left.
+	if err := redirectEvents[0].Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	json.NewDecoder(nextReport(t, reqs).Body).Decode(&events)
+	if len(events) != 1 || events[0].Path != "/c" {
+		t.Errorf("after Close: got %+v", events)
+	}
+}
+
+func TestForwardEventsPlausible(t *testing.T) {
+	reqs := newTestForwarder(t, "plausible", "")
+	serveRedirects(t, "/a")
+	redirectEvents[0].Close(context.Background())
+
+	r := nextReport(t, reqs)
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	if body["name"] != "pageview" || body["domain"] != "go.example.com" || body["url"] != "https://go.example.com/a" {
+		t.Errorf("got %v", body)
+	}
+	if r.Header.Get("User-Agent") != "test-agent" || r.Header.Get("X-Forwarded-For") != "192.0.2.0" {
+		t.Errorf("headers: %v", r.Header)
+	}
+}
+
+func TestForwardEventsGA4(t *testing.T) {
+	reqs := newTestForwarder(t, "ga4", "?measurement_id=G-1&api_secret=s")
+	serveRedirects(t, "/a")
+	redirectEvents[0].Close(context.Background())
+
+	r := nextReport(t, reqs)
+	var body struct {
+		ClientID string `json:"client_id"`
+		Events   []struct {
+			Name   string
+			Params map[string]any
+		}
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if r.URL.Query().Get("measurement_id") != "G-1" || !strings.HasPrefix(body.ClientID, "ip-") || len(body.Events) != 1 || body.Events[0].Params["destination"] != "https://example.com/a" {
+		t.Errorf("got %s %+v", r.URL, body)
+	}
+}
+
+// fakePublisher records the messages published to it.
+type fakePublisher struct {
+	msgs chan stream.Message
+}
+
+func (p fakePublisher) Publish(ctx context.Context, msgs []stream.Message) error {
+	for _, m := range msgs {
+		p.msgs <- m
+	}
+	return nil
+}
+
+func (p fakePublisher) Close() error { return nil }
+
+func TestForwardEventsStream(t *testing.T) {
+	orig, origEvents := resolver, redirectEvents
+	t.Cleanup(func() { resolver, redirectEvents = orig, origEvents })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects from /* to https://example.com/*"}}
+	pub := fakePublisher{make(chan stream.Message, 10)}
+	f := &eventForwarder{name: "stream", sink: streamSink(pub), batchSize: 10, interval: time.Hour}
+	f.start()
+	redirectEvents = []*eventForwarder{f}
+	serveRedirects(t, "/a", "/b")
+	f.Close(context.Background())
+
+	for _, want := range []string{"/a", "/b"} {
+		m := <-pub.msgs
+		var ev redirectEvent
+		if err := json.Unmarshal(m.Value, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Key) != "go.example.com" || ev.Path != want {
+			t.Errorf("got key %q, event %+v", m.Key, ev)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	type signed struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan signed, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- signed{r.Header, body}
+	}))
+	defer ts.Close()
+	sink := &webhookSink{url: ts.URL, format: "json", secret: []byte("s3cret"), client: ts.Client()}
+	events := []redirectEvent{{Host: "go.example.com", Path: "/a", Status: 302, Location: "https://example.com/a"}}
+	sink.send(context.Background(), events)
+	sink.send(context.Background(), events)
+
+	first, second := <-got, <-got
+	h := first.header
+	timestamp, nonce := h.Get("X-Redirect-Timestamp"), h.Get("X-Redirect-Nonce")
+	if n, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(n, 0)) > time.Minute {
+		t.Errorf("X-Redirect-Timestamp %q isn't the time sent", timestamp)
+	}
+	if want := "sha256=" + webhookSignature([]byte("s3cret"), timestamp, nonce, first.body); h.Get("X-Redirect-Signature") != want {
+		t.Errorf("X-Redirect-Signature: want %q, got %q", want, h.Get("X-Redirect-Signature"))
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	io.WriteString(mac, timestamp+"."+nonce+"."+string(first.body))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); h.Get("X-Redirect-Signature") != want {
+		t.Errorf("X-Redirect-Signature isn't the HMAC of timestamp.nonce.body")
+	}
+	if len(nonce) != 32 || second.header.Get("X-Redirect-Nonce") == nonce {
+		t.Errorf("want a fresh 32-digit nonce per request, got %q then %q", nonce, second.header.Get("X-Redirect-Nonce"))
+	}
+
+	sink.secret = nil
+	sink.send(context.Background(), events)
+	if h := (<-got).header; h.Get("X-Redirect-Signature") != "" || h.Get("X-Redirect-Nonce") != "" {
+		t.Errorf("signed without a secret: %v", h)
+	}
+}
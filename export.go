@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// exportFormats are the configuration languages `redirect-name export`
+// writes.
+var exportFormats = []string{"nginx", "caddy"}
+
+// runExport runs `redirect-name export` with args, such as
+//
+//	-host go.example.com -format caddy
+//
+// writing the server configuration that redirects as the host's TXT
+// records do to out, for hosts leaving the service for a server of their
+// own. The records are read from DNS, or through doh_url's endpoint.
+func runExport(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	host := fs.String("host", "", "hostname whose rules to export")
+	format := fs.String("format", "nginx", "configuration to write: "+strings.Join(exportFormats, ", "))
+	dohURL := fs.String("doh-url", getenv("DOH_URL"), "DNS-over-HTTPS endpoint used instead of the system resolver")
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	h, err := redirect.ParseHost(*host)
+	if err != nil || h == "" {
+		return fmt.Errorf("-host must be a hostname, such as go.example.com")
+	}
+	if *format != "nginx" && *format != "caddy" {
+		return fmt.Errorf("-format must be one of %s", strings.Join(exportFormats, ", "))
+	}
+	var r redirect.Resolver = redirect.DNSResolver{}
+	if *dohURL != "" {
+		r = &redirect.DoHResolver{URL: *dohURL}
+	}
+	rules, err := r.LookupConfig(ctx, h)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("%s has no rules", redirect.RecordName(h))
+	}
+	_, err = io.WriteString(out, exportConfig(h, rules, *format))
+	return err
+}
+
+// An exportPlan is a host's rules in the order the handler tries them:
+// literal bodies, then ignored paths, then rules with a From path, then
+// the first catch-all. Rules the exported configuration can't express are
+// skipped, with the reason.
+type exportPlan struct {
+	serves, ignores, paths []*redirect.Rule
+	catchAll               *redirect.Rule
+	skipped                []skippedRule
+}
+
+type skippedRule struct {
+	rule   *redirect.Rule
+	reason string
+}
+
+func planExport(rules []*redirect.Rule) *exportPlan {
+	p := new(exportPlan)
+	for _, rule := range rules {
+		if reason := unexportable(rule); reason != "" {
+			p.skipped = append(p.skipped, skippedRule{rule, reason})
+			continue
+		}
+		switch {
+		case rule.Serves != "" || rule.Responds:
+			p.serves = append(p.serves, rule)
+		case rule.Ignores:
+			p.ignores = append(p.ignores, rule)
+		case rule.From != "":
+			p.paths = append(p.paths, rule)
+		case p.catchAll == nil:
+			p.catchAll = rule
+		default:
+			p.skipped = append(p.skipped, skippedRule{rule, "an earlier catch-all takes precedence"})
+		}
+	}
+	return p
+}
+
+// unexportable returns why rule can't be exported, or "" if it can.
+func unexportable(rule *redirect.Rule) string {
+	switch {
+	case rule.Flag != "":
+		return "flags have no equivalent"
+	case rule.ServesFrom != "":
+		return "it serves a fetched document"
+	case rule.Bots:
+		return "it applies to bots only"
+	case rule.GoGet:
+		return "it answers go get with go-import tags"
+	case rule.Methods != "":
+		return "it applies to some methods only"
+	case rule.Header != "":
+		return "it has a header condition"
+	case rule.Requires != "":
+		return "it requires a key"
+	case rule.Protected != "":
+		return "it is protected by a password"
+	case rule.Cookie != "":
+		return "it sets a cookie"
+	case rule.Serves == "" && !rule.Responds && !rule.Ignores && rule.To == "" && rule.Canonical == "":
+		return "it has no target"
+	}
+	return ""
+}
+
+// exportConfig returns rules, those of host, as format's configuration.
+func exportConfig(host string, rules []*redirect.Rule, format string) string {
+	p := planExport(rules)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Exported by redirect-name from the TXT records at %s.\n", redirect.RecordName(host))
+	if format == "caddy" {
+		p.writeCaddy(&b, host)
+	} else {
+		p.writeNginx(&b, host)
+	}
+	return b.String()
+}
+
+// exportStatus returns the status rule redirects with, as Translate does.
+func exportStatus(rule *redirect.Rule) int {
+	switch rule.RedirectState {
+	case "301", "permanently":
+		return 301
+	case "307":
+		return 307
+	case "308":
+		return 308
+	}
+	return 302
+}
+
+// ignoredStatus returns the status of the paths an Ignores rule matches:
+// its own, or the default of ignored_status.
+func ignoredStatus(rule *redirect.Rule) int {
+	if status, err := strconv.Atoi(rule.RedirectState); err == nil {
+		return status
+	}
+	return 404
+}
+
+// servedType returns the content type of a Serves or Responds rule's body.
+func servedType(rule *redirect.Rule) string {
+	switch {
+	case rule.Responds && rule.ContentType != "":
+		return rule.ContentType
+	case rule.Responds, rule.From == redirect.AtprotoDIDPath:
+		return "text/plain"
+	}
+	return "application/json"
+}
+
+// pathRegexp returns a regular expression matching the paths a From
+// pattern does, with its wildcard, if any, captured when capture is set.
+func pathRegexp(pattern string, capture bool) string {
+	before, after, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return "^" + regexp.QuoteMeta(pattern) + "$"
+	}
+	group := ".*"
+	if capture {
+		group = "(.*)"
+	}
+	return "^" + regexp.QuoteMeta(before) + group + regexp.QuoteMeta(after) + "$"
+}
+
+// substitute returns the target to of a rule from a From pattern with its
+// wildcard replaced by capture. A pattern ending with its wildcard matches
+// the query too, so the query is passed on after the capture, as query.
+func substitute(from, to, capture, query string) string {
+	if !strings.Contains(from, "*") || !strings.Contains(to, "*") {
+		return to
+	}
+	if strings.HasSuffix(from, "*") {
+		capture += query
+	}
+	return strings.Replace(to, "*", capture, 1)
+}
+
+func (p *exportPlan) writeNginx(b *strings.Builder, host string) {
+	fmt.Fprintf(b, "server {\n\tserver_name %s;\n", host)
+	// nginx expands variables in the strings it returns, with no escape.
+	skip := func(rule *redirect.Rule, s string) bool {
+		if strings.Contains(s, "$") {
+			p.skipped = append(p.skipped, skippedRule{rule, "nginx can't return a $ literally"})
+			return true
+		}
+		return false
+	}
+	var locations strings.Builder
+	for _, rule := range p.serves {
+		if skip(rule, rule.Serves) {
+			continue
+		}
+		fmt.Fprintf(&locations, "\n\tlocation = %s {\n\t\tdefault_type %s;\n", nginxQuote(rule.From), servedType(rule))
+		status := 200
+		if rule.Responds && rule.RedirectState != "" {
+			status, _ = strconv.Atoi(rule.RedirectState)
+		}
+		if !rule.Responds {
+			locations.WriteString("\t\tadd_header Access-Control-Allow-Origin * always;\n")
+		}
+		fmt.Fprintf(&locations, "\t\treturn %d %s;\n\t}\n", status, nginxQuote(rule.Serves))
+	}
+	for _, rule := range p.ignores {
+		fmt.Fprintf(&locations, "\n\tlocation ~ %s {\n\t\treturn %d;\n\t}\n", nginxQuote(pathRegexp(rule.From, false)), ignoredStatus(rule))
+	}
+	for _, rule := range p.paths {
+		if skip(rule, rule.To) {
+			continue
+		}
+		to := substitute(rule.From, rule.To, "$1", "$is_args$args")
+		fmt.Fprintf(&locations, "\n\tlocation ~ %s {\n\t\treturn %d %s;\n\t}\n", nginxQuote(pathRegexp(rule.From, true)), exportStatus(rule), nginxQuote(to))
+	}
+	if rule := p.catchAll; rule != nil && !skip(rule, rule.To+rule.Canonical) {
+		to := rule.To
+		if rule.Canonical != "" {
+			to = "$scheme://" + rule.Canonical + "$request_uri"
+		}
+		fmt.Fprintf(&locations, "\n\tlocation / {\n\t\treturn %d %s;\n\t}\n", exportStatus(rule), nginxQuote(to))
+	}
+	for _, s := range p.skipped {
+		fmt.Fprintf(b, "\t# Skipped, as %s: %s\n", s.reason, s.rule)
+	}
+	b.WriteString(locations.String())
+	b.WriteString("}\n")
+}
+
+func (p *exportPlan) writeCaddy(b *strings.Builder, host string) {
+	fmt.Fprintf(b, "%s {\n", host)
+	var matchers, route strings.Builder
+	n := 0
+	matcher := func(def string) string {
+		n++
+		name := "rule" + strconv.Itoa(n)
+		fmt.Fprintf(&matchers, "\t@%s %s\n", name, strings.ReplaceAll(def, "NAME", name))
+		return name
+	}
+	for _, rule := range p.serves {
+		name := matcher("path " + caddyQuote(rule.From))
+		fmt.Fprintf(&route, "\t\theader @%s Content-Type %s\n", name, caddyQuote(servedType(rule)))
+		status := 200
+		if rule.Responds && rule.RedirectState != "" {
+			status, _ = strconv.Atoi(rule.RedirectState)
+		}
+		if !rule.Responds {
+			fmt.Fprintf(&route, "\t\theader @%s Access-Control-Allow-Origin *\n", name)
+		}
+		fmt.Fprintf(&route, "\t\trespond @%s %s %d\n", name, caddyQuote(caddyLiteral(rule.Serves)), status)
+	}
+	for _, rule := range p.ignores {
+		name := matcher("path_regexp NAME " + caddyQuote(pathRegexp(rule.From, false)))
+		fmt.Fprintf(&route, "\t\trespond @%s %d\n", name, ignoredStatus(rule))
+	}
+	for _, rule := range p.paths {
+		name := matcher("path_regexp NAME " + caddyQuote(pathRegexp(rule.From, true)))
+		to := substitute(rule.From, caddyLiteral(rule.To), "{re."+name+".1}", "{?query}")
+		fmt.Fprintf(&route, "\t\tredir @%s %s %d\n", name, caddyQuote(to), exportStatus(rule))
+	}
+	if rule := p.catchAll; rule != nil {
+		to := caddyLiteral(rule.To)
+		if rule.Canonical != "" {
+			to = "{scheme}://" + rule.Canonical + "{uri}"
+		}
+		fmt.Fprintf(&route, "\t\tredir %s %d\n", caddyQuote(to), exportStatus(rule))
+	}
+	for _, s := range p.skipped {
+		fmt.Fprintf(b, "\t# Skipped, as %s: %s\n", s.reason, s.rule)
+	}
+	b.WriteString(matchers.String())
+	// A route keeps the directives in the records' order, rather than
+	// Caddy's own.
+	fmt.Fprintf(b, "\troute {\n%s\t}\n}\n", route.String())
+}
+
+// nginxQuote returns s as an nginx argument, quoted if need be.
+func nginxQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n;{}\"'#\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// caddyQuote returns s as a Caddyfile token, quoted if need be: in
+// backticks, within which nothing is escaped, unless s has one.
+func caddyQuote(s string) string {
+	switch {
+	case s != "" && !strings.ContainsAny(s, " \t\n\"'`#"):
+		return s
+	case !strings.Contains(s, "`"):
+		return "`" + s + "`"
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// caddyLiteral escapes the braces of s, which Caddy would otherwise take
+// for placeholders such as {path}.
+func caddyLiteral(s string) string {
+	return strings.NewReplacer("{", `\{`, "}", `\}`).Replace(s)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestExportConfig(t *testing.T) {
+	rules := redirect.ParseAll([]string{
+		"Redirects from /docs/* to https://docs.example.com/* permanently",
+		`Responds to /ping with "pong"`,
+		"Redirects to https://example.com/",
+		"Ignores /api/* with 204",
+		"Redirects from /old to https://example.com/{new} with 308",
+		"Redirects bots to https://example.com/crawlers",
+		`Serves /.well-known/matrix/server {"m.server": "matrix.example.com:443"}`,
+		"Redirects to https://other.example.com/",
+		"Redirects from /private/* to https://example.com/* requiring key=SECRET",
+	})
+	for _, tc := range []struct {
+		format, want string
+	}{
+		{"nginx", `# Exported by redirect-name from the TXT records at _redirect.go.example.com.
+server {
+	server_name go.example.com;
+	# Skipped, as it applies to bots only: Redirects bots to https://example.com/crawlers
+	# Skipped, as an earlier catch-all takes precedence: Redirects to https://other.example.com/
+	# Skipped, as it requires a key: Redirects from /private/* to https://example.com/* requiring key=REDACTED
+
+	location = /ping {
+		default_type text/plain;
+		return 200 pong;
+	}
+
+	location = /.well-known/matrix/server {
+		default_type application/json;
+		add_header Access-Control-Allow-Origin * always;
+		return 200 "{\"m.server\": \"matrix.example.com:443\"}";
+	}
+
+	location ~ ^/api/.*$ {
+		return 204;
+	}
+
+	location ~ ^/docs/(.*)$ {
+		return 301 https://docs.example.com/$1$is_args$args;
+	}
+
+	location ~ ^/old$ {
+		return 308 "https://example.com/{new}";
+	}
+
+	location / {
+		return 302 https://example.com/;
+	}
+}
+`},
+		{"caddy", "# Exported by redirect-name from the TXT records at _redirect.go.example.com.\n" + `go.example.com {
+	# Skipped, as it applies to bots only: Redirects bots to https://example.com/crawlers
+	# Skipped, as an earlier catch-all takes precedence: Redirects to https://other.example.com/
+	# Skipped, as it requires a key: Redirects from /private/* to https://example.com/* requiring key=REDACTED
+	@rule1 path /ping
+	@rule2 path /.well-known/matrix/server
+	@rule3 path_regexp rule3 ^/api/.*$
+	@rule4 path_regexp rule4 ^/docs/(.*)$
+	@rule5 path_regexp rule5 ^/old$
+	route {
+		header @rule1 Content-Type text/plain
+		respond @rule1 pong 200
+		header @rule2 Content-Type application/json
+		header @rule2 Access-Control-Allow-Origin *
+		respond @rule2 ` + "`" + `\{"m.server": "matrix.example.com:443"\}` + "`" + ` 200
+		respond @rule3 204
+		redir @rule4 https://docs.example.com/{re.rule4.1}{?query} 301
+		redir @rule5 https://example.com/\{new\} 308
+		redir https://example.com/ 302
+	}
+}
+`},
+	} {
+		if got := exportConfig("go.example.com", rules, tc.format); got != tc.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tc.format, got, tc.want)
+		}
+	}
+
+	canonical := redirect.ParseAll([]string{"Canonicalizes to www.example.com", "Redirects from /$ to https://example.com/$"})
+	nginx := exportConfig("example.com", canonical, "nginx")
+	if !strings.Contains(nginx, "return 301 $scheme://www.example.com$request_uri;") || !strings.Contains(nginx, "# Skipped, as nginx can't return a $ literally") {
+		t.Errorf("nginx:\n%s", nginx)
+	}
+	if caddy := exportConfig("example.com", canonical, "caddy"); !strings.Contains(caddy, "redir {scheme}://www.example.com{uri} 301") {
+		t.Errorf("caddy:\n%s", caddy)
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	getenv := func(string) string { return "" }
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-format", "nginx"}, "-host"},
+		{[]string{"-host", "go.example.com", "-format", "apache"}, "-format"},
+		{[]string{"-host", "go.example.com", "extra"}, "unexpected argument"},
+	} {
+		if err := runExport(context.Background(), tc.args, getenv, io.Discard); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got %v, want %q", tc.args, err, tc.err)
+		}
+	}
+}
@@ -3,8 +3,16 @@ module github.com/frolic/redirect.name
 go 1.25.0
 
 require (
-	golang.org/x/crypto v0.48.0
-	golang.org/x/net v0.51.0
+	github.com/quic-go/quic-go v0.61.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.56.0
+	golang.org/x/sync v0.22.0
+	golang.org/x/sys v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/text v0.34.0 // indirect
+require (
+	github.com/kr/text v0.2.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+)
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/grpc"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// grpcService prefixes the methods of the gRPC control API, defined in
+// admin.proto.
+const grpcService = "/redirectname.admin.v1.Admin/"
+
+// grpcScopes are the scopes of the gRPC control API's methods, those of
+// their HTTP endpoints.
+var grpcScopes = map[string]string{
+	"GetTop":                 scopeRead,
+	"PurgeCache":             scopeCache,
+	"ListSuspensions":        scopeRead,
+	"LiftSuspension":         scopeSuspensions,
+	"ListCertificates":       scopeRead,
+	"ListCertificateErrors":  scopeRead,
+	"RenewCertificate":       scopeCertificates,
+	"DeleteCertificate":      scopeCertificates,
+	"PurgeCertificatePolicy": scopeCertificates,
+	"GetMaintenance":         scopeRead,
+	"StartMaintenance":       scopeMaintenance,
+	"StopMaintenance":        scopeMaintenance,
+}
+
+// newGRPCServer returns the server for grpc_addr: the gRPC control API
+// over TLS, for clients with a certificate from grpc_client_ca only.
+// admin_auth_file, if set, says which of them may call which methods.
+func newGRPCServer(cfg *config, quota *hostQuota) (*http.Server, error) {
+	config, err := loadServerTLS(cfg.GRPCCert, cfg.GRPCKey, cfg.GRPCClientCA, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("grpc_addr: %w", err)
+	}
+	config.NextProtos = []string{"h2"}
+	auth := &adminAuth{token: cfg.AdminToken, credentials: adminCredentials, clientCerts: true}
+	return &http.Server{Handler: recoverPanics(auth.requireGRPC(newGRPCAdmin(quota))), TLSConfig: config}, nil
+}
+
+// requireGRPC returns next, refusing calls from callers who may not use
+// their method's scope, and audits them as require does.
+func (a *adminAuth) requireGRPC(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, grpcService)
+		scope, ok := grpcScopes[method]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		who, status := a.authorize(r, scope)
+		switch status {
+		case http.StatusUnauthorized:
+			grpc.WriteError(w, grpc.Errorf(grpc.Unauthenticated, "unknown caller"))
+		case http.StatusForbidden:
+			grpc.WriteError(w, grpc.Errorf(grpc.PermissionDenied, "%s may not use the %s scope", who, scope))
+		}
+		if status != 0 {
+			audit(r, who, "gRPC "+method, "grpc-status "+w.Header().Get("Grpc-Status"))
+			return
+		}
+		next.ServeHTTP(w, r)
+		if scope != scopeRead {
+			audit(r, who, "gRPC "+method, "grpc-status "+w.Header().Get("Grpc-Status"))
+		}
+	})
+}
+
+// newGRPCAdmin returns the gRPC control API, which does what the admin
+// listener's HTTP API does, for fleet tooling managing many instances.
+// As there, the methods of features that are off aren't served.
+func newGRPCAdmin(quota *hostQuota) *grpc.Server {
+	s := grpc.NewServer()
+	if topHosts != nil {
+		s.Handle(grpcService+"GetTop", func(ctx context.Context, req []byte) ([]byte, error) {
+			fields, err := grpc.Decode(req)
+			if err != nil {
+				return nil, grpc.Errorf(grpc.InvalidArgument, "%v", err)
+			}
+			n := 10
+			for _, f := range fields {
+				if f.Num == 1 {
+					n = int(int32(f.Varint))
+				}
+			}
+			if n < 1 {
+				return nil, grpc.Errorf(grpc.InvalidArgument, "n must be positive")
+			}
+			hosts, rules := topHosts.top(n)
+			resp := grpc.AppendTime(nil, 1, topHosts.since)
+			for _, h := range hosts {
+				resp = grpc.AppendMessage(resp, 2, encodeHostCount(h))
+			}
+			for _, r := range rules {
+				resp = grpc.AppendMessage(resp, 3, encodeHostCount(r))
+			}
+			return resp, nil
+		})
+	}
+	if configSources != nil {
+		s.Handle(grpcService+"PurgeCache", func(ctx context.Context, req []byte) ([]byte, error) {
+			host, err := grpcHost(req)
+			if err != nil {
+				return nil, err
+			}
+			configSources.purge(host)
+			log.Printf("Purged cached rules of %s", host)
+			return nil, nil
+		})
+	}
+	if quota != nil {
+		s.Handle(grpcService+"ListSuspensions", func(ctx context.Context, req []byte) ([]byte, error) {
+			var resp []byte
+			for _, s := range quota.suspensions() {
+				var msg []byte
+				msg = grpc.AppendString(msg, 1, s.Host)
+				msg = grpc.AppendTime(msg, 2, s.Since)
+				msg = grpc.AppendTime(msg, 3, s.Until)
+				msg = grpc.AppendVarint(msg, 4, uint64(s.Throttled))
+				resp = grpc.AppendMessage(resp, 1, msg)
+			}
+			return resp, nil
+		})
+		s.Handle(grpcService+"LiftSuspension", func(ctx context.Context, req []byte) ([]byte, error) {
+			host, err := grpcHost(req)
+			if err != nil {
+				return nil, err
+			}
+			if !quota.lift(host) {
+				return nil, grpc.Errorf(grpc.NotFound, "%s is not suspended", host)
+			}
+			log.Printf("Lifted suspension of %s", host)
+			return nil, nil
+		})
+	}
+	if certs != nil {
+		s.Handle(grpcService+"ListCertificates", func(ctx context.Context, req []byte) ([]byte, error) {
+			list, err := certs.list(ctx)
+			if err != nil {
+				return nil, grpc.Errorf(grpc.Internal, "listing certificates: %v", err)
+			}
+			var resp []byte
+			for _, info := range list {
+				resp = grpc.AppendMessage(resp, 1, encodeCertInfo(info))
+			}
+			return resp, nil
+		})
+		s.Handle(grpcService+"ListCertificateErrors", func(ctx context.Context, req []byte) ([]byte, error) {
+			var resp []byte
+			for _, e := range recentCertErrors.list() {
+				var msg []byte
+				msg = grpc.AppendString(msg, 1, e.Host)
+				msg = grpc.AppendString(msg, 2, e.Error)
+				msg = grpc.AppendVarint(msg, 3, uint64(e.Count))
+				msg = grpc.AppendTime(msg, 4, e.First)
+				msg = grpc.AppendTime(msg, 5, e.Last)
+				resp = grpc.AppendMessage(resp, 1, msg)
+			}
+			return resp, nil
+		})
+		s.Handle(grpcService+"RenewCertificate", func(ctx context.Context, req []byte) ([]byte, error) {
+			host, err := grpcHost(req)
+			if err != nil {
+				return nil, err
+			}
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			defer cancel()
+			info, err := certs.renew(ctx, host)
+			if err != nil {
+				recentCertErrors.record(host, err)
+				return nil, grpc.Errorf(grpc.Unavailable, "renewing %s: %v", host, err)
+			}
+			log.Printf("Renewed certificate for %s, valid until %s", host, info.NotAfter.Format(time.RFC3339))
+			return encodeCertInfo(info), nil
+		})
+		s.Handle(grpcService+"DeleteCertificate", func(ctx context.Context, req []byte) ([]byte, error) {
+			host, err := grpcHost(req)
+			if err != nil {
+				return nil, err
+			}
+			fields, _ := grpc.Decode(req)
+			revoke := false
+			for _, f := range fields {
+				if f.Num == 2 {
+					revoke = f.Varint != 0
+				}
+			}
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			found, err := certs.remove(ctx, host, revoke)
+			switch {
+			case err != nil:
+				return nil, grpc.Errorf(grpc.Unavailable, "deleting %s: %v", host, err)
+			case !found:
+				return nil, grpc.Errorf(grpc.NotFound, "no certificate for %s", host)
+			}
+			log.Printf("Deleted certificate for %s", host)
+			return nil, nil
+		})
+		s.Handle(grpcService+"PurgeCertificatePolicy", func(ctx context.Context, req []byte) ([]byte, error) {
+			host, err := grpcHost(req)
+			if err != nil {
+				return nil, err
+			}
+			certs.purgePolicy(host)
+			return nil, nil
+		})
+	}
+	if maintenance != nil {
+		status := func() []byte {
+			window := maintenance.on.Load()
+			if window == nil {
+				return nil
+			}
+			msg := grpc.AppendBool(nil, 1, true)
+			msg = grpc.AppendString(msg, 2, window.message)
+			if window.retryAfter > 0 {
+				msg = grpc.AppendDuration(msg, 3, window.retryAfter)
+			}
+			return grpc.AppendTime(msg, 4, window.since)
+		}
+		s.Handle(grpcService+"GetMaintenance", func(ctx context.Context, req []byte) ([]byte, error) {
+			return status(), nil
+		})
+		s.Handle(grpcService+"StartMaintenance", func(ctx context.Context, req []byte) ([]byte, error) {
+			fields, err := grpc.Decode(req)
+			if err != nil {
+				return nil, grpc.Errorf(grpc.InvalidArgument, "%v", err)
+			}
+			message, retryAfter := "", maintenance.retryAfter
+			for _, f := range fields {
+				switch f.Num {
+				case 1:
+					message = f.String()
+				case 2:
+					if retryAfter, err = grpc.DecodeDuration(f.Bytes); err != nil || retryAfter < 0 {
+						return nil, grpc.Errorf(grpc.InvalidArgument, "retry_after must be a duration of at least 0")
+					}
+				}
+			}
+			maintenance.start(message, retryAfter)
+			log.Print("Maintenance mode on")
+			return status(), nil
+		})
+		s.Handle(grpcService+"StopMaintenance", func(ctx context.Context, req []byte) ([]byte, error) {
+			if !maintenance.stop() {
+				return nil, grpc.Errorf(grpc.NotFound, "maintenance mode is not on")
+			}
+			log.Print("Maintenance mode off")
+			return nil, nil
+		})
+	}
+	return s
+}
+
+// grpcHost returns the host of a HostRequest, or of a request whose first
+// field is one too.
+func grpcHost(req []byte) (string, error) {
+	fields, err := grpc.Decode(req)
+	if err != nil {
+		return "", grpc.Errorf(grpc.InvalidArgument, "%v", err)
+	}
+	for _, f := range fields {
+		if f.Num == 1 {
+			host, err := redirect.ParseHost(f.String())
+			if err != nil {
+				break
+			}
+			return host, nil
+		}
+	}
+	return "", grpc.Errorf(grpc.InvalidArgument, "host must be a hostname")
+}
+
+func encodeHostCount(c hostCount) []byte {
+	var msg []byte
+	msg = grpc.AppendString(msg, 1, c.Host)
+	msg = grpc.AppendString(msg, 2, c.Rule)
+	msg = grpc.AppendVarint(msg, 3, c.Requests)
+	return grpc.AppendVarint(msg, 4, c.MaxOvercount)
+}
+
+func encodeCertInfo(info certInfo) []byte {
+	var msg []byte
+	msg = grpc.AppendString(msg, 1, info.Name)
+	msg = grpc.AppendString(msg, 2, info.Key)
+	for _, name := range info.Names {
+		msg = grpc.AppendString(msg, 3, name)
+	}
+	msg = grpc.AppendString(msg, 4, info.KeyType)
+	msg = grpc.AppendString(msg, 5, info.Issuer)
+	msg = grpc.AppendString(msg, 6, info.CA)
+	msg = grpc.AppendTime(msg, 7, info.NotBefore)
+	msg = grpc.AppendTime(msg, 8, info.NotAfter)
+	msg = grpc.AppendBool(msg, 9, info.DNS01)
+	return grpc.AppendString(msg, 10, info.Error)
+}
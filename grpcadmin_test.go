@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/grpc"
+)
+
+// newTestPKI writes a CA's certificate, and a server certificate for
+// 127.0.0.1 and its key, to dir, and returns a client certificate the CA
+// signed.
+func newTestPKI(t *testing.T, dir string) tls.Certificate {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fleet CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "fleet"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	write := func(name, typ string, der []byte) {
+		os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600)
+	}
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(serverKey)
+	write("ca.pem", "CERTIFICATE", caDER)
+	write("server.pem", "CERTIFICATE", serverDER)
+	write("server-key.pem", "PRIVATE KEY", keyDER)
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	return tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestGRPCAdmin(t *testing.T) {
+	origMaintenance, origCerts, origTop, origCreds := maintenance, certs, topHosts, adminCredentials
+	t.Cleanup(func() {
+		maintenance, certs, topHosts, adminCredentials = origMaintenance, origCerts, origTop, origCreds
+	})
+	certs, topHosts, adminCredentials = nil, nil, nil
+	dir := t.TempDir()
+	clientCert := newTestPKI(t, dir)
+	cfg := defaultConfig()
+	cfg.GRPCCert = filepath.Join(dir, "server.pem")
+	cfg.GRPCKey = filepath.Join(dir, "server-key.pem")
+	cfg.GRPCClientCA = filepath.Join(dir, "ca.pem")
+	maintenance = newMaintenanceMode(cfg)
+	cfg.HostRateLimit, cfg.HostRateLimitBurst, cfg.HostSuspendAfter, cfg.HostSuspendFor = 1, 1, 1, time.Hour
+	quota := newHostQuota(cfg)
+	quota.check("spam.example.com")
+	quota.check("spam.example.com")
+
+	listen := func() string {
+		srv, err := newGRPCServer(cfg, quota)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.ServeTLS(ln, "", "")
+		t.Cleanup(func() { srv.Close() })
+		return "https://" + ln.Addr().String()
+	}
+	target := listen()
+
+	caPEM, _ := os.ReadFile(cfg.GRPCClientCA)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	clientWith := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+	client := clientWith(clientCert)
+	ctx := context.Background()
+	call := func(method string, req []byte) ([]byte, grpc.Code) {
+		t.Helper()
+		resp, err := grpc.Invoke(ctx, client, target, grpcService+method, req)
+		var e *grpc.Error
+		if errors.As(err, &e) {
+			return nil, e.Code
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		return resp, grpc.OK
+	}
+
+	if _, err := grpc.Invoke(ctx, clientWith(), target, grpcService+"ListSuspensions", nil); err == nil {
+		t.Error("call without a client certificate succeeded")
+	}
+
+	resp, code := call("ListSuspensions", nil)
+	fields, _ := grpc.Decode(resp)
+	if code != grpc.OK || len(fields) != 1 {
+		t.Fatalf("ListSuspensions: %v, %d suspensions", code, len(fields))
+	}
+	if inner, _ := grpc.Decode(fields[0].Bytes); len(inner) == 0 || inner[0].String() != "spam.example.com" {
+		t.Errorf("suspension: %+v", inner)
+	}
+	host := grpc.AppendString(nil, 1, "spam.example.com")
+	if _, code := call("LiftSuspension", host); code != grpc.OK {
+		t.Errorf("LiftSuspension: %v", code)
+	}
+	if _, code := call("LiftSuspension", host); code != grpc.NotFound {
+		t.Errorf("LiftSuspension again: %v", code)
+	}
+	if _, code := call("LiftSuspension", grpc.AppendString(nil, 1, "not a host")); code != grpc.InvalidArgument {
+		t.Errorf("LiftSuspension of a bad host: %v", code)
+	}
+
+	var start []byte
+	start = grpc.AppendString(start, 1, "Moving house.")
+	start = grpc.AppendDuration(start, 2, 10*time.Minute)
+	resp, code = call("StartMaintenance", start)
+	if code != grpc.OK {
+		t.Fatalf("StartMaintenance: %v", code)
+	}
+	fields, _ = grpc.Decode(resp)
+	if len(fields) != 4 || fields[0].Varint != 1 || fields[1].String() != "Moving house." {
+		t.Errorf("StartMaintenance: %+v", fields)
+	}
+	if retry, _ := grpc.DecodeDuration(fields[2].Bytes); retry != 10*time.Minute {
+		t.Errorf("retry_after %v", retry)
+	}
+	if window := maintenance.on.Load(); window == nil || window.retryAfter != 10*time.Minute {
+		t.Errorf("maintenance mode: %+v", window)
+	}
+	if _, code := call("StopMaintenance", nil); code != grpc.OK {
+		t.Errorf("StopMaintenance: %v", code)
+	}
+	if resp, code := call("GetMaintenance", nil); code != grpc.OK || len(resp) != 0 {
+		t.Errorf("GetMaintenance after stopping: %v, %x", code, resp)
+	}
+
+	// Features that are off have no methods, as they have no endpoints.
+	if _, code := call("ListCertificates", nil); code != grpc.Unimplemented {
+		t.Errorf("ListCertificates without cert_dir: %v", code)
+	}
+	if _, code := call("GetTop", nil); code != grpc.Unimplemented {
+		t.Errorf("GetTop without top hosts: %v", code)
+	}
+
+	// With admin_auth_file, certificates have their credential's scopes.
+	adminCredentials = []adminCredential{{Name: "reader", ClientCert: "fleet", Scopes: []string{scopeRead}}}
+	target = listen()
+	if _, code := call("ListSuspensions", nil); code != grpc.OK {
+		t.Errorf("ListSuspensions with the read scope: %v", code)
+	}
+	if _, code := call("StopMaintenance", nil); code != grpc.PermissionDenied {
+		t.Errorf("StopMaintenance with the read scope: %v", code)
+	}
+	adminCredentials = []adminCredential{{Name: "other", ClientCert: "other", Scopes: []string{scopeAll}}}
+	target = listen()
+	if _, code := call("ListSuspensions", nil); code != grpc.Unauthenticated {
+		t.Errorf("ListSuspensions with an unknown certificate: %v", code)
+	}
+}
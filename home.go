@@ -0,0 +1,34 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+//go:embed home.html
+var homePage []byte
+
+// serveHome serves the homepage, and the check API it uses, to requests for
+// the service's own canonical host, passing every other host to next. The
+// canonical host would otherwise be looked up like a customer's and, with
+// no rules of its own, sent to a fallback URL that is usually itself. The
+// check API answers for the hosts filter (if not nil) serves only.
+func serveHome(canonical string, filter *hostFilter, checks []redirect.TargetChecker, readyz, next http.Handler) http.Handler {
+	home := http.NewServeMux()
+	home.HandleFunc("/healthz", healthzHandler)
+	home.Handle("/readyz", readyz)
+	home.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(homePage)
+	})
+	home.HandleFunc("GET /api/check", handleCheck(filter, checks))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host, err := redirect.ParseHost(r.Host); err == nil && host == canonical {
+			home.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHome(t *testing.T) {
+	stubTXT(t, []string{"Redirects from /docs/* to https://docs.example.com/*", "Redirects from /bad to https://bad.example/"}, nil)
+	cfg := defaultConfig()
+	cfg.CanonicalHost = "Redirect.name"
+	cfg.DeniedHosts = "secret.corp.example"
+	b := &redirect.Blocklist{}
+	b.Set([]string{"bad.example"})
+	h := newMux(cfg, nil, b)
+
+	get := func(host, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("redirect.name:443", "/")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Test your domain") {
+		t.Fatalf("homepage: got %d:\n%s", rr.Code, rr.Body)
+	}
+	if rr := get("redirect.name", "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("health check on the canonical host: got %d", rr.Code)
+	}
+	if rr := get("redirect.name", "/elsewhere"); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown page on the canonical host: want 404, got %d", rr.Code)
+	}
+	if rr := get("go.example.com", "/docs/intro"); rr.Header().Get("Location") != "https://docs.example.com/intro" {
+		t.Errorf("other hosts must still redirect, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	check := func(query string) checkResult {
+		t.Helper()
+		rr := get("redirect.name", "/api/check?"+query)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("check %s: got %d: %s", query, rr.Code, rr.Body)
+		}
+		var result checkResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	result := check("host=Go.Example.com&path=/docs/intro")
+	if result.Host != "go.example.com" || result.RecordName != "_redirect.go.example.com" || len(result.Rules) != 2 ||
+		result.Location != "https://docs.example.com/intro" || result.Status != http.StatusFound || result.Error != "" {
+		t.Errorf("unexpected check result %+v", result)
+	}
+	if result := check("host=go.example.com&path=nowhere"); result.Path != "/nowhere" || result.Error == "" {
+		t.Errorf("want an error for an unmatched path, got %+v", result)
+	}
+	if result := check("host=go.example.com&path=/bad"); !strings.Contains(result.Blocked, "blocklist") {
+		t.Errorf("want a blocked result, got %+v", result)
+	}
+	if rr := get("redirect.name", "/api/check?host=not%20a%20host"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid host: want 400, got %d", rr.Code)
+	}
+	if rr := get("redirect.name", "/api/check?host=secret.corp.example"); rr.Code != http.StatusMisdirectedRequest {
+		t.Errorf("denied host: want 421, got %d", rr.Code)
+	}
+}
+
+func TestCheckLocked(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubTXT(t, []string{
+		"Redirects from /private to https://secret.example.com/plans requiring key=hunter2",
+		"Redirects from /team to https://secret.example.com/team protected by alice:" + string(hash),
+	}, nil)
+	checked := 0
+	h := handleCheck(nil, []redirect.TargetChecker{redirect.TargetCheckerFunc(func(ctx context.Context, location string) error {
+		checked++
+		return &redirect.BlockedError{Status: http.StatusForbidden, Reason: "it leads to " + location}
+	})})
+	for _, path := range []string{"/private", "/team"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/check?host=go.example.com&path="+path, nil))
+		if strings.Contains(rr.Body.String(), "secret.example.com") {
+			t.Errorf("%s: the check API gave a locked destination away: %s", path, rr.Body)
+		}
+		var result checkResult
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		if result.State != "locked" || result.Location != "" || result.Rules[0].State != "locked" || result.Rules[1].State != "locked" {
+			t.Errorf("%s: want it reported locked, got %+v", path, result)
+		}
+	}
+	if checked != 0 {
+		t.Errorf("want no target checks of locked destinations, got %d", checked)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// hostFilter restricts which hostnames are served at all, from
+// allowed_hosts and denied_hosts. A pattern is an exact name, "*.example.com"
+// for any subdomain of example.com, or ".example.com" for example.com and
+// its subdomains.
+type hostFilter struct {
+	allowed []string
+	denied  []string
+}
+
+// newHostFilter returns the filter configured by cfg, or nil if neither
+// list is set.
+func newHostFilter(cfg *config) *hostFilter {
+	allowed, _ := parseHostPatterns(cfg.AllowedHosts)
+	denied, _ := parseHostPatterns(cfg.DeniedHosts)
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+	return &hostFilter{allowed: allowed, denied: denied}
+}
+
+// parseHostPatterns splits a comma-separated pattern list, converting names
+// to their lowercased ASCII form.
+func parseHostPatterns(v string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSuffix(strings.TrimSpace(p), ".")
+		if p == "" {
+			continue
+		}
+		prefix, name := "", p
+		if rest, ok := strings.CutPrefix(p, "*."); ok {
+			prefix, name = "*.", rest
+		} else if rest, ok := strings.CutPrefix(p, "."); ok {
+			prefix, name = ".", rest
+		}
+		name, err := redirect.ASCIIHost(name)
+		if err != nil || name == "" || strings.ContainsAny(name, "*/:@ \t") {
+			return nil, fmt.Errorf("%q is not a hostname or suffix pattern", p)
+		}
+		patterns = append(patterns, prefix+name)
+	}
+	return patterns, nil
+}
+
+// serves reports whether host, as returned by redirect.ParseHost, may be
+// served: it matches no denied pattern and, if there are allowed patterns,
+// one of them.
+func (f *hostFilter) serves(host string) bool {
+	if matchHost(f.denied, host) {
+		return false
+	}
+	return len(f.allowed) == 0 || matchHost(f.allowed, host)
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		switch {
+		case strings.HasPrefix(p, "*."):
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		case strings.HasPrefix(p, "."):
+			if host == p[1:] || strings.HasSuffix(host, p) {
+				return true
+			}
+		case host == p:
+			return true
+		}
+	}
+	return false
+}
+
+// filterHosts refuses requests for hostnames f doesn't serve with 421
+// Misdirected Request, before any lookup is made for them. Health checks
+// are exempt, and invalid Host headers are left to the redirect handler to
+// refuse.
+func filterHosts(f *hostFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host, err := redirect.ParseHost(r.Host); err == nil && !isHealthCheck(r) && !f.serves(host) {
+			http.Error(w, "This server does not serve "+r.Host, http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// filteredHostPolicy is hostPolicy, refusing certificates for hostnames f
+// (if not nil) doesn't serve and allowing the canonical host, which has no
+// rules of its own.
+func filteredHostPolicy(f *hostFilter, canonical string) func(ctx context.Context, host string) error {
+	return func(ctx context.Context, host string) error {
+		h, err := redirect.ParseHost(host)
+		if err != nil || (f != nil && !f.serves(h)) {
+			return errors.New(host + " is not served by this server")
+		}
+		if canonical != "" && h == canonical {
+			return nil
+		}
+		return hostPolicy(ctx, host)
+	}
+}
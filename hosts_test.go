@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestHostFilter(t *testing.T) {
+	stubTXT(t, []string{"Redirects to https://example.com/"}, nil)
+	cfg := defaultConfig()
+	cfg.AllowedHosts = "go.example.com, *.links.example, .corp.example"
+	cfg.DeniedHosts = "secret.corp.example"
+	h := newMux(cfg, nil)
+
+	for host, want := range map[string]int{
+		"go.example.com":       http.StatusFound,
+		"GO.example.com.:8080": http.StatusFound,
+		"a.links.example":      http.StatusFound,
+		"links.example":        http.StatusMisdirectedRequest,
+		"corp.example":         http.StatusFound,
+		"wiki.corp.example":    http.StatusFound,
+		"secret.corp.example":  http.StatusMisdirectedRequest,
+		"evil.example":         http.StatusMisdirectedRequest,
+		"notgo.example.com":    http.StatusMisdirectedRequest,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: want %d, got %d", host, want, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.Host = "evil.example"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("health check: want 200, got %d", rr.Code)
+	}
+
+	policy := filteredHostPolicy(newHostFilter(cfg), "")
+	if err := policy(context.Background(), "evil.example"); err == nil {
+		t.Error("want no certificate for a host that isn't served")
+	}
+	if err := policy(context.Background(), "go.example.com"); err != nil {
+		t.Errorf("want a certificate for go.example.com, got %v", err)
+	}
+}
+
+func TestHostPolicyFlagsOnly(t *testing.T) {
+	stubTXT(t, []string{redirect.FlagPublicStats}, nil)
+	policy := filteredHostPolicy(newHostFilter(defaultConfig()), "")
+	if err := policy(context.Background(), "go.example.com"); err == nil {
+		t.Error("want no certificate for a host with only flag records")
+	}
+}
+
+func TestHostPolicySharesLookups(t *testing.T) {
+	var lookups int
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.NewCache(redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		lookups++
+		if host == "spf.example.com" {
+			return redirect.ParseAll([]string{"v=spf1 -all"}), nil
+		}
+		return redirect.ParseAll([]string{"Redirects to https://example.com/"}), nil
+	}), time.Minute)
+	policy := filteredHostPolicy(nil, "")
+	ctx := context.Background()
+
+	// Handshakes and then the request for a host share one lookup.
+	for range 3 {
+		if err := policy(ctx, "go.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	newMux(defaultConfig(), nil).ServeHTTP(w, req)
+	if w.Code != http.StatusFound || lookups != 1 {
+		t.Errorf("got %d after %d lookups", w.Code, lookups)
+	}
+
+	// Refusals are cached too.
+	for range 3 {
+		if err := policy(ctx, "spf.example.com"); err == nil {
+			t.Fatal("spf.example.com allowed")
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("%d lookups", lookups)
+	}
+}
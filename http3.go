@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server sharing the HTTPS listener's
+// certificates.
+func newHTTP3Server(cfg *config, tlsConfig *tls.Config, h http.Handler) *http3.Server {
+	return &http3.Server{
+		Handler:        h,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+}
+
+// altSvc advertises h3 on responses from h, so clients can make their next
+// request over HTTP/3.
+func altSvc(h3 *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h3.SetQUICHeaders(w.Header()); err != nil {
+			log.Printf("Alt-Svc: %v", err)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTP3(t *testing.T) {
+	// Borrow httptest's self-signed certificate.
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	tlsConfig := &tls.Config{Certificates: ts.TLS.Certificates}
+
+	h3 := newHTTP3Server(defaultConfig(), tlsConfig, http.HandlerFunc(healthzHandler))
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h3.Serve(conn)
+	defer h3.Close()
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("https://" + conn.LocalAddr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Errorf("want 200 over HTTP/3, got %d over %s", resp.StatusCode, resp.Proto)
+	}
+
+	rec := httptest.NewRecorder()
+	altSvc(h3, http.HandlerFunc(healthzHandler)).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	if got := rec.Header().Get("Alt-Svc"); !strings.Contains(got, `h3=":`+port+`"`) {
+		t.Errorf("Alt-Svc: want h3 on port %s, got %q", port, got)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// importFormats are the configuration files `redirect-name import` reads.
+var importFormats = []string{"netlify", "vercel"}
+
+// runImport runs `redirect-name import` with args, such as
+//
+//	-host go.example.com _redirects
+//
+// writing the TXT records equivalent to a Netlify _redirects file's or a
+// vercel.json's redirects to out, in zone file syntax, for hosts moving
+// to the service. Nothing is written to DNS.
+func runImport(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	host := fs.String("host", "", "hostname the redirects are for")
+	format := fs.String("format", "", "file to read: "+strings.Join(importFormats, ", ")+"; by default, vercel for .json files and netlify otherwise")
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("give one _redirects or vercel.json file")
+	}
+	h, err := redirect.ParseHost(*host)
+	if err != nil || h == "" {
+		return fmt.Errorf("-host must be a hostname, such as go.example.com")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = "netlify"
+		if filepath.Ext(path) == ".json" {
+			*format = "vercel"
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var imported []importedRule
+	switch *format {
+	case "netlify":
+		imported = importNetlify(data, h)
+	case "vercel":
+		if imported, err = importVercel(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("-format must be one of %s", strings.Join(importFormats, ", "))
+	}
+	name := redirect.RecordName(h)
+	fmt.Fprintf(out, "; TXT records at %s for the redirects of %s.\n", name, path)
+	for _, r := range imported {
+		if r.skipped != "" {
+			fmt.Fprintf(out, "; Skipped %s, as %s\n", r.source, r.skipped)
+			continue
+		}
+		fmt.Fprintf(out, "%s. TXT %s\n", name, txtValue(r.record))
+	}
+	return nil
+}
+
+// An importedRule is the record a redirect of an imported file becomes, or
+// why it can't become one.
+type importedRule struct {
+	source  string // where in the file the redirect is, for messages
+	record  string
+	skipped string
+}
+
+// placeholderRE matches the placeholders of Netlify's and Vercel's paths,
+// such as :slug.
+var placeholderRE = regexp.MustCompile(`:\w+`)
+
+// hasPlaceholder reports whether the path of target, a path or a URL, has
+// a placeholder.
+func hasPlaceholder(target string) bool {
+	if u, err := url.Parse(target); err == nil {
+		return placeholderRE.MatchString(u.Path)
+	}
+	return placeholderRE.MatchString(target)
+}
+
+// importNetlify converts the lines of a _redirects file, such as
+//
+//	/news/*  /blog/:splat  301
+//
+// the redirects of host's paths, to records.
+func importNetlify(data []byte, host string) []importedRule {
+	var rules []importedRule
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		r := importedRule{source: fmt.Sprintf("line %d (%s)", i+1, strings.Join(fields, " "))}
+		status := 301 // Netlify's default
+		switch {
+		case len(fields) < 2:
+			r.skipped = "it has no target"
+		case strings.Contains(fields[1], "=") && !strings.HasPrefix(fields[1], "/") && !strings.Contains(fields[1], "://"):
+			r.skipped = "query parameters can't be matched"
+		case len(fields) > 3:
+			r.skipped = "conditions such as Country= have no equivalent"
+		case len(fields) == 3:
+			var err error
+			if status, err = strconv.Atoi(strings.TrimSuffix(fields[2], "!")); err != nil {
+				r.skipped = fmt.Sprintf("%q is not a status", fields[2])
+			}
+		}
+		if r.skipped == "" {
+			from := fields[0]
+			if u, err := url.Parse(from); err == nil && u.Host != "" {
+				if !strings.EqualFold(u.Hostname(), host) {
+					rules = append(rules, importedRule{source: r.source, skipped: "it's for another host"})
+					continue
+				}
+				from = u.EscapedPath()
+			}
+			to := strings.Replace(fields[1], ":splat", "*", 1)
+			if hasPlaceholder(from) || hasPlaceholder(to) {
+				r.skipped = "placeholders other than :splat have no equivalent"
+			} else {
+				r.record, r.skipped = importRecord(from, to, status)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// A vercelRedirect is one of vercel.json's redirects.
+type vercelRedirect struct {
+	Source      string          `json:"source"`
+	Destination string          `json:"destination"`
+	Permanent   *bool           `json:"permanent"`
+	StatusCode  int             `json:"statusCode"`
+	Has         json.RawMessage `json:"has"`
+	Missing     json.RawMessage `json:"missing"`
+}
+
+// vercelWildcardRE matches the last segment of a Vercel source matching
+// the rest of the path, such as /:path* or /:path(.*), and the name of the
+// parameter the destination gives it by.
+var vercelWildcardRE = regexp.MustCompile(`/:(\w+)(?:\*|\(\.\*\))$`)
+
+// importVercel converts the redirects of a vercel.json, such as
+//
+//	{"source": "/blog/:slug*", "destination": "/news/:slug*"}
+//
+// to records.
+func importVercel(data []byte) ([]importedRule, error) {
+	var config struct {
+		Redirects []vercelRedirect `json:"redirects"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	var rules []importedRule
+	for i, v := range config.Redirects {
+		r := importedRule{source: fmt.Sprintf("redirect %d (%s to %s)", i+1, v.Source, v.Destination)}
+		// Vercel's redirects are permanent, with 308, unless they say
+		// otherwise.
+		status := 308
+		switch {
+		case v.StatusCode != 0:
+			status = v.StatusCode
+		case v.Permanent != nil && !*v.Permanent:
+			status = 307
+		}
+		from, to := v.Source, v.Destination
+		if m := vercelWildcardRE.FindStringSubmatch(from); m != nil {
+			from = strings.TrimSuffix(from, m[0]) + "/*"
+			to = regexp.MustCompile(`:`+m[1]+`(?:\*|\b)`).ReplaceAllLiteralString(to, "*")
+		}
+		switch {
+		case len(v.Has) > 0 || len(v.Missing) > 0:
+			r.skipped = "has and missing conditions have no equivalent"
+		case strings.ContainsAny(from, ":()"), hasPlaceholder(to):
+			r.skipped = "parameters other than one matching the rest of the path have no equivalent"
+		case strings.Count(to, "*") > 1:
+			r.skipped = "the wildcard can be used once only"
+		default:
+			r.record, r.skipped = importRecord(from, to, status)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// importRecord returns the record redirecting from to to with status, or
+// why there's none.
+func importRecord(from, to string, status int) (record, skipped string) {
+	var state string
+	switch status {
+	case 301:
+		state = "permanently"
+	case 302:
+		state = "temporarily"
+	case 307, 308:
+		state = "with " + strconv.Itoa(status)
+	case 200:
+		return "", "rewrites and proxying have no equivalent"
+	default:
+		return "", fmt.Sprintf("only redirects are imported, not %d responses", status)
+	}
+	switch {
+	case !strings.HasPrefix(from, "/"):
+		return "", "its source is not a path"
+	case strings.Count(from, "*") > 1:
+		return "", "a path can have one wildcard only"
+	case strings.Contains(to, "*") && !strings.Contains(from, "*"):
+		return "", "its target uses a wildcard its path doesn't have"
+	}
+	if err := redirect.ValidateTarget(to); err != nil {
+		return "", err.Error()
+	}
+	record = "Redirects from " + from + " to " + to + " " + state
+	if rule := redirect.Parse(record); rule == nil || rule.From != from || rule.To != to {
+		return "", "it doesn't make a valid rule"
+	}
+	return record, ""
+}
+
+// txtValue returns record as a TXT record's value in zone file syntax: in
+// character-strings of at most 255 bytes, which resolvers join back.
+func txtValue(record string) string {
+	var parts []string
+	for len(record) > 255 {
+		n := 255
+		for !utf8.RuneStart(record[n]) {
+			n--
+		}
+		parts = append(parts, strconv.Quote(record[:n]))
+		record = record[n:]
+	}
+	return strings.Join(append(parts, strconv.Quote(record)), " ")
+}
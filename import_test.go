@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportNetlify(t *testing.T) {
+	data := []byte(`# Moved sections
+/news/*    /blog/:splat    301
+/old       https://example.com/new?ref=old  302!
+/home      /               307
+/docs/*    https://docs.example.com/:splat
+https://go.example.com/x/*  https://x.example.com/:splat  308
+https://www.example.com/*   https://go.example.com/:splat 301!
+
+/store id=:id  /blog/:id  301
+/posts/:year/:slug  /blog/:slug  301
+/app/*     /index.html     200
+/fr/*      /fr/404.html    404
+/admin/*   /login          302  Role=admin
+`)
+	rules := importNetlify(data, "go.example.com")
+	if len(rules) != 11 {
+		t.Fatalf("%d rules: %+v", len(rules), rules)
+	}
+	for i, want := range []struct{ record, skipped string }{
+		{"Redirects from /news/* to /blog/* permanently", ""},
+		{"Redirects from /old to https://example.com/new?ref=old temporarily", ""},
+		{"Redirects from /home to / with 307", ""},
+		{"Redirects from /docs/* to https://docs.example.com/* permanently", ""},
+		{"Redirects from /x/* to https://x.example.com/* with 308", ""},
+		{"", "another host"},
+		{"", "query parameters"},
+		{"", "placeholders"},
+		{"", "rewrites"},
+		{"", "not 404 responses"},
+		{"", "conditions"},
+	} {
+		if r := rules[i]; r.record != want.record || !strings.Contains(r.skipped, want.skipped) || (want.skipped == "") != (r.skipped == "") {
+			t.Errorf("%s: got %q, skipped %q; want %q, %q", r.source, r.record, r.skipped, want.record, want.skipped)
+		}
+	}
+}
+
+func TestImportVercel(t *testing.T) {
+	data := []byte(`{
+  "cleanUrls": true,
+  "redirects": [
+    {"source": "/blog/:slug*", "destination": "/news/:slug*"},
+    {"source": "/old", "destination": "https://example.com/", "permanent": false},
+    {"source": "/docs/:path(.*)", "destination": "https://docs.example.com/:path", "statusCode": 301},
+    {"source": "/team/:name", "destination": "/people/:name"},
+    {"source": "/beta/:path*", "destination": "/", "has": [{"type": "cookie", "key": "beta"}]}
+  ]
+}`)
+	rules, err := importVercel(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct{ record, skipped string }{
+		{"Redirects from /blog/* to /news/* with 308", ""},
+		{"Redirects from /old to https://example.com/ with 307", ""},
+		{"Redirects from /docs/* to https://docs.example.com/* permanently", ""},
+		{"", "parameters other than"},
+		{"", "has and missing"},
+	} {
+		if r := rules[i]; r.record != want.record || !strings.Contains(r.skipped, want.skipped) || (want.skipped == "") != (r.skipped == "") {
+			t.Errorf("%s: got %q, skipped %q; want %q, %q", r.source, r.record, r.skipped, want.record, want.skipped)
+		}
+	}
+	if _, err := importVercel([]byte("{")); err == nil {
+		t.Error("malformed vercel.json imported")
+	}
+}
+
+func TestTXTValue(t *testing.T) {
+	if got := txtValue(`Redirects to https://example.com/"`); got != `"Redirects to https://example.com/\""` {
+		t.Errorf("got %s", got)
+	}
+	long := "Redirects from /a to https://example.com/" + strings.Repeat("é", 200)
+	got := txtValue(long)
+	parts := strings.Split(got, `" "`)
+	if len(parts) != 2 || len(parts[0])-1 > 255 || strings.Contains(got, `\x`) {
+		t.Errorf("got %d parts: %s", len(parts), got)
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vercel.json")
+	os.WriteFile(path, []byte(`{"redirects": [{"source": "/a", "destination": "/b"}, {"source": "/c/:x", "destination": "/d"}]}`), 0o600)
+	var out bytes.Buffer
+	if err := runImport(context.Background(), []string{"-host", "go.example.com", path}, os.Getenv, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`_redirect.go.example.com. TXT "Redirects from /a to /b with 308"`,
+		"; Skipped redirect 2 (/c/:x to /d), as parameters",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if err := runImport(context.Background(), []string{"-host", "go.example.com"}, os.Getenv, &out); err == nil {
+		t.Error("import without a file")
+	}
+	if err := runImport(context.Background(), []string{"-host", "go.example.com", "-format", "apache", path}, os.Getenv, &out); err == nil || !strings.Contains(err.Error(), "-format") {
+		t.Errorf("unknown format: %v", err)
+	}
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+	"github.com/frolic/redirect.name/redirect/dnstest"
 )
 
 // noFollowClient is an HTTP client that does not follow redirects,
@@ -18,15 +22,8 @@ var noFollowClient = &http.Client{
 // with the given DNS stub. The caller must call ts.Close().
 func newTestServer(t *testing.T, txt []string) *httptest.Server {
 	t.Helper()
-	orig := lookupTXT
-	t.Cleanup(func() { lookupTXT = orig })
-	lookupTXT = func(host string) ([]string, error) {
-		return txt, nil
-	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", healthzHandler)
-	mux.HandleFunc("/", redirectHandler)
-	return httptest.NewServer(mux)
+	stubTXT(t, txt, nil)
+	return httptest.NewServer(newMux(defaultConfig(), nil))
 }
 
 func TestIntegration_302(t *testing.T) {
@@ -124,14 +121,8 @@ func TestIntegration_PathMatch(t *testing.T) {
 }
 
 func TestIntegration_DNSFailure(t *testing.T) {
-	orig := lookupTXT
-	t.Cleanup(func() { lookupTXT = orig })
-	lookupTXT = func(host string) ([]string, error) {
-		return nil, &dnsError{"no such host"}
-	}
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", redirectHandler)
-	ts := httptest.NewServer(mux)
+	stubTXT(t, nil, &dnsError{"no such host"})
+	ts := httptest.NewServer(newMux(defaultConfig(), nil))
 	defer ts.Close()
 
 	resp, err := noFollowClient.Get(ts.URL + "/")
@@ -161,6 +152,49 @@ func TestIntegration_Healthz(t *testing.T) {
 	}
 }
 
+// TestIntegration_RealDNS resolves rules over the wire from an in-process
+// DNS server, covering NXDOMAIN and TCP fallback for truncated answers.
+func TestIntegration_RealDNS(t *testing.T) {
+	dns := dnstest.NewServer()
+	defer dns.Close()
+	dns.SetRedirect("127.0.0.1", "Redirects from /docs/* to https://docs.example.com/*")
+
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.DNSResolver{Resolver: dns.Resolver()}
+	ts := httptest.NewServer(newMux(defaultConfig(), nil))
+	defer ts.Close()
+
+	resp, err := noFollowClient.Get(ts.URL + "/docs/intro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "https://docs.example.com/intro" {
+		t.Errorf("Location: want https://docs.example.com/intro, got %q", loc)
+	}
+
+	dns.SetTruncate(true)
+	resp, err = noFollowClient.Get(ts.URL + "/docs/tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "https://docs.example.com/tcp" {
+		t.Errorf("Location over TCP: want https://docs.example.com/tcp, got %q", loc)
+	}
+
+	dns.Delete(redirect.RecordName("127.0.0.1"))
+	resp, err = noFollowClient.Get(ts.URL + "/docs/intro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); !strings.Contains(loc, "#reason=Could+not+resolve") {
+		t.Errorf("Location on NXDOMAIN: want fallback, got %q", loc)
+	}
+}
+
 // dnsError is a minimal error type used to simulate DNS failures.
 type dnsError struct{ msg string }
 
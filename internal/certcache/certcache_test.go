@@ -0,0 +1,397 @@
+package certcache
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/redis"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// exercise checks c misses, stores, lists, replaces and deletes entries.
+func exercise(t *testing.T, c autocert.Cache) {
+	t.Helper()
+	ctx := context.Background()
+	const key = "example.com+rsa"
+	if _, err := c.Get(ctx, key); err != autocert.ErrCacheMiss {
+		t.Fatalf("Get before Put: %v", err)
+	}
+	for _, data := range []string{"first", "second\r\n\x00"} {
+		if err := c.Put(ctx, key, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := c.Get(ctx, key); err != nil || string(got) != data {
+			t.Fatalf("Get: %q, %v; want %q", got, err, data)
+		}
+	}
+	if keys, err := List(ctx, c, "example."); err != nil || !slices.Equal(keys, []string{key}) {
+		t.Errorf("List: %q, %v", keys, err)
+	}
+	if keys, err := List(ctx, c, "other"); err != nil || len(keys) != 0 {
+		t.Errorf("List other: %q, %v", keys, err)
+	}
+	if err := c.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, key); err != autocert.ErrCacheMiss {
+		t.Errorf("Get after Delete: %v", err)
+	}
+	if err := c.Delete(ctx, key); err != nil {
+		t.Errorf("Delete again: %v", err)
+	}
+}
+
+// exerciseLock checks l's locks exclude each other until released by
+// their holder.
+func exerciseLock(t *testing.T, l Locker) {
+	t.Helper()
+	ctx := context.Background()
+	token, ok, err := l.TryLock(ctx, "lock+example.com", time.Minute)
+	if !ok || err != nil {
+		t.Fatalf("TryLock: %v, %v", ok, err)
+	}
+	if _, ok, err := l.TryLock(ctx, "lock+example.com", time.Minute); ok || err != nil {
+		t.Fatalf("TryLock while held: %v, %v", ok, err)
+	}
+	if _, ok, _ := l.TryLock(ctx, "lock+example.org", time.Minute); !ok {
+		t.Error("other lock not taken")
+	}
+	if err := l.Unlock(ctx, "lock+example.com", "someone else"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := l.TryLock(ctx, "lock+example.com", time.Minute); ok {
+		t.Fatal("released by someone else's token")
+	}
+	if err := l.Unlock(ctx, "lock+example.com", token); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := Lock(ctx, l, "lock+example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+	unlock()
+}
+
+// exerciseExpiry checks an expired object lock is taken over.
+func exerciseExpiry(t *testing.T, s objectStore) {
+	t.Helper()
+	ctx := context.Background()
+	if _, ok, _ := tryObjectLock(ctx, s, "lock+expired", -time.Second); !ok {
+		t.Fatal("TryLock failed")
+	}
+	if _, ok, err := tryObjectLock(ctx, s, "lock+expired", time.Minute); !ok || err != nil {
+		t.Errorf("expired lock not taken: %v", err)
+	}
+}
+
+// fakeBucket is an in-memory bucket for the fake S3 and GCS servers.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// serve answers r for the object name, creating it only if absent when
+// ifAbsent is set.
+func (s *fakeBucket) serve(w http.ResponseWriter, r *http.Request, name string, ifAbsent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := s.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case http.MethodPut, http.MethodPost:
+		if _, ok := s.objects[name]; ok && ifAbsent {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.objects[name], _ = io.ReadAll(r.Body)
+	case http.MethodDelete:
+		if _, ok := s.objects[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list returns the names starting with prefix.
+func (s *fakeBucket) list(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestDirCache(t *testing.T) {
+	exercise(t, autocert.DirCache(t.TempDir()))
+}
+
+func TestS3(t *testing.T) {
+	store := &fakeBucket{objects: map[string][]byte{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/certs" && r.URL.Query().Get("list-type") == "2" {
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, name := range store.list(strings.TrimPrefix(r.URL.Query().Get("prefix"), "replicas/")) {
+				fmt.Fprintf(w, "<Contents><Key>replicas/%s</Key></Contents>", name)
+			}
+			fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+			return
+		}
+		name, ok := strings.CutPrefix(r.URL.Path, "/certs/replicas/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		store.serve(w, r, name, r.Header.Get("If-None-Match") == "*")
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	c, err := New("s3://certs/replicas?region=eu-west-1&endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, c)
+	exerciseLock(t, c.(Locker))
+	exerciseExpiry(t, c.(objectStore))
+}
+
+func TestGCS(t *testing.T) {
+	store := &fakeBucket{objects: map[string][]byte{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/certs/o" && r.URL.Query().Get("uploadType") == "media" {
+			store.serve(w, r, r.URL.Query().Get("name"), r.URL.Query().Get("ifGenerationMatch") == "0")
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/certs/o" {
+			var page struct {
+				Items []map[string]string `json:"items"`
+			}
+			for _, name := range store.list(r.URL.Query().Get("prefix")) {
+				page.Items = append(page.Items, map[string]string{"name": name})
+			}
+			json.NewEncoder(w).Encode(page)
+			return
+		}
+		name, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/certs/o/")
+		if !ok || (r.Method == http.MethodGet && r.URL.Query().Get("alt") != "media") {
+			http.NotFound(w, r)
+			return
+		}
+		store.serve(w, r, name, false)
+	}))
+	defer srv.Close()
+
+	c := &GCS{Bucket: "certs", Prefix: "replicas/", Endpoint: srv.URL, Token: func(context.Context) (string, error) { return "tok", nil }}
+	exercise(t, c)
+	exerciseLock(t, c)
+	exerciseExpiry(t, c)
+	c.Put(context.Background(), "k", []byte("v"))
+	if string(store.objects["replicas/k"]) != "v" {
+		t.Errorf("objects = %q", store.objects)
+	}
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims struct{ Iss, Scope string }
+		json.Unmarshal(payload, &claims)
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig) != nil || claims.Iss != "sa@project.iam.gserviceaccount.com" || claims.Scope != gcsScope {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "tok", ExpiresIn: 3600})
+	}))
+	defer srv.Close()
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	file := filepath.Join(t.TempDir(), "key.json")
+	data, _ := json.Marshal(map[string]string{
+		"client_email": "sa@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	os.WriteFile(file, data, 0o600)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+
+	c, err := New("gs://certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := c.(*GCS).Token(context.Background()); token != "tok" || err != nil {
+		t.Errorf("got %q, %v", token, err)
+	}
+}
+
+// fakeRedis serves GET, SET, DEL and SCAN from a map, once AUTH has been sent
+// with password and SELECT chosen database 2.
+func fakeRedis(t *testing.T, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	data := map[string][]byte{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authed, db := false, 0
+				for {
+					reply, err := redis.ReadReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range reply.([]any) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					switch {
+					case args[0] == "AUTH":
+						authed = args[len(args)-1] == password
+						io.WriteString(conn, "+OK\r\n")
+					case !authed:
+						io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+					case args[0] == "SELECT":
+						db = int(args[1][0] - '0')
+						io.WriteString(conn, "+OK\r\n")
+					case db != 2:
+						io.WriteString(conn, "-ERR wrong database\r\n")
+					case args[0] == "GET":
+						if v, ok := data[args[1]]; ok {
+							io.WriteString(conn, "$"+strconv.Itoa(len(v))+"\r\n"+string(v)+"\r\n")
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					case args[0] == "SET" && len(args) > 3 && args[3] == "NX":
+						if _, ok := data[args[1]]; ok {
+							io.WriteString(conn, "$-1\r\n")
+						} else {
+							data[args[1]] = []byte(args[2])
+							io.WriteString(conn, "+OK\r\n")
+						}
+					case args[0] == "SET":
+						data[args[1]] = []byte(args[2])
+						io.WriteString(conn, "+OK\r\n")
+					case args[0] == "EVAL" && args[1] == unlockScript:
+						n := len(data)
+						if string(data[args[3]]) == args[4] {
+							delete(data, args[3])
+						}
+						io.WriteString(conn, ":"+strconv.Itoa(n-len(data))+"\r\n")
+					case args[0] == "SCAN":
+						var matched []string
+						for key := range data {
+							if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+								matched = append(matched, key)
+							}
+						}
+						io.WriteString(conn, "*2\r\n$1\r\n0\r\n*"+strconv.Itoa(len(matched))+"\r\n")
+						for _, key := range matched {
+							io.WriteString(conn, "$"+strconv.Itoa(len(key))+"\r\n"+key+"\r\n")
+						}
+					case args[0] == "DEL":
+						n := len(data)
+						delete(data, args[1])
+						io.WriteString(conn, ":"+strconv.Itoa(n-len(data))+"\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedis(t *testing.T) {
+	addr := fakeRedis(t, "pw")
+	c, err := New("redis://default:pw@" + addr + "/2?prefix=certs:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, c)
+	exerciseLock(t, c.(Locker))
+
+	c, _ = New("redis://" + addr + "/2")
+	var re redis.Error
+	if _, err := c.Get(context.Background(), "k"); !errors.As(err, &re) {
+		t.Errorf("without password: got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"dir:///tmp", "s3://", "gs:///prefix", "redis://localhost/x"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("%s: no error", u)
+		}
+	}
+	c, err := New("rediss://cache.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := c.(*Redis); r.Addr != "cache.internal:6379" || r.TLS == nil || r.Prefix != "autocert:" {
+		t.Errorf("got %+v", r)
+	}
+}
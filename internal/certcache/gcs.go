@@ -0,0 +1,295 @@
+package certcache
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	gcsScope            = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCS keeps entries as objects in a Google Cloud Storage bucket, through
+// its JSON API.
+type GCS struct {
+	Bucket string
+	Prefix string
+	// Token returns an OAuth 2 access token with read and write access to
+	// the bucket.
+	Token func(ctx context.Context) (string, error)
+	// Endpoint is the API's base URL, https://storage.googleapis.com if
+	// empty.
+	Endpoint string
+	Client   *http.Client
+}
+
+func newGCS(u *url.URL) (*GCS, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("gs: no bucket")
+	}
+	g := &GCS{Bucket: u.Host, Prefix: prefix(u)}
+	fetch := metadataToken(g)
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		var err error
+		if fetch, err = serviceAccountToken(g, file); err != nil {
+			return nil, fmt.Errorf("gs: %v", err)
+		}
+	}
+	g.Token = (&tokenCache{fetch: fetch}).token
+	return g, nil
+}
+
+func (g *GCS) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := g.do(ctx, http.MethodGet, "/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o/"+url.PathEscape(g.Prefix+key)+"?alt=media", key, nil)
+	if e, ok := err.(*statusError); ok && e.status == http.StatusNotFound {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (g *GCS) Put(ctx context.Context, key string, data []byte) error {
+	return g.upload(ctx, key, data, nil)
+}
+
+func (g *GCS) upload(ctx context.Context, key string, data []byte, q url.Values) error {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("uploadType", "media")
+	q.Set("name", g.Prefix+key)
+	_, err := g.do(ctx, http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o?"+q.Encode(), key, data)
+	return err
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	_, err := g.do(ctx, http.MethodDelete, "/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o/"+url.PathEscape(g.Prefix+key), key, nil)
+	if e, ok := err.(*statusError); ok && e.status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// List pages through the bucket's objects.
+func (g *GCS) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	q := url.Values{"prefix": {g.Prefix + prefix}, "fields": {"items(name),nextPageToken"}}
+	for {
+		data, err := g.do(ctx, http.MethodGet, "/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o?"+q.Encode(), "(list)", nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items         []struct{ Name string }
+			NextPageToken string
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("gs: listing: %w", err)
+		}
+		for _, item := range page.Items {
+			keys = append(keys, strings.TrimPrefix(item.Name, g.Prefix))
+		}
+		if page.NextPageToken == "" {
+			return keys, nil
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// create uploads on the precondition that no generation of the object
+// exists.
+func (g *GCS) create(ctx context.Context, key string, data []byte) (bool, error) {
+	err := g.upload(ctx, key, data, url.Values{"ifGenerationMatch": {"0"}})
+	if e, ok := err.(*statusError); ok && e.status == http.StatusPreconditionFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g *GCS) TryLock(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	return tryObjectLock(ctx, g, name, ttl)
+}
+
+func (g *GCS) Unlock(ctx context.Context, name, token string) error {
+	return unlockObject(ctx, g, name, token)
+}
+
+func (g *GCS) do(ctx context.Context, method, path, key string, body []byte) ([]byte, error) {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gs: token: %w", err)
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := client(g.Client).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gs: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("gs: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &statusError{"gs", method, key, resp.StatusCode}
+	}
+	return data, nil
+}
+
+// tokenCache reuses the token fetch returns until a minute before it
+// expires.
+type tokenCache struct {
+	fetch func(ctx context.Context) (token string, expiresIn int, err error)
+
+	mu     sync.Mutex
+	value  string
+	expiry time.Time
+}
+
+func (c *tokenCache) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.value != "" && time.Until(c.expiry) > time.Minute {
+		return c.value, nil
+	}
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.value, c.expiry = token, time.Now().Add(time.Duration(expiresIn)*time.Second)
+	return token, nil
+}
+
+// tokenResponse is an OAuth 2 token endpoint's answer.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func fetchToken(req *http.Request, c *http.Client) (string, int, error) {
+	resp, err := client(c).Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s: %s", req.URL.Host, resp.Status)
+	}
+	var t tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&t); err != nil {
+		return "", 0, err
+	}
+	if t.AccessToken == "" {
+		return "", 0, errors.New("no access token")
+	}
+	return t.AccessToken, t.ExpiresIn, nil
+}
+
+// metadataToken fetches the instance service account's tokens from the
+// metadata server, as on Compute Engine, GKE and Cloud Run.
+func metadataToken(g *GCS) func(context.Context) (string, int, error) {
+	return func(ctx context.Context) (string, int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return fetchToken(req, g.Client)
+	}
+}
+
+// serviceAccountToken exchanges JWTs signed with the key in a service
+// account's JSON key file for tokens.
+func serviceAccountToken(g *GCS, file string) (func(context.Context) (string, int, error), error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil || account.ClientEmail == "" {
+		return nil, fmt.Errorf("%s: not a service account key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: private key is not RSA", file)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return func(ctx context.Context) (string, int, error) {
+		assertion, err := signJWT(key, map[string]any{
+			"iss":   account.ClientEmail,
+			"scope": gcsScope,
+			"aud":   account.TokenURI,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return fetchToken(req, g.Client)
+	}, nil
+}
+
+// signJWT returns claims as a JWT signed with RS256.
+func signJWT(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
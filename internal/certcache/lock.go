@@ -0,0 +1,113 @@
+package certcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// A Locker holds named locks across every replica using the same storage.
+// All the caches New returns are Lockers.
+type Locker interface {
+	// TryLock takes the lock name for ttl, if it's free, returning a token
+	// to release it with.
+	TryLock(ctx context.Context, name string, ttl time.Duration) (token string, ok bool, err error)
+	// Unlock releases the lock name if token still holds it.
+	Unlock(ctx context.Context, name, token string) error
+}
+
+// lockRetry is how often Lock tries a lock that's held.
+var lockRetry = time.Second
+
+// Lock waits until it takes the lock name from l, for ttl, or ctx is done,
+// and returns a function releasing it.
+func Lock(ctx context.Context, l Locker, name string, ttl time.Duration) (unlock func(), err error) {
+	for {
+		token, ok, err := l.TryLock(ctx, name, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				l.Unlock(ctx, name, token)
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// objectStore is storage that can create an entry only if it's absent,
+// which object locks are built on.
+type objectStore interface {
+	autocert.Cache
+	// create stores data at key unless key exists, reporting whether it
+	// did.
+	create(ctx context.Context, key string, data []byte) (bool, error)
+}
+
+// tryObjectLock takes the lock name in s by creating an entry holding a
+// token and the lock's expiry. An expired lock, left by a replica that
+// died holding it, is removed and taken again.
+func tryObjectLock(ctx context.Context, s objectStore, name string, ttl time.Duration) (string, bool, error) {
+	token := newToken()
+	data := []byte(token + " " + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	for range 2 {
+		ok, err := s.create(ctx, name, data)
+		if err != nil || ok {
+			return token, ok, err
+		}
+		held, err := s.Get(ctx, name)
+		switch {
+		case errors.Is(err, autocert.ErrCacheMiss):
+			// Released meanwhile.
+		case err != nil:
+			return "", false, err
+		case !expired(held):
+			return "", false, nil
+		default:
+			if err := s.Delete(ctx, name); err != nil {
+				return "", false, err
+			}
+		}
+	}
+	return "", false, nil
+}
+
+func unlockObject(ctx context.Context, s objectStore, name, token string) error {
+	held, err := s.Get(ctx, name)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t, _, _ := strings.Cut(string(held), " "); t != token {
+		return nil // it expired and someone else took it
+	}
+	return s.Delete(ctx, name)
+}
+
+// expired reports whether an object lock holding data has expired.
+func expired(data []byte) bool {
+	_, exp, _ := strings.Cut(string(data), " ")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	return err != nil || time.Now().Unix() >= unix
+}
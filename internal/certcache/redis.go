@@ -0,0 +1,102 @@
+package certcache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/redis"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Redis keeps entries as string keys, starting with Prefix, in a Redis
+// database.
+type Redis struct {
+	*redis.Client
+	Prefix string
+}
+
+func newRedis(u *url.URL) (*Redis, error) {
+	client, err := redis.FromURL(u)
+	if err != nil {
+		return nil, err
+	}
+	r := &Redis{Client: client, Prefix: "autocert:"}
+	if q := u.Query(); q.Has("prefix") {
+		r.Prefix = q.Get("prefix")
+	}
+	return r, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.Do(ctx, "GET", r.Prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (r *Redis) Put(ctx context.Context, key string, data []byte) error {
+	_, err := r.Do(ctx, "SET", r.Prefix+key, string(data))
+	return err
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.Do(ctx, "DEL", r.Prefix+key)
+	return err
+}
+
+// List scans for keys starting with prefix. SCAN may return a key more
+// than once; it's listed once.
+func (r *Redis) List(ctx context.Context, prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(r.Prefix+prefix) + "*"
+	var keys []string
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := r.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		next, _ := items[0].([]byte)
+		batch, _ := items[1].([]any)
+		for _, key := range batch {
+			if key, ok := key.([]byte); ok && !seen[string(key)] {
+				seen[string(key)] = true
+				keys = append(keys, strings.TrimPrefix(string(key), r.Prefix))
+			}
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// globEscaper escapes what MATCH reads as a pattern.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// unlockScript deletes a lock only if it still holds the caller's token,
+// not one taken after it expired.
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+func (r *Redis) TryLock(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	token := newToken()
+	reply, err := r.Do(ctx, "SET", r.Prefix+name, token, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return token, reply == "OK", err
+}
+
+func (r *Redis) Unlock(ctx context.Context, name, token string) error {
+	_, err := r.Do(ctx, "EVAL", unlockScript, "1", r.Prefix+name, token)
+	return err
+}
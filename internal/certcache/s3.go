@@ -0,0 +1,169 @@
+package certcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/sigv4"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// S3 keeps entries as objects in an Amazon S3 bucket, or one of a
+// compatible store such as MinIO's.
+type S3 struct {
+	Bucket      string
+	Prefix      string
+	Region      string
+	Credentials sigv4.Credentials
+	// Endpoint is the store's base URL, addressing buckets by path, or if
+	// empty AWS's, addressing them by host.
+	Endpoint string
+	Client   *http.Client
+
+	now func() time.Time
+}
+
+func newS3(u *url.URL) (*S3, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3: no bucket")
+	}
+	creds, err := sigv4.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("s3: %v", err)
+	}
+	q := u.Query()
+	region := q.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{Bucket: u.Host, Prefix: prefix(u), Region: region, Credentials: creds, Endpoint: q.Get("endpoint")}, nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if e, ok := err.(*statusError); ok && e.status == http.StatusNotFound {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, key, data, nil)
+	return err
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if e, ok := err.(*statusError); ok && e.status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// create writes with If-None-Match, which S3 refuses with 412, or 409 if
+// another write is in progress, when the object exists.
+func (s *S3) create(ctx context.Context, key string, data []byte) (bool, error) {
+	_, err := s.do(ctx, http.MethodPut, key, data, http.Header{"If-None-Match": {"*"}})
+	if e, ok := err.(*statusError); ok && (e.status == http.StatusPreconditionFailed || e.status == http.StatusConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *S3) TryLock(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	return tryObjectLock(ctx, s, name, ttl)
+}
+
+func (s *S3) Unlock(ctx context.Context, name, token string) error {
+	return unlockObject(ctx, s, name, token)
+}
+
+// List pages through ListObjectsV2.
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	bucket := s.base()
+	if s.Endpoint == "" {
+		bucket += "/"
+	}
+	var keys []string
+	q := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + prefix}}
+	for {
+		data, err := s.send(ctx, http.MethodGet, bucket+"?"+q.Encode(), "(list)", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []struct{ Key string }
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("s3: listing: %w", err)
+		}
+		for _, c := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.Prefix))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// base is the bucket's URL, without a trailing slash.
+func (s *S3) base() string {
+	if s.Endpoint != "" {
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	}
+	return "https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com"
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body []byte, header http.Header) ([]byte, error) {
+	// S3 reads a + in a path as a space.
+	object := strings.ReplaceAll(url.PathEscape(s.Prefix+key), "+", "%2B")
+	object = strings.ReplaceAll(object, "%2F", "/")
+	return s.send(ctx, method, s.base()+"/"+object, key, body, header)
+}
+
+func (s *S3) send(ctx context.Context, method, rawURL, key string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	sigv4.Sign(req, body, now(), s.Credentials, s.Region, "s3")
+
+	resp, err := client(s.Client).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &statusError{"s3", method, key, resp.StatusCode}
+	}
+	return data, nil
+}
@@ -0,0 +1,233 @@
+// Package clickstore keeps hourly request counts per host, path and status
+// in daily JSON Lines files, for link-click statistics without a database.
+package clickstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys bounds the distinct host, path and status combinations
+// counted per hour; beyond it, paths are counted as OtherPath.
+const DefaultMaxKeys = 100000
+
+// OtherPath stands in for paths not counted separately.
+const OtherPath = "(other)"
+
+// maxPathLen truncates the paths counted.
+const maxPathLen = 256
+
+// A Key identifies one hourly count.
+type Key struct {
+	Hour   time.Time `json:"hour"`
+	Host   string    `json:"host"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// A Count is the number of requests for a Key.
+type Count struct {
+	Key
+	Count uint64 `json:"count"`
+}
+
+// A Store counts requests in memory and appends the counts to a file per
+// UTC day in Dir on each Flush. Files older than Retention are removed by
+// Prune. It is safe for concurrent use.
+type Store struct {
+	Dir       string
+	Retention time.Duration // zero keeps everything
+	MaxKeys   int           // per hour; zero means DefaultMaxKeys
+
+	flushing sync.Mutex // serializes Flush's writes
+	mu       sync.Mutex
+	pending  map[Key]uint64
+	seen     map[Key]bool // keys counted separately in hours not yet over
+	perHour  map[time.Time]int
+}
+
+// Open returns a Store writing to dir, creating it if needed.
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir, Retention: retention, pending: make(map[Key]uint64), seen: make(map[Key]bool), perHour: make(map[time.Time]int)}, nil
+}
+
+// Add counts one request at t.
+func (s *Store) Add(t time.Time, host, path string, status int) {
+	if len(path) > maxPathLen {
+		path = path[:maxPathLen]
+	}
+	k := Key{Hour: t.UTC().Truncate(time.Hour), Host: host, Path: path, Status: status}
+	maxKeys := s.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.seen[k] {
+		if s.perHour[k.Hour] >= maxKeys {
+			k.Path = OtherPath
+		} else {
+			s.seen[k] = true
+			s.perHour[k.Hour]++
+		}
+	}
+	s.pending[k]++
+}
+
+// Flush appends the counts made since the last Flush to their day's file.
+func (s *Store) Flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[Key]uint64)
+	current := time.Now().UTC().Truncate(time.Hour)
+	for hour := range s.perHour {
+		if hour.Before(current) {
+			delete(s.perHour, hour)
+		}
+	}
+	for k := range s.seen {
+		if k.Hour.Before(current) {
+			delete(s.seen, k)
+		}
+	}
+	s.mu.Unlock()
+
+	byDay := make(map[string][]Count)
+	for k, n := range pending {
+		day := k.Hour.Format(time.DateOnly)
+		byDay[day] = append(byDay[day], Count{k, n})
+	}
+	for day, counts := range byDay {
+		if err := s.appendDay(day, counts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) appendDay(day string, counts []Count) error {
+	f, err := os.OpenFile(s.file(day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, c := range counts {
+		enc.Encode(c)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Store) file(day string) string {
+	return filepath.Join(s.Dir, "clicks-"+day+".jsonl")
+}
+
+// Prune removes the files of days that ended more than Retention before
+// now.
+func (s *Store) Prune(now time.Time) error {
+	if s.Retention <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(s.Dir, "clicks-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	cutoff := now.UTC().Add(-s.Retention)
+	for _, f := range files {
+		day, err := time.Parse(time.DateOnly, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "clicks-"), ".jsonl"))
+		if err == nil && day.Add(24*time.Hour).Before(cutoff) {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query calls fn with the counts for hours from from up to but excluding
+// to, for host if it isn't empty, ordered by hour, host, path and status.
+// Counts not yet flushed are included.
+func (s *Store) Query(from, to time.Time, host string, fn func(Count) error) error {
+	from, to = from.UTC().Truncate(time.Hour), to.UTC()
+	s.flushing.Lock() // so no counts are between pending and the files
+	defer s.flushing.Unlock()
+	s.mu.Lock()
+	pending := make(map[Key]uint64, len(s.pending))
+	for k, n := range s.pending {
+		pending[k] = n
+	}
+	s.mu.Unlock()
+
+	for day := from.Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		counts := make(map[Key]uint64)
+		if err := s.readDay(day.Format(time.DateOnly), counts); err != nil {
+			return err
+		}
+		for k, n := range pending {
+			if k.Hour.Truncate(24 * time.Hour).Equal(day) {
+				counts[k] += n
+			}
+		}
+		var sorted []Count
+		for k, n := range counts {
+			if !k.Hour.Before(from) && k.Hour.Before(to) && (host == "" || k.Host == host) {
+				sorted = append(sorted, Count{k, n})
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			a, b := sorted[i].Key, sorted[j].Key
+			switch {
+			case !a.Hour.Equal(b.Hour):
+				return a.Hour.Before(b.Hour)
+			case a.Host != b.Host:
+				return a.Host < b.Host
+			case a.Path != b.Path:
+				return a.Path < b.Path
+			}
+			return a.Status < b.Status
+		})
+		for _, c := range sorted {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readDay sums the counts in a day's file into counts.
+func (s *Store) readDay(day string, counts map[Key]uint64) error {
+	f, err := os.Open(s.file(day))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var c Count
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return fmt.Errorf("%s:%d: %w", f.Name(), line, err)
+		}
+		counts[c.Key] += c.Count
+	}
+	return scanner.Err()
+}
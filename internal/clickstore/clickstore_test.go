@@ -0,0 +1,113 @@
+package clickstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func query(t *testing.T, s *Store, from, to time.Time, host string) string {
+	t.Helper()
+	var got []string
+	err := s.Query(from, to, host, func(c Count) error {
+		got = append(got, fmt.Sprintf("%s %s%s %d %d", c.Hour.Format("01-02T15"), c.Host, c.Path, c.Status, c.Count))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(got, "\n")
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	s.Add(day.Add(10*time.Hour), "go.example.com", "/docs", 302)
+	s.Add(day.Add(10*time.Hour+time.Minute), "go.example.com", "/docs", 302)
+	s.Add(day.Add(23*time.Hour), "other.example.com", "/", 404)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.Add(day.Add(10*time.Hour+30*time.Minute), "go.example.com", "/docs", 302) // summed with the flushed count
+	s.Add(day.Add(24*time.Hour), "go.example.com", "/", 302)
+
+	want := "03-09T10 go.example.com/docs 302 3\n03-09T23 other.example.com/ 404 1\n03-10T00 go.example.com/ 302 1"
+	if got := query(t, s, day, day.Add(48*time.Hour), ""); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := query(t, s, day, day.Add(48*time.Hour), ""); got != want {
+		t.Errorf("after flush: got\n%s\nwant\n%s", got, want)
+	}
+	if got := query(t, s, day.Add(11*time.Hour), day.Add(48*time.Hour), "go.example.com"); got != "03-10T00 go.example.com/ 302 1" {
+		t.Errorf("filtered: got %q", got)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MaxKeys = 2
+	now := time.Now()
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/d"} {
+		s.Add(now, "go.example.com", path, 302)
+		s.Flush()
+	}
+	want := "go.example.com(other) 302 2\ngo.example.com/a 302 2\ngo.example.com/b 302 1"
+	got := query(t, s, now.Add(-time.Hour), now.Add(time.Hour), "")
+	var trimmed []string
+	for _, line := range strings.Split(got, "\n") {
+		trimmed = append(trimmed, line[strings.Index(line, " ")+1:])
+	}
+	if strings.Join(trimmed, "\n") != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for d := range 5 {
+		s.Add(now.Add(-time.Duration(d)*24*time.Hour), "go.example.com", "/", 302)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	want := "clicks-2026-03-08.jsonl clicks-2026-03-09.jsonl clicks-2026-03-10.jsonl"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "clicks-2026-03-09.jsonl"), []byte("{\"count\":1}\nnot json\n"), 0o644)
+	s, _ := Open(dir, 0)
+	day := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	err := s.Query(day, day.Add(24*time.Hour), "", func(Count) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("want an error naming line 2, got %v", err)
+	}
+}
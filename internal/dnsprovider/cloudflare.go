@@ -0,0 +1,113 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Cloudflare manages records through the Cloudflare API with an API token
+// allowed to edit the zone's DNS.
+type Cloudflare struct {
+	Token string
+	// Endpoint is the API's base URL, https://api.cloudflare.com/client/v4
+	// if empty.
+	Endpoint string
+	Client   *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+// Present creates a TXT record holding value at fqdn.
+func (c *Cloudflare) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := c.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(cloudflareRecord{Type: "TXT", Name: strings.TrimSuffix(fqdn, "."), Content: value, TTL: 120})
+	return c.do(ctx, http.MethodPost, "/zones/"+zone+"/dns_records", body, nil)
+}
+
+// CleanUp deletes the TXT records holding value at fqdn.
+func (c *Cloudflare) CleanUp(ctx context.Context, fqdn, value string) error {
+	zone, err := c.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var records []cloudflareRecord
+	q := url.Values{"type": {"TXT"}, "name": {strings.TrimSuffix(fqdn, ".")}}
+	if err := c.do(ctx, http.MethodGet, "/zones/"+zone+"/dns_records?"+q.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if strings.Trim(r.Content, `"`) != value {
+			continue
+		}
+		if err := c.do(ctx, http.MethodDelete, "/zones/"+zone+"/dns_records/"+url.PathEscape(r.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zone returns the ID of the zone holding fqdn.
+func (c *Cloudflare) zone(ctx context.Context, fqdn string) (string, error) {
+	for _, name := range zoneCandidates(fqdn) {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/zones?"+url.Values{"name": {name}}.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone holds %s", fqdn)
+}
+
+// do calls the API, decoding the result field of its answer into result.
+func (c *Cloudflare) do(ctx context.Context, method, path string, body []byte, result any) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.cloudflare.com/client/v4"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var answer struct {
+		Success bool            `json:"success"`
+		Result  json.RawMessage `json:"result"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := doJSON(c.Client, req, &answer); err != nil {
+		return fmt.Errorf("cloudflare: %w", err)
+	}
+	if !answer.Success {
+		if len(answer.Errors) > 0 {
+			return fmt.Errorf("cloudflare: %s %s: %s", method, path, answer.Errors[0].Message)
+		}
+		return fmt.Errorf("cloudflare: %s %s failed", method, path)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(answer.Result, result)
+}
@@ -0,0 +1,101 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DigitalOcean manages records through the DigitalOcean API with a
+// personal access token allowed to write domains.
+type DigitalOcean struct {
+	Token string
+	// Endpoint is the API's base URL, https://api.digitalocean.com/v2 if
+	// empty.
+	Endpoint string
+	Client   *http.Client
+}
+
+type digitalOceanRecord struct {
+	ID   int    `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// Present creates a TXT record holding value at fqdn.
+func (d *DigitalOcean) Present(ctx context.Context, fqdn, value string) error {
+	domain, err := d.domain(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(fqdn, "."), "."+domain)
+	body, _ := json.Marshal(digitalOceanRecord{Type: "TXT", Name: name, Data: value, TTL: 30})
+	return d.do(ctx, http.MethodPost, "/domains/"+domain+"/records", body, nil)
+}
+
+// CleanUp deletes the TXT records holding value at fqdn.
+func (d *DigitalOcean) CleanUp(ctx context.Context, fqdn, value string) error {
+	domain, err := d.domain(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var answer struct {
+		Records []digitalOceanRecord `json:"domain_records"`
+	}
+	q := url.Values{"type": {"TXT"}, "name": {strings.TrimSuffix(fqdn, ".")}}
+	if err := d.do(ctx, http.MethodGet, "/domains/"+domain+"/records?"+q.Encode(), nil, &answer); err != nil {
+		return err
+	}
+	for _, r := range answer.Records {
+		if r.Data != value {
+			continue
+		}
+		if err := d.do(ctx, http.MethodDelete, "/domains/"+domain+"/records/"+strconv.Itoa(r.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// domain returns the domain, as DigitalOcean calls zones, holding fqdn.
+func (d *DigitalOcean) domain(ctx context.Context, fqdn string) (string, error) {
+	for _, name := range zoneCandidates(fqdn) {
+		err := d.do(ctx, http.MethodGet, "/domains/"+name, nil, nil)
+		var se *statusError
+		if errors.As(err, &se) && se.status == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("digitalocean: no domain holds %s", fqdn)
+}
+
+func (d *DigitalOcean) do(ctx context.Context, method, path string, body []byte, v any) error {
+	endpoint := d.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.digitalocean.com/v2"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := doJSON(d.Client, req, v); err != nil {
+		return fmt.Errorf("digitalocean: %w", err)
+	}
+	return nil
+}
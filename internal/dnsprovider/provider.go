@@ -0,0 +1,114 @@
+// Package dnsprovider publishes the TXT records of ACME DNS-01 challenges
+// through DNS hosting APIs, with minimal built-in clients for Cloudflare,
+// Route 53 and DigitalOcean.
+package dnsprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// A Provider adds and removes TXT records in the zones an account hosts.
+// Present adds value to the TXT records at fqdn, keeping any others there,
+// and CleanUp removes it again. A Provider is safe for concurrent use.
+type Provider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// Names are the providers New accepts.
+var Names = []string{"cloudflare", "route53", "digitalocean"}
+
+// New returns the Provider called name, authenticated with credentials:
+// an API token for Cloudflare and DigitalOcean, and
+// "ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]" for Route 53.
+func New(name, credentials string) (Provider, error) {
+	if credentials == "" {
+		return nil, fmt.Errorf("%s needs credentials", name)
+	}
+	switch name {
+	case "cloudflare":
+		return &Cloudflare{Token: credentials}, nil
+	case "digitalocean":
+		return &DigitalOcean{Token: credentials}, nil
+	case "route53":
+		parts := strings.SplitN(credentials, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("route53 credentials must be ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]")
+		}
+		r := &Route53{AccessKeyID: parts[0], SecretAccessKey: parts[1]}
+		if len(parts) == 3 {
+			r.SessionToken = parts[2]
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown DNS provider %q, want one of %s", name, strings.Join(Names, ", "))
+}
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+func client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return defaultClient
+}
+
+// zoneCandidates returns the names a zone holding fqdn may be at, from
+// fqdn's parent down to its registrable domain.
+func zoneCandidates(fqdn string) []string {
+	fqdn = strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	apex, err := publicsuffix.EffectiveTLDPlusOne(fqdn)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name := fqdn; ; {
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok || len(parent) < len(apex) {
+			break
+		}
+		names = append(names, parent)
+		name = parent
+	}
+	return names
+}
+
+// doJSON sends req and decodes a JSON response into v, if not nil,
+// failing on non-2xx statuses with the start of the body.
+func doJSON(c *http.Client, req *http.Request, v any) error {
+	resp, err := client(c).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &statusError{req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body[:min(len(body), 200)]))}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// A statusError is an API's non-2xx answer.
+type statusError struct {
+	method, path string
+	status       int
+	body         string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.path, e.status, e.body)
+}
@@ -0,0 +1,247 @@
+package dnsprovider
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestZoneCandidates(t *testing.T) {
+	got := zoneCandidates("_acme-challenge.a.example.co.uk.")
+	if want := []string{"a.example.co.uk", "example.co.uk"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if p, err := New("route53", "AKID:secret:token"); err != nil || p.(*Route53).SessionToken != "token" {
+		t.Errorf("route53: %+v, %v", p, err)
+	}
+	for _, c := range []struct{ name, credentials string }{{"route53", "AKID"}, {"cloudflare", ""}, {"bind", "x"}} {
+		if _, err := New(c.name, c.credentials); err == nil {
+			t.Errorf("%s %q: no error", c.name, c.credentials)
+		}
+	}
+}
+
+func TestCloudflare(t *testing.T) {
+	var mu sync.Mutex
+	records := map[string]cloudflareRecord{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var result any = []any{}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") == "example.com" {
+				result = []map[string]string{{"id": "z1"}}
+			}
+		case r.Method == "POST" && r.URL.Path == "/zones/z1/dns_records":
+			var rec cloudflareRecord
+			json.NewDecoder(r.Body).Decode(&rec)
+			rec.ID = "r" + string(rune('0'+len(records)))
+			records[rec.ID] = rec
+			result = rec
+		case r.Method == "GET" && r.URL.Path == "/zones/z1/dns_records":
+			var found []cloudflareRecord
+			for _, rec := range records {
+				if rec.Name == r.URL.Query().Get("name") {
+					found = append(found, rec)
+				}
+			}
+			result = found
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/zones/z1/dns_records/"):
+			delete(records, strings.TrimPrefix(r.URL.Path, "/zones/z1/dns_records/"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "result": result})
+	}))
+	defer srv.Close()
+
+	cf := &Cloudflare{Token: "tok", Endpoint: srv.URL}
+	ctx := context.Background()
+	const fqdn = "_acme-challenge.www.example.com."
+	for _, v := range []string{"one", "two"} {
+		if err := cf.Present(ctx, fqdn, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cf.CleanUp(ctx, fqdn, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records["r1"].Content != "two" || records["r1"].Name != "_acme-challenge.www.example.com" {
+		t.Errorf("records = %+v", records)
+	}
+	if err := (&Cloudflare{Token: "bad", Endpoint: srv.URL}).Present(ctx, fqdn, "x"); err == nil {
+		t.Error("no error with a bad token")
+	}
+}
+
+func TestDigitalOcean(t *testing.T) {
+	var mu sync.Mutex
+	var records []digitalOceanRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/domains/example.com":
+			json.NewEncoder(w).Encode(map[string]any{"domain": map[string]string{"name": "example.com"}})
+		case r.Method == "POST" && r.URL.Path == "/domains/example.com/records":
+			var rec digitalOceanRecord
+			json.NewDecoder(r.Body).Decode(&rec)
+			rec.ID = len(records) + 1
+			records = append(records, rec)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"domain_record": rec})
+		case r.Method == "GET" && r.URL.Path == "/domains/example.com/records":
+			json.NewEncoder(w).Encode(map[string]any{"domain_records": records})
+		case r.Method == "DELETE" && r.URL.Path == "/domains/example.com/records/1":
+			records = records[1:]
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	do := &DigitalOcean{Token: "tok", Endpoint: srv.URL}
+	ctx := context.Background()
+	if err := do.Present(ctx, "_acme-challenge.www.example.com", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "_acme-challenge.www" || records[0].Data != "v" {
+		t.Fatalf("records = %+v", records)
+	}
+	if err := do.CleanUp(ctx, "_acme-challenge.www.example.com", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("records = %+v", records)
+	}
+}
+
+func TestRoute53(t *testing.T) {
+	var mu sync.Mutex
+	// The record sets the fake API holds, by name, as the ResourceRecord
+	// values it returns, with a TXT record someone else put there.
+	sets := map[string][]string{"_acme-challenge.example.com.": {`"v=other"`}}
+	ttls := map[string]int{"_acme-challenge.example.com.": 300}
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/hostedzonesbyname":
+			io.WriteString(w, `<ListHostedZonesByNameResponse><HostedZones><HostedZone><Id>/hostedzone/Z1</Id><Name>`+r.URL.Query().Get("dnsname")+`.</Name></HostedZone></HostedZones></ListHostedZonesByNameResponse>`)
+		case r.URL.Path == "/hostedzone/Z1/rrset" && r.Method == http.MethodGet:
+			name := r.URL.Query().Get("name")
+			values, ok := sets[name]
+			if !ok {
+				// The listing goes on to the next set.
+				io.WriteString(w, `<ListResourceRecordSetsResponse><ResourceRecordSets><ResourceRecordSet><Name>www.example.com.</Name><Type>TXT</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>"unrelated"</Value></ResourceRecord></ResourceRecords></ResourceRecordSet></ResourceRecordSets></ListResourceRecordSetsResponse>`)
+				return
+			}
+			io.WriteString(w, `<ListResourceRecordSetsResponse><ResourceRecordSets><ResourceRecordSet><Name>`+name+`</Name><Type>TXT</Type><TTL>`+strconv.Itoa(ttls[name])+`</TTL><ResourceRecords>`)
+			for _, v := range values {
+				io.WriteString(w, `<ResourceRecord><Value>`+v+`</Value></ResourceRecord>`)
+			}
+			io.WriteString(w, `</ResourceRecords></ResourceRecordSet></ResourceRecordSets></ListResourceRecordSetsResponse>`)
+		case r.URL.Path == "/hostedzone/Z1/rrset":
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			var c struct {
+				Action string   `xml:"ChangeBatch>Changes>Change>Action"`
+				Name   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Name"`
+				TTL    int      `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>TTL"`
+				Values []string `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+			}
+			if err := xml.Unmarshal(body, &c); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if c.Action == "DELETE" {
+				delete(sets, c.Name)
+			} else {
+				sets[c.Name], ttls[c.Name] = c.Values, c.TTL
+			}
+			io.WriteString(w, `<ChangeResourceRecordSetsResponse/>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r53 := &Route53{AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: srv.URL}
+	ctx := context.Background()
+	const fqdn, fresh = "_acme-challenge.example.com", "_acme-challenge.new.example.com"
+	for _, step := range []func() error{
+		func() error { return r53.Present(ctx, fqdn, "apex") },
+		func() error { return r53.Present(ctx, fqdn, "wildcard") },
+		func() error { return r53.CleanUp(ctx, fqdn, "apex") },
+		func() error { return r53.CleanUp(ctx, fqdn, "wildcard") },
+		func() error { return r53.Present(ctx, fresh, `say "hi"`) },
+		func() error { return r53.CleanUp(ctx, fresh, `say "hi"`) },
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	change := func(action, name string, ttl int, values ...string) string {
+		s := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+			`<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/"><ChangeBatch><Changes><Change>` +
+			`<Action>` + action + `</Action><ResourceRecordSet><Name>` + name + `</Name><Type>TXT</Type><TTL>` + strconv.Itoa(ttl) + `</TTL><ResourceRecords>`
+		for _, v := range values {
+			s += `<ResourceRecord><Value>` + v + `</Value></ResourceRecord>`
+		}
+		return s + `</ResourceRecords></ResourceRecordSet></Change></Changes></ChangeBatch></ChangeResourceRecordSetsRequest>`
+	}
+	want := []string{
+		change("UPSERT", "_acme-challenge.example.com.", 300, "&#34;v=other&#34;", "&#34;apex&#34;"),
+		change("UPSERT", "_acme-challenge.example.com.", 300, "&#34;v=other&#34;", "&#34;apex&#34;", "&#34;wildcard&#34;"),
+		change("UPSERT", "_acme-challenge.example.com.", 300, "&#34;v=other&#34;", "&#34;wildcard&#34;"),
+		change("UPSERT", "_acme-challenge.example.com.", 300, "&#34;v=other&#34;"),
+		change("UPSERT", "_acme-challenge.new.example.com.", 60, `&#34;say \&#34;hi\&#34;&#34;`),
+		change("DELETE", "_acme-challenge.new.example.com.", 60, `&#34;say \&#34;hi\&#34;&#34;`),
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("got %d changes: %q", len(bodies), bodies)
+	}
+	for i := range want {
+		if bodies[i] != want[i] {
+			t.Errorf("change %d:\n got %s\nwant %s", i, bodies[i], want[i])
+		}
+	}
+}
+
+func TestQuoteTXT(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	if got, want := quoteTXT(long), `"`+long[:255]+`" "`+long[255:]+`"`; got != want {
+		t.Errorf("quoteTXT of 300 bytes = %q", got)
+	}
+	for _, v := range []string{"", "plain", `a "quoted" \ value`, long} {
+		if got := unquoteTXT(quoteTXT(v)); got != v {
+			t.Errorf("unquoteTXT(quoteTXT(%q)) = %q", v, got)
+		}
+	}
+	if got := unquoteTXT(`"a\142c"`); got != "abc" {
+		t.Errorf("octal escape: got %q", got)
+	}
+}
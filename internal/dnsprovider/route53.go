@@ -0,0 +1,260 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/sigv4"
+)
+
+// Route53 manages records through the Amazon Route 53 API, signing
+// requests with an IAM access key allowed to list hosted zones and list
+// and change their record sets.
+type Route53 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint is the API's base URL,
+	// https://route53.amazonaws.com/2013-04-01 if empty.
+	Endpoint string
+	Client   *http.Client
+
+	// Route 53 replaces a name's TXT records as a set, so each change
+	// reads the set and writes it back with one value added or removed;
+	// mu keeps this process's changes from racing each other.
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// Present adds value to the TXT record set at fqdn, keeping the values
+// already there.
+func (r *Route53) Present(ctx context.Context, fqdn, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	zone, err := r.zone(ctx, name)
+	if err != nil {
+		return err
+	}
+	set, err := r.recordSet(ctx, zone, name)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(set.values(), value) {
+		return nil
+	}
+	if set.TTL == 0 {
+		set.TTL = 60
+	}
+	set.Records = append(set.Records, route53Record{quoteTXT(value)})
+	return r.change(ctx, zone, "UPSERT", set)
+}
+
+// CleanUp removes value from the TXT record set at fqdn, deleting the set
+// once it's empty.
+func (r *Route53) CleanUp(ctx context.Context, fqdn, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := strings.TrimSuffix(strings.ToLower(fqdn), ".")
+	zone, err := r.zone(ctx, name)
+	if err != nil {
+		return err
+	}
+	set, err := r.recordSet(ctx, zone, name)
+	if err != nil || len(set.Records) == 0 {
+		return err
+	}
+	rest := *set
+	rest.Records = slices.DeleteFunc(slices.Clone(set.Records), func(rr route53Record) bool { return unquoteTXT(rr.Value) == value })
+	switch {
+	case len(rest.Records) == len(set.Records):
+		return nil
+	case len(rest.Records) == 0:
+		// A DELETE must give the set exactly as it stands.
+		return r.change(ctx, zone, "DELETE", set)
+	}
+	return r.change(ctx, zone, "UPSERT", &rest)
+}
+
+// A route53RecordSet is a TXT record set as the API reads and writes it:
+// one ResourceRecord per value, each a quoted character-string list.
+type route53RecordSet struct {
+	Name    string          `xml:"Name"`
+	Type    string          `xml:"Type"`
+	TTL     int             `xml:"TTL"`
+	Records []route53Record `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+// values returns the set's values, unquoted.
+func (s *route53RecordSet) values() []string {
+	var values []string
+	for _, rr := range s.Records {
+		values = append(values, unquoteTXT(rr.Value))
+	}
+	return values
+}
+
+type route53Change struct {
+	XMLName xml.Name         `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Action  string           `xml:"ChangeBatch>Changes>Change>Action"`
+	Set     route53RecordSet `xml:"ChangeBatch>Changes>Change>ResourceRecordSet"`
+}
+
+// recordSet returns the TXT record set at name in zone, empty but for its
+// name and type if there is none.
+func (r *Route53) recordSet(ctx context.Context, zone, name string) (*route53RecordSet, error) {
+	var answer struct {
+		Sets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	q := url.Values{"name": {name + "."}, "type": {"TXT"}, "maxitems": {"1"}}
+	if err := r.do(ctx, http.MethodGet, "/hostedzone/"+zone+"/rrset", q, nil, &answer); err != nil {
+		return nil, err
+	}
+	// The listing starts at name, but goes on to the next set if there's
+	// none there.
+	for _, set := range answer.Sets {
+		if strings.EqualFold(strings.TrimSuffix(set.Name, "."), name) && set.Type == "TXT" {
+			return &set, nil
+		}
+	}
+	return &route53RecordSet{Name: name + ".", Type: "TXT"}, nil
+}
+
+// change applies action to set in zone.
+func (r *Route53) change(ctx context.Context, zone, action string, set *route53RecordSet) error {
+	body, err := xml.Marshal(route53Change{Action: action, Set: *set})
+	if err != nil {
+		return err
+	}
+	return r.do(ctx, http.MethodPost, "/hostedzone/"+zone+"/rrset", nil, append([]byte(xml.Header), body...), nil)
+}
+
+// quoteTXT returns value as a TXT record's value in the API: quoted
+// strings of at most 255 bytes each, with quotes and backslashes escaped.
+func quoteTXT(value string) string {
+	var b strings.Builder
+	for {
+		chunk := value[:min(len(value), 255)]
+		value = value[len(chunk):]
+		b.WriteByte('"')
+		for i := 0; i < len(chunk); i++ {
+			if c := chunk[i]; c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(chunk[i])
+		}
+		b.WriteByte('"')
+		if value == "" {
+			return b.String()
+		}
+		b.WriteByte(' ')
+	}
+}
+
+// unquoteTXT reverses quoteTXT, joining the strings of a TXT value and
+// undoing \" and \\ and the \ddd octal escapes Route 53 writes.
+func unquoteTXT(value string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted:
+			// Spaces between strings.
+		case c == '\\' && i+3 < len(value) && isOctal(value[i+1]) && isOctal(value[i+2]) && isOctal(value[i+3]):
+			b.WriteByte((value[i+1]-'0')<<6 | (value[i+2]-'0')<<3 | (value[i+3] - '0'))
+			i += 3
+		case c == '\\' && i+1 < len(value):
+			i++
+			b.WriteByte(value[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool { return '0' <= c && c <= '7' }
+
+// zone returns the ID of the public hosted zone holding fqdn.
+func (r *Route53) zone(ctx context.Context, fqdn string) (string, error) {
+	for _, name := range zoneCandidates(fqdn) {
+		var answer struct {
+			Zones []struct {
+				ID      string `xml:"Id"`
+				Name    string `xml:"Name"`
+				Private bool   `xml:"Config>PrivateZone"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		q := url.Values{"dnsname": {name}, "maxitems": {"1"}}
+		if err := r.do(ctx, http.MethodGet, "/hostedzonesbyname", q, nil, &answer); err != nil {
+			return "", err
+		}
+		for _, z := range answer.Zones {
+			if strings.EqualFold(strings.TrimSuffix(z.Name, "."), name) && !z.Private {
+				return strings.TrimPrefix(z.ID, "/hostedzone/"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("route53: no hosted zone holds %s", fqdn)
+}
+
+func (r *Route53) do(ctx context.Context, method, path string, query url.Values, body []byte, v any) error {
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "https://route53.amazonaws.com/2013-04-01"
+	}
+	u := endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	// Route 53 is signed for us-east-1 wherever it's called from.
+	sigv4.Sign(req, body, now(), sigv4.Credentials{AccessKeyID: r.AccessKeyID, SecretAccessKey: r.SecretAccessKey, SessionToken: r.SessionToken}, "us-east-1", "route53")
+
+	resp, err := client(r.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("route53: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("route53: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `xml:"Error>Message"`
+		}
+		xml.Unmarshal(data, &e)
+		return fmt.Errorf("route53: %w", &statusError{method, path, resp.StatusCode, e.Message})
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
@@ -0,0 +1,261 @@
+// Package grpc serves and calls unary gRPC methods over HTTP/2, with
+// messages encoded by hand using the protobuf wire format. It implements
+// just enough of the protocol for a small control API: no streaming, no
+// compression and no reflection.
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxMessageSize is the largest message a Server accepts, gRPC's usual
+// default.
+const MaxMessageSize = 4 << 20
+
+// A Code is a gRPC status code.
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	Unauthenticated    Code = 16
+)
+
+// An Error is a call ending with a status other than OK.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+// Errorf returns an *Error with code and the formatted message.
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// A Handler answers a call with the encoded request message, returning
+// the encoded response, or an error. Errors other than an *Error end the
+// call with Unknown.
+type Handler func(ctx context.Context, req []byte) ([]byte, error)
+
+// Server is an http.Handler dispatching unary calls to their Handlers by
+// method path, such as "/package.Service/Method". Calls to methods without
+// one end with Unimplemented.
+type Server struct {
+	methods map[string]Handler
+}
+
+func NewServer() *Server {
+	return &Server{methods: make(map[string]Handler)}
+}
+
+// Handle registers h for calls to method.
+func (s *Server) Handle(method string, h Handler) {
+	s.methods[method] = h
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "gRPC calls are POSTs", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && ct != "application/grpc+proto" {
+		http.Error(w, "Content-Type must be application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	ctx := r.Context()
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, err := parseTimeout(v)
+		if err != nil {
+			WriteError(w, Errorf(InvalidArgument, "grpc-timeout: %v", err))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	h := s.methods[r.URL.Path]
+	if h == nil {
+		WriteError(w, Errorf(Unimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+	req, err := readMessage(r.Body)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	resp, err := h(ctx, req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = Errorf(DeadlineExceeded, "%v", err)
+		}
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Write(frame(resp))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// WriteError ends a call with err's status, in headers alone, as gRPC's
+// trailers-only responses are, for handlers in front of a Server. Errors
+// other than an *Error end it with Unknown.
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: Unknown, Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(e.Code)))
+	if e.Message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(e.Message))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// frame prefixes msg with the uncompressed flag and its length.
+func frame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// readMessage reads the one message of a unary call or its response.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, Errorf(Internal, "reading message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed messages aren't supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > MaxMessageSize {
+		return nil, Errorf(ResourceExhausted, "message of %d bytes is over %d", size, MaxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, Errorf(Internal, "reading message: %v", err)
+	}
+	return msg, nil
+}
+
+// parseTimeout parses a grpc-timeout header: at most 8 digits and a unit.
+func parseTimeout(v string) (time.Duration, error) {
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if !ok || err != nil || len(v) > 9 {
+		return 0, fmt.Errorf("bad timeout %q", v)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeMessage percent-encodes a grpc-message status message.
+func encodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Invoke calls method at target, a URL such as https://host:port, over
+// client's transport, which must speak HTTP/2, and returns the encoded
+// response. A call ending with a status other than OK returns an *Error.
+func Invoke(ctx context.Context, client *http.Client, target, method string, req []byte) ([]byte, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(target, "/")+method, bytes.NewReader(frame(req)))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		r.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("grpc %s: %s", method, resp.Status)
+	}
+	if status := resp.Header.Get("Grpc-Status"); status != "" {
+		// A trailers-only response
+		return nil, statusError(status, resp.Header.Get("Grpc-Message"))
+	}
+	msg, err := readMessage(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	if err := statusError(resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// statusError returns the *Error a status and message say the call ended
+// with, or nil for OK.
+func statusError(status, message string) error {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return Errorf(Internal, "call ended without a status")
+	}
+	if code == int(OK) {
+		return nil
+	}
+	if decoded, err := decodeMessage(message); err == nil {
+		message = decoded
+	}
+	return &Error{Code: Code(code), Message: message}
+}
+
+func decodeMessage(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", errors.New("truncated escape")
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWire(t *testing.T) {
+	since := time.Date(2026, 10, 14, 9, 30, 0, 500, time.UTC)
+	var inner []byte
+	inner = AppendString(inner, 1, "go.example.com")
+	var msg []byte
+	msg = AppendVarint(msg, 1, 300)
+	msg = AppendVarint(msg, 2, 0)
+	msg = AppendBool(msg, 3, true)
+	msg = AppendString(msg, 4, "")
+	msg = AppendMessage(msg, 5, inner)
+	msg = AppendMessage(msg, 5, nil)
+	msg = AppendTime(msg, 6, since)
+	msg = AppendDuration(msg, 7, -90*time.Second)
+
+	fields, err := Decode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nums []int
+	for _, f := range fields {
+		nums = append(nums, f.Num)
+	}
+	if len(fields) != 6 {
+		t.Fatalf("decoded fields %v", nums)
+	}
+	if fields[0].Varint != 300 || fields[1].Varint != 1 {
+		t.Errorf("varints: %d, %d", fields[0].Varint, fields[1].Varint)
+	}
+	if got, _ := Decode(fields[2].Bytes); len(got) != 1 || got[0].String() != "go.example.com" {
+		t.Errorf("embedded message: %v", got)
+	}
+	if len(fields[3].Bytes) != 0 || fields[3].Num != 5 {
+		t.Errorf("empty message: %+v", fields[3])
+	}
+	if got, err := DecodeTime(fields[4].Bytes); err != nil || !got.Equal(since) {
+		t.Errorf("time: %v, %v", got, err)
+	}
+	if got, err := DecodeDuration(fields[5].Bytes); err != nil || got != -90*time.Second {
+		t.Errorf("duration: %v, %v", got, err)
+	}
+
+	for _, bad := range [][]byte{{0x0a, 5, 'a'}, {0x08}, {0x0b}, {0x00, 1}} {
+		if _, err := Decode(bad); err == nil {
+			t.Errorf("Decode(%x): no error", bad)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	s.Handle("/test.Echo/Echo", func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	s.Handle("/test.Echo/Fail", func(ctx context.Context, req []byte) ([]byte, error) {
+		return nil, Errorf(NotFound, "no such host: ünïcode 100%%\n")
+	})
+	s.Handle("/test.Echo/Deadline", func(ctx context.Context, req []byte) ([]byte, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("no deadline")
+		}
+		return nil, nil
+	})
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	msg := AppendString(nil, 1, "hello")
+	if got, err := Invoke(ctx, client, srv.URL, "/test.Echo/Echo", msg); err != nil || string(got) != string(msg) {
+		t.Errorf("Echo: %q, %v", got, err)
+	}
+	if got, err := Invoke(ctx, client, srv.URL, "/test.Echo/Echo", nil); err != nil || len(got) != 0 {
+		t.Errorf("Echo of an empty message: %q, %v", got, err)
+	}
+
+	var e *Error
+	_, err := Invoke(ctx, client, srv.URL, "/test.Echo/Fail", nil)
+	if !errors.As(err, &e) || e.Code != NotFound || e.Message != "no such host: ünïcode 100%\n" {
+		t.Errorf("Fail: %v", err)
+	}
+	_, err = Invoke(ctx, client, srv.URL, "/test.Echo/Missing", nil)
+	if !errors.As(err, &e) || e.Code != Unimplemented {
+		t.Errorf("Missing: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if _, err := Invoke(ctx, client, srv.URL, "/test.Echo/Deadline", nil); err != nil {
+		t.Errorf("Deadline: %v", err)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for v, want := range map[string]time.Duration{"1S": time.Second, "250m": 250 * time.Millisecond, "2H": 2 * time.Hour, "99999999n": 99999999} {
+		if got, err := parseTimeout(v); err != nil || got != want {
+			t.Errorf("parseTimeout(%q) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []string{"S", "10", "10x", "123456789S", "-1S"} {
+		if _, err := parseTimeout(v); err == nil {
+			t.Errorf("parseTimeout(%q): no error", v)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// A Field is one field of an encoded protobuf message: a varint (integers,
+// bools and enums) or the bytes of a string, bytes or embedded message.
+// Fixed-width fields keep their little-endian bytes.
+type Field struct {
+	Num    int
+	Varint uint64
+	Bytes  []byte
+}
+
+// String returns the field as a string.
+func (f Field) String() string { return string(f.Bytes) }
+
+// Decode splits msg into its fields, in the order they appear. Repeated
+// fields appear once for each value; packed ones aren't unpacked.
+func Decode(msg []byte) ([]Field, error) {
+	var fields []Field
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("protobuf: bad field tag")
+		}
+		msg = msg[n:]
+		f := Field{Num: int(tag >> 3)}
+		if f.Num == 0 {
+			return nil, errors.New("protobuf: field number 0")
+		}
+		switch tag & 7 {
+		case wireVarint:
+			if f.Varint, n = binary.Uvarint(msg); n <= 0 {
+				return nil, fmt.Errorf("protobuf: field %d: bad varint", f.Num)
+			}
+		case wireBytes:
+			size, m := binary.Uvarint(msg)
+			if m <= 0 || size > uint64(len(msg)-m) {
+				return nil, fmt.Errorf("protobuf: field %d: bad length", f.Num)
+			}
+			f.Bytes, n = msg[m:m+int(size)], m+int(size)
+		case wireFixed64, wireFixed32:
+			n = 8
+			if tag&7 == wireFixed32 {
+				n = 4
+			}
+			if len(msg) < n {
+				return nil, fmt.Errorf("protobuf: field %d: truncated", f.Num)
+			}
+			f.Bytes = msg[:n]
+		default:
+			return nil, fmt.Errorf("protobuf: field %d: unsupported wire type %d", f.Num, tag&7)
+		}
+		msg = msg[n:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func appendTag(b []byte, num, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+// AppendVarint appends an integer field, unless v is 0, proto3's default.
+// Negative int32 and int64 values are appended as uint64(v).
+func AppendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+// AppendBool appends a bool field, unless v is false.
+func AppendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return AppendVarint(b, num, 1)
+}
+
+// AppendString appends a string field, unless s is "".
+func AppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendTag(b, num, wireBytes), uint64(len(s)))
+	return append(b, s...)
+}
+
+// AppendMessage appends an embedded message field, or an element of a
+// repeated one. It's appended even if msg is empty, so it's present.
+func AppendMessage(b []byte, num int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, num, wireBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// AppendTime appends t as a google.protobuf.Timestamp field, unless it's
+// the zero time.
+func AppendTime(b []byte, num int, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var msg []byte
+	msg = AppendVarint(msg, 1, uint64(t.Unix()))
+	msg = AppendVarint(msg, 2, uint64(t.Nanosecond()))
+	return AppendMessage(b, num, msg)
+}
+
+// AppendDuration appends d as a google.protobuf.Duration field. It's
+// appended even if d is 0, so it's present.
+func AppendDuration(b []byte, num int, d time.Duration) []byte {
+	var msg []byte
+	msg = AppendVarint(msg, 1, uint64(int64(d/time.Second)))
+	msg = AppendVarint(msg, 2, uint64(int64(d%time.Second)))
+	return AppendMessage(b, num, msg)
+}
+
+// DecodeTime decodes a google.protobuf.Timestamp.
+func DecodeTime(msg []byte) (time.Time, error) {
+	seconds, nanos, err := decodeSecondsNanos(msg)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, nanos), nil
+}
+
+// DecodeDuration decodes a google.protobuf.Duration.
+func DecodeDuration(msg []byte) (time.Duration, error) {
+	seconds, nanos, err := decodeSecondsNanos(msg)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds)*time.Second + time.Duration(nanos), nil
+}
+
+func decodeSecondsNanos(msg []byte) (seconds, nanos int64, err error) {
+	fields, err := Decode(msg)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range fields {
+		switch f.Num {
+		case 1:
+			seconds = int64(f.Varint)
+		case 2:
+			nanos = int64(int32(f.Varint))
+		}
+	}
+	return seconds, nanos, nil
+}
@@ -0,0 +1,128 @@
+// Package logfile provides a log file that rotates itself by size and age,
+// for operators without a log-collecting supervisor.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A Writer appends to a file, renaming it aside with a timestamp suffix
+// (access.log.20060102T150405.000) and starting a new one once it reaches
+// MaxSize bytes or has been open for MaxAge. It is safe for concurrent use.
+type Writer struct {
+	Path string
+
+	// MaxSize is the size that triggers rotation. Zero disables it.
+	MaxSize int64
+	// MaxAge is how long a file is written before rotation. Zero disables
+	// it.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept. Zero keeps all.
+	MaxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+// Open returns a Writer appending to path.
+func Open(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*Writer, error) {
+	w := &Writer{Path: path, MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// MaxSize or it has reached MaxAge.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if (w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize) ||
+		(w.MaxAge > 0 && w.clock().Sub(w.opened) >= w.MaxAge) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size, w.opened = f, info.Size(), w.clock()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+	backup := fmt.Sprintf("%s.%s", w.Path, w.clock().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(w.Path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// prune removes the oldest backups beyond MaxBackups. Their timestamp
+// suffixes sort in the order they were written.
+func (w *Writer) prune() {
+	if w.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(w.Path + ".*")
+	if err != nil || len(backups) <= w.MaxBackups {
+		return
+	}
+	slices.Sort(backups)
+	for _, old := range backups[:len(backups)-w.MaxBackups] {
+		os.Remove(old)
+	}
+}
+
+func (w *Writer) clock() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
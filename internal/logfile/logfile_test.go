@@ -0,0 +1,66 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	w, err := Open(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := time.Unix(1000, 0)
+	w.now = func() time.Time { return now }
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("want 2 backups kept, got %v", backups)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "gggg\n" {
+		t.Errorf("current file: got %q", current)
+	}
+	newest, _ := os.ReadFile(backups[1])
+	if string(newest) != "eeee\nffff\n" {
+		t.Errorf("newest backup: got %q", newest)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	os.WriteFile(path, []byte("old\n"), 0o644)
+	w, err := Open(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	w.Write([]byte("one\n"))
+	now = now.Add(time.Hour)
+	w.Write([]byte("two\n"))
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("want 1 backup, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "old\none\n" {
+		t.Errorf("backup: got %q", data)
+	}
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "two") {
+		t.Errorf("current file: got %q", data)
+	}
+}
@@ -0,0 +1,327 @@
+// Package metrics is a small metrics registry with Prometheus text
+// exposition, so the server can publish counters without pulling in the
+// full Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the type of a metric family.
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// A Sample is one labelled value of a family. For histograms, Buckets holds
+// cumulative counts for each of the family's bucket bounds, Value the sum of
+// observations and Count their number.
+type Sample struct {
+	Labels  []string
+	Value   float64
+	Count   uint64
+	Buckets []uint64
+}
+
+// A Family is a snapshot of a metric and all of its samples.
+type Family struct {
+	Name       string
+	Help       string
+	Kind       Kind
+	LabelNames []string
+	Bounds     []float64 // histogram bucket upper bounds
+	Samples    []Sample
+}
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []collector
+	names    map[string]bool
+	observe  atomic.Pointer[ObserveFunc]
+}
+
+// An ObserveFunc is told of each histogram observation, for exporters that
+// forward individual timings rather than buckets.
+type ObserveFunc func(name string, labelNames, labelValues []string, value float64)
+
+// OnObserve makes fn see every histogram observation from now on.
+func (r *Registry) OnObserve(fn ObserveFunc) {
+	r.observe.Store(&fn)
+}
+
+type collector interface {
+	collect() Family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, c)
+}
+
+// Snapshot returns the current value of every family.
+func (r *Registry) Snapshot() []Family {
+	r.mu.Lock()
+	families := append([]collector(nil), r.families...)
+	r.mu.Unlock()
+	snapshot := make([]Family, 0, len(families))
+	for _, c := range families {
+		snapshot = append(snapshot, c.collect())
+	}
+	return snapshot
+}
+
+// Counter registers a monotonically increasing counter.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, KindCounter, labels)}
+	r.register(name, c)
+	return c
+}
+
+// Gauge registers a value that can go up and down.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, KindGauge, labels)}
+	r.register(name, g)
+	return g
+}
+
+// Histogram registers a histogram with the given bucket upper bounds.
+func (r *Registry) Histogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: newVec(name, help, KindHistogram, labels), bounds: bounds, registry: r}
+	r.register(name, h)
+	return h
+}
+
+// CounterFunc registers a counter whose samples are computed by fn at
+// collection time, for values already tracked elsewhere.
+func (r *Registry) CounterFunc(name, help string, labels []string, fn func() []Sample) {
+	r.register(name, funcCollector{Family{Name: name, Help: help, Kind: KindCounter, LabelNames: labels}, fn})
+}
+
+// GaugeFunc is like CounterFunc for gauges.
+func (r *Registry) GaugeFunc(name, help string, labels []string, fn func() []Sample) {
+	r.register(name, funcCollector{Family{Name: name, Help: help, Kind: KindGauge, LabelNames: labels}, fn})
+}
+
+type funcCollector struct {
+	family Family
+	fn     func() []Sample
+}
+
+func (f funcCollector) collect() Family {
+	family := f.family
+	family.Samples = f.fn()
+	return family
+}
+
+type vec struct {
+	name, help string
+	kind       Kind
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels  []string
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+func newVec(name, help string, kind Kind, labels []string) vec {
+	return vec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series for labelValues. v.mu must be held.
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) snapshot() Family {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	family := Family{Name: v.name, Help: v.help, Kind: v.kind, LabelNames: v.labels}
+	for _, s := range v.series {
+		family.Samples = append(family.Samples, Sample{
+			Labels:  s.labels,
+			Value:   s.value,
+			Count:   s.count,
+			Buckets: append([]uint64(nil), s.buckets...),
+		})
+	}
+	sort.Slice(family.Samples, func(i, j int) bool {
+		return strings.Join(family.Samples[i].Labels, "\xff") < strings.Join(family.Samples[j].Labels, "\xff")
+	})
+	return family
+}
+
+// Counter is a family of counters partitioned by label values.
+type Counter struct{ vec vec }
+
+// Inc adds one to the counter for labelValues.
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds delta, which must not be negative, to the counter for labelValues.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.vec.mu.Lock()
+	defer c.vec.mu.Unlock()
+	c.vec.get(labelValues).value += delta
+}
+
+func (c *Counter) collect() Family { return c.vec.snapshot() }
+
+// Gauge is a family of gauges partitioned by label values.
+type Gauge struct{ vec vec }
+
+// Set sets the gauge for labelValues.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.vec.mu.Lock()
+	defer g.vec.mu.Unlock()
+	g.vec.get(labelValues).value = value
+}
+
+// Add adds delta to the gauge for labelValues.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.vec.mu.Lock()
+	defer g.vec.mu.Unlock()
+	g.vec.get(labelValues).value += delta
+}
+
+func (g *Gauge) collect() Family { return g.vec.snapshot() }
+
+// Histogram is a family of histograms partitioned by label values.
+type Histogram struct {
+	vec      vec
+	bounds   []float64
+	registry *Registry
+}
+
+// Observe records value in the histogram for labelValues.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if fn := h.registry.observe.Load(); fn != nil {
+		(*fn)(h.vec.name, h.vec.labels, labelValues, value)
+	}
+	h.vec.mu.Lock()
+	defer h.vec.mu.Unlock()
+	s := h.vec.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (h *Histogram) collect() Family {
+	family := h.vec.snapshot()
+	family.Bounds = h.bounds
+	return family
+}
+
+// DefaultLatencyBounds are histogram buckets, in seconds, suited to DNS
+// lookups and request handling.
+var DefaultLatencyBounds = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// WritePrometheus writes every family in the Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+	for _, family := range r.Snapshot() {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.Name, family.Help, family.Name, family.Kind)
+		for _, s := range family.Samples {
+			if family.Kind != KindHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", family.Name, labelString(family.LabelNames, s.Labels, "", ""), formatValue(s.Value))
+				continue
+			}
+			for i, bound := range family.Bounds {
+				var n uint64
+				if i < len(s.Buckets) {
+					n = s.Buckets[i]
+				}
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", family.Name, labelString(family.LabelNames, s.Labels, "le", formatValue(bound)), n)
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", family.Name, labelString(family.LabelNames, s.Labels, "le", "+Inf"), s.Count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", family.Name, labelString(family.LabelNames, s.Labels, "", ""), formatValue(s.Value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", family.Name, labelString(family.LabelNames, s.Labels, "", ""), s.Count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler returns an http.Handler serving the registry for Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, labelEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
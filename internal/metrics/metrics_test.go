@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "status")
+	inflight := r.Gauge("inflight", "Requests in flight.")
+	latency := r.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1})
+	r.GaugeFunc("cache_entries", "Cached hosts.", nil, func() []Sample {
+		return []Sample{{Value: 3}}
+	})
+
+	requests.Inc("302")
+	requests.Add(2, "301")
+	inflight.Set(4)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+
+	var b strings.Builder
+	if err := r.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{status="301"} 2
+requests_total{status="302"} 1
+# HELP inflight Requests in flight.
+# TYPE inflight gauge
+inflight 4
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 0.55
+latency_seconds_count 2
+# HELP cache_entries Cached hosts.
+# TYPE cache_entries gauge
+cache_entries 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("hits_total", "Hits.", "host").Inc(`a"b\c`)
+
+	var b strings.Builder
+	r.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `hits_total{host="a\"b\\c"} 1`) {
+		t.Errorf("label not escaped:\n%s", b.String())
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("dup_total", "Dup.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	r.Counter("dup_total", "Dup.")
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacket keeps statsd datagrams within a typical path MTU.
+const maxPacket = 1432
+
+// Statsd pushes a Registry to a statsd or DogStatsD agent over UDP:
+// counters as their increase since the last push, gauges as their value,
+// and each histogram observation as it happens, as a timing in milliseconds
+// for histograms named _seconds. DogStatsD gets labels as tags; plain
+// statsd gets their values appended to the metric name.
+type Statsd struct {
+	registry *Registry
+	prefix   string
+	tags     bool
+	conn     net.Conn
+
+	mu   sync.Mutex
+	buf  []byte
+	last map[string]float64
+}
+
+// NewStatsd returns a Statsd sending r's metrics, with names prefixed by
+// prefix, to addr. Call Run to push counters and gauges.
+func NewStatsd(r *Registry, addr, prefix string, dogstatsd bool) (*Statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Statsd{registry: r, prefix: prefix, tags: dogstatsd, conn: conn, last: make(map[string]float64)}
+	r.OnObserve(s.observe)
+	return s, nil
+}
+
+// Run pushes every interval until ctx is done.
+func (s *Statsd) Run(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.Push()
+		case <-ctx.Done():
+			s.Push()
+			return
+		}
+	}
+}
+
+// Push sends the counters and gauges, and any timings not yet sent.
+func (s *Statsd) Push() error {
+	families := s.registry.Snapshot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range families {
+		for _, sample := range f.Samples {
+			name := s.name(f.Name, sample.Labels)
+			tags := s.tagString(f.LabelNames, sample.Labels)
+			switch f.Kind {
+			case KindCounter:
+				key := name + tags
+				delta := sample.Value - s.last[key]
+				if delta < 0 {
+					delta = sample.Value // the counter was reset
+				}
+				s.last[key] = sample.Value
+				if delta != 0 {
+					s.add(name + ":" + formatStatsd(delta) + "|c" + tags)
+				}
+			case KindGauge:
+				if sample.Value < 0 && !s.tags {
+					// Plain statsd reads a signed gauge as a change.
+					s.add(name + ":0|g")
+				}
+				s.add(name + ":" + formatStatsd(sample.Value) + "|g" + tags)
+			}
+		}
+	}
+	return s.flush()
+}
+
+func (s *Statsd) observe(name string, labelNames, labelValues []string, value float64) {
+	line := s.name(name, labelValues) + ":"
+	if strings.HasSuffix(name, "_seconds") {
+		line += formatStatsd(value*1000) + "|ms"
+	} else if s.tags {
+		line += formatStatsd(value) + "|h"
+	} else {
+		line += formatStatsd(value) + "|ms"
+	}
+	line += s.tagString(labelNames, labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(line)
+}
+
+// name returns the statsd name for a family and, without tags, its sample's
+// label values.
+func (s *Statsd) name(family string, labelValues []string) string {
+	name := s.prefix + family
+	if !s.tags {
+		for _, v := range labelValues {
+			name += "." + statsdEscaper.Replace(v)
+		}
+	}
+	return name
+}
+
+func (s *Statsd) tagString(labelNames, labelValues []string) string {
+	if !s.tags || len(labelNames) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("|#")
+	for i, name := range labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + ":" + statsdEscaper.Replace(labelValues[i]))
+	}
+	return b.String()
+}
+
+// statsdEscaper replaces the characters that delimit statsd lines, names
+// and tags.
+var statsdEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", ",", "_", "#", "_", "@", "_", "\n", "_", " ", "_")
+
+// add queues line, sending the queued lines first if they would no longer
+// fit in one packet. s.mu must be held.
+func (s *Statsd) add(line string) {
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > maxPacket {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// flush sends the queued lines. s.mu must be held.
+func (s *Statsd) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+	return err
+}
+
+func formatStatsd(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
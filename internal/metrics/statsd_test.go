@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsd(t *testing.T) {
+	for _, tt := range []struct {
+		dogstatsd bool
+		first     []string
+		second    []string
+	}{
+		{
+			dogstatsd: true,
+			first: []string{
+				"rn.latency_seconds:250|ms|#source:dns",
+				"rn.size:3|h|#source:dns",
+				"rn.requests_total:2|c|#status:301",
+				"rn.requests_total:1|c|#status:302",
+				"rn.inflight:-1|g",
+			},
+			second: []string{"rn.requests_total:1|c|#status:301", "rn.inflight:-1|g"},
+		},
+		{
+			first: []string{
+				"rn.latency_seconds.dns:250|ms",
+				"rn.size.dns:3|ms",
+				"rn.requests_total.301:2|c",
+				"rn.requests_total.302:1|c",
+				"rn.inflight:0|g",
+				"rn.inflight:-1|g",
+			},
+			second: []string{"rn.requests_total.301:1|c", "rn.inflight:0|g", "rn.inflight:-1|g"},
+		},
+	} {
+		agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer agent.Close()
+		r := NewRegistry()
+		requests := r.Counter("requests_total", "Requests served.", "status")
+		inflight := r.Gauge("inflight", "Requests in flight.")
+		latency := r.Histogram("latency_seconds", "Request latency.", DefaultLatencyBounds, "source")
+		size := r.Histogram("size", "Response size.", []float64{1, 10}, "source")
+		s, err := NewStatsd(r, agent.LocalAddr().String(), "rn.", tt.dogstatsd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		requests.Add(2, "301")
+		requests.Inc("302")
+		inflight.Set(-1)
+		latency.Observe(0.25, "dns")
+		size.Observe(3, "dns")
+		s.Push()
+		assertPacket(t, agent, tt.first)
+
+		requests.Inc("301")
+		s.Push()
+		assertPacket(t, agent, tt.second)
+	}
+}
+
+func assertPacket(t *testing.T, conn net.PacketConn, want []string) {
+	t.Helper()
+	buf := make([]byte, maxPacket)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != strings.Join(want, "\n") {
+		t.Errorf("want:\n%s\ngot:\n%s", strings.Join(want, "\n"), got)
+	}
+}
@@ -0,0 +1,230 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol, versions 1 and 2, so that servers behind TCP load balancers see
+// the real client address.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds how long a connection may take to send its header.
+const DefaultTimeout = 5 * time.Second
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned from reads on a connection that should have
+// started with a PROXY header but didn't.
+var ErrNoHeader = errors.New("proxyproto: missing PROXY header")
+
+// Listener wraps a net.Listener whose connections start with a PROXY
+// header. The header is read on the first Read or RemoteAddr call, not in
+// Accept, so a slow peer can't hold up the accept loop.
+type Listener struct {
+	net.Listener
+
+	// Trusted, if not empty, limits which peers may send a header.
+	// Connections from other peers are passed through untouched.
+	Trusted []netip.Prefix
+
+	// Timeout bounds how long reading the header may take. Zero means
+	// DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &Conn{Conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	if len(l.Trusted) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range l.Trusted {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose remote address comes from its PROXY header.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.local, c.err = readHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// Read reads data following the PROXY header.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the
+// peer's address for LOCAL and UNKNOWN headers.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.init(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the PROXY header, or the
+// socket's own address.
+func (c *Conn) LocalAddr() net.Addr {
+	if c.init(); c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes a v1 or v2 header from r. It returns nil addresses
+// for headers that carry none.
+func readHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil {
+		if bytes.HasPrefix(v2Signature, peek) || bytes.HasPrefix([]byte("PROXY "), peek) {
+			return nil, nil, fmt.Errorf("proxyproto: reading header: %w", err)
+		}
+		return nil, nil, ErrNoHeader
+	}
+	switch {
+	case bytes.Equal(peek, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(peek, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return nil, nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxyproto: reading header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("proxyproto: v1 header too long or not CRLF-terminated")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("proxyproto: malformed v1 header %q", strings.TrimSpace(string(line)))
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: bad address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: bad port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: reading header: %w", err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("proxyproto: unsupported version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("proxyproto: reading header: %w", err)
+	}
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL: health checks from the proxy itself.
+		return nil, nil, nil
+	case 1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("proxyproto: unknown command %d", hdr[12]&0xf)
+	}
+
+	var size int
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		size = 4
+	case 2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC, AF_UNIX: nothing we can use.
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("proxyproto: v2 address block too short")
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+}
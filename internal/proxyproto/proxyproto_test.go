@@ -0,0 +1,122 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func v2Header(cmd byte, src, dst netip.AddrPort) []byte {
+	var buf bytes.Buffer
+	buf.Write(v2Signature)
+	buf.WriteByte(0x20 | cmd)
+	if src.Addr().Is4() {
+		buf.WriteByte(0x11)
+	} else {
+		buf.WriteByte(0x21)
+	}
+	addrs := append(src.Addr().AsSlice(), dst.Addr().AsSlice()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0xff) // a TLV, ignored
+	binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+// roundTrip sends data to l and returns the accepted connection's remote
+// address and the payload read from it.
+func roundTrip(t *testing.T, l *Listener, data []byte) (string, string, error) {
+	t.Helper()
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write(data)
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	remote := c.RemoteAddr().String()
+	payload, err := io.ReadAll(c)
+	return remote, string(payload), err
+}
+
+func newListener(t *testing.T) *Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return &Listener{Listener: ln, Timeout: time.Second}
+}
+
+func TestHeaders(t *testing.T) {
+	l := newListener(t)
+	cases := []struct {
+		name       string
+		header     []byte
+		wantRemote string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 198.51.100.7 192.0.2.1 51234 443\r\n"), "198.51.100.7:51234"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\n"), "[2001:db8::7]:51234"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v2 tcp4", v2Header(1, netip.MustParseAddrPort("198.51.100.7:51234"), netip.MustParseAddrPort("192.0.2.1:443")), "198.51.100.7:51234"},
+		{"v2 tcp6", v2Header(1, netip.MustParseAddrPort("[2001:db8::7]:51234"), netip.MustParseAddrPort("[2001:db8::1]:443")), "[2001:db8::7]:51234"},
+		{"v2 local", v2Header(0, netip.MustParseAddrPort("198.51.100.7:51234"), netip.MustParseAddrPort("192.0.2.1:443")), ""},
+	}
+	for _, c := range cases {
+		remote, payload, err := roundTrip(t, l, append(c.header, "GET / HTTP/1.1\r\n"...))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if c.wantRemote == "" {
+			if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+				t.Errorf("%s: want the peer address, got %s", c.name, remote)
+			}
+		} else if remote != c.wantRemote {
+			t.Errorf("%s: remote: want %s, got %s", c.name, c.wantRemote, remote)
+		}
+		if payload != "GET / HTTP/1.1\r\n" {
+			t.Errorf("%s: payload: got %q", c.name, payload)
+		}
+	}
+}
+
+func TestMissingHeader(t *testing.T) {
+	l := newListener(t)
+	for _, data := range []string{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", "PROXY TCP4 nonsense\r\n", "hi"} {
+		_, _, err := roundTrip(t, l, []byte(data))
+		if err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+	_, _, err := roundTrip(t, l, []byte("GET / HTTP/1.1\r\n"))
+	if !errors.Is(err, ErrNoHeader) {
+		t.Errorf("want ErrNoHeader, got %v", err)
+	}
+}
+
+func TestUntrustedPeer(t *testing.T) {
+	l := newListener(t)
+	l.Trusted = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	header := "PROXY TCP4 198.51.100.7 192.0.2.1 51234 443\r\n"
+	remote, payload, err := roundTrip(t, l, []byte(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" || payload != header {
+		t.Errorf("untrusted peer: want the connection untouched, got %s sending %q", remote, payload)
+	}
+}
@@ -0,0 +1,219 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// HELLO WORLD as 1-M, from the worked example in the standard's
+	// tutorials.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	for _, c := range []struct {
+		level Level
+		mask  int
+		want  string
+	}{
+		{L, 0, "111011111000100"},
+		{M, 0, "101010000010010"},
+		{H, 7, "000100000111011"},
+	} {
+		code := &Code{Level: c.level}
+		if got := fmt.Sprintf("%015b", code.formatBits(c.mask)); got != c.want {
+			t.Errorf("%d/%d: got %s, want %s", c.level, c.mask, got, c.want)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for _, c := range []struct {
+		level      Level
+		max, versn int
+	}{
+		{L, 17, 1}, {M, 14, 1}, {Q, 11, 1}, {H, 7, 1},
+		{M, 213, 10}, {L, 2953, 40}, {H, 1273, 40},
+	} {
+		code, err := Encode(make([]byte, c.max), c.level)
+		if err != nil || code.Version != c.versn {
+			t.Errorf("%d bytes at %d: got %v, %v, want version %d", c.max, c.level, code, err, c.versn)
+			continue
+		}
+		next, err := Encode(make([]byte, c.max+1), c.level)
+		if c.versn == 40 {
+			if err != ErrTooLong {
+				t.Errorf("%d bytes at %d: want ErrTooLong, got %v", c.max+1, c.level, err)
+			}
+		} else if err != nil || next.Version != c.versn+1 {
+			t.Errorf("%d bytes at %d: got %v, %v", c.max+1, c.level, next, err)
+		}
+	}
+}
+
+// decode reads a code back: the format information, the codewords in
+// placement order, each block checked against its error correction, and
+// the byte mode segment.
+func decode(t *testing.T, c *Code) []byte {
+	t.Helper()
+	var bits int
+	get := func(x, y, i int) {
+		if c.Dark(x, y) {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		get(8, i, i)
+	}
+	get(8, 7, 6)
+	get(8, 8, 7)
+	get(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		get(14-i, 8, i)
+	}
+	if bits != c.formatBits(c.Mask) {
+		t.Fatalf("format bits %015b, want %015b", bits, c.formatBits(c.Mask))
+	}
+	first := bits
+	bits = 0
+	for i := range 8 {
+		get(c.Size-1-i, 8, i)
+	}
+	for i := 8; i < 15; i++ {
+		get(8, c.Size-15+i, i)
+	}
+	if bits != first {
+		t.Fatalf("second format copy %015b, want %015b", bits, first)
+	}
+
+	c.applyMask(c.Mask)
+	defer c.applyMask(c.Mask)
+	raw := make([]byte, rawModules(c.Version)/8)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range c.Size {
+			for j := range 2 {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(raw)*8 {
+					if c.modules[y][x] {
+						raw[i>>3] |= 1 << (7 - i&7)
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	blocks, eccLen := numBlocks[c.Level][c.Version], eccPerBlock[c.Level][c.Version]
+	short := blocks - len(raw)%blocks
+	shortLen := len(raw) / blocks
+	split := make([][]byte, blocks)
+	k := 0
+	for i := 0; i < shortLen+1; i++ {
+		for j := range blocks {
+			if i == shortLen-eccLen && j < short {
+				continue
+			}
+			split[j] = append(split[j], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range split {
+		n := len(block) - eccLen
+		if got := rsRemainder(block[:n], rsDivisor(eccLen)); !bytes.Equal(got, block[n:]) {
+			t.Fatalf("block %d: error correction doesn't check", j)
+		}
+		data = append(data, block[:n]...)
+	}
+
+	bit := func(i int) int { return int(data[i/8]>>(7-i%8)) & 1 }
+	field := func(pos, n int) int {
+		v := 0
+		for i := range n {
+			v = v<<1 | bit(pos+i)
+		}
+		return v
+	}
+	if mode := field(0, 4); mode != 0b0100 {
+		t.Fatalf("mode %04b", mode)
+	}
+	n := field(4, countBits(c.Version))
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(field(4+countBits(c.Version)+8*i, 8))
+	}
+	return out
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, s := range []string{
+		"https://example.com/",
+		"https://go.example.com/promo?utm_source=poster",
+		strings.Repeat("https://example.com/very/long/path/", 20),
+		strings.Repeat("x", 1200),
+	} {
+		for level := L; level <= H; level++ {
+			c, err := Encode([]byte(s), level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Size != c.Version*4+17 {
+				t.Fatalf("size %d for version %d", c.Size, c.Version)
+			}
+			if got := decode(t, c); string(got) != s {
+				t.Errorf("%.20s... at %d: decoded %.20q", s, level, got)
+			}
+			// Finder patterns' centers are dark, ringed by light.
+			for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+				if !c.Dark(p[0], p[1]) || c.Dark(p[0]+2, p[1]) || !c.Dark(p[0]+3, p[1]) {
+					t.Errorf("version %d: bad finder at %v", c.Version, p)
+				}
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode([]byte("https://example.com/"), M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.PNG(&buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if side := (c.Size + 8) * 4; img.Bounds().Dx() != side {
+		t.Errorf("width %d, want %d", img.Bounds().Dx(), side)
+	}
+	if r, _, _, _ := img.At(16, 16).RGBA(); r != 0 {
+		t.Error("top left finder: want dark")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("quiet zone: want light")
+	}
+
+	buf.Reset()
+	c.SVG(&buf)
+	if svg := buf.String(); !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 33 33"`) || !strings.Contains(svg, "M4 4h1v1h-1z") {
+		t.Errorf("svg: %.200s", svg)
+	}
+}
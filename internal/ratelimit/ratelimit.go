@@ -0,0 +1,108 @@
+// Package ratelimit provides token-bucket rate limiting keyed by string, such
+// as a client IP or a hostname.
+package ratelimit
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys bounds how many buckets a Limiter tracks.
+const DefaultMaxKeys = 100000
+
+// A Limiter hands out Rate tokens per second per key, up to Burst at once.
+// It is safe for concurrent use.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	// MaxKeys bounds memory use. When it is reached, idle buckets (which
+	// have refilled completely) are dropped, and failing that the tenth
+	// used longest ago are. Zero means DefaultMaxKeys.
+	MaxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter allowing rate requests per second per key, with
+// bursts of up to burst.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{Rate: rate, Burst: burst}
+}
+
+// Allow takes a token from key's bucket. If the bucket is empty it returns
+// false and how long until a token will be available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt is like Allow with the current time given by the caller.
+func (l *Limiter) AllowAt(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		l.makeRoom(now)
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Len reports how many keys are being tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+}
+
+func (l *Limiter) makeRoom(now time.Time) {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	max := l.MaxKeys
+	if max == 0 {
+		max = DefaultMaxKeys
+	}
+	if len(l.buckets) < max {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < max {
+		return
+	}
+	// Every bucket is in use: drop the tenth that were used longest ago,
+	// rather than all of them, so a flood of new keys can't reset the
+	// buckets of the keys being limited.
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int { return l.buckets[a].last.Compare(l.buckets[b].last) })
+	for _, key := range keys[:len(keys)-max+max/10+1] {
+		delete(l.buckets, key)
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(2, 3)
+
+	for i := range 3 {
+		if ok, _ := l.AllowAt("a", now); !ok {
+			t.Fatalf("request %d within burst refused", i+1)
+		}
+	}
+	ok, wait := l.AllowAt("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("over burst: want refusal with 500ms wait, got %v, %v", ok, wait)
+	}
+	if ok, _ := l.AllowAt("b", now); !ok {
+		t.Error("keys should have separate buckets")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.AllowAt("a", now); !ok {
+		t.Error("expected a token after refilling")
+	}
+	if ok, _ := l.AllowAt("a", now); ok {
+		t.Error("expected only one token after 500ms")
+	}
+}
+
+func TestLimiterMaxKeys(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(1, 1)
+	l.MaxKeys = 2
+
+	l.AllowAt("a", now)
+	l.AllowAt("b", now)
+	now = now.Add(time.Second)
+	l.AllowAt("c", now)
+	if n := l.Len(); n != 1 {
+		t.Errorf("idle buckets should be dropped: want 1 key, got %d", n)
+	}
+}
+
+func TestLimiterMaxKeysBusy(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(0.01, 1)
+	l.MaxKeys = 2
+
+	l.AllowAt("a", now)
+	l.AllowAt("b", now.Add(time.Second))
+	l.AllowAt("c", now.Add(2*time.Second))
+	if n := l.Len(); n != 2 {
+		t.Errorf("want the busy buckets bounded to 2 keys, got %d", n)
+	}
+	if ok, _ := l.AllowAt("b", now.Add(3*time.Second)); ok {
+		t.Error("b's bucket was reset: want only the oldest bucket dropped")
+	}
+	if ok, _ := l.AllowAt("a", now.Add(4*time.Second)); !ok {
+		t.Error("a's bucket, the oldest, should have been dropped")
+	}
+}
@@ -0,0 +1,20 @@
+package sigv4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSign checks the get-vanilla case of AWS's Signature Version 4 test
+// suite.
+func TestSign(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	req.Header = http.Header{}
+	Sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1", "service")
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
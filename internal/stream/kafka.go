@@ -0,0 +1,441 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kafka API keys and the versions used: the oldest Kafka 4 still accepts,
+// none of which need the flexible (tagged field) encoding.
+const (
+	apiProduce      = 0
+	apiMetadata     = 3
+	produceVersion  = 3
+	metadataVersion = 4
+)
+
+// kafkaAcks is the acknowledgement Produce asks for: the partition leader's.
+const kafkaAcks = 1
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka produces to a topic, partitioning messages by a hash of their keys
+// (or round-robin for messages without one) and sending each partition
+// leader its messages as one uncompressed record batch. It has no
+// authentication or TLS, so it is meant for brokers on a private network.
+type Kafka struct {
+	Brokers []string // bootstrap brokers, host:port
+	Topic   string
+
+	mu      sync.Mutex
+	conns   map[int32]*kafkaConn // by broker ID
+	addrs   map[int32]string
+	leaders []int32 // by partition
+	next    int
+	corrID  int32
+}
+
+type kafkaConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// KafkaError is an error code returned by a broker.
+type KafkaError int16
+
+func (e KafkaError) Error() string {
+	switch e {
+	case 3:
+		return "kafka: unknown topic or partition"
+	case 5:
+		return "kafka: leader not available"
+	case 6:
+		return "kafka: not leader for partition"
+	case 10:
+		return "kafka: message too large"
+	}
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// Publish sends msgs, fetching the topic's partition leaders first if
+// needed. After an error the leaders are fetched again on the next call.
+func (k *Kafka) Publish(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.publish(ctx, msgs); err != nil {
+		k.leaders = nil
+		return err
+	}
+	return nil
+}
+
+func (k *Kafka) publish(ctx context.Context, msgs []Message) error {
+	if k.leaders == nil {
+		if err := k.refreshMetadata(ctx); err != nil {
+			return err
+		}
+	}
+	byPartition := make(map[int32][]Message)
+	for _, m := range msgs {
+		var p int
+		if len(m.Key) > 0 {
+			h := fnv.New32a()
+			h.Write(m.Key)
+			p = int(h.Sum32() % uint32(len(k.leaders)))
+		} else {
+			p = k.next % len(k.leaders)
+			k.next++
+		}
+		byPartition[int32(p)] = append(byPartition[int32(p)], m)
+	}
+	byLeader := make(map[int32][]int32)
+	for p := range byPartition {
+		byLeader[k.leaders[p]] = append(byLeader[k.leaders[p]], p)
+	}
+	for leader, partitions := range byLeader {
+		if err := k.produce(ctx, leader, partitions, byPartition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// produce sends leader the messages for its partitions.
+func (k *Kafka) produce(ctx context.Context, leader int32, partitions []int32, msgs map[int32][]Message) error {
+	var b kafkaBuf
+	b.int16(-1) // transactional_id: null
+	b.int16(kafkaAcks)
+	b.int32(10000) // timeout_ms
+	b.int32(1)
+	b.string(k.Topic)
+	b.int32(int32(len(partitions)))
+	now := time.Now().UnixMilli()
+	for _, p := range partitions {
+		b.int32(p)
+		batch := recordBatch(msgs[p], now)
+		b.int32(int32(len(batch)))
+		b = append(b, batch...)
+	}
+	resp, err := k.roundTrip(ctx, leader, apiProduce, produceVersion, b)
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{b: resp}
+	for range r.count() {
+		r.string()
+		for range r.count() {
+			r.int32() // partition
+			code := r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time
+			if code != 0 && r.err == nil {
+				return KafkaError(code)
+			}
+		}
+	}
+	return r.err
+}
+
+// recordBatch encodes msgs as a v2 record batch.
+func recordBatch(msgs []Message, timestamp int64) []byte {
+	var records kafkaBuf
+	for i, m := range msgs {
+		var rec kafkaBuf
+		rec = append(rec, 0) // attributes
+		rec = binary.AppendVarint(rec, 0)
+		rec = binary.AppendVarint(rec, int64(i))
+		if m.Key == nil {
+			rec = binary.AppendVarint(rec, -1)
+		} else {
+			rec = binary.AppendVarint(rec, int64(len(m.Key)))
+			rec = append(rec, m.Key...)
+		}
+		rec = binary.AppendVarint(rec, int64(len(m.Value)))
+		rec = append(rec, m.Value...)
+		rec = binary.AppendVarint(rec, 0) // headers
+		records = binary.AppendVarint(records, int64(len(rec)))
+		records = append(records, rec...)
+	}
+
+	var tail kafkaBuf // from attributes on, covered by the CRC
+	tail.int16(0)     // attributes: no compression
+	tail.int32(int32(len(msgs) - 1))
+	tail.int64(timestamp)
+	tail.int64(timestamp)
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(int32(len(msgs)))
+	tail = append(tail, records...)
+
+	var b kafkaBuf
+	b.int64(0)                            // base_offset
+	b.int32(int32(4 + 1 + 4 + len(tail))) // batch_length
+	b.int32(-1)                           // partition_leader_epoch
+	b = append(b, 2)                      // magic
+	b.int32(int32(crc32.Checksum(tail, castagnoli)))
+	return append(b, tail...)
+}
+
+// refreshMetadata learns the topic's partition leaders from the first
+// bootstrap broker that answers.
+func (k *Kafka) refreshMetadata(ctx context.Context) error {
+	var b kafkaBuf
+	b.int32(1)
+	b.string(k.Topic)
+	b = append(b, 1) // allow_auto_topic_creation
+	var errs []error
+	for _, addr := range k.Brokers {
+		conn, err := dialKafka(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		k.corrID++
+		resp, err := conn.roundTrip(ctx, k.corrID, apiMetadata, metadataVersion, b)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("kafka %s: %w", addr, err))
+			continue
+		}
+		return k.parseMetadata(resp)
+	}
+	return errors.Join(errs...)
+}
+
+func (k *Kafka) parseMetadata(resp []byte) error {
+	r := kafkaReader{b: resp}
+	r.int32() // throttle_time_ms
+	addrs := make(map[int32]string)
+	for range r.count() {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	var leaders []int32
+	var topicErr int16
+	for range r.count() {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		for range r.count() {
+			r.int16() // error_code
+			index := r.int32()
+			leader := r.int32()
+			for range r.count() { // replica_nodes
+				r.int32()
+			}
+			for range r.count() { // isr_nodes
+				r.int32()
+			}
+			if name == k.Topic && index >= 0 && index < 1<<16 {
+				for int(index) >= len(leaders) {
+					leaders = append(leaders, -1)
+				}
+				leaders[index] = leader
+			}
+		}
+		if name == k.Topic {
+			topicErr = code
+		}
+	}
+	switch {
+	case r.err != nil:
+		return fmt.Errorf("kafka: decoding metadata: %w", r.err)
+	case topicErr != 0:
+		return KafkaError(topicErr)
+	case len(leaders) == 0:
+		return KafkaError(3)
+	}
+	for _, leader := range leaders {
+		if _, ok := addrs[leader]; !ok {
+			return KafkaError(5)
+		}
+	}
+	for id, conn := range k.conns {
+		if addrs[id] != k.addrs[id] {
+			conn.Close()
+			delete(k.conns, id)
+		}
+	}
+	k.addrs, k.leaders = addrs, leaders
+	return nil
+}
+
+// roundTrip sends a request to a broker, connecting first if needed, and
+// returns the response body.
+func (k *Kafka) roundTrip(ctx context.Context, broker int32, apiKey, version int16, body []byte) ([]byte, error) {
+	if k.conns == nil {
+		k.conns = make(map[int32]*kafkaConn)
+	}
+	conn := k.conns[broker]
+	if conn == nil {
+		var err error
+		if conn, err = dialKafka(ctx, k.addrs[broker]); err != nil {
+			return nil, err
+		}
+		k.conns[broker] = conn
+	}
+	k.corrID++
+	resp, err := conn.roundTrip(ctx, k.corrID, apiKey, version, body)
+	if err != nil {
+		conn.Close()
+		delete(k.conns, broker)
+		return nil, fmt.Errorf("kafka %s: %w", k.addrs[broker], err)
+	}
+	return resp, nil
+}
+
+func dialKafka(ctx context.Context, addr string) (*kafkaConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (c *kafkaConn) roundTrip(ctx context.Context, corrID int32, apiKey, version int16, body []byte) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(15 * time.Second)
+	}
+	c.SetDeadline(deadline)
+	var b kafkaBuf
+	b.int32(0) // size, filled in below
+	b.int16(apiKey)
+	b.int16(version)
+	b.int32(corrID)
+	b.string("redirect.name")
+	b = append(b, body...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	if _, err := c.Write(b); err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("bad response size %d", size)
+	}
+	if got := int32(binary.BigEndian.Uint32(header[4:])); got != corrID {
+		return nil, fmt.Errorf("response to request %d, want %d", got, corrID)
+	}
+	resp := make([]byte, size-4)
+	_, err := io.ReadFull(c.r, resp)
+	return resp, err
+}
+
+// Close closes the connections to the brokers.
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, id)
+	}
+	k.leaders = nil
+	return nil
+}
+
+// kafkaBuf encodes Kafka's big-endian request fields.
+type kafkaBuf []byte
+
+func (b *kafkaBuf) int16(v int16) { *b = binary.BigEndian.AppendUint16(*b, uint16(v)) }
+func (b *kafkaBuf) int32(v int32) { *b = binary.BigEndian.AppendUint32(*b, uint32(v)) }
+func (b *kafkaBuf) int64(v int64) { *b = binary.BigEndian.AppendUint64(*b, uint64(v)) }
+
+func (b *kafkaBuf) string(s string) {
+	b.int16(int16(len(s)))
+	*b = append(*b, s...)
+}
+
+// kafkaReader decodes response fields, remembering the first error so
+// callers can check once at the end.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) int8() int8 {
+	if v := r.take(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if v := r.take(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if v := r.take(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if v := r.take(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+// count reads an array length, which can't exceed the bytes left.
+func (r *kafkaReader) count() int {
+	n := r.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(n)
+}
+
+// string reads a string; null strings (length -1) read as "".
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
@@ -0,0 +1,139 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes to a subject on a NATS server, using the core protocol
+// without JetStream acknowledgements. Each Publish ends with a PING and
+// waits for the server's PONG, so a nil error means the server has
+// received every message.
+type NATS struct {
+	Addr     string
+	Subject  string
+	User     string
+	Password string
+	Token    string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Publish sends msgs, connecting first if needed.
+func (n *NATS) Publish(ctx context.Context, msgs []Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.publish(ctx, msgs); err != nil {
+		n.closeConn()
+		return fmt.Errorf("nats %s: %w", n.Addr, err)
+	}
+	return nil
+}
+
+func (n *NATS) publish(ctx context.Context, msgs []Message) error {
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	n.conn.SetDeadline(deadline)
+	w := bufio.NewWriter(n.conn)
+	for _, m := range msgs {
+		fmt.Fprintf(w, "PUB %s %d\r\n", n.Subject, len(m.Value))
+		w.Write(m.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// connect dials the server, reads its INFO and sends CONNECT.
+func (n *NATS) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(line[len("INFO "):]), &info)
+	if info.TLSRequired {
+		return errors.New("server requires TLS, which is not supported")
+	}
+	connect, _ := json.Marshal(map[string]any{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "redirect.name",
+		"lang":       "go",
+		"version":    "1",
+		"user":       n.User,
+		"pass":       n.Password,
+		"auth_token": n.Token,
+	})
+	_, err = conn.Write([]byte("CONNECT " + string(connect) + "\r\n"))
+	return err
+}
+
+func (n *NATS) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *NATS) closeConn() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
+
+// Close closes the connection, if any.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closeConn()
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package stream publishes messages to Kafka topics and NATS subjects with
+// minimal built-in clients, for exporting events to analytics pipelines.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// A Message is one record to publish. Kafka partitions by Key; NATS
+// ignores it.
+type Message struct {
+	Key, Value []byte
+}
+
+// A Publisher sends messages to one topic or subject. Publish connects
+// as needed and reconnects after errors. A Publisher is safe for
+// concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+	Close() error
+}
+
+// New returns a Publisher for rawURL, which is either
+// kafka://broker[:port][,broker[:port]...]/topic or
+// nats://[user:password@ or token@]host[:port]/subject. It does not
+// connect.
+func New(rawURL string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	dest := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || dest == "" || strings.ContainsAny(dest, "/ \t") {
+		return nil, fmt.Errorf("%q must be kafka://brokers/topic or nats://host/subject", rawURL)
+	}
+	switch u.Scheme {
+	case "kafka":
+		var brokers []string
+		for _, b := range strings.Split(u.Host, ",") {
+			brokers = append(brokers, withPort(b, "9092"))
+		}
+		return &Kafka{Brokers: brokers, Topic: dest}, nil
+	case "nats":
+		n := &NATS{Addr: withPort(u.Host, "4222"), Subject: dest}
+		if u.User != nil {
+			if pass, ok := u.User.Password(); ok {
+				n.User, n.Password = u.User.Username(), pass
+			} else {
+				n.Token = u.User.Username()
+			}
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%q: scheme must be kafka or nats", rawURL)
+}
+
+func withPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
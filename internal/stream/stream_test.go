@@ -0,0 +1,280 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New("kafka://k1,k2:9093/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := p.(*Kafka); strings.Join(k.Brokers, " ") != "k1:9092 k2:9093" || k.Topic != "events" {
+		t.Errorf("got %+v", k)
+	}
+	p, err = New("nats://s3cret@nats.internal/redirects.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.(*NATS); n.Addr != "nats.internal:4222" || n.Subject != "redirects.events" || n.Token != "s3cret" {
+		t.Errorf("got %+v", n)
+	}
+	for _, bad := range []string{"kafka://k1", "nats:///subject", "amqp://host/q", "kafka://k1/a/b"} {
+		if _, err := New(bad); err == nil {
+			t.Errorf("%s: want an error", bad)
+		}
+	}
+}
+
+// fakeBroker is a single Kafka broker leading every partition of topic,
+// recording the values produced to each.
+type fakeBroker struct {
+	ln         net.Listener
+	topic      string
+	partitions int
+
+	mu       sync.Mutex
+	produced map[int32][]string
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, topic: topic, partitions: partitions, produced: make(map[int32][]string)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var size int32
+		if binary.Read(r, binary.BigEndian, &size) != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		kr := kafkaReader{b: req}
+		apiKey, version, corrID := kr.int16(), kr.int16(), kr.int32()
+		kr.string() // client_id
+		var resp kafkaBuf
+		resp.int32(corrID)
+		switch {
+		case apiKey == apiMetadata && version == metadataVersion:
+			host, port, _ := net.SplitHostPort(b.ln.Addr().String())
+			p, _ := strconv.Atoi(port)
+			resp.int32(0) // throttle
+			resp.int32(1)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(p))
+			resp.int16(-1) // rack
+			resp.int16(-1) // cluster_id
+			resp.int32(1)  // controller
+			resp.int32(1)
+			resp.int16(0)
+			resp.string(b.topic)
+			resp = append(resp, 0)
+			resp.int32(int32(b.partitions))
+			for i := range b.partitions {
+				resp.int16(0)
+				resp.int32(int32(i))
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+			}
+		case apiKey == apiProduce && version == produceVersion:
+			kr.int16() // transactional_id
+			kr.int16() // acks
+			kr.int32() // timeout
+			resp.int32(1)
+			kr.count()
+			resp.string(kr.string())
+			n := kr.count()
+			resp.int32(int32(n))
+			for range n {
+				partition := kr.int32()
+				batch := kr.take(int(kr.int32()))
+				values, err := decodeBatch(batch)
+				if err != nil {
+					t.Errorf("partition %d: %v", partition, err)
+				}
+				b.mu.Lock()
+				b.produced[partition] = append(b.produced[partition], values...)
+				b.mu.Unlock()
+				resp.int32(partition)
+				resp.int16(0)
+				resp.int64(0)
+				resp.int64(-1)
+			}
+			resp.int32(0) // throttle
+		default:
+			t.Errorf("unexpected request: api %d v%d", apiKey, version)
+			return
+		}
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(resp)))
+		conn.Write(append(out, resp...))
+	}
+}
+
+// decodeBatch checks a v2 record batch and returns its records' values.
+func decodeBatch(batch []byte) ([]string, error) {
+	r := kafkaReader{b: batch}
+	r.int64()
+	if length := r.int32(); int(length) != len(batch)-12 {
+		return nil, fmt.Errorf("batch_length %d, want %d", length, len(batch)-12)
+	}
+	r.int32()
+	if magic := r.int8(); magic != 2 {
+		return nil, fmt.Errorf("magic %d", magic)
+	}
+	crc := uint32(r.int32())
+	if got := crc32.Checksum(r.b, crc32.MakeTable(crc32.Castagnoli)); got != crc {
+		return nil, fmt.Errorf("crc %x, want %x", crc, got)
+	}
+	r.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	var values []string
+	rest := r.b[4:]
+	for range r.int32() {
+		length, n := binary.Varint(rest)
+		rec := rest[n : n+int(length)]
+		rest = rest[n+int(length):]
+		rec = rec[1:] // attributes
+		for range 2 { // timestamp and offset deltas
+			_, n = binary.Varint(rec)
+			rec = rec[n:]
+		}
+		keyLen, n := binary.Varint(rec)
+		rec = rec[n:]
+		if keyLen > 0 {
+			rec = rec[keyLen:]
+		}
+		valueLen, n := binary.Varint(rec)
+		values = append(values, string(rec[n:n+int(valueLen)]))
+	}
+	return values, nil
+}
+
+func TestKafkaPublish(t *testing.T) {
+	b := newFakeBroker(t, "events", 3)
+	k := &Kafka{Brokers: []string{b.ln.Addr().String()}, Topic: "events"}
+	defer k.Close()
+	var msgs []Message
+	for i := range 10 {
+		host := []string{"a.example", "b.example"}[i%2]
+		msgs = append(msgs, Message{Key: []byte(host), Value: []byte(host + "/" + strconv.Itoa(i))})
+	}
+	if err := k.Publish(context.Background(), msgs[:6]); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Publish(context.Background(), msgs[6:]); err != nil {
+		t.Fatal(err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var all []string
+	partitionOf := make(map[string]int32)
+	for p, values := range b.produced {
+		for _, v := range values {
+			host, _, _ := strings.Cut(v, "/")
+			if q, ok := partitionOf[host]; ok && q != p {
+				t.Errorf("%s produced to partitions %d and %d", host, q, p)
+			}
+			partitionOf[host] = p
+		}
+		all = append(all, values...)
+	}
+	sort.Strings(all)
+	if len(all) != 10 || all[0] != "a.example/0" || all[9] != "b.example/9" {
+		t.Errorf("want 10 records, got %v", all)
+	}
+}
+
+func TestKafkaUnknownTopic(t *testing.T) {
+	b := newFakeBroker(t, "events", 1)
+	k := &Kafka{Brokers: []string{b.ln.Addr().String()}, Topic: "other"}
+	if err := k.Publish(context.Background(), []Message{{Value: []byte("x")}}); err != KafkaError(3) {
+		t.Errorf("want unknown topic, got %v", err)
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				if !strings.Contains(line, `"auth_token":"tok"`) {
+					got <- "bad connect: " + line
+				}
+			case strings.HasPrefix(line, "PUB "):
+				fields := strings.Fields(line)
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				io.ReadFull(r, payload)
+				got <- fields[1] + " " + string(payload[:n])
+			case line == "PING":
+				conn.Write([]byte("PING\r\nPONG\r\n"))
+			}
+		}
+	}()
+
+	p, err := New("nats://tok@" + ln.Addr().String() + "/redirects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish(context.Background(), []Message{{Value: []byte(`{"a":1}`)}, {Value: []byte("two\r\nlines")}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`redirects {"a":1}`, "redirects two\r\nlines"} {
+		if msg := <-got; msg != want {
+			t.Errorf("got %q, want %q", msg, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package topk counts the most frequent keys in a stream in bounded memory,
+// with the Space-Saving algorithm.
+package topk
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// A Counter tracks at most Capacity keys. Once full, a new key replaces the
+// least counted one and inherits its count, so counts may be overestimated
+// by up to the Entry's Error but every key more frequent than that is kept.
+// It is safe for concurrent use.
+type Counter struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	heap    minHeap
+}
+
+// An Entry is a counted key.
+type Entry struct {
+	Key   string
+	Count uint64
+	// Error bounds how much Count may overstate the key's true count.
+	Error uint64
+}
+
+type entry struct {
+	Entry
+	index int
+}
+
+// New returns a Counter tracking up to capacity keys.
+func New(capacity int) *Counter {
+	return &Counter{capacity: capacity, entries: make(map[string]*entry)}
+}
+
+// Inc counts one occurrence of key.
+func (c *Counter) Inc(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Count++
+		heap.Fix(&c.heap, e.index)
+		return
+	}
+	if len(c.heap) < c.capacity {
+		e := &entry{Entry: Entry{Key: key, Count: 1}}
+		c.entries[key] = e
+		heap.Push(&c.heap, e)
+		return
+	}
+	if c.capacity <= 0 {
+		return
+	}
+	e := c.heap[0]
+	delete(c.entries, e.Key)
+	e.Key, e.Error = key, e.Count
+	e.Count++
+	c.entries[key] = e
+	heap.Fix(&c.heap, 0)
+}
+
+// Top returns the n most counted keys, most counted first, or every key if
+// n is not positive.
+func (c *Counter) Top(n int) []Entry {
+	c.mu.Lock()
+	top := make([]Entry, 0, len(c.heap))
+	for _, e := range c.heap {
+		top = append(top, e.Entry)
+	}
+	c.mu.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Len returns how many keys are tracked.
+func (c *Counter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.heap)
+}
+
+type minHeap []*entry
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *minHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *minHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,46 @@
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTop(t *testing.T) {
+	c := New(10)
+	for i, key := range []string{"a", "b", "c"} {
+		for range 3 - i {
+			c.Inc(key)
+		}
+	}
+	got := c.Top(2)
+	if len(got) != 2 || got[0] != (Entry{Key: "a", Count: 3}) || got[1] != (Entry{Key: "b", Count: 2}) {
+		t.Errorf("got %+v", got)
+	}
+	if n := len(c.Top(0)); n != 3 {
+		t.Errorf("Top(0): want all 3 keys, got %d", n)
+	}
+}
+
+func TestBounded(t *testing.T) {
+	c := New(5)
+	// Two heavy hitters among many keys seen once.
+	for i := range 1000 {
+		c.Inc("hot")
+		if i%2 == 0 {
+			c.Inc("warm")
+		}
+		c.Inc(fmt.Sprintf("cold%d", i))
+	}
+	if c.Len() != 5 {
+		t.Errorf("want 5 keys tracked, got %d", c.Len())
+	}
+	top := c.Top(2)
+	if top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Fatalf("got %+v", top)
+	}
+	for _, e := range top {
+		if e.Count-e.Error > map[string]uint64{"hot": 1000, "warm": 500}[e.Key] {
+			t.Errorf("%s: count %d - error %d exceeds its true count", e.Key, e.Count, e.Error)
+		}
+	}
+}
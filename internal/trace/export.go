@@ -0,0 +1,153 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Flush exports the queued spans now, in one OTLP/HTTP JSON request.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("trace: dropped %d spans exporting to %s", dropped, t.cfg.Endpoint)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.cfg.Client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("trace: exporting %d spans: %w", len(spans), err)
+		log.Print(err)
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("trace: exporting %d spans: %s answered %s", len(spans), t.cfg.Endpoint, resp.Status)
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers are decimal strings, as the protobuf JSON mapping
+// requires.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanJSON `json:"spans"`
+	}
+	scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	spanJSON struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              Kind       `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+	status struct {
+		Message string `json:"message,omitempty"`
+		Code    int    `json:"code,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// statusError is the status code of a failed span; others are left unset.
+const statusError = 2
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	out := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		j := spanJSON{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != (SpanID{}) {
+			j.ParentSpanID = s.parent.String()
+		}
+		for _, a := range s.attrs {
+			j.Attributes = append(j.Attributes, keyValue{a.key, value(a.value)})
+		}
+		if s.err != nil {
+			j.Status = status{Code: statusError, Message: s.err.Error()}
+		}
+		out = append(out, j)
+	}
+	res := []keyValue{{"service.name", value(t.cfg.Service)}}
+	if t.cfg.Version != "" {
+		res = append(res, keyValue{"service.version", value(t.cfg.Version)})
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: res},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: t.cfg.Service, Version: t.cfg.Version}, Spans: out}},
+	}}}
+}
+
+func value(v any) anyValue {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(v)
+	return anyValue{StringValue: &s}
+}
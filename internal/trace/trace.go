@@ -0,0 +1,245 @@
+// Package trace records spans, propagates them with W3C trace context
+// headers, and exports them to an OpenTelemetry collector over OTLP/HTTP,
+// without the weight of the OpenTelemetry SDK.
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A TraceID identifies a trace, and a SpanID a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether sc has nonzero IDs, as the spec requires.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are
+// accepted as long as they start with the fields version 00 defines.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || (len(s) > 55 && s[55] != '-') {
+		return sc, false
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(s[:2])); err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid() && isLowerHex(s[:55])
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'F' {
+			return false
+		}
+	}
+	return true
+}
+
+// Config configures a Tracer.
+type Config struct {
+	// Endpoint is the collector's OTLP/HTTP traces URL, e.g.
+	// http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are sent with every export, e.g. for authentication.
+	Headers map[string]string
+	// Service and Version name this program in the exported resource.
+	Service string
+	Version string
+	// SampleRatio is the fraction of new traces recorded. Traces continued
+	// from a traceparent header follow its sampled flag instead.
+	SampleRatio float64
+	// Interval is how often spans are exported. Zero means 5s.
+	Interval time.Duration
+	// Client is used for exports. If nil, exports time out after 10s.
+	Client *http.Client
+}
+
+// maxQueue bounds the spans held between exports; more are dropped.
+const maxQueue = 2048
+
+// A Tracer starts spans and exports the sampled ones in the background.
+// It is safe for concurrent use.
+type Tracer struct {
+	cfg Config
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// New returns a Tracer exporting per cfg. Call Shutdown to export the
+// spans still queued.
+func New(cfg Config) *Tracer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	t := &Tracer{cfg: cfg, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+// Kind is a span's OTLP SpanKind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// A Span is one timed operation. Spans that weren't sampled are still
+// created, so their context propagates, but they aren't exported.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   Kind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+	end    time.Time
+	attrs  []attribute
+	err    error
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+type spanKey struct{}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a span named name, as a child of the span in ctx if there
+// is one, and returns a context carrying it.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	var parent SpanContext
+	if p := FromContext(ctx); p != nil {
+		parent = p.sc
+	}
+	return t.start(ctx, name, KindInternal, parent)
+}
+
+// StartServer begins a server span for an incoming request, continuing the
+// trace in its traceparent header if that is valid.
+func (t *Tracer) StartServer(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	parent, _ := ParseTraceparent(traceparent)
+	return t.start(ctx, name, KindServer, parent)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, parent SpanContext) (context.Context, *Span) {
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		s.sc.TraceID, s.sc.Sampled, s.parent = parent.TraceID, parent.Sampled, parent.SpanID
+	} else {
+		for s.sc.TraceID == (TraceID{}) {
+			binary.BigEndian.PutUint64(s.sc.TraceID[:8], rand.Uint64())
+			binary.BigEndian.PutUint64(s.sc.TraceID[8:], rand.Uint64())
+		}
+		s.sc.Sampled = t.cfg.SampleRatio >= 1 || rand.Float64() < t.cfg.SampleRatio
+	}
+	for s.sc.SpanID == (SpanID{}) {
+		binary.BigEndian.PutUint64(s.sc.SpanID[:], rand.Uint64())
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Context returns the span's propagated context.
+func (s *Span) Context() SpanContext {
+	return s.sc
+}
+
+// SetAttribute records a string, bool, integer or float64 attribute; other
+// values are recorded as strings.
+func (s *Span) SetAttribute(key string, value any) {
+	if s.sc.Sampled {
+		s.attrs = append(s.attrs, attribute{key, value})
+	}
+}
+
+// End finishes the span, marking it failed if err is not nil, and queues it
+// for export if it was sampled.
+func (s *Span) End(err error) {
+	if !s.sc.Sampled || !s.end.IsZero() {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, s)
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	tick := time.NewTicker(t.cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.Flush(context.Background())
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Shutdown stops the background exports and exports what is still queued.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.stop)
+	<-t.stopped
+	return t.Flush(ctx)
+}
@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok || !sc.Sampled || sc.Traceparent() != valid {
+		t.Fatalf("%s: got %+v, %v", valid, sc, ok)
+	}
+	if sc, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok || sc.Sampled {
+		t.Errorf("future version: got %+v, %v", sc, ok)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("%q: want invalid", bad)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	var body exportRequest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("export body %s: %v", data, err)
+		}
+	}))
+	defer srv.Close()
+	tr := New(Config{Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"}, Service: "test", SampleRatio: 1})
+
+	ctx, server := tr.StartServer(context.Background(), "GET", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, child := tr.Start(ctx, "lookup")
+	child.SetAttribute("host", "go.example.com")
+	child.SetAttribute("records", 2)
+	child.End(errors.New("timeout"))
+	server.End(nil)
+	if FromContext(ctx) != server {
+		t.Error("FromContext: want the server span")
+	}
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer x" {
+		t.Errorf("Authorization: got %q", auth)
+	}
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %+v", spans)
+	}
+	c, s := spans[0], spans[1]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" || s.Kind != KindServer {
+		t.Errorf("server span: got %+v", s)
+	}
+	if c.TraceID != s.TraceID || c.ParentSpanID != s.SpanID || c.Kind != KindInternal {
+		t.Errorf("child span: got %+v", c)
+	}
+	if c.Status.Code != statusError || c.Status.Message != "timeout" {
+		t.Errorf("child status: got %+v", c.Status)
+	}
+	if len(c.Attributes) != 2 || *c.Attributes[0].Value.StringValue != "go.example.com" || *c.Attributes[1].Value.IntValue != "2" {
+		t.Errorf("child attributes: got %+v", c.Attributes)
+	}
+}
+
+func TestUnsampled(t *testing.T) {
+	tr := New(Config{Endpoint: "http://127.0.0.1:0", SampleRatio: 1})
+	defer tr.Shutdown(context.Background())
+	ctx, s := tr.StartServer(context.Background(), "GET", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, child := tr.Start(ctx, "lookup")
+	if child.Context().Sampled || child.Context().TraceID != s.Context().TraceID {
+		t.Errorf("child of unsampled parent: got %+v", child.Context())
+	}
+	child.End(nil)
+	s.End(nil)
+	if len(tr.queue) != 0 {
+		t.Errorf("want nothing queued, got %d spans", len(tr.queue))
+	}
+}
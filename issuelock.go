@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/certcache"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// issueLockTTL bounds how long a replica that dies mid-order holds up
+// others, and issueLockWait how long a handshake waits for another
+// replica's order before going ahead anyway.
+const (
+	issueLockTTL  = 5 * time.Minute
+	issueLockWait = 90 * time.Second
+)
+
+// issueLock serializes certificate orders across replicas sharing
+// cert_cache: a handshake for a host this replica hasn't served yet, and
+// whose certificate isn't cached, takes the host's lock before autocert
+// looks at the cache, so another replica ordering it at the same time is
+// waited for and its certificate used.
+type issueLock struct {
+	cache  autocert.Cache
+	locker certcache.Locker
+	policy autocert.HostPolicy
+
+	mu     sync.Mutex
+	served map[string]bool
+}
+
+// newIssueLock returns the lock for cache, or nil if it isn't shared.
+func newIssueLock(cache autocert.Cache, policy autocert.HostPolicy) *issueLock {
+	locker, ok := cache.(certcache.Locker)
+	if !ok {
+		return nil
+	}
+	return &issueLock{cache: cache, locker: locker, policy: policy, served: make(map[string]bool)}
+}
+
+// tlsConfig returns config with its GetCertificate called under l's lock
+// for hosts not yet served.
+func (l *issueLock) tlsConfig(config *tls.Config) *tls.Config {
+	next := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if isTokenHello(hello) || l.hasServed(name) {
+			return next(hello)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), issueLockWait)
+		defer cancel()
+		if err := l.policy(ctx, name); err != nil {
+			return next(hello) // refused without ordering
+		}
+		if l.cached(ctx, name) {
+			return next(hello) // served from the cache without ordering
+		}
+		unlock, err := certcache.Lock(ctx, l.locker, "lock+"+name, issueLockTTL)
+		if err != nil {
+			log.Printf("Certificate lock for %s: %v", name, err)
+		} else {
+			defer unlock()
+		}
+		cert, err := next(hello)
+		if err == nil {
+			l.mu.Lock()
+			l.served[name] = true
+			l.mu.Unlock()
+		}
+		return cert, err
+	}
+	return config
+}
+
+// cached reports whether a certificate for name that hasn't expired is in
+// the cache, with either key type.
+func (l *issueLock) cached(ctx context.Context, name string) bool {
+	for _, key := range []string{name, name + "+rsa"} {
+		data, err := l.cache.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		if cert, err := parseCertificate(data); err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *issueLock) hasServed(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.served[name]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// memLocker is a certcache.Locker for one process.
+type memLocker struct {
+	mu    sync.Mutex
+	held  map[string]string
+	tries atomic.Int32
+}
+
+func (l *memLocker) TryLock(ctx context.Context, name string, ttl time.Duration) (string, bool, error) {
+	l.tries.Add(1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.held[name]; ok {
+		return "", false, nil
+	}
+	l.held[name] = "token"
+	return "token", true, nil
+}
+
+func (l *memLocker) Unlock(ctx context.Context, name, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[name] == token {
+		delete(l.held, name)
+	}
+	return nil
+}
+
+func TestIssueLock(t *testing.T) {
+	locker := &memLocker{held: make(map[string]string)}
+	cache := autocert.DirCache(t.TempDir())
+	l := &issueLock{
+		cache:  cache,
+		locker: locker,
+		policy: func(ctx context.Context, host string) error {
+			if host == "refused.example.com" {
+				return errors.New("not served")
+			}
+			return nil
+		},
+		served: make(map[string]bool),
+	}
+	var running, overlapped atomic.Int32
+	config := l.tlsConfig(&tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if running.Add(1) > 1 {
+			overlapped.Add(1)
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return &tls.Certificate{}, nil
+	}})
+
+	// Concurrent first handshakes for one host order one at a time.
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "go.example.com"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if overlapped.Load() != 0 {
+		t.Errorf("%d handshakes ran while another held the lock", overlapped.Load())
+	}
+	if len(locker.held) != 0 {
+		t.Errorf("locks left held: %v", locker.held)
+	}
+
+	// Once served, for refused hosts and for those with a certificate in
+	// the cache, the lock isn't touched.
+	ctx := context.Background()
+	cache.Put(ctx, "cached.example.com", cachedCert(t, "cached.example.com", time.Now().Add(time.Hour)))
+	cache.Put(ctx, "expired.example.com", cachedCert(t, "expired.example.com", time.Now().Add(-time.Hour)))
+	tries := locker.tries.Load()
+	config.GetCertificate(&tls.ClientHelloInfo{ServerName: "go.example.com"})
+	config.GetCertificate(&tls.ClientHelloInfo{ServerName: "refused.example.com"})
+	config.GetCertificate(&tls.ClientHelloInfo{ServerName: "cached.example.com"})
+	if got := locker.tries.Load(); got != tries {
+		t.Errorf("lock tried %d more times", got-tries)
+	}
+	config.GetCertificate(&tls.ClientHelloInfo{ServerName: "expired.example.com"})
+	if got := locker.tries.Load(); got != tries+1 {
+		t.Errorf("an expired certificate in the cache: want the lock taken, got %d tries", got-tries)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// upgradeSignals trigger a zero-downtime restart, which is only supported on
+// Linux.
+var upgradeSignals []os.Signal
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port is only supported on Linux")
+}
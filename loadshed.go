@@ -0,0 +1,31 @@
+package main
+
+import "net/http"
+
+var shedRequests = registry.Counter("redirect_shed_requests_total",
+	"Requests answered 503 because max_inflight requests were being handled.")
+
+// shedLoad handles at most n requests at a time, answering the rest at
+// once with 503 and Retry-After rather than queueing them, so that a spike
+// costs the clients past the limit a retry instead of costing everyone
+// file descriptors and memory. Health checks are exempt so an overloaded
+// server isn't also taken out of its load balancer.
+func shedLoad(n int, next http.Handler) http.Handler {
+	slots := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			shedRequests.Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service overloaded, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShedLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := shedLoad(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/slow").Code }()
+	<-started
+	if rr := get("/"); rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("past the limit: want 503 with Retry-After 1, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := get("/healthz"); rr.Code != http.StatusNoContent {
+		t.Errorf("health checks should be exempt, got %d", rr.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("the request in flight: got %d", code)
+	}
+	if rr := get("/"); rr.Code != http.StatusNoContent {
+		t.Errorf("after it finished: got %d", rr.Code)
+	}
+}
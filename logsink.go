@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/frolic/redirect.name/internal/logfile"
+)
+
+// logIdentifier tags messages sent to syslog and journald.
+const logIdentifier = "redirect-name"
+
+// journalSocket is where journald accepts native protocol datagrams.
+var journalSocket = "/run/systemd/journal/socket"
+
+// openLogSink returns the writer for an access_log or error_log setting:
+// stdout, stderr, syslog, journald, or a file rotated per log_max_size,
+// log_max_age and log_max_backups. Each Write must be one message.
+func openLogSink(cfg *config, dest string) (io.Writer, error) {
+	switch dest {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "syslog":
+		return newSyslog()
+	case "journald":
+		return newJournal()
+	}
+	if !isLogFile(dest) {
+		return nil, fmt.Errorf("%q is not a log destination", dest)
+	}
+	w, err := logfile.Open(dest, int64(cfg.LogMaxSize)<<20, cfg.LogMaxAge, cfg.LogMaxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dest, err)
+	}
+	return w, nil
+}
+
+// isLogFile reports whether a log destination names a file.
+func isLogFile(dest string) bool {
+	switch dest {
+	case "stdout", "stderr", "syslog", "journald", "off", "":
+		return false
+	}
+	return true
+}
+
+// setErrorLog sends the standard logger's output to error_log, with client
+// IPs in messages such as TLS handshake errors anonymized per
+// client_ip_logging. Syslog and journald timestamp messages themselves, so
+// the logger's own timestamps are dropped for them.
+func setErrorLog(cfg *config) error {
+	if cfg.ErrorLog == "stderr" && cfg.ClientIPLogging == "full" {
+		return nil
+	}
+	w, err := openLogSink(cfg, cfg.ErrorLog)
+	if err != nil {
+		return fmt.Errorf("error_log: %w", err)
+	}
+	if cfg.ClientIPLogging != "full" {
+		w = scrubWriter{w: w, a: newIPAnonymizer(cfg)}
+	}
+	log.SetOutput(w)
+	if cfg.ErrorLog == "syslog" || cfg.ErrorLog == "journald" {
+		log.SetFlags(0)
+	}
+	return nil
+}
+
+// journal writes each message to journald with the native protocol.
+type journal struct {
+	conn *net.UnixConn
+}
+
+func newJournal() (*journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to journald: %w", err)
+	}
+	return &journal{conn: conn}, nil
+}
+
+// Write sends p, less its trailing newline, as one journal entry at
+// informational priority. MESSAGE uses the length-prefixed form so it may
+// contain newlines.
+func (j *journal) Write(p []byte) (int, error) {
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+	var buf bytes.Buffer
+	buf.WriteString("PRIORITY=6\nSYSLOG_IDENTIFIER=" + logIdentifier + "\nMESSAGE\n")
+	binary.Write(&buf, binary.LittleEndian, uint64(len(msg)))
+	buf.Write(msg)
+	buf.WriteByte('\n')
+	if _, err := j.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
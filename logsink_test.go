@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	orig := journalSocket
+	t.Cleanup(func() { journalSocket = orig })
+	journalSocket = socket
+
+	cfg := defaultConfig()
+	w, err := openLogSink(cfg, "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "first line\nsecond line"
+	if n, err := w.Write([]byte(msg + "\n")); err != nil || n != len(msg)+1 {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "PRIORITY=6\nSYSLOG_IDENTIFIER=redirect-name\nMESSAGE\n"
+	got := buf[:n]
+	if !bytes.HasPrefix(got, []byte(header)) {
+		t.Fatalf("unexpected entry %q", got)
+	}
+	got = got[len(header):]
+	if size := binary.LittleEndian.Uint64(got); size != uint64(len(msg)) || string(got[8:]) != msg+"\n" {
+		t.Errorf("MESSAGE: got size %d and %q", size, got[8:])
+	}
+}
+
+func TestLogFileSink(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.LogMaxSize = 1
+	path := filepath.Join(t.TempDir(), "error.log")
+	if _, err := openLogSink(cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openLogSink(cfg, filepath.Join(path, "not-a-dir", "x.log")); err == nil {
+		t.Error("want an error for an unwritable path")
+	}
+}
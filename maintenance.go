@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// defaultMaintenanceReason is what the maintenance page says without a
+// message of its own.
+const defaultMaintenanceReason = "This service is down for maintenance."
+
+// maintenance switches redirects to the maintenance page. It is nil until
+// main sets it up.
+var maintenance *maintenanceMode
+
+// maintenanceMode answers redirects with the maintenance page and 503
+// while it's on, during a migration say. Health checks, the homepage and
+// the admin listener are left alone, and so are ACME challenges. It's
+// turned on and off through the admin API, or is on from startup with
+// maintenance.
+type maintenanceMode struct {
+	retryAfter time.Duration // unless turned on with another
+	on         atomic.Pointer[maintenanceWindow]
+}
+
+// A maintenanceWindow is maintenance mode as it was turned on.
+type maintenanceWindow struct {
+	message    string
+	retryAfter time.Duration
+	since      time.Time
+}
+
+// newMaintenanceMode returns the maintenance mode cfg starts in.
+func newMaintenanceMode(cfg *config) *maintenanceMode {
+	m := &maintenanceMode{retryAfter: cfg.MaintenanceRetry}
+	if cfg.Maintenance {
+		m.start("", m.retryAfter)
+		log.Print("Starting in maintenance mode")
+	}
+	return m
+}
+
+// start turns maintenance mode on, or changes its message and
+// Retry-After if it's already on.
+func (m *maintenanceMode) start(message string, retryAfter time.Duration) {
+	since := time.Now()
+	if w := m.on.Load(); w != nil {
+		since = w.since
+	}
+	m.on.Store(&maintenanceWindow{message: message, retryAfter: retryAfter, since: since})
+}
+
+// stop turns maintenance mode off, and reports whether it was on.
+func (m *maintenanceMode) stop() bool {
+	return m.on.Swap(nil) != nil
+}
+
+// serve returns next, answering with pages' maintenance page while m is
+// on.
+func (m *maintenanceMode) serve(pages *redirect.Pages, next http.Handler) http.Handler {
+	if pages == nil {
+		pages = redirect.DefaultPages()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		window := m.on.Load()
+		if window == nil || isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if window.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(window.retryAfter.Seconds())))
+		}
+		reason := window.message
+		if reason == "" {
+			reason = defaultMaintenanceReason
+		}
+		host, _ := redirect.ParseHost(r.Host)
+		pages.ServeMaintenance(w, r, host, reason)
+	})
+}
+
+// maintenanceStatus is the admin API's view of maintenance mode.
+type maintenanceStatus struct {
+	On         bool       `json:"on"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter string     `json:"retry_after,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// handleStatus serves GET /maintenance, and PUT /maintenance once it's
+// turned maintenance mode on.
+func (m *maintenanceMode) handleStatus(w http.ResponseWriter, r *http.Request) {
+	var status maintenanceStatus
+	if window := m.on.Load(); window != nil {
+		status = maintenanceStatus{On: true, Message: window.message, Since: &window.since}
+		if window.retryAfter > 0 {
+			status.RetryAfter = window.retryAfter.String()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleStart serves PUT /maintenance?message=...&retry_after=..., turning
+// maintenance mode on with the page saying message, and Retry-After
+// (default maintenance_retry_after; 0 sends none).
+func (m *maintenanceMode) handleStart(w http.ResponseWriter, r *http.Request) {
+	retryAfter := m.retryAfter
+	if v := r.URL.Query().Get("retry_after"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "retry_after must be a duration such as 10m", http.StatusBadRequest)
+			return
+		}
+		retryAfter = d
+	}
+	m.start(r.URL.Query().Get("message"), retryAfter)
+	log.Print("Maintenance mode on")
+	m.handleStatus(w, r)
+}
+
+// handleStop serves DELETE /maintenance, turning maintenance mode off.
+func (m *maintenanceMode) handleStop(w http.ResponseWriter, r *http.Request) {
+	if !m.stop() {
+		http.Error(w, "Maintenance mode is not on", http.StatusNotFound)
+		return
+	}
+	log.Print("Maintenance mode off")
+	w.WriteHeader(http.StatusNoContent)
+}
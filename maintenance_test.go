@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	orig, origResolver := maintenance, resolver
+	t.Cleanup(func() { maintenance, resolver = orig, origResolver })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}
+	cfg := defaultConfig()
+	cfg.AdminToken = "secret"
+	maintenance = newMaintenanceMode(cfg)
+	public, admin := newMux(cfg, nil), newAdminMux(cfg, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		public.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com"+path, nil))
+		return rr
+	}
+	adminDo := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("/"); rr.Code != http.StatusFound {
+		t.Fatalf("before maintenance: %d", rr.Code)
+	}
+	var status maintenanceStatus
+	rr := adminDo("PUT", "/maintenance?message=Moving+house.&retry_after=10m")
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil || !status.On || status.Message != "Moving house." || status.RetryAfter != "10m0s" || status.Since == nil {
+		t.Fatalf("turning on: %d %s", rr.Code, rr.Body)
+	}
+	rr = get("/")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "600" || !strings.Contains(rr.Body.String(), "Moving house.") {
+		t.Errorf("in maintenance: %d, Retry-After %q:\n%s", rr.Code, rr.Header().Get("Retry-After"), rr.Body)
+	}
+	if rr := get("/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("health check in maintenance: %d", rr.Code)
+	}
+	if rr := adminDo("PUT", "/maintenance?retry_after=soon"); rr.Code != http.StatusBadRequest {
+		t.Errorf("bad retry_after: %d", rr.Code)
+	}
+
+	if rr := adminDo("DELETE", "/maintenance"); rr.Code != http.StatusNoContent {
+		t.Errorf("turning off: %d", rr.Code)
+	}
+	if rr := adminDo("DELETE", "/maintenance"); rr.Code != http.StatusNotFound {
+		t.Errorf("turning off again: %d", rr.Code)
+	}
+	if rr := get("/"); rr.Code != http.StatusFound {
+		t.Errorf("after maintenance: %d", rr.Code)
+	}
+
+	// Starting in maintenance mode uses the default Retry-After.
+	cfg.Maintenance = true
+	maintenance = newMaintenanceMode(cfg)
+	public = newMux(cfg, nil)
+	if rr := get("/"); rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "300" || !strings.Contains(rr.Body.String(), defaultMaintenanceReason) {
+		t.Errorf("started in maintenance: %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+}
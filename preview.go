@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/net/html"
+)
+
+// Limits on fetching destinations for link previews.
+const (
+	maxPreviews       = 10000
+	maxPreviewBody    = 1 << 20
+	previewTimeout    = 3 * time.Second
+	previewTTL        = time.Hour
+	previewFailureTTL = 5 * time.Minute
+)
+
+// previewFetcher is a redirect.Previewer that fetches a destination's
+// page and reads its OpenGraph and Twitter card metadata. Results,
+// failures included, are cached, so a burst of crawlers unfurling the same
+// link fetches it once.
+type previewFetcher struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	previews map[string]cachedPreview
+	now      func() time.Time
+}
+
+type cachedPreview struct {
+	preview *redirect.Preview
+	err     error
+	expires time.Time
+}
+
+func newPreviewFetcher(cfg *config) *previewFetcher {
+	if cfg.LinkPreviews != "fetch" {
+		return nil
+	}
+	return &previewFetcher{
+		client:   newPublicClient(previewTimeout),
+		previews: make(map[string]cachedPreview),
+	}
+}
+
+// Preview returns location's metadata, fetching it at most once per TTL.
+func (f *previewFetcher) Preview(ctx context.Context, location string) (*redirect.Preview, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("not an http URL: %q", location)
+	}
+	u.Fragment = ""
+	key := u.String()
+
+	f.mu.Lock()
+	c, ok := f.previews[key]
+	f.mu.Unlock()
+	if ok && f.clock().Before(c.expires) {
+		return c.preview, c.err
+	}
+	p, err := f.fetch(ctx, key)
+	ttl := previewTTL
+	if err != nil {
+		log.Printf("link preview of %s: %v", key, err)
+		ttl = previewFailureTTL
+	}
+	f.mu.Lock()
+	if len(f.previews) >= maxPreviews {
+		clear(f.previews)
+	}
+	f.previews[key] = cachedPreview{preview: p, err: err, expires: f.clock().Add(ttl)}
+	f.mu.Unlock()
+	return p, err
+}
+
+func (f *previewFetcher) fetch(ctx context.Context, target string) (*redirect.Preview, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), previewTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "redirect.name link preview (+https://redirect.name)")
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, fmt.Errorf("content type %s", ct)
+	}
+	p := parsePreview(io.LimitReader(resp.Body, maxPreviewBody))
+	if p.Image != "" {
+		if img, err := resp.Request.URL.Parse(p.Image); err == nil {
+			p.Image = img.String()
+		}
+	}
+	return p, nil
+}
+
+// parsePreview reads the metadata in an HTML document's head. OpenGraph
+// properties take precedence over Twitter card ones, which take
+// precedence over the title element and description meta tag.
+func parsePreview(r io.Reader) *redirect.Preview {
+	meta := make(map[string]string)
+	var title string
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return previewFrom(meta, title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return previewFrom(meta, title)
+			case "title":
+				if z.Next() == html.TextToken && title == "" {
+					title = strings.TrimSpace(string(z.Text()))
+				}
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = strings.TrimSpace(string(v))
+					}
+				}
+				if _, ok := meta[key]; !ok && key != "" && content != "" {
+					meta[key] = content
+				}
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return previewFrom(meta, title)
+			}
+		}
+	}
+}
+
+func previewFrom(meta map[string]string, title string) *redirect.Preview {
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	p := &redirect.Preview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		Image:       first("og:image", "og:image:url", "twitter:image", "twitter:image:src"),
+		SiteName:    first("og:site_name"),
+	}
+	if p.Title == "" {
+		p.Title = title
+	}
+	return p
+}
+
+func (f *previewFetcher) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPreviewFetcher(t *testing.T) {
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/docs":
+			fmt.Fprint(w, `<!doctype html><html><head>
+<title>Docs | Example</title>
+<meta name="description" content="Plain description">
+<meta property="og:description" content="  OG description ">
+<meta property="og:site_name" content="Example">
+<meta name="twitter:image" content="/img/card.png">
+</head><body><meta property="og:title" content="not in head"></body></html>`)
+		case "/data":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	now := time.Unix(1700000000, 0)
+	f := &previewFetcher{client: ts.Client(), previews: make(map[string]cachedPreview), now: func() time.Time { return now }}
+
+	p, err := f.Preview(context.Background(), ts.URL+"/docs#intro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Docs | Example" || p.Description != "OG description" || p.SiteName != "Example" || p.Image != ts.URL+"/img/card.png" {
+		t.Errorf("got %+v", p)
+	}
+	if _, err := f.Preview(context.Background(), ts.URL+"/docs"); err != nil || fetches != 1 {
+		t.Errorf("want a cached preview, got %v after %d fetches", err, fetches)
+	}
+	now = now.Add(previewTTL)
+	f.Preview(context.Background(), ts.URL+"/docs")
+	if fetches != 2 {
+		t.Errorf("want a refetch after the TTL, got %d fetches", fetches)
+	}
+
+	for _, path := range []string{"/data", "/missing"} {
+		if _, err := f.Preview(context.Background(), ts.URL+path); err == nil {
+			t.Errorf("%s: want an error", path)
+		}
+	}
+	if _, err := f.Preview(context.Background(), "ftp://example.com/"); err == nil {
+		t.Error("ftp: want an error")
+	}
+}
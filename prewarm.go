@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prewarmConcurrency is how many certificates are warmed at once, and
+// prewarmReadyWait how long readiness waits for the first round: past it,
+// the process serves while the rest are ordered.
+const (
+	prewarmConcurrency = 8
+	prewarmReadyWait   = time.Minute
+)
+
+// certPrewarm obtains certificates for cert_prewarm and cert_prewarm_file's
+// hosts before anyone visits them. That's done at startup and again every
+// cert_prewarm_every, picking up changes to the file. autocert then keeps
+// each one it has loaded renewed. Hosts policy refuses are logged and
+// skipped.
+type certPrewarm struct {
+	hosts []string
+	file  string
+	// get answers a handshake through autocert and everything wrapping
+	// it.
+	get func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	mu       sync.Mutex
+	fromFile []string // the file's hosts when last read
+}
+
+// newCertPrewarm returns the pre-warming cfg asks for, with the file read
+// once, or nil if there is none.
+func newCertPrewarm(cfg *config, get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*certPrewarm, error) {
+	if cfg.CertPrewarm == "" && cfg.CertPrewarmFile == "" {
+		return nil, nil
+	}
+	p := &certPrewarm{hosts: cfg.certPrewarm(), file: cfg.CertPrewarmFile, get: get}
+	if err := p.loadFile(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// loadFile rereads the file, keeping the hosts last read if it can't be.
+func (p *certPrewarm) loadFile() error {
+	if p.file == "" {
+		return nil
+	}
+	f, err := os.Open(p.file)
+	if err != nil {
+		return fmt.Errorf("reading cert_prewarm_file: %w", err)
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if !validPrewarmHost(line) {
+			log.Printf("%s:%d: %q is not a host name; skipping it", p.file, n, line)
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", p.file, err)
+	}
+	p.mu.Lock()
+	p.fromFile = hosts
+	p.mu.Unlock()
+	return nil
+}
+
+// list returns the hosts to warm, each once.
+func (p *certPrewarm) list() []string {
+	p.mu.Lock()
+	hosts := append(slices.Clone(p.hosts), p.fromFile...)
+	p.mu.Unlock()
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
+
+// warm gets each host's certificate, prewarmConcurrency at a time,
+// ordering those not cached, and returns how many it got of how many
+// hosts.
+func (p *certPrewarm) warm() (warmed, hosts int) {
+	list := p.list()
+	var n atomic.Int64
+	slots := make(chan struct{}, prewarmConcurrency)
+	var wg sync.WaitGroup
+	for _, host := range list {
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			if _, err := p.get(renewalHello(host)); err != nil {
+				log.Printf("Pre-warming certificate for %s: %v", host, err)
+				return
+			}
+			n.Add(1)
+		})
+	}
+	wg.Wait()
+	return int(n.Load()), len(list)
+}
+
+// run warms the hosts now and then every interval until ctx is done,
+// calling warmed once the first round is, or once it's taken
+// prewarmReadyWait.
+func (p *certPrewarm) run(ctx context.Context, interval time.Duration, warmed func()) {
+	var once sync.Once
+	if warmed != nil {
+		timer := time.AfterFunc(prewarmReadyWait, func() {
+			once.Do(func() {
+				log.Printf("Pre-warming certificates is taking over %v; serving meanwhile", prewarmReadyWait)
+				warmed()
+			})
+		})
+		defer timer.Stop()
+	}
+	for {
+		n, hosts := p.warm()
+		log.Printf("Pre-warmed certificates for %d of %d hosts", n, hosts)
+		if warmed != nil {
+			once.Do(warmed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := p.loadFile(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// validPrewarmHost reports whether h is a host name a certificate can be
+// ordered for on demand: not a wildcard, which only DNS-01 can cover.
+func validPrewarmHost(h string) bool {
+	return !strings.Contains(h, "*") && validDNSDomain(h)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCertPrewarm(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts")
+	os.WriteFile(file, []byte("# customers\ndocs.example.com\nGO.example.com  # again\n*.example.com\n\n"), 0o644)
+	cfg := defaultConfig()
+	cfg.CertPrewarm = "go.example.com, refused.example.com"
+	cfg.CertPrewarmFile = file
+
+	var mu sync.Mutex
+	var asked []string
+	p, err := newCertPrewarm(cfg, func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		asked = append(asked, hello.ServerName)
+		mu.Unlock()
+		if hello.ServerName == "refused.example.com" {
+			return nil, errors.New("not served")
+		}
+		return &tls.Certificate{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmed, hosts := p.warm(); warmed != 2 || hosts != 3 {
+		t.Errorf("warmed %d of %d", warmed, hosts)
+	}
+	slices.Sort(asked)
+	if want := []string{"docs.example.com", "go.example.com", "refused.example.com"}; !slices.Equal(asked, want) {
+		t.Errorf("asked for %q, want %q", asked, want)
+	}
+
+	// A file that goes missing keeps the hosts last read.
+	os.Remove(file)
+	if err := p.loadFile(); err == nil {
+		t.Error("missing file: no error")
+	}
+	if hosts := p.list(); len(hosts) != 3 {
+		t.Errorf("after a failed reload: %q", hosts)
+	}
+
+	cfg.CertPrewarm = ""
+	if _, err := newCertPrewarm(cfg, nil); err == nil {
+		t.Error("missing file at startup: no error")
+	}
+}
+
+func TestCertPrewarmConcurrent(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CertPrewarm = "a.example.com, b.example.com, c.example.com"
+	started, release := make(chan string, 3), make(chan struct{})
+	p, err := newCertPrewarm(cfg, func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		started <- hello.ServerName
+		<-release
+		return &tls.Certificate{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		warmed, _ := p.warm()
+		done <- warmed
+	}()
+	for range 3 {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("want the hosts ordered at once, not one after another")
+		}
+	}
+	close(release)
+	if warmed := <-done; warmed != 3 {
+		t.Errorf("warmed %d of 3", warmed)
+	}
+}
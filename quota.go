@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/ratelimit"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// hostQuota throttles hostnames that get more requests than their quota
+// and suspends those that keep at it, such as a free redirect domain being
+// used in a spam campaign.
+type hostQuota struct {
+	limiter      *ratelimit.Limiter
+	suspendAfter int
+	suspendFor   time.Duration
+
+	mu        sync.Mutex
+	strikes   map[string]*strikes
+	suspended map[string]suspension
+	now       func() time.Time
+}
+
+// strikes counts a host's throttled requests within the current minute.
+type strikes struct {
+	count int
+	since time.Time
+}
+
+type suspension struct {
+	Host      string    `json:"host"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Throttled int       `json:"throttled"`
+}
+
+// newHostQuota returns the quota configured by cfg, or nil if host_rate_limit
+// is unset.
+func newHostQuota(cfg *config) *hostQuota {
+	if cfg.HostRateLimit == 0 {
+		return nil
+	}
+	return &hostQuota{
+		limiter:      ratelimit.New(cfg.HostRateLimit, cfg.HostRateLimitBurst),
+		suspendAfter: cfg.HostSuspendAfter,
+		suspendFor:   cfg.HostSuspendFor,
+		strikes:      make(map[string]*strikes),
+		suspended:    make(map[string]suspension),
+	}
+}
+
+// check reports whether a request for host may proceed and, if not, how
+// long the client should wait and why.
+func (q *hostQuota) check(host string) (bool, time.Duration, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock()
+	if s, ok := q.suspended[host]; ok {
+		if now.Before(s.Until) {
+			return false, s.Until.Sub(now), "suspended"
+		}
+		delete(q.suspended, host)
+	}
+	ok, wait := q.limiter.AllowAt(host, now)
+	if ok {
+		return true, 0, ""
+	}
+	if q.suspendAfter > 0 {
+		st := q.strikes[host]
+		if st == nil || now.Sub(st.since) >= time.Minute {
+			st = &strikes{since: now}
+			q.strikes[host] = st
+		}
+		st.count++
+		if st.count >= q.suspendAfter {
+			delete(q.strikes, host)
+			q.suspended[host] = suspension{Host: host, Since: now, Until: now.Add(q.suspendFor), Throttled: st.count}
+			log.Printf("Suspended %s for %v after %d throttled requests in a minute", host, q.suspendFor, st.count)
+			return false, q.suspendFor, "suspended"
+		}
+	}
+	return false, wait, "throttled"
+}
+
+// suspensions returns the hosts currently suspended, by host name.
+func (q *hostQuota) suspensions() []suspension {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock()
+	list := []suspension{}
+	for host, s := range q.suspended {
+		if !now.Before(s.Until) {
+			delete(q.suspended, host)
+			continue
+		}
+		list = append(list, s)
+	}
+	slices.SortFunc(list, func(a, b suspension) int { return strings.Compare(a.Host, b.Host) })
+	return list
+}
+
+// lift ends host's suspension, reporting whether it was suspended.
+func (q *hostQuota) lift(host string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.suspended[host]
+	delete(q.suspended, host)
+	delete(q.strikes, host)
+	return ok
+}
+
+func (q *hostQuota) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// limitHosts refuses requests for hosts that are over quota or suspended.
+func limitHosts(q *hostQuota, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host, err := redirect.ParseHost(r.Host); err == nil && !isHealthCheck(r) {
+			if ok, wait, reason := q.check(host); !ok {
+				rateLimited.Inc("host_" + reason)
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSuspensions lists suspended hosts as JSON.
+func (q *hostQuota) handleSuspensions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.suspensions())
+}
+
+// handleLift lifts the suspension of the host named in the path.
+func (q *hostQuota) handleLift(w http.ResponseWriter, r *http.Request) {
+	host, err := redirect.ParseHost(r.PathValue("host"))
+	if err != nil {
+		http.Error(w, "host must be a hostname", http.StatusBadRequest)
+		return
+	}
+	if !q.lift(host) {
+		http.Error(w, fmt.Sprintf("%s is not suspended", host), http.StatusNotFound)
+		return
+	}
+	log.Printf("Lifted suspension of %s", host)
+	w.WriteHeader(http.StatusNoContent)
+}
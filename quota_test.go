@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostQuota(t *testing.T) {
+	stubTXT(t, []string{"Redirects to https://example.com/"}, nil)
+	cfg := defaultConfig()
+	cfg.HostRateLimit = 1
+	cfg.HostRateLimitBurst = 1
+	cfg.HostSuspendAfter = 3
+	cfg.HostSuspendFor = time.Hour
+	cfg.AdminToken = "secret"
+	quota := newHostQuota(cfg)
+	now := time.Unix(1000, 0)
+	quota.now = func() time.Time { return now }
+	h := newMux(cfg, quota)
+	admin := newAdminMux(cfg, quota)
+
+	get := func(host string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	adminDo := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if code := get("spam.example.com"); code != http.StatusFound {
+		t.Fatalf("first request: want 302, got %d", code)
+	}
+	if code := get("other.example.com"); code != http.StatusFound {
+		t.Fatalf("other host: want 302, got %d", code)
+	}
+	for range 3 {
+		if code := get("spam.example.com:443"); code != http.StatusTooManyRequests {
+			t.Fatalf("over quota: want 429, got %d", code)
+		}
+	}
+
+	// Suspended: refills don't help until the suspension ends or is lifted.
+	now = now.Add(time.Minute)
+	if code := get("spam.example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("suspended host: want 429, got %d", code)
+	}
+	if rr := adminDo("GET", "/suspensions", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("admin API without token: want 401, got %d", rr.Code)
+	}
+	rr := adminDo("GET", "/suspensions", "secret")
+	var list []suspension
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Host != "spam.example.com" {
+		t.Fatalf("suspensions: got %s (%v)", rr.Body, err)
+	}
+
+	if rr := adminDo("DELETE", "/suspensions/spam.example.com", "secret"); rr.Code != http.StatusNoContent {
+		t.Errorf("lift: want 204, got %d", rr.Code)
+	}
+	if rr := adminDo("DELETE", "/suspensions/spam.example.com", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("lift again: want 404, got %d", rr.Code)
+	}
+	if code := get("spam.example.com"); code != http.StatusFound {
+		t.Errorf("after lifting: want 302, got %d", code)
+	}
+
+	// Suspensions of internationalized hosts are lifted by either form.
+	for range 4 {
+		get("bücher.example")
+	}
+	if rr := adminDo("DELETE", "/suspensions/b%C3%BCcher.example", "secret"); rr.Code != http.StatusNoContent {
+		t.Errorf("lift bücher.example: want 204, got %d", rr.Code)
+	}
+}
+
+func TestHostQuotaFlood(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.HostRateLimit = 0.01
+	cfg.HostRateLimitBurst = 1
+	quota := newHostQuota(cfg)
+	quota.limiter.MaxKeys = 10
+	now := time.Unix(1000, 0)
+	quota.now = func() time.Time { return now }
+
+	quota.check("spam.example.com")
+	for i := range 100 {
+		now = now.Add(time.Millisecond)
+		quota.check(fmt.Sprintf("%d.example.net", i))
+		if ok, _, _ := quota.check("spam.example.com"); ok {
+			t.Fatalf("after %d other hosts: spam.example.com's quota was reset", i+1)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/ratelimit"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+var rateLimited = registry.Counter("redirect_rate_limited_total",
+	"Requests refused with 429, by the limit that was hit.", "limit")
+
+// limitClients refuses requests from client IPs that exceed l, sharing a
+// bucket across each IPv6 /64 so a client can't rotate through its own.
+// Health checks are exempt so a load balancer sharing an address with
+// clients isn't locked out.
+func limitClients(l *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isHealthCheck(r) {
+			if ok, wait := l.Allow(clientKey(redirect.ClientIP(r))); !ok {
+				rateLimited.Inc("client_ip")
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey returns the key ip is limited by: itself, or its /64 if it's an
+// IPv6 address.
+func clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Unmap().Is6() {
+		return ip
+	}
+	p, _ := addr.Prefix(64)
+	return p.String()
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
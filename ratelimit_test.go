@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	stubTXT(t, []string{"Redirects to https://example.com/"}, nil)
+	cfg := defaultConfig()
+	cfg.RateLimit = 1
+	cfg.RateLimitBurst = 2
+	cfg.TrustedProxies = "10.0.0.1"
+	h := newMux(cfg, nil)
+
+	get := func(peer, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "go.example.com"
+		req.RemoteAddr = peer
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := range 2 {
+		if rr := get("192.0.2.1:1234", ""); rr.Code != http.StatusFound {
+			t.Fatalf("request %d: want 302, got %d", i+1, rr.Code)
+		}
+	}
+	rr := get("192.0.2.1:1234", "")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("over the limit: want 429 with Retry-After 1, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := get("192.0.2.2:1234", ""); rr.Code != http.StatusFound {
+		t.Errorf("other clients should be unaffected, got %d", rr.Code)
+	}
+
+	// IPv6 clients share a bucket with the rest of their /64.
+	get("[2001:db8:1:2::1]:1234", "")
+	get("[2001:db8:1:2::2]:1234", "")
+	if rr := get("[2001:db8:1:2::3]:1234", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("another address in the same /64: want 429, got %d", rr.Code)
+	}
+	if rr := get("[2001:db8:1:3::1]:1234", ""); rr.Code != http.StatusFound {
+		t.Errorf("another /64 should be unaffected, got %d", rr.Code)
+	}
+
+	// Behind a trusted proxy the forwarded client is limited, not the proxy.
+	get("10.0.0.1:1234", "192.0.2.3")
+	get("10.0.0.1:1234", "192.0.2.3")
+	if rr := get("10.0.0.1:1234", "192.0.2.3"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("forwarded client over the limit: want 429, got %d", rr.Code)
+	}
+	if rr := get("10.0.0.1:1234", "192.0.2.4"); rr.Code != http.StatusFound {
+		t.Errorf("another forwarded client: want 302, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/sync/singleflight"
+)
+
+// readyProbeHost is looked up to check that DNS sources are reachable. Any
+// answer, including "not found", counts as reachable.
+const readyProbeHost = "example.com"
+
+// readyzCacheTTL is how long /readyz reuses its last look at the
+// dependencies, so that frequent checks, on the public listener too, don't
+// each cost a lookup and a file in cert_dir.
+var readyzCacheTTL = 5 * time.Second
+
+// isHealthCheck reports whether r is for /healthz or /readyz, which are
+// exempt from host filtering and rate limits so orchestrators can always
+// reach them.
+func isHealthCheck(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+}
+
+// The phases of the process, for /readyz: it answers 503 but while
+// serving, so that load balancers send traffic only once it's warmed up
+// and stop before its listeners close. /healthz answers throughout.
+const (
+	phaseStarting = "starting"
+	phaseServing  = "serving"
+	phaseDraining = "draining"
+)
+
+// phase holds the process's phase; unset, it's serving.
+var phase atomic.Value
+
+func setPhase(p string) { phase.Store(p) }
+
+func currentPhase() string {
+	if p, ok := phase.Load().(string); ok {
+		return p
+	}
+	return phaseServing
+}
+
+// readiness is the /readyz report.
+type readiness struct {
+	OK      bool           `json:"ok"`
+	Phase   string         `json:"phase"`
+	Sources []sourceStatus `json:"sources"`
+	CertDir *dirStatus     `json:"cert_dir,omitempty"`
+}
+
+type sourceStatus struct {
+	Name               string   `json:"name"`
+	Reachable          *bool    `json:"reachable,omitempty"`
+	LatencyMS          float64  `json:"latency_ms,omitempty"`
+	Error              string   `json:"error,omitempty"`
+	CacheEntries       *int     `json:"cache_entries,omitempty"`
+	LastSuccessSeconds *float64 `json:"last_success_seconds,omitempty"`
+}
+
+type dirStatus struct {
+	Writable bool   `json:"writable"`
+	Error    string `json:"error,omitempty"`
+}
+
+// readyzHandler reports, as JSON, the process's phase, whether the DNS
+// source answers a probe lookup, how full its cache is, when each source
+// last answered and whether cert_dir is writable, as of at most
+// readyzCacheTTL ago. It answers 503 while the process is starting or
+// draining, and otherwise 200 unless ?strict is given, when it answers 503
+// if a dependency is down.
+func readyzHandler(cfg *config) http.HandlerFunc {
+	var (
+		flight  singleflight.Group
+		mu      sync.Mutex
+		last    readiness
+		checked time.Time
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		report, fresh := last, time.Since(checked) < readyzCacheTTL
+		mu.Unlock()
+		if !fresh {
+			v, _, _ := flight.Do("", func() (any, error) {
+				report := checkDependencies(cfg)
+				mu.Lock()
+				last, checked = report, time.Now()
+				mu.Unlock()
+				return report, nil
+			})
+			report = v.(readiness)
+		}
+		report.Phase = currentPhase()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Phase != phaseServing || !report.OK && r.URL.Query().Has("strict") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// checkDependencies returns the /readyz report of the sources and
+// cert_dir, without the phase.
+func checkDependencies(cfg *config) readiness {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	report := readiness{OK: true, Sources: []sourceStatus{}}
+
+	if layers, ok := resolver.(*redirect.Layers); ok {
+		stats := layers.Stats()
+		for i, source := range layers.Sources() {
+			status := sourceStatus{Name: source.Name}
+			if t := stats[i].LastSuccess; !t.IsZero() {
+				ago := time.Since(t).Seconds()
+				status.LastSuccessSeconds = &ago
+			}
+			probe := source.Resolver
+			if cache, ok := probe.(*redirect.Cache); ok {
+				n := cache.Len()
+				status.CacheEntries = &n
+				probe = cache.Resolver
+			}
+			if source.Name == "dns" {
+				report.OK = probeSource(ctx, probe, &status) && report.OK
+			}
+			report.Sources = append(report.Sources, status)
+		}
+	} else {
+		status := sourceStatus{Name: "resolver"}
+		report.OK = probeSource(ctx, resolver, &status)
+		report.Sources = append(report.Sources, status)
+	}
+
+	if cfg.CertDir != "" && cfg.CertCache == "" {
+		report.CertDir = &dirStatus{Writable: true}
+		if err := checkWritable(cfg.CertDir); err != nil {
+			report.CertDir = &dirStatus{Error: err.Error()}
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// probeSource looks readyProbeHost up with r, recording the outcome in
+// status, and reports whether r was reachable.
+func probeSource(ctx context.Context, r redirect.Resolver, status *sourceStatus) bool {
+	start := time.Now()
+	_, err := r.LookupConfig(ctx, readyProbeHost)
+	status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	reachable := err == nil || errors.Is(err, redirect.ErrNotFound)
+	status.Reachable = &reachable
+	if !reachable {
+		status.Error = err.Error()
+	}
+	return reachable
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
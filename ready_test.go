@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestReadyz(t *testing.T) {
+	var dnsErr error
+	var probes int
+	dns := redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		probes++
+		if dnsErr != nil {
+			return nil, dnsErr
+		}
+		return nil, redirect.ErrNotFound
+	})
+	orig, origTTL := resolver, readyzCacheTTL
+	t.Cleanup(func() { resolver, readyzCacheTTL = orig, origTTL })
+	resolver = redirect.NewLayers(
+		redirect.Source{Name: "env", Resolver: redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}},
+		redirect.Source{Name: "dns", Resolver: redirect.NewCache(dns, 0)},
+	)
+	cfg := defaultConfig()
+	cfg.CertDir = t.TempDir()
+	cfg.RateLimit = 1
+	cfg.RateLimitBurst = 1
+	h := newMux(cfg, nil)
+
+	get := func(target string) (int, readiness) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		var report readiness
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v\n%s", target, err, rr.Body)
+		}
+		return rr.Code, report
+	}
+
+	resolver.LookupConfig(context.Background(), "go.example.com")
+	code, report := get("/readyz?strict")
+	if code != http.StatusOK || !report.OK || len(report.Sources) != 2 || !report.CertDir.Writable {
+		t.Fatalf("healthy: got %d %+v", code, report)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(cfg.CertDir, ".readyz-*")); len(matches) != 0 {
+		t.Errorf("probe files left behind: %v", matches)
+	}
+	env, dnsStatus := report.Sources[0], report.Sources[1]
+	if env.LastSuccessSeconds == nil || env.Reachable != nil {
+		t.Errorf("env source: want a last success and no probe, got %+v", env)
+	}
+	if dnsStatus.Reachable == nil || !*dnsStatus.Reachable || dnsStatus.CacheEntries == nil {
+		t.Errorf("dns source: want a reachable probe and cache size, got %+v", dnsStatus)
+	}
+
+	// Many checks in a row are not rate limited, and reuse the last look
+	// at the dependencies until it's readyzCacheTTL old.
+	dnsErr = errors.New("connection refused")
+	os.RemoveAll(cfg.CertDir)
+	probes = 0
+	if code, report = get("/readyz?strict"); code != http.StatusOK || !report.OK || probes != 0 {
+		t.Errorf("within readyzCacheTTL: want the last report, got %d %+v after %d probes", code, report, probes)
+	}
+	readyzCacheTTL = 0
+	code, report = get("/readyz")
+	if code != http.StatusOK || report.OK {
+		t.Errorf("lenient mode: want 200 reporting not ok, got %d %+v", code, report)
+	}
+	code, report = get("/readyz?strict")
+	if code != http.StatusServiceUnavailable || report.Sources[1].Error == "" || report.CertDir.Writable {
+		t.Errorf("strict mode: want 503 with errors, got %d %+v", code, report)
+	}
+}
+
+func TestReadyzPhase(t *testing.T) {
+	t.Cleanup(func() { setPhase(phaseServing) })
+	stubTXT(t, nil, redirect.ErrNotFound)
+	h := newMux(defaultConfig(), nil)
+	get := func(path string) (int, string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		var report readiness
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		return rr.Code, report.Phase
+	}
+	for _, p := range []string{phaseStarting, phaseServing, phaseDraining} {
+		setPhase(p)
+		want := http.StatusServiceUnavailable
+		if p == phaseServing {
+			want = http.StatusOK
+		}
+		if code, got := get("/readyz"); code != want || got != p {
+			t.Errorf("%s: want %d reporting it, got %d reporting %q", p, want, code, got)
+		}
+		if code, _ := get("/healthz"); code != http.StatusOK {
+			t.Errorf("%s: /healthz answered %d", p, code)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package redirect
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/frolic/redirect.name/internal/ratelimit"
+)
+
+var protectedRE = regexp.MustCompile(`\s+protected\s+by\s+(\S+)`)
+
+// maxBcryptCost bounds the cost of "protected by" hashes, since each
+// check of a password costs the server that much work.
+const maxBcryptCost = 12
+
+// validCredentials reports whether protected, "user:bcrypt-hash", has a
+// hash bcrypt can check at a cost of at most maxBcryptCost.
+func validCredentials(protected string) bool {
+	user, hash, ok := strings.Cut(protected, ":")
+	if !ok || user == "" {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost <= maxBcryptCost
+}
+
+// verifiedTTL is how long a user and password checked against a hash are
+// remembered, so each request of a session doesn't pay for bcrypt.
+const verifiedTTL = 10 * time.Minute
+
+// maxVerified bounds the remembered credentials.
+const maxVerified = 10000
+
+// maxAttempts passwords may be checked against each "protected by" hash
+// at once, and attemptsPerSecond after that; others are refused unchecked,
+// so spraying wrong passwords can't keep the server busy with bcrypt.
+const (
+	maxAttempts       = 10
+	attemptsPerSecond = 1
+)
+
+// bcryptSlots bounds the bcrypt checks run at once, across every hash, to
+// half the CPUs.
+var bcryptSlots = make(chan struct{}, max(1, runtime.GOMAXPROCS(0)/2))
+
+// credentials remembers which passwords matched which "protected by"
+// hashes, by a digest of the three, and limits the attempts on each hash.
+type credentials struct {
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]time.Time
+	attempts *ratelimit.Limiter
+}
+
+// check reports whether r's Basic credentials match protected.
+func (c *credentials) check(r *http.Request, protected string) bool {
+	wantUser, hash, _ := strings.Cut(protected, ":")
+	user, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) != 1 {
+		return false
+	}
+	key := sha256.Sum256([]byte(protected + "\x00" + password))
+	now := time.Now()
+	c.mu.Lock()
+	expires, ok := c.verified[key]
+	c.mu.Unlock()
+	if ok && now.Before(expires) {
+		return true
+	}
+	if !c.allow(protected) {
+		return false
+	}
+	select {
+	case bcryptSlots <- struct{}{}:
+	case <-r.Context().Done():
+		return false
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	<-bcryptSlots
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	if c.verified == nil || len(c.verified) >= maxVerified {
+		c.verified = make(map[[sha256.Size]byte]time.Time)
+	}
+	c.verified[key] = now.Add(verifiedTTL)
+	c.mu.Unlock()
+	return true
+}
+
+// allow takes one of protected's attempts, reporting whether any were left.
+func (c *credentials) allow(protected string) bool {
+	c.mu.Lock()
+	if c.attempts == nil {
+		c.attempts = ratelimit.New(attemptsPerSecond, maxAttempts)
+	}
+	attempts := c.attempts
+	c.mu.Unlock()
+	ok, _ := attempts.Allow(protected)
+	return ok
+}
+
+// challenge asks for Basic credentials for a "protected by" rule.
+func (h *handler) challenge(w http.ResponseWriter, r *http.Request, host string) {
+	w.Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(host)+`, charset="UTF-8"`)
+	h.pages.locked(w, r, host, http.StatusUnauthorized, "this link needs a username and password")
+}
@@ -0,0 +1,99 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParseProtected(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := Parse("Redirects from /team/* to https://wiki.example.com/* protected by alice:" + string(hash) + " permanently")
+	if rule == nil || rule.Protected != "alice:"+string(hash) || rule.From != "/team/*" || rule.To != "https://wiki.example.com/*" || rule.RedirectState != "permanently" {
+		t.Fatalf("got %+v", rule)
+	}
+	if s := rule.String(); strings.Contains(s, string(hash)) || !strings.Contains(s, "protected by alice:REDACTED") {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, record := range []string{
+		"Redirects to https://example.com/ protected by alice:$2a$04$notahash",
+		"Redirects to https://example.com/ protected by :" + string(hash),
+		"Redirects to https://example.com/ protected by alice:$2a$31$" + string(hash[7:]),
+	} {
+		if rule := Parse(record); rule != nil {
+			t.Errorf("Parse(%q) = %+v, want nil", record, rule)
+		}
+	}
+}
+
+func TestHandlerProtected(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {"Redirects from /team/* to https://wiki.example.com/* protected by alice:" + string(hash), "Redirects to https://example.org/"},
+	}))
+	get := func(user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/team/plans", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, c := range []struct{ user, password string }{{"", ""}, {"alice", "wrong"}, {"bob", "hunter2"}} {
+		rr := get(c.user, c.password)
+		assertEqual(t, rr.Code, 401)
+		assertEqual(t, rr.Header().Get("WWW-Authenticate"), `Basic realm="example.com", charset="UTF-8"`)
+		if strings.Contains(rr.Body.String(), "wiki.example.com") {
+			t.Errorf("%s: the locked page gives the destination away", c.user)
+		}
+	}
+
+	for range 2 {
+		rr := get("alice", "hunter2")
+		assertEqual(t, rr.Code, 302)
+		assertEqual(t, rr.Header().Get("Location"), "https://wiki.example.com/plans")
+		assertEqual(t, rr.Header().Get("Cache-Control"), "private, no-store")
+	}
+}
+
+func TestCredentialsAttempts(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := "alice:" + string(hash)
+	var c credentials
+	check := func(password string) bool {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("alice", password)
+		return c.check(req, protected)
+	}
+
+	if !check("hunter2") {
+		t.Fatal("the right password was refused")
+	}
+	for range maxAttempts {
+		check("wrong")
+	}
+	if check("hunter3") {
+		t.Error("a wrong password was accepted")
+	}
+	if !check("hunter2") {
+		t.Error("a password already verified should skip the attempt limit")
+	}
+	c.verified = nil
+	if check("hunter2") {
+		t.Error("past maxAttempts, want passwords refused unchecked")
+	}
+}
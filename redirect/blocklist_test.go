@@ -0,0 +1,52 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	entries, err := ReadBlocklist(strings.NewReader(`
+# phishing feed
+bad.example
+0.0.0.0 tracker.example ads.example
+https://files.example/malware/
+cdn.example/phish
+not a valid line
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b Blocklist
+	b.Set(entries)
+	if n := b.Len(); n != 5 {
+		t.Errorf("want 5 entries, got %d (%v)", n, entries)
+	}
+
+	ctx := context.Background()
+	for _, blocked := range []string{
+		"https://bad.example/",
+		"http://login.BAD.example./account",
+		"https://ads.example",
+		"http://files.example/malware/payload.exe",
+		"https://cdn.example/phish/login",
+	} {
+		var be *BlockedError
+		if err := b.CheckTarget(ctx, blocked); !errors.As(err, &be) || be.Status != http.StatusGone {
+			t.Errorf("%s: want a 410 BlockedError, got %v", blocked, err)
+		}
+	}
+	for _, allowed := range []string{
+		"https://notbad.example/",
+		"https://files.example/docs/",
+		"/relative",
+		"mailto:someone@bad.example",
+	} {
+		if err := b.CheckTarget(ctx, allowed); err != nil {
+			t.Errorf("%s: want allowed, got %v", allowed, err)
+		}
+	}
+}
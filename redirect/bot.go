@@ -0,0 +1,41 @@
+package redirect
+
+import (
+	"net/http"
+	"strings"
+)
+
+// botMarkers are lowercase substrings of the User-Agent of crawlers, link
+// previewers, monitors and HTTP libraries, which browsers don't send.
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "scrapy", "archiver",
+	"facebookexternalhit", "facebookcatalog", "whatsapp", "embedly", "preview",
+	"mediapartners-google", "feedfetcher", "google-inspectiontool",
+	"lighthouse", "pingdom", "uptime", "monitor", "headlesschrome", "phantomjs",
+	"curl/", "wget/", "httpie/", "libwww", "python-", "go-http-client",
+	"okhttp", "java/", "axios/", "node-fetch", "http_request", "aiohttp",
+}
+
+// IsBotUserAgent reports whether ua looks like a crawler, link previewer,
+// monitor or script rather than a browser. An empty ua is not classified
+// as a bot.
+func IsBotUserAgent(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, m := range botMarkers {
+		if strings.Contains(ua, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithBotClassifier sets how the handler decides a request comes from a
+// bot, for "Redirects bots ..." rules and LookupInfo.Bot. The default
+// classifies by User-Agent with IsBotUserAgent.
+func WithBotClassifier(isBot func(r *http.Request) bool) Option {
+	return func(h *handler) { h.isBot = isBot }
+}
+
+func isBotRequest(r *http.Request) bool {
+	return IsBotUserAgent(r.UserAgent())
+}
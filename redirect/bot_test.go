@@ -0,0 +1,82 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsBotUserAgent(t *testing.T) {
+	for ua, want := range map[string]bool{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":  true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": true,
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                true,
+		"curl/8.5.0":             true,
+		"python-requests/2.31.0": true,
+		"Go-http-client/2.0":     true,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36":                             false,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1": false,
+		"": false,
+	} {
+		if got := IsBotUserAgent(ua); got != want {
+			t.Errorf("%q: got %v, want %v", ua, got, want)
+		}
+	}
+}
+
+func TestMatchBot(t *testing.T) {
+	rules := ParseAll([]string{
+		"Redirects from /docs/* to https://docs.example.com/*",
+		"Redirects bots to https://example.com/crawlers",
+		"Redirects bots from /feed to https://example.com/feed.xml",
+		"Redirects to https://example.com/",
+	})
+	assertEqual(t, rules[1].Bots, true)
+	assertEqual(t, rules[1].String(), "Redirects bots to https://example.com/crawlers")
+	assertEqual(t, rules[2].From, "/feed")
+
+	for _, c := range []struct {
+		match     func([]*Rule, string) (*Redirect, error)
+		url, want string
+	}{
+		{Match, "/docs/a", "https://docs.example.com/a"},
+		{Match, "/feed", "https://example.com/"},
+		{MatchBot, "/docs/a", "https://example.com/crawlers"},
+		{MatchBot, "/feed", "https://example.com/feed.xml"},
+	} {
+		r, err := c.match(rules, c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, r.Location, c.want)
+	}
+
+	// Bots fall back to the other rules when no bot rule matches.
+	r, err := MatchBot(rules[2:], "/other")
+	assertEqual(t, err, nil)
+	assertEqual(t, r.Location, "https://example.com/")
+}
+
+func TestHandlerBots(t *testing.T) {
+	resolver := StaticResolver{"go.example.com": {"Redirects bots to https://example.com/crawlers", "Redirects to https://example.com/"}}
+	get := func(h http.Handler, ua string) (string, *LookupInfo) {
+		req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+		req.Header.Set("User-Agent", ua)
+		info := new(LookupInfo)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req.WithContext(WithLookupInfo(req.Context(), info)))
+		return rr.Header().Get("Location"), info
+	}
+
+	h := NewHandler(WithResolver(resolver))
+	loc, info := get(h, "Twitterbot/1.0")
+	assertEqual(t, loc, "https://example.com/crawlers")
+	assertEqual(t, info.Bot, true)
+	loc, info = get(h, "Mozilla/5.0 Firefox/128.0")
+	assertEqual(t, loc, "https://example.com/")
+	assertEqual(t, info.Bot, false)
+
+	h = NewHandler(WithResolver(resolver), WithBotClassifier(func(r *http.Request) bool { return true }))
+	loc, _ = get(h, "Mozilla/5.0 Firefox/128.0")
+	assertEqual(t, loc, "https://example.com/crawlers")
+}
@@ -0,0 +1,176 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cache is a Resolver that remembers another Resolver's answers. Lookups
+// finding rules are kept for TTL; ErrNotFound answers, and records holding
+// no rules, for NegativeTTL. Other errors are never cached so transient
+// resolver failures are retried.
+type Cache struct {
+	Resolver    Resolver
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	// MaxEntries bounds the number of cached hosts. Zero means 10000.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	rules   []*Rule
+	err     error
+	expires time.Time
+}
+
+// NewCache returns a Cache in front of resolver that keeps answers for ttl,
+// and not-found answers for a tenth of that.
+func NewCache(resolver Resolver, ttl time.Duration) *Cache {
+	return &Cache{Resolver: resolver, TTL: ttl, NegativeTTL: ttl / 10}
+}
+
+func (c *Cache) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	now := c.clock()
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if info := LookupInfoFrom(ctx); info != nil {
+			info.Cached = true
+		}
+		return entry.rules, entry.err
+	}
+
+	rules, err := c.Resolver.LookupConfig(ctx, host)
+	switch {
+	case err == nil && len(rules) > 0 && c.TTL > 0:
+		c.store(host, cacheEntry{rules: rules, expires: now.Add(c.TTL)})
+	case err == nil && len(rules) == 0 && c.NegativeTTL > 0:
+		c.store(host, cacheEntry{rules: rules, expires: now.Add(c.NegativeTTL)})
+	case errors.Is(err, ErrNotFound) && c.NegativeTTL > 0:
+		c.store(host, cacheEntry{err: err, expires: now.Add(c.NegativeTTL)})
+	}
+	return rules, err
+}
+
+// Purge drops any cached answer for host.
+func (c *Cache) Purge(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host)
+}
+
+// A savedEntry is a cached answer as Save writes it.
+type savedEntry struct {
+	Host    string    `json:"host"`
+	Rules   []*Rule   `json:"rules"`
+	Expires time.Time `json:"expires"`
+}
+
+// Save writes the answers c holds that haven't expired, as JSON Lines, for
+// Load to warm the cache of the next process, which would otherwise look
+// every host up again at once. Errors, such as ErrNotFound, aren't saved.
+func (c *Cache) Save(w io.Writer) error {
+	now := c.clock()
+	c.mu.Lock()
+	saved := make([]savedEntry, 0, len(c.entries))
+	for host, entry := range c.entries {
+		if entry.err == nil && now.Before(entry.expires) {
+			saved = append(saved, savedEntry{Host: host, Rules: entry.rules, Expires: entry.expires})
+		}
+	}
+	c.mu.Unlock()
+	enc := json.NewEncoder(w)
+	for _, entry := range saved {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load adds the answers Save wrote to r that haven't expired since,
+// returning how many. They're kept for no longer than TTL, or NegativeTTL
+// for records holding no rules, from now.
+func (c *Cache) Load(r io.Reader) (int, error) {
+	now := c.clock()
+	dec := json.NewDecoder(r)
+	n := 0
+	for {
+		var entry savedEntry
+		if err := dec.Decode(&entry); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		ttl := c.TTL
+		if len(entry.Rules) == 0 {
+			ttl = c.NegativeTTL
+		}
+		expires := entry.Expires
+		if limit := now.Add(ttl); expires.After(limit) {
+			expires = limit
+		}
+		if entry.Host == "" || !now.Before(expires) {
+			continue
+		}
+		c.store(entry.Host, cacheEntry{rules: entry.Rules, expires: expires})
+		n++
+	}
+}
+
+// Len returns the number of cached hosts, including expired entries that
+// have not been evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) store(host string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	max := c.MaxEntries
+	if max <= 0 {
+		max = 10000
+	}
+	if _, ok := c.entries[host]; !ok && len(c.entries) >= max {
+		c.evict(max)
+	}
+	c.entries[host] = entry
+}
+
+// evict makes room for one more entry, dropping expired entries first and
+// then arbitrary ones. c.mu must be held.
+func (c *Cache) evict(max int) {
+	now := c.clock()
+	for host, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, host)
+		}
+	}
+	for host := range c.entries {
+		if len(c.entries) < max {
+			break
+		}
+		delete(c.entries, host)
+	}
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,127 @@
+package redirect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type countingResolver struct {
+	calls int
+	err   error
+	none  bool // answer with records holding no rules
+}
+
+func (c *countingResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.none {
+		return ParseAll([]string{"v=spf1 -all"}), nil
+	}
+	return ParseAll([]string{"Redirects to https://example.com/"}), nil
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	upstream := &countingResolver{}
+	cache := NewCache(upstream, time.Minute)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cache.LookupConfig(ctx, "go.example.com")
+	cache.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, upstream.calls, 1)
+
+	now = now.Add(2 * time.Minute)
+	cache.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, upstream.calls, 2)
+
+	cache.Purge("go.example.com")
+	cache.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, upstream.calls, 3)
+}
+
+func TestCacheErrors(t *testing.T) {
+	now := time.Unix(0, 0)
+	upstream := &countingResolver{err: errors.New("timeout")}
+	cache := NewCache(upstream, time.Minute)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Transient errors are not cached.
+	cache.LookupConfig(ctx, "go.example.com")
+	cache.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, upstream.calls, 2)
+
+	// Not-found answers are cached for NegativeTTL.
+	upstream.err = fmt.Errorf("%w: no such host", ErrNotFound)
+	cache.LookupConfig(ctx, "missing.example.com")
+	_, err := cache.LookupConfig(ctx, "missing.example.com")
+	assertEqual(t, upstream.calls, 3)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected cached ErrNotFound, got %v", err)
+	}
+	now = now.Add(cache.NegativeTTL)
+	cache.LookupConfig(ctx, "missing.example.com")
+	assertEqual(t, upstream.calls, 4)
+
+	// So are records that aren't redirect rules.
+	upstream.err, upstream.none = nil, true
+	cache.LookupConfig(ctx, "spf.example.com")
+	cache.LookupConfig(ctx, "spf.example.com")
+	assertEqual(t, upstream.calls, 5)
+	now = now.Add(cache.NegativeTTL)
+	cache.LookupConfig(ctx, "spf.example.com")
+	assertEqual(t, upstream.calls, 6)
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	cache := NewCache(&countingResolver{}, time.Minute)
+	cache.MaxEntries = 2
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		cache.LookupConfig(ctx, fmt.Sprintf("host%d.example.com", i))
+	}
+	if n := cache.Len(); n > 2 {
+		t.Errorf("expected at most 2 entries, got %d", n)
+	}
+}
+
+func TestCacheSave(t *testing.T) {
+	now := time.Unix(0, 0)
+	upstream := &countingResolver{}
+	cache := NewCache(upstream, time.Minute)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+	cache.LookupConfig(ctx, "go.example.com")
+	upstream.err = fmt.Errorf("%w for missing.example.com", ErrNotFound)
+	cache.LookupConfig(ctx, "missing.example.com")
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	loaded := NewCache(upstream, time.Minute)
+	loaded.now = func() time.Time { return now }
+	if n, err := loaded.Load(bytes.NewReader(buf.Bytes())); err != nil || n != 1 {
+		t.Fatalf("loaded %d answers, %v; want the one finding rules", n, err)
+	}
+	upstream.calls = 0
+	rules, err := loaded.LookupConfig(ctx, "go.example.com")
+	if err != nil || len(rules) != 1 || rules[0].To != "https://example.com/" || upstream.calls != 0 {
+		t.Errorf("want the saved rules without a lookup, got %v, %v after %d lookups", rules, err, upstream.calls)
+	}
+
+	now = now.Add(time.Minute)
+	late := NewCache(upstream, time.Minute)
+	late.now = func() time.Time { return now }
+	if n, _ := late.Load(bytes.NewReader(buf.Bytes())); n != 0 {
+		t.Errorf("loaded %d expired answers", n)
+	}
+}
@@ -0,0 +1,28 @@
+package redirect
+
+import (
+	"regexp"
+	"strings"
+)
+
+var canonicalRE = regexp.MustCompile(`^\s*Canonicali[sz]es\s+to\s+(\S+?)(?:\s+(permanently|temporarily)|\s+with\s+(30[1278]))?\s*$`)
+
+// parseCanonical parses "Canonicalizes to example.com" into a rule
+// redirecting every request to the same path and query on that host, as
+// from www.example.com to the apex or the reverse. It's permanent unless
+// the record ends "temporarily" or "with 302" and the like. It returns
+// nil for other records and invalid hosts.
+func parseCanonical(record string) *Rule {
+	m := canonicalRE.FindStringSubmatch(record)
+	if m == nil {
+		return nil
+	}
+	if _, err := ParseHost(m[1]); err != nil || strings.ContainsAny(m[1], "/?#@") {
+		return nil
+	}
+	state := m[2] + m[3]
+	if state == "" {
+		state = "permanently"
+	}
+	return &Rule{Canonical: strings.ToLower(m[1]), RedirectState: state}
+}
@@ -0,0 +1,46 @@
+package redirect
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCanonical(t *testing.T) {
+	for _, c := range []struct{ record, host, state, str string }{
+		{"Canonicalizes to example.com", "example.com", "permanently", "Canonicalizes to example.com"},
+		{"Canonicalises to WWW.Example.com temporarily", "www.example.com", "temporarily", "Canonicalizes to www.example.com temporarily"},
+		{"Canonicalizes to example.com:8443 with 308", "example.com:8443", "308", "Canonicalizes to example.com:8443 with 308"},
+	} {
+		rule := Parse(c.record)
+		if rule == nil || rule.Canonical != c.host || rule.RedirectState != c.state {
+			t.Errorf("%s: got %+v", c.record, rule)
+			continue
+		}
+		assertEqual(t, rule.String(), c.str)
+	}
+	for _, bad := range []string{"Canonicalizes to https://example.com/", "Canonicalizes to example.com with 200", "Canonicalizes to ex ample.com"} {
+		if rule := Parse(bad); rule != nil && rule.Canonical != "" {
+			t.Errorf("%s: got %+v", bad, rule)
+		}
+	}
+}
+
+func TestHandlerCanonical(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"www.example.com": {"Redirects from /old to https://example.com/new", "Canonicalizes to example.com"},
+	}))
+	req := httptest.NewRequest("GET", "/docs/a?b=c", nil)
+	req.Host = "www.example.com"
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, 301)
+	assertEqual(t, rr.Header().Get("Location"), "https://example.com/docs/a?b=c")
+
+	req = httptest.NewRequest("GET", "/old", nil)
+	req.Host = "www.example.com"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Header().Get("Location"), "https://example.com/new")
+}
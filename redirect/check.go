@@ -0,0 +1,60 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// A TargetChecker vets where a request is about to be redirected. The
+// location is always absolute: relative targets are resolved against the
+// request, and the context carries the request's LookupInfo, whose Host is
+// the host redirecting. It returns nil to allow the redirect; any error
+// refuses it.
+type TargetChecker interface {
+	CheckTarget(ctx context.Context, location string) error
+}
+
+// TargetCheckerFunc adapts a function to the TargetChecker interface.
+type TargetCheckerFunc func(ctx context.Context, location string) error
+
+// CheckTarget calls f(ctx, location).
+func (f TargetCheckerFunc) CheckTarget(ctx context.Context, location string) error {
+	return f(ctx, location)
+}
+
+// BlockedError is returned by a TargetChecker to refuse a redirect with a
+// particular status and explanation. Other errors refuse it with 403.
+type BlockedError struct {
+	Status int
+	Reason string
+
+	// Proceed turns the page into a warning with a link letting the user
+	// continue to the destination anyway.
+	Proceed bool
+}
+
+func (e *BlockedError) Error() string {
+	return "redirect blocked: " + e.Reason
+}
+
+// WithTargetCheck adds a TargetChecker consulted before every redirect.
+// Checkers run in the order they were added.
+func WithTargetCheck(c TargetChecker) Option {
+	return func(h *handler) { h.checks = append(h.checks, c) }
+}
+
+// checkedLocation resolves location against r, for TargetCheckers.
+func checkedLocation(r *http.Request, location string) string {
+	return resolveLocation(r, r.URL.Path, location)
+}
+
+// resolveLocation resolves location against path on r's host.
+func resolveLocation(r *http.Request, path, location string) string {
+	base := &url.URL{Scheme: RequestScheme(r), Host: r.Host, Path: path}
+	u, err := base.Parse(location)
+	if err != nil {
+		return location
+	}
+	return u.String()
+}
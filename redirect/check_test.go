@@ -0,0 +1,45 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerTargetCheck(t *testing.T) {
+	b := &Blocklist{Status: http.StatusUnavailableForLegalReasons}
+	b.Set([]string{"bad.example"})
+	h := NewHandler(
+		WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://bad.example/<script>"}}),
+		WithTargetCheck(b),
+	)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if rr.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("want 451, got %d", rr.Code)
+	}
+	if rr.Header().Get("Location") != "" {
+		t.Error("blocked redirects must not send a Location")
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "on a blocklist") || strings.Contains(body, "<script>") {
+		t.Errorf("unexpected page:\n%s", body)
+	}
+}
+
+func TestHandlerTargetWarning(t *testing.T) {
+	h := NewHandler(
+		WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://sketchy.example/"}}),
+		WithTargetCheck(TargetCheckerFunc(func(ctx context.Context, location string) error {
+			return &BlockedError{Status: http.StatusOK, Reason: "reported as phishing", Proceed: true}
+		})),
+	)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `<a href="https://sketchy.example/"`) {
+		t.Errorf("want a warning page linking onwards, got %d:\n%s", rr.Code, rr.Body)
+	}
+}
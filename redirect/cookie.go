@@ -0,0 +1,84 @@
+package redirect
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var cookieRE = regexp.MustCompile(`\s+setting\s+cookie\s+([^\s=]+=\S+)(?:\s+for\s+([0-9]+)\s+(days?|hours?|minutes?))?`)
+
+// maxCookieFor is the longest a rule's cookie may last, the most browsers
+// honor.
+const maxCookieFor = 400 * 24 * time.Hour
+
+var cookieUnits = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// parseCookie parses the name=value and optional "for 30 days" of a
+// "setting cookie" clause. It reports false for names and values a
+// Set-Cookie header can't carry and lifetimes out of range.
+func parseCookie(pair, count, unit string) (cookie string, lifetime time.Duration, ok bool) {
+	name, value, _ := strings.Cut(pair, "=")
+	if (&http.Cookie{Name: name, Value: value}).Valid() != nil {
+		return "", 0, false
+	}
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 || n > int(maxCookieFor/time.Minute) {
+			return "", 0, false
+		}
+		lifetime = time.Duration(n) * cookieUnits[strings.TrimSuffix(unit, "s")]
+		if lifetime > maxCookieFor {
+			return "", 0, false
+		}
+	}
+	return pair, lifetime, true
+}
+
+// formatCookieFor returns d as the " for 30 days" of a "setting cookie"
+// clause, in the largest unit that divides it.
+func formatCookieFor(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	n, unit := int64(d/time.Minute), "minute"
+	switch {
+	case d%(24*time.Hour) == 0:
+		n, unit = int64(d/(24*time.Hour)), "day"
+	case d%time.Hour == 0:
+		n, unit = int64(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return " for " + strconv.FormatInt(n, 10) + " " + unit
+}
+
+// setCookie sets rule's cookie on a redirect from host. It's scoped to
+// host's registrable domain, so a destination elsewhere on that domain can
+// read it, and marked Secure when the request came over HTTPS.
+func setCookie(w http.ResponseWriter, r *http.Request, host string, rule *Rule) {
+	name, value, _ := strings.Cut(rule.Cookie, "=")
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   RequestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if apex, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		c.Domain = apex
+	}
+	if rule.CookieFor > 0 {
+		c.MaxAge = int(rule.CookieFor / time.Second)
+	}
+	http.SetCookie(w, c)
+}
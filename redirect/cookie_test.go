@@ -0,0 +1,58 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCookie(t *testing.T) {
+	for _, c := range []struct {
+		record, cookie string
+		lifetime       time.Duration
+	}{
+		{"Redirects to https://shop.example.com/ setting cookie ref=campaign for 30 days", "ref=campaign", 30 * 24 * time.Hour},
+		{"Redirects from /spring to https://shop.example.com/ setting cookie ref=spring for 1 hour permanently", "ref=spring", time.Hour},
+		{"Redirects to https://shop.example.com/ setting cookie ref=campaign", "ref=campaign", 0},
+	} {
+		rule := Parse(c.record)
+		if rule == nil || rule.Cookie != c.cookie || rule.CookieFor != c.lifetime || rule.To != "https://shop.example.com/" {
+			t.Errorf("Parse(%q) = %+v", c.record, rule)
+			continue
+		}
+		if again := Parse(rule.String()); again == nil || *again != *rule {
+			t.Errorf("Parse(%q) = %+v, want %+v", rule.String(), again, rule)
+		}
+	}
+
+	for _, record := range []string{
+		"Redirects to https://shop.example.com/ setting cookie ref=a;b",
+		"Redirects to https://shop.example.com/ setting cookie r(f=campaign",
+		"Redirects to https://shop.example.com/ setting cookie ref=campaign for 401 days",
+		"Redirects to https://shop.example.com/ setting cookie ref=campaign for 0 days",
+	} {
+		if rule := Parse(record); rule != nil {
+			t.Errorf("Parse(%q) = %+v, want nil", record, rule)
+		}
+	}
+}
+
+func TestHandlerSetsCookie(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"go.example.com": {"Redirects from /spring to https://shop.example.com/ setting cookie ref=spring for 30 days", "Redirects to https://example.com/"},
+	}))
+
+	req := httptest.NewRequest("GET", "/spring", nil)
+	req.Host = "go.example.com"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, 302)
+	assertEqual(t, rr.Header().Get("Set-Cookie"), "ref=spring; Path=/; Domain=example.com; Max-Age=2592000; SameSite=Lax")
+	assertEqual(t, rr.Header().Get("Cache-Control"), "private, no-store")
+
+	req = httptest.NewRequest("GET", "/other", nil)
+	req.Host = "go.example.com"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Header().Get("Set-Cookie"), "")
+}
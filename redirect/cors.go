@@ -0,0 +1,95 @@
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ParseOrigins parses a comma-separated list of origins allowed to make
+// cross-origin requests, such as
+// "https://app.example.com,http://localhost:3000", or "*" for any origin.
+func ParseOrigins(s string) ([]string, error) {
+	var origins []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+				return nil, fmt.Errorf("%q is not an origin such as https://example.com", o)
+			}
+		}
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+// WithCORS lets browser scripts on origins (or any, with "*") fetch
+// through redirects: preflight OPTIONS requests are answered with 204 and
+// the CORS headers instead of a redirect, and other requests' responses
+// carry Access-Control-Allow-Origin. A host's "cors=" record overrides
+// origins, with its own list or "off".
+func WithCORS(origins ...string) Option {
+	return func(h *handler) { h.cors = origins }
+}
+
+// corsOrigins returns the origins allowed for a host with rules.
+func (h *handler) corsOrigins(rules []*Rule) []string {
+	v := FlagValue(rules, "cors")
+	if v == "" {
+		return h.cors
+	}
+	if v == "off" {
+		return nil
+	}
+	origins, _ := ParseOrigins(v)
+	return origins
+}
+
+// allowOrigin sets Access-Control-Allow-Origin if r comes from one of
+// origins, reporting whether it did.
+func allowOrigin(w http.ResponseWriter, r *http.Request, origins []string) bool {
+	if len(origins) == 0 {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	for _, o := range origins {
+		if o == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return true
+		}
+		if strings.EqualFold(o, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			return true
+		}
+	}
+	return false
+}
+
+// isPreflight reports whether r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// servePreflight answers a preflight request, allowing the method and
+// headers it asks for if its origin is allowed. Every path of a host
+// answers the same way, so the browser may cache the answer.
+func servePreflight(w http.ResponseWriter, r *http.Request, origins []string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	if allowOrigin(w, r, origins) {
+		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", "600")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
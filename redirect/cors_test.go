@@ -0,0 +1,59 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseOrigins(t *testing.T) {
+	origins, err := ParseOrigins(" https://app.example.com, http://localhost:3000 ")
+	if err != nil || len(origins) != 2 || origins[1] != "http://localhost:3000" {
+		t.Fatalf("got %q, %v", origins, err)
+	}
+	for _, bad := range []string{"app.example.com", "https://app.example.com/", "ftp://example.com"} {
+		if _, err := ParseOrigins(bad); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestHandlerCORS(t *testing.T) {
+	h := NewHandler(WithCORS("https://app.example.com"), WithResolver(StaticResolver{
+		"example.com": {"Redirects to https://api.example.org/*"},
+		"open.com":    {"cors=*", "Redirects to https://api.example.org/*"},
+		"closed.com":  {"cors=off", "Redirects to https://api.example.org/*"},
+	}))
+	request := func(method, host, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/users", nil)
+		req.Host = host
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+			req.Header.Set("Access-Control-Request-Headers", "content-type")
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("OPTIONS", "example.com", "https://app.example.com")
+	assertEqual(t, rr.Code, 204)
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "https://app.example.com")
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Methods"), "PUT")
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Headers"), "content-type")
+
+	rr = request("OPTIONS", "example.com", "https://evil.example")
+	assertEqual(t, rr.Code, 204)
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "")
+
+	rr = request("GET", "example.com", "https://app.example.com")
+	assertEqual(t, rr.Code, 302)
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "https://app.example.com")
+
+	rr = request("GET", "open.com", "https://evil.example")
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "*")
+
+	rr = request("OPTIONS", "closed.com", "https://app.example.com")
+	assertEqual(t, rr.Code, 302)
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "")
+}
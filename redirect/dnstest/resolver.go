@@ -0,0 +1,99 @@
+package dnstest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// Resolver is a scriptable redirect.Resolver. Hosts that haven't been set
+// answer with redirect.ErrNotFound. The zero value is ready to use.
+type Resolver struct {
+	mu    sync.Mutex
+	hosts map[string]answer
+	calls map[string]int
+}
+
+type answer struct {
+	records []string
+	err     error
+	delay   time.Duration
+	// notFound is set for hosts only delayed, which still aren't found.
+	notFound bool
+}
+
+// NewResolver returns an empty Resolver.
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+// Set makes host answer with records.
+func (r *Resolver) Set(host string, records ...string) {
+	r.script(host, answer{records: records})
+}
+
+// Fail makes lookups of host return err.
+func (r *Resolver) Fail(host string, err error) {
+	r.script(host, answer{err: err})
+}
+
+// Delay makes lookups of host wait d before answering, or until the
+// lookup's context is done.
+func (r *Resolver) Delay(host string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.hosts[host]
+	a.delay, a.notFound = d, a.notFound || !ok
+	r.setLocked(host, a)
+}
+
+// Calls returns how many times host has been looked up.
+func (r *Resolver) Calls(host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[host]
+}
+
+func (r *Resolver) LookupConfig(ctx context.Context, host string) ([]*redirect.Rule, error) {
+	r.mu.Lock()
+	if r.calls == nil {
+		r.calls = make(map[string]int)
+	}
+	r.calls[host]++
+	a, ok := r.hosts[host]
+	r.mu.Unlock()
+
+	if a.delay > 0 {
+		timer := time.NewTimer(a.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if !ok || a.notFound {
+		return nil, fmt.Errorf("%w for %s", redirect.ErrNotFound, host)
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+	return redirect.ParseAll(a.records), nil
+}
+
+func (r *Resolver) script(host string, a answer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a.delay = r.hosts[host].delay
+	r.setLocked(host, a)
+}
+
+func (r *Resolver) setLocked(host string, a answer) {
+	if r.hosts == nil {
+		r.hosts = make(map[string]answer)
+	}
+	r.hosts[host] = a
+}
@@ -0,0 +1,58 @@
+package dnstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestResolver(t *testing.T) {
+	r := NewResolver()
+	r.Set("go.example.com", "Redirects to https://example.com/")
+	ctx := context.Background()
+
+	rules, err := r.LookupConfig(ctx, "go.example.com")
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("unexpected answer: %#v, %v", rules, err)
+	}
+	if _, err := r.LookupConfig(ctx, "other.example.com"); !errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for unset host, got %v", err)
+	}
+
+	failure := errors.New("timeout")
+	r.Fail("go.example.com", failure)
+	if _, err := r.LookupConfig(ctx, "go.example.com"); err != failure {
+		t.Errorf("expected scripted failure, got %v", err)
+	}
+	if n := r.Calls("go.example.com"); n != 2 {
+		t.Errorf("expected 2 calls, got %d", n)
+	}
+}
+
+func TestResolverDelay(t *testing.T) {
+	var r Resolver
+	r.Set("slow.example.com", "Redirects to https://example.com/")
+	r.Delay("slow.example.com", time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.LookupConfig(ctx, "slow.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestResolverDelayUnscripted(t *testing.T) {
+	var r Resolver
+	r.Delay("slow.example.com", time.Millisecond)
+	if _, err := r.LookupConfig(context.Background(), "slow.example.com"); !errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("a host only delayed: want ErrNotFound, got %v", err)
+	}
+
+	r.Set("slow.example.com", "Redirects to https://example.com/")
+	if rules, err := r.LookupConfig(context.Background(), "slow.example.com"); err != nil || len(rules) != 1 {
+		t.Errorf("after Set: got %v, %v", rules, err)
+	}
+}
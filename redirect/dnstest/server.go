@@ -0,0 +1,338 @@
+// Package dnstest provides utilities for testing code that resolves
+// redirect rules, in the spirit of net/http/httptest.
+//
+// Server is an in-process authoritative DNS server speaking UDP and TCP on
+// the loopback interface, so tests can exercise the real resolution path
+// (including TTLs, truncation and NXDOMAIN) through a *net.Resolver.
+// Resolver is a scriptable redirect.Resolver for tests that don't need DNS
+// on the wire at all.
+package dnstest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// DefaultTTL is the TTL served for records added with SetRedirect and
+// SetCAA.
+const DefaultTTL = 300
+
+// TypeCAA is the CAA record type (RFC 8659), which dnsmessage doesn't name.
+const TypeCAA dnsmessage.Type = 257
+
+// A Query is a question received by a Server.
+type Query struct {
+	Name    string // fully-qualified, lower-case
+	Type    dnsmessage.Type
+	Network string // "udp", "tcp" or "https"
+}
+
+// Server is an authoritative DNS server for tests. Create one with
+// NewServer and stop it with Close.
+type Server struct {
+	// Addr is the host:port the server listens on for both UDP and TCP.
+	Addr string
+
+	udp net.PacketConn
+	tcp net.Listener
+	wg  sync.WaitGroup
+
+	mu       sync.Mutex
+	records  map[string]rrset
+	rcodes   map[string]dnsmessage.RCode
+	truncate bool
+	queries  []Query
+}
+
+type rrset struct {
+	ttl uint32
+	txt []string
+	caa []string
+}
+
+// NewServer starts a Server on a random loopback port. It panics if it
+// can't listen, since that leaves the test with nothing to do.
+func NewServer() *Server {
+	s := &Server{
+		records: make(map[string]rrset),
+		rcodes:  make(map[string]dnsmessage.RCode),
+	}
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if s.udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			continue
+		}
+		s.Addr = s.udp.LocalAddr().String()
+		if s.tcp, err = net.Listen("tcp", s.Addr); err == nil {
+			break
+		}
+		s.udp.Close()
+	}
+	if err != nil {
+		panic(fmt.Sprintf("dnstest: failed to listen: %v", err))
+	}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+// Close stops the server and waits for its goroutines to exit.
+func (s *Server) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+	s.wg.Wait()
+}
+
+// Resolver returns a *net.Resolver that sends every query to s.
+func (s *Server) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.Addr)
+		},
+	}
+}
+
+// SetTXT replaces the TXT records served for name. Records longer than 255
+// bytes are split into multiple character-strings, as a DNS provider would.
+func (s *Server) SetTXT(name string, ttl uint32, txt ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.records[canonical(name)]
+	set.ttl, set.txt = ttl, txt
+	s.records[canonical(name)] = set
+}
+
+// SetCAA replaces the CAA records served for name, each written as in a
+// zone file: `0 issue "letsencrypt.org"`.
+func (s *Server) SetCAA(name string, records ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.records[canonical(name)]
+	set.caa = records
+	s.records[canonical(name)] = set
+}
+
+// SetRedirect serves records as the redirect configuration of host, at
+// _redirect.<host> with DefaultTTL.
+func (s *Server) SetRedirect(host string, records ...string) {
+	s.SetTXT(redirect.RecordName(host), DefaultTTL, records...)
+}
+
+// Delete removes name, so queries for it get NXDOMAIN.
+func (s *Server) Delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, canonical(name))
+	delete(s.rcodes, canonical(name))
+}
+
+// SetRCode makes queries for name fail with rcode, e.g. RCodeServerFailure.
+func (s *Server) SetRCode(name string, rcode dnsmessage.RCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcodes[canonical(name)] = rcode
+}
+
+// SetTruncate makes every UDP answer truncated, forcing clients to retry
+// over TCP. Answers that don't fit in the client's UDP size are truncated
+// regardless.
+func (s *Server) SetTruncate(truncate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate = truncate
+}
+
+// Queries returns the questions received so far, oldest first.
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// DoHHandler returns an http.Handler answering DNS-over-HTTPS (RFC 8484)
+// POST queries from the same records, for use with redirect.DoHResolver.
+func (s *Server) DoHHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "expected POST application/dns-message", http.StatusBadRequest)
+			return
+		}
+		query, err := io.ReadAll(io.LimitReader(r.Body, 65535))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reply := s.answer(query, "https", 65535)
+		if reply == nil {
+			http.Error(w, "malformed query", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(reply)
+	})
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.answer(buf[:n], "udp", 512); reply != nil {
+			s.udp.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			for {
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				query := make([]byte, length)
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				reply := s.answer(query, "tcp", 65535)
+				if reply == nil {
+					return
+				}
+				if err := binary.Write(conn, binary.BigEndian, uint16(len(reply))); err != nil {
+					return
+				}
+				if _, err := conn.Write(reply); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answer builds the packed reply to query, or returns nil if query is
+// malformed. Replies larger than maxSize are truncated.
+func (s *Server) answer(query []byte, network string, maxSize int) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	q := msg.Questions[0]
+	name := canonical(q.Name.String())
+	if network == "udp" {
+		// EDNS(0) advertises the client's UDP payload size in the OPT
+		// record's class field.
+		for _, extra := range msg.Additionals {
+			if extra.Header.Type == dnsmessage.TypeOPT && int(extra.Header.Class) > maxSize {
+				maxSize = int(extra.Header.Class)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.queries = append(s.queries, Query{Name: name, Type: q.Type, Network: network})
+	set, found := s.records[name]
+	rcode, failing := s.rcodes[name]
+	truncate := s.truncate && network == "udp"
+	s.mu.Unlock()
+
+	reply := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 msg.ID,
+			Response:           true,
+			OpCode:             msg.OpCode,
+			Authoritative:      true,
+			RecursionDesired:   msg.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: msg.Questions,
+	}
+	switch {
+	case failing:
+		reply.RCode = rcode
+	case !found:
+		reply.RCode = dnsmessage.RCodeNameError
+	case q.Type == dnsmessage.TypeTXT:
+		for _, record := range set.txt {
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: set.ttl},
+				Body:   &dnsmessage.TXTResource{TXT: split(record)},
+			})
+		}
+	case q.Type == TypeCAA:
+		for _, record := range set.caa {
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: TypeCAA, Class: dnsmessage.ClassINET, TTL: DefaultTTL},
+				Body:   &dnsmessage.UnknownResource{Type: TypeCAA, Data: caaData(record)},
+			})
+		}
+	}
+
+	packed, err := reply.Pack()
+	if err != nil {
+		return nil
+	}
+	if truncate || len(packed) > maxSize {
+		reply.Truncated = true
+		reply.Answers = nil
+		if packed, err = reply.Pack(); err != nil {
+			return nil
+		}
+	}
+	return packed
+}
+
+// caaData encodes a CAA record written as `flags tag "value"`.
+func caaData(record string) []byte {
+	fields := strings.SplitN(record, " ", 3)
+	for len(fields) < 3 {
+		fields = append(fields, "")
+	}
+	var flags uint8
+	fmt.Sscan(fields[0], &flags)
+	data := []byte{flags, byte(len(fields[1]))}
+	data = append(data, fields[1]...)
+	return append(data, strings.Trim(fields[2], `"`)...)
+}
+
+// split breaks a record into character-strings of at most 255 bytes.
+func split(record string) []string {
+	var parts []string
+	for len(record) > 255 {
+		parts = append(parts, record[:255])
+		record = record[255:]
+	}
+	return append(parts, record)
+}
+
+func canonical(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
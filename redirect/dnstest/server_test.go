@@ -0,0 +1,141 @@
+package dnstest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestServerLookup(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetRedirect("go.example.com", "Redirects to https://example.com/")
+
+	resolver := redirect.DNSResolver{Resolver: srv.Resolver()}
+	rules, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(rules) != 1 || rules[0].To != "https://example.com/" {
+		t.Errorf("unexpected rules %#v", rules)
+	}
+
+	queries := srv.Queries()
+	if len(queries) == 0 || queries[0].Name != "_redirect.go.example.com." || queries[0].Type != dnsmessage.TypeTXT {
+		t.Errorf("unexpected queries %#v", queries)
+	}
+}
+
+func TestServerNXDOMAIN(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	resolver := redirect.DNSResolver{Resolver: srv.Resolver()}
+	_, err := resolver.LookupConfig(context.Background(), "missing.example.com")
+	if !errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	srv.SetRedirect("gone.example.com", "Redirects to https://example.com/")
+	srv.Delete(redirect.RecordName("gone.example.com"))
+	_, err = resolver.LookupConfig(context.Background(), "gone.example.com")
+	if !errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetRedirect("go.example.com", "Redirects to https://example.com/")
+	srv.SetRCode(redirect.RecordName("go.example.com"), dnsmessage.RCodeServerFailure)
+
+	resolver := redirect.DNSResolver{Resolver: srv.Resolver()}
+	_, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if err == nil || errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("expected SERVFAIL lookup error, got %v", err)
+	}
+}
+
+func TestServerTruncation(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	long := "Redirects from /" + strings.Repeat("a", 600) + " to https://example.com/"
+	srv.SetRedirect("go.example.com", long)
+	srv.SetTruncate(true)
+
+	txt, err := srv.Resolver().LookupTXT(context.Background(), "_redirect.go.example.com")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(txt) != 1 || txt[0] != long {
+		t.Errorf("long record not reassembled: got %d records", len(txt))
+	}
+
+	var sawUDP, sawTCP bool
+	for _, q := range srv.Queries() {
+		sawUDP = sawUDP || q.Network == "udp"
+		sawTCP = sawTCP || q.Network == "tcp"
+	}
+	if !sawUDP || !sawTCP {
+		t.Errorf("expected a truncated UDP answer followed by a TCP retry, got %#v", srv.Queries())
+	}
+}
+
+func TestServerTTL(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetTXT("_redirect.go.example.com", 42, "Redirects to https://example.com/")
+
+	var q dnsmessage.Message
+	q.Questions = []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName("_redirect.go.example.com."),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	}}
+	packed, _ := q.Pack()
+
+	conn, err := net.Dial("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(packed)
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply dnsmessage.Message
+	if err := reply.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answers) != 1 || reply.Answers[0].Header.TTL != 42 {
+		t.Errorf("expected one answer with TTL 42, got %#v", reply.Answers)
+	}
+}
+
+func TestServerDoH(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.SetRedirect("go.example.com", "Redirects to https://example.com/")
+	ts := httptest.NewServer(srv.DoHHandler())
+	defer ts.Close()
+
+	resolver := &redirect.DoHResolver{URL: ts.URL}
+	rules, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("DoH lookup: rules %#v, err %v", rules, err)
+	}
+	_, err = resolver.LookupConfig(context.Background(), "missing.example.com")
+	if !errors.Is(err, redirect.ErrNotFound) {
+		t.Errorf("expected ErrNotFound over DoH, got %v", err)
+	}
+}
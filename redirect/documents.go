@@ -0,0 +1,132 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits on documents fetched for "Serves <path> from <url>" rules.
+const (
+	maxDocuments      = 1000
+	maxDocumentBody   = 128 << 10
+	documentTTL       = time.Hour
+	documentRetryTTL  = time.Minute
+	documentFetchWait = 5 * time.Second
+)
+
+var defaultDocumentClient = &http.Client{Timeout: documentFetchWait}
+
+// WithDocumentClient sets the client fetching the documents of "Serves
+// <path> from <url>" rules. The default has a 5s timeout.
+func WithDocumentClient(client *http.Client) Option {
+	return func(h *handler) { h.documents.client = client }
+}
+
+// documents caches fetched well-known documents per URL, for their
+// Cache-Control max-age or an hour. Failures are cached for a minute.
+type documents struct {
+	client *http.Client
+
+	mu   sync.Mutex
+	docs map[string]document
+	now  func() time.Time
+}
+
+type document struct {
+	body    []byte
+	err     error
+	expires time.Time
+}
+
+func (d *documents) get(ctx context.Context, url string) ([]byte, error) {
+	d.mu.Lock()
+	doc, ok := d.docs[url]
+	d.mu.Unlock()
+	if ok && d.clock().Before(doc.expires) {
+		return doc.body, doc.err
+	}
+	body, ttl, err := d.fetch(ctx, url)
+	if err != nil {
+		ttl = documentRetryTTL
+	}
+	d.store(url, document{body: body, err: err, expires: d.clock().Add(ttl)})
+	return body, err
+}
+
+func (d *documents) store(url string, doc document) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.docs == nil {
+		d.docs = make(map[string]document)
+	}
+	if _, ok := d.docs[url]; !ok && len(d.docs) >= maxDocuments {
+		d.evict()
+	}
+	d.docs[url] = doc
+}
+
+// evict makes room for one more document, dropping expired ones first and
+// then arbitrary ones. d.mu must be held.
+func (d *documents) evict() {
+	now := d.clock()
+	for url, doc := range d.docs {
+		if !now.Before(doc.expires) {
+			delete(d.docs, url)
+		}
+	}
+	for url := range d.docs {
+		if len(d.docs) < maxDocuments {
+			break
+		}
+		delete(d.docs, url)
+	}
+}
+
+func (d *documents) fetch(ctx context.Context, url string) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), documentFetchWait)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	client := d.client
+	if client == nil {
+		client = defaultDocumentClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBody+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(body) > maxDocumentBody {
+		return nil, 0, fmt.Errorf("%s: larger than %d bytes", url, maxDocumentBody)
+	}
+	if !json.Valid(body) {
+		return nil, 0, errors.New(url + ": not JSON")
+	}
+	ttl := documentTTL
+	if maxAge, ok := parseMaxAge(resp.Header.Get("Cache-Control")); ok {
+		ttl = maxAge
+	}
+	return body, ttl, nil
+}
+
+func (d *documents) clock() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,103 @@
+package redirect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DoHResolver reads rules from the TXT records at _redirect.<host> using
+// DNS-over-HTTPS (RFC 8484), bypassing the system resolver.
+type DoHResolver struct {
+	// URL is the DoH endpoint, e.g. https://cloudflare-dns.com/dns-query.
+	URL string
+	// Client is used for requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (d *DoHResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	txt, err := lookupTXT(ctx, RecordName(host), d.LookupTXT)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return nil, err
+	}
+	return parseAll(ctx, txt), nil
+}
+
+// LookupTXT returns the TXT records for name. Errors are reported as
+// *net.DNSError, matching the system resolver.
+func (d *DoHResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	fqdn := name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: d.URL, IsTemporary: true}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{Err: "DoH server returned " + resp.Status, Name: name, Server: d.URL, IsTemporary: true}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: d.URL, IsTemporary: true}
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return nil, &net.DNSError{Err: "malformed DNS response", Name: name, Server: d.URL}
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: d.URL, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: "server responded " + reply.RCode.String(), Name: name, Server: d.URL, IsTemporary: true}
+	}
+
+	var txt []string
+	for _, answer := range reply.Answers {
+		if record, ok := answer.Body.(*dnsmessage.TXTResource); ok {
+			txt = append(txt, strings.Join(record.TXT, ""))
+		}
+	}
+	if len(txt) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: d.URL, IsNotFound: true}
+	}
+	return txt, nil
+}
@@ -0,0 +1,85 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDoHServer answers DoH queries from records, keyed by fully-qualified
+// name, returning NXDOMAIN for names it doesn't know.
+func newDoHServer(t *testing.T, records map[string][]string) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := query.Questions[0]
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+		}
+		txt, ok := records[q.Name.String()]
+		if !ok {
+			reply.RCode = dnsmessage.RCodeNameError
+		}
+		for _, record := range txt {
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.TXTResource{TXT: []string{record}},
+			})
+		}
+		packed, err := reply.Pack()
+		if err != nil {
+			t.Errorf("packing reply: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDoHResolver(t *testing.T) {
+	ts := newDoHServer(t, map[string][]string{
+		"_redirect.go.example.com.": {"Redirects to https://example.com/ with 308"},
+	})
+	resolver := &DoHResolver{URL: ts.URL, Client: ts.Client()}
+	ctx := context.Background()
+
+	rules, err := resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, len(rules), 1)
+	assertEqual(t, rules[0].To, "https://example.com/")
+	assertEqual(t, rules[0].RedirectState, "308")
+
+	_, err = resolver.LookupConfig(ctx, "missing.example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for NXDOMAIN, got %v", err)
+	}
+}
+
+func TestDoHResolverServerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	resolver := &DoHResolver{URL: ts.URL}
+
+	_, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected lookup failure, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile reads a StaticResolver from a YAML or JSON file mapping each
+// host to its records, for example:
+//
+//	go.example.com:
+//	  - Redirects from /docs/* to https://docs.example.com/*
+//	  - Redirects to https://example.com/
+//
+// Files ending in .json are parsed as JSON; anything else as YAML.
+func LoadFile(path string) (StaticResolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hosts StaticResolver
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(data, &hosts)
+	} else {
+		err = yaml.Unmarshal(data, &hosts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for host, records := range hosts {
+		ascii, err := ASCIIHost(host)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: host %q: %w", path, host, err)
+		}
+		if ascii != host {
+			delete(hosts, host)
+			hosts[ascii] = append(hosts[ascii], records...)
+		}
+	}
+	return hosts, nil
+}
+
+// FileResolver serves rules from a file in the LoadFile format and can
+// reload it without a restart. A failed reload keeps the previous rules.
+type FileResolver struct {
+	path string
+
+	mu      sync.RWMutex
+	hosts   StaticResolver
+	modTime time.Time
+}
+
+// NewFileResolver loads path and returns a FileResolver serving it.
+func NewFileResolver(path string) (*FileResolver, error) {
+	f := &FileResolver{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	f.mu.RLock()
+	hosts := f.hosts
+	f.mu.RUnlock()
+	return hosts.LookupConfig(ctx, host)
+}
+
+// Reload re-reads the file.
+func (f *FileResolver) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	hosts, err := LoadFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts = hosts
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when its modification
+// time changes, until ctx is done. Reload errors are passed to onError,
+// which may be nil.
+func (f *FileResolver) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err == nil {
+			f.mu.RLock()
+			changed := !info.ModTime().Equal(f.modTime)
+			f.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = f.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
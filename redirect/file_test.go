@@ -0,0 +1,97 @@
+package redirect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	jsonPath := filepath.Join(dir, "redirects.json")
+	writeFile(t, jsonPath, `{"go.example.com": ["Redirects from /docs/* to https://docs.example.com/*"]}`)
+	resolver, err := LoadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("LoadFile json: %v", err)
+	}
+	rules, err := resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, rules[0].From, "/docs/*")
+
+	yamlPath := filepath.Join(dir, "redirects.yaml")
+	writeFile(t, yamlPath, "go.example.com:\n  - Redirects to https://example.com/ permanently\n")
+	resolver, err = LoadFile(yamlPath)
+	if err != nil {
+		t.Fatalf("LoadFile yaml: %v", err)
+	}
+	rules, err = resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, rules[0].RedirectState, "permanently")
+
+	writeFile(t, jsonPath, "not json")
+	if _, err := LoadFile(jsonPath); err == nil {
+		t.Error("expected error for malformed file")
+	}
+}
+
+func TestFileResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redirects.yaml")
+	writeFile(t, path, "go.example.com: [Redirects to https://one.example/]\n")
+	f, err := NewFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	writeFile(t, path, "go.example.com: [Redirects to https://two.example/]\n")
+	if err := f.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	rules, _ := f.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, rules[0].To, "https://two.example/")
+
+	// A broken file keeps the last good rules.
+	writeFile(t, path, "go.example.com: [unterminated\n")
+	if err := f.Reload(); err == nil {
+		t.Error("expected reload error")
+	}
+	rules, _ = f.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, rules[0].To, "https://two.example/")
+}
+
+func TestFileResolverWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redirects.yaml")
+	writeFile(t, path, "go.example.com: [Redirects to https://one.example/]\n")
+	f, err := NewFileResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, 5*time.Millisecond, nil)
+
+	writeFile(t, path, "go.example.com: [Redirects to https://two.example/]\n")
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		rules, _ := f.LookupConfig(ctx, "go.example.com")
+		if rules[0].To == "https://two.example/" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("file change was not picked up by Watch")
+}
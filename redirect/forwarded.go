@@ -0,0 +1,141 @@
+package redirect
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type forwardedProtoKey struct{}
+
+// TrustProxies returns h wrapped so that requests arriving from one of the
+// trusted networks are taken at their word about the original request:
+// X-Forwarded-Host replaces r.Host, the client address from X-Forwarded-For
+// replaces r.RemoteAddr (with port 0), and the scheme the proxy was asked
+// for becomes RequestScheme's, which relative redirect targets, HTTPS
+// upgrades and HSTS go by. Headers from other peers are ignored.
+func TrustProxies(trusted []netip.Prefix, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isTrusted(trusted, peerAddr(r.RemoteAddr)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			r.Host = host
+		}
+		if client, ok := forwardedClient(trusted, r.Header.Values("X-Forwarded-For")); ok {
+			r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+		}
+		if proto := forwardedProto(r.Header); proto != "" {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedProtoKey{}, proto))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the address of the client that sent r, after any
+// rewriting by TrustProxies.
+func ClientIP(r *http.Request) string {
+	return peerAddr(r.RemoteAddr).String()
+}
+
+// RequestScheme returns the scheme r was made with, "http" or "https", as
+// a trusted proxy forwarded it or else as it reached the server.
+func RequestScheme(r *http.Request) string {
+	if scheme, _ := r.Context().Value(forwardedProtoKey{}).(string); scheme != "" {
+		return scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedProto returns the scheme the client asked a proxy for, by
+// whichever header the proxy spells it with: X-Forwarded-Proto, the proto
+// of RFC 7239's Forwarded, CloudFront-Forwarded-Proto or X-Forwarded-Ssl.
+// It's "" if none says.
+func forwardedProto(header http.Header) string {
+	proto := firstValue(header.Get("X-Forwarded-Proto"))
+	if proto == "" {
+		for _, pair := range strings.Split(firstValue(header.Get("Forwarded")), ";") {
+			if name, value, _ := strings.Cut(strings.TrimSpace(pair), "="); strings.EqualFold(name, "proto") {
+				proto = strings.Trim(value, `"`)
+			}
+		}
+	}
+	if proto == "" {
+		proto = firstValue(header.Get("CloudFront-Forwarded-Proto"))
+	}
+	if proto == "" && strings.EqualFold(header.Get("X-Forwarded-Ssl"), "on") {
+		proto = "https"
+	}
+	if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+		return proto
+	}
+	return ""
+}
+
+// forwardedClient returns the right-most untrusted address in the
+// X-Forwarded-For chain: the last hop that a trusted proxy vouched for.
+func forwardedClient(trusted []netip.Prefix, values []string) (netip.Addr, bool) {
+	var hops []string
+	for _, v := range values {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !isTrusted(trusted, client) {
+			break
+		}
+	}
+	return client, client.IsValid()
+}
+
+func peerAddr(remoteAddr string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return ap.Addr().Unmap()
+	}
+	addr, _ := netip.ParseAddr(remoteAddr)
+	return addr.Unmap()
+}
+
+func isTrusted(trusted []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// absoluteLocation makes a relative redirect target absolute, using the
+// scheme and host the client originally asked a trusted proxy for. A
+// scheme-relative target, as canonical host rules give, gets the scheme of
+// the request.
+func absoluteLocation(r *http.Request, location string) string {
+	if strings.HasPrefix(location, "//") {
+		return RequestScheme(r) + ":" + location
+	}
+	proto, _ := r.Context().Value(forwardedProtoKey{}).(string)
+	if proto == "" || !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+		return location
+	}
+	return proto + "://" + r.Host + location
+}
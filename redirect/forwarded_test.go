@@ -0,0 +1,96 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTrustProxies(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var seen *http.Request
+	h := TrustProxies(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "lb.internal"
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("X-Forwarded-Host", "go.example.com, lb.internal")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.9, 10.9.9.9")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen.Host != "go.example.com" {
+		t.Errorf("host: want go.example.com, got %q", seen.Host)
+	}
+	// The left-most hop could have been written by the client itself.
+	if ip := ClientIP(seen); ip != "203.0.113.9" {
+		t.Errorf("client IP: want 203.0.113.9, got %q", ip)
+	}
+
+	req.RemoteAddr = "192.0.2.1:4567"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen.Host != "lb.internal" || ClientIP(seen) != "192.0.2.1" {
+		t.Errorf("untrusted peer: headers should be ignored, got host %q from %q", seen.Host, ClientIP(seen))
+	}
+}
+
+func TestTrustProxiesRelativeTarget(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	h := TrustProxies(trusted, NewHandler(WithResolver(StaticResolver{
+		"go.example.com": []string{"Redirects to /landing"},
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "backend:8081"
+	req.RemoteAddr = "127.0.0.1:4567"
+	req.Header.Set("X-Forwarded-Host", "go.example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "https://go.example.com/landing" {
+		t.Errorf("Location: want https://go.example.com/landing, got %q", loc)
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	for _, c := range []struct {
+		name, value, want string
+	}{
+		{"X-Forwarded-Proto", "HTTPS, http", "https"},
+		{"Forwarded", `for=192.0.2.60;proto="https";by=203.0.113.43, proto=http`, "https"},
+		{"CloudFront-Forwarded-Proto", "http", "http"},
+		{"X-Forwarded-Ssl", "on", "https"},
+		{"X-Forwarded-Proto", "gopher", ""},
+		{"X-Forwarded-Ssl", "off", ""},
+	} {
+		header := http.Header{}
+		header.Set(c.name, c.value)
+		if got := forwardedProto(header); got != c.want {
+			t.Errorf("%s: %s: want %q, got %q", c.name, c.value, c.want, got)
+		}
+	}
+}
+
+func TestTrustProxiesForceHTTPS(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := TrustProxies(trusted, NewHandler(WithForceHTTPS(), WithHSTS(time.Hour), WithResolver(StaticResolver{
+		"go.example.com": []string{"Redirects to https://example.com/"},
+	})))
+
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := serve("Forwarded", "proto=https"); rr.Header().Get("Location") != "https://example.com/" || rr.Header().Get("Strict-Transport-Security") != "max-age=3600" {
+		t.Errorf("over HTTPS: got Location %q, HSTS %q", rr.Header().Get("Location"), rr.Header().Get("Strict-Transport-Security"))
+	}
+	if rr := serve("X-Forwarded-Proto", "http"); rr.Header().Get("Location") != "https://go.example.com/" || rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("over HTTP: got Location %q, HSTS %q", rr.Header().Get("Location"), rr.Header().Get("Strict-Transport-Security"))
+	}
+}
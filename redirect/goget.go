@@ -0,0 +1,121 @@
+package redirect
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A GoImport is the answer to a go-get=1 request for a Go vanity import
+// path, from a "Redirects goget to https://github.com/org/repo" rule.
+type GoImport struct {
+	// Prefix is the import path the repository's root corresponds to,
+	// such as "example.com/tool".
+	Prefix string
+	VCS    string
+	// Repo is the repository's URL.
+	Repo string
+	// Dir and File are go-source URL templates for browsing the
+	// repository's code, set when it's hosted on a forge with known
+	// URLs (GitHub, GitLab).
+	Dir, File string
+	// Rule is the rule that produced the import.
+	Rule *Rule
+}
+
+// MatchGoImport returns the GoImport the goget rules among rules give the
+// import path host + path, or nil. A rule without a From path serves the
+// host itself and every package below it. One from an exact path, such as
+// /tool, serves that path and those below it. One from a wildcard, such as
+// /* to https://github.com/org/*, serves a repository per first path
+// segment below the wildcard's prefix.
+func MatchGoImport(rules []*Rule, host, path string) *GoImport {
+	var catchAll *Rule
+	for _, rule := range rules {
+		if !rule.GoGet || rule.To == "" {
+			continue
+		}
+		if rule.From == "" {
+			if catchAll == nil {
+				catchAll = rule
+			}
+			continue
+		}
+		if imp := goImport(rule, host, path); imp != nil {
+			return imp
+		}
+	}
+	if catchAll != nil {
+		return newGoImport(catchAll, host, catchAll.To)
+	}
+	return nil
+}
+
+func goImport(rule *Rule, host, path string) *GoImport {
+	from := strings.TrimSuffix(rule.From, "/")
+	before, _, wildcard := strings.Cut(from, "*")
+	if !wildcard {
+		if path != from && !strings.HasPrefix(path, from+"/") {
+			return nil
+		}
+		return newGoImport(rule, host+from, rule.To)
+	}
+	rest, ok := strings.CutPrefix(path, before)
+	if !ok {
+		return nil
+	}
+	segment, _, _ := strings.Cut(rest, "/")
+	if segment == "" || segment == "." || segment == ".." {
+		return nil
+	}
+	return newGoImport(rule, host+before+segment, strings.Replace(rule.To, "*", segment, 1))
+}
+
+func newGoImport(rule *Rule, prefix, repo string) *GoImport {
+	u, err := url.Parse(repo)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil
+	}
+	u.RawQuery, u.Fragment = "", ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	imp := &GoImport{Prefix: prefix, VCS: "git", Repo: u.String(), Rule: rule}
+	home := strings.TrimSuffix(imp.Repo, ".git")
+	switch strings.ToLower(u.Host) {
+	case "github.com":
+		imp.Dir = home + "/tree/HEAD{/dir}"
+		imp.File = home + "/blob/HEAD{/dir}/{file}#L{line}"
+	case "gitlab.com":
+		imp.Dir = home + "/-/tree/HEAD{/dir}"
+		imp.File = home + "/-/blob/HEAD{/dir}/{file}#L{line}"
+	}
+	return imp
+}
+
+// Home is the repository's web page.
+func (imp *GoImport) Home() string {
+	return strings.TrimSuffix(imp.Repo, ".git")
+}
+
+var goImportPage = template.Must(template.New("goget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="go-import" content="{{.Prefix}} {{.VCS}} {{.Repo}}">
+{{if .Dir}}<meta name="go-source" content="{{.Prefix}} {{.Home}} {{.Dir}} {{.File}}">
+{{end}}<meta http-equiv="refresh" content="0; url={{.Home}}">
+<title>{{.Prefix}}</title>
+</head>
+<body>
+<p><code>go get {{.Prefix}}</code> is served from <a href="{{.Home}}">{{.Repo}}</a>.</p>
+</body>
+</html>
+`))
+
+// serveGoImport answers a go-get=1 request with imp's go-import and
+// go-source tags.
+func (h *handler) serveGoImport(w http.ResponseWriter, imp *GoImport) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=300")
+	goImportPage.Execute(w, imp)
+}
@@ -0,0 +1,67 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchGoImport(t *testing.T) {
+	rules := ParseAll([]string{
+		"Redirects to https://example.com/",
+		"Redirects goget from /tool to https://gitlab.com/org/tool.git",
+		"Redirects goget from /x/* to https://github.com/org/*",
+		"Redirects goget to https://github.com/org/root/",
+	})
+	assertEqual(t, rules[3].String(), "Redirects goget to https://github.com/org/root/")
+	cases := []struct {
+		path, prefix, repo, dir string
+	}{
+		{"/tool", "example.com/tool", "https://gitlab.com/org/tool.git", "https://gitlab.com/org/tool/-/tree/HEAD{/dir}"},
+		{"/tool/cmd/run", "example.com/tool", "https://gitlab.com/org/tool.git", "https://gitlab.com/org/tool/-/tree/HEAD{/dir}"},
+		{"/x/net/http", "example.com/x/net", "https://github.com/org/net", "https://github.com/org/net/tree/HEAD{/dir}"},
+		{"/toolbox", "example.com", "https://github.com/org/root", "https://github.com/org/root/tree/HEAD{/dir}"},
+		{"/x/", "example.com", "https://github.com/org/root", "https://github.com/org/root/tree/HEAD{/dir}"},
+	}
+	for _, c := range cases {
+		imp := MatchGoImport(rules, "example.com", c.path)
+		if imp == nil {
+			t.Errorf("%s: no import", c.path)
+			continue
+		}
+		if imp.Prefix != c.prefix || imp.Repo != c.repo || imp.Dir != c.dir || imp.VCS != "git" {
+			t.Errorf("%s: got %+v", c.path, imp)
+		}
+	}
+	if imp := MatchGoImport(rules[:1], "example.com", "/"); imp != nil {
+		t.Errorf("no goget rules: got %+v", imp)
+	}
+	if imp := MatchGoImport(ParseAll([]string{"Redirects goget to https://git.example.com/repo"}), "example.com", "/"); imp == nil || imp.Dir != "" {
+		t.Errorf("unknown forge: want no go-source, got %+v", imp)
+	}
+}
+
+func TestHandlerGoGet(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"go.example.com": {"Redirects goget to https://github.com/org/repo"},
+	}))
+	req := httptest.NewRequest("GET", "http://go.example.com/sub/pkg?go-get=1", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, 200)
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<meta name="go-import" content="go.example.com git https://github.com/org/repo">`,
+		`<meta name="go-source" content="go.example.com https://github.com/org/repo https://github.com/org/repo/tree/HEAD{/dir} https://github.com/org/repo/blob/HEAD{/dir}/{file}#L{line}">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("lacks %s:\n%s", want, body)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "http://go.example.com/sub/pkg", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, 302)
+	assertEqual(t, rr.Header().Get("Location"), "https://github.com/org/repo")
+}
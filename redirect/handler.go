@@ -0,0 +1,330 @@
+package redirect
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultFallbackURL is where requests are sent when a host has no usable
+// redirect configuration.
+const DefaultFallbackURL = "http://redirect.name/"
+
+type handler struct {
+	resolver        Resolver
+	fallbackURL     string
+	permanentMaxAge time.Duration
+	sourceHeader    bool
+	serverTiming    bool
+	checks          []TargetChecker
+	pages           *Pages
+	fallbackPage    bool
+	tracer          Tracer
+	isBot           func(*http.Request) bool
+	previews        bool
+	previewer       Previewer
+	webFinger       *http.Client
+	documents       documents
+	ignored         []string
+	ignoredStatus   int
+	qrCodes         bool
+	credentials     credentials
+	cors            []string
+	forceHTTPS      bool
+	hstsMaxAge      time.Duration
+	ruleLimits      RuleLimits
+
+	// permanentCacheControl is the Cache-Control of permanent redirects,
+	// formatted once from permanentMaxAge.
+	permanentCacheControl string
+	// shadow, if set, is evaluated alongside MatchRequest, and
+	// reportDivergence called when they disagree.
+	shadow           Matcher
+	reportDivergence func(*http.Request, Divergence)
+}
+
+// An Option configures a handler returned by NewHandler.
+type Option func(*handler)
+
+// WithResolver sets the Resolver used to look up each request's host. The
+// default is DefaultResolver.
+func WithResolver(resolver Resolver) Option {
+	return func(h *handler) { h.resolver = resolver }
+}
+
+// WithFallbackURL sets where requests are redirected when their host can't
+// be resolved or no rule matches. The failure reason is appended as a
+// #reason= fragment, followed by &request_id= if the request has an ID (see
+// WithRequestID). An empty url keeps DefaultFallbackURL.
+func WithFallbackURL(url string) Option {
+	return func(h *handler) {
+		if url != "" {
+			h.fallbackURL = url
+		}
+	}
+}
+
+// WithPermanentMaxAge sets the Cache-Control max-age sent with permanent
+// (301 and 308) redirects. Zero disables the header. The default is 24h.
+func WithPermanentMaxAge(d time.Duration) Option {
+	return func(h *handler) { h.permanentMaxAge = d }
+}
+
+// WithSourceHeader adds an X-Redirect-Source response header naming the
+// Layers source that answered, so operators can see where a host's
+// configuration came from.
+func WithSourceHeader() Option {
+	return func(h *handler) { h.sourceHeader = true }
+}
+
+// WithServerTiming adds a Server-Timing response header with the config
+// lookup's duration (described by its source, and with "cache" if a Cache
+// answered) and the total time handling the request, so site owners can see
+// in browser devtools how much the redirect hop costs.
+func WithServerTiming() Option {
+	return func(h *handler) { h.serverTiming = true }
+}
+
+// NewHandler returns an http.Handler that redirects each request according
+// to the rules configured for its Host, so the redirect logic can be mounted
+// in an existing mux or wrapped with middleware.
+func NewHandler(opts ...Option) http.Handler {
+	h := &handler{
+		resolver:        DefaultResolver,
+		fallbackURL:     DefaultFallbackURL,
+		permanentMaxAge: 24 * time.Hour,
+		isBot:           isBotRequest,
+		ignoredStatus:   http.StatusNotFound,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.pages == nil {
+		h.pages = DefaultPages()
+	}
+	h.permanentCacheControl = fmt.Sprintf("max-age=%d", int(h.permanentMaxAge.Seconds()))
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begun := time.Now()
+	host, err := ParseHost(r.Host)
+	if err != nil {
+		http.Error(w, "Invalid Host header", http.StatusBadRequest)
+		return
+	}
+
+	if h.ignoredPath(r.URL.Path) {
+		h.serveIgnored(w, nil)
+		return
+	}
+
+	ctx := withWellKnownFetch(r.Context(), r)
+	info := LookupInfoFrom(ctx)
+	if info == nil {
+		info = new(LookupInfo)
+		ctx = WithLookupInfo(ctx, info)
+	}
+	info.Host = host
+	if h.tracer != nil {
+		ctx = withTracer(ctx, h.tracer)
+	}
+	lookupCtx, span := startSpan(ctx, "redirect.lookup")
+	span.SetAttribute("redirect.host", host)
+	start := time.Now()
+	rules, err := h.resolver.LookupConfig(lookupCtx, host)
+	info.Duration = time.Since(start)
+	if info.Source != "" {
+		span.SetAttribute("redirect.source", info.Source)
+	}
+	span.SetAttribute("redirect.cache_hit", info.Cached)
+	span.End(err)
+	if h.sourceHeader && info.Source != "" {
+		w.Header().Set("X-Redirect-Source", info.Source)
+	}
+	if errors.Is(err, ErrOverloaded) {
+		h.setServerTiming(w, begun, info)
+		serveOverloaded(w)
+		return
+	}
+	if err != nil {
+		h.setServerTiming(w, begun, info)
+		h.fallback(w, r, host, fmt.Sprintf("Could not resolve hostname (%v)", err))
+		return
+	}
+	if err := h.ruleLimits.Check(rules); err != nil {
+		h.setServerTiming(w, begun, info)
+		h.fallback(w, r, host, err.Error())
+		return
+	}
+
+	if h.forcesHTTPS(rules) {
+		if RequestScheme(r) != "https" {
+			h.setServerTiming(w, begun, info)
+			redirectHTTPS(w, r, host)
+			return
+		}
+		h.setHSTS(w)
+	}
+	if origins := h.corsOrigins(rules); len(origins) > 0 {
+		if isPreflight(r) {
+			h.setServerTiming(w, begun, info)
+			servePreflight(w, r, origins)
+			return
+		}
+		allowOrigin(w, r, origins)
+	}
+	if h.qrCodes && r.URL.Path == QRPath {
+		h.setServerTiming(w, begun, info)
+		h.serveQR(ctx, w, r, host, rules)
+		return
+	}
+	if body, ok := textFile(rules, r.URL.Path, time.Now()); ok {
+		h.setServerTiming(w, begun, info)
+		h.serveTextFile(w, body)
+		return
+	}
+	if rule := MatchServes(rules, r.URL.Path); rule != nil {
+		info.Rule = rule
+		h.setServerTiming(w, begun, info)
+		h.serveBody(ctx, w, rule)
+		return
+	}
+	if r.URL.Path == WebFingerPath {
+		if delegate := FlagValue(rules, "webfinger"); delegate != "" {
+			h.setServerTiming(w, begun, info)
+			h.serveWebFinger(ctx, w, r, delegate)
+			return
+		}
+	}
+	if rule := MatchIgnores(rules, r.URL.Path); rule != nil {
+		info.Rule = rule
+		h.setServerTiming(w, begun, info)
+		h.serveIgnored(w, rule)
+		return
+	}
+	// Parsing the query costs allocations, so only for requests that
+	// might be go get's.
+	if strings.Contains(r.URL.RawQuery, "go-get") && r.URL.Query().Get("go-get") == "1" {
+		if imp := MatchGoImport(rules, host, r.URL.Path); imp != nil {
+			info.Rule = imp.Rule
+			h.setServerTiming(w, begun, info)
+			h.serveGoImport(w, imp)
+			return
+		}
+	}
+
+	_, span = startSpan(ctx, "redirect.translate")
+	info.Bot = h.isBot(r)
+	target, err := MatchRequest(rules, r, info.Bot)
+	if h.shadow != nil {
+		h.compareShadow(r, host, rules, info.Bot, target, err)
+	}
+	for _, name := range conditionHeaders(rules) {
+		w.Header().Add("Vary", name)
+	}
+	if _, traced := span.(noopSpan); !traced && err == nil && target.Rule != nil {
+		span.SetAttribute("redirect.rule", target.Rule.String())
+	}
+	span.End(err)
+	var methodErr *MethodError
+	if errors.As(err, &methodErr) {
+		h.setServerTiming(w, begun, info)
+		w.Header().Set("Allow", strings.Join(methodErr.Allow, ", "))
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.setServerTiming(w, begun, info)
+		h.fallback(w, r, host, err.Error())
+		return
+	}
+	info.Rule = target.Rule
+	if requires := target.Rule.Requires; requires != "" {
+		if !hasKey(r, requires) {
+			h.setServerTiming(w, begun, info)
+			h.pages.locked(w, r, host, http.StatusForbidden, "this link is missing its key or has the wrong one")
+			return
+		}
+		if t := Translate(withoutKey(r.URL, requires), target.Rule); t != nil {
+			target = t
+		}
+	}
+	if target.Rule.Protected != "" && !h.credentials.check(r, target.Rule.Protected) {
+		h.setServerTiming(w, begun, info)
+		h.challenge(w, r, host)
+		return
+	}
+	location := asciiLocation(absoluteLocation(r, target.Location))
+	if len(h.checks) > 0 {
+		checked := checkedLocation(r, location)
+		for _, c := range h.checks {
+			if err := c.CheckTarget(ctx, checked); err != nil {
+				h.setServerTiming(w, begun, info)
+				h.pages.blocked(w, r, host, checked, err)
+				return
+			}
+		}
+	}
+	switch {
+	case target.Rule.Requires != "" || target.Rule.Protected != "" || target.Rule.Cookie != "":
+		w.Header().Set("Cache-Control", "private, no-store")
+	case h.permanentMaxAge > 0 && (target.Status == http.StatusMovedPermanently || target.Status == http.StatusPermanentRedirect):
+		w.Header().Set("Cache-Control", h.permanentCacheControl)
+	}
+	h.setServerTiming(w, begun, info)
+	if h.previews && IsLinkPreviewer(r.UserAgent()) {
+		h.servePreview(ctx, w, r, host, rules, location)
+		return
+	}
+	if target.Rule.Cookie != "" {
+		setCookie(w, r, host, target.Rule)
+	}
+	http.Redirect(w, r, location, target.Status)
+}
+
+// setServerTiming sets the Server-Timing header, if enabled, from the
+// lookup recorded in info and the time since begun.
+func (h *handler) setServerTiming(w http.ResponseWriter, begun time.Time, info *LookupInfo) {
+	if !h.serverTiming {
+		return
+	}
+	metrics := []string{fmt.Sprintf("dns;dur=%.2f", milliseconds(info.Duration))}
+	if info.Source != "" {
+		metrics[0] += fmt.Sprintf(";desc=%q", info.Source)
+	}
+	if info.Cached {
+		metrics = append(metrics, `cache;desc="hit"`)
+	}
+	metrics = append(metrics, fmt.Sprintf("total;dur=%.2f", milliseconds(time.Since(begun))))
+	w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (h *handler) fallback(w http.ResponseWriter, r *http.Request, host, reason string) {
+	if h.fallbackPage {
+		h.pages.render(w, "fallback.html", PageData{
+			Status:     http.StatusNotFound,
+			Title:      "No redirect configured",
+			Host:       host,
+			RecordName: RecordName(host),
+			Reason:     reason,
+			RequestID:  RequestIDFrom(r.Context()),
+		})
+		return
+	}
+	location := h.fallbackURL
+	if reason != "" {
+		location = fmt.Sprintf("%s#reason=%s", location, url.QueryEscape(reason))
+		if id := RequestIDFrom(r.Context()); id != "" {
+			location += "&request_id=" + url.QueryEscape(id)
+		}
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+}
@@ -0,0 +1,179 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler301CacheControl(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{"go.example.com": []string{"Redirects permanently to https://example.com/"}}))
+
+	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMovedPermanently {
+		t.Errorf("expected 301, got %d", rr.Code)
+	}
+	cc := rr.Header().Get("Cache-Control")
+	if cc == "" {
+		t.Error("expected Cache-Control header on 301, got none")
+	}
+}
+
+func TestHandler308CacheControl(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://example.com/ with 308"}}))
+
+	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPermanentRedirect {
+		t.Errorf("expected 308, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc == "" {
+		t.Error("expected Cache-Control header on 308, got none")
+	}
+}
+
+func TestHandler302NoCacheControl(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://example.com/"}}))
+
+	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Errorf("expected 302, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("expected no Cache-Control on 302, got %q", cc)
+	}
+}
+
+func TestHandler307NoCacheControl(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://example.com/ with 307"}}))
+
+	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected 307, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("expected no Cache-Control on 307, got %q", cc)
+	}
+}
+
+func TestHandlerPermanentMaxAge(t *testing.T) {
+	resolver := StaticResolver{"go.example.com": {"Redirects permanently to https://example.com/"}}
+
+	h := NewHandler(WithResolver(resolver), WithPermanentMaxAge(time.Hour))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	assertEqual(t, rr.Header().Get("Cache-Control"), "max-age=3600")
+
+	h = NewHandler(WithResolver(resolver), WithPermanentMaxAge(0))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	assertEqual(t, rr.Header().Get("Cache-Control"), "")
+}
+
+func TestHandlerFallback(t *testing.T) {
+	resolver := ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		if host == "broken.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return ParseAll([]string{"Redirects from /docs to https://example.com/docs"}), nil
+	})
+	h := NewHandler(WithResolver(resolver), WithFallbackURL("https://fallback.example/"))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://broken.example.com/", nil))
+	assertEqual(t, rr.Code, http.StatusFound)
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "https://fallback.example/#reason=Could+not+resolve") {
+		t.Errorf("unexpected fallback Location %q", loc)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com:8080/other", nil))
+	assertEqual(t, rr.Code, http.StatusFound)
+	assertEqual(t, rr.Header().Get("Location"), "https://fallback.example/#reason=No+paths+matched")
+
+	rr = httptest.NewRecorder()
+	NewHandler(WithResolver(resolver)).ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/other", nil))
+	if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, DefaultFallbackURL) {
+		t.Errorf("expected default fallback, got %q", loc)
+	}
+}
+
+func TestHandlerSourceHeader(t *testing.T) {
+	layers := NewLayers(Source{Name: "file", Resolver: StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}})
+
+	rr := httptest.NewRecorder()
+	NewHandler(WithResolver(layers), WithSourceHeader()).ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	assertEqual(t, rr.Header().Get("X-Redirect-Source"), "file")
+
+	rr = httptest.NewRecorder()
+	NewHandler(WithResolver(layers)).ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	assertEqual(t, rr.Header().Get("X-Redirect-Source"), "")
+}
+
+func TestHandlerServerTiming(t *testing.T) {
+	layers := NewLayers(Source{Name: "dns", Resolver: NewCache(StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}, time.Minute)})
+	h := NewHandler(WithResolver(layers), WithServerTiming())
+	timing := regexp.MustCompile(`^dns;dur=\d+\.\d\d;desc="dns", (cache;desc="hit", )?total;dur=\d+\.\d\d$`)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if got := rr.Header().Get("Server-Timing"); !timing.MatchString(got) || strings.Contains(got, "cache") {
+		t.Errorf("first request: got %q", got)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if got := rr.Header().Get("Server-Timing"); !timing.MatchString(got) || !strings.Contains(got, "cache") {
+		t.Errorf("cached request: got %q", got)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://none.example.com/", nil))
+	if rr.Header().Get("Server-Timing") == "" {
+		t.Error("fallback: want Server-Timing")
+	}
+
+	rr = httptest.NewRecorder()
+	NewHandler(WithResolver(layers)).ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	assertEqual(t, rr.Header().Get("Server-Timing"), "")
+}
+
+// BenchmarkHandler measures a redirect decided from cached rules: the
+// path every request to a busy host takes.
+func BenchmarkHandler(b *testing.B) {
+	rules := ParseAll([]string{
+		"Redirects from /blog/* to https://blog.example.com/*",
+		"Redirects from /docs/* to https://docs.example.com/* permanently",
+		"Redirects to https://example.com/",
+	})
+	h := NewHandler(WithResolver(ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		return rules, nil
+	})))
+	req := httptest.NewRequest("GET", "http://go.example.com/docs/getting-started?lang=en", nil)
+	b.ReportAllocs()
+	for b.Loop() {
+		h.ServeHTTP(discardWriter{header: make(http.Header)}, req)
+	}
+}
+
+// discardWriter is a ResponseWriter keeping nothing but its header, so a
+// benchmark measures the handler rather than a recorder.
+type discardWriter struct{ header http.Header }
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) WriteHeader(int)             {}
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
@@ -0,0 +1,42 @@
+package redirect
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var headerRE = regexp.MustCompile(`\s+when\s+header\s+([A-Za-z0-9-]+)\s+is\s+(\S+)`)
+
+// headerCondition returns the Header of a rule with a "when header <name>
+// is <value>" clause, with the name canonicalized.
+func headerCondition(name, value string) string {
+	return http.CanonicalHeaderKey(name) + "=" + value
+}
+
+// headerMatches reports whether header meets r's header condition.
+func (r *Rule) headerMatches(header http.Header) bool {
+	name, value, _ := strings.Cut(r.Header, "=")
+	return slices.Contains(header.Values(name), value)
+}
+
+// MatchRequest is like MatchMethod for r, and also applies rules with a
+// header condition, as in "Redirects when header X-Env is staging to ...":
+// those r's headers meet are tried before the rules without one, so a
+// hostname can send internal traffic elsewhere.
+func MatchRequest(rules []*Rule, r *http.Request, bot bool) (*Redirect, error) {
+	return matchRequest(rules, r.Method, r.URL.String(), r.Header, bot, Translate)
+}
+
+// conditionHeaders returns the names of the headers rules' conditions
+// look at, for the Vary header of responses they decided.
+func conditionHeaders(rules []*Rule) []string {
+	var names []string
+	for _, rule := range rules {
+		if name, _, _ := strings.Cut(rule.Header, "="); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
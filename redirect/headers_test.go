@@ -0,0 +1,47 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaderCondition(t *testing.T) {
+	rule := Parse("Redirects when header x-env is staging to https://staging.example.com/*")
+	if rule == nil || rule.Header != "X-Env=staging" || rule.To != "https://staging.example.com/*" || rule.From != "" {
+		t.Fatalf("got %+v", rule)
+	}
+	assertEqual(t, rule.String(), "Redirects when header X-Env is staging to https://staging.example.com/*")
+
+	rule = Parse("Redirects POST from /api/* when header X-Env is staging to https://staging.example.com/* with 307")
+	if rule == nil || rule.Header != "X-Env=staging" || rule.Methods != "POST" || rule.From != "/api/*" || rule.RedirectState != "307" {
+		t.Fatalf("got %+v", rule)
+	}
+	if again := Parse(rule.String()); again == nil || *again != *rule {
+		t.Errorf("Parse(%q) = %+v", rule.String(), again)
+	}
+}
+
+func TestHandlerHeaderCondition(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {
+			"Redirects from /docs to https://docs.example.com/",
+			"Redirects from /* when header X-Env is staging to https://staging.example.com/*",
+			"Redirects to https://www.example.com/",
+		},
+	}))
+	get := func(path, env string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if env != "" {
+			req.Header.Set("X-Env", env)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/docs", "staging")
+	assertEqual(t, rr.Header().Get("Location"), "https://staging.example.com/docs")
+	assertEqual(t, rr.Header().Get("Vary"), "X-Env")
+	assertEqual(t, get("/docs", "").Header().Get("Location"), "https://docs.example.com/")
+	assertEqual(t, get("/about", "production").Header().Get("Location"), "https://www.example.com/")
+}
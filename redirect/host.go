@@ -0,0 +1,84 @@
+package redirect
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+// ErrInvalidHost is returned by ParseHost for Host headers that aren't a
+// hostname or IP address with an optional port.
+var ErrInvalidHost = errors.New("invalid host")
+
+// ParseHost returns the hostname in a Host header, without its port or a
+// trailing dot and lowercased, so "Go.Example.com.:8080" becomes
+// "go.example.com". IPv6 literals must be bracketed and are returned
+// without brackets. Internationalized names are converted to punycode with
+// ASCIIHost. IP addresses are looked up like any other host, so a
+// file or static source can configure requests made by address.
+func ParseHost(hostport string) (string, error) {
+	if rest, ok := strings.CutPrefix(hostport, "["); ok {
+		host, port, ok := strings.Cut(rest, "]")
+		if !ok || (port != "" && (port[0] != ':' || !validPort(port[1:]))) {
+			return "", ErrInvalidHost
+		}
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is6() || addr.Zone() != "" {
+			return "", ErrInvalidHost
+		}
+		return strings.ToLower(host), nil
+	}
+
+	host := hostport
+	if i := strings.LastIndexByte(hostport, ':'); i >= 0 {
+		if !validPort(hostport[i+1:]) {
+			return "", ErrInvalidHost
+		}
+		host = hostport[:i]
+	}
+	host, err := ASCIIHost(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", ErrInvalidHost
+	}
+	// Only digits and dots can be an IPv4 address; parsing hostnames as
+	// one would cost an error every request.
+	if strings.Trim(host, "0123456789.") == "" {
+		if addr, err := netip.ParseAddr(host); err == nil && addr.Is4() {
+			return host, nil
+		}
+	}
+	if !validHostname(host) {
+		return "", ErrInvalidHost
+	}
+	return host, nil
+}
+
+func validPort(port string) bool {
+	if port == "" || len(port) > 5 {
+		return false
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHostname reports whether host is made of DNS labels of letters,
+// digits, hyphens and underscores.
+func validHostname(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for label := range strings.SplitSeq(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"go.example.com":        "go.example.com",
+		"Go.Example.COM.:8080":  "go.example.com",
+		"go.example.com.":       "go.example.com",
+		"_dmarc.example.com":    "_dmarc.example.com",
+		"127.0.0.1:80":          "127.0.0.1",
+		"[::1]":                 "::1",
+		"[2001:DB8::1]:443":     "2001:db8::1",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+	} {
+		got, err := ParseHost(in)
+		if err != nil || got != want {
+			t.Errorf("ParseHost(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{
+		"",
+		":80",
+		"go.example.com:",
+		"go.example.com:http",
+		"go..example.com",
+		"-go.example.com",
+		"go.example.com/path",
+		"user@go.example.com",
+		"::1",
+		"[::1",
+		"[::1]x",
+		"[::1]8080",
+		"[127.0.0.1]",
+		"[fe80::1%25eth0]",
+	} {
+		if got, err := ParseHost(in); err == nil {
+			t.Errorf("ParseHost(%q) = %q; want an error", in, got)
+		}
+	}
+}
+
+func TestHandlerInvalidHost(t *testing.T) {
+	var lookups int
+	h := NewHandler(WithResolver(ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		lookups++
+		return ParseAll([]string{"Redirects to https://example.com/"}), nil
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "bad host"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || lookups != 0 {
+		t.Errorf("want 400 without a lookup, got %d after %d lookups", rr.Code, lookups)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Host = "[2001:db8::1]:8080"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Errorf("IPv6 literal: want 302, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package redirect
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// FlagForceHTTPS makes a host's plain-HTTP requests redirect to HTTPS
+// before its rules apply; FlagNoForceHTTPS exempts a host from
+// WithForceHTTPS.
+const (
+	FlagForceHTTPS   = "https=force"
+	FlagNoForceHTTPS = "https=off"
+)
+
+// WithForceHTTPS redirects plain-HTTP requests to every host to the same
+// URL over HTTPS, before the host's rules apply, so the redirect to the
+// destination always happens over TLS. Hosts opt out with an "https=off"
+// record; without this option, hosts opt in with "https=force".
+func WithForceHTTPS() Option {
+	return func(h *handler) { h.forceHTTPS = true }
+}
+
+// WithHSTS sets Strict-Transport-Security with maxAge on HTTPS responses
+// for hosts whose plain-HTTP requests are redirected to HTTPS, so browsers
+// skip the plain-HTTP hop next time.
+func WithHSTS(maxAge time.Duration) Option {
+	return func(h *handler) { h.hstsMaxAge = maxAge }
+}
+
+// setHSTS sets Strict-Transport-Security, if enabled.
+func (h *handler) setHSTS(w http.ResponseWriter) {
+	if h.hstsMaxAge > 0 {
+		w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(h.hstsMaxAge.Seconds())))
+	}
+}
+
+// forcesHTTPS reports whether the host with rules has its plain-HTTP
+// requests redirected to HTTPS.
+func (h *handler) forcesHTTPS(rules []*Rule) bool {
+	if h.forceHTTPS {
+		return !HasFlag(rules, FlagNoForceHTTPS)
+	}
+	return HasFlag(rules, FlagForceHTTPS)
+}
+
+// redirectHTTPS redirects a plain-HTTP request to the same URL on host
+// over HTTPS. GET and HEAD requests get 301, others 308 so their method
+// and body are kept.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request, host string) {
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, asciiLocation("https://"+host+r.URL.RequestURI()), status)
+}
@@ -0,0 +1,42 @@
+package redirect
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerForceHTTPS(t *testing.T) {
+	resolver := StaticResolver{
+		"example.com": {"https=force", "Redirects to https://example.org/"},
+		"plain.com":   {"Redirects to https://example.org/"},
+		"exempt.com":  {"https=off", "Redirects to https://example.org/"},
+	}
+	serve := func(h *handler, method, host, path string, secure bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Host = host
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	h := NewHandler(WithResolver(resolver), WithHSTS(24*time.Hour)).(*handler)
+	rr := serve(h, "GET", "example.com", "/a?b=c", false)
+	assertEqual(t, rr.Code, 301)
+	assertEqual(t, rr.Header().Get("Location"), "https://example.com/a?b=c")
+	assertEqual(t, serve(h, "POST", "example.com", "/form", false).Code, 308)
+
+	rr = serve(h, "GET", "example.com", "/a", true)
+	assertEqual(t, rr.Header().Get("Location"), "https://example.org/")
+	assertEqual(t, rr.Header().Get("Strict-Transport-Security"), "max-age=86400")
+	assertEqual(t, serve(h, "GET", "plain.com", "/", false).Header().Get("Location"), "https://example.org/")
+
+	h = NewHandler(WithResolver(resolver), WithForceHTTPS()).(*handler)
+	assertEqual(t, serve(h, "GET", "plain.com", "/", false).Header().Get("Location"), "https://plain.com/")
+	assertEqual(t, serve(h, "GET", "exempt.com", "/", false).Header().Get("Location"), "https://example.org/")
+	assertEqual(t, serve(h, "GET", "plain.com", "/", true).Header().Get("Strict-Transport-Security"), "")
+}
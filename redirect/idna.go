@@ -0,0 +1,49 @@
+package redirect
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ASCIIHost returns host in the lowercased ASCII form used in DNS, with
+// internationalized labels converted to punycode, so "Bücher.example"
+// becomes "xn--bcher-kva.example". ASCII hosts are only lowercased.
+func ASCIIHost(host string) (string, error) {
+	if isASCII(host) {
+		return strings.ToLower(host), nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// asciiLocation converts an internationalized host in location to punycode,
+// as Location headers must be ASCII; the rest of it is escaped by
+// http.Redirect. Locations it can't convert are returned unchanged.
+func asciiLocation(location string) string {
+	if isASCII(location) {
+		return location
+	}
+	u, err := url.Parse(location)
+	if err != nil || isASCII(u.Host) {
+		return location
+	}
+	host, err := ASCIIHost(u.Hostname())
+	if err != nil {
+		return location
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String()
+}
@@ -0,0 +1,42 @@
+package redirect
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestASCIIHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"Bücher.example":   "xn--bcher-kva.example",
+		"go.EXAMPLE.com":   "go.example.com",
+		"_sub.example.com": "_sub.example.com",
+		"ドメイン.テスト":         "xn--eckwd4c7c.xn--zckzah",
+	} {
+		if got, err := ASCIIHost(in); err != nil || got != want {
+			t.Errorf("ASCIIHost(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if got := RecordName("bücher.example"); got != "_redirect.xn--bcher-kva.example" {
+		t.Errorf("RecordName: got %q", got)
+	}
+}
+
+func TestHandlerIDN(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"bücher.example": []string{"Redirects to https://straße.example:8443/bücher?q=ü"},
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "xn--bcher-kva.example"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	want := "https://xn--strae-oqa.example:8443/b%C3%BCcher?q=%c3%bc"
+	if loc := rr.Header().Get("Location"); loc != want {
+		t.Errorf("Location: want %q, got %q (%d)", want, loc, rr.Code)
+	}
+
+	rules, err := StaticResolver{"bücher.example": []string{"Redirects to /"}}.LookupConfig(context.Background(), "xn--bcher-kva.example")
+	if err != nil || len(rules) != 1 {
+		t.Errorf("punycode lookup of a Unicode key: got %v, %v", rules, err)
+	}
+}
@@ -0,0 +1,76 @@
+package redirect
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ignoresRE = regexp.MustCompile(`^\s*Ignores\s+(/\S*)(?:\s+with\s+(204|404))?\s*$`)
+
+// parseIgnores parses "Ignores /api/*" and "Ignores /healthz with 204"
+// into a rule excluding paths from redirection, or returns nil.
+func parseIgnores(record string) *Rule {
+	m := ignoresRE.FindStringSubmatch(record)
+	if m == nil || strings.Count(m[1], "*") > 1 {
+		return nil
+	}
+	return &Rule{From: m[1], Ignores: true, RedirectState: m[2]}
+}
+
+// matchPattern reports whether path matches pattern, a path with at most
+// one * standing for any run of characters.
+func matchPattern(pattern, path string) bool {
+	before, after, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return path == pattern
+	}
+	return len(path) >= len(before)+len(after) && strings.HasPrefix(path, before) && strings.HasSuffix(path, after)
+}
+
+// MatchIgnores returns the Ignores rule among rules matching path, or nil.
+func MatchIgnores(rules []*Rule, path string) *Rule {
+	for _, rule := range rules {
+		if rule.Ignores && matchPattern(rule.From, path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// WithIgnoredPaths excludes paths matching patterns (such as /api/*) on
+// every host from redirection, answering them with status, 404 or 204,
+// without looking the host up. status is also the answer of a host's
+// "Ignores" rules that don't give one. The default is 404.
+func WithIgnoredPaths(status int, patterns ...string) Option {
+	return func(h *handler) {
+		h.ignoredStatus = status
+		h.ignored = patterns
+	}
+}
+
+// ignoredPath reports whether path matches one of the server's ignored
+// patterns.
+func (h *handler) ignoredPath(path string) bool {
+	for _, p := range h.ignored {
+		if matchPattern(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveIgnored answers a request for an ignored path with rule's status,
+// or the default if rule is nil or gives none.
+func (h *handler) serveIgnored(w http.ResponseWriter, rule *Rule) {
+	status := h.ignoredStatus
+	if rule != nil && rule.RedirectState != "" {
+		status, _ = strconv.Atoi(rule.RedirectState)
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	http.Error(w, "Not redirected", http.StatusNotFound)
+}
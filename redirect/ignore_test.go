@@ -0,0 +1,61 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/api/*", "/api/", true},
+		{"/api/*", "/api/v1/users", true},
+		{"/api/*", "/api", false},
+		{"/healthz", "/healthz", true},
+		{"/healthz", "/healthz/", false},
+		{"/*.txt", "/google123.txt", true},
+		{"/*.txt", "/a.html", false},
+		{"/ab*ba", "/aba", false},
+	}
+	for _, c := range cases {
+		if got := matchPattern(c.pattern, c.path); got != c.want {
+			t.Errorf("%s ~ %s: got %v", c.pattern, c.path, got)
+		}
+	}
+}
+
+func TestParseIgnores(t *testing.T) {
+	rule := Parse("Ignores /api/* with 204")
+	if rule == nil || !rule.Ignores || rule.From != "/api/*" || rule.RedirectState != "204" || rule.To != "" {
+		t.Fatalf("got %+v", rule)
+	}
+	assertEqual(t, rule.String(), "Ignores /api/* with 204")
+	assertEqual(t, Parse("Ignores /.well-known/*").String(), "Ignores /.well-known/*")
+	for _, bad := range []string{"Ignores api/*", "Ignores /a/*/b/*", "Ignores /x with 302"} {
+		if rule := Parse(bad); rule != nil && rule.Ignores {
+			t.Errorf("%s: got %+v", bad, rule)
+		}
+	}
+}
+
+func TestHandlerIgnores(t *testing.T) {
+	resolver := StaticResolver{
+		"example.com": {"Ignores /api/*", "Ignores /ping with 204", "Redirects to https://example.org/*"},
+	}
+	get := func(h *handler, host, path string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://"+host+path, nil))
+		return rr.Code
+	}
+	h := NewHandler(WithResolver(resolver)).(*handler)
+	assertEqual(t, get(h, "example.com", "/api/users"), 404)
+	assertEqual(t, get(h, "example.com", "/ping"), 204)
+	assertEqual(t, get(h, "example.com", "/docs"), 302)
+
+	h = NewHandler(WithResolver(resolver), WithIgnoredPaths(204, "/.well-known/acme-challenge/*")).(*handler)
+	assertEqual(t, get(h, "example.com", "/api/users"), 204)
+	assertEqual(t, get(h, "unconfigured.example", "/.well-known/acme-challenge/token"), 204)
+	assertEqual(t, get(h, "unconfigured.example", "/"), 302)
+}
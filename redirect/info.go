@@ -0,0 +1,56 @@
+package redirect
+
+import (
+	"context"
+	"time"
+)
+
+// LookupInfo records how a host's configuration was found. Attach one to a
+// context with WithLookupInfo before calling a Resolver; resolvers that know
+// more than the rules themselves fill in its fields. The handler returned
+// by NewHandler fills in the one attached to its request's context, if any,
+// so middleware can log what it did.
+type LookupInfo struct {
+	// Host is the host the handler looked up, so that a TargetChecker
+	// can tell which host's redirect it's vetting.
+	Host string
+	// Source is the name of the Layers source that answered.
+	Source string
+	// Cached is set when a Cache answered without asking its resolver.
+	Cached bool
+	// Duration is how long the handler's lookup took.
+	Duration time.Duration
+	// Rule is the rule the handler matched, if any.
+	Rule *Rule
+	// Bot is set when the handler classified the request as coming from a
+	// bot (see WithBotClassifier).
+	Bot bool
+}
+
+type lookupInfoKey struct{}
+
+// WithLookupInfo returns a copy of ctx carrying info.
+func WithLookupInfo(ctx context.Context, info *LookupInfo) context.Context {
+	return context.WithValue(ctx, lookupInfoKey{}, info)
+}
+
+// LookupInfoFrom returns the LookupInfo attached to ctx, or nil.
+func LookupInfoFrom(ctx context.Context) *LookupInfo {
+	info, _ := ctx.Value(lookupInfoKey{}).(*LookupInfo)
+	return info
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying a request's ID. The handler
+// returned by NewHandler shows it on the pages it serves and appends it to
+// fallback URLs, so visitors can quote it when reporting a problem.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID attached to ctx, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,60 @@
+package redirect
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	requiringRE = regexp.MustCompile(`\s+requiring\s+([A-Za-z0-9_.-]+=\S+)`)
+	// requiringKeywordRE finds a requiring clause requiringRE can't parse.
+	requiringKeywordRE = regexp.MustCompile(`\s+requiring(\s|$)`)
+)
+
+// keyParts splits a rule's Requires into the query parameter and its
+// value.
+func keyParts(requires string) (name, value string) {
+	name, value, _ = strings.Cut(requires, "=")
+	return name, value
+}
+
+// hasKey reports whether r carries the key requires asks for, as the
+// query parameter it names or as an "Authorization: Bearer" header.
+func hasKey(r *http.Request, requires string) bool {
+	name, value := keyParts(requires)
+	given := r.URL.Query().Get(name)
+	if given == "" {
+		given, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(value)) == 1
+}
+
+// withoutKey returns u as a request URI without the query parameter
+// requires names, so the key isn't passed on to the destination.
+func withoutKey(u *url.URL, requires string) string {
+	name, _ := keyParts(requires)
+	q := u.Query()
+	if !q.Has(name) {
+		return u.RequestURI()
+	}
+	q.Del(name)
+	stripped := *u
+	stripped.RawQuery = q.Encode()
+	return stripped.RequestURI()
+}
+
+// locked serves the page for a request lacking a rule's key or password,
+// with status and reason.
+func (p *Pages) locked(w http.ResponseWriter, r *http.Request, host string, status int, reason string) {
+	p.render(w, "locked.html", PageData{
+		Status:     status,
+		Title:      "Link locked",
+		Host:       host,
+		RecordName: RecordName(host),
+		Reason:     reason,
+		RequestID:  RequestIDFrom(r.Context()),
+	})
+}
@@ -0,0 +1,67 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRequiring(t *testing.T) {
+	rule := Parse("Redirects from /private/* to https://example.com/files/* requiring key=s3cr3t permanently")
+	if rule == nil || rule.Requires != "key=s3cr3t" || rule.From != "/private/*" || rule.To != "https://example.com/files/*" || rule.RedirectState != "permanently" {
+		t.Fatalf("got %+v", rule)
+	}
+	if s := rule.String(); strings.Contains(s, "s3cr3t") || !strings.Contains(s, "requiring key=REDACTED") {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, record := range []string{
+		"Redirects to https://example.com/ requiring SECRET",
+		"Redirects to https://example.com/ requiring key=",
+		"Redirects to https://example.com/ requiring key= permanently",
+		"Redirects to https://example.com/ requiring",
+	} {
+		if rule := Parse(record); rule != nil {
+			t.Errorf("Parse(%q) = %+v, want nil", record, rule)
+		}
+	}
+	if rule := Parse("Redirects to https://example.com/requiring/"); rule == nil {
+		t.Error("a URL containing requiring isn't a requiring clause")
+	}
+}
+
+func TestHandlerRequiring(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {"Redirects from /private/* to https://files.example.org/* requiring key=s3cr3t permanently", "Redirects to https://example.org/"},
+	}))
+	get := func(target, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, c := range []struct{ target, auth string }{
+		{"/private/report.pdf", ""},
+		{"/private/report.pdf?key=wrong", ""},
+		{"/private/report.pdf", "Bearer wrong"},
+	} {
+		rr := get(c.target, c.auth)
+		assertEqual(t, rr.Code, 403)
+		if strings.Contains(rr.Body.String(), "files.example.org") {
+			t.Errorf("%s: the locked page gives the destination away", c.target)
+		}
+	}
+
+	rr := get("/private/report.pdf?key=s3cr3t&v=2", "")
+	assertEqual(t, rr.Code, 301)
+	assertEqual(t, rr.Header().Get("Location"), "https://files.example.org/report.pdf?v=2")
+	assertEqual(t, rr.Header().Get("Cache-Control"), "private, no-store")
+
+	rr = get("/private/report.pdf", "Bearer s3cr3t")
+	assertEqual(t, rr.Header().Get("Location"), "https://files.example.org/report.pdf")
+	assertEqual(t, get("/public", "").Code, 302)
+}
@@ -0,0 +1,104 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// A Source is a named Resolver in a Layers precedence chain.
+type Source struct {
+	Name     string
+	Resolver Resolver
+}
+
+// SourceStats counts the outcomes of lookups sent to one Source.
+type SourceStats struct {
+	Name string
+	// Answered counts lookups the source answered, ending the chain.
+	Answered uint64
+	// NotFound counts lookups passed on to the next source.
+	NotFound uint64
+	// Errors counts lookups that failed, also ending the chain.
+	Errors uint64
+	// LastSuccess is when the source last answered or passed a lookup on,
+	// or zero if it never has.
+	LastSuccess time.Time
+}
+
+// Layers is a Resolver that consults its sources in precedence order and
+// returns the first answer that isn't ErrNotFound. It records which source
+// answered in the lookup's LookupInfo, and keeps per-source counts.
+type Layers struct {
+	sources []Source
+	counts  []sourceCounts
+}
+
+type sourceCounts struct {
+	answered, notFound, errors atomic.Uint64
+	lastSuccess                atomic.Int64 // Unix nanoseconds
+}
+
+// NewLayers returns Layers consulting sources in the given order.
+func NewLayers(sources ...Source) *Layers {
+	return &Layers{sources: sources, counts: make([]sourceCounts, len(sources))}
+}
+
+// Chain returns a Resolver that consults resolvers in order and returns the
+// first answer that isn't ErrNotFound, so that, for example, a local file
+// can override DNS for the hosts it lists.
+func Chain(resolvers ...Resolver) Resolver {
+	sources := make([]Source, len(resolvers))
+	for i, resolver := range resolvers {
+		sources[i] = Source{Name: fmt.Sprint(i), Resolver: resolver}
+	}
+	return NewLayers(sources...)
+}
+
+func (l *Layers) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	err := fmt.Errorf("%w for %s", ErrNotFound, host)
+	for i, source := range l.sources {
+		var rules []*Rule
+		rules, err = source.Resolver.LookupConfig(ctx, host)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			l.counts[i].notFound.Add(1)
+			l.counts[i].lastSuccess.Store(time.Now().UnixNano())
+			continue
+		case err != nil:
+			l.counts[i].errors.Add(1)
+		default:
+			l.counts[i].answered.Add(1)
+			l.counts[i].lastSuccess.Store(time.Now().UnixNano())
+		}
+		if info := LookupInfoFrom(ctx); info != nil {
+			info.Source = source.Name
+		}
+		return rules, err
+	}
+	return nil, err
+}
+
+// Sources returns the sources in precedence order.
+func (l *Layers) Sources() []Source {
+	return append([]Source(nil), l.sources...)
+}
+
+// Stats returns the per-source counts, in precedence order.
+func (l *Layers) Stats() []SourceStats {
+	stats := make([]SourceStats, len(l.sources))
+	for i, source := range l.sources {
+		stats[i] = SourceStats{
+			Name:     source.Name,
+			Answered: l.counts[i].answered.Load(),
+			NotFound: l.counts[i].notFound.Load(),
+			Errors:   l.counts[i].errors.Load(),
+		}
+		if ns := l.counts[i].lastSuccess.Load(); ns != 0 {
+			stats[i].LastSuccess = time.Unix(0, ns)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,69 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	override := StaticResolver{"go.example.com": {"Redirects to https://override.example/"}}
+	upstream := StaticResolver{
+		"go.example.com":    {"Redirects to https://example.com/"},
+		"other.example.com": {"Redirects to https://other.example/"},
+	}
+	resolver := Chain(override, upstream)
+	ctx := context.Background()
+
+	rules, err := resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, rules[0].To, "https://override.example/")
+
+	rules, err = resolver.LookupConfig(ctx, "other.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, rules[0].To, "https://other.example/")
+
+	_, err = resolver.LookupConfig(ctx, "missing.example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Failures other than not-found stop the chain.
+	failing := ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		return nil, errors.New("timeout")
+	})
+	_, err = Chain(failing, upstream).LookupConfig(ctx, "go.example.com")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected resolver failure, got %v", err)
+	}
+}
+
+func TestLayersStats(t *testing.T) {
+	layers := NewLayers(
+		Source{Name: "file", Resolver: StaticResolver{"go.example.com": {"Redirects to https://file.example/"}}},
+		Source{Name: "dns", Resolver: StaticResolver{"other.example.com": {"Redirects to https://dns.example/"}}},
+	)
+
+	info := new(LookupInfo)
+	ctx := WithLookupInfo(context.Background(), info)
+	layers.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, info.Source, "file")
+
+	info = new(LookupInfo)
+	ctx = WithLookupInfo(context.Background(), info)
+	layers.LookupConfig(ctx, "other.example.com")
+	assertEqual(t, info.Source, "dns")
+
+	layers.LookupConfig(context.Background(), "missing.example.com")
+
+	stats := layers.Stats()
+	for i := range stats {
+		if stats[i].LastSuccess.IsZero() {
+			t.Errorf("%s: want LastSuccess set", stats[i].Name)
+		}
+		stats[i].LastSuccess = time.Time{}
+	}
+	assertEqual(t, stats[0], SourceStats{Name: "file", Answered: 1, NotFound: 2})
+	assertEqual(t, stats[1], SourceStats{Name: "dns", Answered: 1, NotFound: 1})
+}
@@ -0,0 +1,52 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrOverloaded is returned by a LookupLimiter for lookups past its limit.
+// The handler answers them with 503 Service Unavailable and Retry-After.
+var ErrOverloaded = errors.New("too many lookups in flight")
+
+// A LookupLimiter is a Resolver passing at most a fixed number of lookups
+// at a time to another and failing the rest at once with ErrOverloaded.
+// They aren't queued: under a spike, a lookup that waits only holds its
+// request, and its connection, for longer.
+type LookupLimiter struct {
+	resolver Resolver
+	slots    chan struct{}
+	refused  atomic.Int64
+}
+
+// NewLookupLimiter returns a LookupLimiter allowing resolver n lookups at a
+// time.
+func NewLookupLimiter(resolver Resolver, n int) *LookupLimiter {
+	return &LookupLimiter{resolver: resolver, slots: make(chan struct{}, n)}
+}
+
+func (l *LookupLimiter) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		l.refused.Add(1)
+		return nil, ErrOverloaded
+	}
+	defer func() { <-l.slots }()
+	return l.resolver.LookupConfig(ctx, host)
+}
+
+// InFlight returns the number of lookups in progress.
+func (l *LookupLimiter) InFlight() int { return len(l.slots) }
+
+// Refused returns the number of lookups failed with ErrOverloaded.
+func (l *LookupLimiter) Refused() int64 { return l.refused.Load() }
+
+// serveOverloaded answers a request that couldn't be looked up for load,
+// asking the client to come back in a second.
+func serveOverloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Service overloaded, try again shortly", http.StatusServiceUnavailable)
+}
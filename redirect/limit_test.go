@@ -0,0 +1,46 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLookupLimiter(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	l := NewLookupLimiter(ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		started <- struct{}{}
+		<-release
+		return ParseAll([]string{"Redirects to https://example.com/"}), nil
+	}), 1)
+	done := make(chan error)
+	go func() {
+		_, err := l.LookupConfig(context.Background(), "a.example.com")
+		done <- err
+	}()
+	<-started
+	if l.InFlight() != 1 {
+		t.Errorf("%d lookups in flight", l.InFlight())
+	}
+
+	h := NewHandler(WithResolver(l))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://b.example.com/", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("past the limit: %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if l.Refused() != 1 {
+		t.Errorf("%d lookups refused", l.Refused())
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	go func() { <-started }()
+	if _, err := l.LookupConfig(context.Background(), "b.example.com"); errors.Is(err, ErrOverloaded) {
+		t.Error("a lookup was refused after the first finished")
+	}
+}
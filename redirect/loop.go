@@ -0,0 +1,60 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LoopDetector is a TargetChecker refusing redirects that would come back
+// around: it follows the destination through Resolver's rules for up to
+// MaxHops redirects and refuses it with 508 Loop Detected if a URL repeats,
+// so a misconfigured pair of hosts gets an explanation instead of bouncing
+// the browser until it gives up. Hops stop at the first host Resolver has
+// no matching rule for.
+type LoopDetector struct {
+	Resolver Resolver
+	MaxHops  int
+}
+
+// CheckTarget returns a *BlockedError if location leads back to itself or
+// into a cycle within MaxHops redirects.
+func (d *LoopDetector) CheckTarget(ctx context.Context, location string) error {
+	// Keep the hops' lookups out of the request's LookupInfo.
+	ctx = WithLookupInfo(ctx, nil)
+	seen := make(map[string]bool)
+	var chain []string
+	for hop := 0; ; hop++ {
+		u, err := url.Parse(location)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil
+		}
+		key := strings.ToLower(u.Hostname()) + u.RequestURI()
+		chain = append(chain, key)
+		if seen[key] {
+			return &BlockedError{
+				Status: http.StatusLoopDetected,
+				Reason: "it redirects in a loop (" + strings.Join(chain, " → ") + ")",
+			}
+		}
+		seen[key] = true
+		if hop == d.MaxHops {
+			return nil
+		}
+
+		rules, err := d.Resolver.LookupConfig(ctx, strings.ToLower(u.Hostname()))
+		if err != nil {
+			return nil
+		}
+		target, err := Match(rules, u.RequestURI())
+		if err != nil {
+			return nil
+		}
+		next, err := u.Parse(target.Location)
+		if err != nil {
+			return nil
+		}
+		location = next.String()
+	}
+}
@@ -0,0 +1,45 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoopDetector(t *testing.T) {
+	d := &LoopDetector{MaxHops: 3, Resolver: StaticResolver{
+		"a.example":    []string{"Redirects to https://b.example/"},
+		"b.example":    []string{"Redirects to http://A.example/"},
+		"self.example": []string{"Redirects from /old to /new", "Redirects from /new to /old"},
+		"c.example":    []string{"Redirects to https://d.example/"},
+		"d.example":    []string{"Redirects to https://e.example/"},
+		"e.example":    []string{"Redirects to https://f.example/"},
+		"f.example":    []string{"Redirects to https://c.example/"},
+		"ok.example":   []string{"Redirects to https://elsewhere.example/"},
+	}}
+	ctx := context.Background()
+	for _, loop := range []string{"https://b.example/", "http://self.example/new"} {
+		var be *BlockedError
+		if err := d.CheckTarget(ctx, loop); !errors.As(err, &be) || be.Status != http.StatusLoopDetected {
+			t.Errorf("%s: want a 508 BlockedError, got %v", loop, err)
+		}
+	}
+	for _, fine := range []string{"https://ok.example/", "https://c.example/", "mailto:a@a.example", "https://elsewhere.example/"} {
+		if err := d.CheckTarget(ctx, fine); err != nil {
+			t.Errorf("%s: want allowed, got %v", fine, err)
+		}
+	}
+}
+
+func TestHandlerLoop(t *testing.T) {
+	resolver := StaticResolver{"go.example.com": []string{"Redirects to /"}}
+	h := NewHandler(WithResolver(resolver), WithTargetCheck(&LoopDetector{Resolver: resolver, MaxHops: 3}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if rr.Code != http.StatusLoopDetected || !strings.Contains(rr.Body.String(), "go.example.com/ → go.example.com/") {
+		t.Errorf("want a 508 loop page, got %d:\n%s", rr.Code, rr.Body)
+	}
+}
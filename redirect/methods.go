@@ -0,0 +1,93 @@
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var methodsRE = regexp.MustCompile(`^\s+([A-Z]+(?:,[A-Z]+)*)\b`)
+
+// knownMethods are the methods a rule may be scoped to.
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// validMethods reports whether methods, such as "POST,PUT", are all known
+// and given once.
+func validMethods(methods string) bool {
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(methods, ",") {
+		if !slices.Contains(knownMethods, m) || seen[m] {
+			return false
+		}
+		seen[m] = true
+	}
+	return true
+}
+
+// allowsMethod reports whether r applies to method requests. A rule for
+// GET also applies to HEAD.
+func (r *Rule) allowsMethod(method string) bool {
+	if r.Methods == "" {
+		return true
+	}
+	methods := strings.Split(r.Methods, ",")
+	return slices.Contains(methods, method) || (method == http.MethodHead && slices.Contains(methods, http.MethodGet))
+}
+
+// A MethodError is returned by MatchMethod when rules for url exist but
+// none for the request's method.
+type MethodError struct {
+	Method string
+	// Allow lists the methods rules for the URL do apply to.
+	Allow []string
+}
+
+func (e *MethodError) Error() string {
+	return fmt.Sprintf("%s is not allowed here, only %s", e.Method, strings.Join(e.Allow, ", "))
+}
+
+// MatchMethod is like Match, or MatchBot if bot, for a request with
+// method: rules scoped to other methods, as in "Redirects POST from
+// /webhook to ...", are skipped. If url matches only such rules, it
+// returns a *MethodError listing the methods they allow, to answer with
+// 405 Method Not Allowed.
+func MatchMethod(rules []*Rule, method, url string, bot bool) (*Redirect, error) {
+	return matchRequest(rules, method, url, nil, bot, Translate)
+}
+
+// matchRequest implements MatchMethod and MatchRequest, applying rules
+// with translate.
+func matchRequest(rules []*Rule, method, url string, header http.Header, bot bool, translate func(string, *Rule) *Redirect) (*Redirect, error) {
+	if bot {
+		if r := match(rules, method, url, header, true, translate); r != nil {
+			return r, nil
+		}
+	}
+	if r := match(rules, method, url, header, false, translate); r != nil {
+		return r, nil
+	}
+
+	var allow []string
+	for _, rule := range rules {
+		if rule.Methods == "" || (rule.Bots && !bot) || (rule.Header != "" && !rule.headerMatches(header)) || translate(url, rule) == nil {
+			continue
+		}
+		for _, m := range strings.Split(rule.Methods, ",") {
+			if !slices.Contains(allow, m) {
+				allow = append(allow, m)
+			}
+		}
+		if slices.Contains(allow, http.MethodGet) && !slices.Contains(allow, http.MethodHead) {
+			allow = append(allow, http.MethodHead)
+		}
+	}
+	if len(allow) > 0 {
+		return nil, &MethodError{Method: method, Allow: allow}
+	}
+	return nil, ErrNoMatch
+}
@@ -0,0 +1,64 @@
+package redirect
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseMethods(t *testing.T) {
+	rule := Parse("Redirects POST,PUT from /webhook to https://hooks.example.com/in with 307")
+	if rule == nil || rule.Methods != "POST,PUT" || rule.From != "/webhook" || rule.To != "https://hooks.example.com/in" {
+		t.Fatalf("got %+v", rule)
+	}
+	assertEqual(t, rule.String(), "Redirects POST,PUT from /webhook to https://hooks.example.com/in with 307")
+	for _, bad := range []string{"Redirects FETCH from /a to https://example.com/", "Redirects GET,GET to https://example.com/"} {
+		if rule := Parse(bad); rule != nil {
+			t.Errorf("%s: got %+v", bad, rule)
+		}
+	}
+}
+
+func TestMatchMethod(t *testing.T) {
+	rules := ParseAll([]string{
+		"Redirects POST from /webhook to https://hooks.example.com/in with 307",
+		"Redirects GET from /api/* to https://api.example.com/*",
+		"Redirects from /docs to https://docs.example.com/",
+	})
+	r, err := MatchMethod(rules, "POST", "/webhook", false)
+	if err != nil || r.Location != "https://hooks.example.com/in" {
+		t.Fatalf("POST /webhook: %v, %v", r, err)
+	}
+	if r, err := MatchMethod(rules, "HEAD", "/api/users", false); err != nil || r.Location != "https://api.example.com/users" {
+		t.Errorf("HEAD /api/users: %v, %v", r, err)
+	}
+	if _, err := MatchMethod(rules, "DELETE", "/docs", false); err != nil {
+		t.Errorf("DELETE /docs: %v", err)
+	}
+
+	_, err = MatchMethod(rules, "GET", "/webhook", false)
+	var methodErr *MethodError
+	if !errors.As(err, &methodErr) || len(methodErr.Allow) != 1 || methodErr.Allow[0] != "POST" {
+		t.Errorf("GET /webhook: %v", err)
+	}
+	if _, err := MatchMethod(rules, "GET", "/other", false); err != ErrNoMatch {
+		t.Errorf("GET /other: %v", err)
+	}
+	if _, err := Match(rules, "/webhook"); err == nil {
+		t.Error("Match applied a POST rule")
+	}
+}
+
+func TestHandlerMethodNotAllowed(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {"Redirects GET to https://example.org/*"},
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/form", nil))
+	assertEqual(t, rr.Code, 405)
+	assertEqual(t, rr.Header().Get("Allow"), "GET, HEAD")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("HEAD", "/form", nil))
+	assertEqual(t, rr.Code, 302)
+}
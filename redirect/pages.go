@@ -0,0 +1,159 @@
+package redirect
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//go:embed pages/*.html
+var defaultPages embed.FS
+
+// Pages renders the HTML pages the handler serves in place of a redirect:
+// fallback.html for hosts without a matching rule, blocked.html,
+// warning.html, loop.html and gone.html for redirects a TargetChecker
+// refused, locked.html for requests lacking a rule's key or password,
+// preview.html for link previewers (see WithLinkPreviews),
+// maintenance.html for ServeMaintenance and report.html for ServeReport.
+// Each is executed with a PageData, and may use the "header" and "footer"
+// templates defined in layout.html.
+type Pages struct {
+	t *template.Template
+}
+
+// PageData is what page templates are executed with.
+type PageData struct {
+	Status     int
+	Title      string
+	Host       string // the requested host
+	RecordName string // where the host's rules are looked up
+	Location   string // the refused destination, if any
+	Reason     string
+	RequestID  string   // to quote when reporting a problem, if any
+	Preview    *Preview // for preview.html
+}
+
+var builtinPages = sync.OnceValue(func() *Pages {
+	return &Pages{t: template.Must(template.ParseFS(defaultPages, "pages/*.html"))}
+})
+
+// DefaultPages returns the built-in pages.
+func DefaultPages() *Pages {
+	return builtinPages()
+}
+
+// LoadPages returns the built-in pages overridden by any .html files in
+// dir, so a deployment can rebrand every page by replacing layout.html or
+// reword just one. An empty dir returns DefaultPages.
+func LoadPages(dir string) (*Pages, error) {
+	if dir == "" {
+		return DefaultPages(), nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	t := template.Must(template.ParseFS(defaultPages, "pages/*.html"))
+	if len(files) > 0 {
+		if t, err = t.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	return &Pages{t: t}, nil
+}
+
+// WithPages sets the pages served when a redirect is refused or, with
+// WithFallbackPage, when a host has no matching rule. The default is
+// DefaultPages.
+func WithPages(p *Pages) Option {
+	return func(h *handler) { h.pages = p }
+}
+
+// WithFallbackPage serves the fallback page, with 404 Not Found, to
+// requests whose host can't be resolved or has no matching rule, telling
+// visitors how to set a redirect up instead of sending them to the
+// fallback URL.
+func WithFallbackPage() Option {
+	return func(h *handler) { h.fallbackPage = true }
+}
+
+// render serves the named page. If the template fails, a plain-text page
+// with the same status and reason is served instead.
+func (p *Pages) render(w http.ResponseWriter, name string, data PageData) {
+	var buf bytes.Buffer
+	if err := p.t.ExecuteTemplate(&buf, name, data); err != nil {
+		msg := fmt.Sprintf("%s: %s", data.Title, data.Reason)
+		if data.RequestID != "" {
+			msg += " (request ID " + data.RequestID + ")"
+		}
+		http.Error(w, msg, data.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(data.Status)
+	w.Write(buf.Bytes())
+}
+
+// ServeMaintenance serves the page saying host's links are down for
+// maintenance, with 503 Service Unavailable and reason. The caller sets
+// Retry-After, if it knows.
+func (p *Pages) ServeMaintenance(w http.ResponseWriter, r *http.Request, host, reason string) {
+	p.render(w, "maintenance.html", PageData{
+		Status:    http.StatusServiceUnavailable,
+		Title:     "Back soon",
+		Host:      host,
+		Reason:    reason,
+		RequestID: RequestIDFrom(r.Context()),
+	})
+}
+
+// ServeReport serves the page on which visitors report a link on host as
+// leading somewhere malicious: a form, prefilled with path (as Location),
+// or, with a reason, the reason in its place, such as thanks for a report.
+func (p *Pages) ServeReport(w http.ResponseWriter, r *http.Request, host, path string, status int, reason string) {
+	p.render(w, "report.html", PageData{
+		Status:    status,
+		Title:     "Report a link",
+		Host:      host,
+		Location:  path,
+		Reason:    reason,
+		RequestID: RequestIDFrom(r.Context()),
+	})
+}
+
+// blocked serves the page explaining why a redirect to location was refused.
+func (p *Pages) blocked(w http.ResponseWriter, r *http.Request, host, location string, err error) {
+	data := PageData{
+		Status:     http.StatusForbidden,
+		Title:      "Redirect blocked",
+		Host:       host,
+		RecordName: RecordName(host),
+		Location:   location,
+		Reason:     err.Error(),
+		RequestID:  RequestIDFrom(r.Context()),
+	}
+	name := "blocked.html"
+	var be *BlockedError
+	if errors.As(err, &be) {
+		data.Status, data.Reason = be.Status, be.Reason
+		switch {
+		case be.Proceed:
+			name, data.Title = "warning.html", "Warning: suspicious destination"
+		case be.Status == http.StatusLoopDetected:
+			name, data.Title = "loop.html", "Redirect loop"
+		case be.Status == http.StatusGone:
+			name, data.Title = "gone.html", "Destination gone"
+		}
+	}
+	p.render(w, name, data)
+}
@@ -0,0 +1,108 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFallbackPage(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{}), WithFallbackPage())
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://go.example.com/", nil))
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Location") != "" {
+		t.Errorf("want a 404 page without a Location, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{"<code>go.example.com</code>", "_redirect.go.example.com", "no redirect configuration found"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("fallback page lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestPagesRequestID(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{}), WithFallbackPage())
+	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req.WithContext(WithRequestID(req.Context(), "abc123")))
+	if !strings.Contains(rr.Body.String(), "Request ID: <code>abc123</code>") {
+		t.Errorf("fallback page lacks the request ID:\n%s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	NewHandler(WithResolver(StaticResolver{}), WithFallbackURL("https://fallback.example/")).
+		ServeHTTP(rr, req.WithContext(WithRequestID(req.Context(), "abc123")))
+	if loc := rr.Header().Get("Location"); !strings.HasSuffix(loc, "&request_id=abc123") {
+		t.Errorf("fallback URL lacks the request ID: %q", loc)
+	}
+}
+
+func TestLoadPages(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "layout.html"), []byte(`{{define "header"}}<h1>Acme links: {{.Title}}</h1>{{end}}{{define "footer"}}{{end}}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "gone.html"), []byte(`{{template "header" .}}<p>Gone for good: {{.Reason}}</p>`), 0o644)
+	pages, err := LoadPages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Blocklist{}
+	b.Set([]string{"old.example"})
+	h := NewHandler(
+		WithResolver(StaticResolver{"go.example.com": []string{"Redirects to https://old.example/"}}),
+		WithTargetCheck(b),
+		WithPages(pages),
+		WithFallbackPage(),
+	)
+	for url, want := range map[string]string{
+		"http://go.example.com/":   "<h1>Acme links: Destination gone</h1><p>Gone for good: the destination domain old.example is on a blocklist</p>",
+		"http://none.example.com/": "<h1>Acme links: No redirect configured</h1>",
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s: want %q in:\n%s", url, want, rr.Body)
+		}
+	}
+
+	if _, err := LoadPages(filepath.Join(dir, "missing")); err == nil {
+		t.Error("want an error for a missing templates directory")
+	}
+	os.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{template`), 0o644)
+	if _, err := LoadPages(dir); err == nil {
+		t.Error("want an error for a broken template")
+	}
+}
+
+func TestMaintenancePage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	DefaultPages().ServeMaintenance(rr, httptest.NewRequest("GET", "http://go.example.com/", nil), "go.example.com", "Moving to a new data center.")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("got %d, Cache-Control %q", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+	for _, want := range []string{"Back soon", "Moving to a new data center.", "<code>go.example.com</code>"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("maintenance page lacks %q:\n%s", want, rr.Body)
+		}
+	}
+}
+
+func TestReportPage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	DefaultPages().ServeReport(rr, httptest.NewRequest("GET", "http://go.example.com/_redirect/report?path=/docs", nil), "go.example.com", "/docs", http.StatusOK, "")
+	for _, want := range []string{"Report a link", `<form method="post">`, `value="/docs"`} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("report form lacks %q:\n%s", want, rr.Body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	DefaultPages().ServeReport(rr, httptest.NewRequest("POST", "http://go.example.com/_redirect/report", nil), "go.example.com", "/docs", http.StatusOK, "Thanks for your report.")
+	if body := rr.Body.String(); !strings.Contains(body, "Thanks for your report.") || strings.Contains(body, "<form") {
+		t.Errorf("want the reason in place of the form:\n%s", body)
+	}
+}
@@ -0,0 +1,355 @@
+package redirect
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Rule is a single redirect directive parsed from a TXT record, such as
+// "Redirects from /docs/* to https://docs.example.com/* permanently", a
+// well-known document to serve, or a flag such as "stats=public" that
+// applies to the whole host.
+type Rule struct {
+	From          string
+	To            string
+	RedirectState string
+	// Bots restricts the rule to requests from bots, as in "Redirects bots
+	// to https://example.com/crawlers".
+	Bots bool
+	// Requires gates the rule behind a key, as in "Redirects from
+	// /private/* to https://example.com/* requiring key=SECRET": requests
+	// without ?key=SECRET or "Authorization: Bearer SECRET" get 403.
+	Requires string
+	// Protected gates the rule behind HTTP Basic auth, as in "Redirects to
+	// https://example.com/ protected by alice:$2y$10$...": a user and a
+	// bcrypt hash of their password.
+	Protected string
+	// Cookie is the name=value the rule sets on its redirects, as in
+	// "Redirects to https://shop.example.com/ setting cookie ref=campaign
+	// for 30 days", for CookieFor or, if zero, the browser session.
+	Cookie    string
+	CookieFor time.Duration
+	// Methods scopes the rule to requests with the comma-separated
+	// methods, as in "Redirects POST from /webhook to ..." (see
+	// MatchMethod). It's empty for rules applying to every method.
+	Methods string
+	// Header is the condition of a rule such as "Redirects when header
+	// X-Env is staging to ...", as name=value; such rules are tried before
+	// the others, for requests meeting it (see MatchRequest).
+	Header string
+	// GoGet makes the rule a Go vanity import path, as in "Redirects goget
+	// to https://github.com/org/repo": go-get=1 requests get its go-import
+	// tags (see MatchGoImport) while browsers are redirected to To.
+	GoGet bool
+	// Serves is the literal body answered at the well-known path From,
+	// for "Serves /.well-known/<path> <json>", "Serves did <did>" and
+	// Matrix delegation records. Such rules never redirect.
+	Serves string
+	// ServesFrom is the URL of the JSON document answered at From, for
+	// "Serves /.well-known/<path> from <url>". It's fetched and cached.
+	ServesFrom string
+	// Responds marks a `Responds to /ping with "pong"` rule, answering
+	// From with the body in Serves, ContentType (text/plain if empty) and
+	// the status in RedirectState (200 if empty).
+	Responds    bool
+	ContentType string
+	// Canonical is the host a "Canonicalizes to example.com" rule sends
+	// every request to, keeping its path and query.
+	Canonical string
+	// Ignores excludes paths matching From from redirection, as in
+	// "Ignores /api/*". RedirectState is the status they get instead, 204
+	// or 404, if the record gives one.
+	Ignores bool
+	// Flag is set, and the other fields empty, for flag records. Such
+	// rules never match a request.
+	Flag string
+}
+
+// FlagPublicStats opts a host in to serving its redirect counts at
+// /_redirect/stats.
+const FlagPublicStats = "stats=public"
+
+// knownFlags are the flag records Parse accepts.
+var knownFlags = []string{FlagPublicStats, FlagForceHTTPS, FlagNoForceHTTPS}
+
+// valueFlags are the key=value flag records Parse accepts: a link
+// preview's "og:title=Our docs" and the like, a WebFinger delegate such
+// as "webfinger=@alice@mastodon.social", and the origins allowed to fetch
+// through the host's redirects, such as "cors=*" (see WithCORS).
+var valueFlags = []string{"og:title", "og:description", "og:image", "webfinger", "cors"}
+
+// HasFlag reports whether one of rules is the flag record flag.
+func HasFlag(rules []*Rule, flag string) bool {
+	return slices.ContainsFunc(rules, func(r *Rule) bool { return r.Flag == flag })
+}
+
+// FlagValue returns the value of the first key=value flag record for key
+// among rules, such as "Our docs" for og:title, or "".
+func FlagValue(rules []*Rule, key string) string {
+	for _, r := range rules {
+		if k, v, ok := strings.Cut(r.Flag, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// String returns r as a record, such as "Redirects from /docs/* to
+// https://docs.example.com/* with 301", with any key or hash redacted.
+func (r *Rule) String() string {
+	if r.Flag != "" {
+		return r.Flag
+	}
+	if r.Responds {
+		s := "Responds to " + r.From + " with "
+		if r.RedirectState != "" {
+			s += r.RedirectState + " "
+		}
+		s += strconv.Quote(r.Serves)
+		if r.ContentType != "" {
+			s += " as " + r.ContentType
+		}
+		return s
+	}
+	if r.Serves != "" && r.From == AtprotoDIDPath {
+		return "Serves did " + r.Serves
+	}
+	if r.Serves != "" {
+		return "Serves " + r.From + " " + r.Serves
+	}
+	if r.ServesFrom != "" {
+		return "Serves " + r.From + " from " + r.ServesFrom
+	}
+	if r.Canonical != "" {
+		s := "Canonicalizes to " + r.Canonical
+		switch r.RedirectState {
+		case "permanently":
+		case "temporarily":
+			s += " temporarily"
+		default:
+			s += " with " + r.RedirectState
+		}
+		return s
+	}
+	if r.Ignores {
+		s := "Ignores " + r.From
+		if r.RedirectState != "" {
+			s += " with " + r.RedirectState
+		}
+		return s
+	}
+	s := "Redirects"
+	if r.Bots {
+		s += " bots"
+	}
+	if r.GoGet {
+		s += " goget"
+	}
+	if r.Methods != "" {
+		s += " " + r.Methods
+	}
+	if r.Header != "" {
+		name, value, _ := strings.Cut(r.Header, "=")
+		s += " when header " + name + " is " + value
+	}
+	if r.From != "" {
+		s += " from " + r.From
+	}
+	s += " to " + r.To
+	switch r.RedirectState {
+	case "":
+	case "permanently", "temporarily":
+		s += " " + r.RedirectState
+	default:
+		s += " with " + r.RedirectState
+	}
+	if r.Requires != "" {
+		// Keys and hashes stay out of logs and traces.
+		name, _ := keyParts(r.Requires)
+		s += " requiring " + name + "=REDACTED"
+	}
+	if r.Protected != "" {
+		user, _, _ := strings.Cut(r.Protected, ":")
+		s += " protected by " + user + ":REDACTED"
+	}
+	if r.Cookie != "" {
+		s += " setting cookie " + r.Cookie + formatCookieFor(r.CookieFor)
+	}
+	return s
+}
+
+var configRE = regexp.MustCompile(`Redirects?(\s+.*)`)
+var botsRE = regexp.MustCompile(`^\s+bots\b`)
+var goGetRE = regexp.MustCompile(`^\s+goget\b`)
+var fromRE = regexp.MustCompile(`\s+from\s+(/\S*)`)
+var toRE = regexp.MustCompile(`\s+to\s+(\S+)`)
+var stateRE = regexp.MustCompile(`\s+(permanently|temporarily)|\s+with\s+(301|302|307|308)`)
+
+// AllowedSchemes are the URL schemes a redirect target may use. Targets may
+// also be paths, starting with "/". Schemes that run code in the browser
+// (javascript, data and vbscript) are refused even if listed here.
+var AllowedSchemes = []string{"http", "https", "ftp", "mailto", "magnet"}
+
+var dangerousSchemes = []string{"javascript", "data", "vbscript"}
+
+// ErrUnsafeTarget is returned by ValidateTarget for targets that must not
+// be redirected to.
+var ErrUnsafeTarget = errors.New("unsafe redirect target")
+
+// ValidateTarget reports whether target can be redirected to: a path, or a
+// well-formed URL with one of the AllowedSchemes.
+func ValidateTarget(target string) error {
+	if strings.ContainsFunc(target, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '\\' }) {
+		return fmt.Errorf("%w: %q contains a control character or backslash", ErrUnsafeTarget, target)
+	}
+	if strings.HasPrefix(target, "/") {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsafeTarget, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	switch {
+	case scheme == "":
+		return fmt.Errorf("%w: %q is neither a path nor an absolute URL", ErrUnsafeTarget, target)
+	case slices.Contains(dangerousSchemes, scheme) || !slices.Contains(AllowedSchemes, scheme):
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrUnsafeTarget, scheme)
+	case (scheme == "http" || scheme == "https" || scheme == "ftp") && u.Host == "":
+		return fmt.Errorf("%w: %q has no host", ErrUnsafeTarget, target)
+	}
+	return nil
+}
+
+// Parse parses a TXT record into a Rule. It returns nil if the record is
+// neither a redirect directive nor a known flag (stats=public, a link
+// preview's og:title=, og:description= or og:image=, a valid webfinger=,
+// or a valid security-*= or robots-*= line of security.txt or robots.txt)
+// nor a literal body (see Serves and Responds) nor an Ignores directive. Targets that fail
+// ValidateTarget are ignored, leaving a Rule that matches nothing.
+func Parse(record string) *Rule {
+	if flag := strings.ToLower(strings.TrimSpace(record)); slices.Contains(knownFlags, flag) {
+		return &Rule{Flag: flag}
+	}
+	if key, value, ok := strings.Cut(strings.TrimSpace(record), "="); ok {
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if f, ok := textFileFlags[key]; ok {
+			if !f.valid(value) {
+				return nil
+			}
+			return &Rule{Flag: key + "=" + value}
+		}
+		if slices.Contains(valueFlags, key) && value != "" {
+			switch key {
+			case "webfinger":
+				if _, err := webFingerTarget(value, "resource=acct:x"); err != nil {
+					return nil
+				}
+			case "cors":
+				if _, err := ParseOrigins(value); err != nil && value != "off" {
+					return nil
+				}
+			}
+			return &Rule{Flag: key + "=" + value}
+		}
+	}
+	if rule := parseServes(record); rule != nil {
+		return rule
+	}
+	if rule := parseResponds(record); rule != nil {
+		return rule
+	}
+	if rule := parseCanonical(record); rule != nil {
+		return rule
+	}
+	if rule := parseIgnores(record); rule != nil {
+		return rule
+	}
+	configMatches := configRE.FindStringSubmatch(record)
+	if len(configMatches) == 0 {
+		return nil
+	}
+
+	rule := new(Rule)
+	if loc := botsRE.FindStringIndex(configMatches[1]); loc != nil {
+		rule.Bots = true
+		configMatches[1] = configMatches[1][loc[1]:]
+	}
+	if loc := goGetRE.FindStringIndex(configMatches[1]); loc != nil {
+		rule.GoGet = true
+		configMatches[1] = configMatches[1][loc[1]:]
+	}
+	if m := methodsRE.FindStringSubmatchIndex(configMatches[1]); m != nil {
+		if rule.Methods = configMatches[1][m[2]:m[3]]; !validMethods(rule.Methods) {
+			return nil
+		}
+		configMatches[1] = configMatches[1][m[1]:]
+	}
+
+	// Clauses are looked for only in records with their keyword: the
+	// unanchored expressions are most of parsing's cost.
+	if strings.Contains(configMatches[1], "header") {
+		if m := headerRE.FindStringSubmatch(configMatches[1]); m != nil {
+			rule.Header = headerCondition(m[1], m[2])
+			configMatches[1] = strings.Replace(configMatches[1], m[0], "", 1)
+		}
+	}
+	if strings.Contains(configMatches[1], "requiring") {
+		if m := requiringRE.FindStringSubmatchIndex(configMatches[1]); m != nil {
+			rule.Requires = configMatches[1][m[2]:m[3]]
+			configMatches[1] = configMatches[1][:m[0]] + configMatches[1][m[1]:]
+		} else if requiringKeywordRE.MatchString(configMatches[1]) {
+			// A rule whose key can't be read must not redirect without it.
+			return nil
+		}
+	}
+	if strings.Contains(configMatches[1], "protected") {
+		if m := protectedRE.FindStringSubmatchIndex(configMatches[1]); m != nil {
+			if protected := configMatches[1][m[2]:m[3]]; validCredentials(protected) {
+				rule.Protected = protected
+			} else {
+				// A rule whose protection can't be checked must not redirect
+				// unprotected.
+				return nil
+			}
+			configMatches[1] = configMatches[1][:m[0]] + configMatches[1][m[1]:]
+		}
+	}
+	if strings.Contains(configMatches[1], "setting") {
+		if m := cookieRE.FindStringSubmatch(configMatches[1]); m != nil {
+			var ok bool
+			if rule.Cookie, rule.CookieFor, ok = parseCookie(m[1], m[2], m[3]); !ok {
+				return nil
+			}
+			configMatches[1] = strings.Replace(configMatches[1], m[0], "", 1)
+		}
+	}
+
+	fromMatches := fromRE.FindStringSubmatch(configMatches[1])
+	toMatches := toRE.FindAllStringSubmatch(configMatches[1], -1)
+	stateMatches := stateRE.FindStringSubmatch(configMatches[1])
+
+	if len(fromMatches) > 0 {
+		rule.From = fromMatches[1]
+	}
+	for _, m := range toMatches {
+		if ValidateTarget(m[1]) == nil {
+			rule.To = m[1]
+			break
+		}
+	}
+	if len(stateMatches) > 0 {
+		rule.RedirectState = stateMatches[1]
+		if rule.RedirectState == "" {
+			rule.RedirectState = stateMatches[2]
+		}
+	}
+
+	return rule
+}
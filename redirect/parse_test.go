@@ -1,6 +1,9 @@
-package main
+package redirect
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func assertEqual(t *testing.T, value interface{}, expectation interface{}) {
 	if value != expectation {
@@ -9,7 +12,7 @@ func assertEqual(t *testing.T, value interface{}, expectation interface{}) {
 }
 
 func TestParse(t *testing.T) {
-	var config *Config
+	var config *Rule
 
 	config = Parse("This is not a valid statement")
 	if config != nil {
@@ -86,3 +89,63 @@ func TestParse(t *testing.T) {
 	assertEqual(t, config.To, "/new")
 	assertEqual(t, config.RedirectState, "307")
 }
+
+func TestParseUnsafeTargets(t *testing.T) {
+	for _, record := range []string{
+		"Redirect to javascript:alert(1)",
+		"Redirect to JavaScript://%0aalert(1)",
+		"Redirect to data:text/html,<script>alert(1)</script>",
+		"Redirect to vbscript:msgbox",
+		"Redirect to https://",
+		"Redirect to http:/example.com",
+		"Redirect to https://exa%zzmple.com/",
+		"Redirect to example.com",
+		"Redirect to /\\evil.example",
+		"Redirect to gopher://example.com/",
+	} {
+		config := Parse(record)
+		if config == nil {
+			t.Errorf("%q: expected a rule", record)
+			continue
+		}
+		assertEqual(t, config.To, "")
+	}
+
+	config := Parse("Redirect from /a to javascript:alert(1) to https://example.com/")
+	assertEqual(t, config.To, "https://example.com/")
+}
+
+func TestParseFlags(t *testing.T) {
+	rules := ParseAll([]string{"Redirects to https://example.com/", " Stats=Public ", "stats=private"})
+	if len(rules) != 2 {
+		t.Fatalf("want a rule and a flag, got %d rules", len(rules))
+	}
+	assertEqual(t, rules[1].Flag, FlagPublicStats)
+	assertEqual(t, rules[1].String(), "stats=public")
+	if !HasFlag(rules, FlagPublicStats) || HasFlag(rules[:1], FlagPublicStats) {
+		t.Error("HasFlag: wrong answer")
+	}
+	if r, err := Match(rules[1:], "/"); err == nil {
+		t.Errorf("flag matched: %+v", r)
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	for _, target := range []string{"/", "//example.com/", "https://example.com/a?b#c", "HTTPS://example.com", "magnet:?xt=urn:btih:c12fe1", "mailto:a@example.com"} {
+		if err := ValidateTarget(target); err != nil {
+			t.Errorf("%q: %v", target, err)
+		}
+	}
+	for _, target := range []string{"javascript:alert(1)", "https://example.com/\x00", "/new\r\nSet-Cookie: a=b"} {
+		if err := ValidateTarget(target); !errors.Is(err, ErrUnsafeTarget) {
+			t.Errorf("%q: expected ErrUnsafeTarget, got %v", target, err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		Parse("Redirects from /docs/* to https://docs.example.com/* permanently")
+	}
+}
@@ -0,0 +1,127 @@
+package redirect
+
+import (
+	"container/list"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxPatterns bounds the From patterns kept compiled, past which the
+// least recently used is dropped.
+const maxPatterns = 10000
+
+// patterns is the cache of compiled From patterns Translate uses, so that
+// a host's rules are compiled once rather than on every request.
+var patterns = newPatternCache(maxPatterns)
+
+// A patternCache is a bounded LRU of compiled From patterns, keyed by the
+// pattern as written in the record.
+type patternCache struct {
+	max int
+
+	mu      sync.Mutex
+	order   *list.List // of *patternEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type patternEntry struct {
+	from    string
+	pattern *pattern
+}
+
+func newPatternCache(max int) *patternCache {
+	return &patternCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// compile returns from compiled: the pattern literally, but for its first
+// * capturing anything.
+func (c *patternCache) compile(from string) *pattern {
+	c.mu.Lock()
+	if e, ok := c.entries[from]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*patternEntry).pattern
+	}
+	c.mu.Unlock()
+
+	// Compile outside the lock; a pattern compiled twice at once is only
+	// stored once.
+	p := compilePattern(from)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[from]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*patternEntry).pattern
+	}
+	c.entries[from] = c.order.PushFront(&patternEntry{from: from, pattern: p})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*patternEntry).from)
+	}
+	return p
+}
+
+// Len returns the number of compiled patterns kept.
+func (c *patternCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// A pattern is a compiled From: the literal text before and after its
+// first *, which captures whatever is between them.
+type pattern struct {
+	prefix, suffix string
+	wildcard       bool
+	// re is the pattern as an expression, for targets with $ references
+	// to expand.
+	re *regexp.Regexp
+}
+
+func compilePattern(from string) *pattern {
+	p := new(pattern)
+	p.prefix, p.suffix, p.wildcard = strings.Cut(from, "*")
+	p.re = regexp.MustCompile(patternExpr(from))
+	return p
+}
+
+// match reports whether uri matches p, and what the wildcard captured.
+// The capture doesn't span lines, as .* doesn't in an expression.
+func (p *pattern) match(uri string) (string, bool) {
+	if !p.wildcard {
+		return "", uri == p.prefix
+	}
+	if len(uri) < len(p.prefix)+len(p.suffix) || !strings.HasPrefix(uri, p.prefix) || !strings.HasSuffix(uri, p.suffix) {
+		return "", false
+	}
+	capture := uri[len(p.prefix) : len(uri)-len(p.suffix)]
+	return capture, !strings.Contains(capture, "\n")
+}
+
+// expand returns to with its first * replaced by capture, the match of p
+// in uri. Targets with $ references are expanded as in the expression's
+// ReplaceAllString, $1 standing for the capture.
+func (p *pattern) expand(uri, capture, to string) string {
+	if strings.Contains(to, "$") {
+		return p.re.ReplaceAllString(uri, strings.Replace(to, "*", "${1}", 1))
+	}
+	before, after, ok := strings.Cut(to, "*")
+	if !ok {
+		return to
+	}
+	var b strings.Builder
+	b.Grow(len(before) + len(capture) + len(after))
+	b.WriteString(before)
+	b.WriteString(capture)
+	b.WriteString(after)
+	return b.String()
+}
+
+// patternExpr returns the expression from matches: itself literally, but
+// for its first * capturing anything.
+func patternExpr(from string) string {
+	return `^` + strings.Replace(regexp.QuoteMeta(from), `\*`, `(.*)`, 1) + `$`
+}
@@ -0,0 +1,87 @@
+package redirect
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPatternCache(t *testing.T) {
+	c := newPatternCache(2)
+	a := c.compile("/a/*")
+	if c.compile("/a/*") != a {
+		t.Error("/a/* compiled twice")
+	}
+	c.compile("/*.html*")
+
+	// /a/* was used last, so /*.html* is dropped for /b.
+	c.compile("/a/*")
+	c.compile("/b")
+	if c.Len() != 2 {
+		t.Errorf("%d patterns kept", c.Len())
+	}
+	if _, ok := c.entries["/*.html*"]; ok {
+		t.Error("the least recently used pattern was kept")
+	}
+	if c.compile("/a/*") != a {
+		t.Error("/a/* was dropped")
+	}
+}
+
+func TestPattern(t *testing.T) {
+	for _, tc := range []struct {
+		from, uri, to string
+		want          string // "" if uri doesn't match
+	}{
+		{"/a", "/a", "https://example.com/", "https://example.com/"},
+		{"/a", "/a/", "https://example.com/", ""},
+		{"/a/*", "/a/b?c=d", "https://example.com/*", "https://example.com/b?c=d"},
+		{"/a/*", "/a/", "https://example.com/*/x", "https://example.com//x"},
+		{"/a/*", "/a", "https://example.com/*", ""},
+		{"/*.html", "/x.html", "https://example.com/*", "https://example.com/x"},
+		{"/*.html", "/.htm", "https://example.com/*", ""},
+		{"/*.html*", "/x.html*", "https://example.com/*", "https://example.com/x"},
+		{"/*.html*", "/x.htmly", "https://example.com/*", ""},
+		{"/a/*", "/a/b\nc", "https://example.com/*", ""},
+		{"/a/*", "/a/b", "https://example.com/*/*", "https://example.com/b/*"},
+		{"/a/*", "/a/b", "https://example.com/$1/${1}x/$$/$0", "https://example.com/b/bx/$//a/b"},
+		{"/a/*", "/a/b", "https://example.com/$x", "https://example.com/"},
+	} {
+		p := compilePattern(tc.from)
+		capture, ok := p.match(tc.uri)
+		if ok != (tc.want != "") {
+			t.Errorf("%s matching %q: %v", tc.from, tc.uri, ok)
+			continue
+		}
+		// The expression Translate once compiled on every request agrees.
+		if ok != p.re.MatchString(tc.uri) {
+			t.Errorf("%s matching %q: %v, but the expression says otherwise", tc.from, tc.uri, ok)
+		}
+		if !ok {
+			continue
+		}
+		got := tc.to
+		if p.wildcard {
+			got = p.expand(tc.uri, capture, tc.to)
+		}
+		if got != tc.want {
+			t.Errorf("%s to %s for %q: got %q, want %q", tc.from, tc.to, tc.uri, got, tc.want)
+		}
+	}
+}
+
+func TestTranslateCompilesOnce(t *testing.T) {
+	rule := &Rule{From: "/docs/*", To: "https://example.com/*"}
+	Translate("/docs/intro", rule)
+	allocs := testing.AllocsPerRun(100, func() { Translate("/docs/intro", rule) })
+	// The Redirect and its location only; compiling takes dozens.
+	if allocs > 2 {
+		t.Errorf("%v allocations per translation", allocs)
+	}
+
+	for i := range maxPatterns + 10 {
+		patterns.compile("/page" + strconv.Itoa(i))
+	}
+	if patterns.Len() != maxPatterns {
+		t.Errorf("%d patterns kept", patterns.Len())
+	}
+}
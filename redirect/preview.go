@@ -0,0 +1,102 @@
+package redirect
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// linkPreviewers are lowercase substrings of the User-Agent of bots that
+// fetch shared links to unfurl them in chats and feeds.
+var linkPreviewers = []string{
+	"slackbot", "twitterbot", "facebookexternalhit", "facebookcatalog",
+	"linkedinbot", "discordbot", "telegrambot", "whatsapp", "skypeuripreview",
+	"redditbot", "mastodon", "pinterestbot", "embedly", "iframely", "vkshare",
+}
+
+// IsLinkPreviewer reports whether ua is a bot that unfurls shared links,
+// such as Slack's, Twitter's or Facebook's.
+func IsLinkPreviewer(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, m := range linkPreviewers {
+		if strings.Contains(ua, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Preview describes a redirect's destination for link previews.
+type Preview struct {
+	Title       string
+	Description string
+	Image       string // an absolute http or https URL
+	SiteName    string
+}
+
+// A Previewer describes a destination, typically by fetching its
+// OpenGraph metadata.
+type Previewer interface {
+	Preview(ctx context.Context, location string) (*Preview, error)
+}
+
+// WithLinkPreviews answers link previewers (see IsLinkPreviewer) with 200
+// and preview.html, a page carrying OpenGraph and Twitter card metadata
+// and a refresh to the destination, instead of the redirect, so shared
+// links unfurl with a description of where they lead. The metadata comes
+// from the host's og:title=, og:description= and og:image= records or,
+// failing a title there, from p if it isn't nil.
+func WithLinkPreviews(p Previewer) Option {
+	return func(h *handler) {
+		h.previews = true
+		h.previewer = p
+	}
+}
+
+// preview describes location from rules' flags or h.previewer. The title
+// is at least location's host.
+func (h *handler) preview(ctx context.Context, rules []*Rule, location string) *Preview {
+	p := &Preview{
+		Title:       FlagValue(rules, "og:title"),
+		Description: FlagValue(rules, "og:description"),
+		Image:       FlagValue(rules, "og:image"),
+	}
+	if p.Title == "" && h.previewer != nil {
+		_, span := startSpan(ctx, "redirect.preview")
+		fetched, err := h.previewer.Preview(ctx, location)
+		span.End(err)
+		if err == nil && fetched != nil {
+			p.Title, p.SiteName = fetched.Title, fetched.SiteName
+			if p.Description == "" {
+				p.Description = fetched.Description
+			}
+			if p.Image == "" {
+				p.Image = fetched.Image
+			}
+		}
+	}
+	if u, err := url.Parse(p.Image); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.Image = ""
+	}
+	if p.Title == "" {
+		p.Title = location
+		if u, err := url.Parse(location); err == nil && u.Host != "" {
+			p.Title = u.Host
+		}
+	}
+	return p
+}
+
+// servePreview serves preview.html for a redirect to location.
+func (h *handler) servePreview(ctx context.Context, w http.ResponseWriter, r *http.Request, host string, rules []*Rule, location string) {
+	h.pages.render(w, "preview.html", PageData{
+		Status:     http.StatusOK,
+		Title:      "Redirecting",
+		Host:       host,
+		RecordName: RecordName(host),
+		Location:   location,
+		RequestID:  RequestIDFrom(r.Context()),
+		Preview:    h.preview(ctx, rules, location),
+	})
+}
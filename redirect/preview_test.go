@@ -0,0 +1,75 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type staticPreviewer struct {
+	p     *Preview
+	err   error
+	calls int
+}
+
+func (s *staticPreviewer) Preview(ctx context.Context, location string) (*Preview, error) {
+	s.calls++
+	return s.p, s.err
+}
+
+func TestHandlerLinkPreviews(t *testing.T) {
+	resolver := StaticResolver{
+		"go.example.com":   {"Redirects to https://example.com/docs?a=1&b=2", "og:title=Our docs", "og:description=Everything <you> need", "og:image=https://example.com/card.png"},
+		"bare.example.com": {"Redirects to https://example.org/"},
+	}
+	previewer := &staticPreviewer{p: &Preview{Title: "Example Org", Description: "Fetched", Image: "javascript:alert(1)", SiteName: "Example"}}
+	h := NewHandler(WithResolver(resolver), WithLinkPreviews(previewer))
+	get := func(host, ua string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		req.Header.Set("User-Agent", ua)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("go.example.com", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	assertEqual(t, rr.Code, 200)
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="Our docs">`,
+		`<meta property="og:description" content="Everything &lt;you&gt; need">`,
+		`<meta property="og:image" content="https://example.com/card.png">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`<meta property="og:url" content="https://example.com/docs?a=1&amp;b=2">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("preview lacks %s:\n%s", want, body)
+		}
+	}
+	assertEqual(t, previewer.calls, 0)
+
+	rr = get("bare.example.com", "Twitterbot/1.0")
+	body = rr.Body.String()
+	if !strings.Contains(body, `content="Example Org"`) || !strings.Contains(body, `content="Fetched"`) || strings.Contains(body, "javascript") {
+		t.Errorf("fetched preview:\n%s", body)
+	}
+
+	previewer.err = errors.New("timeout")
+	if body := get("bare.example.com", "Twitterbot/1.0").Body.String(); !strings.Contains(body, `<title>example.org</title>`) {
+		t.Errorf("failed fetch: want the destination host as title:\n%s", body)
+	}
+
+	if rr := get("go.example.com", "Mozilla/5.0 Firefox/128.0"); rr.Code != 302 {
+		t.Errorf("browser: want the redirect, got %d", rr.Code)
+	}
+	if rr := get("go.example.com", "Googlebot/2.1"); rr.Code != 302 {
+		t.Errorf("crawler: want the redirect, got %d", rr.Code)
+	}
+
+	h = NewHandler(WithResolver(resolver))
+	if rr := get("go.example.com", "Slackbot"); rr.Code != 302 {
+		t.Errorf("previews off: want the redirect, got %d", rr.Code)
+	}
+}
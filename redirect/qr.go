@@ -0,0 +1,124 @@
+package redirect
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/frolic/redirect.name/internal/qr"
+)
+
+// QRPath is where hosts serve QR codes of their links, with WithQRCodes.
+const QRPath = "/_redirect/qr"
+
+// maxQRPath bounds the path a QR code is made for, and maxQRPixels the
+// width of its PNG.
+const (
+	maxQRPath   = 2048
+	maxQRPixels = 2048
+)
+
+// WithQRCodes makes every configured host serve QR codes of its links at
+// QRPath, for printed materials:
+//
+//	/_redirect/qr?path=/promo
+//
+// encodes the redirect URL http(s)://<host>/promo, so scans go through
+// the redirect (and its counts). With to=destination, the code encodes
+// where /promo redirects to instead, unless a TargetChecker refuses it as
+// it would the redirect. format is png (the default) or svg,
+// scale the PNG's pixels per module (1 to 32, default 8, and at most
+// maxQRPixels wide in all), and ecc the error correction level, L, M (the
+// default), Q or H.
+func WithQRCodes() Option {
+	return func(h *handler) { h.qrCodes = true }
+}
+
+func (h *handler) serveQR(ctx context.Context, w http.ResponseWriter, r *http.Request, host string, rules []*Rule) {
+	q := r.URL.Query()
+	path := q.Get("path")
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || len(path) > maxQRPath {
+		http.Error(w, "path must be a path on this host", http.StatusBadRequest)
+		return
+	}
+	level := qr.M
+	if s := q.Get("ecc"); s != "" {
+		var err error
+		if level, err = qr.ParseLevel(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	scale := 8
+	if s := q.Get("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 32 {
+			http.Error(w, "scale must be 1 to 32", http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+
+	target := resolveLocation(r, "/", path)
+	switch q.Get("to") {
+	case "", "redirect":
+	case "destination":
+		_, span := startSpan(ctx, "redirect.translate")
+		redirect, err := Match(rules, path)
+		span.End(err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if redirect.Rule.Requires != "" || redirect.Rule.Protected != "" {
+			http.Error(w, "the destination of a locked link isn't shown", http.StatusForbidden)
+			return
+		}
+		target = asciiLocation(resolveLocation(r, path, redirect.Location))
+		// A code of a destination the redirect itself would refuse would
+		// lead around the refusal.
+		checked := checkedLocation(r, target)
+		for _, c := range h.checks {
+			if err := c.CheckTarget(ctx, checked); err != nil {
+				h.pages.blocked(w, r, host, checked, err)
+				return
+			}
+		}
+	default:
+		http.Error(w, "to must be redirect or destination", http.StatusBadRequest)
+		return
+	}
+
+	code, err := qr.Encode([]byte(target), level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	switch q.Get("format") {
+	case "", "png":
+		if side := code.Side(scale); side > maxQRPixels {
+			http.Error(w, "the code would be "+strconv.Itoa(side)+" pixels wide, over "+strconv.Itoa(maxQRPixels)+"; use a smaller scale", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		err = code.PNG(&buf, scale)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = code.SVG(&buf)
+	default:
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,78 @@
+package redirect
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/internal/qr"
+)
+
+func TestHandlerQR(t *testing.T) {
+	h := NewHandler(WithQRCodes(), WithResolver(StaticResolver{
+		"example.com": {"Redirects from /promo to https://shop.example.org/sale", "Redirects to https://example.org/*"},
+	}))
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+QRPath+query, nil))
+		return rr
+	}
+	encoded := func(target string, level qr.Level, scale int) []byte {
+		code, err := qr.Encode([]byte(target), level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		code.PNG(&buf, scale)
+		return buf.Bytes()
+	}
+
+	rr := get("?path=/promo")
+	assertEqual(t, rr.Code, 200)
+	assertEqual(t, rr.Header().Get("Content-Type"), "image/png")
+	if !bytes.Equal(rr.Body.Bytes(), encoded("http://example.com/promo", qr.M, 8)) {
+		t.Error("want a code of the redirect URL")
+	}
+	rr = get("?path=/promo&to=destination&ecc=h&scale=2")
+	if !bytes.Equal(rr.Body.Bytes(), encoded("https://shop.example.org/sale", qr.H, 2)) {
+		t.Error("want a code of the destination")
+	}
+	rr = get("?format=svg")
+	assertEqual(t, rr.Header().Get("Content-Type"), "image/svg+xml")
+
+	long := "?path=/" + strings.Repeat("a", 1000)
+	if rr := get(long); rr.Code != 200 {
+		t.Errorf("a long path at the default scale: got %d", rr.Code)
+	}
+	for _, bad := range []string{"?path=promo", "?path=//evil.example", "?scale=100", "?ecc=X", "?format=gif", "?to=elsewhere", long + "&scale=32"} {
+		if rr := get(bad); rr.Code != 400 {
+			t.Errorf("%s: got %d", bad, rr.Code)
+		}
+	}
+
+	h = NewHandler(WithResolver(StaticResolver{"example.com": {"Redirects to https://example.org/"}}))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+QRPath, nil))
+	assertEqual(t, rr.Code, 302)
+}
+
+func TestHandlerQRChecksDestination(t *testing.T) {
+	var blocklist Blocklist
+	blocklist.Set([]string{"shop.example.org"})
+	h := NewHandler(WithQRCodes(), WithTargetCheck(&blocklist), WithResolver(StaticResolver{
+		"example.com": {"Redirects from /promo to https://shop.example.org/sale", "Redirects to https://example.org/*"},
+	}))
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+QRPath+query, nil))
+		return rr
+	}
+
+	if rr := get("?path=/promo&to=destination"); rr.Code != 410 || rr.Header().Get("Content-Type") == "image/png" {
+		t.Errorf("a code of a blocklisted destination: want 410, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if rr := get("?path=/other&to=destination"); rr.Code != 200 {
+		t.Errorf("a code of another destination: want 200, got %d", rr.Code)
+	}
+}
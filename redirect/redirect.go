@@ -0,0 +1,121 @@
+// Package redirect implements DNS-driven HTTP redirects as served by
+// redirect.name.
+//
+// A host opts in by publishing one or more TXT records at _redirect.<host>,
+// each holding a rule such as:
+//
+//	Redirects from /docs/* to https://docs.example.com/* permanently
+//
+// Parse turns a record into a Rule, Translate applies a single Rule to a
+// request URL, and Resolve looks up a host's rules through a Resolver and
+// picks the Redirect for a URL, so that other Go services can embed the same
+// behaviour. Rules can come from system DNS (DNSResolver), DNS-over-HTTPS
+// (DoHResolver), a static file (LoadFile) or memory (StaticResolver).
+package redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNoMatch is returned when none of a host's rules match the request URL.
+var ErrNoMatch = errors.New("No paths matched")
+
+// DefaultResolver is the Resolver used by Resolve.
+var DefaultResolver Resolver = DNSResolver{}
+
+// RecordName returns the DNS name holding the redirect rules for host,
+// converting an internationalized host to punycode.
+func RecordName(host string) string {
+	if ascii, err := ASCIIHost(host); err == nil {
+		host = ascii
+	}
+	return "_redirect." + host
+}
+
+// Resolve looks up the rules for host using DefaultResolver and returns the
+// Redirect for url.
+func Resolve(ctx context.Context, host, url string) (*Redirect, error) {
+	return ResolveWith(ctx, DefaultResolver, host, url)
+}
+
+// ResolveWith is like Resolve but uses the given Resolver.
+func ResolveWith(ctx context.Context, resolver Resolver, host, url string) (*Redirect, error) {
+	rules, err := resolver.LookupConfig(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("Could not resolve hostname (%w)", err)
+	}
+	return Match(rules, url)
+}
+
+// ParseAll parses each record with Parse, skipping records that are not
+// redirect directives.
+func ParseAll(txt []string) []*Rule {
+	var rules []*Rule
+	for _, record := range txt {
+		if rule := Parse(record); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Match returns the Redirect for url given a host's rules. Rules with a
+// From path are tried first, in order; catch-all rules apply only when no
+// path matched. Rules for bots are skipped; goget rules redirect like any
+// other. The request is taken to be a GET, as of a followed link, without
+// headers meeting any rule's header condition; see MatchRequest.
+func Match(rules []*Rule, url string) (*Redirect, error) {
+	if r := match(rules, http.MethodGet, url, nil, false, Translate); r != nil {
+		return r, nil
+	}
+	return nil, ErrNoMatch
+}
+
+// MatchBot is like Match for a request from a bot: rules for bots are tried
+// first, the same way, then the others.
+func MatchBot(rules []*Rule, url string) (*Redirect, error) {
+	if r := match(rules, http.MethodGet, url, nil, true, Translate); r != nil {
+		return r, nil
+	}
+	return Match(rules, url)
+}
+
+// match returns the Redirect the rules whose Bots field is bots give a
+// method request for url with header: first those with a header condition
+// header meets, then those without one.
+func match(rules []*Rule, method, url string, header http.Header, bots bool, translate func(string, *Rule) *Redirect) *Redirect {
+	applies := func(rule *Rule) bool { return rule.Bots == bots && rule.allowsMethod(method) }
+	if header != nil {
+		if r := matchWhere(rules, url, translate, func(rule *Rule) bool {
+			return applies(rule) && rule.Header != "" && rule.headerMatches(header)
+		}); r != nil {
+			return r
+		}
+	}
+	return matchWhere(rules, url, translate, func(rule *Rule) bool { return applies(rule) && rule.Header == "" })
+}
+
+// matchWhere returns the Redirect the rules for which applies is true give
+// url, or nil: rules with a From path first, then catch-alls.
+func matchWhere(rules []*Rule, url string, translate func(string, *Rule) *Redirect, applies func(*Rule) bool) *Redirect {
+	for _, rule := range rules {
+		if rule.From == "" || !applies(rule) {
+			continue
+		}
+		if redirect := translate(url, rule); redirect != nil {
+			return redirect
+		}
+	}
+	for _, rule := range rules {
+		if rule.From != "" || !applies(rule) {
+			continue
+		}
+		if redirect := translate(url, rule); redirect != nil {
+			return redirect
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMatchSimple(t *testing.T) {
+	var redirect *Redirect
+	var err error
+
+	rules := ParseAll([]string{
+		"Redirects from /test/* to https://github.com/holic/*",
+	})
+
+	redirect, err = Match(rules, "/test/")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic/")
+
+	redirect, err = Match(rules, "/test/success")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic/success")
+
+	redirect, err = Match(rules, "/should/fail")
+	assertEqual(t, err, ErrNoMatch)
+	assertEqual(t, err.Error(), "No paths matched")
+}
+
+func TestMatchComplex(t *testing.T) {
+	// Tests that catchalls (even interspersed in the TXT records) apply
+	// only after more specific matches
+	var redirect *Redirect
+	var err error
+
+	rules := ParseAll([]string{
+		"Redirects from /test/* to https://github.com/holic/*",
+		"Redirects to https://github.com/holic",
+		"Redirects from /noglob/ to https://github.com/holic/noglob",
+	})
+
+	redirect, err = Match(rules, "/")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic")
+
+	redirect, err = Match(rules, "/test/somepath")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic/somepath")
+
+	redirect, err = Match(rules, "/noglob/")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic/noglob")
+
+	redirect, err = Match(rules, "/catch/all")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://github.com/holic")
+}
+
+func TestParseAll(t *testing.T) {
+	rules := ParseAll([]string{
+		"v=spf1 include:example.com ~all",
+		"Redirects to https://example.com/",
+	})
+	assertEqual(t, len(rules), 1)
+	assertEqual(t, rules[0].To, "https://example.com/")
+}
+
+func TestResolveWith(t *testing.T) {
+	resolver := StaticResolver{
+		"go.example.com": {"Redirects from /docs/* to https://docs.example.com/* permanently"},
+	}
+	ctx := context.Background()
+
+	redirect, err := ResolveWith(ctx, resolver, "go.example.com", "/docs/intro")
+	assertEqual(t, err, nil)
+	assertEqual(t, redirect.Location, "https://docs.example.com/intro")
+	assertEqual(t, redirect.Status, 301)
+
+	_, err = ResolveWith(ctx, resolver, "go.example.com", "/other")
+	assertEqual(t, err, ErrNoMatch)
+
+	_, err = ResolveWith(ctx, resolver, "missing.example.com", "/")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for unknown host, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrNotFound is returned (possibly wrapped) by a Resolver when it has no
+// configuration at all for a host, as opposed to failing to look it up.
+var ErrNotFound = errors.New("no redirect configuration found")
+
+// A Resolver looks up the redirect rules configured for a host.
+type Resolver interface {
+	LookupConfig(ctx context.Context, host string) ([]*Rule, error)
+}
+
+// ResolverFunc adapts an ordinary function to the Resolver interface.
+type ResolverFunc func(ctx context.Context, host string) ([]*Rule, error)
+
+func (f ResolverFunc) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	return f(ctx, host)
+}
+
+// DNSResolver reads rules from the TXT records at _redirect.<host> using the
+// system resolver.
+type DNSResolver struct {
+	// Resolver is used for lookups. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+}
+
+func (d DNSResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	txt, err := lookupTXT(ctx, RecordName(host), resolver.LookupTXT)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return nil, err
+	}
+	return parseAll(ctx, txt), nil
+}
+
+// StaticResolver serves rules from an in-memory map of host to TXT-style
+// records. It is useful for tests and for hosts configured without DNS.
+// Internationalized hosts may be keyed in Unicode or punycode.
+type StaticResolver map[string][]string
+
+func (s StaticResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	txt, ok := s[host]
+	if !ok && strings.Contains(host, "xn--") {
+		if unicode, err := idna.Lookup.ToUnicode(host); err == nil {
+			txt, ok = s[unicode]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNotFound, host)
+	}
+	return ParseAll(txt), nil
+}
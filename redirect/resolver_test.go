@@ -0,0 +1,37 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStaticResolver(t *testing.T) {
+	resolver := StaticResolver{
+		"go.example.com": {"v=spf1 -all", "Redirects to https://example.com/"},
+	}
+	ctx := context.Background()
+
+	rules, err := resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, len(rules), 1)
+	assertEqual(t, rules[0].To, "https://example.com/")
+
+	_, err = resolver.LookupConfig(ctx, "other.example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestResolverFunc(t *testing.T) {
+	var got string
+	resolver := ResolverFunc(func(ctx context.Context, host string) ([]*Rule, error) {
+		got = host
+		return ParseAll([]string{"Redirects to /new"}), nil
+	})
+
+	rules, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	assertEqual(t, err, nil)
+	assertEqual(t, got, "go.example.com")
+	assertEqual(t, rules[0].To, "/new")
+}
@@ -0,0 +1,41 @@
+package redirect
+
+import (
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var respondsRE = regexp.MustCompile(`^\s*Responds\s+to\s+(/\S*)\s+with\s+(?:([1-5][0-9][0-9])\s+)?("(?:[^"\\]|\\.)*"|[^"\s]\S*)(?:\s+as\s+(\S+))?\s*$`)
+
+// parseResponds parses `Responds to /ping with "pong"` into a rule
+// answering the path with a literal body. A status may precede the body,
+// as in `with 404 "gone"`, and a content type follow it, as in
+// `as application/json`; the defaults are 200 and text/plain. It returns
+// nil for other records, redirect statuses and invalid content types.
+func parseResponds(record string) *Rule {
+	m := respondsRE.FindStringSubmatch(record)
+	if m == nil {
+		return nil
+	}
+	if m[2] != "" {
+		if status, _ := strconv.Atoi(m[2]); status < 200 || (status >= 300 && status < 400) {
+			return nil
+		}
+	}
+	body := m[3]
+	if strings.HasPrefix(body, `"`) {
+		var err error
+		if body, err = strconv.Unquote(body); err != nil {
+			return nil
+		}
+	}
+	contentType := m[4]
+	if contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return nil
+		}
+	}
+	return &Rule{From: m[1], Responds: true, Serves: body, ContentType: contentType, RedirectState: m[2]}
+}
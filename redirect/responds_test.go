@@ -0,0 +1,58 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseResponds(t *testing.T) {
+	cases := []struct {
+		record, from, body, contentType, status string
+	}{
+		{`Responds to /ping with "pong"`, "/ping", "pong", "", ""},
+		{`Responds to /verify.txt with token-abc123`, "/verify.txt", "token-abc123", "", ""},
+		{`Responds to /status with 503 "{\"up\": false}" as application/json`, "/status", `{"up": false}`, "application/json", "503"},
+		{`Responds to /empty with 204 ""`, "/empty", "", "", "204"},
+	}
+	for _, c := range cases {
+		rule := Parse(c.record)
+		if rule == nil || !rule.Responds || rule.From != c.from || rule.Serves != c.body || rule.ContentType != c.contentType || rule.RedirectState != c.status {
+			t.Errorf("%s: got %+v", c.record, rule)
+			continue
+		}
+		if again := Parse(rule.String()); again == nil || *again != *rule {
+			t.Errorf("%s: %s doesn't parse back", c.record, rule.String())
+		}
+	}
+	for _, bad := range []string{
+		`Responds to /x with 302 "moved"`,
+		`Responds to /x with 99 "low"`,
+		`Responds to /x with "unterminated`,
+		`Responds to /x with "ok" as not a type`,
+		`Responds to x with "ok"`,
+	} {
+		if rule := Parse(bad); rule != nil && rule.Responds {
+			t.Errorf("%s: got %+v", bad, rule)
+		}
+	}
+}
+
+func TestHandlerResponds(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {`Responds to /ping with "pong"`, `Responds to /gone with 410 "{}" as application/json`, "Redirects to https://example.org/"},
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/ping", nil))
+	assertEqual(t, rr.Code, 200)
+	assertEqual(t, rr.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	assertEqual(t, rr.Body.String(), "pong")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/gone", nil))
+	assertEqual(t, rr.Code, 410)
+	assertEqual(t, rr.Header().Get("Content-Type"), "application/json")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/ping/x", nil))
+	assertEqual(t, rr.Code, 302)
+}
@@ -0,0 +1,43 @@
+package redirect
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooComplex is returned by RuleLimits.Check for a host whose rules go
+// past the limits. The handler answers its requests with the fallback,
+// giving the limit as the reason.
+var ErrTooComplex = errors.New("Rules exceed this server's limits")
+
+// RuleLimits bounds the rules the handler applies for a host, so that one
+// host's records can't make each of its requests costly for a service
+// shared with others. Zero fields are unlimited.
+type RuleLimits struct {
+	// Rules is the most rules a host may have.
+	Rules int
+	// PatternLength is the longest a rule's From may be, in bytes.
+	PatternLength int
+}
+
+// Check returns an error wrapping ErrTooComplex that names the first of
+// l rules go past, or nil if they're within them.
+func (l RuleLimits) Check(rules []*Rule) error {
+	if l.Rules > 0 && len(rules) > l.Rules {
+		return fmt.Errorf("%w: %d rules, more than the %d allowed", ErrTooComplex, len(rules), l.Rules)
+	}
+	if l.PatternLength > 0 {
+		for _, rule := range rules {
+			if len(rule.From) > l.PatternLength {
+				return fmt.Errorf("%w: a %d-byte pattern, longer than the %d allowed", ErrTooComplex, len(rule.From), l.PatternLength)
+			}
+		}
+	}
+	return nil
+}
+
+// WithRuleLimits applies l to each host's rules, answering requests for
+// hosts past them with the fallback rather than matching their rules.
+func WithRuleLimits(l RuleLimits) Option {
+	return func(h *handler) { h.ruleLimits = l }
+}
@@ -0,0 +1,53 @@
+package redirect
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRuleLimits(t *testing.T) {
+	long := "/" + strings.Repeat("a", 100) + "/*"
+	for _, tt := range []struct {
+		limits  RuleLimits
+		records []string
+		want    string
+	}{
+		{RuleLimits{}, []string{"Redirects to https://example.com/", "Redirects from " + long + " to https://example.com/*"}, ""},
+		{RuleLimits{Rules: 2}, []string{"Redirects from /a to https://a.example.com/", "Redirects to https://example.com/"}, ""},
+		{RuleLimits{Rules: 1}, []string{"Redirects from /a to https://a.example.com/", "Redirects to https://example.com/"}, "2 rules, more than the 1 allowed"},
+		{RuleLimits{PatternLength: 103}, []string{"Redirects from " + long + " to https://example.com/*"}, ""},
+		{RuleLimits{PatternLength: 64}, []string{"Redirects from " + long + " to https://example.com/*"}, "a 103-byte pattern, longer than the 64 allowed"},
+	} {
+		err := tt.limits.Check(ParseAll(tt.records))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.limits, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrTooComplex) || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%+v: want ErrTooComplex, %q, got %v", tt.limits, tt.want, err)
+		}
+	}
+}
+
+func TestHandlerRuleLimits(t *testing.T) {
+	h := NewHandler(WithRuleLimits(RuleLimits{Rules: 1}), WithResolver(StaticResolver{
+		"a.example.com": {"Redirects to https://example.com/"},
+		"b.example.com": {"Redirects from /a to https://a.example.com/", "Redirects to https://example.com/"},
+	}))
+	get := func(host string) string {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://"+host+"/", nil))
+		return rr.Header().Get("Location")
+	}
+	if loc := get("a.example.com"); loc != "https://example.com/" {
+		t.Errorf("within the limits: redirected to %q", loc)
+	}
+	if loc, want := get("b.example.com"), DefaultFallbackURL+"#reason="+url.QueryEscape("Rules exceed this server's limits: 2 rules, more than the 1 allowed"); loc != want {
+		t.Errorf("past the limits: want %q, got %q", want, loc)
+	}
+}
@@ -0,0 +1,131 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Well-known paths Matrix homeservers and clients discover a domain's
+// homeserver at.
+const (
+	MatrixServerPath = "/.well-known/matrix/server"
+	MatrixClientPath = "/.well-known/matrix/client"
+)
+
+// AtprotoDIDPath is where Bluesky verifies a domain handle, expecting the
+// account's DID as plain text.
+const AtprotoDIDPath = "/.well-known/atproto-did"
+
+// Well-known paths of the app association files that make iOS universal
+// links and Android app links open apps rather than the browser.
+const (
+	AppleAppSiteAssociationPath = "/.well-known/apple-app-site-association"
+	AssetLinksPath              = "/.well-known/assetlinks.json"
+)
+
+var servesFromRE = regexp.MustCompile(`^\s*Serves\s+(/\.well-known/\S+)\s+from\s+(https://\S+)\s*$`)
+var servesRE = regexp.MustCompile(`^\s*Serves\s+(/\.well-known/\S+)\s+(.+?)\s*$`)
+var servesDIDRE = regexp.MustCompile(`^\s*Serves\s+did\s+(did:[a-z]+:[a-zA-Z0-9._:%-]+)\s*$`)
+
+// parseServes parses "Serves /.well-known/<path> <json>" and the Matrix
+// delegation records matrix-server=<host[:port]> and
+// matrix-client=<base URL> into rules serving a literal JSON body, and
+// "Serves did <did>" into one serving an atproto DID, and "Serves
+// /.well-known/<path> from <url>" into one serving a JSON document fetched
+// from url. It returns nil for other records, and for invalid paths,
+// bodies or DIDs.
+func parseServes(record string) *Rule {
+	record = strings.TrimSpace(record)
+	if key, value, ok := strings.Cut(record, "="); ok {
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "matrix-server":
+			if _, err := ParseHost(value); err != nil || strings.ContainsAny(value, "/?#@") {
+				return nil
+			}
+			body, _ := json.Marshal(map[string]string{"m.server": value})
+			return &Rule{From: MatrixServerPath, Serves: string(body)}
+		case "matrix-client":
+			if u, err := url.Parse(value); err != nil || u.Scheme != "https" || u.Host == "" {
+				return nil
+			}
+			body, _ := json.Marshal(map[string]any{"m.homeserver": map[string]string{"base_url": value}})
+			return &Rule{From: MatrixClientPath, Serves: string(body)}
+		}
+	}
+	if m := servesDIDRE.FindStringSubmatch(record); m != nil {
+		return &Rule{From: AtprotoDIDPath, Serves: m[1]}
+	}
+	if m := servesFromRE.FindStringSubmatch(record); m != nil {
+		if path.Clean(m[1]) != m[1] || ValidateTarget(m[2]) != nil {
+			return nil
+		}
+		return &Rule{From: m[1], ServesFrom: m[2]}
+	}
+	m := servesRE.FindStringSubmatch(record)
+	if m == nil || path.Clean(m[1]) != m[1] || !json.Valid([]byte(m[2])) {
+		return nil
+	}
+	return &Rule{From: m[1], Serves: m[2]}
+}
+
+// MatchServes returns the rule among rules serving a body at path, or nil.
+// Apple's older /apple-app-site-association path is served like
+// AppleAppSiteAssociationPath.
+func MatchServes(rules []*Rule, path string) *Rule {
+	if path == "/apple-app-site-association" {
+		path = AppleAppSiteAssociationPath
+	}
+	for _, rule := range rules {
+		if (rule.Serves != "" || rule.ServesFrom != "" || rule.Responds) && rule.From == path {
+			return rule
+		}
+	}
+	return nil
+}
+
+// serveBody answers with rule's body. Those of Serves rules are readable
+// from any origin, as Matrix clients require, and JSON but for
+// AtprotoDIDPath's. Those of Responds rules have their own content type
+// and status.
+func (h *handler) serveBody(ctx context.Context, w http.ResponseWriter, rule *Rule) {
+	body := []byte(rule.Serves)
+	if rule.Responds {
+		contentType, status := rule.ContentType, http.StatusOK
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		if rule.RedirectState != "" {
+			status, _ = strconv.Atoi(rule.RedirectState)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	if rule.ServesFrom != "" {
+		ctx, span := startSpan(ctx, "redirect.document")
+		var err error
+		body, err = h.documents.get(ctx, rule.ServesFrom)
+		span.End(err)
+		if err != nil {
+			http.Error(w, "Could not fetch "+rule.ServesFrom, http.StatusBadGateway)
+			return
+		}
+	}
+	if rule.From == AtprotoDIDPath {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write(body)
+}
@@ -0,0 +1,122 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseServes(t *testing.T) {
+	cases := []struct {
+		record, from, serves string
+	}{
+		{"matrix-server=matrix.example.org:443", MatrixServerPath, `{"m.server":"matrix.example.org:443"}`},
+		{"Matrix-Client = https://matrix.example.org", MatrixClientPath, `{"m.homeserver":{"base_url":"https://matrix.example.org"}}`},
+		{"Serves did did:plc:z72i7hdynmk6r22z27h6tvur", AtprotoDIDPath, "did:plc:z72i7hdynmk6r22z27h6tvur"},
+		{`Serves /.well-known/nostr.json {"names": {"bob": "b0b"}}`, "/.well-known/nostr.json", `{"names": {"bob": "b0b"}}`},
+	}
+	for _, c := range cases {
+		rule := Parse(c.record)
+		if rule == nil || rule.From != c.from || rule.Serves != c.serves || rule.To != "" {
+			t.Errorf("%s: got %+v", c.record, rule)
+			continue
+		}
+		if again := Parse(rule.String()); again == nil || *again != *rule {
+			t.Errorf("%s: %q doesn't parse back", c.record, rule.String())
+		}
+	}
+	for _, bad := range []string{
+		"matrix-server=https://matrix.example.org",
+		"matrix-client=http://matrix.example.org",
+		"Serves /.well-known/x {not json}",
+		`Serves /.well-known/../admin {}`,
+		`Serves /admin {}`,
+		"Serves did plc:xyz",
+		"Serves did did:plc:xyz extra",
+		"Serves /.well-known/assetlinks.json from http://example.com/links.json",
+	} {
+		if rule := Parse(bad); rule != nil && rule.Serves != "" {
+			t.Errorf("%s: got %+v", bad, rule)
+		}
+	}
+}
+
+func TestHandlerServes(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {"matrix-server=matrix.example.org:443", "Serves did did:web:example.com", "Redirects to https://example.org/"},
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+MatrixServerPath, nil))
+	assertEqual(t, rr.Code, 200)
+	assertEqual(t, rr.Header().Get("Content-Type"), "application/json")
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "*")
+	assertEqual(t, rr.Body.String(), `{"m.server":"matrix.example.org:443"}`)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+AtprotoDIDPath, nil))
+	assertEqual(t, rr.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	assertEqual(t, rr.Body.String(), "did:web:example.com")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+MatrixClientPath, nil))
+	assertEqual(t, rr.Code, 302)
+}
+
+func TestHandlerServesFrom(t *testing.T) {
+	fetches := 0
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/aasa.json":
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, `{"applinks":{"details":[]}}`)
+		case "/broken.json":
+			fmt.Fprint(w, `<html>`)
+		}
+	}))
+	defer ts.Close()
+	records := []string{
+		"Serves " + AppleAppSiteAssociationPath + " from " + ts.URL + "/aasa.json",
+		"Serves " + AssetLinksPath + " from " + ts.URL + "/broken.json",
+		"Redirects to https://example.org/",
+	}
+	assertEqual(t, Parse(records[0]).String(), records[0])
+	h := NewHandler(WithResolver(StaticResolver{"example.com": records}), WithDocumentClient(ts.Client()))
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+path, nil))
+		return rr
+	}
+
+	for _, path := range []string{AppleAppSiteAssociationPath, "/apple-app-site-association"} {
+		rr := get(path)
+		assertEqual(t, rr.Code, 200)
+		assertEqual(t, rr.Header().Get("Content-Type"), "application/json")
+		assertEqual(t, rr.Body.String(), `{"applinks":{"details":[]}}`)
+	}
+	assertEqual(t, fetches, 1)
+	assertEqual(t, get(AssetLinksPath).Code, 502)
+}
+
+func TestDocumentsEviction(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+	now := time.Unix(1000, 0)
+	d := &documents{client: ts.Client(), now: func() time.Time { return now }}
+	d.store("https://warm.example/doc.json", document{body: []byte(`{}`), expires: now.Add(time.Hour)})
+	for i := range maxDocuments - 1 {
+		d.store(fmt.Sprintf("https://%d.example/doc.json", i), document{expires: now})
+	}
+
+	if _, err := d.get(context.Background(), ts.URL+"/doc.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.docs["https://warm.example/doc.json"]; !ok || len(d.docs) != 2 {
+		t.Errorf("want the expired documents evicted and the warm one kept, got %d documents", len(d.docs))
+	}
+}
@@ -0,0 +1,101 @@
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A Matcher decides a request's redirect from its host's rules, as
+// MatchRequest does. Handlers match with MatchRequest; another Matcher,
+// such as a new engine being migrated to, can be evaluated alongside it
+// with WithShadowMatcher.
+type Matcher func(rules []*Rule, r *http.Request, bot bool) (*Redirect, error)
+
+// A Divergence is a request a shadow Matcher decided differently from
+// MatchRequest, which decided the response.
+type Divergence struct {
+	Host string
+	// URL is the request's path and query.
+	URL       string
+	Served    *Redirect
+	ServedErr error
+	Shadow    *Redirect
+	ShadowErr error
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s%s: served %s, shadow %s", d.Host, d.URL, decision(d.Served, d.ServedErr), decision(d.Shadow, d.ShadowErr))
+}
+
+// decision describes a Matcher's result for a Divergence.
+func decision(r *Redirect, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error %q", err)
+	}
+	return fmt.Sprintf("%d to %s by %q", r.Status, r.Location, r.Rule)
+}
+
+// WithShadowMatcher evaluates m for every request MatchRequest decides,
+// calling report with the request when they disagree: on a different
+// location, status or rule, or one failing where the other doesn't. The
+// response is always MatchRequest's, so that a new matching engine, such
+// as one with new precedence rules, can be tried on real traffic before it
+// serves any. A panic in m is reported as its error. m runs in the
+// request, so it adds its time to each.
+func WithShadowMatcher(m Matcher, report func(*http.Request, Divergence)) Option {
+	return func(h *handler) { h.shadow, h.reportDivergence = m, report }
+}
+
+// compareShadow evaluates h.shadow for r, reporting if it diverges from
+// the served result.
+func (h *handler) compareShadow(r *http.Request, host string, rules []*Rule, bot bool, served *Redirect, servedErr error) {
+	d := Divergence{Host: host, URL: r.URL.RequestURI(), Served: served, ServedErr: servedErr}
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				d.Shadow, d.ShadowErr = nil, fmt.Errorf("panic: %v", p)
+			}
+		}()
+		d.Shadow, d.ShadowErr = h.shadow(rules, r, bot)
+	}()
+	if !d.diverges() {
+		return
+	}
+	h.reportDivergence(r, d)
+}
+
+func (d Divergence) diverges() bool {
+	if d.ServedErr != nil || d.ShadowErr != nil {
+		return d.ServedErr == nil || d.ShadowErr == nil || d.ServedErr.Error() != d.ShadowErr.Error()
+	}
+	return d.Served.Location != d.Shadow.Location || d.Served.Status != d.Shadow.Status || d.Served.Rule != d.Shadow.Rule
+}
+
+// RegexpMatcher is MatchRequest as it matched From patterns before they
+// were compiled into a prefix and suffix: with an expression compiled for
+// each request. It's kept as a shadow Matcher to check the faster
+// engine against.
+func RegexpMatcher(rules []*Rule, r *http.Request, bot bool) (*Redirect, error) {
+	return matchRequest(rules, r.Method, r.URL.String(), r.Header, bot, translateRegexp)
+}
+
+// translateRegexp is Translate for RegexpMatcher.
+func translateRegexp(uri string, rule *Rule) *Redirect {
+	if rule == nil || rule.From == "" || rule.Canonical != "" {
+		return Translate(uri, rule)
+	}
+	if uri == "" || rule.To == "" {
+		return nil
+	}
+	fromRE := regexp.MustCompile(patternExpr(rule.From))
+	if !fromRE.MatchString(uri) {
+		return nil
+	}
+	redirect := &Redirect{Location: rule.To, Status: redirectStatus(rule.RedirectState), Rule: rule}
+	if strings.Contains(rule.From, "*") {
+		redirect.Location = fromRE.ReplaceAllString(uri, strings.Replace(redirect.Location, "*", "${1}", 1))
+	}
+	return redirect
+}
@@ -0,0 +1,80 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShadowMatcher(t *testing.T) {
+	resolver := StaticResolver{"go.example.com": []string{
+		"Redirects from /docs/* to https://docs.example.com/*",
+		"Redirects to https://example.com/",
+	}}
+	for _, tc := range []struct {
+		name   string
+		shadow Matcher
+		want   string // the shadow's part of the Divergence; "" if none
+	}{
+		{"agreeing", MatchRequest, ""},
+		{"diverging", func(rules []*Rule, r *http.Request, bot bool) (*Redirect, error) {
+			return &Redirect{Location: "https://elsewhere.example.com/", Status: 301, Rule: rules[1]}, nil
+		}, `shadow 301 to https://elsewhere.example.com/ by "Redirects to https://example.com/"`},
+		{"failing", func(rules []*Rule, r *http.Request, bot bool) (*Redirect, error) {
+			return nil, ErrNoMatch
+		}, `shadow error "No paths matched"`},
+		{"panicking", func(rules []*Rule, r *http.Request, bot bool) (*Redirect, error) {
+			panic("oops")
+		}, `shadow error "panic: oops"`},
+	} {
+		var reported []Divergence
+		h := NewHandler(WithResolver(resolver), WithShadowMatcher(tc.shadow, func(r *http.Request, d Divergence) {
+			reported = append(reported, d)
+		}))
+		req := httptest.NewRequest("GET", "/docs/intro?x=1", nil)
+		req.Host = "go.example.com"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if loc := rr.Header().Get("Location"); rr.Code != http.StatusFound || loc != "https://docs.example.com/intro?x=1" {
+			t.Errorf("%s: served %d %s", tc.name, rr.Code, loc)
+		}
+		if tc.want == "" {
+			if len(reported) != 0 {
+				t.Errorf("%s: reported %v", tc.name, reported)
+			}
+			continue
+		}
+		if len(reported) != 1 {
+			t.Fatalf("%s: reported %v", tc.name, reported)
+		}
+		got := reported[0].String()
+		if !strings.HasPrefix(got, `go.example.com/docs/intro?x=1: served 302 to https://docs.example.com/intro?x=1 by "Redirects from /docs/* to https://docs.example.com/*", `) || !strings.HasSuffix(got, tc.want) {
+			t.Errorf("%s: reported %s", tc.name, got)
+		}
+	}
+}
+
+func TestRegexpMatcher(t *testing.T) {
+	rules := ParseAll([]string{
+		"Redirects from /docs/* to https://docs.example.com/*",
+		"Redirects from /*.html to https://example.com/$1/*?v=$$",
+		"Redirects POST from /hook to https://hooks.example.com/",
+		"Redirects from /exact to https://example.com/exact permanently",
+		"Redirects bots to https://example.com/bots",
+		"Redirects to https://example.com/ with 307",
+	})
+	for _, target := range []string{"/docs/a/b?c=d", "/docs/", "/docs", "/page.html", "/hook", "/exact", "/exact/", "/", "/other?q"} {
+		for _, method := range []string{"GET", "POST"} {
+			r := httptest.NewRequest(method, target, nil)
+			for _, bot := range []bool{false, true} {
+				want, wantErr := MatchRequest(rules, r, bot)
+				got, err := RegexpMatcher(rules, r, bot)
+				d := Divergence{URL: target, Served: want, ServedErr: wantErr, Shadow: got, ShadowErr: err}
+				if d.diverges() {
+					t.Errorf("%s %s (bot %v): %s", method, target, bot, d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package redirect
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Paths of the text files hosts can configure with flag records, which
+// scanners and crawlers fetch without following redirects.
+const (
+	SecurityTxtPath = "/.well-known/security.txt"
+	RobotsTxtPath   = "/robots.txt"
+)
+
+// textFileFlags maps the flag records making up security.txt (RFC 9116)
+// and robots.txt to their fields and a check of their values.
+var textFileFlags = map[string]struct {
+	field string
+	valid func(string) bool
+}{
+	"security-contact":    {"Contact", isContactURI},
+	"security-policy":     {"Policy", isHTTPSURL},
+	"security-encryption": {"Encryption", isHTTPSURL},
+	"robots-disallow":     {"Disallow", isRobotsPath},
+	"robots-allow":        {"Allow", isRobotsPath},
+	"robots-sitemap":      {"Sitemap", isHTTPSURL},
+}
+
+func isContactURI(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "mailto" && u.Opaque != "" || u.Scheme == "tel" && u.Opaque != "" || isHTTPSURL(v))
+}
+
+func isHTTPSURL(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func isRobotsPath(v string) bool {
+	return strings.HasPrefix(v, "/") && !strings.ContainsAny(v, " \t")
+}
+
+// flagValues returns the values of every key=value flag record for key
+// among rules, in order.
+func flagValues(rules []*Rule, key string) []string {
+	var values []string
+	for _, r := range rules {
+		if k, v, ok := strings.Cut(r.Flag, "="); ok && k == key {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// textFile returns the security.txt or robots.txt that rules' flag
+// records make up for path, or false if path isn't one of them or rules
+// configure neither. A security.txt needs a security-contact= record, and
+// expires a year after now as RFC 9116 requires an expiry.
+func textFile(rules []*Rule, path string, now time.Time) (string, bool) {
+	var b strings.Builder
+	switch path {
+	case SecurityTxtPath, "/security.txt":
+		contacts := flagValues(rules, "security-contact")
+		if len(contacts) == 0 {
+			return "", false
+		}
+		for _, c := range contacts {
+			b.WriteString("Contact: " + c + "\n")
+		}
+		b.WriteString("Expires: " + now.UTC().AddDate(1, 0, 0).Truncate(24*time.Hour).Format(time.RFC3339) + "\n")
+		for _, key := range []string{"security-policy", "security-encryption"} {
+			for _, v := range flagValues(rules, key) {
+				b.WriteString(textFileFlags[key].field + ": " + v + "\n")
+			}
+		}
+	case RobotsTxtPath:
+		disallow, allow, sitemaps := flagValues(rules, "robots-disallow"), flagValues(rules, "robots-allow"), flagValues(rules, "robots-sitemap")
+		if len(disallow)+len(allow)+len(sitemaps) == 0 {
+			return "", false
+		}
+		b.WriteString("User-agent: *\n")
+		for _, v := range allow {
+			b.WriteString("Allow: " + v + "\n")
+		}
+		for _, v := range disallow {
+			b.WriteString("Disallow: " + v + "\n")
+		}
+		if len(allow)+len(disallow) == 0 {
+			b.WriteString("Disallow:\n")
+		}
+		for _, v := range sitemaps {
+			b.WriteString("Sitemap: " + v + "\n")
+		}
+	default:
+		return "", false
+	}
+	return b.String(), true
+}
+
+// serveTextFile answers with a text file from textFile.
+func (h *handler) serveTextFile(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Write([]byte(body))
+}
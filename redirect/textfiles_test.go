@@ -0,0 +1,60 @@
+package redirect
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTextFile(t *testing.T) {
+	rules := ParseAll([]string{
+		"security-contact=mailto:security@example.com",
+		"Security-Contact = https://example.com/report",
+		"security-policy=https://example.com/policy",
+		"security-contact=security@example.com",
+		"robots-disallow=/private",
+		"robots-disallow=/tmp/",
+		"robots-sitemap=https://example.com/sitemap.xml",
+		"robots-allow=private",
+		"Redirects to https://example.org/",
+	})
+	assertEqual(t, len(rules), 7)
+	now := time.Date(2026, 3, 4, 15, 4, 5, 0, time.UTC)
+
+	security, ok := textFile(rules, SecurityTxtPath, now)
+	assertEqual(t, ok, true)
+	assertEqual(t, security, "Contact: mailto:security@example.com\n"+
+		"Contact: https://example.com/report\n"+
+		"Expires: 2027-03-04T00:00:00Z\n"+
+		"Policy: https://example.com/policy\n")
+	legacy, _ := textFile(rules, "/security.txt", now)
+	assertEqual(t, legacy, security)
+
+	robots, ok := textFile(rules, RobotsTxtPath, now)
+	assertEqual(t, ok, true)
+	assertEqual(t, robots, "User-agent: *\nDisallow: /private\nDisallow: /tmp/\nSitemap: https://example.com/sitemap.xml\n")
+
+	if _, ok := textFile(rules[4:], SecurityTxtPath, now); ok {
+		t.Error("security.txt without a contact: want none")
+	}
+	if _, ok := textFile(rules, "/other.txt", now); ok {
+		t.Error("other path: want none")
+	}
+	sitemapOnly, _ := textFile(ParseAll([]string{"robots-sitemap=https://example.com/s.xml"}), RobotsTxtPath, now)
+	assertEqual(t, sitemapOnly, "User-agent: *\nDisallow:\nSitemap: https://example.com/s.xml\n")
+}
+
+func TestHandlerTextFiles(t *testing.T) {
+	h := NewHandler(WithResolver(StaticResolver{
+		"example.com": {"robots-disallow=/", "Redirects to https://example.org/"},
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/robots.txt", nil))
+	assertEqual(t, rr.Code, 200)
+	assertEqual(t, rr.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	assertEqual(t, rr.Body.String(), "User-agent: *\nDisallow: /\n")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+SecurityTxtPath, nil))
+	assertEqual(t, rr.Code, 302)
+}
@@ -0,0 +1,67 @@
+package redirect
+
+import "context"
+
+// A Tracer records spans for the stages of handling a request: the config
+// lookup, the TXT query and parse inside DNSResolver and DoHResolver, and
+// matching the request against the rules. Adapt it to OpenTelemetry or
+// another tracing library with WithTracer.
+type Tracer interface {
+	// Start begins a span named name as a child of any span in ctx, and
+	// returns a context carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is one timed operation started by a Tracer.
+type Span interface {
+	// SetAttribute records a string, bool, int or float64 attribute.
+	SetAttribute(key string, value any)
+	// End finishes the span, marking it failed if err is not nil.
+	End(err error)
+}
+
+// WithTracer records spans with t for each request handled. The lookup
+// spans are started from the request's context, so they join a trace begun
+// by middleware using the same Tracer.
+func WithTracer(t Tracer) Option {
+	return func(h *handler) { h.tracer = t }
+}
+
+type tracerKey struct{}
+
+func withTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// startSpan starts a span with the Tracer in ctx, or a no-op span if there
+// is none.
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if t, ok := ctx.Value(tracerKey{}).(Tracer); ok {
+		return t.Start(ctx, name)
+	}
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) End(error)                {}
+
+// lookupTXT runs lookup for name in a span.
+func lookupTXT(ctx context.Context, name string, lookup func(context.Context, string) ([]string, error)) ([]string, error) {
+	ctx, span := startSpan(ctx, "redirect.txt_lookup")
+	span.SetAttribute("dns.question.name", name)
+	txt, err := lookup(ctx, name)
+	span.SetAttribute("redirect.records", len(txt))
+	span.End(err)
+	return txt, err
+}
+
+// parseAll runs ParseAll in a span.
+func parseAll(ctx context.Context, txt []string) []*Rule {
+	_, span := startSpan(ctx, "redirect.parse")
+	rules := ParseAll(txt)
+	span.SetAttribute("redirect.rules", len(rules))
+	span.End(nil)
+	return rules
+}
@@ -0,0 +1,62 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingTracer records the spans started with it and their parents.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	parent, name string
+	attrs        []string
+	ended        bool
+}
+
+type spanNameKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanNameKey{}).(string)
+	s := &recordedSpan{parent: parent, name: name}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanNameKey{}, name), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) {
+	s.attrs = append(s.attrs, fmt.Sprintf("%s=%v", key, value))
+}
+
+func (s *recordedSpan) End(err error) { s.ended = true }
+
+func TestHandlerTracing(t *testing.T) {
+	ts := newDoHServer(t, map[string][]string{
+		"_redirect.go.example.com.": {"Redirects to https://example.com/"},
+	})
+	tracer := new(recordingTracer)
+	h := NewHandler(WithResolver(&DoHResolver{URL: ts.URL, Client: ts.Client()}), WithTracer(tracer))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got []string
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Errorf("%s: not ended", s.name)
+		}
+		got = append(got, s.parent+">"+s.name+" "+strings.Join(s.attrs, " "))
+	}
+	want := []string{
+		">redirect.lookup redirect.host=go.example.com redirect.cache_hit=false",
+		"redirect.lookup>redirect.txt_lookup dns.question.name=_redirect.go.example.com redirect.records=1",
+		"redirect.lookup>redirect.parse redirect.rules=1",
+		">redirect.translate redirect.rule=Redirects to https://example.com/",
+	}
+	assertEqual(t, strings.Join(got, "\n"), strings.Join(want, "\n"))
+}
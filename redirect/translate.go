@@ -0,0 +1,70 @@
+package redirect
+
+import "strings"
+
+// A Redirect is the response computed for a request URL.
+type Redirect struct {
+	Location string
+	Status   int
+	// Rule is the rule that produced the redirect.
+	Rule *Rule
+}
+
+// Translate applies rule to uri. It returns nil if the rule does not match,
+// so the caller can move on to the next rule.
+func Translate(uri string, rule *Rule) *Redirect {
+	if uri == "" {
+		return nil
+	}
+	if rule == nil {
+		return nil
+	}
+	if rule.To == "" && rule.Canonical == "" {
+		return nil
+	}
+
+	// a `From` pattern that doesn't match returns before the Redirect is
+	// built, so the rules tried before the one matching cost nothing
+	var from *pattern
+	var capture string
+	if rule.Canonical == "" && rule.From != "" {
+		from = patterns.compile(rule.From)
+		var ok bool
+		// if we can't find the pattern, return to continue to next record
+		if capture, ok = from.match(uri); !ok {
+			return nil
+		}
+	}
+
+	redirect := &Redirect{Location: rule.To, Status: redirectStatus(rule.RedirectState), Rule: rule}
+
+	// a canonical host keeps the path and query, and the request's scheme
+	if rule.Canonical != "" {
+		if !strings.HasPrefix(uri, "/") {
+			uri = "/" + uri
+		}
+		redirect.Location = "//" + rule.Canonical + uri
+		return redirect
+	}
+
+	// wildcard replacement of `uri` if there's a wildcard in our `From` path;
+	// no `From` assumes catch-all, so redirect immediately to `Location`
+	if from != nil && from.wildcard {
+		redirect.Location = from.expand(uri, capture, redirect.Location)
+	}
+
+	return redirect
+}
+
+// redirectStatus returns the status of a rule's RedirectState.
+func redirectStatus(state string) int {
+	switch state {
+	case "301", "permanently":
+		return 301
+	case "307":
+		return 307
+	case "308":
+		return 308
+	}
+	return 302
+}
@@ -0,0 +1,90 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebFingerPath is where fediverse servers look up a handle such as
+// @alice@example.com (RFC 7033).
+const WebFingerPath = "/.well-known/webfinger"
+
+// maxWebFingerBody bounds proxied WebFinger responses.
+const maxWebFingerBody = 64 << 10
+
+// webFingerTarget returns the URL answering a WebFinger query for a host
+// whose webfinger= record is delegate, given the query's raw query string.
+// A delegate naming an account, as in "@alice@mastodon.social", answers
+// every query with that account. One naming an instance, as in
+// "mastodon.social", passes the query on unchanged.
+func webFingerTarget(delegate, rawQuery string) (string, error) {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil || query.Get("resource") == "" {
+		return "", fmt.Errorf("no resource in WebFinger query")
+	}
+	instance := strings.TrimPrefix(strings.TrimPrefix(delegate, "https://"), "acct:")
+	if user, domain, ok := strings.Cut(strings.TrimPrefix(instance, "@"), "@"); ok {
+		if user == "" {
+			return "", fmt.Errorf("webfinger=%s: empty user", delegate)
+		}
+		instance = domain
+		query.Set("resource", "acct:"+user+"@"+domain)
+	}
+	instance = strings.TrimSuffix(instance, "/")
+	if _, err := ParseHost(instance); err != nil || strings.ContainsAny(instance, "/?#@") {
+		return "", fmt.Errorf("webfinger=%s: not an instance or account", delegate)
+	}
+	return "https://" + instance + WebFingerPath + "?" + query.Encode(), nil
+}
+
+// WithWebFingerProxy makes the handler fetch the answers to
+// /.well-known/webfinger queries for hosts with a webfinger= record with
+// client, rather than redirecting to them. Some fediverse servers don't
+// follow redirects for WebFinger.
+func WithWebFingerProxy(client *http.Client) Option {
+	return func(h *handler) { h.webFinger = client }
+}
+
+// serveWebFinger answers a WebFinger query for a host whose webfinger=
+// record is delegate.
+func (h *handler) serveWebFinger(ctx context.Context, w http.ResponseWriter, r *http.Request, delegate string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	target, err := webFingerTarget(delegate, r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.webFinger == nil {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ctx, span := startSpan(ctx, "redirect.webfinger")
+	resp, err := h.fetchWebFinger(ctx, target)
+	span.End(err)
+	if err != nil {
+		http.Error(w, "WebFinger delegate unreachable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.LimitReader(resp.Body, maxWebFingerBody))
+}
+
+func (h *handler) fetchWebFinger(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jrd+json, application/json")
+	return h.webFinger.Do(req)
+}
@@ -0,0 +1,72 @@
+package redirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWebFingerTarget(t *testing.T) {
+	cases := []struct {
+		delegate, query, want string
+	}{
+		{"@alice@mastodon.social", "resource=acct:me@example.com", "https://mastodon.social/.well-known/webfinger?resource=acct%3Aalice%40mastodon.social"},
+		{"acct:alice@mastodon.social", "resource=acct:me@example.com&rel=self", "https://mastodon.social/.well-known/webfinger?rel=self&resource=acct%3Aalice%40mastodon.social"},
+		{"https://social.example.org/", "resource=acct:bob@example.com", "https://social.example.org/.well-known/webfinger?resource=acct%3Abob%40example.com"},
+	}
+	for _, c := range cases {
+		got, err := webFingerTarget(c.delegate, c.query)
+		if err != nil || got != c.want {
+			t.Errorf("%s ? %s: got %q, %v, want %q", c.delegate, c.query, got, err, c.want)
+		}
+	}
+	for _, bad := range []string{"", "@@mastodon.social", "mastodon.social/users", "http://127.0.0.1:8080/x"} {
+		if _, err := webFingerTarget(bad, "resource=acct:x"); err == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+	if _, err := webFingerTarget("mastodon.social", ""); err == nil {
+		t.Error("no resource: want an error")
+	}
+	if Parse("webfinger=not a host") != nil {
+		t.Error("invalid webfinger record: want it ignored")
+	}
+	assertEqual(t, Parse("WebFinger= @alice@mastodon.social").Flag, "webfinger=@alice@mastodon.social")
+}
+
+func TestHandlerWebFinger(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jrd+json")
+		fmt.Fprintf(w, `{"subject":%q}`, r.URL.Query().Get("resource"))
+	}))
+	defer ts.Close()
+	instance, _ := url.Parse(ts.URL)
+	resolver := StaticResolver{
+		"example.com": {"Redirects to https://example.com/home", "webfinger=@alice@" + instance.Host},
+	}
+	get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com"+path, nil))
+		return rr
+	}
+
+	h := NewHandler(WithResolver(resolver))
+	rr := get(h, WebFingerPath+"?resource=acct:me@example.com")
+	assertEqual(t, rr.Code, 302)
+	assertEqual(t, rr.Header().Get("Location"), ts.URL+WebFingerPath+"?resource=acct%3Aalice%40"+url.QueryEscape(instance.Host))
+	assertEqual(t, rr.Header().Get("Access-Control-Allow-Origin"), "*")
+	assertEqual(t, get(h, WebFingerPath).Code, 400)
+	assertEqual(t, get(h, "/other").Code, 302)
+
+	h = NewHandler(WithResolver(resolver), WithWebFingerProxy(ts.Client()))
+	rr = get(h, WebFingerPath+"?resource=acct:me@example.com")
+	assertEqual(t, rr.Code, 200)
+	assertEqual(t, rr.Header().Get("Content-Type"), "application/jrd+json")
+	assertEqual(t, rr.Body.String(), `{"subject":"acct:alice@`+instance.Host+`"}`)
+
+	h = NewHandler(WithResolver(StaticResolver{"example.com": {"Redirects to https://example.com/home"}}))
+	rr = get(h, WebFingerPath+"?resource=acct:me@example.com")
+	assertEqual(t, rr.Header().Get("Location"), "https://example.com/home")
+}
@@ -0,0 +1,211 @@
+package redirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/sync/singleflight"
+)
+
+// WellKnownPath is where WellKnownResolver fetches rules from a host's apex
+// domain. The document uses the JSON form of LoadFile, mapping each host to
+// its records.
+const WellKnownPath = "/.well-known/redirect.name.json"
+
+// WellKnownResolver reads rules from https://<apex>/.well-known/redirect.name.json,
+// for users whose DNS provider mangles long TXT values. Documents are cached
+// per apex for their Cache-Control max-age, or TTL if they don't send one.
+// Lookups while an apex's document is being fetched wait for that fetch.
+type WellKnownResolver struct {
+	// Client is used for fetches. If nil, a client with a 5s timeout is used.
+	Client *http.Client
+	// TTL is how long documents (and their absence) are cached when the
+	// response doesn't say. Zero means 5 minutes.
+	TTL time.Duration
+	// MaxEntries bounds the number of cached apexes. Zero means 10000.
+	MaxEntries int
+
+	mu      sync.Mutex
+	docs    map[string]wellKnownDoc
+	fetches singleflight.Group
+	now     func() time.Time
+}
+
+type wellKnownDoc struct {
+	hosts   StaticResolver
+	err     error
+	expires time.Time
+}
+
+var defaultWellKnownClient = &http.Client{Timeout: 5 * time.Second}
+
+// wellKnownFetchHeader marks the requests of a WellKnownResolver's fetches,
+// so that one arriving back at the handler, because the apex points at
+// this server, isn't answered by fetching the same document again.
+const wellKnownFetchHeader = "Redirect-Name-Fetch"
+
+type wellKnownFetchKey struct{}
+
+// withWellKnownFetch marks ctx as the lookup of a request r that a
+// WellKnownResolver's own fetch made, if it is one.
+func withWellKnownFetch(ctx context.Context, r *http.Request) context.Context {
+	if r.URL.Path != WellKnownPath || r.Header.Get(wellKnownFetchHeader) == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, wellKnownFetchKey{}, true)
+}
+
+func (k *WellKnownResolver) LookupConfig(ctx context.Context, host string) ([]*Rule, error) {
+	apex, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrNotFound, host, err)
+	}
+	if ctx.Value(wellKnownFetchKey{}) != nil {
+		return nil, fmt.Errorf("%w for %s: the request is a fetch of %s's document", ErrNotFound, host, apex)
+	}
+
+	now := k.clock()
+	k.mu.Lock()
+	doc, ok := k.docs[apex]
+	k.mu.Unlock()
+	if !ok || !now.Before(doc.expires) {
+		// The fetch outlives a waiter giving up, so the others still get
+		// its result.
+		fetched := k.fetches.DoChan(apex, func() (any, error) {
+			doc := k.fetch(context.WithoutCancel(ctx), apex, now)
+			if doc.expires.After(now) {
+				k.store(apex, doc)
+			}
+			return doc, nil
+		})
+		select {
+		case res := <-fetched:
+			doc = res.Val.(wellKnownDoc)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if doc.err != nil {
+		return nil, doc.err
+	}
+	return doc.hosts.LookupConfig(ctx, host)
+}
+
+func (k *WellKnownResolver) store(apex string, doc wellKnownDoc) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.docs == nil {
+		k.docs = make(map[string]wellKnownDoc)
+	}
+	max := k.MaxEntries
+	if max <= 0 {
+		max = 10000
+	}
+	if _, ok := k.docs[apex]; !ok && len(k.docs) >= max {
+		k.evict(max)
+	}
+	k.docs[apex] = doc
+}
+
+// evict makes room for one more document, dropping expired ones first and
+// then arbitrary ones. k.mu must be held.
+func (k *WellKnownResolver) evict(max int) {
+	now := k.clock()
+	for apex, doc := range k.docs {
+		if !now.Before(doc.expires) {
+			delete(k.docs, apex)
+		}
+	}
+	for apex := range k.docs {
+		if len(k.docs) < max {
+			break
+		}
+		delete(k.docs, apex)
+	}
+}
+
+// Purge drops the cached document for host's apex.
+func (k *WellKnownResolver) Purge(host string) {
+	apex, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.docs, apex)
+}
+
+func (k *WellKnownResolver) fetch(ctx context.Context, apex string, now time.Time) wellKnownDoc {
+	ttl := k.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	url := "https://" + apex + WellKnownPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return wellKnownDoc{err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(wellKnownFetchHeader, "1")
+	client := k.Client
+	if client == nil {
+		client = defaultWellKnownClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Not cached, so the next request retries.
+		return wellKnownDoc{err: fmt.Errorf("fetching %s: %w", url, err)}
+	}
+	defer resp.Body.Close()
+	if maxAge, ok := parseMaxAge(resp.Header.Get("Cache-Control")); ok {
+		ttl = maxAge
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return wellKnownDoc{err: fmt.Errorf("%w at %s", ErrNotFound, url), expires: now.Add(ttl)}
+	case resp.StatusCode != http.StatusOK:
+		return wellKnownDoc{err: fmt.Errorf("fetching %s: %s", url, resp.Status)}
+	}
+
+	var hosts StaticResolver
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&hosts); err != nil {
+		return wellKnownDoc{err: fmt.Errorf("parsing %s: %w", url, err), expires: now.Add(ttl)}
+	}
+	return wellKnownDoc{hosts: hosts, expires: now.Add(ttl)}
+}
+
+func (k *WellKnownResolver) clock() time.Time {
+	if k.now != nil {
+		return k.now()
+	}
+	return time.Now()
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header.
+func parseMaxAge(header string) (time.Duration, bool) {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "no-store") || strings.EqualFold(name, "no-cache") {
+			return 0, true
+		}
+		if strings.EqualFold(name, "max-age") {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,176 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newWellKnownServer serves handler over TLS and returns a client that
+// sends every request to it, whatever the URL's host.
+func newWellKnownServer(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+	ts := httptest.NewTLSServer(handler)
+	t.Cleanup(ts.Close)
+	client := ts.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	return client
+}
+
+func TestWellKnownResolver(t *testing.T) {
+	fetches := 0
+	client := newWellKnownServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Host != "example.com" || r.URL.Path != WellKnownPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{"go.example.com": ["Redirects to https://example.com/go permanently"]}`))
+	})
+	now := time.Unix(0, 0)
+	resolver := &WellKnownResolver{Client: client}
+	resolver.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	rules, err := resolver.LookupConfig(ctx, "go.example.com")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	assertEqual(t, rules[0].To, "https://example.com/go")
+	assertEqual(t, rules[0].RedirectState, "permanently")
+
+	// Hosts sharing an apex are served from the cached document.
+	_, err = resolver.LookupConfig(ctx, "other.example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for unlisted host, got %v", err)
+	}
+	assertEqual(t, fetches, 1)
+
+	now = now.Add(time.Minute)
+	resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, fetches, 2)
+
+	resolver.Purge("go.example.com")
+	resolver.LookupConfig(ctx, "go.example.com")
+	assertEqual(t, fetches, 3)
+}
+
+func TestWellKnownResolverMissing(t *testing.T) {
+	client := newWellKnownServer(t, http.NotFound)
+	resolver := &WellKnownResolver{Client: client}
+
+	_, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound on 404, got %v", err)
+	}
+
+	_, err = resolver.LookupConfig(context.Background(), "localhost")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a host without an apex, got %v", err)
+	}
+}
+
+func TestWellKnownResolverServerError(t *testing.T) {
+	client := newWellKnownServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	resolver := &WellKnownResolver{Client: client}
+
+	_, err := resolver.LookupConfig(context.Background(), "go.example.com")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected fetch failure, got %v", err)
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	d, ok := parseMaxAge("public, max-age=120")
+	assertEqual(t, ok, true)
+	assertEqual(t, d, 2*time.Minute)
+
+	d, ok = parseMaxAge("no-store")
+	assertEqual(t, ok, true)
+	assertEqual(t, d, time.Duration(0))
+
+	_, ok = parseMaxAge("public")
+	assertEqual(t, ok, false)
+}
+
+func TestWellKnownResolverConcurrent(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	client := newWellKnownServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write([]byte(`{"go.example.com": ["Redirects to https://example.com/go"]}`))
+	})
+	resolver := &WellKnownResolver{Client: client}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Go(func() {
+			_, err := resolver.LookupConfig(context.Background(), "go.example.com")
+			errs <- err
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("a lookup during the fetch: %v", err)
+		}
+	}
+	assertEqual(t, int(fetches.Load()), 1)
+}
+
+func TestWellKnownResolverLoopsBack(t *testing.T) {
+	// The apex points at the handler using this resolver, so its fetch
+	// arrives there as a lookup of the apex.
+	var h http.Handler
+	client := newWellKnownServer(t, func(w http.ResponseWriter, r *http.Request) { h.ServeHTTP(w, r) })
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	client.Timeout = 2 * time.Second
+	resolver := &WellKnownResolver{Client: client}
+	h = NewHandler(WithResolver(resolver))
+
+	done := make(chan error)
+	go func() {
+		_, err := resolver.LookupConfig(context.Background(), "example.com")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("want an error for an apex without a document")
+		}
+	case <-time.After(time.Second):
+		t.Error("the fetch looping back waited for itself")
+		<-done
+	}
+}
+
+func TestWellKnownResolverMaxEntries(t *testing.T) {
+	client := newWellKnownServer(t, http.NotFound)
+	// The test server's certificate is for example.com only.
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+	resolver := &WellKnownResolver{Client: client, MaxEntries: 2}
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		resolver.LookupConfig(context.Background(), host)
+	}
+	if n := len(resolver.docs); n != 2 {
+		t.Errorf("want 2 cached documents, got %d", n)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// errorReports sends unexpected errors to error_dsn. It is nil when that is
+// unset; its methods do nothing then.
+var errorReports *reporter
+
+// reportRepeatAfter is how long an error is suppressed after being
+// reported, so a failure on every handshake is reported once a minute.
+const reportRepeatAfter = time.Minute
+
+// An errorEvent is one unexpected error.
+type errorEvent struct {
+	Kind    string // "panic" or "certificate"
+	Message string
+	Tags    map[string]string
+	Frames  []runtime.Frame // innermost first
+	Request *http.Request
+}
+
+// reporter sends errorEvents in the background, to Sentry if error_dsn
+// carries a Sentry key (https://key@o1.ingest.sentry.io/42) or otherwise as
+// JSON POSTed to it.
+type reporter struct {
+	endpoint string
+	auth     string // X-Sentry-Auth, for Sentry
+	dsn      string
+	client   *http.Client
+	release  string
+	server   string
+	events   chan errorEvent
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newReporter(cfg *config) (*reporter, error) {
+	if cfg.ErrorDSN == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.ErrorDSN)
+	if err != nil {
+		return nil, fmt.Errorf("error_dsn: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	r := &reporter{
+		endpoint: cfg.ErrorDSN,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		release:  readBuildInfo().Version,
+		server:   hostname,
+		events:   make(chan errorEvent, 64),
+		sent:     make(map[string]time.Time),
+	}
+	if key := u.User.Username(); key != "" {
+		prefix, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+		if project == "" {
+			return nil, fmt.Errorf("error_dsn %q has no Sentry project ID", cfg.ErrorDSN)
+		}
+		r.dsn = cfg.ErrorDSN
+		r.endpoint = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: prefix + "api/" + project + "/envelope/"}).String()
+		r.auth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s/%s, sentry_key=%s", logIdentifier, r.release, key)
+	}
+	go r.run()
+	return r, nil
+}
+
+// report queues ev unless the same error was reported recently or the
+// queue is full.
+func (r *reporter) report(ev errorEvent) {
+	if r == nil {
+		return
+	}
+	key := ev.Kind + "\x00" + ev.Message
+	r.mu.Lock()
+	if time.Since(r.sent[key]) < reportRepeatAfter {
+		r.mu.Unlock()
+		return
+	}
+	if len(r.sent) > 1000 {
+		clear(r.sent)
+	}
+	r.sent[key] = time.Now()
+	r.mu.Unlock()
+	select {
+	case r.events <- ev:
+	default:
+	}
+}
+
+func (r *reporter) run() {
+	for ev := range r.events {
+		if err := r.send(ev); err != nil {
+			log.Printf("Reporting %s error: %v", ev.Kind, err)
+		}
+	}
+}
+
+func (r *reporter) send(ev errorEvent) error {
+	var body []byte
+	var err error
+	if r.auth != "" {
+		body, err = r.sentryEnvelope(ev)
+	} else {
+		body, err = json.Marshal(r.webhookPayload(ev))
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if r.auth != "" {
+		req.Header.Set("Content-Type", "application/x-sentry-envelope")
+		req.Header.Set("X-Sentry-Auth", r.auth)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", r.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// webhookPayload is what a generic error_dsn receives.
+type webhookPayload struct {
+	Kind      string            `json:"kind"`
+	Message   string            `json:"message"`
+	Time      time.Time         `json:"time"`
+	Release   string            `json:"release"`
+	Server    string            `json:"server"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	Stack     []string          `json:"stack,omitempty"`
+}
+
+func (r *reporter) webhookPayload(ev errorEvent) webhookPayload {
+	p := webhookPayload{Kind: ev.Kind, Message: ev.Message, Time: time.Now().UTC(), Release: r.release, Server: r.server, Tags: ev.Tags}
+	if req := ev.Request; req != nil {
+		p.Method, p.URL, p.RequestID = req.Method, requestURL(req), redirect.RequestIDFrom(req.Context())
+	}
+	for _, f := range ev.Frames {
+		p.Stack = append(p.Stack, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+	}
+	return p
+}
+
+// sentryEnvelope encodes ev as a Sentry envelope holding one event.
+func (r *reporter) sentryEnvelope(ev errorEvent) ([]byte, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	type frame struct {
+		Function string `json:"function"`
+		AbsPath  string `json:"abs_path"`
+		Lineno   int    `json:"lineno"`
+		InApp    bool   `json:"in_app"`
+	}
+	var frames []frame
+	for i := len(ev.Frames) - 1; i >= 0; i-- { // Sentry wants the innermost last
+		f := ev.Frames[i]
+		frames = append(frames, frame{f.Function, f.File, f.Line, strings.HasPrefix(f.Function, "github.com/frolic/redirect.name")})
+	}
+	event := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "error",
+		"logger":      ev.Kind,
+		"release":     r.release,
+		"server_name": r.server,
+		"exception": map[string]any{"values": []map[string]any{{
+			"type":       ev.Kind,
+			"value":      ev.Message,
+			"stacktrace": map[string]any{"frames": frames},
+		}}},
+	}
+	tags := map[string]string{}
+	for k, v := range ev.Tags {
+		tags[k] = v
+	}
+	if req := ev.Request; req != nil {
+		event["request"] = map[string]any{"method": req.Method, "url": requestURL(req)}
+		if id := redirect.RequestIDFrom(req.Context()); id != "" {
+			tags["request_id"] = id
+		}
+	}
+	if len(tags) > 0 {
+		event["tags"] = tags
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": event["event_id"].(string), "dsn": r.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	return bytes.Join([][]byte{header, item, payload}, []byte("\n")), nil
+}
+
+func requestURL(r *http.Request) string {
+	return redirect.RequestScheme(r) + "://" + r.Host + r.URL.RequestURI()
+}
+
+// recoverPanics answers 500 to requests whose handler panics, logging and
+// reporting the panic instead of dropping the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			pcs := make([]uintptr, 64)
+			frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+			var stack []runtime.Frame
+			for {
+				f, more := frames.Next()
+				stack = append(stack, f)
+				if !more {
+					break
+				}
+			}
+			log.Printf("panic serving %s%s: %v", r.Host, r.URL.RequestURI(), v)
+			errorReports.report(errorEvent{Kind: "panic", Message: fmt.Sprint(v), Frames: stack, Request: r})
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refusedHostError marks a host policy's refusal, which isn't worth
+// reporting: clients may ask for any name.
+type refusedHostError struct {
+	error
+}
+
+func (e refusedHostError) Unwrap() error { return e.error }
+
+// markRefusals wraps policy's errors in refusedHostError.
+func markRefusals(policy autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := policy(ctx, host); err != nil {
+			return refusedHostError{err}
+		}
+		return nil
+	}
+}
+
+// reportCertErrors reports certificate lookups and issuance that fail for
+// reasons other than the client asking for a name that isn't served.
+func reportCertErrors(config *tls.Config) *tls.Config {
+	get := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if isCertFailure(err) {
+			recentCertErrors.record(hello.ServerName, err)
+			errorReports.report(errorEvent{
+				Kind:    "certificate",
+				Message: err.Error(),
+				Tags:    map[string]string{"server_name": hello.ServerName},
+			})
+		}
+		return cert, err
+	}
+	return config
+}
+
+// isCertFailure reports whether err is a certificate lookup or issuance
+// failing, rather than nil or the client asking for a name not served.
+func isCertFailure(err error) bool {
+	var refused refusedHostError
+	return err != nil && !errors.As(err, &refused) && !isBadServerName(err)
+}
+
+// isBadServerName reports whether err is autocert refusing a handshake's
+// missing or malformed server name before doing any work.
+func isBadServerName(err error) bool {
+	msg := err.Error()
+	for _, benign := range []string{"missing server name", "server name component count invalid", "server name contains invalid character", "no token cert"} {
+		if strings.Contains(msg, benign) {
+			return true
+		}
+	}
+	return false
+}
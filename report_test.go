@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestReporter points errorReports at a server that passes each report's
+// path, headers and body to the returned channel.
+func newTestReporter(t *testing.T, dsn func(url string) string) chan *http.Request {
+	t.Helper()
+	got := make(chan *http.Request, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		got <- r
+	}))
+	t.Cleanup(ts.Close)
+	orig := errorReports
+	t.Cleanup(func() { errorReports = orig })
+	cfg := defaultConfig()
+	cfg.ErrorDSN = dsn(ts.URL)
+	var err error
+	if errorReports, err = newReporter(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func nextReport(t *testing.T, reports chan *http.Request) *http.Request {
+	t.Helper()
+	select {
+	case r := <-reports:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report sent")
+		return nil
+	}
+}
+
+func TestRecoverPanicsWebhook(t *testing.T) {
+	reports := newTestReporter(t, func(url string) string { return url + "/hook" })
+	h := requestIDs("X-Request-ID", recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest("GET", "http://go.example.com/docs", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("want 500, got %d", rr.Code)
+	}
+
+	r := nextReport(t, reports)
+	if r.URL.Path != "/hook" {
+		t.Errorf("reported to %s", r.URL.Path)
+	}
+	var p webhookPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Kind != "panic" || p.Message != "boom" || p.URL != "http://go.example.com/docs" || p.RequestID != "req-1" {
+		t.Errorf("got %+v", p)
+	}
+	if len(p.Stack) == 0 || !strings.Contains(strings.Join(p.Stack, "\n"), "TestRecoverPanicsWebhook") {
+		t.Errorf("stack lacks the panicking handler: %v", p.Stack)
+	}
+}
+
+func TestReportSentry(t *testing.T) {
+	reports := newTestReporter(t, func(url string) string { return strings.Replace(url, "://", "://pubkey@", 1) + "/42" })
+	errorReports.report(errorEvent{Kind: "certificate", Message: "acme: rate limited", Tags: map[string]string{"server_name": "go.example.com"}})
+
+	r := nextReport(t, reports)
+	if r.URL.Path != "/api/42/envelope/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=pubkey") {
+		t.Errorf("sent to %s with auth %q", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+	}
+	body, _ := io.ReadAll(r.Body)
+	lines := strings.Split(string(body), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want header, item header and event, got %q", body)
+	}
+	var event struct {
+		Exception struct {
+			Values []struct{ Type, Value string }
+		}
+		Tags map[string]string
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if v := event.Exception.Values; len(v) != 1 || v[0].Type != "certificate" || v[0].Value != "acme: rate limited" || event.Tags["server_name"] != "go.example.com" {
+		t.Errorf("got %s", lines[2])
+	}
+}
+
+func TestReportCertErrors(t *testing.T) {
+	reports := newTestReporter(t, func(url string) string { return url })
+	policy := markRefusals(func(ctx context.Context, host string) error {
+		if host != "go.example.com" {
+			return errors.New(host + " is not served by this server")
+		}
+		return nil
+	})
+	config := reportCertErrors(&tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "" {
+			return nil, errors.New("acme/autocert: missing server name")
+		}
+		if err := policy(context.Background(), hello.ServerName); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("acme: urn:ietf:params:acme:error:rateLimited")
+	}})
+
+	for _, name := range []string{"", "other.example.com", "go.example.com", "go.example.com"} {
+		config.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	}
+	var p webhookPayload
+	json.NewDecoder(nextReport(t, reports).Body).Decode(&p)
+	if p.Kind != "certificate" || p.Tags["server_name"] != "go.example.com" {
+		t.Errorf("got %+v", p)
+	}
+	select {
+	case r := <-reports:
+		body, _ := io.ReadAll(r.Body)
+		t.Errorf("want one report, got another: %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
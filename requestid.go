@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// maxRequestIDLen bounds request IDs accepted from clients and proxies.
+const maxRequestIDLen = 128
+
+// requestIDs gives each request an ID, taken from header if the request
+// carries a plausible one (so a proxy's ID carries through) or generated,
+// and echoes it in the response's header. Handlers find it with
+// redirect.RequestIDFrom.
+func requestIDs(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, r.WithContext(redirect.WithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id is short and uses only characters that
+// are safe to log and show on a page unescaped.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=') {
+			return false
+		}
+	}
+	return true
+}
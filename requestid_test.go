@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestRequestIDs(t *testing.T) {
+	var seen string
+	h := requestIDs("X-Request-ID", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = redirect.RequestIDFrom(r.Context())
+	}))
+	for incoming, keep := range map[string]bool{
+		"":                          false,
+		"req-42":                    true,
+		"Root=1-67891233-abcdef012": true,
+		"<script>":                  false,
+		strings.Repeat("a", 129):    false,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if incoming != "" {
+			req.Header.Set("X-Request-ID", incoming)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		got := rr.Header().Get("X-Request-ID")
+		if got != seen || got == "" {
+			t.Errorf("%q: response has %q, handler saw %q", incoming, got, seen)
+		}
+		if keep != (got == incoming) {
+			t.Errorf("%q: got ID %q", incoming, got)
+		}
+	}
+}
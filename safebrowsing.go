@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// safeBrowsingURL is the Safe Browsing Lookup API (v4) endpoint.
+const safeBrowsingURL = "https://safebrowsing.googleapis.com/v4/threatMatches:find"
+
+// maxVerdicts bounds the Safe Browsing verdict cache.
+const maxVerdicts = 100000
+
+// safeBrowsing is a redirect.TargetChecker that looks destinations up with
+// Google Safe Browsing. Lookups run in the background: a request waits at
+// most wait for a verdict and is let through if none has arrived, so an
+// API outage or slow answer never holds up redirects.
+type safeBrowsing struct {
+	key      string
+	endpoint string
+	client   *http.Client
+	warn     bool
+	wait     time.Duration
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	verdicts map[string]verdict
+	inflight map[string]chan struct{}
+	now      func() time.Time
+}
+
+// verdict is a cached lookup result. threat is empty for safe URLs.
+type verdict struct {
+	threat  string
+	expires time.Time
+}
+
+func newSafeBrowsing(cfg *config) *safeBrowsing {
+	if cfg.SafeBrowsingAPIKey == "" {
+		return nil
+	}
+	return &safeBrowsing{
+		key:      cfg.SafeBrowsingAPIKey,
+		endpoint: safeBrowsingURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		warn:     cfg.SafeBrowsingAction == "warn",
+		wait:     cfg.SafeBrowsingWait,
+		ttl:      cfg.SafeBrowsingCacheTTL,
+		verdicts: make(map[string]verdict),
+		inflight: make(map[string]chan struct{}),
+	}
+}
+
+// CheckTarget refuses, or warns about, destinations Safe Browsing has
+// flagged.
+func (s *safeBrowsing) CheckTarget(ctx context.Context, location string) error {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	u.Fragment = ""
+	key := u.String()
+
+	s.mu.Lock()
+	v, ok := s.verdicts[key]
+	if ok && s.clock().Before(v.expires) {
+		s.mu.Unlock()
+		return s.refuse(v.threat)
+	}
+	done, ok := s.inflight[key]
+	if !ok {
+		done = make(chan struct{})
+		s.inflight[key] = done
+		go s.lookup(key, done)
+	}
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+	s.mu.Lock()
+	v = s.verdicts[key]
+	s.mu.Unlock()
+	return s.refuse(v.threat)
+}
+
+func (s *safeBrowsing) refuse(threat string) error {
+	if threat == "" {
+		return nil
+	}
+	reason := "Google Safe Browsing reports it as " + strings.ToLower(strings.ReplaceAll(threat, "_", " "))
+	if s.warn {
+		return &redirect.BlockedError{Status: http.StatusOK, Reason: reason, Proceed: true}
+	}
+	return &redirect.BlockedError{Status: http.StatusForbidden, Reason: reason}
+}
+
+// lookup asks the API about target and caches the verdict. Failures are
+// logged and not cached.
+func (s *safeBrowsing) lookup(target string, done chan struct{}) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, target)
+		s.mu.Unlock()
+		close(done)
+	}()
+	threat, ttl, err := s.find(target)
+	if err != nil {
+		log.Printf("Safe Browsing lookup for %s: %v", target, err)
+		return
+	}
+	s.mu.Lock()
+	if len(s.verdicts) >= maxVerdicts {
+		clear(s.verdicts)
+	}
+	s.verdicts[target] = verdict{threat: threat, expires: s.clock().Add(ttl)}
+	s.mu.Unlock()
+}
+
+type threatMatches struct {
+	Matches []struct {
+		ThreatType    string `json:"threatType"`
+		CacheDuration string `json:"cacheDuration"`
+	} `json:"matches"`
+}
+
+// find returns the first threat type reported for target, and how long the
+// answer may be cached.
+func (s *safeBrowsing) find(target string) (string, time.Duration, error) {
+	body, _ := json.Marshal(map[string]any{
+		"client": map[string]string{"clientId": "redirect.name", "clientVersion": "1.0"},
+		"threatInfo": map[string]any{
+			"threatTypes":      []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"},
+			"platformTypes":    []string{"ANY_PLATFORM"},
+			"threatEntryTypes": []string{"URL"},
+			"threatEntries":    []map[string]string{{"url": target}},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint+"?key="+url.QueryEscape(s.key), bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("%s", resp.Status)
+	}
+	var result threatMatches
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, err
+	}
+	if len(result.Matches) == 0 {
+		return "", s.ttl, nil
+	}
+	ttl := s.ttl
+	if d, err := time.ParseDuration(result.Matches[0].CacheDuration); err == nil && d > ttl {
+		ttl = d
+	}
+	return result.Matches[0].ThreatType, ttl, nil
+}
+
+func (s *safeBrowsing) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func newSafeBrowsingAPI(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Query().Get("key") != "test-key" {
+			http.Error(w, "bad key", http.StatusForbidden)
+			return
+		}
+		time.Sleep(delay)
+		var req struct {
+			ThreatInfo struct {
+				ThreatEntries []struct{ URL string } `json:"threatEntries"`
+			} `json:"threatInfo"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.Contains(req.ThreatInfo.ThreatEntries[0].URL, "phish") {
+			w.Write([]byte(`{"matches":[{"threatType":"SOCIAL_ENGINEERING","cacheDuration":"300s"}]}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestSafeBrowsing(t *testing.T) {
+	ts, calls := newSafeBrowsingAPI(t, 0)
+	cfg := defaultConfig()
+	cfg.SafeBrowsingAPIKey = "test-key"
+	cfg.SafeBrowsingWait = 5 * time.Second
+	sb := newSafeBrowsing(cfg)
+	sb.endpoint = ts.URL
+	ctx := context.Background()
+
+	var be *redirect.BlockedError
+	err := sb.CheckTarget(ctx, "https://phish.example/login#frag")
+	if !errors.As(err, &be) || !be.Proceed || be.Status != http.StatusOK {
+		t.Fatalf("flagged target: want a warning, got %v", err)
+	}
+	if err := sb.CheckTarget(ctx, "https://phish.example/login"); err == nil {
+		t.Error("cached verdict not applied")
+	}
+	if err := sb.CheckTarget(ctx, "https://example.com/"); err != nil {
+		t.Errorf("safe target: got %v", err)
+	}
+	sb.CheckTarget(ctx, "https://example.com/")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("want verdicts cached, got %d API calls", n)
+	}
+
+	sb.warn = false
+	if err := sb.CheckTarget(ctx, "https://phish.example/login"); !errors.As(err, &be) || be.Proceed || be.Status != http.StatusForbidden {
+		t.Errorf("block mode: want 403, got %v", err)
+	}
+}
+
+func TestSafeBrowsingSlowAPI(t *testing.T) {
+	ts, _ := newSafeBrowsingAPI(t, 200*time.Millisecond)
+	cfg := defaultConfig()
+	cfg.SafeBrowsingAPIKey = "test-key"
+	cfg.SafeBrowsingWait = 10 * time.Millisecond
+	sb := newSafeBrowsing(cfg)
+	sb.endpoint = ts.URL
+
+	// The first request isn't held up; once the verdict lands, it applies.
+	if err := sb.CheckTarget(context.Background(), "https://phish.example/"); err != nil {
+		t.Errorf("slow verdict: want the redirect let through, got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sb.CheckTarget(context.Background(), "https://phish.example/") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("verdict never arrived")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	sb.key = "wrong"
+	if err := sb.CheckTarget(context.Background(), "https://other.example/"); err != nil {
+		t.Errorf("API errors should fail open, got %v", err)
+	}
+}
@@ -2,226 +2,793 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"net/http"
-	"net/url"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/frolic/redirect.name/internal/certcache"
+	"github.com/frolic/redirect.name/internal/metrics"
+	"github.com/frolic/redirect.name/internal/proxyproto"
+	"github.com/frolic/redirect.name/internal/ratelimit"
+	"github.com/frolic/redirect.name/redirect"
+	"github.com/frolic/redirect.name/serverless"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/net/publicsuffix"
 )
 
-var lookupTXT = net.LookupTXT
-
-func fallback(w http.ResponseWriter, r *http.Request, reason string) {
-	location := os.Getenv("FALLBACK_URL")
-	if location == "" {
-		location = "http://redirect.name/"
-	}
-	if reason != "" {
-		location = fmt.Sprintf("%s#reason=%s", location, url.QueryEscape(reason))
-	}
-	http.Redirect(w, r, location, 302)
-}
-
-func getRedirect(txt []string, url string) (*Redirect, error) {
-	var catchAlls []*Config
-	for _, record := range txt {
-		config := Parse(record)
-		if config == nil {
-			continue
-		}
-		if config.From == "" {
-			catchAlls = append(catchAlls, config)
-			continue
-		}
-		redirect := Translate(url, config)
-		if redirect != nil {
-			return redirect, nil
-		}
-	}
-
-	for _, config := range catchAlls {
-		redirect := Translate(url, config)
-		if redirect != nil {
-			return redirect, nil
-		}
-	}
-
-	return nil, errors.New("No paths matched")
-}
+var resolver redirect.Resolver = redirect.DNSResolver{}
 
 func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "ok")
 }
 
-func redirectHandler(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.Host, ":")
-	host := parts[0]
-
-	hostname := fmt.Sprintf("_redirect.%s", host)
-	txt, err := lookupTXT(hostname)
-	if err != nil {
-		fallback(w, r, fmt.Sprintf("Could not resolve hostname (%v)", err))
-		return
+// newMux returns the public handler: the health checks and the redirect
+// handler (with checks vetting its targets) for every other path, or the
+// homepage for the canonical host, all behind the host quota (if not nil),
+// the client rate limit, the served-host filter, request timing metrics
+// and per-host counts, access logging, tracing, panic recovery, request
+// IDs and any trusted proxies.
+func newMux(cfg *config, quota *hostQuota, checks ...redirect.TargetChecker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	readyz := readyzHandler(cfg)
+	mux.Handle("/readyz", readyz)
+	opts := []redirect.Option{
+		redirect.WithResolver(resolver),
+		redirect.WithFallbackURL(cfg.FallbackURL),
+	}
+	if cfg.SourceHeader {
+		opts = append(opts, redirect.WithSourceHeader())
+	}
+	if cfg.ServerTiming {
+		opts = append(opts, redirect.WithServerTiming())
+	}
+	pages, err := redirect.LoadPages(cfg.TemplatesDir)
+	if err == nil {
+		opts = append(opts, redirect.WithPages(pages))
+	}
+	if cfg.FallbackPage {
+		opts = append(opts, redirect.WithFallbackPage())
+	}
+	for _, c := range checks {
+		opts = append(opts, redirect.WithTargetCheck(c))
+	}
+	if tracer != nil {
+		opts = append(opts, redirect.WithTracer(spanTracer{tracer}))
 	}
+	opts = append(opts, redirect.WithBotClassifier(isBot))
+	opts = append(opts, redirect.WithIgnoredPaths(cfg.IgnoredStatus, cfg.ignoredPaths()...))
+	opts = append(opts, redirect.WithRuleLimits(redirect.RuleLimits{Rules: cfg.MaxHostRules, PatternLength: cfg.MaxPatternLength}))
+	if origins, _ := redirect.ParseOrigins(cfg.CORSOrigins); len(origins) > 0 {
+		opts = append(opts, redirect.WithCORS(origins...))
+	}
+	switch cfg.LinkPreviews {
+	case "txt":
+		opts = append(opts, redirect.WithLinkPreviews(nil))
+	case "fetch":
+		opts = append(opts, redirect.WithLinkPreviews(newPreviewFetcher(cfg)))
+	}
+	opts = append(opts, redirect.WithDocumentClient(newPublicClient(5*time.Second)))
+	if cfg.QRCodes {
+		opts = append(opts, redirect.WithQRCodes())
+	}
+	if cfg.ForceHTTPS {
+		opts = append(opts, redirect.WithForceHTTPS())
+	}
+	if cfg.HSTSMaxAge > 0 {
+		opts = append(opts, redirect.WithHSTS(cfg.HSTSMaxAge))
+	}
+	if cfg.WebFinger == "proxy" {
+		opts = append(opts, redirect.WithWebFingerProxy(newPublicClient(5*time.Second)))
+	}
+	if m := shadowMatchers[cfg.ShadowMatcher]; m != nil {
+		opts = append(opts, redirect.WithShadowMatcher(m, newShadowLog(cfg.ShadowMatcher).report))
+	}
+	var redirects http.Handler = redirect.NewHandler(opts...)
+	if clicks != nil {
+		redirects = serveStats(clicks, redirects)
+	}
+	if abuseReports != nil {
+		redirects = abuseReports.serve(pages, redirects)
+	}
+	if maintenance != nil {
+		redirects = maintenance.serve(pages, redirects)
+	}
+	if cfg.HandlerTimeout > 0 {
+		redirects = http.TimeoutHandler(redirects, cfg.HandlerTimeout, "Request timed out")
+	}
+	mux.Handle("/", redirects)
 
-	redirect, err := getRedirect(txt, r.URL.String())
-	if err != nil {
-		fallback(w, r, err.Error())
-	} else {
-		if redirect.Status == http.StatusMovedPermanently || redirect.Status == http.StatusPermanentRedirect {
-			w.Header().Set("Cache-Control", "max-age=86400")
-		}
-		http.Redirect(w, r, redirect.Location, redirect.Status)
+	var h http.Handler = mux
+	if canonical := cfg.canonicalHost(); canonical != "" {
+		h = serveHome(canonical, newHostFilter(cfg), checks, readyz, h)
 	}
+	if quota != nil {
+		h = limitHosts(quota, h)
+	}
+	if cfg.RateLimit > 0 {
+		h = limitClients(ratelimit.New(cfg.RateLimit, cfg.RateLimitBurst), h)
+	}
+	if f := newHostFilter(cfg); f != nil {
+		h = filterHosts(f, h)
+	}
+	h = measureRequests(h)
+	if topHosts != nil {
+		h = countRedirects(topHosts, cfg.AnalyticsBots == "include", h)
+	}
+	if clicks != nil {
+		h = recordClicks(clicks, cfg.AnalyticsBots == "include", h)
+	}
+	if len(redirectEvents) > 0 {
+		h = forwardEvents(redirectEvents, newEventClient(cfg), cfg.AnalyticsBots == "include", h)
+	}
+	if accessLog != nil {
+		h = logRequests(accessLog, newIPAnonymizer(cfg), h)
+	}
+	if tracer != nil {
+		h = traceRequests(tracer, newIPAnonymizer(cfg), h)
+	}
+	if cfg.MaxInflight > 0 {
+		h = shedLoad(cfg.MaxInflight, h)
+	}
+	h = recoverPanics(h)
+	if cfg.RequestIDHeader != "" {
+		h = requestIDs(cfg.RequestIDHeader, h)
+	}
+	if proxies := cfg.trustedProxies(); len(proxies) > 0 {
+		h = redirect.TrustProxies(proxies, h)
+	}
+	return h
 }
 
 // hostPolicy validates that a host has a _redirect TXT record before
 // autocert will issue a certificate for it.
 func hostPolicy(ctx context.Context, host string) error {
-	hostname := fmt.Sprintf("_redirect.%s", host)
-	txt, err := lookupTXT(hostname)
+	rules, err := resolver.LookupConfig(ctx, host)
 	if err != nil {
-		return fmt.Errorf("DNS lookup failed for %s: %w", hostname, err)
+		return fmt.Errorf("DNS lookup failed for %s: %w", redirect.RecordName(host), err)
 	}
-	for _, record := range txt {
-		if Parse(record) != nil {
-			return nil
-		}
+	// Flag records alone redirect nowhere, so don't earn a certificate.
+	if slices.ContainsFunc(rules, func(rule *redirect.Rule) bool { return rule.Flag == "" }) {
+		return nil
 	}
-	return fmt.Errorf("no valid redirect config in TXT records for %s", hostname)
+	return fmt.Errorf("no valid redirect config in TXT records for %s", redirect.RecordName(host))
 }
 
-// rateLimitedCache wraps autocert.DirCache and enforces a limit of 2 new
-// certificates per apex domain per week to stay well within Let's Encrypt
-// rate limits. The counter resets on restart and on weekly rollover.
+// certCache returns where certificates are kept: cert_cache, or files in
+// cert_dir.
+func (c *config) certCache() autocert.Cache {
+	if c.CertCache == "" {
+		return autocert.DirCache(c.CertDir)
+	}
+	cache, err := certcache.New(c.CertCache)
+	if err != nil {
+		log.Fatalf("cert_cache: %v", err)
+	}
+	return cache
+}
+
+// certsPerApex certificates may be issued per apex domain in any
+// certWindow, to stay well within Let's Encrypt's rolling weekly limits.
+const (
+	certsPerApex = 2
+	certWindow   = 7 * 24 * time.Hour
+)
+
+// rateLimitedCache wraps a certificate cache and refuses to store more than
+// certsPerApex new certificates per apex domain in any certWindow. When in
+// doubt, it refuses. The times of recent issuances are kept in the cache
+// itself, under ratelimit+<apex>, so that the limit survives restarts and,
+// when cert_cache is shared, covers every replica.
 type rateLimitedCache struct {
 	autocert.Cache
-	mu     sync.Mutex
-	counts map[string]int
-	weekOf time.Time
+	mu  sync.Mutex
+	now func() time.Time
 }
 
-func newRateLimitedCache(dir string) *rateLimitedCache {
-	return &rateLimitedCache{
-		Cache:  autocert.DirCache(dir),
-		counts: make(map[string]int),
-		weekOf: time.Now().Truncate(7 * 24 * time.Hour),
-	}
+func newRateLimitedCache(cache autocert.Cache) *rateLimitedCache {
+	return &rateLimitedCache{Cache: cache, now: time.Now}
 }
 
 func (c *rateLimitedCache) Put(ctx context.Context, key string, data []byte) error {
-	apex, err := publicsuffix.EffectiveTLDPlusOne(key)
+	// DNS-01 certificates, under dns01+<domain>, count as autocert's do.
+	name := strings.TrimSuffix(strings.TrimPrefix(key, "dns01+"), "+rsa")
+	if strings.Contains(name, "+") {
+		// A challenge token or an account key.
+		return c.Cache.Put(ctx, key, data)
+	}
+	apex, err := publicsuffix.EffectiveTLDPlusOne(name)
 	if err != nil {
-		// Not a domain key (e.g., acme_account+key) — skip rate limiting.
 		return c.Cache.Put(ctx, key, data)
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
-
-	week := time.Now().Truncate(7 * 24 * time.Hour)
-	if !week.Equal(c.weekOf) {
-		c.counts = make(map[string]int)
-		c.weekOf = week
+	if locker, ok := c.Cache.(certcache.Locker); ok {
+		unlock, err := certcache.Lock(ctx, locker, "lock+ratelimit+"+apex, time.Minute)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 	}
 
-	if c.counts[apex] >= 2 {
-		return fmt.Errorf("rate limit exceeded: 2 certs already issued for %s this week", apex)
+	now := c.now()
+	issued, err := c.issued(ctx, apex, now)
+	if err != nil {
+		return err
+	}
+	if err := overLimit(apex, issued); err != nil {
+		return err
 	}
-
 	if err := c.Cache.Put(ctx, key, data); err != nil {
 		return err
 	}
-	c.counts[apex]++
+	var record strings.Builder
+	for _, t := range append(issued, now) {
+		record.WriteString(t.UTC().Format(time.RFC3339) + "\n")
+	}
+	return c.Cache.Put(ctx, "ratelimit+"+apex, []byte(record.String()))
+}
+
+// check returns an error if apex has had its certsPerApex certificates in
+// the certWindow, so one is refused before being ordered rather than
+// after.
+func (c *rateLimitedCache) check(ctx context.Context, apex string) error {
+	issued, err := c.issued(ctx, apex, c.now())
+	if err != nil {
+		return err
+	}
+	return overLimit(apex, issued)
+}
+
+// overLimit returns an error if issued holds certsPerApex certificates.
+func overLimit(apex string, issued []time.Time) error {
+	if len(issued) >= certsPerApex {
+		return fmt.Errorf("rate limit exceeded: %d certs already issued for %s since %s", len(issued), apex, issued[0].Format(time.RFC3339))
+	}
 	return nil
 }
 
+// issued returns when certificates were issued for apex in the certWindow
+// before now, oldest first.
+func (c *rateLimitedCache) issued(ctx context.Context, apex string, now time.Time) ([]time.Time, error) {
+	data, err := c.Cache.Get(ctx, "ratelimit+"+apex)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var issued []time.Time
+	for _, line := range strings.Fields(string(data)) {
+		t, err := time.Parse(time.RFC3339, line)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit+%s: %v", apex, err)
+		}
+		if now.Sub(t) < certWindow {
+			issued = append(issued, t)
+		}
+	}
+	return issued, nil
+}
+
+// watchFile reloads file on SIGHUP and whenever it changes on disk.
+func watchFile(file *redirect.FileResolver) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := file.Reload(); err != nil {
+				log.Printf("Reloading redirects file: %v", err)
+				continue
+			}
+			log.Println("Reloaded redirects file")
+		}
+	}()
+	go file.Watch(context.Background(), 10*time.Second, func(err error) {
+		log.Printf("Reloading redirects file: %v", err)
+	})
+}
+
+// subcommands are the commands `redirect-name <name>` runs instead of the
+// server.
+var subcommands = map[string]func(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error{
+	"setup":  runSetup,
+	"export": runExport,
+	"import": runImport,
+	"bench":  runBench,
+}
+
 func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", healthzHandler)
-	mux.HandleFunc("/", redirectHandler)
-
-	certDir := os.Getenv("CERT_DIR")
-	if certDir == "" {
-		port := os.Getenv("PORT")
-		if port == "" {
-			port = "8081"
-		}
-		srv := &http.Server{
-			Addr:         ":" + port,
-			Handler:      mux,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 5 * time.Second,
-		}
-		log.Printf("Listening on http://0.0.0.0:%s", port)
-		stop := make(chan os.Signal, 1)
-		signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
-		go func() {
-			<-stop
-			log.Println("Shutting down...")
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			srv.Shutdown(ctx)
-		}()
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal(err)
+	if len(os.Args) > 1 {
+		if run := subcommands[os.Args[1]]; run != nil {
+			if err := run(context.Background(), os.Args[2:], os.Getenv, os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
 		}
+	}
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if cfg.validateOnly {
+		if validateDeploy(context.Background(), cfg, os.Stdout) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	// Until warmup is done, /readyz answers 503.
+	setPhase(phaseStarting)
+	var warmup sync.WaitGroup
+	if err := setErrorLog(cfg); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("redirect.name %s", readBuildInfo())
+	if accessLog, err = newAccessLog(cfg); err != nil {
+		log.Fatal(err)
+	}
+	redirect.AllowedSchemes = cfg.allowedSchemes()
+	tracer = newTracer(cfg)
+	topHosts = newHostTraffic(cfg)
+	if cfg.BotNetworksFile != "" {
+		if botNets, err = loadBotNetworks(cfg.BotNetworksFile); err != nil {
+			log.Fatalf("bot_networks_file: %v", err)
+		}
+	}
+	if clicks, err = newClickStore(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if clicks != nil {
+		go runClickStore(context.Background(), clicks)
+	}
+	if redirectEvents, err = newEventForwarders(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if errorReports, err = newReporter(cfg); err != nil {
+		log.Fatal(err)
+	}
 
-	manager := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      newRateLimitedCache(certDir),
-		HostPolicy: hostPolicy,
+	srcs, err := newSources(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if srcs.cache != nil && cfg.CacheFile != "" {
+		if err := loadCache(cfg.CacheFile, srcs.cache); err != nil {
+			log.Printf("Loading cache_file: %v", err)
+		}
+	}
+	if srcs.file != nil {
+		watchFile(srcs.file)
+	}
+	if srcs.shared != nil {
+		go srcs.shared.watchPurges(context.Background(), srcs.purgeLocal)
+	}
+	resolver, configSources = srcs.layers, srcs
+	registerSourceMetrics(registry, srcs)
+	statsd, err := newStatsd(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if statsd != nil {
+		go statsd.Run(context.Background(), cfg.StatsdInterval)
+	}
+
+	up, err := newUpgrader(cfg.ReusePort)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	httpSrv := &http.Server{
-		Addr:         ":80",
-		Handler:      manager.HTTPHandler(mux),
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+	quota := newHostQuota(cfg)
+	if quota != nil {
+		registry.GaugeFunc("redirect_suspended_hosts", "Hosts suspended for exceeding their quota.", nil,
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(len(quota.suspensions()))}}
+			})
 	}
-	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+	if cfg.AdminAuthFile != "" {
+		if adminCredentials, err = loadAdminCredentials(cfg.AdminAuthFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	maintenance = newMaintenanceMode(cfg)
+	registry.GaugeFunc("redirect_maintenance", "1 while redirects get the maintenance page.", nil,
+		func() []metrics.Sample {
+			if maintenance.on.Load() != nil {
+				return []metrics.Sample{{Value: 1}}
+			}
+			return []metrics.Sample{{Value: 0}}
+		})
+
+	var checks []redirect.TargetChecker
+	if cfg.LoopHops > 0 {
+		checks = append(checks, countBlocks("loop", &redirect.LoopDetector{Resolver: resolver, MaxHops: cfg.LoopHops}))
+	}
+	blocklist, err := newBlocklist(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if blocklist != nil {
+		go blocklist.run(context.Background(), cfg.BlocklistRefresh)
+		registry.GaugeFunc("redirect_blocklist_entries", "Entries in the target blocklist.", nil,
+			func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(blocklist.list.Len())}}
+			})
+		checks = append(checks, countBlocks("blocklist", blocklist.list))
+	}
+	if sb := newSafeBrowsing(cfg); sb != nil {
+		checks = append(checks, countBlocks("safe_browsing", sb))
+	}
+	if abuseReports, err = newAbuseReportStore(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if abuseReports != nil {
+		checks = append(checks, countBlocks("abuse_reports", abuseReports))
+	}
+
+	var servers []server
+	if addr := cfg.DebugAddr; addr != "" {
+		auth := &adminAuth{token: cfg.AdminToken, credentials: adminCredentials}
+		servers = append(servers, server{name: "debug", addr: addr, srv: &http.Server{Handler: auth.require(scopeDebug, newDebugMux())}})
+	}
+
+	mux := newMux(cfg, quota, checks...)
+	if cfg.Serverless == "lambda" {
+		setPhase(phaseServing)
+		log.Print("Serving AWS Lambda invocations")
+		log.Fatal(serverless.StartLambda(context.Background(), os.Getenv("AWS_LAMBDA_RUNTIME_API"), mux))
+	}
+	tlsSettings, err := newTLSPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go tlsSettings.run(context.Background())
+	switch {
+	case cfg.DevTLS:
+		dev, err := newDevCerts(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving HTTPS with %s certificates for local development", dev)
+		servers = append(servers, httpsServers(cfg, mux, mux, func() *tls.Config {
+			return tlsSettings.apply(dev.tlsConfig())
+		})...)
+	case cfg.BehindProxy:
+		log.Printf("Serving plain HTTP on :%d for the TLS-terminating proxy in front", cfg.Port)
+		fallthrough
+	case cfg.CertDir == "":
+		servers = append(servers, server{name: "http", addr: ":" + strconv.Itoa(cfg.Port), srv: newPublicServer(cfg, mux, false)})
+	default:
+		policy := markRefusals(filteredHostPolicy(newHostFilter(cfg), cfg.canonicalHost()))
+		store := cfg.certCache()
+		primaryPolicy, primaryCAA := policy, newCAAChecker(cfg, cfg.ACMEDirectory, cfg.acmeCAAIdentities())
+		if primaryCAA != nil {
+			primaryPolicy = primaryCAA.policy(policy)
+			caaChecks = append(caaChecks, primaryCAA)
+		}
+		manager := newSwitchedManager(func() *autocert.Manager {
+			m := &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				Cache:      newIssuerCache(newRateLimitedCache(store), store, cfg.ACMEDirectory),
+				HostPolicy: primaryPolicy,
+				Client:     newACMEClient(cfg),
+				Email:      cfg.ACMEEmail,
+			}
+			m.ExternalAccountBinding, _ = cfg.acmeEAB()
+			return m
+		})
+		var secondary *switchedManager
+		var fallback *caFallback
+		if cfg.ACMEFallbackDirectory != "" {
+			secondaryPolicy := policy
+			if caa := newCAAChecker(cfg, cfg.ACMEFallbackDirectory, nil); caa != nil {
+				secondaryPolicy = caa.policy(policy)
+				caaChecks = append(caaChecks, caa)
+			}
+			secondary = newSwitchedManager(func() *autocert.Manager {
+				m := &autocert.Manager{
+					Prompt:     autocert.AcceptTOS,
+					Cache:      newIssuerCache(newRateLimitedCache(store), store, cfg.ACMEFallbackDirectory),
+					HostPolicy: secondaryPolicy,
+					Client:     &acme.Client{DirectoryURL: cfg.ACMEFallbackDirectory},
+					Email:      cfg.ACMEEmail,
+				}
+				m.ExternalAccountBinding, _ = cfg.acmeFallbackEAB()
+				return m
+			})
+			fallback = newCAFallback(secondary.GetCertificate)
+		}
+		lock := newIssueLock(store, policy)
+		dns := newDNSCertManager(cfg, policy, store, primaryCAA)
+		static, err := newStaticCerts(cfg)
+		if err != nil {
 			log.Fatal(err)
 		}
+		tlsConfig := func() *tls.Config {
+			config := manager.tlsConfig()
+			if fallback != nil {
+				config = fallback.tlsConfig(config)
+			}
+			if lock != nil {
+				config = lock.tlsConfig(config)
+			}
+			if cfg.ACMEKeyType == keyTypeRSA {
+				config = rsaOnly(config)
+			}
+			if dns != nil {
+				config = dns.tlsConfig(config)
+			}
+			if static != nil {
+				config = static.tlsConfig(config)
+			}
+			return tlsSettings.apply(reportCertErrors(config))
+		}
+		if dns != nil {
+			go dns.run(context.Background())
+		}
+		if static != nil {
+			go static.watch(context.Background())
+		}
+		prewarm, err := newCertPrewarm(cfg, tlsConfig().GetCertificate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if prewarm != nil {
+			warmup.Add(1)
+			go prewarm.run(context.Background(), cfg.CertPrewarmEvery, warmup.Done)
+		}
+		certs = &certAdmin{
+			cache: store,
+			dns:   dns,
+			get:   tlsConfig().GetCertificate,
+			reset: func() {
+				manager.reset()
+				if secondary != nil {
+					secondary.reset()
+				}
+			},
+			keyType:   cfg.ACMEKeyType,
+			directory: cfg.ACMEDirectory,
+		}
+		servers = append(servers, httpsServers(cfg, mux, manager.httpHandler(mux), tlsConfig)...)
+	}
+	// The admin APIs manage the certificates set up above.
+	if addr := cfg.AdminAddr; addr != "" {
+		admin := server{name: "admin", addr: addr, srv: &http.Server{Handler: recoverPanics(newAdminMux(cfg, quota))}}
+		if cfg.AdminCert != "" {
+			config, err := loadServerTLS(cfg.AdminCert, cfg.AdminKey, cfg.AdminClientCA, tls.VerifyClientCertIfGiven)
+			if err != nil {
+				log.Fatalf("admin_cert: %v", err)
+			}
+			admin.srv.TLSConfig, admin.tls = config, true
+		}
+		servers = append(servers, admin)
+	}
+	if addr := cfg.GRPCAddr; addr != "" {
+		srv, err := newGRPCServer(cfg, quota)
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers = append(servers, server{name: "grpc", addr: addr, tls: true, srv: srv})
+	}
+	for i := range servers {
+		if slices.Contains(cfg.proxyProtocolListeners(), servers[i].name) {
+			servers[i].proxyProtocol = true
+			servers[i].trusted = cfg.trustedProxies()
+		}
+	}
+	go func() {
+		warmup.Wait()
+		setPhase(phaseServing)
 	}()
+	serve(up, cfg, servers)
+	// With the listeners drained, nothing more is recorded: flush what's
+	// buffered.
+	if clicks != nil {
+		if err := clicks.Flush(); err != nil {
+			log.Printf("Writing analytics: %v", err)
+		}
+	}
+	if statsd != nil {
+		statsd.Push()
+	}
+	if srcs.cache != nil && cfg.CacheFile != "" {
+		if err := saveCache(cfg.CacheFile, srcs.cache); err != nil {
+			log.Printf("Saving cache_file: %v", err)
+		}
+	}
+	if abuseReports != nil {
+		abuseReports.save()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	for _, f := range redirectEvents {
+		if err := f.Close(ctx); err != nil {
+			log.Printf("Sending queued %s events: %v", f.name, err)
+		}
+	}
+	if tracer != nil {
+		tracer.Shutdown(ctx)
+	}
+}
+
+// httpsServers returns the http, https and, if http3_addr is set, http3
+// listeners serving mux with certificates from tlsConfig. The http one
+// serves httpHandler.
+func httpsServers(cfg *config, mux, httpHandler http.Handler, tlsConfig func() *tls.Config) []server {
+	var h3 *http3.Server
+	handler := mux
+	if cfg.HTTP3Addr != "" {
+		h3 = newHTTP3Server(cfg, tlsConfig(), mux)
+		handler = altSvc(h3, mux)
+	}
+	https := newPublicServer(cfg, handler, true)
+	https.TLSConfig = tlsConfig()
+	servers := []server{
+		{name: "http", addr: cfg.HTTPAddr, srv: newPublicServer(cfg, httpHandler, false)},
+		{name: "https", addr: cfg.HTTPSAddr, tls: true, srv: https},
+	}
+	if h3 != nil {
+		servers = append(servers, server{name: "http3", addr: cfg.HTTP3Addr, h3: h3})
+	}
+	return servers
+}
 
-	httpsSrv := &http.Server{
-		Addr:         ":443",
-		Handler:      mux,
-		TLSConfig:    manager.TLSConfig(),
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+// newPublicServer returns a server for one of the public listeners. Plain
+// HTTP listeners also speak HTTP/2 without TLS (h2c) if cfg.H2C is set.
+func newPublicServer(cfg *config, h http.Handler, tls bool) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: cfg.HTTP2MaxStreams},
+	}
+	if cfg.H2C && !tls {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// server is one of the HTTP servers main runs: srv on a TCP listener, or h3
+// on a UDP socket. Its name identifies its socket when handing it to a new
+// process.
+type server struct {
+	name string
+	addr string
+	tls  bool
+	srv  *http.Server
+	h3   *http3.Server
+
+	// proxyProtocol expects a PROXY protocol header on every connection
+	// from trusted (or, if empty, any) peers.
+	proxyProtocol bool
+	trusted       []netip.Prefix
+}
+
+// serve runs every server on a listener from up until SIGTERM or SIGINT, or
+// until an upgrade signal has handed the listeners to a new process, then
+// shuts them all down gracefully. On SIGTERM or SIGINT, /readyz answers 503
+// for drain_delay first, for load balancers to stop sending requests.
+func serve(up *upgrader, cfg *config, servers []server) {
+	for _, s := range servers {
+		if s.h3 != nil {
+			conn, err := up.ListenPacket(s.name, s.addr)
+			if err != nil {
+				log.Fatalf("%s listener: %v", s.name, err)
+			}
+			log.Printf("Listening for %s on %s", s.name, conn.LocalAddr())
+			go func() {
+				if err := s.h3.Serve(conn); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("%s listener: %v", s.name, err)
+				}
+			}()
+			continue
+		}
+		ln, err := up.Listen(s.name, s.addr)
+		if err != nil {
+			log.Fatalf("%s listener: %v", s.name, err)
+		}
+		log.Printf("Listening for %s on %s", s.name, ln.Addr())
+		if s.proxyProtocol {
+			ln = &proxyproto.Listener{Listener: ln, Trusted: s.trusted}
+		}
+		go func() {
+			var err error
+			if s.tls {
+				err = s.srv.ServeTLS(ln, "", "")
+			} else {
+				err = s.srv.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("%s listener: %v", s.name, err)
+			}
+		}()
+	}
+	if err := up.Ready(); err != nil {
+		log.Printf("Notifying systemd: %v", err)
 	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-stop
-		log.Println("Shutting down...")
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		var wg sync.WaitGroup
-		wg.Add(2)
-		go func() { defer wg.Done(); httpSrv.Shutdown(ctx) }()
-		go func() { defer wg.Done(); httpsSrv.Shutdown(ctx) }()
-		wg.Wait()
-	}()
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+	}
+	for waiting := true; waiting; {
+		select {
+		case <-stop:
+			setPhase(phaseDraining)
+			if cfg.DrainDelay > 0 {
+				log.Printf("Draining for %s...", cfg.DrainDelay)
+				time.Sleep(cfg.DrainDelay)
+			}
+			waiting = false
+		case <-upgrade:
+			log.Println("Upgrading...")
+			if err := up.Upgrade(cfg.UpgradeTimeout); err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Println("New process is ready")
+			setPhase(phaseDraining)
+			waiting = false
+		}
+	}
 
-	log.Printf("Listening on :80 and :443")
-	if err := httpsSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-		log.Fatal(err)
+	log.Println("Shutting down...")
+	shutdown(servers, cfg.DrainTimeout)
+}
+
+// shutdown stops servers accepting connections and waits up to timeout
+// for their requests in flight to finish, then closes the connections
+// still open.
+func shutdown(servers []server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Go(func() {
+			var err error
+			if s.h3 != nil {
+				err = s.h3.Shutdown(ctx)
+			} else {
+				err = s.srv.Shutdown(ctx)
+			}
+			if err == nil {
+				return
+			}
+			log.Printf("%s listener still had requests in flight after %s, closing its connections", s.name, timeout)
+			if s.h3 != nil {
+				s.h3.Close()
+			} else {
+				s.srv.Close()
+			}
+		})
 	}
+	wg.Wait()
 }
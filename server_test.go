@@ -3,161 +3,48 @@ package main
 import (
 	"context"
 	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
-)
-
-func TestGetRedirectSimple(t *testing.T) {
-	var redirect *Redirect
-	var err error
-
-	dnsTXT := []string{
-		"Redirects from /test/* to https://github.com/holic/*",
-	}
-
-	redirect, err = getRedirect(dnsTXT, "/test/")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic/")
-
-	redirect, err = getRedirect(dnsTXT, "/test/success")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic/success")
-
-	redirect, err = getRedirect(dnsTXT, "/should/fail")
-	assertEqual(t, err.Error(), "No paths matched")
-}
-
-func TestGetRedirectComplex(t *testing.T) {
-	// Tests that catchalls (even interspersed in the TXT records) apply
-	// only after more specific matches
-	var redirect *Redirect
-	var err error
-
-	dnsTXT := []string{
-		"Redirects from /test/* to https://github.com/holic/*",
-		"Redirects to https://github.com/holic",
-		"Redirects from /noglob/ to https://github.com/holic/noglob",
-	}
-
-	redirect, err = getRedirect(dnsTXT, "/")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic")
-
-	redirect, err = getRedirect(dnsTXT, "/test/somepath")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic/somepath")
-
-	redirect, err = getRedirect(dnsTXT, "/noglob/")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic/noglob")
-
-	redirect, err = getRedirect(dnsTXT, "/catch/all")
-	assertEqual(t, err, nil)
-	assertEqual(t, redirect.Location, "https://github.com/holic")
-}
-
-func TestRedirectHandler301CacheControl(t *testing.T) {
-	orig := lookupTXT
-	defer func() { lookupTXT = orig }()
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"Redirects permanently to https://example.com/"}, nil
-	}
-
-	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
-	rr := httptest.NewRecorder()
-	redirectHandler(rr, req)
-
-	if rr.Code != http.StatusMovedPermanently {
-		t.Errorf("expected 301, got %d", rr.Code)
-	}
-	cc := rr.Header().Get("Cache-Control")
-	if cc == "" {
-		t.Error("expected Cache-Control header on 301, got none")
-	}
-}
-
-func TestRedirectHandler308CacheControl(t *testing.T) {
-	orig := lookupTXT
-	defer func() { lookupTXT = orig }()
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"Redirects to https://example.com/ with 308"}, nil
-	}
-
-	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
-	rr := httptest.NewRecorder()
-	redirectHandler(rr, req)
-
-	if rr.Code != http.StatusPermanentRedirect {
-		t.Errorf("expected 308, got %d", rr.Code)
-	}
-	if cc := rr.Header().Get("Cache-Control"); cc == "" {
-		t.Error("expected Cache-Control header on 308, got none")
-	}
-}
-
-func TestRedirectHandler302NoCacheControl(t *testing.T) {
-	orig := lookupTXT
-	defer func() { lookupTXT = orig }()
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"Redirects to https://example.com/"}, nil
-	}
-
-	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
-	rr := httptest.NewRecorder()
-	redirectHandler(rr, req)
+	"time"
 
-	if rr.Code != http.StatusFound {
-		t.Errorf("expected 302, got %d", rr.Code)
-	}
-	if cc := rr.Header().Get("Cache-Control"); cc != "" {
-		t.Errorf("expected no Cache-Control on 302, got %q", cc)
-	}
-}
-
-func TestRedirectHandler307NoCacheControl(t *testing.T) {
-	orig := lookupTXT
-	defer func() { lookupTXT = orig }()
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"Redirects to https://example.com/ with 307"}, nil
-	}
-
-	req := httptest.NewRequest("GET", "http://go.example.com/", nil)
-	rr := httptest.NewRecorder()
-	redirectHandler(rr, req)
+	"github.com/frolic/redirect.name/redirect"
+	"golang.org/x/crypto/acme/autocert"
+)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("expected 307, got %d", rr.Code)
-	}
-	if cc := rr.Header().Get("Cache-Control"); cc != "" {
-		t.Errorf("expected no Cache-Control on 307, got %q", cc)
-	}
+// stubTXT replaces the package resolver with one that answers every host
+// with the given TXT records (or err) for the duration of the test.
+func stubTXT(t *testing.T, txt []string, err error) {
+	t.Helper()
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		if err != nil {
+			return nil, err
+		}
+		return redirect.ParseAll(txt), nil
+	})
 }
 
 func TestHostPolicy(t *testing.T) {
-	orig := lookupTXT
-	defer func() { lookupTXT = orig }()
 
 	// Valid: TXT record contains a parseable redirect config
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"Redirects to https://example.com"}, nil
-	}
+	stubTXT(t, []string{"Redirects to https://example.com"}, nil)
 	if err := hostPolicy(context.Background(), "foo.example.com"); err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 
 	// DNS error
-	lookupTXT = func(host string) ([]string, error) {
-		return nil, errors.New("no such host")
-	}
+	stubTXT(t, nil, errors.New("no such host"))
 	if err := hostPolicy(context.Background(), "foo.example.com"); err == nil {
 		t.Error("expected error for DNS failure")
 	}
 
 	// TXT records exist but none parse as redirect configs
-	lookupTXT = func(host string) ([]string, error) {
-		return []string{"v=spf1 include:example.com ~all"}, nil
-	}
+	stubTXT(t, []string{"v=spf1 include:example.com ~all"}, nil)
 	if err := hostPolicy(context.Background(), "foo.example.com"); err == nil {
 		t.Error("expected error when no valid redirect config found")
 	}
@@ -165,7 +52,7 @@ func TestHostPolicy(t *testing.T) {
 
 func TestRateLimitedCache(t *testing.T) {
 	dir := t.TempDir()
-	cache := newRateLimitedCache(dir)
+	cache := newRateLimitedCache(autocert.DirCache(dir))
 	ctx := context.Background()
 	data := []byte("test-cert-data")
 
@@ -187,8 +74,163 @@ func TestRateLimitedCache(t *testing.T) {
 		t.Fatalf("different apex put failed: %v", err)
 	}
 
-	// Non-domain keys (e.g., acme account key) bypass rate limiting
-	if err := cache.Put(ctx, "acme_account+key", data); err != nil {
-		t.Fatalf("acme account key put failed: %v", err)
+	// Non-domain keys (e.g., acme account key, challenge tokens) bypass
+	// rate limiting
+	for _, key := range []string{"acme_account+key", "sub1.example.com+token"} {
+		if err := cache.Put(ctx, key, data); err != nil {
+			t.Fatalf("%s put failed: %v", key, err)
+		}
+	}
+
+	// The count survives a restart
+	now := time.Now()
+	cache = newRateLimitedCache(autocert.DirCache(dir))
+	cache.now = func() time.Time { return now }
+	if err := cache.Put(ctx, "sub3.example.com", data); err == nil {
+		t.Error("expected rate limit error after restart")
+	}
+
+	// The window rolls: certs issued 7 days ago no longer count
+	now = now.Add(certWindow)
+	for _, key := range []string{"sub3.example.com", "sub4.example.com"} {
+		if err := cache.Put(ctx, key, data); err != nil {
+			t.Errorf("%s put after the window rolled failed: %v", key, err)
+		}
+	}
+	if err := cache.Put(ctx, "sub5.example.com", data); err == nil {
+		t.Error("expected rate limit error once the new window is full")
+	}
+}
+
+func TestPublicServerH2C(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.H2C = true
+	cfg.HTTP2MaxStreams = 10
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newPublicServer(cfg, http.HandlerFunc(healthzHandler), false)
+	ts.Start()
+	defer ts.Close()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	resp, err := client.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("want HTTP/2 over cleartext, got %s", resp.Proto)
+	}
+
+	if srv := newPublicServer(cfg, nil, true); srv.Protocols != nil {
+		t.Error("TLS listeners should keep the default protocols")
+	}
+}
+
+func TestPublicServerLimits(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MaxHeaderBytes = 1 << 10
+	cfg.ReadHeaderTimeout = 50 * time.Millisecond
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newPublicServer(cfg, http.HandlerFunc(healthzHandler), false)
+	ts.Start()
+	defer ts.Close()
+
+	// Go allows 4 KB over MaxHeaderBytes before refusing.
+	req, _ := http.NewRequest("GET", ts.URL+"/healthz", nil)
+	req.Header.Set("X-Padding", strings.Repeat("x", 8<<10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers: want 431, got %d", resp.StatusCode)
+	}
+
+	// A client that never finishes its headers is dropped.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: example.com\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("a slow client's connection wasn't closed: %v", err)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cfg := defaultConfig()
+	cfg.HandlerTimeout = 20 * time.Millisecond
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	rr := httptest.NewRecorder()
+	newMux(cfg, nil).ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("a lookup past handler_timeout: want 503, got %d", rr.Code)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		if r.URL.Path == "/stuck" {
+			<-r.Context().Done()
+			return
+		}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	get := func(path string) (*http.Response, error) {
+		return http.Get("http://" + ln.Addr().String() + path)
+	}
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	finished, stuck := make(chan result), make(chan result)
+	go func() { resp, err := get("/"); finished <- result{resp, err} }()
+	go func() { resp, err := get("/stuck"); stuck <- result{resp, err} }()
+	<-started
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		shutdown([]server{{name: "http", srv: srv}}, 200*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if _, err := get("/"); err == nil {
+		t.Error("a new connection was accepted while draining")
+	}
+	close(release)
+	if r := <-finished; r.err != nil || r.resp.StatusCode != http.StatusNoContent {
+		t.Errorf("the request in flight: %v", r.err)
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown returned with a request in flight")
+	case r := <-stuck:
+		t.Fatalf("the stuck request finished before the drain timeout: %v", r.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-done
+	if r := <-stuck; r.err == nil {
+		t.Errorf("the stuck request's connection wasn't closed, got %d", r.resp.StatusCode)
 	}
 }
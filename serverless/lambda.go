@@ -0,0 +1,245 @@
+package serverless
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// StartLambda serves the invocations of an AWS Lambda function with h,
+// through the runtime API at api, the host and port Lambda gives in
+// AWS_LAMBDA_RUNTIME_API, until ctx is done or the API fails. This makes
+// the binary a custom runtime (provided.al2023), run as bootstrap.
+//
+// Invocations are API Gateway or function URL events, in either payload
+// format: the REST APIs' 1.0 or the HTTP APIs' and function URLs' 2.0.
+// Requests are taken to have come over HTTPS, the way both are reached.
+func StartLambda(ctx context.Context, api string, h http.Handler) error {
+	if api == "" {
+		return errors.New("AWS_LAMBDA_RUNTIME_API is not set: not running in Lambda")
+	}
+	base := "http://" + api + "/2018-06-01/runtime/invocation/"
+	// Waiting for the next invocation takes as long as it takes.
+	client := &http.Client{}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"next", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("lambda runtime API: %w", err)
+		}
+		event, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("lambda runtime API: reading invocation: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("lambda runtime API: next invocation: %s", resp.Status)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		if trace := resp.Header.Get("Lambda-Runtime-Trace-Id"); trace != "" {
+			os.Setenv("_X_AMZN_TRACE_ID", trace)
+		}
+		invocation := ctx
+		cancel := context.CancelFunc(func() {})
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			invocation, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		}
+		body, err := invoke(invocation, h, event)
+		path := "/response"
+		if err != nil {
+			path = "/error"
+			body, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"})
+		}
+		cancel()
+		if err := post(ctx, client, base+url.PathEscape(id)+path, body); err != nil {
+			return err
+		}
+	}
+}
+
+// post reports an invocation's result to the runtime API.
+func post(ctx context.Context, client *http.Client, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("lambda runtime API: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("lambda runtime API: reporting invocation: %s", resp.Status)
+	}
+	return nil
+}
+
+// An event is an API Gateway or function URL invocation, with the fields
+// of both payload formats.
+type event struct {
+	Version string `json:"version"`
+	// Payload format 1.0
+	HTTPMethod        string              `json:"httpMethod"`
+	Path              string              `json:"path"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	MultiValueQuery   url.Values          `json:"multiValueQueryStringParameters"`
+	Query             map[string]string   `json:"queryStringParameters"`
+	// Payload format 2.0
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+	RequestContext  struct {
+		DomainName string `json:"domainName"`
+		Identity   struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// A response is the answer to an event, in the payload format of either:
+// 1.0 reads multiValueHeaders and 2.0 headers and cookies.
+type response struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// invoke answers an invocation's event with h.
+func invoke(ctx context.Context, h http.Handler, payload []byte) ([]byte, error) {
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+	r, err := e.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &recorder{header: make(http.Header)}
+	h.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	resp := response{StatusCode: w.status}
+	if e.Version == "2.0" {
+		resp.Headers = make(map[string]string)
+		for name, values := range w.header {
+			if name == "Set-Cookie" {
+				resp.Cookies = values
+				continue
+			}
+			resp.Headers[name] = strings.Join(values, ", ")
+		}
+	} else {
+		resp.MultiValueHeaders = w.header
+	}
+	if body := w.body.Bytes(); utf8.Valid(body) {
+		resp.Body = string(body)
+	} else {
+		resp.Body, resp.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	}
+	return json.Marshal(resp)
+}
+
+// request returns the request e describes.
+func (e *event) request(ctx context.Context) (*http.Request, error) {
+	method, path, query, ip := e.HTTPMethod, e.Path, "", e.RequestContext.Identity.SourceIP
+	if e.Version == "2.0" {
+		method, path, query, ip = e.RequestContext.HTTP.Method, e.RawPath, e.RawQueryString, e.RequestContext.HTTP.SourceIP
+	} else if len(e.MultiValueQuery) > 0 {
+		query = e.MultiValueQuery.Encode()
+	} else if len(e.Query) > 0 {
+		values := make(url.Values)
+		for k, v := range e.Query {
+			values.Set(k, v)
+		}
+		query = values.Encode()
+	}
+	if method == "" || !strings.HasPrefix(path, "/") {
+		return nil, errors.New("not an API Gateway or function URL event")
+	}
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, fmt.Errorf("decoding body: %w", err)
+		}
+	}
+	target := path
+	if query != "" {
+		target += "?" + query
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.MultiValueHeaders {
+		for _, v := range values {
+			r.Header.Add(name, v)
+		}
+	}
+	for name, v := range e.Headers {
+		if r.Header.Get(name) == "" {
+			r.Header.Set(name, v)
+		}
+	}
+	if len(e.Cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	r.Host = r.Header.Get("Host")
+	if r.Host == "" {
+		r.Host = e.RequestContext.DomainName
+	}
+	r.RequestURI = target
+	r.RemoteAddr = net.JoinHostPort(ip, "0")
+	r.ContentLength = int64(len(body))
+	r.TLS = &tls.ConnectionState{ServerName: r.Host}
+	return r, nil
+}
+
+// recorder is the http.ResponseWriter of an invocation.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recorder) Header() http.Header { return w.header }
+
+func (w *recorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
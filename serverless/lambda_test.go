@@ -0,0 +1,125 @@
+package serverless
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func newTestHandler() http.Handler {
+	return redirect.NewHandler(redirect.WithResolver(redirect.StaticResolver{
+		"go.example.com": {"Redirects from /docs/* to https://docs.example.com/* permanently"},
+	}))
+}
+
+func TestInvoke(t *testing.T) {
+	for _, tc := range []struct {
+		name, event string
+		headers     string
+	}{
+		{"REST API", `{
+			"httpMethod": "GET",
+			"path": "/docs/intro",
+			"multiValueHeaders": {"Host": ["go.example.com"]},
+			"multiValueQueryStringParameters": {"lang": ["en"]},
+			"requestContext": {"identity": {"sourceIp": "203.0.113.7"}}
+		}`, "multiValueHeaders"},
+		{"HTTP API", `{
+			"version": "2.0",
+			"rawPath": "/docs/intro",
+			"rawQueryString": "lang=en",
+			"headers": {"host": "go.example.com"},
+			"requestContext": {"domainName": "go.example.com", "http": {"method": "GET", "sourceIp": "203.0.113.7"}}
+		}`, "headers"},
+	} {
+		out, err := invoke(context.Background(), newTestHandler(), []byte(tc.event))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(out, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp["statusCode"] != 301.0 {
+			t.Errorf("%s: status %v", tc.name, resp["statusCode"])
+		}
+		location := resp[tc.headers].(map[string]any)["Location"]
+		if tc.headers == "multiValueHeaders" {
+			location = location.([]any)[0]
+		}
+		if location != "https://docs.example.com/intro?lang=en" {
+			t.Errorf("%s: Location %v", tc.name, location)
+		}
+	}
+
+	// The request is as a server would have received it over HTTPS.
+	var got *http.Request
+	invoke(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+		w.Write([]byte{0xff, 0xfe})
+	}), []byte(`{
+		"version": "2.0",
+		"rawPath": "/",
+		"cookies": ["x=1", "y=2"],
+		"headers": {"host": "go.example.com"},
+		"body": "aGk=",
+		"isBase64Encoded": true,
+		"requestContext": {"http": {"method": "POST", "sourceIp": "2001:db8::1"}}
+	}`))
+	if got == nil || got.TLS == nil || got.RemoteAddr != "[2001:db8::1]:0" || got.Header.Get("Cookie") != "x=1; y=2" {
+		t.Fatalf("request: %+v", got)
+	}
+	if body, _ := io.ReadAll(got.Body); string(body) != "hi" {
+		t.Errorf("body %q", body)
+	}
+
+	if _, err := invoke(context.Background(), newTestHandler(), []byte(`{"source": "aws.events"}`)); err == nil {
+		t.Error("invoked with a scheduled event")
+	}
+}
+
+func TestStartLambda(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var responses []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/2018-06-01/runtime/invocation/next" && len(responses) == 0:
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req-1")
+			w.Header().Set("Lambda-Runtime-Deadline-Ms", "99999999999999")
+			io.WriteString(w, `{"version": "2.0", "rawPath": "/docs/a", "headers": {"host": "go.example.com"}, "requestContext": {"http": {"method": "GET"}}}`)
+		case r.URL.Path == "/2018-06-01/runtime/invocation/next":
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req-2")
+			io.WriteString(w, `[]`)
+		case r.Method == http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			responses = append(responses, r.URL.Path+" "+string(body))
+			if len(responses) == 2 {
+				cancel()
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer api.Close()
+	err := StartLambda(ctx, strings.TrimPrefix(api.URL, "http://"), newTestHandler())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("StartLambda: %v", err)
+	}
+	if len(responses) != 2 || !strings.HasPrefix(responses[0], "/2018-06-01/runtime/invocation/req-1/response ") || !strings.Contains(responses[0], "https://docs.example.com/a") {
+		t.Fatalf("responses: %q", responses)
+	}
+	if !strings.HasPrefix(responses[1], "/2018-06-01/runtime/invocation/req-2/error ") {
+		t.Errorf("response to a bad event: %q", responses[1])
+	}
+	if err := StartLambda(ctx, "", newTestHandler()); err == nil {
+		t.Error("StartLambda outside Lambda")
+	}
+}
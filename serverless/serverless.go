@@ -0,0 +1,69 @@
+// Package serverless runs the redirect handler on serverless platforms,
+// for self-hosters whose traffic doesn't warrant a server of its own:
+// StartLambda serves AWS Lambda invocations from API Gateway or function
+// URLs, and Redirect is the entry point of a Google Cloud Function.
+package serverless
+
+import (
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// CacheEntries bounds the hosts a serverless instance caches. An instance
+// serves one request at a time and lives for minutes or hours, so it sees
+// few hosts, and its memory is small.
+const CacheEntries = 256
+
+// NewCache returns a Cache in front of resolver tuned for short-lived
+// instances: answers are kept for 5 minutes, as the low traffic such
+// instances serve would otherwise find them expired on most requests, and
+// not-found answers for 30 seconds, so a newly added record isn't missed
+// for long.
+func NewCache(resolver redirect.Resolver) *redirect.Cache {
+	c := redirect.NewCache(resolver, 5*time.Minute)
+	c.NegativeTTL = 30 * time.Second
+	c.MaxEntries = CacheEntries
+	return c
+}
+
+// NewHandler returns the redirect handler of a serverless instance,
+// configured from the environment as read by getenv: DOH_URL names a
+// DNS-over-HTTPS endpoint to use instead of the system resolver, and
+// FALLBACK_URL where requests without a matching rule go, the fallback
+// page being served if it's empty. Rules are looked up through NewCache.
+func NewHandler(getenv func(string) string) http.Handler {
+	var resolver redirect.Resolver = redirect.DNSResolver{}
+	if url := getenv("DOH_URL"); url != "" {
+		resolver = &redirect.DoHResolver{URL: url}
+	}
+	opts := []redirect.Option{redirect.WithResolver(NewCache(resolver))}
+	if url := getenv("FALLBACK_URL"); url != "" {
+		opts = append(opts, redirect.WithFallbackURL(url))
+	} else {
+		opts = append(opts, redirect.WithFallbackPage())
+	}
+	return redirect.NewHandler(opts...)
+}
+
+// everywhere is every address: a function is reached through its
+// platform's front end only, whose X-Forwarded-* headers are trusted.
+var everywhere = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+
+var functionHandler = sync.OnceValue(func() http.Handler {
+	return redirect.TrustProxies(everywhere, NewHandler(os.Getenv))
+})
+
+// Redirect is the entry point of a Google Cloud Function, or of anything
+// else run by the Functions Framework, redirecting each request as
+// NewHandler does:
+//
+//	gcloud functions deploy redirect --gen2 --runtime go125 --trigger-http \
+//	  --allow-unauthenticated --entry-point Redirect
+func Redirect(w http.ResponseWriter, r *http.Request) {
+	functionHandler().ServeHTTP(w, r)
+}
@@ -0,0 +1,28 @@
+package serverless
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestNewCache(t *testing.T) {
+	c := NewCache(redirect.StaticResolver{})
+	if c.TTL != 5*time.Minute || c.NegativeTTL != 30*time.Second || c.MaxEntries != CacheEntries {
+		t.Errorf("cache: TTL %v, NegativeTTL %v, MaxEntries %d", c.TTL, c.NegativeTTL, c.MaxEntries)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	env := map[string]string{"FALLBACK_URL": "https://example.com/setup", "DOH_URL": "http://127.0.0.1:1/dns-query"}
+	h := NewHandler(func(name string) string { return env[name] })
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://go.example.com/", nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), env["FALLBACK_URL"]) {
+		t.Errorf("got %d to %q, want the fallback URL", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/internal/dnsprovider"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// setup onboards a host, for `redirect-name setup`: it adds the host's
+// TXT record for a rule through a DNS provider's API, waits until
+// the record resolves, and has the service order the host's certificate,
+// so none of it is left to do by hand.
+type setup struct {
+	provider dnsprovider.Provider
+	out      io.Writer
+	// existing returns the TXT records already at a name, which setup
+	// keeps; propagated waits for a record to be visible, and certificate
+	// returns the one served for a host, ordering it if need be.
+	existing    func(ctx context.Context, fqdn string) ([]string, error)
+	propagated  func(ctx context.Context, fqdn, value string) error
+	certificate func(ctx context.Context, host string) (*x509.Certificate, error)
+}
+
+// runSetup runs `redirect-name setup` with args, such as
+//
+//	-host go.example.com -provider cloudflare -from /x/* -to https://y/*
+//
+// Credentials for the provider default to acme_dns_credentials', from the
+// environment or its _FILE, and the provider to acme_dns_provider's.
+func runSetup(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	host := fs.String("host", "", "hostname to redirect, whose DNS is hosted by provider")
+	from := fs.String("from", "", "path to redirect, with at most one *; every path if empty")
+	to := fs.String("to", "", "URL to redirect to, with a * for the path matched by from's")
+	provider := fs.String("provider", getenv("ACME_DNS_PROVIDER"), "DNS provider hosting host's zone: "+strings.Join(dnsprovider.Names, ", "))
+	credentials := fs.String("credentials", "", "API token for provider, or ACCESS_KEY_ID:SECRET_ACCESS_KEY for route53; ACME_DNS_CREDENTIALS or ACME_DNS_CREDENTIALS_FILE's if empty")
+	wait := fs.Duration("wait", 5*time.Minute, "how long to wait for the record to resolve, and for the certificate")
+	prewarm := fs.Bool("prewarm", true, "have the service order host's certificate once the record resolves")
+	dryRun := fs.Bool("dry-run", false, "print the record without writing it")
+	fs.SetOutput(out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	name, record, err := setupRecord(*host, *from, *to)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "%s. TXT %q\n", name, record)
+		return nil
+	}
+	if *credentials == "" {
+		if *credentials, err = secretEnv(getenv, "ACME_DNS_CREDENTIALS"); err != nil {
+			return err
+		}
+	}
+	p, err := dnsprovider.New(*provider, *credentials)
+	if err != nil {
+		return err
+	}
+	s := &setup{
+		provider: p,
+		out:      out,
+		existing: lookupTXT,
+		propagated: func(ctx context.Context, fqdn, value string) error {
+			return waitForTXT(ctx, net.DefaultResolver, fqdn, value, *wait)
+		},
+	}
+	if *prewarm {
+		s.certificate = func(ctx context.Context, host string) (*x509.Certificate, error) {
+			return waitForCertificate(ctx, host, *wait)
+		}
+	}
+	return s.run(ctx, name, record)
+}
+
+// setupRecord returns the TXT record redirecting host's from paths to to,
+// and the name it goes at.
+func setupRecord(host, from, to string) (name, record string, err error) {
+	h, err := redirect.ParseHost(host)
+	if err != nil || h == "" {
+		return "", "", fmt.Errorf("-host must be a hostname, such as go.example.com")
+	}
+	if to == "" {
+		return "", "", errors.New("-to is required")
+	}
+	if err := redirect.ValidateTarget(to); err != nil {
+		return "", "", fmt.Errorf("-to: %w", err)
+	}
+	record = "Redirects to " + to
+	if from != "" {
+		if !strings.HasPrefix(from, "/") || strings.Count(from, "*") > 1 {
+			return "", "", fmt.Errorf("-from must be a path with at most one *, such as /docs/*")
+		}
+		record = "Redirects from " + from + " to " + to
+	}
+	if rule := redirect.Parse(record); rule == nil {
+		return "", "", fmt.Errorf("%q is not a rule", record)
+	}
+	return redirect.RecordName(h), record, nil
+}
+
+// run adds record at name, next to the records already there, waits for
+// it, and prewarms the certificate of the host it's for. It refuses to add
+// a rule for paths an existing one already redirects.
+func (s *setup) run(ctx context.Context, name, record string) error {
+	existing, err := s.existing(ctx, name)
+	if err != nil {
+		return fmt.Errorf("looking up %s: %w", name, err)
+	}
+	if slices.Contains(existing, record) {
+		fmt.Fprintf(s.out, "%s. TXT %q is already there\n", name, record)
+	} else {
+		from := redirect.Parse(record).From
+		for _, r := range existing {
+			if rule := redirect.Parse(r); rule != nil && rule.From == from && !rule.Bots && rule.Methods == "" && rule.Header == "" {
+				return fmt.Errorf("%s already has %q for the same paths; remove it first to replace it", name, r)
+			}
+		}
+		if err := s.provider.Present(ctx, name, record); err != nil {
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+		fmt.Fprintf(s.out, "Added %s. TXT %q\n", name, record)
+		for _, r := range existing {
+			fmt.Fprintf(s.out, "Kept %s. TXT %q\n", name, r)
+		}
+	}
+	if err := s.propagated(ctx, name, record); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "%s resolves\n", name)
+	if s.certificate == nil {
+		return nil
+	}
+	host := strings.TrimPrefix(name, "_redirect.")
+	cert, err := s.certificate(ctx, host)
+	if err != nil {
+		return fmt.Errorf("the record is live, but %s has no certificate yet: %w; check that it points at this service", host, err)
+	}
+	fmt.Fprintf(s.out, "https://%s/ is served with a certificate from %s, valid until %s\n", host, cert.Issuer.CommonName, cert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// lookupTXT returns the TXT records at fqdn, or none if it doesn't exist.
+func lookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	records, err := net.DefaultResolver.LookupTXT(ctx, fqdn)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	return records, err
+}
+
+// waitForCertificate connects to host over HTTPS until it's served with a
+// valid certificate, which has the service order one on the first
+// handshake, for up to timeout.
+func waitForCertificate(ctx context.Context, host string, timeout time.Duration) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, "443"))
+		if err == nil {
+			defer conn.Close()
+			return conn.(*tls.Conn).ConnectionState().PeerCertificates[0], nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetupRecord(t *testing.T) {
+	for _, tc := range []struct {
+		host, from, to string
+		name, record   string
+		err            string
+	}{
+		{"Go.Example.com", "/x/*", "https://y.example.com/*", "_redirect.go.example.com", "Redirects from /x/* to https://y.example.com/*", ""},
+		{"go.example.com", "", "https://example.com/", "_redirect.go.example.com", "Redirects to https://example.com/", ""},
+		{"bücher.example", "", "https://example.com/", "_redirect.xn--bcher-kva.example", "Redirects to https://example.com/", ""},
+		{"", "", "https://example.com/", "", "", "-host"},
+		{"go.example.com", "", "", "", "", "-to is required"},
+		{"go.example.com", "", "javascript:alert(1)", "", "", "-to"},
+		{"go.example.com", "x/*", "https://example.com/", "", "", "-from"},
+		{"go.example.com", "/*/*", "https://example.com/", "", "", "-from"},
+	} {
+		name, record, err := setupRecord(tc.host, tc.from, tc.to)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%+v: got %v, want %q", tc, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || name != tc.name || record != tc.record {
+			t.Errorf("%+v: got %q %q, %v", tc, name, record, err)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	provider := &fakeProvider{records: make(map[string][]string)}
+	var out bytes.Buffer
+	var waited, prewarmed string
+	s := &setup{
+		provider: provider,
+		out:      &out,
+		existing: provider.lookup,
+		propagated: func(ctx context.Context, fqdn, value string) error {
+			if !provider.has(fqdn) {
+				t.Errorf("waited for %s before adding it", fqdn)
+			}
+			waited = fqdn
+			return nil
+		},
+		certificate: func(ctx context.Context, host string) (*x509.Certificate, error) {
+			prewarmed = host
+			return &x509.Certificate{Issuer: pkix.Name{CommonName: "R11"}, NotAfter: time.Now().Add(90 * 24 * time.Hour)}, nil
+		},
+	}
+	if err := s.run(context.Background(), "_redirect.go.example.com", "Redirects to https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := provider.records["_redirect.go.example.com"]; len(got) != 1 || got[0] != "Redirects to https://example.com/" {
+		t.Errorf("records: %q", got)
+	}
+	if waited != "_redirect.go.example.com" || prewarmed != "go.example.com" {
+		t.Errorf("waited for %q, prewarmed %q", waited, prewarmed)
+	}
+	if !strings.Contains(out.String(), "certificate from R11") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	s.certificate = func(ctx context.Context, host string) (*x509.Certificate, error) {
+		return nil, errors.New("connection refused")
+	}
+	if err := s.run(context.Background(), "_redirect.go.example.com", "Redirects to https://example.com/"); err == nil || !strings.Contains(err.Error(), "record is live") {
+		t.Errorf("failed prewarm: %v", err)
+	}
+}
+
+func TestSetupExistingRecords(t *testing.T) {
+	const name = "_redirect.go.example.com"
+	provider := &fakeProvider{records: map[string][]string{name: {"Redirects from /docs/* to https://docs.example.com/*"}}}
+	var out bytes.Buffer
+	s := &setup{
+		provider:   provider,
+		out:        &out,
+		existing:   provider.lookup,
+		propagated: func(ctx context.Context, fqdn, value string) error { return nil },
+	}
+	if err := s.run(context.Background(), name, "Redirects to https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := provider.records[name]; len(got) != 2 || got[0] != "Redirects from /docs/* to https://docs.example.com/*" {
+		t.Errorf("want the existing rule kept, got %q", got)
+	}
+	if !strings.Contains(out.String(), "Kept "+name+". TXT") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	if err := s.run(context.Background(), name, "Redirects to https://example.com/"); err != nil || len(provider.records[name]) != 2 {
+		t.Errorf("adding the same record again: %v, records %q", err, provider.records[name])
+	}
+	err := s.run(context.Background(), name, "Redirects from /docs/* to https://elsewhere.example.com/*")
+	if err == nil || !strings.Contains(err.Error(), "remove it first") || len(provider.records[name]) != 2 {
+		t.Errorf("a second rule for /docs/*: want it refused, got %v, records %q", err, provider.records[name])
+	}
+}
+
+func TestRunSetup(t *testing.T) {
+	var out bytes.Buffer
+	err := runSetup(context.Background(), []string{"-host", "go.example.com", "-from", "/x/*", "-to", "https://y.example.com/*", "-dry-run"}, env(nil), &out)
+	if err != nil || out.String() != "_redirect.go.example.com. TXT \"Redirects from /x/* to https://y.example.com/*\"\n" {
+		t.Errorf("dry run: %v\n%s", err, out.String())
+	}
+	err = runSetup(context.Background(), []string{"-host", "go.example.com", "-to", "https://example.com/"}, env(map[string]string{"ACME_DNS_PROVIDER": "cloudflare"}), &out)
+	if err == nil || !strings.Contains(err.Error(), "needs credentials") {
+		t.Errorf("without credentials: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(file, []byte("not-a-key-pair\n"), 0o600)
+	vars := map[string]string{"ACME_DNS_PROVIDER": "route53", "ACME_DNS_CREDENTIALS_FILE": file}
+	err = runSetup(context.Background(), []string{"-host", "go.example.com", "-to", "https://example.com/"}, env(vars), &out)
+	if err == nil || !strings.Contains(err.Error(), "route53 credentials must be") {
+		t.Errorf("with ACME_DNS_CREDENTIALS_FILE: want the file's credentials used, got %v", err)
+	}
+}
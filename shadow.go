@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// shadowMatchers are the engines shadow_matcher can name: candidates for
+// deciding redirects evaluated alongside the one that does, so a change
+// to matching is tried on real traffic before it serves any.
+var shadowMatchers = map[string]redirect.Matcher{
+	"regexp": redirect.RegexpMatcher,
+}
+
+// shadowMatcherNames lists shadowMatchers' names, for errors.
+func shadowMatcherNames() string {
+	var names []string
+	for name := range shadowMatchers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+var shadowDivergences = registry.Counter("redirect_shadow_divergences_total",
+	"Requests shadow_matcher decided differently from the matcher serving them.", "matcher")
+
+// shadowLog reports the divergences of the shadow matcher name: all of
+// them in redirect_shadow_divergences_total, and in the log a host's at
+// most once per reportRepeatAfter, so that a host diverging on every
+// request doesn't flood it.
+type shadowLog struct {
+	name string
+
+	mu     sync.Mutex
+	logged map[string]time.Time
+}
+
+func newShadowLog(name string) *shadowLog {
+	return &shadowLog{name: name, logged: make(map[string]time.Time)}
+}
+
+func (s *shadowLog) report(r *http.Request, d redirect.Divergence) {
+	shadowDivergences.Inc(s.name)
+	s.mu.Lock()
+	if time.Since(s.logged[d.Host]) < reportRepeatAfter {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.logged) > 1000 {
+		clear(s.logged)
+	}
+	s.logged[d.Host] = time.Now()
+	s.mu.Unlock()
+	log.Printf("Shadow matcher %s diverged on %s", s.name, d)
+}
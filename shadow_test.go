@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestShadowLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := newShadowLog("regexp")
+	req := httptest.NewRequest("GET", "/a", nil)
+	served := &redirect.Redirect{Location: "https://example.com/", Status: 302}
+	for _, host := range []string{"a.example.com", "a.example.com", "b.example.com"} {
+		s.report(req, redirect.Divergence{Host: host, URL: "/a", Served: served, ShadowErr: errors.New("no match")})
+	}
+	got := buf.String()
+	if n := strings.Count(got, "Shadow matcher regexp diverged on a.example.com/a"); n != 1 {
+		t.Errorf("want a.example.com logged once, got %d times in %q", n, got)
+	}
+	if !strings.Contains(got, "b.example.com/a: served 302 to https://example.com/") {
+		t.Errorf("want b.example.com logged, got %q", got)
+	}
+}
+
+func TestShadowMatcherConfig(t *testing.T) {
+	orig := resolver
+	t.Cleanup(func() { resolver = orig })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects from /docs/* to https://docs.example.com/*", "Redirects to https://example.com/"}}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cfg := defaultConfig()
+	cfg.ShadowMatcher = "regexp"
+	h := newMux(cfg, nil)
+	req := httptest.NewRequest("GET", "/docs/intro", nil)
+	req.Host = "go.example.com"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if loc := rr.Header().Get("Location"); loc != "https://docs.example.com/intro" {
+		t.Errorf("want the serving matcher's redirect, got %d to %q", rr.Code, loc)
+	}
+	if strings.Contains(buf.String(), "diverged") {
+		t.Errorf("the engines agree, but logged %q", buf.String())
+	}
+}
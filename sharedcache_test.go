@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// fakeStore is a Redis of one process: GET, SET, DEL and PUBLISH on a
+// map, or errors for all of them when down, or no answer until the
+// context is done when hung.
+type fakeStore struct {
+	mu          sync.Mutex
+	data        map[string]string
+	ttls        map[string]string
+	subscribers []func(string)
+	down, hung  bool
+}
+
+func (f *fakeStore) Do(ctx context.Context, args ...string) (any, error) {
+	f.mu.Lock()
+	if f.hung {
+		f.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errors.New("redis: connection refused")
+	}
+	switch args[0] {
+	case "GET":
+		if v, ok := f.data[args[1]]; ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	case "SET":
+		f.data[args[1]], f.ttls[args[1]] = args[2], args[4]
+		return "OK", nil
+	case "DEL":
+		delete(f.data, args[1])
+		return int64(1), nil
+	case "PUBLISH":
+		for _, handle := range f.subscribers {
+			handle(args[2])
+		}
+		return int64(len(f.subscribers)), nil
+	}
+	return nil, errors.New("redis: unknown command")
+}
+
+func (f *fakeStore) Subscribe(ctx context.Context, channel string, handle func(string)) error {
+	f.mu.Lock()
+	f.subscribers = append(f.subscribers, handle)
+	f.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSharedCache(t *testing.T) {
+	store := &fakeStore{data: map[string]string{}, ttls: map[string]string{}}
+	var lookups int
+	dns := redirect.ResolverFunc(func(ctx context.Context, host string) ([]*redirect.Rule, error) {
+		lookups++
+		if host == "go.example.com" {
+			return redirect.ParseAll([]string{"Redirects to https://example.com/"}), nil
+		}
+		return nil, redirect.ErrNotFound
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Two replicas, each with its own cache in front of the shared one.
+	replica := func() *sources {
+		shared := &sharedCache{resolver: dns, store: store, prefix: "redirect:", ttl: time.Hour, negativeTTL: time.Minute}
+		s := &sources{shared: shared, cache: redirect.NewCache(shared, time.Hour)}
+		go shared.watchPurges(ctx, s.purgeLocal)
+		return s
+	}
+	a, b := replica(), replica()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		store.mu.Lock()
+		n := len(store.subscribers)
+		store.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replicas didn't subscribe")
+		}
+	}
+
+	for _, s := range []*sources{a, b} {
+		if rules, err := s.cache.LookupConfig(ctx, "go.example.com"); err != nil || len(rules) != 1 || rules[0].To != "https://example.com/" {
+			t.Fatalf("got %v, %v", rules, err)
+		}
+		if _, err := s.cache.LookupConfig(ctx, "none.example.com"); !errors.Is(err, redirect.ErrNotFound) {
+			t.Errorf("none.example.com: %v", err)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("%d DNS lookups for two hosts on two replicas", lookups)
+	}
+	if store.ttls["redirect:rules:go.example.com"] != "3600000" || store.ttls["redirect:rules:none.example.com"] != "60000" {
+		t.Errorf("TTLs: %v", store.ttls)
+	}
+	if a.shared.hits.Load() != 0 || b.shared.hits.Load() != 2 {
+		t.Errorf("hits: %d, %d", a.shared.hits.Load(), b.shared.hits.Load())
+	}
+
+	// A purge on one replica purges the others'.
+	a.purge("go.example.com")
+	if _, ok := store.data["redirect:rules:go.example.com"]; ok {
+		t.Error("purge left the shared entry")
+	}
+	if b.cache.Len() != 1 {
+		t.Errorf("%d hosts left in the other replica's cache", b.cache.Len())
+	}
+	b.cache.LookupConfig(ctx, "go.example.com")
+	if lookups != 3 {
+		t.Errorf("%d DNS lookups after the purge", lookups)
+	}
+
+	// Without Redis, lookups go to DNS.
+	store.down = true
+	b.cache.Purge("go.example.com")
+	if rules, err := b.cache.LookupConfig(ctx, "go.example.com"); err != nil || len(rules) != 1 || b.shared.errors.Load() != 2 {
+		t.Errorf("with Redis down: %v, %v, %d errors", rules, err, b.shared.errors.Load())
+	}
+
+	// Nor do they wait long on a Redis that doesn't answer.
+	store.mu.Lock()
+	store.down, store.hung = false, true
+	store.mu.Unlock()
+	b.cache.Purge("go.example.com")
+	start := time.Now()
+	if rules, err := b.cache.LookupConfig(ctx, "go.example.com"); err != nil || len(rules) != 1 {
+		t.Errorf("with Redis hung: %v, %v", rules, err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*sharedCacheTimeout {
+		t.Errorf("with Redis hung: the lookup took %v", elapsed)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+	"github.com/frolic/redirect.name/serverless"
+)
+
+// sources holds the configured resolver chain along with the individual
+// sources that need looking after (reloading, purging, metrics).
+type sources struct {
+	layers    *redirect.Layers
+	file      *redirect.FileResolver
+	cache     *redirect.Cache
+	shared    *sharedCache
+	lookups   *redirect.LookupLimiter
+	wellKnown *redirect.WellKnownResolver
+}
+
+// configSources are the sources resolver reads, for the admin API to
+// purge. It is nil until main sets them up.
+var configSources *sources
+
+// newSources builds the resolver precedence chain named by the sources
+// setting, a comma-separated list of "env", "file", "dns" and "wellknown".
+// By default the file (if redirects_file is set) takes precedence over DNS,
+// and static_redirects overrides come first unless sources places "env"
+// explicitly.
+func newSources(cfg *config) (*sources, error) {
+	s := new(sources)
+	var layers []redirect.Source
+	for _, name := range cfg.sourceNames() {
+		var r redirect.Resolver
+		switch name {
+		case "dns":
+			r = redirect.DNSResolver{}
+			if cfg.DoHURL != "" {
+				r = &redirect.DoHResolver{URL: cfg.DoHURL}
+			}
+			if cfg.MaxDNSLookups > 0 {
+				s.lookups = redirect.NewLookupLimiter(r, cfg.MaxDNSLookups)
+				r = s.lookups
+			}
+			if cfg.SharedCache != "" {
+				shared, err := newSharedCache(cfg, r)
+				if err != nil {
+					return nil, err
+				}
+				s.shared, r = shared, shared
+			}
+			if cfg.CacheTTL > 0 {
+				if cfg.Serverless != "" {
+					// Lambda caches the way the Cloud Functions entry
+					// point does, its instances being just as short-lived.
+					s.cache = serverless.NewCache(r)
+				} else {
+					s.cache = redirect.NewCache(r, cfg.CacheTTL)
+					s.cache.NegativeTTL = cfg.NegativeCacheTTL
+				}
+				r = s.cache
+			}
+		case "file":
+			file, err := redirect.NewFileResolver(cfg.RedirectsFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading redirects_file: %w", err)
+			}
+			s.file = file
+			r = file
+		case "env":
+			static, err := parseStaticRedirects(cfg.StaticRedirects)
+			if err != nil {
+				return nil, fmt.Errorf("invalid static_redirects: %w", err)
+			}
+			r = static
+		case "wellknown":
+			s.wellKnown = &redirect.WellKnownResolver{Client: newPublicClient(5 * time.Second)}
+			r = s.wellKnown
+		default:
+			return nil, fmt.Errorf("unknown source %q in sources", name)
+		}
+		layers = append(layers, redirect.Source{Name: name, Resolver: r})
+	}
+	s.layers = redirect.NewLayers(layers...)
+	return s, nil
+}
+
+// purge drops what s has cached of host's rules, so the next request
+// looks them up afresh, on every replica if the cache is shared.
+func (s *sources) purge(host string) {
+	if s.shared != nil {
+		s.shared.purge(host)
+	}
+	s.purgeLocal(host)
+}
+
+// purgeLocal drops what this replica has cached of host's rules.
+func (s *sources) purgeLocal(host string) {
+	if s.cache != nil {
+		s.cache.Purge(host)
+	}
+	if s.wellKnown != nil {
+		s.wellKnown.Purge(host)
+	}
+}
+
+// handlePurge serves DELETE /cache/{host}, dropping the host's cached
+// rules so a change to its records is live at once rather than after
+// cache_ttl.
+func (s *sources) handlePurge(w http.ResponseWriter, r *http.Request) {
+	host, err := redirect.ParseHost(r.PathValue("host"))
+	if err != nil {
+		http.Error(w, "host must be a hostname", http.StatusBadRequest)
+		return
+	}
+	s.purge(host)
+	log.Printf("Purged cached rules of %s", host)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseStaticRedirects parses a comma-separated list of host=target
+// overrides, e.g. "old.example.com=https://new.example.com/*". A target
+// containing * redirects every path, with * replaced by the request path.
+func parseStaticRedirects(v string) (redirect.StaticResolver, error) {
+	static := make(redirect.StaticResolver)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, target, ok := strings.Cut(entry, "=")
+		host, err := redirect.ASCIIHost(strings.TrimSpace(host))
+		target = strings.TrimSpace(target)
+		if !ok || err != nil || host == "" || target == "" {
+			return nil, fmt.Errorf("%q is not of the form host=target", entry)
+		}
+		record := "Redirects to " + target
+		if strings.Contains(target, "*") {
+			record = "Redirects from /* to " + target
+		}
+		if err := redirect.ValidateTarget(target); err != nil {
+			return nil, err
+		}
+		if rule := redirect.Parse(record); rule == nil || rule.To != target {
+			return nil, fmt.Errorf("%q is not a valid redirect target", target)
+		}
+		static[host] = append(static[host], record)
+	}
+	return static, nil
+}
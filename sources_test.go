@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+	"github.com/frolic/redirect.name/serverless"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func mustSources(t *testing.T, vars map[string]string) *sources {
+	t.Helper()
+	cfg, err := loadConfig(nil, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSources(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func sourceNames(s *sources) []string {
+	var names []string
+	for _, source := range s.layers.Sources() {
+		names = append(names, source.Name)
+	}
+	return names
+}
+
+func TestNewSourcesDefaults(t *testing.T) {
+	s := mustSources(t, nil)
+	if names := sourceNames(s); len(names) != 1 || names[0] != "dns" {
+		t.Errorf("default sources: want [dns], got %v", names)
+	}
+	if s.cache == nil || s.cache.TTL != time.Minute {
+		t.Error("expected DNS lookups to be cached for 1m by default")
+	}
+	if s = mustSources(t, map[string]string{"SERVERLESS": "lambda"}); s.cache == nil || s.cache.MaxEntries != serverless.CacheEntries || s.cache.TTL != 5*time.Minute || s.cache.NegativeTTL != 30*time.Second {
+		t.Error("expected a serverless instance's cache to be serverless.NewCache's")
+	}
+	if s = mustSources(t, map[string]string{"MAX_DNS_LOOKUPS": "8"}); s.lookups == nil || s.cache == nil || s.cache.Resolver != s.lookups {
+		t.Error("expected DNS lookups past the cache to be limited")
+	}
+
+	path := filepath.Join(t.TempDir(), "redirects.yaml")
+	os.WriteFile(path, []byte("go.example.com: [Redirects to https://example.com/]\n"), 0o644)
+	s = mustSources(t, map[string]string{"REDIRECTS_FILE": path, "CACHE_TTL": "0"})
+	if names := sourceNames(s); len(names) != 2 || names[0] != "file" || names[1] != "dns" {
+		t.Errorf("with REDIRECTS_FILE: want [file dns], got %v", names)
+	}
+	if s.file == nil || s.cache != nil {
+		t.Error("expected a file source and no cache")
+	}
+}
+
+func TestNewSourcesPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redirects.yaml")
+	os.WriteFile(path, []byte("{}\n"), 0o644)
+
+	s := mustSources(t, map[string]string{"SOURCES": "dns, wellknown,file", "REDIRECTS_FILE": path})
+	names := sourceNames(s)
+	if len(names) != 3 || names[0] != "dns" || names[1] != "wellknown" || names[2] != "file" {
+		t.Errorf("want [dns wellknown file], got %v", names)
+	}
+
+	bad := []map[string]string{
+		{"SOURCES": "dns,carrier-pigeon"},
+		{"SOURCES": "dns,dns"},
+		{"SOURCES": "file"},
+		{"CACHE_TTL": "forever"},
+	}
+	for _, vars := range bad {
+		if _, err := loadConfig(nil, env(vars)); err == nil {
+			t.Errorf("expected error for %v", vars)
+		}
+	}
+}
+
+func TestStaticRedirects(t *testing.T) {
+	s := mustSources(t, map[string]string{
+		"STATIC_REDIRECTS": "old.example.com=https://new.example.com/*, Promo.Example.com=https://example.com/sale",
+	})
+	if names := sourceNames(s); len(names) != 2 || names[0] != "env" {
+		t.Errorf("want env overrides first, got %v", names)
+	}
+
+	ctx := context.Background()
+	rules, err := s.layers.LookupConfig(ctx, "old.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := redirect.Match(rules, "/some/path?q=1")
+	if err != nil || target.Location != "https://new.example.com/some/path?q=1" {
+		t.Errorf("wildcard override: got %#v, %v", target, err)
+	}
+
+	rules, err = s.layers.LookupConfig(ctx, "promo.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err = redirect.Match(rules, "/anything")
+	if err != nil || target.Location != "https://example.com/sale" {
+		t.Errorf("fixed override: got %#v, %v", target, err)
+	}
+
+	for _, v := range []string{"no-equals-sign", "host=", "host=javascript:alert(1)"} {
+		if _, err := parseStaticRedirects(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	records := redirect.StaticResolver{"go.example.com": {"Redirects to https://old.example.com/"}}
+	cache := redirect.NewCache(records, time.Hour)
+	orig := configSources
+	t.Cleanup(func() { configSources = orig })
+	configSources = &sources{layers: redirect.NewLayers(redirect.Source{Name: "dns", Resolver: cache}), cache: cache}
+	lookup := func() string {
+		rules, _ := configSources.layers.LookupConfig(context.Background(), "go.example.com")
+		return rules[0].To
+	}
+	lookup()
+	records["go.example.com"] = []string{"Redirects to https://new.example.com/"}
+	if to := lookup(); to != "https://old.example.com/" {
+		t.Fatalf("not cached: %s", to)
+	}
+
+	cfg := defaultConfig()
+	cfg.AdminToken = "secret"
+	admin := newAdminMux(cfg, nil)
+	for path, want := range map[string]int{"/cache/GO.example.com": http.StatusNoContent, "/cache/not%20a%20host": http.StatusBadRequest} {
+		req := httptest.NewRequest("DELETE", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("DELETE %s: %d, want %d", path, rr.Code, want)
+		}
+	}
+	if to := lookup(); to != "https://new.example.com/" {
+		t.Errorf("after purging: %s", to)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// staticCertsPoll is how often static_certs_dir is checked for changes.
+const staticCertsPoll = 10 * time.Second
+
+// staticCerts serves certificates from static_certs_dir, such as EV or
+// corporate-CA ones, for the names they cover, in preference to autocert's
+// and DNS-01's. Each <name>.crt there holds a chain, leaf first, and
+// <name>.key its key; which hosts it serves comes from the certificate's
+// own names, wildcards included. The directory is reloaded on SIGHUP and
+// when its files change; if it can't be, the certificates loaded before
+// stay.
+type staticCerts struct {
+	dir string
+
+	mu     sync.RWMutex
+	byName map[string][]*tls.Certificate // the latest to expire first
+	stamp  string                        // of the files last loaded
+}
+
+// newStaticCerts returns cfg's static certificates, loaded once, or nil if
+// it has none.
+func newStaticCerts(cfg *config) (*staticCerts, error) {
+	if cfg.StaticCertsDir == "" {
+		return nil, nil
+	}
+	s := &staticCerts{dir: cfg.StaticCertsDir}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the directory's certificates. Expired ones are skipped.
+func (s *staticCerts) load() error {
+	stamp, err := s.files()
+	if err != nil {
+		return err
+	}
+	crts, err := filepath.Glob(filepath.Join(s.dir, "*.crt"))
+	if err != nil {
+		return err
+	}
+	byName := make(map[string][]*tls.Certificate)
+	loaded := 0
+	for _, crt := range crts {
+		key := strings.TrimSuffix(crt, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(crt, key)
+		if err != nil {
+			return fmt.Errorf("static_certs_dir: %s: %w", filepath.Base(crt), err)
+		}
+		leaf := cert.Leaf
+		if time.Now().After(leaf.NotAfter) {
+			log.Printf("Static certificate %s expired %s; skipping it", crt, leaf.NotAfter.Format(time.RFC3339))
+			continue
+		}
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			byName[name] = append(byName[name], &cert)
+		}
+		loaded++
+	}
+	for _, certs := range byName {
+		slices.SortStableFunc(certs, func(a, b *tls.Certificate) int { return b.Leaf.NotAfter.Compare(a.Leaf.NotAfter) })
+	}
+	s.mu.Lock()
+	s.byName, s.stamp = byName, stamp
+	s.mu.Unlock()
+	log.Printf("Loaded %d static certificates from %s", loaded, s.dir)
+	return nil
+}
+
+// files describes the directory's files, to notice when they change.
+func (s *staticCerts) files() (string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return "", fmt.Errorf("static_certs_dir: %w", err)
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// watch reloads the directory on SIGHUP and when its files change.
+func (s *staticCerts) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(staticCertsPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			stamp, err := s.files()
+			s.mu.RLock()
+			same := stamp == s.stamp
+			s.mu.RUnlock()
+			if err == nil && same {
+				continue
+			}
+		}
+		if err := s.load(); err != nil {
+			log.Printf("Reloading static certificates: %v", err)
+		}
+	}
+}
+
+// certificate returns the certificate for hello's server name, preferring
+// one the client supports, or nil if none covers it.
+func (s *staticCerts) certificate(hello *tls.ClientHelloInfo) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	certs := s.byName[name]
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		certs = append(slices.Clip(certs), s.byName["*."+parent]...)
+	}
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert
+		}
+	}
+	if len(certs) > 0 {
+		return certs[0]
+	}
+	return nil
+}
+
+// tlsConfig returns config with s's certificates for the names they
+// cover, and the rest as before.
+func (s *staticCerts) tlsConfig(config *tls.Config) *tls.Config {
+	next := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if !isTokenHello(hello) {
+			if cert := s.certificate(hello); cert != nil {
+				return cert, nil
+			}
+		}
+		return next(hello)
+	}
+	return config
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestStaticCerts(t *testing.T) {
+	dir := t.TempDir()
+	put := func(file string, pem []byte) {
+		// The cache's encoding holds both key and chain, so it serves as either.
+		os.WriteFile(filepath.Join(dir, file+".crt"), pem, 0o644)
+		os.WriteFile(filepath.Join(dir, file+".key"), pem, 0o600)
+	}
+	put("wildcard", cachedCert(t, "*.example.com", time.Now().Add(60*24*time.Hour)))
+	put("go", cachedCert(t, "go.example.com", time.Now().Add(30*24*time.Hour)))
+	put("expired", cachedCert(t, "old.example.com", time.Now().Add(-time.Hour)))
+	cfg := defaultConfig()
+	cfg.StaticCertsDir = dir
+	s, err := newStaticCerts(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passed []string
+	config := s.tlsConfig(&tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		passed = append(passed, hello.ServerName)
+		return &tls.Certificate{}, nil
+	}})
+	served := func(host string) string {
+		cert, err := config.GetCertificate(renewalHello(host))
+		if err != nil || cert.Leaf == nil {
+			return ""
+		}
+		return cert.Leaf.DNSNames[0]
+	}
+	for host, want := range map[string]string{
+		"go.example.com":   "go.example.com",
+		"GO.example.com.":  "go.example.com",
+		"docs.example.com": "*.example.com",
+		"a.b.example.com":  "",
+		"example.com":      "",
+		"old.example.com":  "*.example.com",
+	} {
+		if got := served(host); got != want {
+			t.Errorf("%s: served %q, want %q", host, got, want)
+		}
+	}
+	if len(passed) != 2 {
+		t.Errorf("passed on %q", passed)
+	}
+
+	// Challenges are still autocert's to answer.
+	passed = nil
+	hello := renewalHello("go.example.com")
+	hello.SupportedProtos = []string{acme.ALPNProto}
+	config.GetCertificate(hello)
+	if len(passed) != 1 {
+		t.Error("challenge hello answered with a static certificate")
+	}
+
+	// A pair that can't be read keeps the certificates loaded before.
+	os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("nonsense"), 0o644)
+	if err := s.load(); err == nil {
+		t.Error("broken pair: no error")
+	}
+	if served("go.example.com") != "go.example.com" {
+		t.Error("certificates dropped after a failed reload")
+	}
+	os.Remove(filepath.Join(dir, "broken.crt"))
+	os.Remove(filepath.Join(dir, "go.crt"))
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := served("go.example.com"); got != "*.example.com" {
+		t.Errorf("after removing go.crt: served %q", got)
+	}
+
+	cfg.StaticCertsDir = filepath.Join(dir, "missing")
+	if _, err := newStaticCerts(cfg); err == nil {
+		t.Error("missing directory: no error")
+	}
+}
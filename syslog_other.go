@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslog fails: log/syslog is not available on this platform.
+func newSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ticketKeysKept is how many session ticket keys are accepted with
+// tls_session_ticket_rotation: the one new tickets are encrypted with and
+// those before it, so a ticket outlives a rotation or two.
+const ticketKeysKept = 3
+
+// tlsCurves are the key exchanges tls_curves can name.
+var tlsCurves = map[string]tls.CurveID{
+	"x25519":         tls.X25519,
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"p-256":          tls.CurveP256,
+	"p-384":          tls.CurveP384,
+	"p-521":          tls.CurveP521,
+}
+
+// tlsPolicy is the TLS settings of cfg, applied to the HTTPS and HTTP/3
+// listeners' configs on top of autocert's.
+type tlsPolicy struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+	rotation     time.Duration
+
+	mu      sync.Mutex
+	configs []*tls.Config
+	keys    [][32]byte
+}
+
+// newTLSPolicy parses cfg's TLS settings.
+func newTLSPolicy(cfg *config) (*tlsPolicy, error) {
+	p := &tlsPolicy{rotation: cfg.TLSTicketRotation}
+	switch cfg.TLSMinVersion {
+	case "1.2":
+		p.minVersion = tls.VersionTLS12
+	case "1.3":
+		p.minVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("tls_min_version must be 1.2 or 1.3, not %q", cfg.TLSMinVersion)
+	}
+	for _, name := range strings.Split(cfg.TLSCipherSuites, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		i := slices.IndexFunc(tls.CipherSuites(), func(s *tls.CipherSuite) bool { return strings.EqualFold(s.Name, name) })
+		if i < 0 {
+			return nil, fmt.Errorf("tls_cipher_suites: %q is not a secure cipher suite", name)
+		}
+		p.cipherSuites = append(p.cipherSuites, tls.CipherSuites()[i].ID)
+	}
+	for _, name := range strings.Split(cfg.TLSCurves, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, ok := tlsCurves[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("tls_curves: unknown curve %q", name)
+		}
+		p.curves = append(p.curves, id)
+	}
+	if p.rotation < 0 {
+		return nil, fmt.Errorf("tls_session_ticket_rotation must not be negative")
+	}
+	if p.rotation > 0 {
+		p.rotate()
+	}
+	return p, nil
+}
+
+// apply sets p's settings on config, which from then on gets p's session
+// ticket keys.
+func (p *tlsPolicy) apply(config *tls.Config) *tls.Config {
+	config.MinVersion = p.minVersion
+	if p.cipherSuites != nil {
+		config.CipherSuites = p.cipherSuites
+	}
+	if p.curves != nil {
+		config.CurvePreferences = p.curves
+	}
+	if p.rotation > 0 {
+		p.mu.Lock()
+		p.configs = append(p.configs, config)
+		config.SetSessionTicketKeys(p.keys)
+		p.mu.Unlock()
+	}
+	return config
+}
+
+// rotate starts encrypting session tickets with a new key, still
+// accepting those made with the last ticketKeysKept-1.
+func (p *tlsPolicy) rotate() {
+	var key [32]byte
+	rand.Read(key[:])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append([][32]byte{key}, p.keys[:min(len(p.keys), ticketKeysKept-1)]...)
+	for _, config := range p.configs {
+		config.SetSessionTicketKeys(p.keys)
+	}
+}
+
+// run rotates session ticket keys every tls_session_ticket_rotation until
+// ctx is done, or leaves that to crypto/tls if it's 0.
+func (p *tlsPolicy) run(ctx context.Context) {
+	if p.rotation == 0 {
+		return
+	}
+	ticker := time.NewTicker(p.rotation)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.rotate()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TLSMinVersion = "1.3"
+	cfg.TLSCipherSuites = "tls_ecdhe_ecdsa_with_aes_256_gcm_sha384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
+	cfg.TLSCurves = "X25519, p-256"
+	p, err := newTLSPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := p.apply(&tls.Config{NextProtos: []string{"h2"}})
+	if config.MinVersion != tls.VersionTLS13 || !slices.Equal(config.NextProtos, []string{"h2"}) {
+		t.Errorf("config = %+v", config)
+	}
+	if want := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}; !slices.Equal(config.CipherSuites, want) {
+		t.Errorf("cipher suites = %x", config.CipherSuites)
+	}
+	if want := []tls.CurveID{tls.X25519, tls.CurveP256}; !slices.Equal(config.CurvePreferences, want) {
+		t.Errorf("curves = %v", config.CurvePreferences)
+	}
+}
+
+func TestTLSTicketRotation(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TLSTicketRotation = time.Hour
+	p, err := newTLSPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := cachedCert(t, "go.example.com", time.Now().Add(time.Hour))
+	leaf, err := parseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := p.apply(&tls.Config{Certificates: []tls.Certificate{*leaf}, MaxVersion: tls.VersionTLS12})
+	client := &tls.Config{InsecureSkipVerify: true, ServerName: "go.example.com", ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+	resumed := func() bool {
+		c, s := net.Pipe()
+		defer c.Close()
+		go func() {
+			conn := tls.Server(s, server)
+			conn.Handshake()
+			conn.Close()
+		}()
+		conn := tls.Client(c, client)
+		if err := conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		return conn.ConnectionState().DidResume
+	}
+
+	resumed()
+	if !resumed() {
+		t.Fatal("session not resumed")
+	}
+	// Tickets outlive ticketKeysKept-1 rotations, and no more.
+	for range ticketKeysKept - 1 {
+		p.rotate()
+	}
+	if !resumed() {
+		t.Errorf("session not resumed after %d rotations", ticketKeysKept-1)
+	}
+	for range ticketKeysKept {
+		p.rotate()
+	}
+	if resumed() {
+		t.Errorf("session resumed after %d rotations", ticketKeysKept)
+	}
+}
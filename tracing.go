@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/frolic/redirect.name/internal/trace"
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// tracer records request spans. It is nil when otlp_endpoint is unset.
+var tracer *trace.Tracer
+
+// newTracer returns a Tracer exporting to otlp_endpoint, or nil if it is
+// unset. An endpoint without a path gets the standard /v1/traces.
+func newTracer(cfg *config) *trace.Tracer {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	endpoint := cfg.OTLPEndpoint
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = "/v1/traces"
+		endpoint = u.String()
+	}
+	return trace.New(trace.Config{
+		Endpoint:    endpoint,
+		Headers:     cfg.otlpHeaders(),
+		Service:     logIdentifier,
+		Version:     readBuildInfo().Version,
+		SampleRatio: cfg.TraceSampleRatio,
+	})
+}
+
+// spanTracer adapts a trace.Tracer to redirect.Tracer.
+type spanTracer struct {
+	t *trace.Tracer
+}
+
+func (s spanTracer) Start(ctx context.Context, name string) (context.Context, redirect.Span) {
+	return s.t.Start(ctx, name)
+}
+
+// traceRequests starts a server span for each request other than health
+// checks, continuing any trace in its traceparent header. The client's
+// address is recorded as ip allows.
+func traceRequests(t *trace.Tracer, ip *ipAnonymizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := t.StartServer(r.Context(), r.Method, r.Header.Get("Traceparent"))
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("server.address", r.Host)
+		span.SetAttribute("url.path", r.URL.Path)
+		if client := ip.anonymize(redirect.ClientIP(r)); client != "" {
+			span.SetAttribute("client.address", client)
+		}
+		if ua := r.UserAgent(); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+		if id := redirect.RequestIDFrom(r.Context()); id != "" {
+			span.SetAttribute("http.request.id", id)
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", rec.status)
+		if loc := rec.Header().Get("Location"); loc != "" {
+			span.SetAttribute("http.response.header.location", loc)
+		}
+		var err error
+		if rec.status >= 500 {
+			err = fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status))
+		}
+		span.End(err)
+	})
+}
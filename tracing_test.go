@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+func TestTraceRequests(t *testing.T) {
+	var mu sync.Mutex
+	var exported []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("exported to %s", r.URL.Path)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		exported, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	orig, origTracer, origLog := resolver, tracer, accessLog
+	t.Cleanup(func() { resolver, tracer, accessLog = orig, origTracer, origLog })
+	resolver = redirect.StaticResolver{"go.example.com": {"Redirects to https://example.com/"}}
+	var logs bytes.Buffer
+	accessLog = slog.New(slog.NewJSONHandler(&logs, nil))
+	cfg := defaultConfig()
+	cfg.OTLPEndpoint = collector.URL
+	cfg.ClientIPLogging = "truncate"
+	tracer = newTracer(cfg)
+	h := newMux(cfg, nil)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "go.example.com"
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	body := string(exported)
+	mu.Unlock()
+	for _, want := range []string{
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"parentSpanId":"00f067aa0ba902b7"`,
+		`"name":"GET"`,
+		`"name":"redirect.lookup"`,
+		`"name":"redirect.translate"`,
+		`"key":"client.address","value":{"stringValue":"192.0.2.0"}`,
+		`"key":"http.response.status_code","value":{"intValue":"302"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("export lacks %s: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "/healthz") {
+		t.Errorf("health check traced: %s", body)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("access log trace_id: got %v", entry["trace_id"])
+	}
+}
+
+func TestNewTracer(t *testing.T) {
+	cfg := defaultConfig()
+	if newTracer(cfg) != nil {
+		t.Error("want no tracer without otlp_endpoint")
+	}
+}
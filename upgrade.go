@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listenersEnv names the listening sockets a parent process passed to
+	// us, in order, starting at file descriptor 3.
+	listenersEnv = "REDIRECT_NAME_LISTENERS"
+	// readyEnv holds the file descriptor on which to tell the parent
+	// process we are serving.
+	readyEnv = "REDIRECT_NAME_READY_FD"
+)
+
+// upgrader implements zero-downtime restarts in the style of tableflip: on
+// Upgrade, a fresh copy of the binary is started with our listening sockets
+// and we only shut down once it reports ready, so no connection is refused
+// in between.
+type upgrader struct {
+	reusePort bool
+	inherited map[string]*os.File
+	ready     *os.File
+
+	names   []string
+	sockets []socket
+
+	// command builds the process to start on Upgrade. It defaults to
+	// re-running the current executable with the same arguments.
+	command func() (*exec.Cmd, error)
+}
+
+// socket is a listener or packet connection that can be handed over.
+type socket interface {
+	File() (*os.File, error)
+}
+
+// newUpgrader picks up any sockets and ready pipe passed by a parent
+// process, or sockets passed by systemd socket activation. With reusePort,
+// fresh sockets set SO_REUSEPORT so that an independently started process
+// can bind the same addresses.
+func newUpgrader(reusePort bool) (*upgrader, error) {
+	u := &upgrader{
+		reusePort: reusePort,
+		inherited: make(map[string]*os.File),
+		command:   selfCommand,
+	}
+	var names []string
+	if v := os.Getenv(listenersEnv); v != "" {
+		names = strings.Split(v, ",")
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		// systemd socket activation. Sockets are matched by the
+		// FileDescriptorName of their socket unit, e.g. "http" or "https".
+		n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		fdNames := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := range n {
+			name := "unknown"
+			if i < len(fdNames) && fdNames[i] != "" {
+				name = fdNames[i]
+			}
+			names = append(names, name)
+		}
+	}
+	for i, name := range names {
+		u.inherited[name] = os.NewFile(uintptr(3+i), name)
+	}
+	if v := os.Getenv(readyEnv); v != "" {
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", readyEnv, v)
+		}
+		u.ready = os.NewFile(uintptr(fd), "ready")
+	}
+	// Don't let our own children mistake these for theirs.
+	for _, name := range []string{listenersEnv, readyEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	return u, nil
+}
+
+func selfCommand() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// Listen returns the listener called name, inherited from the parent
+// process or systemd if there is one, or freshly bound to addr.
+func (u *upgrader) Listen(name, addr string) (net.Listener, error) {
+	var ln net.Listener
+	var err error
+	if f := u.take(name); f != nil {
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = u.listenConfig().Listen(context.Background(), "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.add(name, ln.(socket))
+	return ln, nil
+}
+
+// ListenPacket is like Listen for UDP sockets.
+func (u *upgrader) ListenPacket(name, addr string) (net.PacketConn, error) {
+	var conn net.PacketConn
+	var err error
+	if f := u.take(name); f != nil {
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = u.listenConfig().ListenPacket(context.Background(), "udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.add(name, conn.(socket))
+	return conn, nil
+}
+
+func (u *upgrader) take(name string) *os.File {
+	f := u.inherited[name]
+	delete(u.inherited, name)
+	return f
+}
+
+func (u *upgrader) add(name string, s socket) {
+	u.names = append(u.names, name)
+	u.sockets = append(u.sockets, s)
+}
+
+func (u *upgrader) listenConfig() *net.ListenConfig {
+	var lc net.ListenConfig
+	if u.reusePort {
+		lc.Control = reusePortControl
+	}
+	return &lc
+}
+
+// Ready reports to the parent process and to systemd that we are serving,
+// and closes inherited sockets that this configuration no longer uses.
+func (u *upgrader) Ready() error {
+	for name, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, name)
+	}
+	if u.ready != nil {
+		u.ready.Write([]byte{1})
+		u.ready.Close()
+		u.ready = nil
+	}
+	return sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid()))
+}
+
+// Upgrade starts a new process with our sockets and waits up to timeout
+// for it to become ready. On success the caller should shut down gracefully;
+// on failure it should carry on serving.
+func (u *upgrader) Upgrade(timeout time.Duration) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, s := range u.sockets {
+		f, err := s.File()
+		if err != nil {
+			return fmt.Errorf("%s listener: %w", u.names[i], err)
+		}
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd, err := u.command()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd.ExtraFiles = append(files, w)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		listenersEnv+"="+strings.Join(u.names, ","),
+		fmt.Sprintf("%s=%d", readyEnv, 3+len(files)),
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("starting new process: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := r.Read(buf); err != nil {
+			ready <- errors.New("new process exited before becoming ready")
+			return
+		}
+		ready <- nil
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process not ready after %v", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	// The new process outlives us; reap it if we're still around when it
+	// exits.
+	go cmd.Wait()
+	return nil
+}
+
+// sdNotify sends state to systemd's notification socket, if there is one.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUpgradeHelper is the new process started by TestUpgrade and
+// TestSocketActivation. It serves "child" on the listener it inherits.
+func TestUpgradeHelper(t *testing.T) {
+	mode := os.Getenv("UPGRADE_HELPER")
+	if mode == "" {
+		t.Skip("helper process")
+	}
+	if mode == "fail" {
+		os.Exit(1)
+	}
+	if mode == "activated" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+	up, err := newUpgrader(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ln, err := up.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "child")
+	}))
+	up.Ready()
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func helperUpgrader(t *testing.T, mode string) *upgrader {
+	t.Helper()
+	up, err := newUpgrader(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started *exec.Cmd
+	up.command = func() (*exec.Cmd, error) {
+		started = exec.Command(os.Args[0], "-test.run=^TestUpgradeHelper$")
+		started.Env = append(os.Environ(), "UPGRADE_HELPER="+mode)
+		started.Stderr = os.Stderr
+		return started, nil
+	}
+	t.Cleanup(func() {
+		if started != nil && started.Process != nil {
+			started.Process.Kill()
+		}
+	})
+	return up
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestUpgrade(t *testing.T) {
+	up := helperUpgrader(t, "serve")
+	ln, err := up.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "parent")
+	})}
+	go srv.Serve(ln)
+	url := "http://" + ln.Addr().String()
+	if body := get(t, url); body != "parent" {
+		t.Fatalf("before upgrade: got %q", body)
+	}
+
+	if err := up.Upgrade(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	if body := get(t, url); body != "child" {
+		t.Errorf("after upgrade: want the new process to answer, got %q", body)
+	}
+}
+
+func TestUpgradeFailure(t *testing.T) {
+	up := helperUpgrader(t, "fail")
+	ln, err := up.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	err = up.Upgrade(10 * time.Second)
+	if err == nil || !strings.Contains(err.Error(), "before becoming ready") {
+		t.Errorf("want error for a process that exits early, got %v", err)
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUpgradeHelper$")
+	cmd.Env = append(os.Environ(), "UPGRADE_HELPER=activated", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	f.Close()
+	ln.Close()
+
+	// The socket stays open in the child, so this connects even before it
+	// starts accepting.
+	if body := get(t, "http://"+ln.Addr().String()); body != "child" {
+		t.Errorf("want the activated process to answer, got %q", body)
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	up, err := newUpgrader(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := up.Listen("a", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("SO_REUSEPORT unavailable: %v", err)
+	}
+	defer first.Close()
+	second, err := up.Listen("b", first.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+
+	if _, err := net.Listen("tcp", first.Addr().String()); err == nil {
+		t.Error("expected a plain listener to conflict")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frolic/redirect.name/redirect"
+)
+
+// validateDeploy checks, for -validate, what loading cfg can't: that the
+// listeners can bind their addresses, cert_dir is writable, the files of
+// admin_auth_file, admin_cert, grpc_cert, static_certs_dir and dev_tls_ca
+// load, the config sources load and DNS answers, and the blocklist can be
+// read. It writes each problem to out and returns how many there were,
+// so that a deploy can be stopped before the server fails to start or
+// starts without a dependency. Nothing is created: cert_dir, if missing,
+// is left for the server to make.
+func validateDeploy(ctx context.Context, cfg *config, out io.Writer) int {
+	var problems []string
+	check := func(name string, err error) {
+		if err != nil {
+			// Some loaders name their setting already.
+			msg, _ := strings.CutPrefix(err.Error(), name+": ")
+			problems = append(problems, name+": "+msg)
+		}
+	}
+
+	// Listeners handed over by systemd are already bound.
+	if os.Getenv("LISTEN_FDS") == "" {
+		seen := make(map[string]string)
+		for _, l := range cfg.listenAddrs() {
+			// Port 0 is a different port each time.
+			if _, port, _ := net.SplitHostPort(l.addr); port != "0" {
+				key := l.network + " " + l.addr
+				if other, ok := seen[key]; ok {
+					check(l.setting, fmt.Errorf("%s is %s's address too", l.addr, other))
+					continue
+				}
+				seen[key] = l.setting
+			}
+			check(l.setting, checkListen(ctx, l.network, l.addr))
+		}
+	}
+	if cfg.CertDir != "" && cfg.CertCache == "" {
+		check("cert_dir", checkCertDir(cfg.CertDir))
+	}
+	if cfg.AdminAuthFile != "" {
+		_, err := loadAdminCredentials(cfg.AdminAuthFile)
+		check("admin_auth_file", err)
+	}
+	if cfg.AdminCert != "" {
+		_, err := loadServerTLS(cfg.AdminCert, cfg.AdminKey, cfg.AdminClientCA, tls.VerifyClientCertIfGiven)
+		check("admin_cert", err)
+	}
+	if cfg.GRPCAddr != "" {
+		_, err := loadServerTLS(cfg.GRPCCert, cfg.GRPCKey, cfg.GRPCClientCA, tls.RequireAndVerifyClientCert)
+		check("grpc_cert", err)
+	}
+	if cfg.StaticCertsDir != "" {
+		_, err := newStaticCerts(cfg)
+		check("static_certs_dir", err)
+	}
+	if cfg.DevTLSCA != "" {
+		_, _, err := loadDevCA(cfg.DevTLSCA)
+		check("dev_tls_ca", err)
+	}
+
+	if srcs, err := newSources(cfg); err != nil {
+		check("sources", err)
+	} else {
+		for _, source := range srcs.layers.Sources() {
+			if source.Name != "dns" {
+				continue
+			}
+			probe := source.Resolver
+			if cache, ok := probe.(*redirect.Cache); ok {
+				probe = cache.Resolver
+			}
+			lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			status := sourceStatus{Name: source.Name}
+			if !probeSource(lookupCtx, probe, &status) {
+				check("dns", fmt.Errorf("looking up %s: %s", readyProbeHost, status.Error))
+			}
+			cancel()
+		}
+	}
+
+	// The server only logs a blocklist_url it can't fetch, to start
+	// regardless; before a deploy, it's worth knowing.
+	blocklist := &blocklistSync{file: cfg.BlocklistFile, url: cfg.BlocklistURL, client: &http.Client{Timeout: time.Minute}}
+	check("blocklist_file", blocklist.loadFile())
+	check("blocklist_url", blocklist.fetch(ctx))
+
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(out, "Configuration OK")
+	}
+	return len(problems)
+}
+
+// checkCertDir checks that dir is a writable directory or, if it doesn't
+// exist yet, that the server could make it in the nearest directory that
+// does.
+func checkCertDir(dir string) error {
+	for path := dir; ; path = filepath.Dir(path) {
+		info, err := os.Stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && filepath.Dir(path) != path:
+			continue
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return fmt.Errorf("%s is not a directory", path)
+		}
+		return checkWritable(path)
+	}
+}
+
+// A listenAddr is an address a listener binds, and the setting it's from.
+type listenAddr struct {
+	setting, network, addr string
+}
+
+// listenAddrs returns the addresses the server would listen on with c.
+func (c *config) listenAddrs() []listenAddr {
+	var addrs []listenAddr
+	switch {
+	case c.Serverless != "":
+	case c.BehindProxy || c.CertDir == "" && !c.DevTLS:
+		addrs = append(addrs, listenAddr{"port", "tcp", ":" + strconv.Itoa(c.Port)})
+	default:
+		addrs = append(addrs, listenAddr{"http_addr", "tcp", c.HTTPAddr}, listenAddr{"https_addr", "tcp", c.HTTPSAddr})
+		if c.HTTP3Addr != "" {
+			addrs = append(addrs, listenAddr{"http3_addr", "udp", c.HTTP3Addr})
+		}
+	}
+	for _, l := range []listenAddr{{"admin_addr", "tcp", c.AdminAddr}, {"debug_addr", "tcp", c.DebugAddr}, {"grpc_addr", "tcp", c.GRPCAddr}} {
+		if l.addr != "" {
+			addrs = append(addrs, l)
+		}
+	}
+	return addrs
+}
+
+// checkListen binds addr and lets it go again.
+func checkListen(ctx context.Context, network, addr string) error {
+	var lc net.ListenConfig
+	if network == "udp" {
+		conn, err := lc.ListenPacket(ctx, network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	ln, err := lc.Listen(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
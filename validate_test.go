@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDeploy(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	notDir := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notDir, nil, 0o600)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	cfg := defaultConfig()
+	cfg.CertDir = notDir
+	cfg.HTTPAddr, cfg.HTTPSAddr = taken.Addr().String(), "127.0.0.1:0"
+	cfg.AdminAddr, cfg.DebugAddr = admin.Addr().String(), admin.Addr().String()
+	cfg.DoHURL = down.URL
+	cfg.BlocklistURL = down.URL + "/blocklist"
+	cfg.AdminAuthFile = missing
+	cfg.GRPCAddr = "127.0.0.1:0"
+	cfg.GRPCCert, cfg.GRPCKey, cfg.GRPCClientCA = missing, missing, missing
+	cfg.StaticCertsDir = missing
+	cfg.DevTLSCA = missing
+	var out bytes.Buffer
+	n := validateDeploy(context.Background(), cfg, &out)
+	for _, want := range []string{
+		"http_addr: listen tcp",
+		"admin_addr: listen tcp " + admin.Addr().String(),
+		"debug_addr: " + admin.Addr().String() + " is admin_addr's address too",
+		"cert_dir: " + notDir + " is not a directory",
+		"admin_auth_file: open " + missing,
+		"grpc_cert: ",
+		"static_certs_dir: open " + missing,
+		"dev_tls_ca: open " + missing,
+		"dns: looking up example.com",
+		"blocklist_url: " + down.URL + "/blocklist: 500",
+	} {
+		if !strings.Contains(out.String(), "\n"+want) && !strings.HasPrefix(out.String(), want) {
+			t.Errorf("want a line starting %q, got:\n%s", want, &out)
+		}
+	}
+	if n != 10 {
+		t.Errorf("want 10 problems, got %d:\n%s", n, &out)
+	}
+
+	free, _ := net.Listen("tcp", ":0")
+	free.Close()
+	cfg = defaultConfig()
+	cfg.Port = free.Addr().(*net.TCPAddr).Port
+	cfg.StaticRedirects = "go.example.com=https://example.com/"
+	cfg.Sources = "env"
+	cfg.CertDir = filepath.Join(t.TempDir(), "certs")
+	cfg.HTTPAddr, cfg.HTTPSAddr = "127.0.0.1:0", "127.0.0.1:0"
+	out.Reset()
+	if n := validateDeploy(context.Background(), cfg, &out); n != 0 || out.String() != "Configuration OK\n" {
+		t.Errorf("a usable config: %d problems:\n%s", n, &out)
+	}
+	if _, err := os.Stat(cfg.CertDir); err == nil {
+		t.Error("a missing cert_dir was made")
+	}
+}
+
+func TestValidateFlag(t *testing.T) {
+	cfg, err := loadConfig([]string{"-validate"}, env(nil))
+	if err != nil || !cfg.validateOnly {
+		t.Fatalf("-validate: %v", err)
+	}
+	if cfg, err := loadConfig(nil, env(map[string]string{"VALIDATE": "true"})); err != nil || cfg.validateOnly {
+		t.Errorf("VALIDATE in the environment isn't a setting: %v", err)
+	}
+}
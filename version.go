@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+// buildTime is set at build time with
+// -ldflags "-X main.buildTime=2006-01-02T15:04:05Z".
+var buildTime string
+
+// buildInfo identifies the running binary.
+type buildInfo struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// readBuildInfo returns the module version and VCS details the binary was
+// built with.
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: "(devel)", BuildTime: buildTime}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.CommitTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func (b buildInfo) String() string {
+	s := b.Version
+	if b.Revision != "" {
+		s += " (" + b.Revision
+		if b.Modified {
+			s += "+modified"
+		}
+		s += ")"
+	}
+	if b.BuildTime != "" {
+		s += " built " + b.BuildTime
+	}
+	return s + " with " + b.GoVersion
+}
+
+// versionHandler serves the build info as JSON.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readBuildInfo())
+}